@@ -1,10 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"ffmcli/internal/transcoder"
 
@@ -12,17 +19,114 @@ import (
 )
 
 var (
-	recursive  bool
-	outputDir  string
-	preset     string
-	inputFile  string
-	overwrite  bool
-	verbose    bool
-	dryRun     bool
-	gpuIndex   int
-	noGPU      bool
-	audioCodec string
-	csvOutput  string
+	recursive      bool
+	outputDir      string
+	preset         string
+	inputFile      string
+	inputPaths     []string
+	overwrite      bool
+	skipIfCodec    bool
+	deleteSource   bool
+	verbose        bool
+	logLevel       string
+	quiet          bool
+	logFile        string
+	dryRun         bool
+	estimate       bool
+	estimateSample time.Duration
+	gpuIndex       int
+	noGPU          bool
+	audioCodec     string
+	audioBitrate   string
+	normalizeAudio bool
+	normalize2Pass bool
+	audioTrack     string
+	csvOutput      string
+	jsonOutput     string
+	noToolMetadata bool
+	stripMetadata  bool
+	sidecar        bool
+	thumbnail      bool
+	thumbnailAt    string
+	contactSheet   int
+	noProbe        bool
+	noProgress     bool
+	tempDir        string
+	stageDir       string
+	tune           string
+	qualityTarget  string
+	policy         []string
+	softwareCodecs []string
+	outputMode     string
+	outputGroup    string
+	dvdMode        bool
+	maxrateFactor  float64
+	bufsizeFactor  float64
+	interactive    bool
+	assumeYes      bool
+	subFiles       []string
+	subLang        string
+	noAutoSubs     bool
+	subtitleMode   string
+	tonemapMode    string
+	tonemapAlgo    string
+	deinterlace    string
+	deintFilter    string
+	cropValue      string
+	autoOrient     bool
+	fixAspect      bool
+	noUpscale      bool
+	maxRuntime     time.Duration
+	batchSize      int
+	jobs           int
+	maxFilesPerDir int
+	fps            string
+	manifest       string
+	manifestAlgo   string
+	crf            int
+	encoderSpeed   string
+	suffix         string
+	nameTemplate   string
+	forceExtension string
+	container      string
+	groupByCodec   bool
+	ratioStyle     string
+	onlyNew        bool
+	energy         bool
+	energyWatts    float64
+	probeTimeout   time.Duration
+	probeCache     string
+	resume         bool
+	resumeFile     string
+	retryFailed    bool
+	noKeys         bool
+	codecFlag      string
+	resolution     string
+	followSymlinks bool
+	lookahead      int
+	bframes        int
+	aqMode         string
+	historyFiles   []string
+	maxBitrate     string
+	adaptive       bool
+	smartBitrate   bool
+	twoPass        bool
+	ffmpegArgs     string
+	measureVMAF    bool
+	measureSSIM    bool
+	retries        int
+	trimStart      string
+	trimDuration   string
+	trimEnd        string
+	minSize        string
+	minDuration    string
+	maxDuration    string
+	repair         bool
+	adaptiveMin    string
+	adaptiveMax    string
+	projectFile    string
+	presetsFile    string
+	toolVersion    = "dev"
 )
 
 var rootCmd = &cobra.Command{
@@ -37,204 +141,1882 @@ Includes recursive directory scanning and provides presets for common encoding s
   # Recursively transcode all videos in a directory
   ffmcli -i /path/to/videos/ -r -p 720p_av1 -o /path/to/output/
 
+  # Batch several folders and files in one run
+  ffmcli -i /path/to/shows/ -i /path/to/movies/ extra.mkv -r -o /path/to/output/
+
   # Dry run to see what would be processed
   ffmcli -i /path/to/videos/ -r -p 1080p_h264 --dry-run
 
+  # Run the conversion described by a project file
+  ffmcli --project ffmcli.yaml
+
   # Force software encoding (disable GPU)
   ffmcli -i input.mp4 -p 1080p_h264 -o output/ --no-gpu`,
+	Args: cobra.ArbitraryArgs,
+	// Subcommands load the presets file before running; the main command
+	// waits until a project file had the chance to name one
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.HasParent() {
+			return nil
+		}
+		return loadPresetsFile()
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyProject(cmd, args); err != nil {
+			return err
+		}
+		return loadPresetsFile()
+	},
 	RunE: runTranscode,
 }
 
+// loadPresetsFile makes the presets of --presets-file available, if given
+func loadPresetsFile() error {
+	if presetsFile == "" {
+		return nil
+	}
+	return transcoder.LoadPresetsFile(presetsFile)
+}
+
 func init() {
-	rootCmd.Flags().StringVarP(&inputFile, "input", "i", "", "Input file or directory (required)")
+	rootCmd.Flags().StringArrayVarP(&inputPaths, "input", "i", nil, "Input file, directory or quoted glob such as '*.mkv' (required; repeat or pass extra paths as arguments for several)")
 	rootCmd.Flags().StringVarP(&outputDir, "output", "o", "", "Output directory (required)")
-	rootCmd.Flags().StringVarP(&preset, "preset", "p", "1080p_h264", "Encoding preset (720p_av1, 1080p_av1, 720p_h264, 1080p_h264, 1080p_h265, 4k_av1, 4k_h265)")
+	rootCmd.PersistentFlags().StringVar(&presetsFile, "presets-file", "", "JSON or YAML file with a list of extra presets; presets named like built-in ones replace them")
+	rootCmd.Flags().StringVar(&projectFile, "project", "", "YAML project file describing the run, keyed by flag name (e.g. 'preset: 1080p_h265'); flags on the command line take precedence")
+	rootCmd.Flags().StringVarP(&preset, "preset", "p", "1080p_h264", "Encoding preset (720p_av1, 1080p_av1, 720p_h264, 1080p_h264, 1080p_h265, 4k_av1, 4k_h265, 720p_vertical, 1080p_vertical); chosen from a menu in a terminal when omitted; a comma-separated list such as 720p_h265,1080p_h265 encodes each file once per preset")
 	rootCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Recursively process directories")
+	rootCmd.Flags().BoolVar(&followSymlinks, "follow-symlinks", false, "Descend into symlinked directories when recursive (symlinked files are always included)")
 	rootCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Overwrite existing output files")
-	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
-	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be processed without actually transcoding")
+	rootCmd.Flags().BoolVar(&skipIfCodec, "skip-if-codec", false, "Skip sources already in the preset's codec at or below its resolution")
+	rootCmd.Flags().BoolVar(&deleteSource, "delete-source", false, "Delete each source after a successful encode, once the output probes cleanly and matches the source duration")
+	rootCmd.Flags().BoolVar(&onlyNew, "only-new", false, "Skip sources whose output file name already exists anywhere under the output directory, even in other folders")
+	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output (same as --log-level debug)")
+	rootCmd.Flags().StringVar(&logLevel, "log-level", "info", "Lowest level of messages shown: debug, info, warn or error")
+	rootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Only show warnings and errors (same as --log-level warn)")
+	rootCmd.Flags().StringVar(&logFile, "log-file", "", "Append each input's ffmpeg commands, full stderr, exit status and fallback to this file, at any log level")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be processed, with an approximate time and size estimate, without transcoding")
+	rootCmd.Flags().BoolVar(&estimate, "estimate", false, "Encode a short sample of each file and project the run's total output size, space saved and encoding time, without transcoding")
+	rootCmd.Flags().DurationVar(&estimateSample, "estimate-sample", transcoder.DefaultEstimateSample, "Length of the sample --estimate encodes from the middle of each file")
+	rootCmd.Flags().StringArrayVar(&historyFiles, "history", nil, "Analytics CSV from an earlier --csv-output run to base --dry-run estimates on (repeatable)")
 	rootCmd.Flags().IntVar(&gpuIndex, "gpu", 0, "GPU index to use (default: 0)")
 	rootCmd.Flags().BoolVar(&noGPU, "no-gpu", false, "Force software encoding (disable GPU acceleration)")
-	rootCmd.Flags().StringVar(&audioCodec, "audio-codec", "copy", "Audio codec: copy (default), aac, ac3, mp3")
+	rootCmd.Flags().StringSliceVar(&softwareCodecs, "software-codecs", nil, "Force software encoding only for these codecs, e.g. av1 (comma-separated: h264, hevc, av1)")
+	rootCmd.Flags().StringVar(&outputMode, "output-mode", "", "Permissions for output files in octal, e.g. 0644 (directories get matching search bits)")
+	rootCmd.Flags().StringVar(&outputGroup, "output-group", "", "Group name or ID for output files and directories (Unix only)")
+	rootCmd.Flags().BoolVar(&dvdMode, "dvd", false, "Treat input as ripped DVD folders: concatenate each title's VTS_XX_Y.VOB parts into one output")
+	rootCmd.Flags().Float64Var(&maxrateFactor, "maxrate-factor", 0, "Set -maxrate to this multiple of the preset bitrate, e.g. 1.5 (default: preset value)")
+	rootCmd.Flags().Float64Var(&bufsizeFactor, "bufsize-factor", 0, "Set -bufsize to this multiple of the preset bitrate, e.g. 2 (default: preset value)")
+	rootCmd.Flags().BoolVar(&interactive, "interactive", false, "Review each file before encoding: encode, skip, change preset or quit")
+	rootCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Approve all files without prompting (allows --interactive without a terminal)")
+	rootCmd.Flags().StringArrayVar(&subFiles, "sub-file", nil, "External subtitle file to mux into the output (repeatable; single input file only)")
+	rootCmd.Flags().StringVar(&subLang, "sub-lang", "", "Language tag for attached subtitles without one in their filename, e.g. eng")
+	rootCmd.Flags().StringVar(&tonemapMode, "tonemap", transcoder.TonemapAuto, "Tone map HDR sources to SDR: auto (sources probed as HDR10 or HLG), on (every source) or off")
+	rootCmd.Flags().StringVar(&tonemapAlgo, "tonemap-algorithm", transcoder.TonemapAlgorithms[0], "Tone mapping curve: hable (filmic), mobius (keeps in-range colors) or reinhard (simple, brighter)")
+	rootCmd.Flags().StringVar(&deinterlace, "deinterlace", transcoder.DeinterlaceOff, "Deinterlace sources: off, auto (sources probed as interlaced; the value of a bare --deinterlace) or on (every source)")
+	rootCmd.Flags().Lookup("deinterlace").NoOptDefVal = transcoder.DeinterlaceAuto
+	rootCmd.Flags().StringVar(&deintFilter, "deinterlace-filter", transcoder.DeinterlaceFilters[0], "Deinterlacing filter: yadif (fast) or bwdif (sharper, slower)")
+	rootCmd.Flags().StringVar(&cropValue, "crop", "", "Crop each source before scaling: W:H:X:Y, or auto to detect black bars with cropdetect (shown with --verbose)")
+	rootCmd.Flags().StringVar(&subtitleMode, "subtitles", transcoder.SubtitleModeNone, "Embedded subtitles: none (ffmpeg's default selection), copy (keep every track) or burn (draw the first text track onto the video)")
+	rootCmd.Flags().BoolVar(&noAutoSubs, "no-auto-subs", false, "Don't attach same-basename subtitle files (movie.srt, movie.en.srt) automatically")
+	rootCmd.Flags().StringVar(&manifest, "manifest", "", "Append a checksum line for each successful output to this file, verifiable with sha256sum -c")
+	rootCmd.Flags().StringVar(&manifestAlgo, "manifest-algo", "sha256", "Manifest hash algorithm: sha256, sha512 or blake3")
+	rootCmd.Flags().IntVarP(&jobs, "jobs", "j", 1, "Files to encode at once; hardware encodes are capped at 3 because NVENC limits concurrent sessions")
+	rootCmd.Flags().BoolVar(&groupByCodec, "group-by-codec", false, "End the run with counts and space saved per source video codec")
+	rootCmd.Flags().StringVar(&ratioStyle, "ratio-style", transcoder.RatioStyleSaved, "How output sizes are shown: saved (\"saved 58.0% (2.3 GiB)\") or original (\"42.0% of original size\")")
+	rootCmd.Flags().BoolVar(&energy, "energy", false, "End each batch with an approximate energy estimate, total and per GB saved (NVIDIA hardware encodes sample the GPU power draw)")
+	rootCmd.Flags().Float64Var(&energyWatts, "energy-watts", 0, "Power draw in watts assumed for --energy when it is not sampled (default: 65, or 20 on Apple Silicon)")
+	rootCmd.Flags().StringVar(&maxBitrate, "max-bitrate", "", "Bitrate ceiling such as 8M for capped CRF (requires --crf): quality floats but never exceeds the cap")
+	rootCmd.Flags().BoolVar(&repair, "repair", false, "Stream-copy remux inputs with fixable container problems (MP4 index at the end, broken index) before encoding them")
+	rootCmd.Flags().BoolVar(&adaptive, "adaptive-bitrate", false, "Set each file's target bitrate from a quick complexity probe encode instead of the preset's fixed value")
+	rootCmd.Flags().BoolVar(&twoPass, "two-pass", false, "Encode presets with a target bitrate in two passes for predictable file sizes (x264/x265; NVENC uses its own multipass)")
+	rootCmd.Flags().BoolVar(&measureVMAF, "measure-quality", false, "Score each output against its source with VMAF after encoding and record it in the analytics (needs libvmaf; skipped with a warning without it)")
+	rootCmd.Flags().BoolVar(&measureSSIM, "measure-ssim", false, "Score each output against its source with SSIM after encoding and record it in the analytics")
+	rootCmd.Flags().StringVar(&trimStart, "start", "", "Start each output at this position in the source, e.g. 5:00, 300 or 5m (seeks before decoding)")
+	rootCmd.Flags().StringVar(&trimDuration, "duration", "", "Encode only this much of each source from --start, e.g. 1:30:00 or 90m")
+	rootCmd.Flags().StringVar(&trimEnd, "end", "", "End each output at this position in the source (instead of --duration)")
+	rootCmd.Flags().IntVar(&retries, "retries", 0, "Retry an encode up to N times, with growing waits, when it fails with a transient GPU error such as out of memory or no free sessions")
+	rootCmd.Flags().StringVar(&ffmpegArgs, "ffmpeg-args", "", "Extra ffmpeg arguments, quoted like a shell command line, placed just before the output path so they override preset options (use at your own risk; -i, -y and extra outputs are rejected)")
+	rootCmd.Flags().StringVar(&adaptiveMin, "adaptive-min", "", "Lowest bitrate --adaptive-bitrate may choose, e.g. 2M (default: half the preset bitrate)")
+	rootCmd.Flags().StringVar(&adaptiveMax, "adaptive-max", "", "Highest bitrate --adaptive-bitrate may choose, e.g. 12M (default: 1.5x the preset bitrate)")
+	rootCmd.Flags().BoolVar(&smartBitrate, "smart-bitrate", false, "Cap each file's target bitrate at 80% of the source's bitrate, scaling -maxrate and -bufsize to match; never raises it")
+	rootCmd.Flags().IntVar(&lookahead, "lookahead", 0, "NVENC rate-control lookahead in frames (0-32); ignored with a warning on other encoders")
+	rootCmd.Flags().IntVar(&bframes, "bframes", 0, "NVENC B-frames (0-4); ignored with a warning on other encoders")
+	rootCmd.Flags().StringVar(&aqMode, "aq", "", "NVENC adaptive quantization: spatial, temporal or both; ignored with a warning on other encoders")
+	rootCmd.Flags().StringVar(&container, "container", transcoder.ContainerMKV, "Output container: mkv, mp4 (with +faststart, for device compatibility) or webm (AV1 with Opus audio, for web delivery)")
+	rootCmd.Flags().StringVar(&forceExtension, "force-extension", "", "Name outputs with this extension (e.g. mp4) while still writing the --container format, for devices that check only the name")
+	rootCmd.Flags().StringVar(&suffix, "suffix", "", "Tag appended to output names after the preset, e.g. crf20 for movie_1080p_h265_crf20.mkv")
+	rootCmd.Flags().StringVar(&nameTemplate, "name-template", transcoder.DefaultNameTemplate, "Output name before the suffix and extension, from {name}, {preset}, {codec}, {resolution}, {height}, {ext} and {date}")
+	rootCmd.Flags().IntVar(&crf, "crf", -1, "Quality override on a 0-51 CRF scale (lower is better), translated to -q:v for VideoToolbox and -cq for NVENC (default: preset value)")
+	rootCmd.Flags().StringVar(&encoderSpeed, "encoder-speed", "", "Speed preset override: ultrafast ... veryslow, translated to p1-p7 for NVENC and SVT-AV1 presets, or the encoder's own value such as p6 (default: preset value)")
+	rootCmd.Flags().StringVar(&fps, "fps", "", "Output frame rate: integer, decimal, fraction or name, e.g. 25, 29.97, 30000/1001, ntsc, pal, film (default: source rate)")
+	rootCmd.Flags().IntVar(&batchSize, "batch-size", 0, "Discover and process files in batches of this many, with progress and summaries per batch; keeps memory bounded for huge libraries (0 processes all files as one batch)")
+	rootCmd.Flags().StringVar(&minSize, "min-size", "", "Skip discovered files smaller than this, e.g. 50M or 1.5G (powers of 1024)")
+	rootCmd.Flags().StringVar(&minDuration, "min-duration", "", "Skip discovered files shorter than this, e.g. 2:00 or 120 (probes each file)")
+	rootCmd.Flags().StringVar(&maxDuration, "max-duration", "", "Skip discovered files longer than this, e.g. 3:00:00 or 180m (probes each file)")
+	rootCmd.Flags().IntVar(&maxFilesPerDir, "max-files-per-dir", 0, "Stop with an error before using a directory that holds more video files than this (0 for no limit)")
+	rootCmd.Flags().DurationVar(&maxRuntime, "max-runtime", 0, "Stop starting new files after the batch has run this long, e.g. 2h; the file in progress finishes")
+	rootCmd.Flags().BoolVar(&fixAspect, "fix-aspect", false, "Scale sources with non-square pixels (anamorphic DVDs, broadcast captures) to their display shape with square pixels")
+	rootCmd.Flags().BoolVar(&noUpscale, "no-upscale", false, "Don't scale sources that are already at or below the preset resolution up to it; they keep their own size")
+	rootCmd.Flags().BoolVar(&autoOrient, "auto-orient", false, "Match the output orientation to the source: portrait sources get portrait scaling and vice versa")
+	rootCmd.Flags().StringVar(&audioCodec, "audio-codec", "copy", "Audio codec: copy (default), aac, ac3, mp3, opus or flac; webm outputs re-encode audio that is not opus")
+	rootCmd.Flags().StringVar(&audioBitrate, "audio-bitrate", "", "Bitrate of re-encoded audio, e.g. 192k (default 128k; not for flac)")
+	rootCmd.Flags().BoolVar(&normalizeAudio, "normalize-audio", false, "Normalize loudness to EBU R128 (-23 LUFS) with loudnorm; copied audio is re-encoded to aac")
+	rootCmd.Flags().BoolVar(&normalize2Pass, "normalize-audio-2pass", false, "Measure each source's loudness first and normalize with the measured values (implies --normalize-audio)")
+	rootCmd.Flags().StringVar(&audioTrack, "audio-track", "", "Audio track to keep: a 0-based index among the audio streams, or all (default: the track ffmpeg picks)")
 	rootCmd.Flags().StringVar(&csvOutput, "csv-output", "", "CSV file to save conversion analytics (optional)")
+	rootCmd.Flags().StringVar(&jsonOutput, "json-output", "", "JSON file to save conversion analytics; .jsonl or .ndjson writes one record per line (optional)")
+	rootCmd.Flags().BoolVar(&sidecar, "sidecar", false, "Write a <output>.json sidecar describing each successful encode")
+	rootCmd.Flags().BoolVar(&thumbnail, "thumbnail", false, "Write a representative frame of each successful output as a .jpg next to it")
+	rootCmd.Flags().StringVar(&thumbnailAt, "thumbnail-at", "", "Take the thumbnail at this position in the output instead, e.g. 90 or 00:01:30 (implies --thumbnail)")
+	rootCmd.Flags().IntVar(&contactSheet, "contact-sheet", 0, fmt.Sprintf("Tile this many evenly spaced frames of each output into a <output>_sheet.jpg (2-%d; 0 for none)", transcoder.MaxContactSheetFrames))
+	rootCmd.Flags().StringArrayVar(&policy, "policy", nil, "Only process files violating a policy, e.g. 'codec!=hevc' or 'codec==h264,bitrate>8M' (repeatable; any expression may match)")
+	rootCmd.Flags().StringVar(&qualityTarget, "quality-target", "", "Quality level setting CRF, speed preset and tune together per encoder: low, medium, high, archival (--crf and --tune override its parts)")
+	rootCmd.Flags().StringVar(&tune, "tune", "", "Encoder tuning: film, animation, grain, stillimage, fastdecode, zerolatency (x264/x265); hq, ll, ull, lossless (NVENC); film, grain, psnr (SVT-AV1)")
+	rootCmd.Flags().StringVar(&tempDir, "temp-dir", "", "Directory for intermediate files (default: $TMPDIR or the system temp directory)")
+	rootCmd.Flags().StringVar(&stageDir, "stage-dir", "", "Write outputs here first and move them into place once each encode succeeds")
+	rootCmd.Flags().StringVar(&probeCache, "probe-cache", "", "JSON file that keeps ffprobe results between runs; unchanged files (same size and mtime) are not probed again")
+	rootCmd.Flags().BoolVar(&resume, "resume", false, "Record each file's status in a state file and skip files an earlier, interrupted run finished with the same preset")
+	rootCmd.Flags().StringVar(&resumeFile, "resume-file", "", "State file for --resume (default: "+transcoder.ResumeStateName+" in the output directory)")
+	rootCmd.Flags().BoolVar(&retryFailed, "retry-failed", false, "With --resume, encode files that failed in an earlier run again instead of skipping them")
+	rootCmd.Flags().DurationVar(&probeTimeout, "input-probe-timeout", transcoder.DefaultProbeTimeout, "Skip a file when checking it before encoding takes longer than this (guards against files that hang ffmpeg)")
+	rootCmd.Flags().StringVar(&codecFlag, "codec", "", "Video codec (h264, h265, av1); combined with --resolution into a preset for this platform, overriding --preset")
+	rootCmd.Flags().StringVar(&resolution, "resolution", "", "Resolution tier (720p, 1080p, 4k); combined with --codec into a preset for this platform, overriding --preset")
+	rootCmd.Flags().BoolVar(&noKeys, "no-keys", false, "Disable the p/r/q keyboard controls (pause, resume, quit) on a terminal")
+	rootCmd.Flags().BoolVar(&noProbe, "no-probe", false, "Skip probing input durations up front (progress counts files instead of duration)")
+	rootCmd.Flags().BoolVar(&noProgress, "no-progress", false, "Don't draw the live per-file progress line; progress is printed only after each file (useful for log capture)")
+	rootCmd.Flags().BoolVar(&noToolMetadata, "no-tool-metadata", false, "Don't embed ffmcli provenance metadata in output files")
+	rootCmd.Flags().BoolVar(&stripMetadata, "strip-metadata", false, "Don't copy the source's title and other global tags or its chapters to the output")
 
-	rootCmd.MarkFlagRequired("input")
 	rootCmd.MarkFlagRequired("output")
 
 	// Add subcommands
 	rootCmd.AddCommand(checkCmd)
 	rootCmd.AddCommand(presetsCmd)
+	presetsCmd.AddCommand(presetsShowCmd)
+	rootCmd.AddCommand(suggestCmd)
+	rootCmd.AddCommand(reportExistingCmd)
+	rootCmd.AddCommand(previewCmd)
+	rootCmd.AddCommand(encodersCmd)
+	rootCmd.AddCommand(qualityLadderCmd)
+	rootCmd.AddCommand(cleanupCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(watchCmd)
+
+	suggestCmd.Flags().BoolVarP(&suggestRecursive, "recursive", "r", false, "Recursively scan directories")
+	suggestCmd.Flags().IntVar(&suggestSample, "sample", 20, "Maximum number of files to probe when suggesting for a directory")
+
+	reportExistingCmd.Flags().StringVarP(&inputFile, "input", "i", "", "Source file or directory (required)")
+	reportExistingCmd.Flags().StringVarP(&outputDir, "output", "o", "", "Directory holding existing outputs (required)")
+	reportExistingCmd.Flags().StringVarP(&reportPreset, "preset", "p", "", "Only pair outputs of this preset (default: any preset)")
+	reportExistingCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Recursively scan the source directory")
+	reportExistingCmd.Flags().BoolVar(&reportSidecars, "sidecars", false, "Pair outputs using their .json sidecars instead of output naming")
+	reportExistingCmd.Flags().StringVar(&reportTemplate, "name-template", transcoder.DefaultNameTemplate, "The --name-template the run used, to pair sources with output names")
+	reportExistingCmd.Flags().StringVar(&csvOutput, "csv-output", "", "CSV file to save conversion analytics (optional)")
+	reportExistingCmd.Flags().StringVar(&ratioStyle, "ratio-style", transcoder.RatioStyleSaved, "How the total size change is shown: saved or original")
+	reportExistingCmd.MarkFlagRequired("input")
+	reportExistingCmd.MarkFlagRequired("output")
+
+	previewCmd.Flags().StringVarP(&preset, "preset", "p", "1080p_h264", "Encoding preset to preview")
+	previewCmd.Flags().StringVarP(&previewDir, "output", "o", "", "Directory for the sample file (default: system temp directory)")
+	previewCmd.Flags().DurationVar(&previewStart, "start", 0, "Position in the source to start the sample at, e.g. 5m30s")
+	previewCmd.Flags().DurationVar(&previewLength, "length", 10*time.Second, "Length of the sample")
+	previewCmd.Flags().BoolVar(&previewNoPlay, "no-play", false, "Only write the sample file, don't play it")
+	previewCmd.Flags().BoolVar(&debugOverlay, "debug-overlay", false, "Burn the frame number, timestamp, quality and bitrate into the sample (needs drawtext)")
+	previewCmd.Flags().BoolVar(&noGPU, "no-gpu", false, "Force software encoding (disable GPU acceleration)")
+	previewCmd.Flags().StringVar(&tune, "tune", "", "Encoder tuning (see the main command)")
+	previewCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
+
+	qualityLadderCmd.Flags().StringVarP(&preset, "preset", "p", "1080p_h264", "Encoding preset the samples use")
+	qualityLadderCmd.Flags().StringVarP(&previewDir, "output", "o", "", "Directory for the sample files (default: system temp directory)")
+	qualityLadderCmd.Flags().IntSliceVar(&ladderCRFs, "crf", transcoder.DefaultLadderCRFs, "CRF values to encode, e.g. 18,20,22,24,26")
+	qualityLadderCmd.Flags().DurationVar(&previewStart, "start", 0, "Position in the source to start the samples at, e.g. 5m30s")
+	qualityLadderCmd.Flags().DurationVar(&previewLength, "length", 10*time.Second, "Length of each sample")
+	qualityLadderCmd.Flags().StringVar(&ladderMetric, "metric", "", "Also score each sample against the source: vmaf (needs libvmaf) or ssim")
+	qualityLadderCmd.Flags().BoolVar(&debugOverlay, "debug-overlay", false, "Burn the frame number, timestamp, CRF and bitrate into each sample (needs drawtext; not with --metric)")
+	qualityLadderCmd.Flags().BoolVar(&noGPU, "no-gpu", false, "Force software encoding (disable GPU acceleration)")
+	qualityLadderCmd.Flags().StringVar(&tune, "tune", "", "Encoder tuning (see the main command)")
+	qualityLadderCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
+
+	cleanupCmd.Flags().StringVarP(&outputDir, "output", "o", "", "Output directory to scan (required)")
+	cleanupCmd.Flags().StringVarP(&inputFile, "input", "i", "", "Source file or directory, to detect outputs much shorter than their source")
+	cleanupCmd.Flags().StringVarP(&cleanupPreset, "preset", "p", "", "Only pair sources with outputs of this preset (default: any preset)")
+	cleanupCmd.Flags().StringVar(&cleanupTemplate, "name-template", transcoder.DefaultNameTemplate, "The --name-template the run used, to pair sources with output names")
+	cleanupCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Recursively scan the source directory")
+	cleanupCmd.Flags().Float64Var(&cleanupMinRatio, "min-duration-ratio", transcoder.DefaultMinDurationRatio, "Outputs shorter than this share of their source duration are considered truncated")
+	cleanupCmd.Flags().BoolVar(&cleanupDelete, "delete", false, "Delete the listed files (asks for confirmation unless --yes)")
+	cleanupCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Delete without asking for confirmation")
+	cleanupCmd.MarkFlagRequired("output")
+
+	verifyCmd.Flags().StringVarP(&outputDir, "output", "o", "", "Output directory to verify (required)")
+	verifyCmd.Flags().StringVarP(&inputFile, "input", "i", "", "Source file or directory, to compare output durations with their sources")
+	verifyCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Recursively scan the source directory")
+	verifyCmd.Flags().StringVar(&verifyCSV, "csv", "", "Analytics CSV from the run (--csv-output); only its converted files are verified, and missing outputs fail")
+	verifyCmd.Flags().StringVar(&verifySuffix, "suffix", "", "The --suffix the run used, to match CSV rows to output names")
+	verifyCmd.Flags().StringVar(&verifyTemplate, "name-template", transcoder.DefaultNameTemplate, "The --name-template the run used, to match CSV rows to output names")
+	verifyCmd.Flags().StringVar(&verifyContainer, "container", transcoder.ContainerMKV, "The --container the run used, to match CSV rows to output names")
+	verifyCmd.Flags().StringVar(&verifyExtension, "force-extension", "", "The --force-extension the run used, to match CSV rows to output names")
+	verifyCmd.Flags().Float64Var(&verifyMinRatio, "min-duration-ratio", transcoder.DefaultMinDurationRatio, "Outputs shorter than this share of their source duration fail")
+	verifyCmd.MarkFlagRequired("output")
+
+	watchCmd.Flags().StringVarP(&inputFile, "input", "i", "", "Directory to watch for new video files (required)")
+	watchCmd.Flags().StringVarP(&outputDir, "output", "o", "", "Output directory (required)")
+	watchCmd.Flags().StringVarP(&preset, "preset", "p", "1080p_h264", "Encoding preset")
+	watchCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Also watch subdirectories, including ones created later")
+	watchCmd.Flags().DurationVar(&stableDelay, "stable-delay", transcoder.DefaultStableDelay, "How long a file's size must stay unchanged before it is considered completely written")
+	watchCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Overwrite existing output files")
+	watchCmd.Flags().BoolVar(&deleteSource, "delete-source", false, "Delete each source after a successful encode, once the output probes cleanly and matches the source duration")
+	watchCmd.Flags().IntVar(&gpuIndex, "gpu", 0, "GPU index to use (default: 0)")
+	watchCmd.Flags().BoolVar(&noGPU, "no-gpu", false, "Force software encoding (disable GPU acceleration)")
+	watchCmd.Flags().StringVar(&container, "container", transcoder.ContainerMKV, "Output container: mkv, mp4 or webm")
+	watchCmd.Flags().StringVar(&audioCodec, "audio-codec", "copy", "Audio codec: copy (default), aac, ac3, mp3, opus or flac; webm outputs re-encode audio that is not opus")
+	watchCmd.Flags().StringVar(&audioBitrate, "audio-bitrate", "", "Bitrate of re-encoded audio, e.g. 192k (default 128k; not for flac)")
+	watchCmd.Flags().BoolVar(&normalizeAudio, "normalize-audio", false, "Normalize loudness to EBU R128 (-23 LUFS) with loudnorm; copied audio is re-encoded to aac")
+	watchCmd.Flags().BoolVar(&normalize2Pass, "normalize-audio-2pass", false, "Measure each source's loudness first and normalize with the measured values (implies --normalize-audio)")
+	watchCmd.Flags().StringVar(&csvOutput, "csv-output", "", "CSV file to save conversion analytics (optional)")
+	watchCmd.Flags().StringVar(&jsonOutput, "json-output", "", "JSON file to save conversion analytics; .jsonl or .ndjson writes one record per line (optional)")
+	watchCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output (same as --log-level debug)")
+	watchCmd.Flags().StringVar(&logLevel, "log-level", "info", "Lowest level of messages shown: debug, info, warn or error")
+	watchCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Only show warnings and errors (same as --log-level warn)")
+	watchCmd.Flags().StringVar(&logFile, "log-file", "", "Append each input's ffmpeg commands, full stderr, exit status and fallback to this file, at any log level")
+	watchCmd.MarkFlagRequired("input")
+	watchCmd.MarkFlagRequired("output")
+
+	encodersCmd.Flags().BoolVar(&encodersJSON, "json", false, "Print the encoder list as JSON")
+	encodersCmd.Flags().BoolVar(&encodersNoSmoke, "no-smoke-test", false, "Only check that encoders are compiled in; skip the one-frame test encode")
 }
 
-func Execute() error {
+// Execute runs the root command; version is recorded in output metadata
+func Execute(version string) error {
+	if version != "" {
+		toolVersion = version
+		rootCmd.Version = version
+	}
 	return rootCmd.Execute()
 }
 
+// applyProject fills in flags from --project. It runs before cobra checks
+// required flags, so the project file can supply --output. Flags given on
+// the command line, and positional inputs, take precedence over the file.
+func applyProject(cmd *cobra.Command, args []string) error {
+	if projectFile == "" {
+		return nil
+	}
+
+	flags := cmd.Flags()
+	settings, err := transcoder.LoadProject(projectFile, func(key string) bool {
+		return key != "project" && key != "help" && flags.Lookup(key) != nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fromCommandLine := make(map[string]bool)
+	for _, setting := range settings {
+		fromCommandLine[setting.Key] = flags.Changed(setting.Key) || (setting.Key == "input" && len(args) > 0)
+	}
+	for _, setting := range settings {
+		if fromCommandLine[setting.Key] {
+			continue
+		}
+		switch flags.Lookup(setting.Key).Value.Type() {
+		case "stringArray", "stringSlice":
+		default:
+			if len(setting.Values) > 1 {
+				return fmt.Errorf("%s:%d: key '%s' takes a single value, not a list", projectFile, setting.Line, setting.Key)
+			}
+		}
+		for _, value := range setting.Values {
+			if err := flags.Set(setting.Key, value); err != nil {
+				return fmt.Errorf("%s:%d: key '%s': %v", projectFile, setting.Line, setting.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
 func runTranscode(cmd *cobra.Command, args []string) error {
+	// Inputs come from repeated -i flags and positional arguments
+	inputs := append(append([]string(nil), inputPaths...), args...)
+
 	// Validate required flags
-	if inputFile == "" {
+	if len(inputs) == 0 {
 		return fmt.Errorf("input file or directory is required")
 	}
+	for _, input := range inputs {
+		if input == "" {
+			return fmt.Errorf("input file or directory is required")
+		}
+	}
+
+	// Expand quoted globs such as -i '*.mkv' and drop repeated inputs
+	inputs, err := transcoder.ExpandInputs(inputs)
+	if err != nil {
+		return err
+	}
 	if outputDir == "" {
 		return fmt.Errorf("output directory is required")
 	}
 
-	// Check if input exists
-	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
-		return fmt.Errorf("input file or directory does not exist: %s", inputFile)
+	// Check if inputs exist
+	for _, input := range inputs {
+		if _, err := os.Stat(input); os.IsNotExist(err) {
+			return fmt.Errorf("input file or directory does not exist: %s", input)
+		}
 	}
 
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
+	// Offer a menu to users who did not name a preset, when someone can answer
+	if !cmd.Flags().Changed("preset") && codecFlag == "" && resolution == "" && !assumeYes && transcoder.IsTerminal(os.Stdin) {
+		chosen, err := transcoder.ChoosePreset(os.Stdin, os.Stdout, preset)
+		if err != nil {
+			return err
+		}
+		preset = chosen
 	}
 
-	// Validate preset
-	if !transcoder.IsValidPreset(preset) {
-		availablePresets := strings.Join(transcoder.GetAvailablePresets(), ", ")
-		return fmt.Errorf("invalid preset '%s'. Available presets: %s", preset, availablePresets)
+	// Validate presets; a comma-separated list encodes each file once per preset
+	var presets []string
+	for _, name := range strings.Split(preset, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || slices.Contains(presets, name) {
+			continue
+		}
+		if !transcoder.IsValidPreset(name) {
+			availablePresets := strings.Join(transcoder.GetAvailablePresets(), ", ")
+			return fmt.Errorf("invalid preset '%s'. Available presets: %s", name, availablePresets)
+		}
+		presets = append(presets, name)
+	}
+	if len(presets) == 0 {
+		return fmt.Errorf("a preset is required")
+	}
+	if len(presets) > 1 && (codecFlag != "" || resolution != "") {
+		return fmt.Errorf("--codec and --resolution build a single preset and cannot be combined with several presets")
+	}
+	preset = presets[0]
+	if len(presets) == 1 {
+		presets = nil
+	}
+	if codecFlag != "" && !transcoder.IsComposableCodec(codecFlag) {
+		return fmt.Errorf("invalid --codec '%s' (use h264, h265 or av1)", codecFlag)
+	}
+	if resolution != "" && !transcoder.IsValidResolution(resolution) {
+		return fmt.Errorf("invalid --resolution '%s'. Available resolutions: %s",
+			resolution, strings.Join(transcoder.GetResolutions(), ", "))
 	}
 
-	// Create transcoder config
-	config := transcoder.Config{
-		InputPath:  inputFile,
-		OutputDir:  outputDir,
-		Preset:     preset,
-		Recursive:  recursive,
-		Overwrite:  overwrite,
-		Verbose:    verbose,
-		DryRun:     dryRun,
-		GPUIndex:   gpuIndex,
-		NoGPU:      noGPU,
-		AudioCodec: audioCodec,
+	var mode os.FileMode
+	if outputMode != "" {
+		parsed, err := strconv.ParseUint(outputMode, 8, 32)
+		if err != nil || parsed > 0777 {
+			return fmt.Errorf("invalid --output-mode '%s' (use octal such as 0644)", outputMode)
+		}
+		mode = os.FileMode(parsed)
 	}
 
-	// Initialize transcoder
-	t := transcoder.New(config)
+	// Explicit subtitle files only make sense for a single input file
+	if len(subFiles) > 0 {
+		if len(inputs) > 1 {
+			return fmt.Errorf("--sub-file can only be used with a single input file")
+		}
+		if info, err := os.Stat(inputs[0]); err == nil && info.IsDir() {
+			return fmt.Errorf("--sub-file can only be used with a single input file")
+		}
+	}
 
-	// Check GPU availability (skip if using software-only mode)
-	if !noGPU {
-		if err := t.CheckGPUAvailability(); err != nil {
-			fmt.Printf("GPU check failed: %v\n", err)
-			fmt.Printf("Consider using --no-gpu flag for software encoding\n")
+	// Refuse to prompt when nobody can answer, so scripts never hang
+	if interactive && !assumeYes && !transcoder.IsTerminal(os.Stdin) {
+		return fmt.Errorf("--interactive requires a terminal; use --yes to approve all files")
+	}
+
+	if len(historyFiles) > 0 && !dryRun && !estimate {
+		return fmt.Errorf("--history is only used with --dry-run or --estimate")
+	}
+	if estimate && dryRun {
+		return fmt.Errorf("--estimate and --dry-run cannot be combined; --dry-run estimates without encoding samples")
+	}
+	if cmd.Flags().Changed("estimate-sample") && !estimate {
+		return fmt.Errorf("--estimate-sample requires --estimate")
+	}
+	if estimateSample <= 0 {
+		return fmt.Errorf("--estimate-sample must be positive")
+	}
+
+	if maxrateFactor < 0 || bufsizeFactor < 0 {
+		return fmt.Errorf("--maxrate-factor and --bufsize-factor must be positive")
+	}
+
+	var frameRate string
+	if fps != "" {
+		var err error
+		if frameRate, err = transcoder.ParseFrameRate(fps); err != nil {
 			return err
 		}
 	}
 
-	// Find files to process
-	files, err := t.FindVideoFiles()
+	if qualityTarget != "" && !transcoder.IsQualityTarget(qualityTarget) {
+		return fmt.Errorf("--quality-target must be one of %s", strings.Join(transcoder.QualityTargets, ", "))
+	}
+	if !transcoder.IsRatioStyle(ratioStyle) {
+		return fmt.Errorf("--ratio-style must be one of %s", strings.Join(transcoder.RatioStyles, ", "))
+	}
+	level, err := resolveLogLevel(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to find video files: %v", err)
+		return err
+	}
+	if !transcoder.IsTonemapMode(tonemapMode) {
+		return fmt.Errorf("--tonemap must be one of %s", strings.Join(transcoder.TonemapModes, ", "))
+	}
+	if !transcoder.IsTonemapAlgorithm(tonemapAlgo) {
+		return fmt.Errorf("--tonemap-algorithm must be one of %s", strings.Join(transcoder.TonemapAlgorithms, ", "))
+	}
+	if !transcoder.IsDeinterlaceMode(deinterlace) {
+		return fmt.Errorf("--deinterlace must be one of %s", strings.Join(transcoder.DeinterlaceModes, ", "))
+	}
+	if !transcoder.IsDeinterlaceFilter(deintFilter) {
+		return fmt.Errorf("--deinterlace-filter must be one of %s", strings.Join(transcoder.DeinterlaceFilters, ", "))
+	}
+	if cropValue != "" && cropValue != transcoder.CropAuto {
+		if _, err := transcoder.ParseCrop(cropValue); err != nil {
+			return err
+		}
+	}
+	if !transcoder.IsSubtitleMode(subtitleMode) {
+		return fmt.Errorf("--subtitles must be one of %s", strings.Join(transcoder.SubtitleModes, ", "))
+	}
+	if qualityTarget != "" && adaptive {
+		return fmt.Errorf("--adaptive-bitrate sets a target bitrate and cannot be combined with --quality-target")
 	}
 
-	if len(files) == 0 {
-		return fmt.Errorf("no video files found")
+	if energyWatts < 0 {
+		return fmt.Errorf("--energy-watts must not be negative")
 	}
 
-	fmt.Printf("Found %d video file(s) to process\n", len(files))
+	if suffix != "" && transcoder.NewPathUtils().SanitizeSuffix(suffix) == "" {
+		return fmt.Errorf("--suffix '%s' is empty after removing characters not allowed in filenames", suffix)
+	}
 
-	// Setup CSV logging if requested
-	var csvWriter *csv.Writer
-	var csvFile *os.File
-	if csvOutput != "" {
-		var err error
-		csvFile, err = os.Create(csvOutput)
-		if err != nil {
-			return fmt.Errorf("failed to create CSV file: %v", err)
+	if err := transcoder.ValidateNameTemplate(nameTemplate); err != nil {
+		return fmt.Errorf("--name-template: %v", err)
+	}
+
+	if !transcoder.IsContainer(container) {
+		return fmt.Errorf("--container must be one of %s", strings.Join(transcoder.Containers, ", "))
+	}
+	if err := transcoder.CheckAudioBitrate(audioCodec, audioBitrate, container, normalizeAudio || normalize2Pass); err != nil {
+		return fmt.Errorf("--audio-bitrate: %v", err)
+	}
+	outputExtension, err := forcedExtension(forceExtension, container)
+	if err != nil {
+		return err
+	}
+	if outputExtension != "" {
+		fmt.Printf("WARNING: outputs are %s files named *%s. Players and tools that trust the extension may refuse or misread them.\n", container, outputExtension)
+	}
+
+	var crfOverride *int
+	if cmd.Flags().Changed("crf") {
+		if crf < 0 || crf > transcoder.MaxCRF {
+			return fmt.Errorf("--crf must be between 0 and %d", transcoder.MaxCRF)
 		}
-		defer csvFile.Close()
+		crfOverride = &crf
+	}
 
-		csvWriter = csv.NewWriter(csvFile)
-		defer csvWriter.Flush()
+	var bitrateCap float64
+	if maxBitrate != "" {
+		if crfOverride == nil {
+			return fmt.Errorf("--max-bitrate caps a constant-quality encode and requires --crf")
+		}
+		parsed, err := transcoder.ParseBitrate(maxBitrate)
+		if err != nil {
+			return err
+		}
+		bitrateCap = parsed
+	}
 
-		// Write CSV header
-		header := []string{"filename", "start_time", "end_time", "duration_seconds", "size_before_mb", "size_after_mb", "space_saved_mb", "compression_ratio", "preset", "status"}
-		if err := csvWriter.Write(header); err != nil {
-			return fmt.Errorf("failed to write CSV header: %v", err)
+	var adaptiveLow, adaptiveHigh float64
+	if jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+	if adaptive && jobs > 1 {
+		return fmt.Errorf("--adaptive-bitrate cannot be combined with --jobs above 1")
+	}
+	if twoPass && (crfOverride != nil || qualityTarget != "") {
+		return fmt.Errorf("--two-pass targets the preset bitrate and cannot be combined with --crf or --quality-target")
+	}
+	if adaptive && crfOverride != nil {
+		return fmt.Errorf("--adaptive-bitrate sets a target bitrate and cannot be combined with --crf")
+	}
+	if smartBitrate && (crfOverride != nil || qualityTarget != "") {
+		return fmt.Errorf("--smart-bitrate caps the target bitrate and cannot be combined with --crf or --quality-target")
+	}
+	if (adaptiveMin != "" || adaptiveMax != "") && !adaptive {
+		return fmt.Errorf("--adaptive-min and --adaptive-max require --adaptive-bitrate")
+	}
+	if adaptiveMin != "" {
+		parsed, err := transcoder.ParseBitrate(adaptiveMin)
+		if err != nil {
+			return err
+		}
+		adaptiveLow = parsed
+	}
+	if adaptiveMax != "" {
+		parsed, err := transcoder.ParseBitrate(adaptiveMax)
+		if err != nil {
+			return err
 		}
+		adaptiveHigh = parsed
+	}
+	if adaptiveLow > 0 && adaptiveHigh > 0 && adaptiveLow > adaptiveHigh {
+		return fmt.Errorf("--adaptive-min must not be above --adaptive-max")
 	}
 
-	// Process files with progress tracking
-	return t.ProcessFilesWithProgress(files, csvWriter)
-}
+	var lookaheadOverride, bframesOverride *int
+	if cmd.Flags().Changed("lookahead") {
+		lookaheadOverride = &lookahead
+	}
+	if cmd.Flags().Changed("bframes") {
+		bframesOverride = &bframes
+	}
+	if err := transcoder.ValidateNVENCOptions(lookaheadOverride, bframesOverride, aqMode); err != nil {
+		return err
+	}
 
-var checkCmd = &cobra.Command{
-	Use:   "check",
-	Short: "Check system requirements and hardware acceleration availability",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		config := transcoder.Config{SkipValidation: true}
-		t := transcoder.New(config)
+	if probeTimeout <= 0 {
+		return fmt.Errorf("--input-probe-timeout must be positive")
+	}
 
-		fmt.Println("System Check Results:")
-		fmt.Println("====================")
+	if maxRuntime < 0 {
+		return fmt.Errorf("--max-runtime must be positive")
+	}
 
-		// Check FFmpeg
-		if err := t.CheckFFmpegAvailability(); err != nil {
-			fmt.Printf("FFmpeg: %v\n", err)
-		} else {
-			fmt.Println("FFmpeg: Available")
+	if batchSize < 0 || maxFilesPerDir < 0 {
+		return fmt.Errorf("--batch-size and --max-files-per-dir must not be negative")
+	}
+	if retries < 0 {
+		return fmt.Errorf("--retries must not be negative")
+	}
+	if batchSize > 0 && interactive && !assumeYes {
+		return fmt.Errorf("--interactive reviews the whole file list and cannot be combined with --batch-size")
+	}
+	if batchSize > 0 && dvdMode {
+		return fmt.Errorf("--dvd groups files into titles and cannot be combined with --batch-size")
+	}
+	if onlyNew && overwrite {
+		return fmt.Errorf("--only-new skips files that have outputs and cannot be combined with --overwrite")
+	}
+	if !resume && (resumeFile != "" || retryFailed) {
+		return fmt.Errorf("--resume-file and --retry-failed require --resume")
+	}
+	var resumeState string
+	if resume {
+		resumeState = resumeFile
+		if resumeState == "" {
+			resumeState = filepath.Join(outputDir, transcoder.ResumeStateName)
 		}
+	}
 
-		// Check hardware acceleration
-		if err := t.CheckGPUAvailability(); err != nil {
-			fmt.Printf("Hardware Acceleration: %v\n", err)
-		} else {
-			// Determine platform and show appropriate message
-			systemChecker := transcoder.NewSystemChecker(&transcoder.RealCommandExecutor{})
-			platform := systemChecker.GetPlatform()
+	if trimDuration != "" && trimEnd != "" {
+		return fmt.Errorf("--duration and --end cannot be combined")
+	}
+	var trimFrom, trimLength, trimTo time.Duration
+	for _, trim := range []struct {
+		flag, value string
+		target      *time.Duration
+	}{{"--start", trimStart, &trimFrom}, {"--duration", trimDuration, &trimLength}, {"--end", trimEnd, &trimTo}} {
+		if trim.value == "" {
+			continue
+		}
+		parsed, err := transcoder.ParseTimestamp(trim.value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", trim.flag, err)
+		}
+		*trim.target = parsed
+	}
+	if trimDuration != "" && trimLength <= 0 {
+		return fmt.Errorf("--duration must be positive")
+	}
+	if trimEnd != "" && trimTo <= trimFrom {
+		return fmt.Errorf("--start must be before --end")
+	}
 
-			switch platform {
-			case transcoder.PlatformAppleSilicon:
-				fmt.Println("Hardware Acceleration: Apple Silicon VideoToolbox detected")
-			case transcoder.PlatformNVIDIA:
-				fmt.Println("Hardware Acceleration: NVIDIA GPU with CUDA support detected")
-			default:
-				fmt.Println("Hardware Acceleration: Available")
-			}
+	var sizeFloor int64
+	if minSize != "" {
+		parsed, err := transcoder.ParseSize(minSize)
+		if err != nil {
+			return fmt.Errorf("--min-size: %v", err)
+		}
+		sizeFloor = parsed
+	}
+	var durationFloor, durationCeiling time.Duration
+	for _, limit := range []struct {
+		flag, value string
+		target      *time.Duration
+	}{{"--min-duration", minDuration, &durationFloor}, {"--max-duration", maxDuration, &durationCeiling}} {
+		if limit.value == "" {
+			continue
+		}
+		parsed, err := transcoder.ParseTimestamp(limit.value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", limit.flag, err)
+		}
+		*limit.target = parsed
+	}
+	if durationCeiling > 0 && durationCeiling < durationFloor {
+		return fmt.Errorf("--min-duration must not exceed --max-duration")
+	}
+	if thumbnailAt != "" {
+		if _, err := transcoder.ParseTimestamp(thumbnailAt); err != nil {
+			return fmt.Errorf("--thumbnail-at: %v", err)
 		}
+	}
+	if err := transcoder.CheckContactSheet(contactSheet); err != nil {
+		return fmt.Errorf("--contact-sheet: %v", err)
+	}
 
-		// Check platform-appropriate encoders
-		systemChecker := transcoder.NewSystemChecker(&transcoder.RealCommandExecutor{})
-		platform := systemChecker.GetPlatform()
+	extraArgs, err := transcoder.SplitArgs(ffmpegArgs)
+	if err != nil {
+		return err
+	}
 
-		var encoders []string
-		switch platform {
-		case transcoder.PlatformAppleSilicon:
-			encoders = []string{"h264_videotoolbox", "hevc_videotoolbox", "libsvtav1"}
-		default:
-			encoders = []string{"h264_nvenc", "hevc_nvenc", "av1_nvenc"}
+	for _, codec := range softwareCodecs {
+		if !transcoder.IsSupportedCodec(codec) {
+			return fmt.Errorf("unknown codec '%s' in --software-codecs (use h264, hevc or av1)", codec)
 		}
+	}
 
-		for _, encoder := range encoders {
-			if available, err := t.CheckEncoderAvailability(encoder); err != nil {
-				fmt.Printf("%s: Error checking (%v)\n", encoder, err)
-			} else if available {
-				fmt.Printf("%s: Available\n", encoder)
-			} else {
-				fmt.Printf("%s: Not available\n", encoder)
-			}
-		}
+	// Create transcoder config
+	config := transcoder.Config{
+		InputPath:         inputs[0],
+		InputPaths:        inputs,
+		OutputDir:         outputDir,
+		Preset:            preset,
+		Presets:           presets,
+		Recursive:         recursive,
+		Overwrite:         overwrite,
+		SkipIfCodec:       skipIfCodec,
+		DeleteSource:      deleteSource,
+		Verbose:           verbose,
+		LogLevel:          level,
+		LogFile:           logFile,
+		DryRun:            dryRun,
+		GPUIndex:          gpuIndex,
+		NoGPU:             noGPU,
+		AudioCodec:        audioCodec,
+		AudioBitrate:      audioBitrate,
+		NormalizeAudio:    normalizeAudio,
+		NormalizeTwoPass:  normalize2Pass,
+		AudioTrack:        audioTrack,
+		NoToolMetadata:    noToolMetadata,
+		StripMetadata:     stripMetadata,
+		ToolVersion:       toolVersion,
+		Sidecar:           sidecar,
+		Thumbnail:         thumbnail,
+		ThumbnailAt:       thumbnailAt,
+		ContactSheet:      contactSheet,
+		NoProbe:           noProbe,
+		TempDir:           tempDir,
+		StageDir:          stageDir,
+		Tune:              tune,
+		QualityTarget:     qualityTarget,
+		Policy:            policy,
+		SoftwareCodecs:    softwareCodecs,
+		OutputMode:        mode,
+		OutputGroup:       outputGroup,
+		DVD:               dvdMode,
+		MaxrateFactor:     maxrateFactor,
+		BufsizeFactor:     bufsizeFactor,
+		SubtitleFiles:     subFiles,
+		SubtitleLanguage:  subLang,
+		NoAutoSubtitles:   noAutoSubs,
+		Subtitles:         subtitleMode,
+		Tonemap:           tonemapMode,
+		TonemapAlgorithm:  tonemapAlgo,
+		Deinterlace:       deinterlace,
+		DeinterlaceFilter: deintFilter,
+		Crop:              cropValue,
+		AutoOrient:        autoOrient,
+		FixAspect:         fixAspect,
+		NoUpscale:         noUpscale,
+		MaxRuntime:        maxRuntime,
+		MaxFilesPerDir:    maxFilesPerDir,
+		FrameRate:         frameRate,
+		Manifest:          manifest,
+		JSONOutput:        jsonOutput,
+		ManifestAlgorithm: manifestAlgo,
+		CRF:               crfOverride,
+		EncoderSpeed:      encoderSpeed,
+		MaxBitrate:        bitrateCap,
+		Repair:            repair,
+		AdaptiveBitrate:   adaptive,
+		AdaptiveMin:       adaptiveLow,
+		AdaptiveMax:       adaptiveHigh,
+		SmartBitrate:      smartBitrate,
+		TwoPass:           twoPass,
+		FFmpegArgs:        extraArgs,
+		MeasureQuality:    measureVMAF,
+		MeasureSSIM:       measureSSIM,
+		Retries:           retries,
+		TrimStart:         trimFrom,
+		TrimDuration:      trimLength,
+		TrimEnd:           trimTo,
+		MinSize:           sizeFloor,
+		MinDuration:       durationFloor,
+		MaxDuration:       durationCeiling,
+		Suffix:            suffix,
+		NameTemplate:      nameTemplate,
+		ForceExtension:    outputExtension,
+		Container:         container,
+		GroupByCodec:      groupByCodec,
+		RatioStyle:        ratioStyle,
+		Parallelism:       jobs,
+		NoProgress:        noProgress,
+		OnlyNew:           onlyNew,
+		Energy:            energy,
+		EnergyWatts:       energyWatts,
+		ProbeTimeout:      probeTimeout,
+		ProbeCache:        probeCache,
+		ResumeFile:        resumeState,
+		RetryFailed:       retryFailed,
+		Codec:             codecFlag,
+		Resolution:        resolution,
+		FollowSymlinks:    followSymlinks,
+		NVENCLookahead:    lookaheadOverride,
+		NVENCBFrames:      bframesOverride,
+		NVENCAQ:           aqMode,
+	}
 
-		return nil
-	},
-}
+	// Initialize transcoder
+	t := transcoder.New(config)
+	defer t.Cleanup()
 
-var presetsCmd = &cobra.Command{
-	Use:   "presets",
-	Short: "List available encoding presets",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		presets := transcoder.GetAvailablePresets()
+	// Partial encodes in the temp and staging areas must not outlive an interrupt
+	defer transcoder.OnInterrupt(t.Cleanup)()
 
-		fmt.Println("Available Presets:")
-		fmt.Println("==================")
+	// The first Ctrl-C stops the encode in flight and removes its partial
+	// output; a second one quits at once
+	ctx, stopShutdown := t.ShutdownContext(context.Background())
+	defer stopShutdown()
 
-		for _, preset := range presets {
-			fmt.Printf("  %s\n", preset)
+	if err := t.ValidateTempDir(); err != nil {
+		return err
+	}
+
+	if err := t.ValidateStageDir(); err != nil {
+		return err
+	}
+
+	if err := t.OpenProbeCache(); err != nil {
+		return err
+	}
+
+	if err := t.OpenResumeState(); err != nil {
+		return err
+	}
+
+	if err := t.ResolveOutputGroup(); err != nil {
+		return err
+	}
+
+	// A dry run or estimate leaves the filesystem untouched
+	if !dryRun && !estimate {
+		if err := t.OpenManifest(); err != nil {
+			return err
+		}
+		if err := t.OpenJSONOutput(); err != nil {
+			return err
+		}
+		if err := t.OpenCommandLog(); err != nil {
+			return err
 		}
 
-		fmt.Println("\nExample Usage:")
-		fmt.Println("  ffmcli -i input.mp4 -p 1080p_av1 -o output/")
+		// Create output directory if it doesn't exist
+		if err := t.PrepareOutputDir(); err != nil {
+			return fmt.Errorf("failed to create output directory: %v", err)
+		}
+	}
 
-		return nil
+	// Check GPU availability (skip if using software-only mode)
+	if !noGPU {
+		if err := t.CheckGPUAvailability(); err != nil {
+			t.Logger().Errorf("GPU check failed: %v", err)
+			t.Logger().Infof("Consider using --no-gpu flag for software encoding")
+			return err
+		}
+	}
+
+	// A synthesized preset must map to an encoder this ffmpeg provides
+	if codecFlag != "" || resolution != "" {
+		if err := t.ValidateCodec(); err != nil {
+			return err
+		}
+		t.Logger().Infof("Using preset %s", t.PresetName())
+	}
+
+	// Validate tune and speed against the encoder that will actually be used
+	if err := t.ValidateTune(); err != nil {
+		return err
+	}
+	if err := t.ValidateEncoderSpeed(); err != nil {
+		return err
+	}
+	if err := t.ValidateContainer(); err != nil {
+		return err
+	}
+	if subtitleMode == transcoder.SubtitleModeBurn {
+		if err := t.RequireFilter("subtitles", "--subtitles burn"); err != nil {
+			return err
+		}
+	}
+	if tonemapMode == transcoder.TonemapOn {
+		for _, filter := range []string{"zscale", "tonemap"} {
+			if err := t.RequireFilter(filter, "--tonemap on"); err != nil {
+				return err
+			}
+		}
+	}
+	if deinterlace != transcoder.DeinterlaceOff {
+		if err := t.RequireFilter(deintFilter, "--deinterlace"); err != nil {
+			return err
+		}
+	}
+	if cropValue == transcoder.CropAuto {
+		if err := t.RequireFilter("cropdetect", "--crop auto"); err != nil {
+			return err
+		}
+	}
+	if normalizeAudio || normalize2Pass {
+		if err := t.RequireFilter("loudnorm", "--normalize-audio"); err != nil {
+			return err
+		}
+	}
+	if thumbnail && thumbnailAt == "" {
+		if err := t.RequireFilter("thumbnail", "--thumbnail"); err != nil {
+			return err
+		}
+	}
+	if contactSheet > 0 {
+		if err := t.RequireFilter("tile", "--contact-sheet"); err != nil {
+			return err
+		}
+	}
+
+	// Huge libraries are discovered and processed a batch at a time
+	if batchSize > 0 {
+		return runBatches(ctx, t)
+	}
+
+	// Find files to process
+	files, err := t.FindVideoFiles()
+	if err != nil {
+		return fmt.Errorf("failed to find video files: %v", err)
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("no video files found")
+	}
+
+	// Leave out clips and samples outside --min-size/--min-duration/--max-duration
+	files = filterByThresholds(t, files)
+	if len(files) == 0 {
+		t.Logger().Infof("No files within the size and duration thresholds, nothing to do")
+		return nil
+	}
+
+	// Leave out files an earlier run finished according to --resume
+	files = t.FilterResumed(files)
+	if len(files) == 0 {
+		t.Logger().Infof("Every file was finished by an earlier run, nothing to do")
+		return nil
+	}
+
+	// Leave out sources already converted somewhere in the library
+	files, err = t.FilterNew(files)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		t.Logger().Infof("Every file already has an output in the library, nothing to do")
+		return nil
+	}
+
+	// Narrow down to files violating the policy, if one was given
+	files, err = t.FilterByPolicy(files)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		t.Logger().Infof("No files matched the policy, nothing to do")
+		return nil
+	}
+
+	t.Logger().Infof("Found %d video file(s) to process", len(files))
+
+	// Refuse a --name-template that would give two encodes one output
+	if err := t.CheckOutputNames(files); err != nil {
+		return err
+	}
+
+	if dryRun {
+		history, err := transcoder.LoadHistory(historyFiles...)
+		if err != nil {
+			return err
+		}
+		t.PrintDryRun(os.Stdout, files)
+		transcoder.PrintEstimate(os.Stdout, t.EstimateRun(files, history))
+		return nil
+	}
+	if estimate {
+		history, err := transcoder.LoadHistory(historyFiles...)
+		if err != nil {
+			return err
+		}
+		transcoder.PrintEstimate(os.Stdout, t.EstimateFromSamples(ctx, files, history, estimateSample))
+		return nil
+	}
+
+	if interactive && !assumeYes {
+		files, err = t.ReviewFiles(files, os.Stdin, os.Stdout)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			t.Logger().Infof("No files approved, nothing to do")
+			return nil
+		}
+	}
+
+	csvWriter, closeCSV, err := openCSVOutput()
+	if err != nil {
+		return err
+	}
+	defer closeCSV()
+
+	defer startKeyControls(t)()
+
+	// Process files with progress tracking
+	_, err = t.ProcessFilesWithProgress(ctx, files, csvWriter)
+	return err
+}
+
+// runBatches runs the transcode with streaming discovery: files are filtered,
+// planned or encoded one --batch-size batch at a time, each with its own
+// progress and summary
+func runBatches(ctx context.Context, t *transcoder.Transcoder) error {
+	var history map[string]*transcoder.PresetHistory
+	var csvWriter *csv.Writer
+	if dryRun || estimate {
+		var err error
+		if history, err = transcoder.LoadHistory(historyFiles...); err != nil {
+			return err
+		}
+	} else {
+		var closeCSV func()
+		var err error
+		if csvWriter, closeCSV, err = openCSVOutput(); err != nil {
+			return err
+		}
+		defer closeCSV()
+		defer startKeyControls(t)()
+	}
+
+	found := 0
+	err := t.StreamBatches(ctx, batchSize, func(batch []string, index int) error {
+		found += len(batch)
+		files, err := t.FilterNew(t.FilterResumed(filterByThresholds(t, batch)))
+		if err != nil {
+			return err
+		}
+		if files, err = t.FilterByPolicy(files); err != nil {
+			return err
+		}
+		t.Logger().Infof("\nBatch %d: %d video file(s) to process (%d found so far)", index, len(files), found)
+		if len(files) == 0 {
+			return nil
+		}
+		if err := t.CheckOutputNames(files); err != nil {
+			return err
+		}
+		if dryRun {
+			t.PrintDryRun(os.Stdout, files)
+			transcoder.PrintEstimate(os.Stdout, t.EstimateRun(files, history))
+			return nil
+		}
+		if estimate {
+			transcoder.PrintEstimate(os.Stdout, t.EstimateFromSamples(ctx, files, history, estimateSample))
+			return nil
+		}
+		_, err = t.ProcessFilesWithProgress(ctx, files, csvWriter)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if found == 0 {
+		return fmt.Errorf("no video files found")
+	}
+	return nil
+}
+
+// filterByThresholds applies the size and duration thresholds and reports how
+// many files they left out
+func filterByThresholds(t *transcoder.Transcoder, files []string) []string {
+	kept, excluded := t.FilterByThresholds(files)
+	if excluded > 0 {
+		t.Logger().Infof("Skipped %d file(s) outside the size and duration thresholds", excluded)
+	}
+	return kept
+}
+
+// openCSVOutput creates the --csv-output analytics file with its header. The
+// writer is nil when no file was requested; the returned function closes it.
+func openCSVOutput() (*csv.Writer, func(), error) {
+	if csvOutput == "" {
+		return nil, func() {}, nil
+	}
+	csvFile, err := os.Create(csvOutput)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CSV file: %v", err)
+	}
+	csvWriter := csv.NewWriter(csvFile)
+	closeCSV := func() {
+		csvWriter.Flush()
+		csvFile.Close()
+	}
+
+	// Write CSV header
+	if err := transcoder.WriteCSVHeader(csvWriter); err != nil {
+		closeCSV()
+		return nil, nil, fmt.Errorf("failed to write CSV header: %v", err)
+	}
+	return csvWriter, closeCSV, nil
+}
+
+// forcedExtension checks --force-extension and returns the extension outputs
+// are named with, or "" when it is the container's own
+func forcedExtension(value, container string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	ext, err := transcoder.NormalizeExtension(value)
+	if err != nil {
+		return "", fmt.Errorf("--force-extension: %v", err)
+	}
+	if ext == transcoder.ContainerExtension(container) {
+		return "", nil
+	}
+	return ext, nil
+}
+
+// resolveLogLevel combines --log-level with its --quiet and --verbose
+// shorthands into one --log-level value
+func resolveLogLevel(cmd *cobra.Command) (string, error) {
+	changed := cmd.Flags().Changed("log-level")
+	switch {
+	case quiet && verbose:
+		return "", fmt.Errorf("--quiet and --verbose cannot be combined")
+	case quiet && changed, verbose && changed && logLevel != "debug":
+		return "", fmt.Errorf("--log-level cannot be combined with --quiet or --verbose")
+	case quiet:
+		return "warn", nil
+	case verbose:
+		return "debug", nil
+	}
+	if _, err := transcoder.ParseLogLevel(logLevel); err != nil {
+		return "", fmt.Errorf("--log-level must be one of %s", strings.Join(transcoder.LogLevels, ", "))
+	}
+	return logLevel, nil
+}
+
+// startKeyControls enables keyboard controls when someone is at the terminal
+// and returns the function restoring the terminal
+func startKeyControls(t *transcoder.Transcoder) func() {
+	if noKeys || dryRun || estimate || !transcoder.IsTerminal(os.Stdin) {
+		return func() {}
+	}
+	control, restore, err := transcoder.StartKeyControls(os.Stdin, os.Stdout)
+	if err != nil {
+		t.Logger().Debugf("Keyboard controls unavailable: %v", err)
+		return func() {}
+	}
+	t.SetRunControl(control)
+	return restore
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check system requirements and hardware acceleration availability",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := transcoder.Config{SkipValidation: true}
+		t := transcoder.New(config)
+
+		fmt.Println("System Check Results:")
+		fmt.Println("====================")
+
+		// Check FFmpeg
+		if err := t.CheckFFmpegAvailability(); err != nil {
+			fmt.Printf("FFmpeg: %v\n", err)
+		} else {
+			fmt.Println("FFmpeg: Available")
+		}
+
+		if err := t.CheckFFplayAvailability(); err != nil {
+			fmt.Println("FFplay: Not found (preview writes samples without playing them)")
+		} else {
+			fmt.Println("FFplay: Available")
+		}
+
+		// Check hardware acceleration
+		if err := t.CheckGPUAvailability(); err != nil {
+			fmt.Printf("Hardware Acceleration: %v\n", err)
+		} else {
+			// Determine platform and show appropriate message
+			systemChecker := transcoder.NewSystemChecker(&transcoder.RealCommandExecutor{})
+			platform := systemChecker.GetPlatform()
+
+			switch platform {
+			case transcoder.PlatformAppleSilicon:
+				fmt.Println("Hardware Acceleration: Apple Silicon VideoToolbox detected")
+			case transcoder.PlatformNVIDIA:
+				fmt.Println("Hardware Acceleration: NVIDIA GPU with CUDA support detected")
+			case transcoder.PlatformIntelQSV:
+				fmt.Println("Hardware Acceleration: Intel GPU with Quick Sync detected")
+			case transcoder.PlatformAMD:
+				fmt.Println("Hardware Acceleration: AMD GPU with VAAPI/AMF detected")
+			default:
+				fmt.Println("Hardware Acceleration: Available")
+			}
+		}
+
+		// Check platform-appropriate encoders
+		systemChecker := transcoder.NewSystemChecker(&transcoder.RealCommandExecutor{})
+		platform := systemChecker.GetPlatform()
+
+		var encoders []string
+		switch platform {
+		case transcoder.PlatformAppleSilicon:
+			encoders = []string{"h264_videotoolbox", "hevc_videotoolbox", "libsvtav1"}
+		case transcoder.PlatformIntelQSV:
+			encoders = []string{"h264_qsv", "hevc_qsv", "av1_qsv"}
+		case transcoder.PlatformAMD:
+			if runtime.GOOS == "windows" {
+				encoders = []string{"h264_amf", "hevc_amf", "av1_amf"}
+			} else {
+				encoders = []string{"h264_vaapi", "hevc_vaapi", "av1_vaapi"}
+			}
+		default:
+			encoders = []string{"h264_nvenc", "hevc_nvenc", "av1_nvenc"}
+		}
+
+		for _, encoder := range encoders {
+			if available, err := t.CheckEncoderAvailability(encoder); err != nil {
+				fmt.Printf("%s: Error checking (%v)\n", encoder, err)
+			} else if available {
+				fmt.Printf("%s: Available\n", encoder)
+			} else {
+				fmt.Printf("%s: Not available\n", encoder)
+			}
+		}
+
+		// Check optional filters used by filter-dependent features
+		for _, filter := range []string{"scale_cuda", "zscale", "tonemap", "bwdif", "cropdetect", "loudnorm", "libvmaf"} {
+			if available, err := t.CheckFilterAvailability(filter); err != nil {
+				fmt.Printf("%s filter: Error checking (%v)\n", filter, err)
+			} else if available {
+				fmt.Printf("%s filter: Available\n", filter)
+			} else {
+				fmt.Printf("%s filter: Not available\n", filter)
+			}
+		}
+
+		return nil
+	},
+}
+
+var presetsCmd = &cobra.Command{
+	Use:   "presets",
+	Short: "List available encoding presets",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		presets := transcoder.GetAvailablePresets()
+
+		fmt.Println("Available Presets:")
+		fmt.Println("==================")
+
+		all := transcoder.GetPresets()
+		for _, preset := range presets {
+			line := "  " + preset
+			if crf, ok := transcoder.PresetCRF(all[preset]); ok {
+				line = fmt.Sprintf("  %-16s quality ~ CRF %d", preset, crf)
+			}
+			if transcoder.IsUserPreset(preset) {
+				line = fmt.Sprintf("%-40s (from %s)", line, filepath.Base(presetsFile))
+			}
+			fmt.Println(line)
+		}
+
+		fmt.Println("\nExample Usage:")
+		fmt.Println("  ffmcli -i input.mp4 -p 1080p_av1 -o output/")
+		fmt.Println("  ffmcli presets show 1080p_av1")
+
+		return nil
+	},
+}
+
+var presetsShowCmd = &cobra.Command{
+	Use:   "show NAME",
+	Short: "Show a preset in full, with the ffmpeg commands it produces",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t := transcoder.New(transcoder.Config{Preset: args[0], NoProbe: true, SkipValidation: true})
+		detail, err := t.DescribePreset(args[0])
+		if err != nil {
+			return err
+		}
+
+		orNone := func(value string) string {
+			if value == "" {
+				return "(none)"
+			}
+			return value
+		}
+		preset := detail.Preset
+		fmt.Printf("Preset:      %s\n", preset.Name)
+		fmt.Printf("Description: %s\n", orNone(preset.Description))
+		fmt.Printf("Resolution:  %s\n", orNone(preset.Resolution))
+		fmt.Printf("Codec:       %s\n", orNone(preset.Codec))
+		fmt.Printf("Encoder:     %s\n", orNone(preset.Encoder))
+		fmt.Printf("Bitrate:     %s\n", orNone(preset.Bitrate))
+		if crf, ok := transcoder.PresetCRF(preset); ok {
+			fmt.Printf("Quality:     ~ CRF %d\n", crf)
+		}
+		fmt.Printf("Platform:    %s (this machine: %s)\n", orNone(detail.PresetPlatform), detail.DetectedPlatform)
+		if preset.Tune != "" {
+			fmt.Printf("Tune:        %s\n", preset.Tune)
+		}
+		if preset.TwoPass {
+			fmt.Println("Two-pass:    yes")
+		}
+		if detail.FromPresetsFile {
+			fmt.Printf("Defined in:  %s\n", presetsFile)
+		}
+
+		if detail.Hardware {
+			fmt.Println("\nFFmpeg command:")
+		} else {
+			fmt.Println("\nFFmpeg command (software; the preset's encoder does not run on this machine):")
+		}
+		fmt.Printf("  %s\n", transcoder.FormatCommand("ffmpeg", detail.Args))
+		if detail.Hardware {
+			fmt.Printf("\nSoftware fallback (%s):\n", detail.FallbackEncoder)
+			fmt.Printf("  %s\n", transcoder.FormatCommand("ffmpeg", detail.FallbackArgs))
+		}
+		fmt.Println("\nPer-file options such as tone mapping, subtitles and trimming are added when encoding.")
+
+		return nil
+	},
+}
+
+var (
+	suggestRecursive bool
+	suggestSample    int
+)
+
+var suggestCmd = &cobra.Command{
+	Use:   "suggest PATH",
+	Short: "Probe a file or directory and recommend a preset",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("input file or directory does not exist: %s", path)
+		}
+
+		config := transcoder.Config{InputPath: path, Recursive: suggestRecursive, SkipValidation: true}
+		t := transcoder.New(config)
+
+		files, err := t.FindVideoFiles()
+		if err != nil {
+			return fmt.Errorf("failed to find video files: %v", err)
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no video files found")
+		}
+
+		suggestion, _, err := t.SuggestForFiles(files, suggestSample)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			fmt.Printf("Sampled %d of %d video file(s) in %s\n", min(len(files), suggestSample), len(files), path)
+		} else {
+			fmt.Printf("Source: %s\n", path)
+		}
+		fmt.Printf("Suggested preset: %s\n", suggestion.Preset)
+		for _, reason := range suggestion.Reasons {
+			fmt.Printf("  - %s\n", reason)
+		}
+		if suggestion.EstimatedSize > 0 {
+			fmt.Printf("Estimated size: %s -> %s (~%.0f%% savings)\n",
+				transcoder.FormatBytes(suggestion.SourceSize), transcoder.FormatBytes(suggestion.EstimatedSize), suggestion.EstimatedSaved*100)
+		}
+
+		runArgs := []string{"-i", path, "-p", suggestion.Preset, "-o", "output/"}
+		if info.IsDir() && suggestRecursive {
+			runArgs = append(runArgs, "-r")
+		}
+		fmt.Println("\nRun:")
+		fmt.Printf("  %s\n", transcoder.FormatCommand("ffmcli", runArgs))
+
+		return nil
+	},
+}
+
+var (
+	reportPreset   string
+	reportSidecars bool
+	reportTemplate string
+)
+
+var reportExistingCmd = &cobra.Command{
+	Use:   "report-existing",
+	Short: "Recompute conversion statistics from existing outputs without re-encoding",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := os.Stat(inputFile); err != nil {
+			return fmt.Errorf("input file or directory does not exist: %s", inputFile)
+		}
+		if _, err := os.Stat(outputDir); err != nil {
+			return fmt.Errorf("output directory does not exist: %s", outputDir)
+		}
+		if !transcoder.IsRatioStyle(ratioStyle) {
+			return fmt.Errorf("--ratio-style must be one of %s", strings.Join(transcoder.RatioStyles, ", "))
+		}
+		if reportPreset != "" && !transcoder.IsValidPreset(reportPreset) {
+			availablePresets := strings.Join(transcoder.GetAvailablePresets(), ", ")
+			return fmt.Errorf("invalid preset '%s'. Available presets: %s", reportPreset, availablePresets)
+		}
+		if err := transcoder.ValidateNameTemplate(reportTemplate); err != nil {
+			return fmt.Errorf("--name-template: %v", err)
+		}
+
+		config := transcoder.Config{
+			InputPath:      inputFile,
+			OutputDir:      outputDir,
+			Preset:         reportPreset,
+			Recursive:      recursive,
+			NameTemplate:   reportTemplate,
+			SkipValidation: true,
+		}
+		t := transcoder.New(config)
+
+		report, err := t.ReportExisting(reportSidecars)
+		if err != nil {
+			return err
+		}
+
+		if csvOutput != "" {
+			csvFile, err := os.Create(csvOutput)
+			if err != nil {
+				return fmt.Errorf("failed to create CSV file: %v", err)
+			}
+			defer csvFile.Close()
+			csvWriter := csv.NewWriter(csvFile)
+			if err := report.WriteCSV(csvWriter); err != nil {
+				return fmt.Errorf("failed to write CSV file: %v", err)
+			}
+		}
+
+		before, after := report.TotalBeforeMB(), report.TotalAfterMB()
+		fmt.Printf("Paired outputs: %d\n", len(report.Records))
+		fmt.Printf("Total size: %.2f MB -> %.2f MB\n", before, after)
+		if before > 0 {
+			fmt.Printf("Size change: %s\n", transcoder.FormatSizeChange(int64(before*(1<<20)), int64(after*(1<<20)), ratioStyle))
+		}
+
+		if len(report.MissingOutputs) > 0 {
+			fmt.Printf("\nSources without an output (%d):\n", len(report.MissingOutputs))
+			for _, path := range report.MissingOutputs {
+				fmt.Printf("  - %s\n", path)
+			}
+		}
+		if len(report.OrphanOutputs) > 0 {
+			fmt.Printf("\nOutputs without a source (%d):\n", len(report.OrphanOutputs))
+			for _, path := range report.OrphanOutputs {
+				fmt.Printf("  - %s\n", path)
+			}
+		}
+
+		return nil
+	},
+}
+
+var (
+	previewDir    string
+	previewStart  time.Duration
+	previewLength time.Duration
+	previewNoPlay bool
+	debugOverlay  bool
+)
+
+var previewCmd = &cobra.Command{
+	Use:   "preview FILE",
+	Short: "Encode a short sample with a preset and play it with ffplay (tuning aid)",
+	Long: `Encode a few seconds of FILE with the chosen preset and play the result with
+ffplay, to compare presets and tunings quickly. When ffplay is not installed
+the sample is only written and its path printed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		input := args[0]
+		if info, err := os.Stat(input); err != nil || info.IsDir() {
+			return fmt.Errorf("input file does not exist: %s", input)
+		}
+		if !transcoder.IsValidPreset(preset) {
+			availablePresets := strings.Join(transcoder.GetAvailablePresets(), ", ")
+			return fmt.Errorf("invalid preset '%s'. Available presets: %s", preset, availablePresets)
+		}
+		if previewLength <= 0 || previewStart < 0 {
+			return fmt.Errorf("--length must be positive and --start must not be negative")
+		}
+
+		dir := previewDir
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		config := transcoder.Config{
+			InputPath:      input,
+			OutputDir:      dir,
+			Preset:         preset,
+			NoGPU:          noGPU,
+			Tune:           tune,
+			Verbose:        verbose,
+			DebugOverlay:   debugOverlay,
+			NoToolMetadata: true,
+			SkipValidation: true,
+		}
+		t := transcoder.New(config)
+
+		if err := t.CheckFFmpegAvailability(); err != nil {
+			return err
+		}
+		if err := t.ValidateTune(); err != nil {
+			return err
+		}
+		if debugOverlay {
+			if err := t.RequireFilter("drawtext", "--debug-overlay"); err != nil {
+				return err
+			}
+		}
+
+		samplePath, err := t.PreviewPath(input, dir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Encoding %s sample of %s with %s...\n", previewLength, filepath.Base(input), preset)
+		if err := t.EncodeSample(input, samplePath, previewStart, previewLength); err != nil {
+			return err
+		}
+		fmt.Printf("Sample written to %s\n", samplePath)
+
+		if previewNoPlay {
+			return nil
+		}
+		if err := t.CheckFFplayAvailability(); err != nil {
+			fmt.Println("ffplay not found; open the sample in any player to review it")
+			return nil
+		}
+		return transcoder.PlayFile(samplePath)
+	},
+}
+
+var (
+	ladderCRFs   []int
+	ladderMetric string
+)
+
+var qualityLadderCmd = &cobra.Command{
+	Use:   "quality-ladder FILE",
+	Short: "Encode the same sample at several CRF values to compare size and quality",
+	Long: `Encode the same short window of FILE once per CRF value and print the size
+of each sample, optionally with a VMAF or SSIM score against the source, to
+pick a --crf value. The samples are kept for viewing side by side.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		input := args[0]
+		if info, err := os.Stat(input); err != nil || info.IsDir() {
+			return fmt.Errorf("input file does not exist: %s", input)
+		}
+		if !transcoder.IsValidPreset(preset) {
+			availablePresets := strings.Join(transcoder.GetAvailablePresets(), ", ")
+			return fmt.Errorf("invalid preset '%s'. Available presets: %s", preset, availablePresets)
+		}
+		if previewLength <= 0 || previewStart < 0 {
+			return fmt.Errorf("--length must be positive and --start must not be negative")
+		}
+		if len(ladderCRFs) == 0 {
+			return fmt.Errorf("--crf needs at least one value")
+		}
+		for _, value := range ladderCRFs {
+			if value < 0 || value > transcoder.MaxCRF {
+				return fmt.Errorf("--crf %d is out of range (0-%d)", value, transcoder.MaxCRF)
+			}
+		}
+		switch ladderMetric {
+		case transcoder.LadderMetricNone, transcoder.LadderMetricVMAF, transcoder.LadderMetricSSIM:
+		default:
+			return fmt.Errorf("invalid --metric '%s' (use vmaf or ssim)", ladderMetric)
+		}
+		if debugOverlay && ladderMetric != transcoder.LadderMetricNone {
+			return fmt.Errorf("--debug-overlay changes the picture and would skew --metric scores")
+		}
+
+		dir := previewDir
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		config := transcoder.Config{
+			InputPath:      input,
+			OutputDir:      dir,
+			Preset:         preset,
+			NoGPU:          noGPU,
+			Tune:           tune,
+			Verbose:        verbose,
+			DebugOverlay:   debugOverlay,
+			NoToolMetadata: true,
+			SkipValidation: true,
+		}
+		t := transcoder.New(config)
+
+		if err := t.CheckFFmpegAvailability(); err != nil {
+			return err
+		}
+		if err := t.ValidateTune(); err != nil {
+			return err
+		}
+		if debugOverlay {
+			if err := t.RequireFilter("drawtext", "--debug-overlay"); err != nil {
+				return err
+			}
+		}
+
+		rungs, err := t.QualityLadder(input, dir, ladderCRFs, previewStart, previewLength, ladderMetric)
+		if len(rungs) > 0 {
+			fmt.Printf("\nQuality ladder for %s (%s, %s sample):\n", filepath.Base(input), preset, previewLength)
+			transcoder.WriteLadderTable(os.Stdout, rungs, ladderMetric)
+			fmt.Printf("Samples written to %s\n", dir)
+		}
+		return err
+	},
+}
+
+var (
+	cleanupPreset   string
+	cleanupTemplate string
+	cleanupMinRatio float64
+	cleanupDelete   bool
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Find and remove partial or broken outputs left by interrupted runs",
+	Long: `Scan an output directory for files that are zero bytes, cannot be probed,
+have no video stream, or are much shorter than their source (known from the
+output's sidecar or by pairing with --input). Candidates are only listed
+unless --delete is given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if info, err := os.Stat(outputDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("output directory does not exist: %s", outputDir)
+		}
+		if inputFile != "" {
+			if _, err := os.Stat(inputFile); err != nil {
+				return fmt.Errorf("input file or directory does not exist: %s", inputFile)
+			}
+		}
+		if cleanupPreset != "" && !transcoder.IsValidPreset(cleanupPreset) {
+			availablePresets := strings.Join(transcoder.GetAvailablePresets(), ", ")
+			return fmt.Errorf("invalid preset '%s'. Available presets: %s", cleanupPreset, availablePresets)
+		}
+		if err := transcoder.ValidateNameTemplate(cleanupTemplate); err != nil {
+			return fmt.Errorf("--name-template: %v", err)
+		}
+		if cleanupMinRatio <= 0 || cleanupMinRatio > 1 {
+			return fmt.Errorf("--min-duration-ratio must be between 0 and 1")
+		}
+		if cleanupDelete && !assumeYes && !transcoder.IsTerminal(os.Stdin) {
+			return fmt.Errorf("--delete requires a terminal to confirm; use --yes to delete without asking")
+		}
+
+		config := transcoder.Config{
+			InputPath:      inputFile,
+			OutputDir:      outputDir,
+			Preset:         cleanupPreset,
+			Recursive:      recursive,
+			NameTemplate:   cleanupTemplate,
+			SkipValidation: true,
+		}
+		t := transcoder.New(config)
+
+		candidates, err := t.FindCleanupCandidates(cleanupMinRatio)
+		if err != nil {
+			return err
+		}
+		if len(candidates) == 0 {
+			fmt.Println("No partial or broken outputs found")
+			return nil
+		}
+
+		var total int64
+		fmt.Printf("Found %d partial or broken output(s):\n", len(candidates))
+		for _, candidate := range candidates {
+			fmt.Printf("  %s (%s): %s\n", candidate.Path, transcoder.FormatBytes(candidate.Size), candidate.Reason)
+			total += candidate.Size
+		}
+
+		if !cleanupDelete {
+			fmt.Printf("Dry run: nothing deleted. Rerun with --delete to remove these files (%s)\n", transcoder.FormatBytes(total))
+			return nil
+		}
+		if !assumeYes {
+			confirmed, err := transcoder.ConfirmCleanup(os.Stdin, os.Stdout, len(candidates))
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				fmt.Println("Nothing deleted")
+				return nil
+			}
+		}
+
+		deleted, errs := transcoder.DeleteCleanupCandidates(candidates)
+		fmt.Printf("Deleted %d file(s)\n", deleted)
+		if len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Printf("  - %v\n", err)
+			}
+			return fmt.Errorf("cleanup completed with %d error(s)", len(errs))
+		}
+		return nil
+	},
+}
+
+var (
+	verifyCSV       string
+	verifySuffix    string
+	verifyTemplate  string
+	verifyContainer string
+	verifyExtension string
+	verifyMinRatio  float64
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that outputs of a finished run are complete before trusting them",
+	Long: `Probe every output under an output directory, or only those an analytics
+CSV lists as converted, without changing anything. An output fails when it is
+empty, cannot be probed, has no video stream, is much shorter than its source
+(known from its sidecar or by pairing with --input) or does not have its
+preset's codec. Outputs listed in the CSV but missing also fail. The command
+exits with an error if any output fails.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if info, err := os.Stat(outputDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("output directory does not exist: %s", outputDir)
+		}
+		if inputFile != "" {
+			if _, err := os.Stat(inputFile); err != nil {
+				return fmt.Errorf("input file or directory does not exist: %s", inputFile)
+			}
+		}
+		if verifyMinRatio <= 0 || verifyMinRatio > 1 {
+			return fmt.Errorf("--min-duration-ratio must be between 0 and 1")
+		}
+		if err := transcoder.ValidateNameTemplate(verifyTemplate); err != nil {
+			return fmt.Errorf("--name-template: %v", err)
+		}
+		if !transcoder.IsContainer(verifyContainer) {
+			return fmt.Errorf("--container must be one of %s", strings.Join(transcoder.Containers, ", "))
+		}
+		extension, err := forcedExtension(verifyExtension, verifyContainer)
+		if err != nil {
+			return err
+		}
+
+		config := transcoder.Config{
+			InputPath:      inputFile,
+			OutputDir:      outputDir,
+			Recursive:      recursive,
+			Suffix:         verifySuffix,
+			NameTemplate:   verifyTemplate,
+			Container:      verifyContainer,
+			ForceExtension: extension,
+			SkipValidation: true,
+		}
+		t := transcoder.New(config)
+
+		results, err := t.VerifyOutputs(verifyCSV, verifyMinRatio)
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return fmt.Errorf("no outputs found to verify")
+		}
+
+		failed := 0
+		for _, result := range results {
+			if result.Passed() {
+				fmt.Printf("PASS  %s\n", result.Path)
+				continue
+			}
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", result.Path, strings.Join(result.Problems, "; "))
+		}
+		fmt.Printf("Verified %d output(s): %d passed, %d failed\n", len(results), len(results)-failed, failed)
+		if failed > 0 {
+			return fmt.Errorf("%d output(s) failed verification", failed)
+		}
+		return nil
+	},
+}
+
+var (
+	encodersJSON    bool
+	encodersNoSmoke bool
+)
+
+var encodersCmd = &cobra.Command{
+	Use:   "encoders",
+	Short: "List the video encoders ffmcli can use and whether they work here",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t := transcoder.New(transcoder.Config{SkipValidation: true})
+
+		statuses, err := t.EncoderStatuses(!encodersNoSmoke)
+		if err != nil {
+			return err
+		}
+
+		if encodersJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(statuses)
+		}
+
+		fmt.Printf("Detected platform: %s\n", t.GetPlatform())
+		group := ""
+		for _, status := range statuses {
+			if status.Platform != group {
+				group = status.Platform
+				fmt.Printf("\n%s:\n", group)
+			}
+			state := "Not available"
+			if status.Available {
+				state = "Available"
+				switch status.SmokeTest {
+				case transcoder.SmokeTestPassed:
+					state += ", test encode passed"
+				case transcoder.SmokeTestFailed:
+					state += ", test encode FAILED"
+				}
+			}
+			fmt.Printf("  %-6s %-18s %s\n", status.Codec, status.Encoder, state)
+			if status.Error != "" {
+				fmt.Printf("         %s\n", status.Error)
+			}
+		}
+		return nil
+	},
+}
+
+var stableDelay time.Duration
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Watch a directory and transcode new video files as they appear",
+	Long: `Watch an input directory and transcode each new video file once its size has
+stayed unchanged for --stable-delay, so files still being copied or recorded
+are not picked up half written. Video files already in the directory are
+processed first. Processed sources are listed in ` + transcoder.WatchRecordFile + ` in the
+output directory, so restarting the watch doesn't encode them again. Stop
+watching with Ctrl-C.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if info, err := os.Stat(inputFile); err != nil || !info.IsDir() {
+			return fmt.Errorf("input directory does not exist: %s", inputFile)
+		}
+		if !transcoder.IsValidPreset(preset) {
+			availablePresets := strings.Join(transcoder.GetAvailablePresets(), ", ")
+			return fmt.Errorf("invalid preset '%s'. Available presets: %s", preset, availablePresets)
+		}
+		if stableDelay <= 0 {
+			return fmt.Errorf("--stable-delay must be positive")
+		}
+		level, err := resolveLogLevel(cmd)
+		if err != nil {
+			return err
+		}
+		if !transcoder.IsContainer(container) {
+			return fmt.Errorf("--container must be one of %s", strings.Join(transcoder.Containers, ", "))
+		}
+		if err := transcoder.CheckAudioBitrate(audioCodec, audioBitrate, container, normalizeAudio || normalize2Pass); err != nil {
+			return fmt.Errorf("--audio-bitrate: %v", err)
+		}
+		if transcoder.NewPathUtils().IsSamePath(inputFile, outputDir) {
+			return fmt.Errorf("the output directory must differ from the watched directory")
+		}
+
+		config := transcoder.Config{
+			InputPath:        inputFile,
+			OutputDir:        outputDir,
+			Preset:           preset,
+			Recursive:        recursive,
+			Overwrite:        overwrite,
+			DeleteSource:     deleteSource,
+			Verbose:          verbose,
+			LogLevel:         level,
+			LogFile:          logFile,
+			GPUIndex:         gpuIndex,
+			NoGPU:            noGPU,
+			AudioCodec:       audioCodec,
+			AudioBitrate:     audioBitrate,
+			NormalizeAudio:   normalizeAudio,
+			NormalizeTwoPass: normalize2Pass,
+			Container:        container,
+			JSONOutput:       jsonOutput,
+			ToolVersion:      toolVersion,
+			NoProgress:       true,
+		}
+		t := transcoder.New(config)
+		defer t.Cleanup()
+		defer transcoder.OnInterrupt(t.Cleanup)()
+		ctx, stopShutdown := t.ShutdownContext(context.Background())
+		defer stopShutdown()
+
+		if err := t.ValidateTempDir(); err != nil {
+			return err
+		}
+		if err := t.ValidateContainer(); err != nil {
+			return err
+		}
+		if err := t.PrepareOutputDir(); err != nil {
+			return fmt.Errorf("failed to create output directory: %v", err)
+		}
+		if err := t.OpenJSONOutput(); err != nil {
+			return err
+		}
+		if err := t.OpenCommandLog(); err != nil {
+			return err
+		}
+		if !noGPU {
+			if err := t.CheckGPUAvailability(); err != nil {
+				t.Logger().Errorf("GPU check failed: %v", err)
+				t.Logger().Infof("Consider using --no-gpu flag for software encoding")
+				return err
+			}
+		}
+
+		csvWriter, closeCSV, err := openCSVOutput()
+		if err != nil {
+			return err
+		}
+		defer closeCSV()
+
+		t.Logger().Infof("Watching %s for new video files (Ctrl-C to stop)", inputFile)
+		return t.Watch(ctx, stableDelay, csvWriter)
 	},
 }
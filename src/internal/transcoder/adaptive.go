@@ -0,0 +1,171 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Adaptive bitrate defaults, relative to the preset's target bitrate
+const (
+	defaultAdaptiveMinFactor = 0.5
+	defaultAdaptiveMaxFactor = 1.5
+
+	// complexitySampleLength is how much of the source the probe encode covers
+	complexitySampleLength = 20 * time.Second
+
+	// complexityProbeCRF is the probe quality for presets without one
+	complexityProbeCRF = 23
+)
+
+// adaptiveBounds returns the range an adaptive bitrate is clamped to
+func (t *Transcoder) adaptiveBounds(presetBitrate float64) (float64, float64) {
+	lo, hi := t.config.AdaptiveMin, t.config.AdaptiveMax
+	if lo <= 0 {
+		lo = presetBitrate * defaultAdaptiveMinFactor
+	}
+	if hi <= 0 {
+		hi = presetBitrate * defaultAdaptiveMaxFactor
+	}
+	return lo, max(lo, hi)
+}
+
+// chooseAdaptiveBitrate measures how hard a file is to compress and returns
+// its target bitrate. A window from the middle of the source is encoded at
+// the preset's quality, capped at the upper bound, without audio; the bitrate
+// that needed is clamped to the adaptive bounds.
+func (t *Transcoder) chooseAdaptiveBitrate(ctx context.Context, inputPath string, preset Preset) (float64, error) {
+	hardware := t.useHardware(preset)
+	videoArgs := t.videoArgs(preset, hardware)
+	presetBitrate, err := parseSIValue(argValue(videoArgs, "-b:v"))
+	if err != nil || presetBitrate <= 0 {
+		return 0, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("preset %s has no target bitrate to adapt", preset.Name), nil)
+	}
+	lo, hi := t.adaptiveBounds(presetBitrate)
+
+	info, err := t.prober.Probe(t.mediaInput(inputPath))
+	if err != nil {
+		return 0, err
+	}
+	start, length := complexityWindow(time.Duration(t.trimmedDuration(info.Duration) * float64(time.Second)))
+	if length <= 0 {
+		return 0, NewTranscoderError(ErrorTypeInvalidFilePath,
+			"cannot measure complexity of "+inputPath+": unknown duration", nil)
+	}
+
+	dir, err := t.temp.CreateDir("complexity-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	samplePath := filepath.Join(dir, "sample.mkv")
+
+	// The probe is a capped constant-quality encode; put the configured rate
+	// control back afterwards
+	savedCRF, savedMax := t.config.CRF, t.config.MaxBitrate
+	defer func() { t.config.CRF, t.config.MaxBitrate = savedCRF, savedMax }()
+	crf, ok := PresetCRF(preset)
+	if !ok {
+		crf = complexityProbeCRF
+	}
+	t.config.CRF = &crf
+	if _, capped := CappedRateArgs(argValue(videoArgs, "-c:v"), hi, 0); capped {
+		t.config.MaxBitrate = hi
+	}
+
+	stderr, _, err := t.runSample(ctx, inputPath, hardware, func(hardware bool) []string {
+		return complexityArgs(t.buildFFmpegArgs(inputPath, samplePath, preset, hardware), start, length)
+	})
+	if err != nil {
+		return 0, NewTranscoderError(ErrorTypeEncodingFailed,
+			fmt.Sprintf("complexity probe failed for %s: %s", inputPath, strings.TrimSpace(stderr)), err)
+	}
+
+	sample, err := os.Stat(samplePath)
+	if err != nil {
+		return 0, NewTranscoderError(ErrorTypeFileSystemError, "cannot read complexity sample", err)
+	}
+	measured := float64(sample.Size()) * 8 / length.Seconds()
+	return min(max(measured, lo), hi), nil
+}
+
+// complexityWindow picks the probe window: up to complexitySampleLength from
+// the middle of the source
+func complexityWindow(duration time.Duration) (time.Duration, time.Duration) {
+	return sampleWindow(duration, complexitySampleLength)
+}
+
+// sampleWindow picks up to length from the middle of a source, where intros
+// and credits are least likely; all of it when it is shorter
+func sampleWindow(duration, length time.Duration) (time.Duration, time.Duration) {
+	if duration <= length {
+		return 0, duration
+	}
+	return (duration - length) / 2, length
+}
+
+// complexityArgs limits an encode to the probe window and drops audio and
+// subtitles, so the sample size reflects the video alone
+func complexityArgs(args []string, start, length time.Duration) []string {
+	args = sampleArgs(args, start, length)
+	result := make([]string, 0, len(args)+2)
+	result = append(result, args[:len(args)-2]...)
+	result = append(result, "-an", "-sn")
+	return append(result, args[len(args)-2:]...)
+}
+
+// setTargetBitrate records the target bitrate chosen for a file and preset,
+// which applyAdaptiveBitrate then puts in its arguments. Rates are kept per
+// preset, as a rate chosen against one preset's bitrate means nothing for
+// another's.
+func (t *Transcoder) setTargetBitrate(inputPath, preset string, rate float64) {
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	if t.adaptiveRates == nil {
+		t.adaptiveRates = make(map[string]map[string]float64)
+	}
+	if t.adaptiveRates[inputPath] == nil {
+		t.adaptiveRates[inputPath] = make(map[string]float64)
+	}
+	t.adaptiveRates[inputPath][preset] = rate
+}
+
+// clearTargetBitrate drops the target bitrate chosen for a file and preset,
+// so an encode whose probe fails falls back to the preset bitrate rather
+// than an earlier run's choice
+func (t *Transcoder) clearTargetBitrate(inputPath, preset string) {
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	delete(t.adaptiveRates[inputPath], preset)
+}
+
+// applyAdaptiveBitrate replaces the preset's target bitrate with the one
+// chosen for the file and preset. -maxrate and -bufsize are scaled by the
+// same ratio so the preset's rate control shape is kept.
+func (t *Transcoder) applyAdaptiveBitrate(inputPath string, preset Preset, args []string) []string {
+	t.stateMu.Lock()
+	rate, ok := t.adaptiveRates[inputPath][preset.Name]
+	t.stateMu.Unlock()
+	if !ok {
+		return args
+	}
+	bitrate, err := parseSIValue(argValue(args, "-b:v"))
+	if err != nil || bitrate <= 0 {
+		return args
+	}
+	ratio := rate / bitrate
+
+	// Never modify the preset's own slice
+	args = append([]string(nil), args...)
+	args = setArgValue(args, "-b:v", formatBitrateArg(rate))
+	for _, flag := range []string{"-maxrate", "-bufsize"} {
+		if value, err := parseSIValue(argValue(args, flag)); err == nil && value > 0 {
+			args = setArgValue(args, flag, formatBitrateArg(value*ratio))
+		}
+	}
+	return args
+}
@@ -0,0 +1,200 @@
+package transcoder
+
+import (
+	"runtime"
+	"strings"
+)
+
+// vaapiUploadFilter converts software frames to a surface format VAAPI
+// encoders accept and uploads them to the GPU
+const vaapiUploadFilter = "format=nv12,hwupload"
+
+// amdEncoderSuffix returns the suffix of the AMD encoders ffmpeg provides on
+// a system: AMF on Windows and VAAPI elsewhere
+func amdEncoderSuffix(goos string) string {
+	if goos == "windows" {
+		return "_amf"
+	}
+	return "_vaapi"
+}
+
+// amdEncoders returns the AMD encoders the built-in presets need; ffmpeg must
+// provide both H.264 and HEVC for the platform to count as AMD
+func amdEncoders(goos string) []string {
+	suffix := amdEncoderSuffix(goos)
+	return []string{"h264" + suffix, "hevc" + suffix}
+}
+
+// isVAAPIEncoder reports whether an encoder runs on VAAPI
+func isVAAPIEncoder(encoder string) bool {
+	return strings.HasSuffix(encoder, "_vaapi")
+}
+
+// isAMFEncoder reports whether an encoder runs on AMD AMF
+func isAMFEncoder(encoder string) bool {
+	return strings.HasSuffix(encoder, "_amf")
+}
+
+// hasAMDEncoders reports whether ffmpeg provides every AMD encoder the
+// presets use on a system
+func (s *SystemChecker) hasAMDEncoders(goos string) (bool, error) {
+	for _, encoder := range amdEncoders(goos) {
+		available, err := s.CheckEncoderAvailability(encoder)
+		if err != nil || !available {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// amdDeviceCount returns how many AMD GPUs can encode. Linux exposes each as
+// a render node; Windows builds of ffmpeg list AMF whatever the GPU, so there
+// one test frame must encode.
+func (s *SystemChecker) amdDeviceCount(goos string) int {
+	switch goos {
+	case "windows":
+		if s.SmokeTestEncoder(amdEncoders(goos)[0]) == nil {
+			return 1
+		}
+	case "linux":
+		return len(s.vendorRenderNodes(amdPCIVendor))
+	}
+	return 0
+}
+
+// vaapiDevice returns the render node of the AMD GPU at an index, or "" when
+// there is none
+func (s *SystemChecker) vaapiDevice(gpuIndex int) string {
+	nodes := s.vendorRenderNodes(amdPCIVendor)
+	if gpuIndex < 0 || gpuIndex >= len(nodes) {
+		return ""
+	}
+	return nodes[gpuIndex]
+}
+
+// amdRateArgs returns the rate control arguments of the AMD presets, which
+// follow the preset bitrate with peaks up to -maxrate
+func amdRateArgs(suffix string) []string {
+	if suffix == "_amf" {
+		return []string{"-quality", "quality", "-rc", "vbr_peak"}
+	}
+	return []string{"-rc_mode", "VBR"}
+}
+
+// addAMDPresets adds AMD presets, encoded with VAAPI on Linux and AMF on
+// Windows. AV1 needs an RDNA 3 or newer GPU; older ones fall back to software.
+func addAMDPresets(presets map[string]Preset) {
+	suffix := amdEncoderSuffix(runtime.GOOS)
+	rate := amdRateArgs(suffix)
+	api := map[string]string{"_amf": "AMF", "_vaapi": "VAAPI"}[suffix]
+	args := func(encoder string, rest ...string) []string {
+		return append(append([]string{"-c:v", encoder}, rate...), rest...)
+	}
+
+	amdPresets := map[string]Preset{
+		"720p_av1": {
+			Name:        "720p_av1",
+			Resolution:  "1280x720",
+			Codec:       "AV1",
+			Encoder:     "av1" + suffix,
+			Bitrate:     "2M",
+			Description: "720p AV1 encoding with AMD " + api,
+			Args:        args("av1"+suffix, "-b:v", "2M", "-maxrate", "3M", "-bufsize", "6M", "-vf", "scale=1280:720"),
+			Platform:    PlatformAMD,
+		},
+		"1080p_av1": {
+			Name:        "1080p_av1",
+			Resolution:  "1920x1080",
+			Codec:       "AV1",
+			Encoder:     "av1" + suffix,
+			Bitrate:     "4M",
+			Description: "1080p AV1 encoding with AMD " + api,
+			Args:        args("av1"+suffix, "-b:v", "4M", "-maxrate", "6M", "-bufsize", "12M", "-vf", "scale=1920:1080"),
+			Platform:    PlatformAMD,
+		},
+		"720p_h264": {
+			Name:        "720p_h264",
+			Resolution:  "1280x720",
+			Codec:       "H.264",
+			Encoder:     "h264" + suffix,
+			Bitrate:     "3M",
+			Description: "720p H.264 encoding with AMD " + api,
+			Args:        args("h264"+suffix, "-b:v", "3M", "-maxrate", "4M", "-bufsize", "8M", "-vf", "scale=1280:720"),
+			Platform:    PlatformAMD,
+		},
+		"1080p_h264": {
+			Name:        "1080p_h264",
+			Resolution:  "1920x1080",
+			Codec:       "H.264",
+			Encoder:     "h264" + suffix,
+			Bitrate:     "5M",
+			Description: "1080p H.264 encoding with AMD " + api,
+			Args:        args("h264"+suffix, "-b:v", "5M", "-maxrate", "8M", "-bufsize", "16M", "-vf", "scale=1920:1080"),
+			Platform:    PlatformAMD,
+		},
+		"1080p_h265": {
+			Name:        "1080p_h265",
+			Resolution:  "1920x1080",
+			Codec:       "H.265",
+			Encoder:     "hevc" + suffix,
+			Bitrate:     "3M",
+			Description: "1080p H.265 encoding with AMD " + api,
+			Args:        args("hevc"+suffix, "-b:v", "3M", "-maxrate", "5M", "-bufsize", "10M", "-vf", "scale=1920:1080"),
+			Platform:    PlatformAMD,
+		},
+		"4k_av1": {
+			Name:        "4k_av1",
+			Resolution:  "3840x2160",
+			Codec:       "AV1",
+			Encoder:     "av1" + suffix,
+			Bitrate:     "15M",
+			Description: "4K AV1 encoding with AMD " + api,
+			Args:        args("av1"+suffix, "-b:v", "15M", "-maxrate", "20M", "-bufsize", "40M", "-vf", "scale=3840:2160"),
+			Platform:    PlatformAMD,
+		},
+		"4k_h265": {
+			Name:        "4k_h265",
+			Resolution:  "3840x2160",
+			Codec:       "H.265",
+			Encoder:     "hevc" + suffix,
+			Bitrate:     "20M",
+			Description: "4K H.265 encoding with AMD " + api,
+			Args:        args("hevc"+suffix, "-b:v", "20M", "-maxrate", "30M", "-bufsize", "60M", "-vf", "scale=3840:2160"),
+			Platform:    PlatformAMD,
+		},
+		"720p_vertical": {
+			Name:        "720p_vertical",
+			Resolution:  "720x1280",
+			Codec:       "H.264",
+			Encoder:     "h264" + suffix,
+			Bitrate:     "3M",
+			Description: "720p portrait H.264 encoding with AMD " + api,
+			Args:        args("h264"+suffix, "-b:v", "3M", "-maxrate", "4M", "-bufsize", "8M", "-vf", "scale=720:1280"),
+			Platform:    PlatformAMD,
+		},
+		"1080p_vertical": {
+			Name:        "1080p_vertical",
+			Resolution:  "1080x1920",
+			Codec:       "H.264",
+			Encoder:     "h264" + suffix,
+			Bitrate:     "5M",
+			Description: "1080p portrait H.264 encoding with AMD " + api,
+			Args:        args("h264"+suffix, "-b:v", "5M", "-maxrate", "8M", "-bufsize", "16M", "-vf", "scale=1080:1920"),
+			Platform:    PlatformAMD,
+		},
+	}
+
+	for name, preset := range amdPresets {
+		presets[name] = preset
+	}
+}
+
+// vaapiUpload ends the chain of a VAAPI encoder with the upload to the GPU.
+// Scaling and every other filter run in software before it, so they keep
+// working on frames ffmpeg decoded in system memory.
+func vaapiUpload(encoder string, chain FilterChain) FilterChain {
+	if !isVAAPIEncoder(encoder) {
+		return chain
+	}
+	return chain.Append(ParseFilterChain(vaapiUploadFilter)...)
+}
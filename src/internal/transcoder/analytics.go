@@ -0,0 +1,271 @@
+package transcoder
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// csvHeader lists the analytics CSV columns. New columns go at the end so
+// consumers that read columns by position keep working.
+var csvHeader = []string{"filename", "start_time", "end_time", "duration_seconds", "size_before_mb", "size_after_mb", "space_saved_mb", "compression_ratio", "preset", "status", "target_bitrate_kbps", "vmaf", "ssim", "bitrate_capped", "space_saved_percent"}
+
+// AnalyticsRecord is one row of conversion analytics, written to the CSV and
+// JSON analytics files
+type AnalyticsRecord struct {
+	Filename        string
+	StartTime       time.Time
+	EndTime         time.Time
+	DurationSeconds float64
+	SizeBeforeMB    float64
+	SizeAfterMB     float64 // Zero when no output was produced
+	Preset          string
+	Status          string
+	TargetBitrate   float64  // Adaptive or smart target in bits/s, zero when the preset bitrate applied
+	BitrateCapped   bool     // --smart-bitrate lowered the target to fit the source's bitrate
+	Encoder         string   // Video encoder that produced the output, empty when nothing was encoded
+	Platform        string   // Detected encoding platform
+	EncodingMode    string   // Encoding mode that succeeded (EncodingModeHardware, ...), empty when nothing was encoded
+	Error           string   // Failure message for status error
+	Trimmed         bool     // Only a --start/--duration/--end segment was encoded, so sizes don't compare
+	VMAF            *float64 // VMAF score against the source, nil when not measured
+	SSIM            *float64 // SSIM score against the source, nil when not measured
+}
+
+// Fallback reports whether the first encode attempt failed and a fallback
+// produced the output
+func (r AnalyticsRecord) Fallback() bool {
+	return r.EncodingMode == EncodingModeSoftwareFallback || r.EncodingMode == EncodingModeSafeFallback
+}
+
+// SpaceSavedMB returns how much smaller the output is than the source, zero
+// when there is no output or it holds a trimmed segment
+func (r AnalyticsRecord) SpaceSavedMB() float64 {
+	if r.SizeAfterMB == 0 || r.Trimmed {
+		return 0
+	}
+	return r.SizeBeforeMB - r.SizeAfterMB
+}
+
+// CompressionRatio returns output size as a fraction of the source size,
+// zero when there is no output, it holds a trimmed segment or the source
+// size is unknown
+func (r AnalyticsRecord) CompressionRatio() float64 {
+	if r.SizeAfterMB == 0 || r.Trimmed {
+		return 0
+	}
+	ratio, _ := sizeRatio(r.SizeBeforeMB, r.SizeAfterMB)
+	return ratio
+}
+
+// SpaceSavedPercent returns how much smaller the output is than the source
+// in percent, negative when it grew and zero when no ratio exists
+func (r AnalyticsRecord) SpaceSavedPercent() float64 {
+	ratio := r.CompressionRatio()
+	if ratio == 0 {
+		return 0
+	}
+	return (1 - ratio) * 100
+}
+
+// csvRow renders the record in csvHeader column order
+func (r AnalyticsRecord) csvRow() []string {
+	return []string{
+		r.Filename,
+		r.StartTime.Format("2006-01-02 15:04:05"),
+		r.EndTime.Format("2006-01-02 15:04:05"),
+		fmt.Sprintf("%.2f", r.DurationSeconds),
+		fmt.Sprintf("%.2f", r.SizeBeforeMB),
+		fmt.Sprintf("%.2f", r.SizeAfterMB),
+		r.untrimmed(fmt.Sprintf("%.2f", r.SpaceSavedMB())),
+		r.untrimmed(fmt.Sprintf("%.4f", r.CompressionRatio())),
+		r.Preset,
+		r.Status,
+		r.targetBitrateKbps(),
+		formatScore(r.VMAF, "%.2f"),
+		formatScore(r.SSIM, "%.4f"),
+		fmt.Sprint(r.BitrateCapped),
+		r.untrimmed(fmt.Sprintf("%.1f", r.SpaceSavedPercent())),
+	}
+}
+
+// formatScore renders a quality score, empty when it was not measured
+func formatScore(score *float64, format string) string {
+	if score == nil {
+		return ""
+	}
+	return fmt.Sprintf(format, *score)
+}
+
+// untrimmed returns a size comparison column, left empty for a trimmed
+// segment
+func (r AnalyticsRecord) untrimmed(value string) string {
+	if r.Trimmed {
+		return ""
+	}
+	return value
+}
+
+// targetBitrateKbps renders the adaptive or smart target, empty when none was chosen
+func (r AnalyticsRecord) targetBitrateKbps() string {
+	if r.TargetBitrate <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.0f", r.TargetBitrate/1000)
+}
+
+// WriteCSVHeader writes the analytics CSV header row
+func WriteCSVHeader(w *csv.Writer) error {
+	return w.Write(csvHeader)
+}
+
+// writeCSVRecord writes and flushes one analytics row
+func writeCSVRecord(w *csv.Writer, record AnalyticsRecord) error {
+	if err := w.Write(record.csvRow()); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// analyticsJSONRecord is the JSON form of an AnalyticsRecord: the CSV
+// columns plus how the file was encoded
+type analyticsJSONRecord struct {
+	Filename          string    `json:"filename"`
+	StartTime         time.Time `json:"start_time"`
+	EndTime           time.Time `json:"end_time"`
+	DurationSeconds   float64   `json:"duration_seconds"`
+	SizeBeforeMB      float64   `json:"size_before_mb"`
+	SizeAfterMB       float64   `json:"size_after_mb"`
+	SpaceSavedMB      float64   `json:"space_saved_mb"`
+	CompressionRatio  float64   `json:"compression_ratio"`
+	SpaceSavedPercent float64   `json:"space_saved_percent"`
+	Preset            string    `json:"preset"`
+	Status            string    `json:"status"`
+	TargetBitrateKbps float64   `json:"target_bitrate_kbps,omitempty"`
+	BitrateCapped     bool      `json:"bitrate_capped,omitempty"`
+	Encoder           string    `json:"encoder,omitempty"`
+	Platform          string    `json:"platform"`
+	EncodingMode      string    `json:"encoding_mode,omitempty"`
+	Hardware          bool      `json:"hardware"`
+	Fallback          bool      `json:"fallback"`
+	Trimmed           bool      `json:"trimmed,omitempty"`
+	VMAF              *float64  `json:"vmaf,omitempty"`
+	SSIM              *float64  `json:"ssim,omitempty"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// jsonRecord converts the record to its JSON form
+func (r AnalyticsRecord) jsonRecord() analyticsJSONRecord {
+	return analyticsJSONRecord{
+		Filename:          r.Filename,
+		StartTime:         r.StartTime,
+		EndTime:           r.EndTime,
+		DurationSeconds:   r.DurationSeconds,
+		SizeBeforeMB:      r.SizeBeforeMB,
+		SizeAfterMB:       r.SizeAfterMB,
+		SpaceSavedMB:      r.SpaceSavedMB(),
+		CompressionRatio:  r.CompressionRatio(),
+		SpaceSavedPercent: r.SpaceSavedPercent(),
+		Preset:            r.Preset,
+		Status:            r.Status,
+		TargetBitrateKbps: r.TargetBitrate / 1000,
+		BitrateCapped:     r.BitrateCapped,
+		Encoder:           r.Encoder,
+		Platform:          r.Platform,
+		EncodingMode:      r.EncodingMode,
+		Hardware:          r.EncodingMode == EncodingModeHardware,
+		Fallback:          r.Fallback(),
+		Trimmed:           r.Trimmed,
+		VMAF:              r.VMAF,
+		SSIM:              r.SSIM,
+		Error:             r.Error,
+	}
+}
+
+// IsJSONLines reports whether a --json-output path names a newline-delimited
+// JSON file (.jsonl or .ndjson) rather than a JSON array
+func IsJSONLines(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".jsonl" || ext == ".ndjson"
+}
+
+// AnalyticsJSON writes analytics records to the --json-output file. Every
+// record is on disk before the next file starts, and the file is valid JSON
+// after every record: newline-delimited files get one appended line per
+// record, and arrays get each record written over the closing bracket, which
+// follows it again. Neither keeps earlier records in memory.
+type AnalyticsJSON struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	lines   bool  // Newline-delimited output rather than an array
+	records int   // Records in the array so far
+	end     int64 // Offset the next array element is written at
+}
+
+// OpenAnalyticsJSON creates the JSON analytics file, replacing an existing one
+func OpenAnalyticsJSON(path string) (*AnalyticsJSON, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError, "failed to create JSON output "+path, err)
+	}
+	a := &AnalyticsJSON{path: path, file: file, lines: IsJSONLines(path)}
+	if !a.lines {
+		if _, err := file.WriteString("[]\n"); err != nil {
+			file.Close()
+			return nil, NewTranscoderError(ErrorTypeFileSystemError, "failed to write "+path, err)
+		}
+		a.end = 1
+	}
+	return a, nil
+}
+
+// Write adds one record to the file
+func (a *AnalyticsJSON) Write(record AnalyticsRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lines {
+		line, err := json.Marshal(record.jsonRecord())
+		if err != nil {
+			return err
+		}
+		if _, err := a.file.Write(append(line, '\n')); err != nil {
+			return NewTranscoderError(ErrorTypeFileSystemError, "failed to write "+a.path, err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(record.jsonRecord(), "  ", "  ")
+	if err != nil {
+		return err
+	}
+	separator := ",\n  "
+	if a.records == 0 {
+		separator = "\n  "
+	}
+	element := append([]byte(separator), data...)
+	if _, err := a.file.WriteAt(append(element, "\n]\n"...), a.end); err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError, "failed to write "+a.path, err)
+	}
+	a.end += int64(len(element))
+	a.records++
+	return nil
+}
+
+// Close closes the file
+func (a *AnalyticsJSON) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// bytesToMB converts a byte count to MiB as used in the analytics columns
+func bytesToMB(n int64) float64 {
+	return float64(n) / (1024 * 1024)
+}
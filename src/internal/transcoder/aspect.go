@@ -0,0 +1,103 @@
+package transcoder
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseRatio parses a ratio such as "32:27" as ffprobe reports it
+func parseRatio(value string) (float64, bool) {
+	num, den, found := strings.Cut(value, ":")
+	if !found {
+		return 0, false
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	d, err := strconv.Atoi(den)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return float64(n) / float64(d), true
+}
+
+// evenRound rounds a dimension to the nearest even number, as 4:2:0 video requires
+func evenRound(v float64) int {
+	return max(2, int(math.Round(v/2))*2)
+}
+
+// aspectFilters makes the output of a non-square-pixel source use square
+// pixels at its true display shape. A fixed-size scale filter becomes the largest
+// size of the source's display aspect ratio that fits in the preset's box;
+// without one, the source is scaled to its display dimensions. setsar=1 then
+// marks the pixels square so players that ignore the aspect flag still show
+// the right shape. Sources with square or unknown pixels are left alone.
+func aspectFilters(chain FilterChain, info *ProbeInfo) FilterChain {
+	sar, ok := parseRatio(info.SAR)
+	if !ok || math.Abs(sar-1) < 0.001 || info.Width <= 0 || info.Height <= 0 {
+		return chain
+	}
+
+	// Frames are rotated before the filter chain runs, so the display shape
+	// follows the rotation too
+	displayW, displayH := float64(info.Width)*sar, float64(info.Height)
+	if info.Rotation == 90 || info.Rotation == 270 {
+		displayW, displayH = displayH, displayW
+	}
+
+	var filters FilterChain
+	fixed := false
+	for _, filter := range chain {
+		boxW, boxH, options, ok := fixedScale(filter)
+		if !ok {
+			filters = append(filters, filter)
+			continue
+		}
+		fit := min(float64(boxW)/displayW, float64(boxH)/displayH)
+		filters = append(filters, fmt.Sprintf("scale=%d:%d%s", evenRound(displayW*fit), evenRound(displayH*fit), options), "setsar=1")
+		fixed = true
+	}
+	if !fixed {
+		filters = filters.Append(fmt.Sprintf("scale=%d:%d", evenRound(displayW), evenRound(displayH)), "setsar=1")
+	}
+	return filters
+}
+
+// fixedScale returns the target size of a "scale=W:H" filter with positive
+// dimensions, along with any further options such as ":flags=lanczos"
+func fixedScale(filter string) (int, int, string, bool) {
+	if !strings.HasPrefix(filter, "scale=") {
+		return 0, 0, "", false
+	}
+	dims := strings.SplitN(strings.TrimPrefix(filter, "scale="), ":", 3)
+	if len(dims) < 2 {
+		return 0, 0, "", false
+	}
+	width, werr := strconv.Atoi(dims[0])
+	height, herr := strconv.Atoi(dims[1])
+	if werr != nil || herr != nil || width <= 0 || height <= 0 {
+		return 0, 0, "", false
+	}
+	options := ""
+	if len(dims) == 3 {
+		options = ":" + dims[2]
+	}
+	return width, height, options, true
+}
+
+// fixAspect corrects the scaling of anamorphic sources when --fix-aspect is
+// set. Sources that cannot be probed keep the preset scaling.
+func (t *Transcoder) fixAspect(inputPath string, chain FilterChain) FilterChain {
+	if !t.config.FixAspect {
+		return chain
+	}
+	info, err := t.prober.Probe(t.mediaInput(inputPath))
+	if err != nil {
+		t.log.Debugf("Cannot determine pixel aspect ratio of %s, keeping preset scaling", inputPath)
+		return chain
+	}
+	return aspectFilters(chain, info)
+}
@@ -0,0 +1,68 @@
+package transcoder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultCapBufsizeFactor sizes the VBV buffer of a capped CRF encode when
+// --bufsize-factor is not set
+const defaultCapBufsizeFactor = 2.0
+
+// ParseBitrate parses a bitrate such as 8M or 7500k into bits per second
+func ParseBitrate(value string) (float64, error) {
+	bps, err := parseSIValue(strings.TrimSpace(value))
+	if err != nil || bps <= 0 {
+		return 0, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("invalid bitrate '%s' (use a value such as 8M or 7500k)", value), err)
+	}
+	return bps, nil
+}
+
+// CappedRateArgs returns the arguments that let a constant-quality encode
+// float below a bitrate ceiling. NVENC needs variable bitrate mode with no
+// target bitrate for -cq to apply; software encoders combine -crf with the
+// VBV limits directly. VideoToolbox and AMD have no capped quality mode, and
+// Quick Sync switches from constant quality to bitrate modes once limits are
+// set.
+func CappedRateArgs(encoder string, maxBitrate, bufsizeFactor float64) ([]string, bool) {
+	if strings.HasSuffix(encoder, "_videotoolbox") || isQSVEncoder(encoder) || isVAAPIEncoder(encoder) || isAMFEncoder(encoder) {
+		return nil, false
+	}
+	if bufsizeFactor <= 0 {
+		bufsizeFactor = defaultCapBufsizeFactor
+	}
+	limits := []string{"-maxrate", formatBitrateArg(maxBitrate), "-bufsize", formatBitrateArg(maxBitrate * bufsizeFactor)}
+	if isNVENCEncoder(encoder) {
+		return append([]string{"-rc", "vbr", "-b:v", "0"}, limits...), true
+	}
+	return limits, true
+}
+
+// applyBitrateCap turns a --crf encode into capped CRF when --max-bitrate is
+// set: the preset's target bitrate is dropped so quality decides the rate,
+// and the cap bounds it from above
+func (t *Transcoder) applyBitrateCap(args []string) []string {
+	if t.config.MaxBitrate <= 0 || t.config.CRF == nil {
+		return args
+	}
+	encoder := argValue(args, "-c:v")
+	capArgs, ok := CappedRateArgs(encoder, t.config.MaxBitrate, t.config.BufsizeFactor)
+	if !ok {
+		t.capWarnOnce.Do(func() {
+			t.log.Warnf("%s has no capped quality mode; --max-bitrate is ignored and only --crf applies", encoder)
+		})
+		return args
+	}
+
+	result := make([]string, 0, len(args)+len(capArgs))
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-b:v", "-maxrate", "-bufsize", "-rc":
+			i++
+			continue
+		}
+		result = append(result, args[i])
+	}
+	return append(result, capArgs...)
+}
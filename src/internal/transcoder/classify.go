@@ -0,0 +1,97 @@
+package transcoder
+
+import "strings"
+
+// ffmpegErrorPatterns map lowercase ffmpeg error output to the error type it
+// signals. They are checked in order: GPU failures come first, as ffmpeg
+// follows some of them with a generic "No such file" or "Invalid argument".
+var ffmpegErrorPatterns = []struct {
+	marker    string
+	errorType ErrorType
+}{
+	{"cannot load nvcuda", ErrorTypeGPUNotAvailable},
+	{"cannot load libcuda", ErrorTypeGPUNotAvailable},
+	{"cannot load libnvidia-encode", ErrorTypeGPUNotAvailable},
+	{"device creation failed", ErrorTypeGPUNotAvailable},
+	{"no nvenc capable devices found", ErrorTypeGPUNotAvailable},
+	{"no capable devices found", ErrorTypeGPUNotAvailable},
+	{"failed to initialise vaapi connection", ErrorTypeGPUNotAvailable},
+	{"unknown encoder", ErrorTypeEncoderNotFound},
+	{"no such filter", ErrorTypeFilterNotFound},
+	{"no such file or directory", ErrorTypeInvalidFilePath},
+	{"is a directory", ErrorTypeInvalidFilePath},
+	{"permission denied", ErrorTypeFileSystemError},
+	{"no space left on device", ErrorTypeFileSystemError},
+	{"unrecognized option", ErrorTypeInvalidPreset},
+	{"error parsing options", ErrorTypeInvalidPreset},
+	{"invalid argument", ErrorTypeInvalidPreset},
+}
+
+// gpuDeviceNodes are the device node paths through which ffmpeg opens a GPU
+var gpuDeviceNodes = []string{"/dev/dri/", "/dev/nvidia"}
+
+// gpuDeviceFailures are the file errors that opening a GPU device node fails
+// with, such as a missing video or render group membership
+var gpuDeviceFailures = []string{"permission denied", "operation not permitted", "no such file or directory"}
+
+// gpuDeviceError reports whether a line of ffmpeg output is a failure to open
+// a GPU device node. It is a GPU problem that software can get past, not a
+// file system error of the input or output.
+func gpuDeviceError(line string) bool {
+	hasNode := false
+	for _, node := range gpuDeviceNodes {
+		hasNode = hasNode || strings.Contains(line, node)
+	}
+	if !hasNode {
+		return false
+	}
+	for _, failure := range gpuDeviceFailures {
+		if strings.Contains(line, failure) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyFFmpegError returns the error type a failed ffmpeg run's stderr
+// points to, ErrorTypeEncodingFailed when no known message is found
+func classifyFFmpegError(stderr string) ErrorType {
+	stderr = strings.ToLower(stderr)
+	for _, line := range strings.Split(stderr, "\n") {
+		if gpuDeviceError(line) {
+			return ErrorTypeGPUNotAvailable
+		}
+	}
+	for _, pattern := range ffmpegErrorPatterns {
+		if strings.Contains(stderr, pattern.marker) {
+			return pattern.errorType
+		}
+	}
+	return ErrorTypeEncodingFailed
+}
+
+// fallbackHelps reports whether a software encode can get past an error of
+// the given type. Missing files, missing filters and file system errors fail
+// every fallback the same way, so retrying only wastes time.
+func fallbackHelps(errorType ErrorType) bool {
+	switch errorType {
+	case ErrorTypeInvalidFilePath, ErrorTypeFilterNotFound, ErrorTypeFileSystemError:
+		return false
+	}
+	return true
+}
+
+// ffmpegErrorLine returns the last line of ffmpeg's stderr that names an
+// error, or its last line, for error messages
+func ffmpegErrorLine(stderr string) string {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.ToLower(lines[i])
+		for _, pattern := range ffmpegErrorPatterns {
+			if strings.Contains(line, pattern.marker) {
+				return strings.TrimSpace(lines[i])
+			}
+		}
+	}
+	return strings.TrimSpace(lines[len(lines)-1])
+}
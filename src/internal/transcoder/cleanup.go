@@ -0,0 +1,141 @@
+package transcoder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultMinDurationRatio is the share of the source duration an output must
+// reach before cleanup considers it complete
+const DefaultMinDurationRatio = 0.9
+
+// CleanupCandidate is an output that looks partial or broken
+type CleanupCandidate struct {
+	Path   string
+	Size   int64
+	Reason string
+}
+
+// FindCleanupCandidates scans the output directory for outputs that are
+// empty, cannot be probed, have no video stream, or are much shorter than
+// their source. The source duration comes from the output's sidecar, or from
+// probing the matching source under the input path when one is configured.
+func (t *Transcoder) FindCleanupCandidates(minDurationRatio float64) ([]CleanupCandidate, error) {
+	if err := t.systemChecker.CheckFFprobeAvailability(); err != nil {
+		return nil, err
+	}
+	outputs, err := t.fileDiscovery.FindVideoFiles(t.config.OutputDir, true)
+	if err != nil {
+		return nil, err
+	}
+	sources := t.cleanupSources()
+
+	var candidates []CleanupCandidate
+	for _, output := range outputs {
+		info, err := os.Stat(output)
+		if err != nil {
+			continue
+		}
+		candidate := CleanupCandidate{Path: output, Size: info.Size()}
+		if reason := t.cleanupReason(output, info.Size(), sources[filepath.Clean(output)], minDurationRatio); reason != "" {
+			candidate.Reason = reason
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, nil
+}
+
+// cleanupReason explains why an output looks partial, or returns "" for a
+// complete one
+func (t *Transcoder) cleanupReason(output string, size int64, source string, minDurationRatio float64) string {
+	if size == 0 {
+		return "zero-byte file"
+	}
+	probe, err := t.prober.Probe(output)
+	if err != nil {
+		return "unreadable (probe failed)"
+	}
+	if !probe.HasVideo() {
+		return "no video stream"
+	}
+
+	expected := t.expectedDuration(output, source)
+	if expected > 0 && probe.Duration < expected*minDurationRatio {
+		return fmt.Sprintf("truncated (%s of %s)", formatDuration(probe.Duration), formatDuration(expected))
+	}
+	return ""
+}
+
+// expectedDuration returns the source duration of an output from its sidecar
+// or by probing its source, or 0 when neither is available
+func (t *Transcoder) expectedDuration(output, source string) float64 {
+	if data, err := os.ReadFile(SidecarPath(output)); err == nil {
+		var sidecar Sidecar
+		if json.Unmarshal(data, &sidecar) == nil && sidecar.Source != nil && sidecar.Source.Duration > 0 {
+			return sidecar.Source.Duration
+		}
+	}
+	if source == "" {
+		return 0
+	}
+	if info, err := t.prober.Probe(t.mediaInput(source)); err == nil {
+		return info.Duration
+	}
+	return 0
+}
+
+// cleanupSources maps outputs to their sources by output naming when an
+// input path is configured
+func (t *Transcoder) cleanupSources() map[string]string {
+	sources := make(map[string]string)
+	if t.config.InputPath == "" {
+		return sources
+	}
+	files, err := t.FindVideoFiles()
+	if err != nil {
+		return sources
+	}
+	for source, pairs := range t.pairFromPaths(files) {
+		for _, pair := range pairs {
+			sources[filepath.Clean(pair.output)] = source
+		}
+	}
+	return sources
+}
+
+// formatDuration renders seconds like 1h40m0s for reports
+func formatDuration(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+}
+
+// ConfirmCleanup asks whether to delete the candidates; anything but yes declines
+func ConfirmCleanup(in io.Reader, out io.Writer, count int) (bool, error) {
+	fmt.Fprintf(out, "Delete %d file(s)? [y/N] ", count)
+	answer, err := readAnswer(bufio.NewReader(in))
+	if err != nil {
+		return false, err
+	}
+	return answer == "y" || answer == "yes", nil
+}
+
+// DeleteCleanupCandidates removes the candidates and their sidecars
+func DeleteCleanupCandidates(candidates []CleanupCandidate) (int, []error) {
+	deleted := 0
+	var errors []error
+	for _, candidate := range candidates {
+		if err := os.Remove(candidate.Path); err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		deleted++
+		if err := os.Remove(SidecarPath(candidate.Path)); err != nil && !os.IsNotExist(err) {
+			errors = append(errors, err)
+		}
+	}
+	return deleted, errors
+}
@@ -0,0 +1,171 @@
+package transcoder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandLog appends the ffmpeg commands run for each input to a file, with
+// their full stderr, exit status and the outcome of the input. The console
+// only shows the last lines of a failure; the log keeps everything at any
+// --log-level. Commands of an input are collected while it is encoded and
+// written as one section, so inputs encoded in parallel never interleave.
+type CommandLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	sections map[string]*commandSection // Inputs being encoded, by path
+}
+
+// commandSection collects the log entry of one input and preset
+type commandSection struct {
+	input    string
+	preset   string
+	started  time.Time
+	commands int
+	body     strings.Builder
+}
+
+// OpenCommandLog opens a command log for appending, creating it if needed
+func OpenCommandLog(path string) (*CommandLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot open log file "+path, err)
+	}
+	return &CommandLog{file: file, sections: make(map[string]*commandSection)}, nil
+}
+
+// Begin starts the section of an input encoded with a preset. Commands
+// logged for the input until End belong to it.
+func (l *CommandLog) Begin(input, preset string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sections[input] = &commandSection{input: input, preset: preset, started: time.Now()}
+}
+
+// Command records an ffmpeg run. A command outside any section, such as a
+// preview, is written at once as a section of its own.
+func (l *CommandLog) Command(input string, args []string, stderr string, err error, took time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	section, open := l.sections[input]
+	if !open {
+		section = &commandSection{input: input, started: time.Now().Add(-took)}
+	}
+	section.commands++
+	fmt.Fprintf(&section.body, "--- Command %d ---\n%s\n", section.commands, FormatCommand("ffmpeg", args))
+	fmt.Fprintf(&section.body, "Exit status: %s after %s\n", exitStatus(err), took.Round(time.Millisecond))
+	if stderr = strings.TrimRight(stderr, "\n"); stderr != "" {
+		fmt.Fprintf(&section.body, "Stderr:\n%s\n", stderr)
+	} else {
+		section.body.WriteString("Stderr: (empty)\n")
+	}
+	if !open {
+		l.write(section, "")
+	}
+}
+
+// End closes the section of an input with its outcome and writes it. Inputs
+// that ran no command and did not fail, such as those skipped because their
+// output exists, leave nothing in the log.
+func (l *CommandLog) End(input string, result *FileResult, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	section, open := l.sections[input]
+	if !open {
+		return
+	}
+	delete(l.sections, input)
+	if section.commands == 0 && err == nil {
+		return
+	}
+
+	var outcome string
+	switch {
+	case err != nil:
+		outcome = "failed: " + err.Error()
+	case result == nil:
+		outcome = "done"
+	case result.Skipped:
+		outcome = "skipped (" + result.SkipReason + ")"
+	case result.EncodingMode == EncodingModeSoftwareFallback || result.EncodingMode == EncodingModeSafeFallback:
+		outcome = "encoded with fallback " + result.EncodingMode
+	default:
+		outcome = "encoded (" + result.EncodingMode + ", no fallback)"
+	}
+	l.write(section, outcome)
+}
+
+// write appends a finished section to the file. The caller holds l.mu.
+func (l *CommandLog) write(section *commandSection, outcome string) {
+	var out strings.Builder
+	fmt.Fprintf(&out, "=== %s %s", section.started.Format(time.RFC3339), section.input)
+	if section.preset != "" {
+		fmt.Fprintf(&out, " (preset %s)", section.preset)
+	}
+	out.WriteString(" ===\n")
+	out.WriteString(section.body.String())
+	if outcome != "" {
+		fmt.Fprintf(&out, "Result: %s after %s\n", outcome, time.Since(section.started).Round(time.Millisecond))
+	}
+	out.WriteString("\n")
+
+	// A log that cannot be written must not fail the encode it describes
+	io.WriteString(l.file, out.String())
+	l.file.Sync()
+}
+
+// Close writes the sections of inputs still in flight and closes the file
+func (l *CommandLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for input, section := range l.sections {
+		if section.commands > 0 {
+			l.write(section, "interrupted")
+		}
+		delete(l.sections, input)
+	}
+	return l.file.Close()
+}
+
+// exitStatus describes how an ffmpeg run ended
+func exitStatus(err error) string {
+	if err == nil {
+		return "0"
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return fmt.Sprint(exitErr.ExitCode())
+	}
+	return err.Error()
+}
+
+// OpenCommandLog opens the log file configured with --log-file
+func (t *Transcoder) OpenCommandLog() error {
+	if t.config.LogFile == "" {
+		return nil
+	}
+	log, err := OpenCommandLog(t.config.LogFile)
+	if err != nil {
+		return err
+	}
+	t.commandLog = log
+	return nil
+}
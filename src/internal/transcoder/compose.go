@@ -0,0 +1,223 @@
+package transcoder
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// resolutionTier holds the target size of a resolution and its bitrate
+// ladder per codec
+type resolutionTier struct {
+	width, height int
+	rates         map[string]composedRate // keyed by normalized codec name
+}
+
+// composedRate is the bitrate, rate cap, buffer and quality used for a codec
+type composedRate struct {
+	bitrate, maxrate, bufsize string
+	crf                       int
+}
+
+// resolutionTiers mirror the fixed preset matrix and fill in the combinations
+// it has no preset for
+var resolutionTiers = map[string]resolutionTier{
+	"720p": {1280, 720, map[string]composedRate{
+		"h264": {"3M", "4M", "8M", 23},
+		"hevc": {"2M", "3M", "6M", 26},
+		"av1":  {"2M", "3M", "6M", 28},
+	}},
+	"1080p": {1920, 1080, map[string]composedRate{
+		"h264": {"5M", "8M", "16M", 23},
+		"hevc": {"3M", "5M", "10M", 26},
+		"av1":  {"4M", "6M", "12M", 26},
+	}},
+	"4k": {3840, 2160, map[string]composedRate{
+		"h264": {"25M", "35M", "70M", 23},
+		"hevc": {"20M", "30M", "60M", 26},
+		"av1":  {"15M", "20M", "40M", 28},
+	}},
+}
+
+// resolutionAliases maps other common names to a tier
+var resolutionAliases = map[string]string{
+	"hd":    "720p",
+	"fhd":   "1080p",
+	"2160p": "4k",
+	"uhd":   "4k",
+}
+
+// composedCodecNames are the codec names used in synthesized preset names
+var composedCodecNames = map[string]string{
+	"h264": "h264",
+	"hevc": "h265",
+	"av1":  "av1",
+}
+
+// normalizeResolution lowercases a resolution name and resolves aliases
+func normalizeResolution(resolution string) string {
+	resolution = strings.ToLower(strings.TrimSpace(resolution))
+	if alias, ok := resolutionAliases[resolution]; ok {
+		return alias
+	}
+	return resolution
+}
+
+// IsValidResolution reports whether --resolution accepts the name
+func IsValidResolution(resolution string) bool {
+	_, ok := resolutionTiers[normalizeResolution(resolution)]
+	return ok
+}
+
+// GetResolutions returns the resolution tiers accepted by --resolution
+func GetResolutions() []string {
+	names := make([]string, 0, len(resolutionTiers))
+	for name := range resolutionTiers {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return resolutionTiers[names[i]].height < resolutionTiers[names[j]].height
+	})
+	return names
+}
+
+// IsComposableCodec reports whether --codec accepts the name (h264, h265, av1
+// and their aliases)
+func IsComposableCodec(codec string) bool {
+	_, ok := composedCodecNames[normalizeCodecName(codec)]
+	return ok
+}
+
+// ComposePreset synthesizes a preset from a codec and a resolution tier,
+// using the encoder the platform's preset table uses for that codec and the
+// tier's bitrate for it. Non-Apple platforms get NVENC arguments, which are
+// converted to software encoders off NVIDIA hardware like any other preset.
+func ComposePreset(codec, resolution string, platform Platform) (Preset, error) {
+	codec = normalizeCodecName(codec)
+	codecName, ok := composedCodecNames[codec]
+	if !ok {
+		return Preset{}, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("unsupported codec '%s' (use h264, h265 or av1)", codec), nil)
+	}
+	resolution = normalizeResolution(resolution)
+	tier, ok := resolutionTiers[resolution]
+	if !ok {
+		return Preset{}, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("unsupported resolution '%s' (use %s)", resolution, strings.Join(GetResolutions(), ", ")), nil)
+	}
+	rate := tier.rates[codec]
+
+	preset := Preset{
+		Name:       resolution + "_" + codecName,
+		Resolution: fmt.Sprintf("%dx%d", tier.width, tier.height),
+		Codec:      map[string]string{"h264": "H.264", "hevc": "H.265", "av1": "AV1"}[codec],
+		Bitrate:    rate.bitrate,
+		Platform:   platform,
+	}
+	rateArgs := []string{"-b:v", rate.bitrate, "-maxrate", rate.maxrate, "-bufsize", rate.bufsize,
+		"-vf", fmt.Sprintf("scale=%d:%d", tier.width, tier.height)}
+
+	var qualityArgs []string
+	switch {
+	case platform == PlatformAppleSilicon && codec == "av1":
+		// VideoToolbox has no AV1 encoder
+		preset.Encoder = "libsvtav1"
+		svtPreset := "6"
+		if tier.height >= 2160 {
+			svtPreset = "5"
+		}
+		qualityArgs = []string{"-preset", svtPreset, "-crf", fmt.Sprint(rate.crf)}
+		preset.Description = fmt.Sprintf("%s %s encoding (software, optimized for Apple Silicon)", resolution, preset.Codec)
+	case platform == PlatformAppleSilicon:
+		preset.Encoder = codec + "_videotoolbox"
+		qualityArgs, _ = QualityArgs(preset.Encoder, rate.crf)
+		preset.Description = fmt.Sprintf("%s %s encoding with VideoToolbox", resolution, preset.Codec)
+	case platform == PlatformIntelQSV:
+		preset.Encoder = codec + "_qsv"
+		qualityArgs = []string{"-preset", "slower", "-global_quality", fmt.Sprint(rate.crf)}
+		preset.Description = fmt.Sprintf("%s %s encoding with Quick Sync", resolution, preset.Codec)
+	case platform == PlatformAMD:
+		suffix := amdEncoderSuffix(runtime.GOOS)
+		preset.Encoder = codec + suffix
+		qualityArgs = amdRateArgs(suffix)
+		preset.Description = fmt.Sprintf("%s %s encoding with AMD %s", resolution, preset.Codec, strings.ToUpper(strings.TrimPrefix(suffix, "_")))
+	default:
+		preset.Encoder = codec + "_nvenc"
+		qualityArgs = []string{"-preset", "p7", "-crf", fmt.Sprint(rate.crf)}
+		preset.Description = fmt.Sprintf("%s %s encoding with NVENC", resolution, preset.Codec)
+	}
+
+	preset.Args = append([]string{"-c:v", preset.Encoder}, qualityArgs...)
+	preset.Args = append(preset.Args, rateArgs...)
+	return preset, nil
+}
+
+// composePreset registers the preset described by --codec and --resolution
+// and makes it the configured preset. Whichever of the two is not given is
+// taken from the configured preset.
+func (t *Transcoder) composePreset() error {
+	if t.config.Codec == "" && t.config.Resolution == "" {
+		return nil
+	}
+
+	codec, resolution := t.config.Codec, t.config.Resolution
+	base, hasBase := t.presets[t.config.Preset]
+	if codec == "" {
+		codec = "h264"
+		if hasBase {
+			codec = base.Codec
+		}
+	}
+	if resolution == "" {
+		resolution = "1080p"
+		if hasBase {
+			resolution = resolutionForSize(base.Resolution)
+		}
+	}
+
+	preset, err := ComposePreset(codec, resolution, hostPresetPlatform(t.systemChecker.GetPlatform()))
+	if err != nil {
+		return err
+	}
+	t.presets[preset.Name] = preset
+	t.config.Preset = preset.Name
+	return nil
+}
+
+// resolutionForSize returns the tier matching a WxH size in either orientation,
+// or 1080p when none does
+func resolutionForSize(size string) string {
+	width, height, ok := parseResolution(size)
+	if ok {
+		for name, tier := range resolutionTiers {
+			if (tier.width == width && tier.height == height) || (tier.width == height && tier.height == width) {
+				return name
+			}
+		}
+	}
+	return "1080p"
+}
+
+// ValidateCodec checks that ffmpeg provides the encoder a --codec selection
+// will use on this host
+func (t *Transcoder) ValidateCodec() error {
+	if t.config.Codec == "" && t.config.Resolution == "" {
+		return nil
+	}
+	preset, exists := t.presets[t.config.Preset]
+	if !exists {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("preset %s not found", t.config.Preset), nil)
+	}
+	encoder := argValue(t.videoArgs(preset, t.useHardware(preset)), "-c:v")
+	available, err := t.systemChecker.CheckEncoderAvailability(encoder)
+	if err != nil {
+		return err
+	}
+	if !available {
+		return NewTranscoderError(ErrorTypeEncoderNotFound,
+			fmt.Sprintf("codec %s is not supported here: ffmpeg has no %s encoder", preset.Codec, encoder), nil)
+	}
+	return nil
+}
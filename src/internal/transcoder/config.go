@@ -1,18 +1,111 @@
 package transcoder
 
+import (
+	"os"
+	"time"
+)
+
 // Config holds the transcoder configuration
 type Config struct {
-	InputPath      string // Path to input file or directory
-	OutputDir      string // Output directory for transcoded files
-	Preset         string // Encoding preset name
-	GPUIndex       int    // GPU index to use (0-based)
-	AudioCodec     string // Audio codec ("copy", "aac", etc.)
-	Verbose        bool   // Enable verbose output
-	Recursive      bool   // Process files recursively
-	Overwrite      bool   // Overwrite existing output files
-	NoGPU          bool   // Disable GPU acceleration
-	DryRun         bool   // Perform a dry run without actual transcoding
-	SkipValidation bool   // Skip path validation (for system checks)
+	InputPath         string        // Path to input file or directory
+	InputPaths        []string      // All input roots when several are given (InputPath is the first)
+	OutputDir         string        // Output directory for transcoded files
+	Preset            string        // Encoding preset name
+	Presets           []string      // All presets each file is encoded to when several are given (Preset is the first)
+	GPUIndex          int           // GPU index to use (0-based)
+	AudioCodec        string        // Audio codec ("copy", "aac", etc.)
+	AudioBitrate      string        // Bitrate of re-encoded audio such as 192k (empty for DefaultAudioBitrate)
+	NormalizeAudio    bool          // Normalize audio loudness to EBU R128 with loudnorm, re-encoding copied audio
+	NormalizeTwoPass  bool          // Measure each source's loudness first and normalize with the measured values; implies NormalizeAudio
+	AudioTrack        string        // Audio track to keep: a 0-based index or "all" (empty for ffmpeg's default choice)
+	Verbose           bool          // Enable verbose output
+	Recursive         bool          // Process files recursively
+	FollowSymlinks    bool          // Descend into symlinked directories when recursive (loops are detected)
+	Overwrite         bool          // Overwrite existing output files
+	SkipIfCodec       bool          // Skip sources already in the preset's codec at or below its resolution
+	DeleteSource      bool          // Delete each source once its output is verified against it
+	NoGPU             bool          // Disable GPU acceleration
+	DryRun            bool          // Perform a dry run without actual transcoding
+	SkipValidation    bool          // Skip path validation (for system checks)
+	NoToolMetadata    bool          // Don't tag outputs with ffmcli provenance metadata
+	StripMetadata     bool          // Drop the source's global tags and chapters instead of copying them to the output
+	ToolVersion       string        // ffmcli version recorded in output metadata
+	Sidecar           bool          // Write a <output>.json sidecar describing each encode
+	Thumbnail         bool          // Write a poster frame as a .jpg next to each output
+	ThumbnailAt       string        // Position of the poster frame in the output (empty lets the thumbnail filter pick); implies Thumbnail
+	ContactSheet      int           // Frames tiled into a <output>_sheet.jpg contact sheet (0 for none)
+	NoProbe           bool          // Skip up-front ffprobe of inputs (progress counts files)
+	TempDir           string        // Directory for intermediate files (default: system temp, honors TMPDIR)
+	StageDir          string        // Directory outputs are written to before being moved into place (optional)
+	Tune              string        // Encoder tuning (film, animation, grain, ...); overrides the preset default
+	QualityTarget     string        // Quality level (low, medium, high, archival) setting CRF, speed preset and tune together
+	Policy            []string      // Only process files matching any of these policy expressions
+	SoftwareCodecs    []string      // Codecs (h264, hevc, av1) always encoded in software
+	OutputMode        os.FileMode   // Permissions for outputs (0 keeps the default); directories get matching search bits
+	OutputGroup       string        // Group name or ID for outputs (Unix only)
+	DVD               bool          // Treat input as ripped DVDs, concatenating VTS_XX_Y.VOB parts per title
+	MaxrateFactor     float64       // -maxrate as a multiple of the effective bitrate (0 keeps the preset value)
+	BufsizeFactor     float64       // -bufsize as a multiple of the effective bitrate (0 keeps the preset value)
+	SubtitleFiles     []string      // External subtitle files to mux (instead of auto-detection)
+	SubtitleLanguage  string        // Language tag for subtitles without one in their filename
+	NoAutoSubtitles   bool          // Don't attach same-basename subtitle files automatically
+	Subtitles         string        // Embedded subtitles: none (ffmpeg's default selection), copy or burn
+	AutoOrient        bool          // Swap the preset's scale dimensions to match a portrait or landscape source
+	FixAspect         bool          // Scale non-square-pixel sources to their display shape with square pixels
+	NoUpscale         bool          // Keep sources at or below the preset resolution at their own size
+	MaxRuntime        time.Duration // Stop starting new files once the batch has run this long (0 for no limit)
+	MaxFilesPerDir    int           // Fail on a directory holding more video files than this (0 for no limit)
+	FrameRate         string        // Output frame rate as normalized by ParseFrameRate (empty keeps the source rate)
+	Manifest          string        // Checksum manifest appended after each successful encode
+	ManifestAlgorithm string        // Manifest hash algorithm (sha256, sha512, blake3)
+	JSONOutput        string        // JSON analytics file: an array, or one record per line for .jsonl/.ndjson
+	CRF               *int          // Unified 0-51 quality translated per encoder (nil keeps the preset value)
+	EncoderSpeed      string        // Speed preset (x264 names, translated per encoder) overriding the preset's -preset
+	MaxBitrate        float64       // Bitrate ceiling in bits/s for capped CRF; requires CRF (0 for none)
+	Suffix            string        // Extra tag appended to output names after the preset name
+	NameTemplate      string        // Template of output names before the suffix and extension (empty for DefaultNameTemplate)
+	ForceExtension    string        // Output file extension such as ".mp4"; the container is still the one Container selects
+	Container         string        // Output container: mkv (default), mp4 or webm
+	NoProgress        bool          // Never draw the in-place per-file progress line
+	Parallelism       int           // Files encoded at once (0 or 1 for one at a time); capped for hardware encoders
+	GroupByCodec      bool          // Summarize converted files per source video codec at the end of a run
+	RatioStyle        string        // How sizes compare with the source: saved (default) or original
+	OnlyNew           bool          // Skip sources whose output name exists anywhere under the output directory
+	Energy            bool          // Estimate the energy each batch's encodes used and report it in the summary
+	EnergyWatts       float64       // Power draw assumed for encodes that are not sampled (0 uses a platform default)
+	ProbeCache        string        // JSON file keeping probe results across runs (optional)
+	ProbeTimeout      time.Duration // Limit for the pre-encode input check (0 uses DefaultProbeTimeout)
+	Codec             string        // Codec (h264, h265, av1) for a preset synthesized with Resolution; overrides Preset
+	Resolution        string        // Resolution tier (720p, 1080p, 4k) for a synthesized preset; overrides Preset
+	NVENCLookahead    *int          // NVENC -rc-lookahead frames (nil keeps the encoder default)
+	NVENCBFrames      *int          // NVENC -bf B-frames (nil keeps the encoder default)
+	NVENCAQ           string        // NVENC adaptive quantization: spatial, temporal or both (empty for default)
+	DebugOverlay      bool          // Burn frame number, timestamp and rate settings into sample encodes
+	Repair            bool          // Remux inputs with fixable container problems before encoding them
+	AdaptiveBitrate   bool          // Pick each file's target bitrate from a quick complexity probe
+	AdaptiveMin       float64       // Lowest adaptive bitrate in bits/s (0 for half the preset bitrate)
+	AdaptiveMax       float64       // Highest adaptive bitrate in bits/s (0 for 1.5x the preset bitrate)
+	SmartBitrate      bool          // Cap each file's target bitrate below the source's so outputs never outgrow their sources
+	TwoPass           bool          // Encode presets with a bitrate in two passes for predictable sizes
+	FFmpegArgs        []string      // Extra ffmpeg arguments placed before the output path, overriding preset options
+	MeasureQuality    bool          // Score each output against its source with VMAF (skipped without libvmaf)
+	MeasureSSIM       bool          // Score each output against its source with SSIM
+	Retries           int           // Times an encode failing with a transient GPU error is retried before the fallbacks
+	TrimStart         time.Duration // Position in each source the output starts at (0 for the beginning)
+	TrimDuration      time.Duration // Length of the output (0 for the rest of the source); excludes TrimEnd
+	TrimEnd           time.Duration // Position in each source the output ends at (0 for the end); excludes TrimDuration
+	MinSize           int64         // Leave out discovered files smaller than this many bytes (0 for no minimum)
+	MinDuration       time.Duration // Leave out discovered files shorter than this (0 for no minimum)
+	MaxDuration       time.Duration // Leave out discovered files longer than this (0 for no maximum)
+	Tonemap           string        // Tone mapping of HDR sources to SDR: auto (empty), on or off
+	TonemapAlgorithm  string        // Tone mapping curve: hable (empty), mobius or reinhard
+	Deinterlace       string        // Deinterlacing: off (empty), auto or on
+	DeinterlaceFilter string        // Deinterlacing filter: yadif (empty) or bwdif
+	Crop              string        // Crop rectangle as W:H:X:Y, or auto to detect black bars per file (empty for none)
+	ResumeFile        string        // JSON state recording each file's status with --resume (optional)
+	RetryFailed       bool          // Encode files the resume state records as failed again
+	LogLevel          string        // Lowest level of messages shown: debug, info (empty), warn or error; Verbose means debug
+	LogFile           string        // File each input's ffmpeg commands, full stderr and outcome are appended to (optional)
 }
 
 // Validate validates the configuration
@@ -28,17 +121,160 @@ func (c *Config) Validate() error {
 		return nil
 	}
 
-	if c.InputPath == "" {
+	if c.InputPath == "" && len(c.InputPaths) == 0 {
 		return NewTranscoderError(ErrorTypeInvalidFilePath, "input path is required", nil)
 	}
 	if c.OutputDir == "" {
 		return NewTranscoderError(ErrorTypeInvalidFilePath, "output directory is required", nil)
 	}
+	if c.Preset == "" && len(c.Presets) > 0 {
+		c.Preset = c.Presets[0]
+	}
+	if len(c.Presets) > 0 && c.Preset != c.Presets[0] {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "the preset must be the first of the presets", nil)
+	}
+	if len(c.Presets) > 1 && (c.Codec != "" || c.Resolution != "") {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "a codec or resolution selection makes a single preset and cannot be combined with several presets", nil)
+	}
+	if c.Codec != "" && !IsComposableCodec(c.Codec) {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "unsupported codec "+c.Codec, nil)
+	}
+	if c.Resolution != "" && !IsValidResolution(c.Resolution) {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "unsupported resolution "+c.Resolution, nil)
+	}
+	if c.MaxBitrate > 0 && c.CRF == nil {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "a bitrate cap requires a CRF value", nil)
+	}
+	if c.QualityTarget != "" && !IsQualityTarget(c.QualityTarget) {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "unsupported quality target "+c.QualityTarget, nil)
+	}
+	if c.Subtitles != "" && !IsSubtitleMode(c.Subtitles) {
+		return NewTranscoderError(ErrorTypeInvalidSubtitle, "unsupported subtitle mode "+c.Subtitles, nil)
+	}
+	if _, err := ParseAudioTrack(c.AudioTrack); err != nil {
+		return err
+	}
+	if c.Tonemap != "" && !IsTonemapMode(c.Tonemap) {
+		return NewTranscoderError(ErrorTypeInvalidOption, "unsupported tone mapping mode "+c.Tonemap, nil)
+	}
+	if c.TonemapAlgorithm != "" && !IsTonemapAlgorithm(c.TonemapAlgorithm) {
+		return NewTranscoderError(ErrorTypeInvalidOption, "unsupported tone mapping algorithm "+c.TonemapAlgorithm, nil)
+	}
+	if c.LogLevel != "" {
+		if _, err := ParseLogLevel(c.LogLevel); err != nil {
+			return err
+		}
+	}
+	if c.Deinterlace != "" && !IsDeinterlaceMode(c.Deinterlace) {
+		return NewTranscoderError(ErrorTypeInvalidOption, "unsupported deinterlacing mode "+c.Deinterlace, nil)
+	}
+	if c.DeinterlaceFilter != "" && !IsDeinterlaceFilter(c.DeinterlaceFilter) {
+		return NewTranscoderError(ErrorTypeInvalidOption, "unsupported deinterlacing filter "+c.DeinterlaceFilter, nil)
+	}
+	if c.Crop != "" && c.Crop != CropAuto {
+		if _, err := ParseCrop(c.Crop); err != nil {
+			return err
+		}
+	}
+	if c.NameTemplate != "" {
+		if err := ValidateNameTemplate(c.NameTemplate); err != nil {
+			return err
+		}
+	}
+	if c.Container != "" && !IsContainer(c.Container) {
+		return NewTranscoderError(ErrorTypeInvalidContainer, "unsupported container "+c.Container, nil)
+	}
+	if c.RatioStyle != "" && !IsRatioStyle(c.RatioStyle) {
+		return NewTranscoderError(ErrorTypeInvalidOption, "unsupported ratio style "+c.RatioStyle, nil)
+	}
+	if c.AdaptiveBitrate && c.QualityTarget != "" {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "adaptive bitrate cannot be combined with a quality target", nil)
+	}
+	if c.AdaptiveBitrate && c.Parallelism > 1 {
+		return NewTranscoderError(ErrorTypeInvalidOption, "adaptive bitrate cannot be combined with parallel jobs", nil)
+	}
+	if c.TrimStart < 0 || c.TrimDuration < 0 || c.TrimEnd < 0 {
+		return NewTranscoderError(ErrorTypeInvalidTime, "trim positions cannot be negative", nil)
+	}
+	if c.TrimDuration > 0 && c.TrimEnd > 0 {
+		return NewTranscoderError(ErrorTypeInvalidTime, "a trim duration and end cannot be combined", nil)
+	}
+	if c.TrimEnd > 0 && c.TrimEnd <= c.TrimStart {
+		return NewTranscoderError(ErrorTypeInvalidTime, "the trim start must be before its end", nil)
+	}
+	if c.MinSize < 0 || c.MinDuration < 0 || c.MaxDuration < 0 {
+		return NewTranscoderError(ErrorTypeInvalidThreshold, "size and duration thresholds cannot be negative", nil)
+	}
+	if c.MaxDuration > 0 && c.MaxDuration < c.MinDuration {
+		return NewTranscoderError(ErrorTypeInvalidThreshold, "the minimum duration must not exceed the maximum duration", nil)
+	}
+	if c.Retries < 0 {
+		return NewTranscoderError(ErrorTypeInvalidOption, "the number of retries cannot be negative", nil)
+	}
+	if c.Parallelism < 0 {
+		return NewTranscoderError(ErrorTypeInvalidOption, "the number of parallel jobs cannot be negative", nil)
+	}
+	if c.AdaptiveBitrate && c.CRF != nil {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "adaptive bitrate cannot be combined with a CRF value", nil)
+	}
+	if c.SmartBitrate && (c.CRF != nil || c.QualityTarget != "") {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "smart bitrate caps a target bitrate and cannot be combined with a CRF value or quality target", nil)
+	}
+	if err := ValidateFFmpegArgs(c.FFmpegArgs); err != nil {
+		return err
+	}
+	if c.TwoPass && (c.CRF != nil || c.QualityTarget != "") {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "two-pass encoding targets a bitrate and cannot be combined with a CRF value or quality target", nil)
+	}
+	if c.AdaptiveMin > 0 && c.AdaptiveMax > 0 && c.AdaptiveMin > c.AdaptiveMax {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "the adaptive bitrate minimum is above the maximum", nil)
+	}
 	if c.GPUIndex < 0 {
 		c.GPUIndex = 0
 	}
 	if c.AudioCodec == "" {
 		c.AudioCodec = "copy"
 	}
-	return nil
+	if c.NormalizeTwoPass {
+		c.NormalizeAudio = true
+	}
+	if c.ThumbnailAt != "" {
+		if _, err := ParseTimestamp(c.ThumbnailAt); err != nil {
+			return err
+		}
+		c.Thumbnail = true
+	}
+	if err := CheckContactSheet(c.ContactSheet); err != nil {
+		return err
+	}
+	return CheckAudioBitrate(c.AudioCodec, c.AudioBitrate, c.Container, c.NormalizeAudio)
+}
+
+// PresetNames returns every preset each file is encoded to
+func (c *Config) PresetNames() []string {
+	if len(c.Presets) > 0 {
+		return c.Presets
+	}
+	return []string{c.Preset}
+}
+
+// InputRoots returns every input file or directory of the run
+func (c *Config) InputRoots() []string {
+	if len(c.InputPaths) > 0 {
+		return c.InputPaths
+	}
+	return []string{c.InputPath}
+}
+
+// logLevel returns the lowest level of messages to show. --verbose is the
+// same as --log-level debug.
+func (c *Config) logLevel() LogLevel {
+	if c.Verbose {
+		return LogDebug
+	}
+	level, err := ParseLogLevel(c.LogLevel)
+	if err != nil {
+		return LogInfo
+	}
+	return level
 }
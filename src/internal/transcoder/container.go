@@ -0,0 +1,192 @@
+package transcoder
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Output containers selectable with --container
+const (
+	ContainerMKV  = "mkv"
+	ContainerMP4  = "mp4"
+	ContainerWebM = "webm"
+)
+
+// Containers lists the --container values, the default first
+var Containers = []string{ContainerMKV, ContainerMP4, ContainerWebM}
+
+// containerFormat describes what an output container can hold and how
+// ffmpeg writes it
+type containerFormat struct {
+	extension   string
+	muxer       string
+	videoCodecs map[string]bool // Video codecs the container holds
+	audioCodecs map[string]bool // Audio codecs the container holds; nil for any
+	copyAudio   string          // Audio encoder for --audio-codec copy, copying only sources in audioCodecs (empty copies everything)
+	muxerFlags  []string        // Muxer options, placed just before the output
+}
+
+// containerFormats is the codec/container compatibility matrix. AV1 in MP4
+// needs ffmpeg's mp4 muxer, so the muxer is always named; older MP4-style
+// muxers such as ipod, which ffmpeg picks for .m4v, cannot write it. MP4
+// outputs get their index at the front (+faststart) so they play while
+// still downloading.
+var containerFormats = map[string]containerFormat{
+	ContainerMKV: {
+		extension:   ".mkv",
+		muxer:       "matroska",
+		videoCodecs: map[string]bool{"h264": true, "hevc": true, "av1": true, "vp9": true},
+	},
+	ContainerMP4: {
+		extension:   ".mp4",
+		muxer:       "mp4",
+		videoCodecs: map[string]bool{"h264": true, "hevc": true, "av1": true, "vp9": true},
+		audioCodecs: map[string]bool{"aac": true, "mp3": true, "ac3": true, "eac3": true, "opus": true, "flac": true, "alac": true},
+		copyAudio:   "aac",
+		muxerFlags:  []string{"-movflags", "+faststart"},
+	},
+	ContainerWebM: {
+		extension:   ".webm",
+		muxer:       "webm",
+		videoCodecs: map[string]bool{"av1": true, "vp9": true, "vp8": true},
+		audioCodecs: map[string]bool{"opus": true, "vorbis": true},
+		copyAudio:   "libopus",
+	},
+}
+
+// IsContainer reports whether name is a known --container value
+func IsContainer(name string) bool {
+	_, ok := containerFormats[name]
+	return ok
+}
+
+// ContainerExtension returns the file extension of a container, such as
+// ".mp4"; Matroska's for ""
+func ContainerExtension(container string) string {
+	return formatFor(container).extension
+}
+
+// formatFor returns the format of a container, Matroska for ""
+func formatFor(container string) containerFormat {
+	if format, ok := containerFormats[container]; ok {
+		return format
+	}
+	return containerFormats[ContainerMKV]
+}
+
+// encoderVideoCodec returns the codec an ffmpeg video encoder produces, or ""
+// for encoders it doesn't recognize
+func encoderVideoCodec(encoder string) string {
+	encoder = strings.ToLower(encoder)
+	switch {
+	case strings.Contains(encoder, "264"):
+		return "h264"
+	case strings.Contains(encoder, "265"), strings.Contains(encoder, "hevc"):
+		return "hevc"
+	case strings.Contains(encoder, "av1"):
+		return "av1"
+	case strings.Contains(encoder, "vp9"):
+		return "vp9"
+	case strings.Contains(encoder, "vpx"), strings.Contains(encoder, "vp8"):
+		return "vp8"
+	}
+	return ""
+}
+
+// sortedKeys returns the keys of a codec set in order, for messages
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CheckContainer reports whether a container can hold the output of a video
+// encoder together with an --audio-codec value. Unknown video encoders are
+// left to ffmpeg.
+func CheckContainer(container, videoEncoder, audioCodec string) error {
+	format, ok := containerFormats[container]
+	if !ok {
+		return NewTranscoderError(ErrorTypeInvalidContainer,
+			fmt.Sprintf("unknown container '%s' (use %s)", container, strings.Join(Containers, ", ")), nil)
+	}
+	if codec := encoderVideoCodec(videoEncoder); codec != "" && !format.videoCodecs[codec] {
+		return NewTranscoderError(ErrorTypeInvalidContainer,
+			fmt.Sprintf("%s cannot hold %s video; use a preset with %s", container, codec, strings.Join(sortedKeys(format.videoCodecs), ", ")), nil)
+	}
+	if audioCodec == "" || audioCodec == "copy" || format.audioCodecs == nil {
+		return nil
+	}
+	if !format.audioCodecs[audioTargetCodec(audioCodec)] {
+		return NewTranscoderError(ErrorTypeInvalidContainer,
+			fmt.Sprintf("%s cannot hold %s audio; use --audio-codec with one of %s", container, audioCodec, strings.Join(sortedKeys(format.audioCodecs), ", ")), nil)
+	}
+	return nil
+}
+
+// containerFormat returns the format outputs are written in
+func (t *Transcoder) containerFormat() containerFormat {
+	return formatFor(t.config.Container)
+}
+
+// checkContainer confirms that the container can hold what a preset encodes
+func (t *Transcoder) checkContainer(preset Preset) error {
+	if t.config.Container == "" || t.config.Container == ContainerMKV {
+		return nil
+	}
+	encoder := argValue(t.videoArgs(preset, t.useHardware(preset)), "-c:v")
+	return CheckContainer(t.config.Container, encoder, t.config.AudioCodec)
+}
+
+// ValidateContainer checks that --container can hold the output of the
+// configured presets and audio codec
+func (t *Transcoder) ValidateContainer() error {
+	presets, err := t.configuredPresets()
+	if err != nil {
+		return err
+	}
+	for _, preset := range presets {
+		if err := t.checkContainer(preset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// muxerArgs returns the arguments before the output path that select the
+// muxer: its options, and its name whenever the file extension would
+// suggest another
+func (t *Transcoder) muxerArgs(outputPath string) []string {
+	format := t.containerFormat()
+	args := append([]string(nil), format.muxerFlags...)
+	if !strings.EqualFold(filepath.Ext(outputPath), format.extension) {
+		args = append(args, "-f", format.muxer)
+	}
+	return args
+}
+
+// containerVideoTag returns the arguments tagging HEVC in MP4 as hvc1, which
+// Apple players require; ffmpeg's default hev1 tag plays elsewhere only
+func (t *Transcoder) containerVideoTag(encoder string) []string {
+	if t.config.Container == ContainerMP4 && encoderVideoCodec(encoder) == "hevc" {
+		return []string{"-tag:v", "hvc1"}
+	}
+	return nil
+}
+
+// embeddedSubtitleCodec returns the codec for an embedded subtitle stream in
+// the output container, or false when the container cannot hold it. MP4 and
+// WebM store text subtitles in their own format and no bitmap subtitles.
+func (t *Transcoder) embeddedSubtitleCodec(codec string) (string, bool) {
+	switch t.config.Container {
+	case ContainerMP4:
+		return "mov_text", !bitmapSubtitleCodecs[codec]
+	case ContainerWebM:
+		return "webvtt", !bitmapSubtitleCodecs[codec]
+	}
+	return matroskaSubtitleCodec(codec), true
+}
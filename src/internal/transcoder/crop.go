@@ -0,0 +1,214 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CropAuto is the --crop value that detects black bars per file
+const CropAuto = "auto"
+
+// cropDetectLength is how much of the source cropdetect looks at, taken
+// from the middle where intros and credits with other framing are unlikely
+const cropDetectLength = 60 * time.Second
+
+// cropDetectFilter finds the non-black area of each frame. round=2 keeps the
+// suggested sizes even, as 4:2:0 video requires.
+const cropDetectFilter = "cropdetect=limit=24:round=2:reset=0"
+
+// Crop is a rectangle of the source frame in W:H:X:Y order, as ffmpeg's
+// crop filter takes it
+type Crop struct {
+	Width, Height int
+	X, Y          int
+}
+
+// String returns the crop in W:H:X:Y form
+func (c Crop) String() string {
+	return fmt.Sprintf("%d:%d:%d:%d", c.Width, c.Height, c.X, c.Y)
+}
+
+// ParseCrop parses W:H:X:Y crop parameters. The size must be positive and
+// the offsets not negative; whether the rectangle fits a source is only
+// known once it is probed.
+func ParseCrop(value string) (Crop, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	invalid := NewTranscoderError(ErrorTypeInvalidOption,
+		fmt.Sprintf("invalid crop '%s' (use W:H:X:Y, e.g. 1920:800:0:140, or auto)", value), nil)
+	if len(parts) != 4 {
+		return Crop{}, invalid
+	}
+	var numbers [4]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Crop{}, invalid
+		}
+		numbers[i] = n
+	}
+	crop := Crop{Width: numbers[0], Height: numbers[1], X: numbers[2], Y: numbers[3]}
+	if crop.Width == 0 || crop.Height == 0 {
+		return Crop{}, invalid
+	}
+	return crop, nil
+}
+
+// cropdetectPattern matches the suggestion cropdetect logs for each frame
+var cropdetectPattern = regexp.MustCompile(`crop=(\d+:\d+:\d+:\d+)`)
+
+// parseCropDetect returns the crop cropdetect suggested most often in its
+// stderr output; ties go to the one suggested first
+func parseCropDetect(stderr string) (Crop, bool) {
+	counts := make(map[string]int)
+	var best string
+	for _, match := range cropdetectPattern.FindAllStringSubmatch(stderr, -1) {
+		counts[match[1]]++
+		if counts[match[1]] > counts[best] {
+			best = match[1]
+		}
+	}
+	if best == "" {
+		return Crop{}, false
+	}
+	crop, err := ParseCrop(best)
+	return crop, err == nil
+}
+
+// detectCrop runs cropdetect over the middle of the source and returns the
+// most common suggestion
+func (t *Transcoder) detectCrop(ctx context.Context, inputPath string, info *ProbeInfo) (Crop, error) {
+	start, length := sampleWindow(time.Duration(info.Duration*float64(time.Second)), cropDetectLength)
+	if length <= 0 {
+		length = cropDetectLength
+	}
+	args := []string{"-hide_banner"}
+	input := t.inputArgs(inputPath)
+	if start > 0 && !containsArg(input, "-ss") {
+		args = append(args, "-ss", formatSeconds(start))
+	}
+	args = append(args, input...)
+	args = append(args, "-t", formatSeconds(length), "-map", "0:v:0", "-vf", cropDetectFilter, "-an", "-sn", "-f", "null", "-")
+
+	t.log.Debugf("Running: %s", FormatCommand("ffmpeg", args))
+	stderr, err := t.runFFmpeg(ctx, inputPath, args, nil)
+	if err != nil {
+		return Crop{}, NewTranscoderError(ErrorTypeEncodingFailed,
+			fmt.Sprintf("crop detection failed for %s: %s", inputPath, ffmpegErrorLine(stderr)), err)
+	}
+	crop, ok := parseCropDetect(stderr)
+	if !ok {
+		return Crop{}, NewTranscoderError(ErrorTypeEncodingFailed,
+			"crop detection found no picture in "+inputPath, nil)
+	}
+	return crop, nil
+}
+
+// chooseCrop settles the crop of a file before its commands are built:
+// the --crop rectangle, or with auto the detected one. Detection runs once
+// per file, however many presets encode it. Nothing is cropped when the
+// rectangle covers the whole frame, and a rectangle that does not fit the
+// source is an error rather than an ffmpeg failure.
+func (t *Transcoder) chooseCrop(ctx context.Context, inputPath string) error {
+	if t.config.Crop == "" {
+		return nil
+	}
+	t.stateMu.Lock()
+	_, chosen := t.crops[inputPath]
+	t.stateMu.Unlock()
+	if chosen {
+		return nil
+	}
+
+	info, err := t.prober.Probe(t.mediaInput(inputPath))
+	if err != nil {
+		if t.config.Crop == CropAuto {
+			t.log.Warnf("cannot detect crop of %s: %v; encoding uncropped", inputPath, err)
+			t.setCrop(inputPath, Crop{})
+			return nil
+		}
+		info = &ProbeInfo{}
+	}
+
+	var crop Crop
+	if t.config.Crop == CropAuto {
+		if crop, err = t.detectCrop(ctx, inputPath, info); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			t.log.Warnf("%v; encoding uncropped", err)
+			t.setCrop(inputPath, Crop{})
+			return nil
+		}
+	} else if crop, err = ParseCrop(t.config.Crop); err != nil {
+		return err
+	}
+
+	width, height := info.DisplaySize()
+	if width > 0 && height > 0 {
+		if crop.X+crop.Width > width || crop.Y+crop.Height > height {
+			return NewTranscoderError(ErrorTypeInvalidOption,
+				fmt.Sprintf("crop %s does not fit the %dx%d frame of %s", crop, width, height, inputPath), nil)
+		}
+		if crop.Width == width && crop.Height == height {
+			t.log.Debugf("No black bars detected in %s", inputPath)
+			t.setCrop(inputPath, Crop{})
+			return nil
+		}
+	}
+	if t.config.Crop == CropAuto {
+		t.log.Debugf("Detected crop for %s: %s (%dx%d of %dx%d)", inputPath, crop, crop.Width, crop.Height, width, height)
+	}
+	t.setCrop(inputPath, crop)
+	return nil
+}
+
+// setCrop records the crop of a file; the zero Crop records that it is
+// not cropped
+func (t *Transcoder) setCrop(inputPath string, crop Crop) {
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	if t.crops == nil {
+		t.crops = make(map[string]Crop)
+	}
+	t.crops[inputPath] = crop
+}
+
+// crop puts the crop chosen for a file at the front of the chain, so
+// the scale filters after it see the cropped frame. Fixed-size scale
+// filters are fitted to the cropped shape, as stretching it to the preset's
+// box would bring back the distortion of the bars' aspect ratio.
+func (t *Transcoder) crop(inputPath string, chain FilterChain) FilterChain {
+	t.stateMu.Lock()
+	crop, chosen := t.crops[inputPath]
+	t.stateMu.Unlock()
+	// A dry run shows an explicit rectangle without settling it first
+	if !chosen && t.config.Crop != "" && t.config.Crop != CropAuto {
+		crop, _ = ParseCrop(t.config.Crop)
+	}
+	if crop.Width == 0 {
+		return chain
+	}
+
+	// Non-square pixels widen the displayed shape
+	shapeW, shapeH := float64(crop.Width), float64(crop.Height)
+	if info, err := t.prober.Probe(t.mediaInput(inputPath)); err == nil {
+		if sar, ok := parseRatio(info.SAR); ok && info.Rotation != 90 && info.Rotation != 270 {
+			shapeW *= sar
+		}
+	}
+
+	cropped := FilterChain{"crop=" + crop.String()}
+	for _, filter := range chain {
+		if boxW, boxH, options, ok := fixedScale(filter); ok {
+			fit := math.Min(float64(boxW)/shapeW, float64(boxH)/shapeH)
+			filter = fmt.Sprintf("scale=%d:%d%s", evenRound(shapeW*fit), evenRound(shapeH*fit), options)
+		}
+		cropped = append(cropped, filter)
+	}
+	return cropped
+}
@@ -0,0 +1,64 @@
+package transcoder
+
+import "slices"
+
+// Modes of --deinterlace
+const (
+	DeinterlaceOff  = "off"  // Never deinterlace
+	DeinterlaceAuto = "auto" // Deinterlace sources whose field order marks them as interlaced
+	DeinterlaceOn   = "on"   // Deinterlace every source, for interlaced files with missing metadata
+)
+
+// DeinterlaceModes lists the --deinterlace values, the default first
+var DeinterlaceModes = []string{DeinterlaceOff, DeinterlaceAuto, DeinterlaceOn}
+
+// DeinterlaceFilters lists the --deinterlace-filter values, the default first
+var DeinterlaceFilters = []string{"yadif", "bwdif"}
+
+// IsDeinterlaceMode reports whether name is a known --deinterlace value
+func IsDeinterlaceMode(name string) bool {
+	return slices.Contains(DeinterlaceModes, name)
+}
+
+// IsDeinterlaceFilter reports whether name is a known --deinterlace-filter value
+func IsDeinterlaceFilter(name string) bool {
+	return slices.Contains(DeinterlaceFilters, name)
+}
+
+// interlacedFieldOrders are the field orders of interlaced video as ffprobe
+// names them; progressive and unknown sources are left alone
+var interlacedFieldOrders = map[string]bool{
+	"tt": true, // Top field first, coded and displayed
+	"bb": true, // Bottom field first, coded and displayed
+	"tb": true, // Top coded first, bottom displayed first
+	"bt": true, // Bottom coded first, top displayed first
+}
+
+// IsInterlaced reports whether the primary video stream is interlaced
+func (p *ProbeInfo) IsInterlaced() bool {
+	return interlacedFieldOrders[p.FieldOrder]
+}
+
+// deinterlace puts a deinterlacing filter at the front of the chain, for
+// interlaced sources with --deinterlace auto and for every source with
+// --deinterlace on. Being first, it hands whole frames to tone mapping,
+// scaling and everything after it. Sources that cannot be probed are left
+// alone in auto mode.
+func (t *Transcoder) deinterlace(inputPath string, chain FilterChain) FilterChain {
+	if t.config.Deinterlace == "" || t.config.Deinterlace == DeinterlaceOff {
+		return chain
+	}
+	if t.config.Deinterlace != DeinterlaceOn {
+		info, err := t.prober.Probe(t.mediaInput(inputPath))
+		if err != nil || !info.IsInterlaced() {
+			return chain
+		}
+		t.log.Debugf("Deinterlacing %s (field order %s)", inputPath, info.FieldOrder)
+	}
+
+	filter := t.config.DeinterlaceFilter
+	if filter == "" {
+		filter = DeinterlaceFilters[0]
+	}
+	return chain.Prepend(filter)
+}
@@ -0,0 +1,91 @@
+package transcoder
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Tolerance for --delete-source: an output's duration may differ from its
+// source's by this share of the source duration, or by minDeleteTolerance for
+// short sources, before the source is kept
+const (
+	deleteDurationTolerance = 0.02
+	minDeleteTolerance      = 1.0 // seconds
+)
+
+// sourceDeletionProblem explains why the source of a finished encode must be
+// kept, or returns "" when the output is verified and the source can go. The
+// output is probed afresh and must hold video whose duration matches the
+// source's within the tolerance.
+func (t *Transcoder) sourceDeletionProblem(result *FileResult) string {
+	switch {
+	case t.config.DryRun:
+		return "dry run"
+	case result.EncodingMode == EncodingModeSafeFallback:
+		return "encoded with the safe fallback settings"
+	case t.pathUtils.IsSamePath(result.InputPath, result.OutputPath):
+		return "output is the same file"
+	case t.trimming():
+		return "output is a trimmed segment"
+	}
+	if _, ok := t.dvdTitles[result.InputPath]; ok {
+		return "DVD titles span several files"
+	}
+
+	source, err := t.prober.Probe(t.mediaInput(result.InputPath))
+	if err != nil || source.Duration <= 0 {
+		return "source duration unknown"
+	}
+	info, err := os.Stat(result.OutputPath)
+	if err != nil || info.Size() == 0 {
+		return "output missing or empty"
+	}
+	t.prober.Invalidate(result.OutputPath)
+	output, err := t.prober.Probe(result.OutputPath)
+	if err != nil {
+		return "output cannot be probed"
+	}
+	if !output.HasVideo() {
+		return "output has no video stream"
+	}
+	tolerance := max(source.Duration*deleteDurationTolerance, minDeleteTolerance)
+	if math.Abs(output.Duration-source.Duration) > tolerance {
+		return fmt.Sprintf("output runs %s, source %s", formatDuration(output.Duration), formatDuration(source.Duration))
+	}
+	return ""
+}
+
+// deleteSource removes the source of a successful encode when --delete-source
+// is set and the output of every preset checks out, logging the deletion or
+// why the source was kept. Sources of skipped files are left alone.
+func (t *Transcoder) deleteSource(result *FileResult) {
+	if !t.config.DeleteSource || result == nil {
+		return
+	}
+	outputs := result.outputs()
+	for _, output := range outputs {
+		if output.Skipped {
+			return
+		}
+	}
+	var names []string
+	for _, output := range outputs {
+		if problem := t.sourceDeletionProblem(output); problem != "" {
+			t.log.Infof("Keeping source %s: %s", result.InputPath, problem)
+			return
+		}
+		names = append(names, filepath.Base(output.OutputPath))
+	}
+	if err := os.Remove(result.InputPath); err != nil {
+		t.log.Warnf("failed to delete source %s: %v", result.InputPath, err)
+		return
+	}
+	label := "output"
+	if len(names) > 1 {
+		label = "outputs"
+	}
+	t.log.Infof("Deleted source %s (%s %s verified)", result.InputPath, label, strings.Join(names, ", "))
+}
@@ -1,14 +1,20 @@
 package transcoder
 
 import (
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // FileDiscovery handles finding video files
 type FileDiscovery struct {
 	videoExtensions map[string]bool
+	followSymlinks  bool // Descend into symlinked directories during recursive discovery
+	maxFilesPerDir  int  // Fail on directories with more video files than this (0 for no limit)
 }
 
 // NewFileDiscovery creates a new file discovery instance
@@ -34,46 +40,295 @@ func NewFileDiscovery() *FileDiscovery {
 // FindVideoFiles finds all video files based on configuration
 func (f *FileDiscovery) FindVideoFiles(inputPath string, recursive bool) ([]string, error) {
 	var files []string
+	err := f.streamVideoFiles(inputPath, recursive, func(path string) error {
+		files = append(files, path)
+		return nil
+	})
+	return files, err
+}
 
+// streamVideoFiles passes each video file under inputPath to yield as it is
+// found, in lexical order, and stops at the first error yield returns
+func (f *FileDiscovery) streamVideoFiles(inputPath string, recursive bool, yield func(string) error) error {
 	info, err := os.Stat(inputPath)
 	if err != nil {
-		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+		return NewTranscoderError(ErrorTypeFileSystemError,
 			"cannot access input path", err)
 	}
 
-	if info.IsDir() {
-		if recursive {
-			err = filepath.Walk(inputPath, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
+	if !info.IsDir() {
+		if f.isVideoFile(inputPath) {
+			return yield(inputPath)
+		}
+		return nil
+	}
+	if recursive {
+		return f.walkVideoFiles(inputPath, make(map[string]bool), yield)
+	}
+
+	entries, err := os.ReadDir(inputPath)
+	if err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot read directory", err)
+	}
+	if err := f.checkDirSize(inputPath, entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			fullPath := filepath.Join(inputPath, entry.Name())
+			if f.isVideoFile(fullPath) {
+				if err := yield(fullPath); err != nil {
 					return err
 				}
-				if !info.IsDir() && f.isVideoFile(path) {
-					files = append(files, path)
-				}
-				return nil
-			})
-		} else {
-			entries, err := os.ReadDir(inputPath)
+			}
+		}
+	}
+	return nil
+}
+
+// SetFollowSymlinks controls whether recursive discovery descends into
+// symlinked directories. Symlinked files are always included and the input
+// path itself is always followed.
+func (f *FileDiscovery) SetFollowSymlinks(follow bool) {
+	f.followSymlinks = follow
+}
+
+// SetMaxFilesPerDir makes discovery fail on a directory holding more video
+// files than max, before any of them is used. Zero disables the limit.
+func (f *FileDiscovery) SetMaxFilesPerDir(max int) {
+	f.maxFilesPerDir = max
+}
+
+// checkDirSize enforces the per-directory file limit on a directory listing
+func (f *FileDiscovery) checkDirSize(dir string, entries []os.DirEntry) error {
+	if f.maxFilesPerDir <= 0 || len(entries) <= f.maxFilesPerDir {
+		return nil
+	}
+	count := 0
+	for _, entry := range entries {
+		if !entry.IsDir() && f.isVideoFile(entry.Name()) {
+			count++
+		}
+	}
+	if count > f.maxFilesPerDir {
+		return NewTranscoderError(ErrorTypeFileSystemError,
+			fmt.Sprintf("%s holds %d video files, more than the limit of %d; raise --max-files-per-dir to process it",
+				dir, count, f.maxFilesPerDir), nil)
+	}
+	return nil
+}
+
+// walkVideoFiles passes video files below dir to yield in lexical order.
+// Symlinked directories are only entered when following symlinks, and a
+// directory reached a second time, through a loop or another link, is
+// skipped. Broken symlinks are ignored.
+func (f *FileDiscovery) walkVideoFiles(dir string, visited map[string]bool, yield func(string) error) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if visited[realDir] {
+		return nil
+	}
+	visited[realDir] = true
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if err := f.checkDirSize(dir, entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
 			if err != nil {
-				return nil, NewTranscoderError(ErrorTypeFileSystemError,
-					"cannot read directory", err)
+				continue
+			}
+			if target.IsDir() && !f.followSymlinks {
+				continue
+			}
+			isDir = target.IsDir()
+		}
+
+		if isDir {
+			if err := f.walkVideoFiles(path, visited, yield); err != nil {
+				return err
+			}
+		} else if f.isVideoFile(path) {
+			if err := yield(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SourceFile is a discovered input together with the root it was found under
+type SourceFile struct {
+	Path string // Path to the video file
+	Root string // Input root whose structure the output mirrors
+}
+
+// FindVideoFilesInRoots finds video files under several input roots. A file
+// reachable from more than one root (overlapping directories, or a file also
+// given on its own) is returned once, attributed to the first root listing it.
+func (f *FileDiscovery) FindVideoFilesInRoots(roots []string, recursive bool) ([]SourceFile, error) {
+	var sources []SourceFile
+	err := f.StreamVideoFiles(roots, recursive, func(source SourceFile) error {
+		sources = append(sources, source)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// StreamVideoFiles is FindVideoFilesInRoots without collecting the results:
+// each file is passed to yield as soon as it is found, so memory does not
+// grow with the size of the library. Discovery stops at the first error
+// yield returns. Duplicates are only tracked when roots could overlap.
+func (f *FileDiscovery) StreamVideoFiles(roots []string, recursive bool, yield func(SourceFile) error) error {
+	var seen map[string]bool
+	if len(roots) > 1 {
+		seen = make(map[string]bool)
+	}
+	for _, root := range roots {
+		err := f.streamVideoFiles(root, recursive, func(file string) error {
+			if seen != nil {
+				key := sourceKey(file)
+				if seen[key] {
+					return nil
+				}
+				seen[key] = true
+			}
+			return yield(SourceFile{Path: file, Root: root})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Thresholds leave out discovered files that are too small, too short or
+// too long to be worth encoding. Each limit is off when zero, and a file must
+// pass every limit that is set.
+type Thresholds struct {
+	MinSize     int64         // Minimum file size in bytes
+	MinDuration time.Duration // Minimum media duration
+	MaxDuration time.Duration // Maximum media duration
+}
+
+// IsZero reports whether no threshold is set
+func (th Thresholds) IsZero() bool {
+	return th.MinSize == 0 && th.MinDuration == 0 && th.MaxDuration == 0
+}
+
+// FilterByThresholds returns the files passing th and how many were left
+// out. Sizes are read with os.Stat; durationOf is only called for files that
+// pass the size check, and only when a duration limit is set. Files whose
+// size or duration cannot be read are kept, so the encode reports the
+// problem instead of the file silently disappearing.
+func (f *FileDiscovery) FilterByThresholds(files []string, th Thresholds, durationOf func(string) (time.Duration, error)) ([]string, int) {
+	if th.IsZero() {
+		return files, 0
+	}
+
+	kept := make([]string, 0, len(files))
+	for _, file := range files {
+		if th.MinSize > 0 {
+			if info, err := os.Stat(file); err == nil && info.Size() < th.MinSize {
+				continue
 			}
-			for _, entry := range entries {
-				if !entry.IsDir() {
-					fullPath := filepath.Join(inputPath, entry.Name())
-					if f.isVideoFile(fullPath) {
-						files = append(files, fullPath)
-					}
+		}
+		if th.MinDuration > 0 || th.MaxDuration > 0 {
+			duration, err := durationOf(file)
+			if err == nil && duration > 0 {
+				if duration < th.MinDuration || (th.MaxDuration > 0 && duration > th.MaxDuration) {
+					continue
 				}
 			}
 		}
+		kept = append(kept, file)
+	}
+	return kept, len(files) - len(kept)
+}
+
+// ParseSize parses a file size such as 50M, 1.5GB or 700MiB into bytes.
+// Suffixes K, M, G and T are powers of 1024, like the sizes ffmcli prints;
+// a trailing B or iB is optional.
+func ParseSize(value string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	if strings.HasSuffix(upper, "IB") {
+		upper = strings.TrimSuffix(upper, "IB")
 	} else {
-		if f.isVideoFile(inputPath) {
-			files = append(files, inputPath)
+		upper = strings.TrimSuffix(upper, "B")
+	}
+
+	multiplier := 1.0
+	if n := len(upper); n > 0 {
+		if exp := strings.IndexByte("KMGT", upper[n-1]); exp >= 0 {
+			multiplier = float64(int64(1) << (10 * (exp + 1)))
+			upper = upper[:n-1]
 		}
 	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, NewTranscoderError(ErrorTypeInvalidThreshold,
+			fmt.Sprintf("invalid size '%s' (use a value such as 50M or 1.5G)", value), err)
+	}
+	return int64(n * multiplier), nil
+}
 
-	return files, err
+// ExpandInputs expands shell-style glob patterns among input paths, for
+// patterns the shell passed through unexpanded (quoted, or on Windows).
+// Paths that exist are kept as given even when they contain glob characters,
+// so inputs the shell already expanded are unaffected. Matches are sorted,
+// and an input listed more than once is kept once. A pattern matching
+// nothing is an error.
+func ExpandInputs(inputs []string) ([]string, error) {
+	var expanded []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		key := sourceKey(path)
+		if !seen[key] {
+			seen[key] = true
+			expanded = append(expanded, path)
+		}
+	}
+
+	for _, input := range inputs {
+		if _, err := os.Lstat(input); err == nil || !strings.ContainsAny(input, "*?[") {
+			add(input)
+			continue
+		}
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return nil, NewTranscoderError(ErrorTypeInvalidFilePath,
+				fmt.Sprintf("invalid input pattern '%s'", input), err)
+		}
+		if len(matches) == 0 {
+			return nil, NewTranscoderError(ErrorTypeInvalidFilePath,
+				fmt.Sprintf("no files match input pattern '%s'", input), nil)
+		}
+		for _, match := range matches {
+			add(match)
+		}
+	}
+	return expanded, nil
+}
+
+// sourceKey identifies a file regardless of how its root was spelled
+func sourceKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
 }
 
 // isVideoFile checks if a file is a video file based on extension
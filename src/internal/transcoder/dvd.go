@@ -0,0 +1,154 @@
+package transcoder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// vobPattern matches DVD title set VOBs such as VTS_01_1.VOB
+var vobPattern = regexp.MustCompile(`(?i)^VTS_(\d{2})_(\d)\.VOB$`)
+
+// extrasSizeRatio flags titles smaller than this fraction of the main title as likely extras
+const extrasSizeRatio = 0.1
+
+// DVDTitle is a DVD title set whose VOB parts are concatenated into one output
+type DVDTitle struct {
+	Number int
+	Parts  []string // VOB files in playback order
+	Size   int64    // Combined size of all parts
+}
+
+// Name returns the title set name, e.g. VTS_01
+func (d *DVDTitle) Name() string {
+	return fmt.Sprintf("VTS_%02d", d.Number)
+}
+
+// ConcatURL returns an ffmpeg concat protocol URL joining the title's parts
+func (d *DVDTitle) ConcatURL() string {
+	return "concat:" + strings.Join(d.Parts, "|")
+}
+
+// FindDVDTitles groups VTS_XX_Y.VOB files into titles. Menu VOBs (VIDEO_TS.VOB
+// and VTS_XX_0.VOB) are skipped. The returned warnings describe skipped menus,
+// gaps in part numbering and titles that look like extras.
+func (f *FileDiscovery) FindDVDTitles(inputPath string, recursive bool) ([]*DVDTitle, []string, error) {
+	var vobs []string
+	walk := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != inputPath && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.EqualFold(filepath.Ext(path), ".vob") {
+			vobs = append(vobs, path)
+		}
+		return nil
+	}
+	if err := filepath.Walk(inputPath, walk); err != nil {
+		return nil, nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot scan DVD directory", err)
+	}
+
+	var warnings []string
+	type titleKey struct {
+		dir    string
+		number int
+	}
+	titles := make(map[titleKey]*DVDTitle)
+	partNumbers := make(map[titleKey][]int)
+
+	for _, vob := range vobs {
+		match := vobPattern.FindStringSubmatch(filepath.Base(vob))
+		if match == nil {
+			warnings = append(warnings, fmt.Sprintf("skipping %s (menu or non-title VOB)", vob))
+			continue
+		}
+		number, _ := strconv.Atoi(match[1])
+		part, _ := strconv.Atoi(match[2])
+		if part == 0 {
+			warnings = append(warnings, fmt.Sprintf("skipping %s (title menu VOB)", vob))
+			continue
+		}
+
+		key := titleKey{dir: filepath.Dir(vob), number: number}
+		title, ok := titles[key]
+		if !ok {
+			title = &DVDTitle{Number: number}
+			titles[key] = title
+		}
+		title.Parts = append(title.Parts, vob)
+		partNumbers[key] = append(partNumbers[key], part)
+		if info, err := os.Stat(vob); err == nil {
+			title.Size += info.Size()
+		}
+	}
+
+	keys := make([]titleKey, 0, len(titles))
+	for key := range titles {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].dir != keys[j].dir {
+			return keys[i].dir < keys[j].dir
+		}
+		return keys[i].number < keys[j].number
+	})
+
+	var result []*DVDTitle
+	var largest int64
+	for _, key := range keys {
+		title := titles[key]
+		// VOB parts sort correctly by name since the part number is one digit
+		sort.Strings(title.Parts)
+		numbers := partNumbers[key]
+		sort.Ints(numbers)
+		for i, n := range numbers {
+			if n != i+1 {
+				warnings = append(warnings, fmt.Sprintf("%s in %s is missing part %d; output will be incomplete",
+					title.Name(), key.dir, i+1))
+				break
+			}
+		}
+		largest = max(largest, title.Size)
+		result = append(result, title)
+	}
+
+	for _, title := range result {
+		if float64(title.Size) < float64(largest)*extrasSizeRatio {
+			warnings = append(warnings, fmt.Sprintf("%s is much smaller than the main title and is likely an extra",
+				title.Name()))
+		}
+	}
+
+	return result, warnings, nil
+}
+
+// dvdInputArgs returns input arguments for a concatenated DVD title. VOBs
+// carry streams that only appear later in the file, so probe deeper than usual.
+func dvdInputArgs(title *DVDTitle) []string {
+	return []string{
+		"-fflags", "+genpts",
+		"-analyzeduration", "100M",
+		"-probesize", "100M",
+		"-i", title.ConcatURL(),
+	}
+}
+
+// dvdMapArgs maps the main feature's video plus all audio and subtitle streams
+func dvdMapArgs() []string {
+	return []string{
+		"-map", "0:v:0",
+		"-map", "0:a?",
+		"-map", "0:s?",
+		"-c:s", "copy",
+	}
+}
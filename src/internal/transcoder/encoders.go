@@ -0,0 +1,126 @@
+package transcoder
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// KnownEncoder is a video encoder ffmcli can use, either directly from a
+// preset or as a software fallback
+type KnownEncoder struct {
+	Encoder  string   // FFmpeg encoder name
+	Codec    string   // Friendly codec name
+	Platform Platform // Platform whose presets use it; PlatformSoftware for fallbacks
+}
+
+// knownEncoders lists encoders grouped by platform, in display order
+var knownEncoders = []KnownEncoder{
+	{Encoder: "h264_nvenc", Codec: "H.264", Platform: PlatformNVIDIA},
+	{Encoder: "hevc_nvenc", Codec: "H.265", Platform: PlatformNVIDIA},
+	{Encoder: "av1_nvenc", Codec: "AV1", Platform: PlatformNVIDIA},
+	{Encoder: "h264_videotoolbox", Codec: "H.264", Platform: PlatformAppleSilicon},
+	{Encoder: "hevc_videotoolbox", Codec: "H.265", Platform: PlatformAppleSilicon},
+	{Encoder: "libsvtav1", Codec: "AV1", Platform: PlatformAppleSilicon},
+	{Encoder: "h264_qsv", Codec: "H.264", Platform: PlatformIntelQSV},
+	{Encoder: "hevc_qsv", Codec: "H.265", Platform: PlatformIntelQSV},
+	{Encoder: "av1_qsv", Codec: "AV1", Platform: PlatformIntelQSV},
+	{Encoder: "h264_vaapi", Codec: "H.264", Platform: PlatformAMD},
+	{Encoder: "hevc_vaapi", Codec: "H.265", Platform: PlatformAMD},
+	{Encoder: "av1_vaapi", Codec: "AV1", Platform: PlatformAMD},
+	{Encoder: "h264_amf", Codec: "H.264", Platform: PlatformAMD},
+	{Encoder: "hevc_amf", Codec: "H.265", Platform: PlatformAMD},
+	{Encoder: "av1_amf", Codec: "AV1", Platform: PlatformAMD},
+	{Encoder: "libx264", Codec: "H.264", Platform: PlatformSoftware},
+	{Encoder: "libx265", Codec: "H.265", Platform: PlatformSoftware},
+}
+
+// platformNames are the display names of each platform
+var platformNames = map[Platform]string{
+	PlatformUnknown:      "Unknown",
+	PlatformNVIDIA:       "NVIDIA",
+	PlatformAppleSilicon: "Apple Silicon",
+	PlatformSoftware:     "Software",
+	PlatformIntelQSV:     "Intel QSV",
+	PlatformAMD:          "AMD",
+}
+
+// String returns the platform's display name
+func (p Platform) String() string {
+	if name, ok := platformNames[p]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// Smoke test results reported by EncoderStatus
+const (
+	SmokeTestPassed  = "passed"
+	SmokeTestFailed  = "failed"
+	SmokeTestSkipped = "skipped"
+)
+
+// EncoderStatus describes whether an encoder is usable on this system
+type EncoderStatus struct {
+	Encoder   string `json:"encoder"`
+	Codec     string `json:"codec"`
+	Platform  string `json:"platform"`
+	Available bool   `json:"available"`
+	SmokeTest string `json:"smoke_test"`
+	Error     string `json:"error,omitempty"`
+}
+
+// EncoderStatuses reports the availability of every known encoder. With
+// smokeTest set, each available encoder also encodes a single test frame,
+// which catches encoders that are compiled in but lack a working device.
+func (s *SystemChecker) EncoderStatuses(smokeTest bool) ([]EncoderStatus, error) {
+	statuses := make([]EncoderStatus, 0, len(knownEncoders))
+	for _, known := range knownEncoders {
+		available, err := s.CheckEncoderAvailability(known.Encoder)
+		if err != nil {
+			return nil, err
+		}
+
+		status := EncoderStatus{
+			Encoder:   known.Encoder,
+			Codec:     known.Codec,
+			Platform:  known.Platform.String(),
+			Available: available,
+			SmokeTest: SmokeTestSkipped,
+		}
+		if available && smokeTest {
+			if err := s.SmokeTestEncoder(known.Encoder); err != nil {
+				status.SmokeTest = SmokeTestFailed
+				status.Error = err.Error()
+			} else {
+				status.SmokeTest = SmokeTestPassed
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// SmokeTestEncoder encodes one synthetic frame with an encoder, discarding
+// the output. The frame is large enough for hardware encoders' minimum sizes.
+func (s *SystemChecker) SmokeTestEncoder(encoder string) error {
+	output, err := s.executor.Execute("ffmpeg",
+		"-hide_banner", "-loglevel", "error",
+		"-f", "lavfi", "-i", "color=c=black:s=640x360:d=0.1",
+		"-frames:v", "1",
+		"-c:v", encoder,
+		"-f", "null", "-",
+	)
+	if err != nil {
+		message := strings.TrimSpace(string(output))
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			message = strings.TrimSpace(string(exitErr.Stderr))
+		}
+		if message == "" {
+			message = "test encode failed"
+		}
+		return NewTranscoderError(ErrorTypeEncoderNotFound, encoder+": "+message, err)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package transcoder
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Power assumed for encodes whose draw is not sampled when --energy-watts is
+// not given: a typical desktop CPU under load, and an Apple Silicon SoC
+const (
+	defaultCPUWatts          = 65
+	defaultAppleSiliconWatts = 20
+)
+
+// powerSampleInterval is how often nvidia-smi is asked for the power draw
+var powerSampleInterval = time.Second
+
+// parsePowerDraw reads the watts printed by
+// `nvidia-smi --query-gpu=power.draw --format=csv,noheader,nounits`
+func parsePowerDraw(output string) (float64, error) {
+	line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(output), "\n", 2)[0])
+	watts, err := strconv.ParseFloat(line, 64)
+	if err != nil || watts < 0 {
+		return 0, fmt.Errorf("unexpected power draw %q", line)
+	}
+	return watts, nil
+}
+
+// powerSampler integrates the power draw of one GPU over time
+type powerSampler struct {
+	stop chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	joules  float64
+	watts   float64   // Latest reading, applied until the next one
+	last    time.Time // Time of the latest reading
+	samples int
+}
+
+// startPowerSampler polls the GPU's power draw every interval until Stop
+func startPowerSampler(executor CommandExecutor, gpuIndex int, interval time.Duration) *powerSampler {
+	s := &powerSampler{stop: make(chan struct{}), done: make(chan struct{})}
+	query := func() {
+		output, err := executor.Execute("nvidia-smi", "--query-gpu=power.draw",
+			"--format=csv,noheader,nounits", "-i", strconv.Itoa(gpuIndex))
+		if err != nil {
+			return
+		}
+		if watts, err := parsePowerDraw(string(output)); err == nil {
+			s.record(watts, time.Now())
+		}
+	}
+
+	go func() {
+		defer close(s.done)
+		query()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				query()
+			}
+		}
+	}()
+	return s
+}
+
+// record adds the energy since the previous reading at its power and makes
+// watts the current draw
+func (s *powerSampler) record(watts float64, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.samples > 0 {
+		s.joules += s.watts * at.Sub(s.last).Seconds()
+	}
+	s.watts, s.last = watts, at
+	s.samples++
+}
+
+// Stop ends sampling and returns the energy used in joules. ok is false when
+// no reading succeeded.
+func (s *powerSampler) Stop() (joules float64, ok bool) {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.samples == 0 {
+		return 0, false
+	}
+	return s.joules + s.watts*time.Since(s.last).Seconds(), true
+}
+
+// energyShares splits the wall time between the encodes that run at once
+// with --jobs, so each is charged its part of the shared power draw rather
+// than all of it. elapsed integrates 1/active over time; an encode's share
+// of the time is how much it grew while the encode ran.
+type energyShares struct {
+	mu      sync.Mutex
+	active  int
+	last    time.Time
+	elapsed float64 // Seconds per encode since the first one started
+}
+
+// join counts an encode starting at the given time and returns the mark its
+// share is measured from
+func (s *energyShares) join(at time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance(at)
+	s.active++
+	return s.elapsed
+}
+
+// leave counts an encode that joined at mark as finished and returns its
+// share of the wall time in seconds
+func (s *energyShares) leave(mark float64, at time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance(at)
+	s.active--
+	return s.elapsed - mark
+}
+
+func (s *energyShares) advance(at time.Time) {
+	if s.active > 0 {
+		s.elapsed += at.Sub(s.last).Seconds() / float64(s.active)
+	}
+	s.last = at
+}
+
+// energyMeter estimates the energy one file's encode uses
+type energyMeter struct {
+	sampler *powerSampler // Nil when the power draw is not sampled
+	watts   float64       // Draw assumed when no sample applies
+	shares  *energyShares
+	mark    float64 // Share mark when the encode started
+	start   time.Time
+}
+
+// startEnergyMeter starts measuring a file for --energy; it returns nil
+// when the estimate is off. Hardware encodes on NVIDIA sample the GPU's
+// power draw; everything else assumes a constant draw over the wall time.
+// Files encoded at the same time split the draw.
+func (t *Transcoder) startEnergyMeter() *energyMeter {
+	if !t.config.Energy {
+		return nil
+	}
+	start := time.Now()
+	m := &energyMeter{watts: t.assumedWatts(), shares: &t.energyShares, mark: t.energyShares.join(start), start: start}
+	if !t.config.NoGPU && t.systemChecker.GetPlatform() == PlatformNVIDIA {
+		m.sampler = startPowerSampler(t.systemChecker.executor, t.config.GPUIndex, powerSampleInterval)
+	}
+	return m
+}
+
+// assumedWatts returns the configured draw or the platform's default
+func (t *Transcoder) assumedWatts() float64 {
+	if t.config.EnergyWatts > 0 {
+		return t.config.EnergyWatts
+	}
+	if t.systemChecker.GetPlatform() == PlatformAppleSilicon {
+		return defaultAppleSiliconWatts
+	}
+	return defaultCPUWatts
+}
+
+// finish stops the meter and stores the estimate in result, if it describes
+// an encode
+func (m *energyMeter) finish(result *FileResult) {
+	if m == nil {
+		return
+	}
+	var measured float64
+	var sampled bool
+	if m.sampler != nil {
+		measured, sampled = m.sampler.Stop()
+	}
+	now := time.Now()
+	share := m.shares.leave(m.mark, now)
+	if result == nil || result.Skipped {
+		return
+	}
+	if sampled && result.EncodingMode == EncodingModeHardware {
+		// The GPU's draw covers every encode running on it
+		if wall := now.Sub(m.start).Seconds(); wall > 0 {
+			measured *= min(share/wall, 1)
+		}
+		result.Energy, result.PowerSampled = measured, true
+		return
+	}
+	result.Energy = m.watts * share
+}
+
+// printEnergySummary reports the approximate energy of a batch's encodes,
+// in total and per gigabyte saved
+func printEnergySummary(out io.Writer, results []*FileResult, watts float64) {
+	var joules float64
+	var files, measured int
+	var saved int64
+	for _, result := range results {
+		if result == nil || result.Skipped {
+			continue
+		}
+		files++
+		joules += result.Energy
+		saved += result.InputSize - result.OutputSize
+		if result.PowerSampled {
+			measured++
+		}
+	}
+	if files == 0 {
+		return
+	}
+
+	wh := joules / 3600
+	fmt.Fprintf(out, "\nEnergy (approximate): %.1f Wh for %d file(s)", wh, files)
+	if saved > 0 {
+		fmt.Fprintf(out, ", %.1f Wh per GB saved", wh/(float64(saved)/(1<<30)))
+	}
+	fmt.Fprintln(out)
+	switch {
+	case measured == files:
+		fmt.Fprintln(out, "  from sampled GPU power draw only; CPU and the rest of the system are not included")
+	case measured > 0:
+		fmt.Fprintf(out, "  %d file(s) from sampled GPU power draw, %d assuming %.0f W for the encode time\n", measured, files-measured, watts)
+	default:
+		fmt.Fprintf(out, "  assuming a constant %.0f W for the encode time; set --energy-watts to match your machine\n", watts)
+	}
+}
@@ -11,13 +11,24 @@ type TranscoderError struct {
 type ErrorType string
 
 const (
-	ErrorTypeFFmpegNotFound  ErrorType = "ffmpeg_not_found"
-	ErrorTypeGPUNotAvailable ErrorType = "gpu_not_available"
-	ErrorTypeEncoderNotFound ErrorType = "encoder_not_found"
-	ErrorTypeInvalidPreset   ErrorType = "invalid_preset"
-	ErrorTypeInvalidFilePath ErrorType = "invalid_file_path"
-	ErrorTypeEncodingFailed  ErrorType = "encoding_failed"
-	ErrorTypeFileSystemError ErrorType = "file_system_error"
+	ErrorTypeFFmpegNotFound   ErrorType = "ffmpeg_not_found"
+	ErrorTypeGPUNotAvailable  ErrorType = "gpu_not_available"
+	ErrorTypeEncoderNotFound  ErrorType = "encoder_not_found"
+	ErrorTypeFilterNotFound   ErrorType = "filter_not_found"
+	ErrorTypeInvalidPreset    ErrorType = "invalid_preset"
+	ErrorTypeInvalidFilePath  ErrorType = "invalid_file_path"
+	ErrorTypeEncodingFailed   ErrorType = "encoding_failed"
+	ErrorTypeFileSystemError  ErrorType = "file_system_error"
+	ErrorTypeInvalidPolicy    ErrorType = "invalid_policy"
+	ErrorTypeInvalidFPS       ErrorType = "invalid_fps"
+	ErrorTypeInvalidAudio     ErrorType = "invalid_audio_track"
+	ErrorTypeInvalidSubtitle  ErrorType = "invalid_subtitle"
+	ErrorTypeInvalidContainer ErrorType = "invalid_container"
+	ErrorTypeInvalidArgs      ErrorType = "invalid_ffmpeg_args"
+	ErrorTypeInvalidTime      ErrorType = "invalid_time_range"
+	ErrorTypeInvalidThreshold ErrorType = "invalid_threshold"
+	ErrorTypeInvalidOption    ErrorType = "invalid_option"
+	ErrorTypeInterrupted      ErrorType = "interrupted"
 )
 
 func (e *TranscoderError) Error() string {
@@ -0,0 +1,367 @@
+package transcoder
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rough figures used for presets without history: a mid-range hardware
+// encode speed and a typical size reduction for re-encoded camera footage
+const (
+	defaultThroughputMBps   = 20.0
+	defaultCompressionRatio = 0.5
+)
+
+// PresetHistory aggregates successful encodes of one preset from analytics CSVs
+type PresetHistory struct {
+	Files    int
+	InputMB  float64
+	OutputMB float64
+	Seconds  float64
+}
+
+// ThroughputMBps returns the average encode speed in input MB per second
+func (h *PresetHistory) ThroughputMBps() float64 {
+	if h.Seconds <= 0 {
+		return 0
+	}
+	return h.InputMB / h.Seconds
+}
+
+// CompressionRatio returns the average output size as a fraction of the input
+func (h *PresetHistory) CompressionRatio() float64 {
+	if h.InputMB <= 0 {
+		return 0
+	}
+	return h.OutputMB / h.InputMB
+}
+
+// LoadHistory reads analytics CSVs written with --csv-output and aggregates
+// successful encodes per preset. Columns are matched by header name.
+func LoadHistory(paths ...string) (map[string]*PresetHistory, error) {
+	history := make(map[string]*PresetHistory)
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, NewTranscoderError(ErrorTypeFileSystemError,
+				"cannot open history file "+path, err)
+		}
+		err = readHistory(file, history)
+		file.Close()
+		if err != nil {
+			return nil, NewTranscoderError(ErrorTypeFileSystemError,
+				"invalid history file "+path, err)
+		}
+	}
+	return history, nil
+}
+
+// readHistory adds the successful rows of one analytics CSV to history
+func readHistory(r io.Reader, history map[string]*PresetHistory) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"duration_seconds", "size_before_mb", "size_after_mb", "preset", "status"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("missing column %s", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i := columns[name]; i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if field(row, "status") != "success" {
+			continue
+		}
+		seconds, errSeconds := strconv.ParseFloat(field(row, "duration_seconds"), 64)
+		before, errBefore := strconv.ParseFloat(field(row, "size_before_mb"), 64)
+		after, errAfter := strconv.ParseFloat(field(row, "size_after_mb"), 64)
+		// Skipped files are recorded as successes with no output or time
+		if errSeconds != nil || errBefore != nil || errAfter != nil || seconds <= 0 || before <= 0 || after <= 0 {
+			continue
+		}
+
+		preset := field(row, "preset")
+		entry, ok := history[preset]
+		if !ok {
+			entry = &PresetHistory{}
+			history[preset] = entry
+		}
+		entry.Files++
+		entry.InputMB += before
+		entry.OutputMB += after
+		entry.Seconds += seconds
+	}
+}
+
+// RunEstimate is the projected duration and output size of a run
+type RunEstimate struct {
+	Files        int // Encodes, one per file and preset
+	Skipped      int // Encodes whose output already exists
+	InCodec      int // Encodes --skip-if-codec leaves out
+	InputBytes   int64
+	OutputBytes  int64
+	Duration     time.Duration
+	FromSamples  int           // Files estimated by encoding a sample with --estimate
+	FromHistory  int           // Files estimated from their preset's history
+	FromDefaults int           // Files estimated from the rough defaults
+	Presets      []string      // Presets of files estimated from defaults
+	SampleLength time.Duration // Length of the --estimate samples
+}
+
+// SpaceSaved returns the projected reduction in bytes
+func (e *RunEstimate) SpaceSaved() int64 {
+	return e.InputBytes - e.OutputBytes
+}
+
+// EstimateRun projects how long encoding files takes and how large the
+// outputs get from the per-preset history, falling back to rough defaults
+// for presets that were never recorded. Files whose output exists are not
+// counted unless outputs are overwritten.
+func (t *Transcoder) EstimateRun(files []string, history map[string]*PresetHistory) *RunEstimate {
+	return t.estimateRun(files, history, nil)
+}
+
+// sampleEstimator projects the encode time in seconds and the output size of
+// one file and preset, returning false when it cannot
+type sampleEstimator func(file, output string, preset Preset) (float64, int64, bool)
+
+// estimateRun is EstimateRun, asking sample first for each encode when set
+func (t *Transcoder) estimateRun(files []string, history map[string]*PresetHistory, sample sampleEstimator) *RunEstimate {
+	estimate := &RunEstimate{}
+	missing := make(map[string]bool)
+	var seconds float64
+	for _, file := range files {
+		size, sizeErr := t.inputSize(file)
+		for _, name := range t.presetNamesFor(file) {
+			output, exists, err := t.plannedPresetOutput(file, name)
+			if err == nil && exists {
+				estimate.Skipped++
+				continue
+			}
+			if _, skip := t.skipTargetCodec(file, t.presets[name]); skip {
+				estimate.InCodec++
+				continue
+			}
+			if sizeErr != nil {
+				continue
+			}
+			estimate.Files++
+			estimate.InputBytes += size
+
+			if sample != nil && err == nil {
+				if sampleSeconds, outputBytes, ok := sample(file, output, t.presets[name]); ok {
+					estimate.FromSamples++
+					seconds += sampleSeconds
+					estimate.OutputBytes += outputBytes
+					continue
+				}
+			}
+
+			throughput, ratio := defaultThroughputMBps, defaultCompressionRatio
+			if entry, ok := history[name]; ok && entry.ThroughputMBps() > 0 {
+				throughput, ratio = entry.ThroughputMBps(), entry.CompressionRatio()
+				estimate.FromHistory++
+			} else {
+				estimate.FromDefaults++
+				if !missing[name] {
+					missing[name] = true
+					estimate.Presets = append(estimate.Presets, name)
+				}
+			}
+			seconds += bytesToMB(size) / throughput
+			estimate.OutputBytes += int64(float64(size) * ratio)
+		}
+	}
+	estimate.Duration = time.Duration(seconds * float64(time.Second))
+	return estimate
+}
+
+// PrintDryRun lists the outputs each file would be encoded to and their
+// commands. Files are not probed; commands use results already cached, such
+// as those of --policy, and treat other files as unprobed.
+func (t *Transcoder) PrintDryRun(w io.Writer, files []string) {
+	offline := t.prober.setOffline(true)
+	defer t.prober.setOffline(offline)
+	fmt.Fprintln(w, "Dry run, nothing will be encoded:")
+	for _, file := range files {
+		for _, name := range t.presetNamesFor(file) {
+			output, exists, err := t.plannedPresetOutput(file, name)
+			if err != nil {
+				fmt.Fprintf(w, "  %s: %v\n", file, err)
+				continue
+			}
+			status := ""
+			if exists {
+				status = " (exists, would be skipped)"
+			}
+			fmt.Fprintf(w, "  %s -> %s [%s]%s\n", file, filepath.Base(output), name, status)
+			if !exists {
+				for _, args := range t.dryRunCommands(file, output, t.presets[name]) {
+					fmt.Fprintf(w, "    %s\n", FormatCommand("ffmpeg", args))
+				}
+			}
+		}
+	}
+	if t.config.DeleteSource {
+		fmt.Fprintln(w, "Sources would be deleted once their outputs are verified (--delete-source)")
+	}
+}
+
+// dryRunCommands returns the ffmpeg commands the first encode attempt of a
+// file would run: one, or both passes of a two-pass encode. Fallbacks are
+// not shown, and the pass log directory is a placeholder for the one created
+// at encode time.
+func (t *Transcoder) dryRunCommands(inputPath, outputPath string, preset Preset) [][]string {
+	args := t.buildFFmpegArgs(inputPath, outputPath, preset, t.useHardware(preset))
+	if !t.usesTwoPass(preset, args) || !passLogEncoders[argValue(args, "-c:v")] {
+		return [][]string{args}
+	}
+	passLog := filepath.Join(t.temp.Dir(), "ffmcli-2pass-XXXX", strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath)))
+	first, second := twoPassArgs(args, passLog)
+	return [][]string{first, second}
+}
+
+// plannedOutput returns the output path of a file with its first preset and
+// whether the run would skip it because the output already exists
+func (t *Transcoder) plannedOutput(file string) (string, bool, error) {
+	return t.plannedPresetOutput(file, t.presetNameFor(file))
+}
+
+// plannedPresetOutput is plannedOutput for one of the file's presets
+func (t *Transcoder) plannedPresetOutput(file, name string) (string, bool, error) {
+	preset, exists := t.presets[name]
+	if !exists {
+		return "", false, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("preset %s not found", name), nil)
+	}
+	output := t.pathUtils.GenerateOutputPath(t.outputSource(file), t.config.OutputDir, t.inputBase(file), preset)
+	exists, err := outputExists(output)
+	if err != nil {
+		return output, false, err
+	}
+	return output, exists && !t.config.Overwrite, nil
+}
+
+// PrintEstimate writes the projected totals with their caveats
+func PrintEstimate(w io.Writer, estimate *RunEstimate) {
+	fmt.Fprintf(w, "\nApproximate estimate for %d file(s), %s of input:\n", estimate.Files, FormatBytes(estimate.InputBytes))
+	if estimate.Skipped > 0 {
+		fmt.Fprintf(w, "  %d file(s) with existing outputs are not counted\n", estimate.Skipped)
+	}
+	if estimate.InCodec > 0 {
+		fmt.Fprintf(w, "  %d file(s) already in the target codec are not counted\n", estimate.InCodec)
+	}
+	fmt.Fprintf(w, "  Encoding time: ~%s\n", estimate.Duration.Round(time.Minute))
+	fmt.Fprintf(w, "  Output size:   ~%s (saves ~%s)\n", FormatBytes(estimate.OutputBytes), FormatBytes(estimate.SpaceSaved()))
+	if estimate.FromSamples > 0 {
+		fmt.Fprintf(w, "  %d file(s) projected from encoding a %s sample of each.\n", estimate.FromSamples, estimate.SampleLength)
+	}
+	switch {
+	case estimate.FromSamples > 0 && estimate.FromHistory+estimate.FromDefaults == 0:
+	case estimate.FromDefaults == 0:
+		fmt.Fprintln(w, "  Based on the speed and compression of earlier runs with the same preset.")
+	case estimate.FromHistory == 0:
+		fmt.Fprintln(w, "  No history available; based on rough defaults. Pass --history with earlier --csv-output files for a better estimate.")
+	default:
+		fmt.Fprintf(w, "  %d file(s) use rough defaults (no history for %s).\n",
+			estimate.FromDefaults, strings.Join(estimate.Presets, ", "))
+	}
+	fmt.Fprintln(w, "  Actual results vary with source content, skipped outputs and hardware load.")
+}
+
+// DefaultEstimateSample is how much of each file --estimate encodes
+const DefaultEstimateSample = 30 * time.Second
+
+// EstimateFromSamples projects a run like EstimateRun, but from encoding a
+// sample of each file: length from the middle of the source, with the
+// preset, hardware and options the run would use. Its size and encode time
+// are scaled up to the whole duration. Files whose sample cannot be encoded
+// fall back to the history and defaults. Samples are written to the temp
+// directory and removed as soon as they are measured.
+func (t *Transcoder) EstimateFromSamples(ctx context.Context, files []string, history map[string]*PresetHistory, length time.Duration) *RunEstimate {
+	if length <= 0 {
+		length = DefaultEstimateSample
+	}
+	dir, err := t.temp.CreateDir("estimate-*")
+	if err != nil {
+		t.log.Warnf("%v; estimating from history and defaults only", err)
+		return t.EstimateRun(files, history)
+	}
+	defer os.RemoveAll(dir)
+
+	count := 0
+	estimate := t.estimateRun(files, history, func(file, output string, preset Preset) (float64, int64, bool) {
+		if ctx.Err() != nil {
+			return 0, 0, false
+		}
+		count++
+		t.log.Infof("Encoding estimate sample %d of %s", count, filepath.Base(file))
+		samplePath := filepath.Join(dir, fmt.Sprintf("sample-%d%s", count, filepath.Ext(output)))
+		defer os.Remove(samplePath)
+		return t.sampleEstimate(ctx, file, samplePath, preset, length)
+	})
+	estimate.SampleLength = length
+	return estimate
+}
+
+// sampleEstimate encodes the sample of one file and extrapolates it
+func (t *Transcoder) sampleEstimate(ctx context.Context, file, samplePath string, preset Preset, length time.Duration) (float64, int64, bool) {
+	info, err := t.prober.Probe(t.mediaInput(file))
+	if err != nil {
+		t.log.Warnf("cannot sample %s: %v", file, err)
+		return 0, 0, false
+	}
+	duration := t.trimmedDuration(info.Duration)
+	start, length := sampleWindow(time.Duration(duration*float64(time.Second)), length)
+	if length <= 0 {
+		t.log.Warnf("cannot sample %s: unknown duration", file)
+		return 0, 0, false
+	}
+
+	stderr, elapsed, err := t.runSample(ctx, file, t.useHardware(preset), func(hardware bool) []string {
+		return sampleArgs(t.buildFFmpegArgs(file, samplePath, preset, hardware), start, length)
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			t.log.Warnf("sample encode of %s failed: %s", file, ffmpegErrorLine(stderr))
+		}
+		return 0, 0, false
+	}
+
+	sample, err := os.Stat(samplePath)
+	if err != nil {
+		return 0, 0, false
+	}
+	scale := duration / length.Seconds()
+	return elapsed.Seconds() * scale, int64(float64(sample.Size()) * scale), true
+}
@@ -0,0 +1,110 @@
+package transcoder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitArgs splits a command line into arguments the way a POSIX shell does
+// for plain words. Single quotes keep their contents literally; inside double
+// quotes and bare words a backslash escapes a quote, a backslash or, outside
+// quotes, whitespace. Any other backslash is kept, so Windows paths survive.
+func SplitArgs(line string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inWord  bool
+		quote   rune
+	)
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\' && i+1 < len(runes) && isEscapable(runes[i+1], quote):
+			i++
+			current.WriteRune(runes[i])
+			inWord = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				args = append(args, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, NewTranscoderError(ErrorTypeInvalidArgs,
+			fmt.Sprintf("unterminated %c quote in %q", quote, line), nil)
+	}
+	if inWord {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// isEscapable reports whether a backslash before r escapes it
+func isEscapable(r, quote rune) bool {
+	switch r {
+	case '"', '\'', '\\':
+		return quote == 0 || r != '\''
+	case ' ', '\t':
+		return quote == 0
+	}
+	return false
+}
+
+// reservedFFmpegArgs are options the transcoder owns: extra arguments cannot
+// add inputs or change how the output is written
+var reservedFFmpegArgs = map[string]string{
+	"-i": "inputs are set by ffmcli",
+	"-y": "overwriting is controlled by --overwrite",
+	"-n": "overwriting is controlled by --overwrite",
+}
+
+// ValidateFFmpegArgs checks extra ffmpeg arguments before they are spliced
+// into encode commands. They may override or add to any option, but cannot
+// add inputs or outputs: -i is rejected, and so is a bare word that does not
+// follow an option, which ffmpeg would take for another output file.
+func ValidateFFmpegArgs(args []string) error {
+	afterOption := false
+	for _, arg := range args {
+		if reason, ok := reservedFFmpegArgs[arg]; ok {
+			return NewTranscoderError(ErrorTypeInvalidArgs,
+				fmt.Sprintf("%s cannot be passed through --ffmpeg-args: %s", arg, reason), nil)
+		}
+		if isOption(arg) {
+			afterOption = true
+			continue
+		}
+		if !afterOption {
+			return NewTranscoderError(ErrorTypeInvalidArgs,
+				fmt.Sprintf("'%s' in --ffmpeg-args does not follow an option and would be another output; the output path is set by ffmcli", arg), nil)
+		}
+		afterOption = false
+	}
+	return nil
+}
+
+// isOption reports whether an ffmpeg argument is an option name rather than a
+// value or a file; "-" alone is standard output
+func isOption(arg string) bool {
+	return len(arg) > 1 && arg[0] == '-'
+}
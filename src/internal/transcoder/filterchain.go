@@ -0,0 +1,100 @@
+package transcoder
+
+import (
+	"slices"
+	"strings"
+)
+
+// FilterChain is an ordered list of ffmpeg video filters as passed to -vf.
+// Features that add to the chain prepend or append whole filters instead of
+// editing the rendered string, so they compose in any combination.
+type FilterChain []string
+
+// ParseFilterChain splits a -vf value into its filters. Commas that are
+// escaped with a backslash or inside single quotes belong to a filter's
+// options, as in an escaped subtitles path or a drawtext label.
+func ParseFilterChain(value string) FilterChain {
+	if value == "" {
+		return nil
+	}
+	var chain FilterChain
+	start, quoted := 0, false
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if !quoted {
+				i++ // The escaped character is part of the filter
+			}
+		case '\'':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				chain = append(chain, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(chain, value[start:])
+}
+
+// String renders the chain as a -vf value
+func (c FilterChain) String() string {
+	return strings.Join(c, ",")
+}
+
+// Prepend returns a new chain with filters run before the existing ones
+func (c FilterChain) Prepend(filters ...string) FilterChain {
+	return append(slices.Clone(FilterChain(filters)), c...)
+}
+
+// Append returns a new chain with filters run after the existing ones
+func (c FilterChain) Append(filters ...string) FilterChain {
+	return append(slices.Clone(c), filters...)
+}
+
+// CutSuffix returns the chain without the trailing filters of suffix, and
+// whether it ended with them
+func (c FilterChain) CutSuffix(suffix FilterChain) (FilterChain, bool) {
+	at := len(c) - len(suffix)
+	if at < 0 || !slices.Equal(c[at:], suffix) {
+		return c, false
+	}
+	return c[:at], true
+}
+
+// filterStage adds to or edits the -vf chain of a file's encode
+type filterStage func(inputPath string, chain FilterChain) FilterChain
+
+// filterStages lists the stages that build the -vf chain of an encode with
+// the given video arguments, in the order they run. Presets that set an HDR
+// -color_trc produce HDR and are not tone mapped.
+func (t *Transcoder) filterStages(videoArgs []string) []filterStage {
+	stages := []filterStage{t.autoOrient, t.noUpscale, t.fixAspect}
+	if !hdrTransfers[argValue(videoArgs, "-color_trc")] {
+		stages = append(stages, t.tonemap)
+	}
+	return append(stages, t.crop, t.deinterlace, t.burnSubtitles, t.frameRate)
+}
+
+// filterChainOf returns the -vf chain of ffmpeg arguments, nil without one
+func filterChainOf(args []string) FilterChain {
+	return ParseFilterChain(argValue(args, "-vf"))
+}
+
+// withFilterChain returns a copy of args with the -vf value replaced by the
+// chain, added at the end when absent and dropped when the chain is empty.
+// The caller's slice, often a preset's own, is never modified.
+func withFilterChain(args []string, chain FilterChain) []string {
+	if len(chain) == 0 {
+		result := make([]string, 0, len(args))
+		for i := 0; i < len(args); i++ {
+			if args[i] == "-vf" && i+1 < len(args) {
+				i++
+				continue
+			}
+			result = append(result, args[i])
+		}
+		return result
+	}
+	return setArgValue(append([]string(nil), args...), "-vf", chain.String())
+}
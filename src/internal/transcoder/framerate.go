@@ -0,0 +1,57 @@
+package transcoder
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// namedFrameRates maps ffmpeg's frame rate abbreviations to exact rationals
+var namedFrameRates = map[string]string{
+	"ntsc":      "30000/1001",
+	"pal":       "25",
+	"film":      "24",
+	"ntsc-film": "24000/1001",
+}
+
+// ntscTolerance is how close a decimal rate must be to N*1000/1001 to be
+// treated as the NTSC rational, so 29.97 and 23.976 don't drift
+const ntscTolerance = 0.005
+
+// ParseFrameRate normalizes a frame rate given as an integer (30), a decimal
+// (29.97), a fraction (30000/1001) or a name (ntsc, pal, film, ntsc-film) into
+// the exact rational ffmpeg expects for -r
+func ParseFrameRate(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if named, ok := namedFrameRates[value]; ok {
+		return named, nil
+	}
+
+	rate, ok := new(big.Rat).SetString(value)
+	if !ok || rate.Sign() <= 0 {
+		return "", NewTranscoderError(ErrorTypeInvalidFPS,
+			fmt.Sprintf("invalid frame rate '%s' (use e.g. 25, 29.97, 30000/1001 or ntsc)", value), nil)
+	}
+
+	// Decimals are approximations of NTSC rates more often than not
+	if strings.Contains(value, ".") && !rate.IsInt() {
+		f, _ := rate.Float64()
+		n := math.Round(f * 1.001)
+		if n > 0 && math.Abs(f-n*1000/1001) < ntscTolerance {
+			return fmt.Sprintf("%d/1001", int64(n)*1000), nil
+		}
+	}
+
+	return rate.RatString(), nil
+}
+
+// frameRate puts an fps filter at the end of the chain when --fps is set, so
+// frames are dropped or duplicated after deinterlacing and scaling instead of
+// the output option overriding the filtered rate
+func (t *Transcoder) frameRate(_ string, chain FilterChain) FilterChain {
+	if t.config.FrameRate == "" {
+		return chain
+	}
+	return chain.Append("fps=" + t.config.FrameRate)
+}
@@ -0,0 +1,143 @@
+package transcoder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IsTerminal reports whether a file is an interactive terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// ReviewFiles walks the user through each file, showing its probe summary and
+// asking whether to encode it, skip it or encode it with a different preset.
+// Quitting stops the review; only files approved so far are returned.
+func (t *Transcoder) ReviewFiles(files []string, in io.Reader, out io.Writer) ([]string, error) {
+	reader := bufio.NewReader(in)
+	infos := t.probeAll(files)
+	var approved []string
+
+	for i, file := range files {
+		fmt.Fprintf(out, "\n[%d/%d] %s\n", i+1, len(files), file)
+		fmt.Fprintf(out, "  %s\n", summarizeProbe(infos[file]))
+
+	prompt:
+		for {
+			fmt.Fprintf(out, "  Preset: %s\n", t.presetNameFor(file))
+			fmt.Fprint(out, "  [e]ncode (default), [s]kip, change [p]reset, [q]uit (encode approved files only)? ")
+
+			answer, err := readAnswer(reader)
+			if err != nil {
+				return nil, err
+			}
+
+			switch answer {
+			case "e", "encode", "":
+				approved = append(approved, file)
+				break prompt
+			case "s", "skip":
+				break prompt
+			case "p", "preset":
+				names := append([]string(nil), GetAvailablePresets()...)
+				sort.Strings(names)
+				fmt.Fprintf(out, "  Available presets: %s\n", strings.Join(names, ", "))
+				fmt.Fprint(out, "  Preset for this file: ")
+				name, err := readAnswer(reader)
+				if err != nil {
+					return nil, err
+				}
+				if err := t.SetPresetOverride(file, name); err != nil {
+					fmt.Fprintf(out, "  Unknown preset '%s'\n", name)
+				}
+			case "q", "quit":
+				fmt.Fprintf(out, "\nStopped reviewing; %d file(s) approved\n", len(approved))
+				return approved, nil
+			default:
+				fmt.Fprintf(out, "  Please answer e, s, p or q\n")
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "\n%d of %d file(s) approved\n", len(approved), len(files))
+	return approved, nil
+}
+
+// ChoosePreset shows a numbered menu of the available presets with their
+// resolution, codec and description, and returns the preset picked by number
+// or name. An empty answer picks def.
+func ChoosePreset(in io.Reader, out io.Writer, def string) (string, error) {
+	reader := bufio.NewReader(in)
+	names := GetAvailablePresets()
+	presets := GetPresets()
+
+	fmt.Fprintln(out, "Choose a preset:")
+	for i, name := range names {
+		preset := presets[name]
+		fmt.Fprintf(out, "  %2d) %-16s %-10s %-6s %s\n", i+1, name, preset.Resolution, preset.Codec, preset.Description)
+	}
+
+	for {
+		fmt.Fprintf(out, "Preset number or name [%s]: ", def)
+		answer, err := readAnswer(reader)
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			return def, nil
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(names) {
+			return names[n-1], nil
+		}
+		for _, name := range names {
+			if strings.EqualFold(name, answer) {
+				return name, nil
+			}
+		}
+		fmt.Fprintf(out, "Please enter a number from 1 to %d or a preset name\n", len(names))
+	}
+}
+
+// readAnswer reads one trimmed, lowercased line. End of input is an error so a
+// closed stdin can never be mistaken for approval.
+func readAnswer(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", NewTranscoderError(ErrorTypeFileSystemError, "interactive input closed", err)
+	}
+	return strings.ToLower(strings.TrimSpace(line)), nil
+}
+
+// summarizeProbe renders a one-line description of a probed file
+func summarizeProbe(info *ProbeInfo) string {
+	if info == nil {
+		return "(probe unavailable)"
+	}
+	parts := []string{}
+	if info.VideoCodec != "" {
+		parts = append(parts, fmt.Sprintf("%s %dx%d", codecLabel(info.VideoCodec), info.Width, info.Height))
+	}
+	if info.AudioCodec != "" {
+		parts = append(parts, "audio "+info.AudioCodec)
+	}
+	if info.Duration > 0 {
+		parts = append(parts, fmt.Sprintf("%.0fs", info.Duration))
+	}
+	parts = append(parts, formatBitrate(info.Bitrate))
+	if info.Size > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f MB", float64(info.Size)/(1024*1024)))
+	}
+	if info.FormatName != "" {
+		parts = append(parts, strings.Split(info.FormatName, ",")[0])
+	}
+	return strings.Join(parts, ", ")
+}
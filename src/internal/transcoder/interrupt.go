@@ -0,0 +1,126 @@
+package transcoder
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// interruptHooks run, newest first, when SIGINT or SIGTERM ends the process.
+// A single handler owns the signals so every hook runs before the exit.
+// While shutdown contexts are registered, the first signal cancels them
+// instead, and only a second one ends the process.
+var (
+	interruptMu       sync.Mutex
+	interruptHooks    = map[int]func(){}
+	interruptNext     int
+	interruptOnce     sync.Once
+	shutdownCancels   = map[int]shutdownEntry{}
+	shutdownNext      int
+	shutdownRequested bool
+)
+
+// handleInterrupts installs the signal handler on first use
+func handleInterrupts() {
+	interruptOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			for range signals {
+				if log, ok := requestShutdown(); ok {
+					log.Logf(LogWarn, "\nInterrupted; stopping the current encode and removing its partial output (press Ctrl-C again to quit immediately)")
+					continue
+				}
+				runInterruptHooks()
+				os.Exit(130)
+			}
+		}()
+	})
+}
+
+// shutdownEntry is a registered shutdown context and the logger of the run
+// it belongs to
+type shutdownEntry struct {
+	cancel context.CancelFunc
+	log    *Logger
+}
+
+// requestShutdown cancels the registered shutdown contexts and returns the
+// logger to report the interrupt with, or false when there were none to
+// cancel; a repeated request reports false
+func requestShutdown() (*Logger, bool) {
+	interruptMu.Lock()
+	defer interruptMu.Unlock()
+	if shutdownRequested || len(shutdownCancels) == 0 {
+		return nil, false
+	}
+	shutdownRequested = true
+	var log *Logger
+	for id := range shutdownNext {
+		if entry, ok := shutdownCancels[id]; ok {
+			entry.cancel()
+			if log == nil {
+				log = entry.log
+			}
+		}
+	}
+	return log, true
+}
+
+// ShutdownContext returns a context that the first SIGINT or SIGTERM cancels
+// instead of terminating the process, so a run can stop its encodes and
+// remove partial outputs before it returns. The returned function releases
+// the context.
+func (t *Transcoder) ShutdownContext(parent context.Context) (context.Context, func()) {
+	handleInterrupts()
+	ctx, cancel := context.WithCancel(parent)
+
+	interruptMu.Lock()
+	id := shutdownNext
+	shutdownNext++
+	shutdownCancels[id] = shutdownEntry{cancel: cancel, log: t.log}
+	interruptMu.Unlock()
+
+	return ctx, func() {
+		interruptMu.Lock()
+		delete(shutdownCancels, id)
+		interruptMu.Unlock()
+		cancel()
+	}
+}
+
+// OnInterrupt registers fn to run before an interrupt terminates the process.
+// The returned function unregisters it.
+func OnInterrupt(fn func()) func() {
+	handleInterrupts()
+
+	interruptMu.Lock()
+	id := interruptNext
+	interruptNext++
+	interruptHooks[id] = fn
+	interruptMu.Unlock()
+
+	return func() {
+		interruptMu.Lock()
+		delete(interruptHooks, id)
+		interruptMu.Unlock()
+	}
+}
+
+// runInterruptHooks runs the registered hooks in reverse registration order
+func runInterruptHooks() {
+	interruptMu.Lock()
+	hooks := make([]func(), 0, len(interruptHooks))
+	for id := interruptNext - 1; id >= 0; id-- {
+		if fn, ok := interruptHooks[id]; ok {
+			hooks = append(hooks, fn)
+		}
+	}
+	interruptMu.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+}
@@ -0,0 +1,145 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// RunControl lets the user pause, resume or stop a batch between files, or
+// cancel the file in flight
+type RunControl struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+	quit   bool
+	out    io.Writer
+
+	cancelFile context.CancelFunc // Cancels the file in flight, nil between files
+	skipFile   bool               // The file in flight was skipped with 's'
+}
+
+// NewRunControl creates a run control that reports state changes to out
+func NewRunControl(out io.Writer) *RunControl {
+	c := &RunControl{out: out}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// HandleKey applies a keypress: 'p' pauses dispatching after the current
+// file, 'r' resumes, 's' cancels the current file and moves on, and 'q'
+// finishes the current file and stops
+func (c *RunControl) HandleKey(key byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch key {
+	case 'p', 'P':
+		if c.paused || c.quit {
+			return
+		}
+		c.paused = true
+		fmt.Fprintln(c.out, "\n[paused] No new files will start after the current one; press 'r' to resume, 'q' to quit")
+	case 'r', 'R':
+		if !c.paused || c.quit {
+			return
+		}
+		c.paused = false
+		fmt.Fprintln(c.out, "\n[running] Resumed")
+	case 'q', 'Q':
+		if c.quit {
+			return
+		}
+		c.quit = true
+		fmt.Fprintln(c.out, "\n[quitting] Stopping after the current file")
+	case 's', 'S':
+		if c.cancelFile == nil || c.skipFile {
+			return
+		}
+		c.skipFile = true
+		c.cancelFile()
+		fmt.Fprintln(c.out, "\n[skipping] Cancelling the current file")
+		return
+	default:
+		return
+	}
+	c.cond.Broadcast()
+}
+
+// Wait blocks while the run is paused. It returns false once quitting was requested.
+func (c *RunControl) Wait() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.paused && !c.quit {
+		c.cond.Wait()
+	}
+	return !c.quit
+}
+
+// StartFile returns the context a file's ffmpeg runs under, derived from the
+// run's context; 's' cancels it
+func (c *RunControl) StartFile(parent context.Context) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+	c.mu.Lock()
+	c.cancelFile = cancel
+	c.skipFile = false
+	c.mu.Unlock()
+	return ctx
+}
+
+// FinishFile ends the file started last and reports whether it was skipped
+func (c *RunControl) FinishFile() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancelFile != nil {
+		c.cancelFile()
+		c.cancelFile = nil
+	}
+	skipped := c.skipFile
+	c.skipFile = false
+	return skipped
+}
+
+// Listen applies keypresses read from in until it is closed or fails
+func (c *RunControl) Listen(in io.Reader) {
+	buf := make([]byte, 1)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			c.HandleKey(buf[0])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// StartKeyControls puts the terminal into keypress mode and listens for
+// pause, resume and quit keys. The returned function restores the terminal;
+// it is also restored when the process is interrupted.
+func StartKeyControls(in *os.File, out io.Writer) (*RunControl, func(), error) {
+	restoreMode, err := enableKeypressMode(in)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	var unregister func()
+	restore := func() {
+		once.Do(func() {
+			unregister()
+			restoreMode()
+		})
+	}
+
+	// Keypress mode outlives the process unless undone, so restore it
+	// before an interrupt terminates the run
+	unregister = OnInterrupt(func() { restoreMode() })
+
+	control := NewRunControl(out)
+	go control.Listen(in)
+	fmt.Fprintln(out, "Keys: 'p' pause after the current file, 'r' resume, 's' skip the current file, 'q' quit after the current file")
+	return control, restore, nil
+}
@@ -0,0 +1,177 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLadderCRFs are the quality levels encoded by quality-ladder by default
+var DefaultLadderCRFs = []int{18, 20, 22, 24, 26}
+
+// Ladder metrics comparing each sample to the source
+const (
+	LadderMetricNone = ""
+	LadderMetricVMAF = "vmaf"
+	LadderMetricSSIM = "ssim"
+)
+
+// LadderRung is one sample of a quality ladder
+type LadderRung struct {
+	CRF       int     // Quality the sample was encoded at
+	Path      string  // Sample file
+	SizeBytes int64   // Sample file size
+	Score     float64 // VMAF (0-100) or SSIM (0-1) against the source, when measured
+}
+
+// QualityLadder encodes the same window of an input once per CRF and reports
+// the size of each sample, scored against the source when metric is set.
+// Samples are written to dir as e.g. movie_1080p_h264_crf22.mkv.
+func (t *Transcoder) QualityLadder(inputPath, dir string, crfs []int, start, length time.Duration, metric string) ([]LadderRung, error) {
+	if metric == LadderMetricVMAF {
+		if err := t.RequireFilter("libvmaf", "--metric vmaf"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Each rung overrides --crf; put the configured value back afterwards
+	saved := t.config.CRF
+	defer func() { t.config.CRF = saved }()
+
+	rungs := make([]LadderRung, 0, len(crfs))
+	for _, crf := range crfs {
+		if crf < 0 || crf > MaxCRF {
+			return rungs, NewTranscoderError(ErrorTypeInvalidPreset,
+				fmt.Sprintf("CRF %d is out of range (0-%d)", crf, MaxCRF), nil)
+		}
+		value := crf
+		t.config.CRF = &value
+
+		path, err := t.samplePath(inputPath, dir, fmt.Sprintf("crf%d", crf))
+		if err != nil {
+			return rungs, err
+		}
+		t.log.Infof("Encoding CRF %d sample...", crf)
+		if err := t.EncodeSample(inputPath, path, start, length); err != nil {
+			return rungs, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return rungs, NewTranscoderError(ErrorTypeFileSystemError, "cannot read sample "+path, err)
+		}
+		rung := LadderRung{CRF: crf, Path: path, SizeBytes: info.Size()}
+
+		if metric != LadderMetricNone {
+			rung.Score, err = t.scoreSample(inputPath, path, start, length, metric)
+			if err != nil {
+				return rungs, err
+			}
+		}
+		rungs = append(rungs, rung)
+	}
+	return rungs, nil
+}
+
+// scoreSample compares a sample to the same window of its source
+func (t *Transcoder) scoreSample(inputPath, samplePath string, start, length time.Duration, metric string) (float64, error) {
+	args := metricArgs(samplePath, t.mediaInput(inputPath), start, length, metric)
+	t.log.Debugf("Running: %s", FormatCommand("ffmpeg", args))
+	stderr, err := t.runFFmpeg(context.Background(), inputPath, args, nil)
+	if err != nil {
+		return 0, NewTranscoderError(ErrorTypeEncodingFailed,
+			fmt.Sprintf("%s measurement failed for %s: %s", metric, samplePath, strings.TrimSpace(stderr)), err)
+	}
+
+	var score float64
+	var ok bool
+	if metric == LadderMetricVMAF {
+		score, ok = parseVMAFScore(stderr)
+	} else {
+		score, ok = parseSSIMScore(stderr)
+	}
+	if !ok {
+		return 0, NewTranscoderError(ErrorTypeEncodingFailed,
+			fmt.Sprintf("no %s score in ffmpeg output for %s", metric, samplePath), nil)
+	}
+	return score, nil
+}
+
+// metricArgs builds an ffmpeg command scoring a sample against the matching
+// window of the source. The source is scaled to the sample's size since
+// presets usually change the resolution.
+func metricArgs(samplePath, source string, start, length time.Duration, metric string) []string {
+	args := []string{"-hide_banner", "-i", samplePath}
+	if start > 0 {
+		args = append(args, "-ss", formatSeconds(start))
+	}
+	args = append(args, "-t", formatSeconds(length), "-i", source)
+
+	compare := "ssim"
+	if metric == LadderMetricVMAF {
+		compare = "libvmaf"
+	}
+	graph := "[1:v][0:v]scale2ref=flags=bicubic[ref][dist];" +
+		"[dist]setpts=PTS-STARTPTS[d];[ref]setpts=PTS-STARTPTS[r];" +
+		"[d][r]" + compare
+	return append(args, "-lavfi", graph, "-f", "null", "-")
+}
+
+var (
+	vmafScorePattern = regexp.MustCompile(`VMAF score[:=]\s*([0-9.]+)`)
+	ssimScorePattern = regexp.MustCompile(`SSIM .*All:([0-9.]+)`)
+)
+
+// parseVMAFScore extracts the pooled score from libvmaf's log line
+// "VMAF score: 93.512345"
+func parseVMAFScore(stderr string) (float64, bool) {
+	return lastScore(vmafScorePattern, stderr)
+}
+
+// parseSSIMScore extracts the overall score from the ssim filter's summary
+// "SSIM Y:0.987 (18.9) U:0.991 (20.5) V:0.990 (20.1) All:0.988 (19.3)"
+func parseSSIMScore(stderr string) (float64, bool) {
+	return lastScore(ssimScorePattern, stderr)
+}
+
+func lastScore(pattern *regexp.Regexp, stderr string) (float64, bool) {
+	matches := pattern.FindAllStringSubmatch(stderr, -1)
+	if len(matches) == 0 {
+		return 0, false
+	}
+	score, err := strconv.ParseFloat(matches[len(matches)-1][1], 64)
+	return score, err == nil
+}
+
+// WriteLadderTable prints the size (and score) of each rung, with sizes
+// relative to the first rung so the size/quality curve is easy to read
+func WriteLadderTable(w io.Writer, rungs []LadderRung, metric string) {
+	if len(rungs) == 0 {
+		return
+	}
+	header := fmt.Sprintf("%-5s %12s %9s", "CRF", "Size", "Relative")
+	if metric != LadderMetricNone {
+		header += fmt.Sprintf(" %8s", strings.ToUpper(metric))
+	}
+	fmt.Fprintln(w, header)
+
+	base := rungs[0].SizeBytes
+	for _, rung := range rungs {
+		relative := "-"
+		if base > 0 {
+			relative = fmt.Sprintf("%.0f%%", float64(rung.SizeBytes)/float64(base)*100)
+		}
+		line := fmt.Sprintf("%-5d %12s %9s", rung.CRF, FormatBytes(rung.SizeBytes), relative)
+		switch metric {
+		case LadderMetricVMAF:
+			line += fmt.Sprintf(" %8.2f", rung.Score)
+		case LadderMetricSSIM:
+			line += fmt.Sprintf(" %8.4f", rung.Score)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,79 @@
+package transcoder
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// indexLibrary maps the name of every file under the output directory to
+// where it was found. A missing output directory is an empty library.
+func (t *Transcoder) indexLibrary() (map[string]string, error) {
+	if t.library != nil {
+		return t.library, nil
+	}
+
+	library := make(map[string]string)
+	err := filepath.WalkDir(t.config.OutputDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == t.config.OutputDir && os.IsNotExist(err) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if entry.Type().IsRegular() {
+			if _, seen := library[entry.Name()]; !seen {
+				library[entry.Name()] = path
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot index the output library", err)
+	}
+	t.library = library
+	return library, nil
+}
+
+// FilterNew drops sources that already have an output anywhere under the
+// output directory when --only-new is set. Outputs are matched by the file
+// name the current presets and suffix would give them, so outputs moved
+// into other folders of the library still count. With several presets, a
+// source is kept while any of its outputs is missing.
+func (t *Transcoder) FilterNew(files []string) ([]string, error) {
+	if !t.config.OnlyNew {
+		return files, nil
+	}
+
+	library, err := t.indexLibrary()
+	if err != nil {
+		return nil, err
+	}
+
+	var fresh []string
+	for _, file := range files {
+		var found []string
+		for _, name := range t.presetNamesFor(file) {
+			preset, ok := t.presets[name]
+			if !ok {
+				break
+			}
+			output := filepath.Base(t.pathUtils.GenerateOutputPath(t.outputSource(file), t.config.OutputDir, t.inputBase(file), preset))
+			existing, ok := library[output]
+			if !ok {
+				break
+			}
+			found = append(found, existing)
+		}
+		if len(found) == len(t.presetNamesFor(file)) {
+			t.log.Debugf("Skipping %s (output exists: %s)", file, strings.Join(found, ", "))
+			continue
+		}
+		fresh = append(fresh, file)
+	}
+
+	t.log.Infof("%d of %d file(s) have no output in the library yet", len(fresh), len(files))
+	return fresh, nil
+}
@@ -0,0 +1,23 @@
+package transcoder
+
+import (
+	"fmt"
+	"os"
+)
+
+// linkedOutputWarning describes what else overwriting an existing output
+// changes: the target of a symlink, or every name of a hard-linked file.
+// It returns an empty string for a plain file with a single name.
+func linkedOutputWarning(outputPath string) string {
+	info, err := os.Lstat(outputPath)
+	if err != nil {
+		return ""
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Sprintf("%s is a symlink; overwriting it replaces the file it points to", outputPath)
+	}
+	if links := hardLinkCount(info); links > 1 {
+		return fmt.Sprintf("%s has %d hard links; overwriting it changes the file under every linked name", outputPath, links)
+	}
+	return ""
+}
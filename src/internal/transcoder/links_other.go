@@ -0,0 +1,9 @@
+//go:build !unix
+
+package transcoder
+
+import "os"
+
+func hardLinkCount(info os.FileInfo) uint64 {
+	return 1
+}
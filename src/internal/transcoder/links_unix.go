@@ -0,0 +1,16 @@
+//go:build unix
+
+package transcoder
+
+import (
+	"os"
+	"syscall"
+)
+
+// hardLinkCount returns the number of names linked to a file
+func hardLinkCount(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
@@ -0,0 +1,95 @@
+//go:build unix
+
+package transcoder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindVideoFiles_Symlinks(t *testing.T) {
+	root := t.TempDir()
+	library := t.TempDir()
+	for _, path := range []string{
+		filepath.Join(root, "real.mp4"),
+		filepath.Join(library, "linked", "episode.mkv"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(root, "alias.mp4"):  filepath.Join(root, "real.mp4"),
+		filepath.Join(root, "shows"):      filepath.Join(library, "linked"),
+		filepath.Join(root, "broken.mp4"): filepath.Join(root, "missing.mp4"),
+		filepath.Join(root, "loop"):       root,
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	discovery := NewFileDiscovery()
+	files, err := discovery.FindVideoFiles(root, true)
+	if err != nil {
+		t.Fatalf("FindVideoFiles() error = %v", err)
+	}
+	want := []string{filepath.Join(root, "alias.mp4"), filepath.Join(root, "real.mp4")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("FindVideoFiles() = %v, want %v", files, want)
+	}
+
+	// Following enters the linked directory once and stops at the loop
+	discovery.SetFollowSymlinks(true)
+	files, err = discovery.FindVideoFiles(root, true)
+	if err != nil {
+		t.Fatalf("FindVideoFiles() with symlinks error = %v", err)
+	}
+	want = []string{
+		filepath.Join(root, "alias.mp4"),
+		filepath.Join(root, "real.mp4"),
+		filepath.Join(root, "shows", "episode.mkv"),
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("FindVideoFiles() with symlinks = %v, want %v", files, want)
+	}
+
+	// A symlinked input root is always followed
+	files, err = NewFileDiscovery().FindVideoFiles(filepath.Join(root, "shows"), true)
+	if err != nil || len(files) != 1 {
+		t.Errorf("FindVideoFiles(symlinked root) = %v, %v, want one file", files, err)
+	}
+}
+
+func TestLinkedOutputWarning(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "movie_1080p_h264.mp4")
+	if err := os.WriteFile(output, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if warning := linkedOutputWarning(output); warning != "" {
+		t.Errorf("linkedOutputWarning(single name) = %q, want none", warning)
+	}
+
+	if err := os.Link(output, filepath.Join(dir, "backup.mp4")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+	if warning := linkedOutputWarning(output); !strings.Contains(warning, "2 hard links") {
+		t.Errorf("linkedOutputWarning(hard link) = %q, want a 2 hard links warning", warning)
+	}
+
+	symlink := filepath.Join(dir, "latest.mp4")
+	if err := os.Symlink(output, symlink); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if warning := linkedOutputWarning(symlink); !strings.Contains(warning, "symlink") {
+		t.Errorf("linkedOutputWarning(symlink) = %q, want a symlink warning", warning)
+	}
+}
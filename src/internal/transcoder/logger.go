@@ -0,0 +1,134 @@
+package transcoder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// LogLevel orders log messages by importance; a logger shows its level and above
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota // Commands, ffmpeg output and per-file decisions (--verbose)
+	LogInfo                  // Progress and results
+	LogWarn                  // Problems the run works around (--quiet)
+	LogError                 // Failures
+)
+
+// LogLevels lists the --log-level values in order of LogLevel
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// ParseLogLevel returns the level named by a --log-level value
+func ParseLogLevel(name string) (LogLevel, error) {
+	i := slices.Index(LogLevels, strings.ToLower(name))
+	if i < 0 {
+		return LogInfo, NewTranscoderError(ErrorTypeInvalidOption,
+			fmt.Sprintf("unsupported log level '%s' (use %s)", name, strings.Join(LogLevels, ", ")), nil)
+	}
+	return LogLevel(i), nil
+}
+
+// String returns the --log-level name of the level
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogError {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+	return LogLevels[l]
+}
+
+// Logger writes leveled messages, one whole message at a time, so files
+// encoded in parallel never garble each other's output
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer // nil writes to os.Stdout as it is at the time of writing
+	level LogLevel
+}
+
+// NewLogger creates a logger showing messages at level and above
+func NewLogger(out io.Writer, level LogLevel) *Logger {
+	return &Logger{out: out, level: level}
+}
+
+// Level returns the lowest level the logger shows
+func (l *Logger) Level() LogLevel {
+	return l.level
+}
+
+// Enabled reports whether messages at level are shown
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level >= l.level
+}
+
+// Logf writes a message at level as given, adding the line break when
+// missing. It suits summaries such as the list of failed files that must
+// survive --quiet without reading like a single warning.
+func (l *Logger) Logf(level LogLevel, format string, args ...any) {
+	if !l.Enabled(level) {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	l.Writer().Write([]byte(message))
+}
+
+// Debugf writes a message shown with --verbose or --log-level debug
+func (l *Logger) Debugf(format string, args ...any) { l.Logf(LogDebug, format, args...) }
+
+// Infof writes a message hidden by --quiet
+func (l *Logger) Infof(format string, args ...any) { l.Logf(LogInfo, format, args...) }
+
+// Warnf writes a message prefixed with "Warning: "
+func (l *Logger) Warnf(format string, args ...any) { l.Logf(LogWarn, "Warning: "+format, args...) }
+
+// Errorf writes a message prefixed with "Error: "
+func (l *Logger) Errorf(format string, args ...any) { l.Logf(LogError, "Error: "+format, args...) }
+
+// Writer returns a writer that shares the logger's lock, for output such as
+// the live progress line that is written in pieces rather than whole lines
+func (l *Logger) Writer() io.Writer {
+	return &lockedWriter{logger: l}
+}
+
+// lockedWriter serializes writes with the messages of its logger
+type lockedWriter struct {
+	logger *Logger
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.logger.mu.Lock()
+	defer w.logger.mu.Unlock()
+	out := w.logger.out
+	if out == nil {
+		out = os.Stdout
+	}
+	return out.Write(p)
+}
+
+// ffmpegOutputLines caps the ffmpeg output logged for a failed command; the
+// cause is almost always in the last lines
+const ffmpegOutputLines = 10
+
+// logFFmpegOutput passes ffmpeg's captured stderr to the log. Warnings of a
+// command that succeeded are debug messages; the output of one that failed
+// explains a fallback or failure and is logged as a warning.
+func (t *Transcoder) logFFmpegOutput(inputPath, stderr string, failed bool) {
+	stderr = strings.TrimSpace(stderr)
+	if stderr == "" {
+		return
+	}
+	lines := strings.Split(stderr, "\n")
+	if !failed {
+		t.log.Debugf("FFmpeg output for %s:\n  %s", inputPath, strings.Join(lines, "\n  "))
+		return
+	}
+	if !t.log.Enabled(LogDebug) && len(lines) > ffmpegOutputLines {
+		lines = lines[len(lines)-ffmpegOutputLines:]
+	}
+	t.log.Warnf("ffmpeg failed for %s:\n  %s", inputPath, strings.Join(lines, "\n  "))
+}
@@ -0,0 +1,146 @@
+package transcoder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// loudnormTarget is the EBU R128 target: -23 LUFS integrated loudness, a
+// loudness range of 7 LU and true peaks up to -1 dBTP
+const loudnormTarget = "I=-23:LRA=7:TP=-1"
+
+// loudnormResample follows loudnorm, which upsamples to 192 kHz to find
+// true peaks, with a rate every audio encoder accepts
+const loudnormResample = "aresample=48000"
+
+// normalizeAudioCodec is the codec normalized audio is encoded to when
+// --audio-codec is copy
+const normalizeAudioCodec = "aac"
+
+// singlePassLoudnorm is the filter of --normalize-audio, which adjusts the
+// loudness dynamically as the audio plays
+func singlePassLoudnorm() string {
+	return "loudnorm=" + loudnormTarget + "," + loudnormResample
+}
+
+// LoudnessMeasurement is what the measuring pass of the loudnorm filter
+// reports about a source, in the filter's own units
+type LoudnessMeasurement struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// parseLoudnorm reads the JSON block loudnorm prints at the end of a
+// measuring pass with print_format=json. Silent sources measure -inf, which
+// the second pass cannot apply.
+func parseLoudnorm(stderr string) (LoudnessMeasurement, bool) {
+	start, end := strings.LastIndex(stderr, "{"), strings.LastIndex(stderr, "}")
+	if start < 0 || end < start {
+		return LoudnessMeasurement{}, false
+	}
+	var m LoudnessMeasurement
+	if err := json.Unmarshal([]byte(stderr[start:end+1]), &m); err != nil {
+		return LoudnessMeasurement{}, false
+	}
+	for _, value := range []string{m.InputI, m.InputTP, m.InputLRA, m.InputThresh, m.TargetOffset} {
+		if n, err := strconv.ParseFloat(value, 64); err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+			return LoudnessMeasurement{}, false
+		}
+	}
+	return m, true
+}
+
+// Filter returns the loudnorm filter of the second pass, which applies the
+// measured values with a constant gain where the range allows
+func (m LoudnessMeasurement) Filter() string {
+	return fmt.Sprintf("loudnorm=%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true,%s",
+		loudnormTarget, m.InputI, m.InputTP, m.InputLRA, m.InputThresh, m.TargetOffset, loudnormResample)
+}
+
+// measureLoudness runs the measuring pass of loudnorm over one audio track
+// of the part of the source that is encoded
+func (t *Transcoder) measureLoudness(ctx context.Context, inputPath string, track int) (LoudnessMeasurement, error) {
+	args := []string{"-hide_banner"}
+	args = append(args, t.inputArgs(inputPath)...)
+	args = append(args, t.trimOutputArgs()...)
+	args = append(args, "-map", fmt.Sprintf("0:a:%d", track), "-af", "loudnorm="+loudnormTarget+":print_format=json", "-vn", "-sn", "-f", "null", "-")
+
+	t.log.Debugf("Running: %s", FormatCommand("ffmpeg", args))
+	stderr, err := t.runFFmpeg(ctx, inputPath, args, nil)
+	if err != nil {
+		return LoudnessMeasurement{}, NewTranscoderError(ErrorTypeEncodingFailed,
+			fmt.Sprintf("loudness measurement failed for %s: %s", inputPath, ffmpegErrorLine(stderr)), err)
+	}
+	m, ok := parseLoudnorm(stderr)
+	if !ok {
+		return LoudnessMeasurement{}, NewTranscoderError(ErrorTypeEncodingFailed,
+			"loudness measurement found no usable loudness in "+inputPath, nil)
+	}
+	return m, nil
+}
+
+// chooseLoudnorm settles the loudnorm filter of a file before its commands
+// are built. With --normalize-audio-2pass the source is measured once per
+// file, however many presets encode it. Measuring needs a single audio
+// track; sources keeping several, and those whose measurement fails, are
+// normalized in a single pass.
+func (t *Transcoder) chooseLoudnorm(ctx context.Context, inputPath string) error {
+	if !t.config.NormalizeTwoPass {
+		return nil
+	}
+	t.stateMu.Lock()
+	_, chosen := t.loudnorms[inputPath]
+	t.stateMu.Unlock()
+	if chosen {
+		return nil
+	}
+
+	filter := singlePassLoudnorm()
+	info, err := t.prober.Probe(t.mediaInput(inputPath))
+	track, _ := ParseAudioTrack(t.config.AudioTrack)
+	switch {
+	case err != nil || len(info.AudioCodecs) == 0:
+	case track < 0 && len(info.AudioCodecs) > 1:
+		t.log.Debugf("Normalizing %s in a single pass: it has %d audio tracks and no --audio-track selects one to measure", inputPath, len(info.AudioCodecs))
+	default:
+		m, err := t.measureLoudness(ctx, inputPath, max(track, 0))
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			t.log.Warnf("%v; normalizing in a single pass", err)
+			break
+		}
+		t.log.Debugf("Measured loudness of %s: %s LUFS, range %s LU, peak %s dBTP", inputPath, m.InputI, m.InputLRA, m.InputTP)
+		filter = m.Filter()
+	}
+
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	if t.loudnorms == nil {
+		t.loudnorms = make(map[string]string)
+	}
+	t.loudnorms[inputPath] = filter
+	return nil
+}
+
+// loudnorm returns the loudnorm filter chosen for a file, or "" without
+// --normalize-audio. A dry run shows the single pass without measuring.
+func (t *Transcoder) loudnorm(inputPath string) string {
+	if !t.config.NormalizeAudio {
+		return ""
+	}
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	if filter, ok := t.loudnorms[inputPath]; ok {
+		return filter
+	}
+	return singlePassLoudnorm()
+}
@@ -0,0 +1,123 @@
+package transcoder
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"lukechampine.com/blake3"
+)
+
+// manifestHashes lists the supported manifest algorithms. Each produces lines
+// that the matching tool (sha256sum, sha512sum, b3sum) can verify with -c.
+var manifestHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake3": func() hash.Hash { return blake3.New(32, nil) },
+}
+
+// ManifestAlgorithms returns the supported checksum algorithms, sorted
+func ManifestAlgorithms() []string {
+	names := make([]string, 0, len(manifestHashes))
+	for name := range manifestHashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Manifest appends output checksums in sha256sum format. Each line is synced
+// as it is written, so an interrupted run leaves a valid partial manifest.
+type Manifest struct {
+	mu      sync.Mutex
+	file    *os.File
+	dir     string // Paths are written relative to the manifest's directory
+	newHash func() hash.Hash
+}
+
+// OpenManifest opens a manifest for appending, creating it if needed
+func OpenManifest(path, algorithm string) (*Manifest, error) {
+	newHash, ok := manifestHashes[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, NewTranscoderError(ErrorTypeInvalidOption,
+			fmt.Sprintf("unsupported manifest algorithm '%s' (supported: %s)",
+				algorithm, strings.Join(ManifestAlgorithms(), ", ")), nil)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeInvalidFilePath,
+			"cannot resolve manifest path "+path, err)
+	}
+	file, err := os.OpenFile(absPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot open manifest "+path, err)
+	}
+
+	return &Manifest{file: file, dir: filepath.Dir(absPath), newHash: newHash}, nil
+}
+
+// Add hashes a file and appends its manifest line
+func (m *Manifest) Add(path string) error {
+	sum, err := hashFile(path, m.newHash)
+	if err != nil {
+		return err
+	}
+
+	name := path
+	if absPath, err := filepath.Abs(path); err == nil {
+		if rel, err := filepath.Rel(m.dir, absPath); err == nil {
+			name = rel
+		} else {
+			name = absPath
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := io.WriteString(m.file, manifestLine(sum, filepath.ToSlash(name))); err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError, "cannot write manifest", err)
+	}
+	return m.file.Sync()
+}
+
+// Close closes the manifest file
+func (m *Manifest) Close() error {
+	return m.file.Close()
+}
+
+// manifestLine formats a checksum line the way sha256sum does, escaping
+// backslashes and newlines in the name and flagging such lines with a
+// leading backslash
+func manifestLine(sum, name string) string {
+	if !strings.ContainsAny(name, "\\\n") {
+		return sum + "  " + name + "\n"
+	}
+	name = strings.ReplaceAll(name, "\\", "\\\\")
+	name = strings.ReplaceAll(name, "\n", "\\n")
+	return "\\" + sum + "  " + name + "\n"
+}
+
+// hashFile streams a file through a hash and returns the hex digest
+func hashFile(path string, newHash func() hash.Hash) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", NewTranscoderError(ErrorTypeFileSystemError, "cannot open "+path+" for hashing", err)
+	}
+	defer file.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", NewTranscoderError(ErrorTypeFileSystemError, "cannot read "+path+" for hashing", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
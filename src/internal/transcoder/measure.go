@@ -0,0 +1,137 @@
+package transcoder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// QualityScores are objective quality measurements of an output against its
+// source; a nil score was not measured
+type QualityScores struct {
+	VMAF *float64 // Pooled VMAF mean (0-100)
+	SSIM *float64 // Overall SSIM (0-1)
+}
+
+// vmafLog is the part of libvmaf's JSON log holding the pooled scores
+type vmafLog struct {
+	PooledMetrics map[string]struct {
+		Mean float64 `json:"mean"`
+	} `json:"pooled_metrics"`
+}
+
+// parseVMAFLog extracts the pooled VMAF mean from a libvmaf JSON log
+func parseVMAFLog(data []byte) (float64, bool) {
+	var parsed vmafLog
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return 0, false
+	}
+	vmaf, ok := parsed.PooledMetrics["vmaf"]
+	return vmaf.Mean, ok
+}
+
+// qualityArgs builds an ffmpeg command comparing an output with its source,
+// or the segment from start of length when it was trimmed (zero for none).
+// The output is scaled to the source's size, the reference resolution VMAF
+// expects, before both are scored from their first frames. logPath receives
+// libvmaf's JSON log; an empty logPath leaves VMAF out.
+func qualityArgs(outputPath, source, logPath string, ssim bool, start, length time.Duration) []string {
+	args := []string{"-hide_banner", "-i", outputPath}
+	if start > 0 {
+		args = append(args, "-ss", formatSeconds(start))
+	}
+	if length > 0 {
+		args = append(args, "-t", formatSeconds(length))
+	}
+	args = append(args, "-i", source)
+
+	graph := "[0:v][1:v]scale2ref=flags=bicubic[dist][ref];" +
+		"[dist]setpts=PTS-STARTPTS[d];[ref]setpts=PTS-STARTPTS[r];"
+	vmaf := "libvmaf=log_fmt=json:log_path=" + escapeFilterValue(logPath)
+	switch {
+	case logPath != "" && ssim:
+		graph += "[d]split[d1][d2];[r]split[r1][r2];[d1][r1]" + vmaf + ";[d2][r2]ssim"
+	case logPath != "":
+		graph += "[d][r]" + vmaf
+	default:
+		graph += "[d][r]ssim"
+	}
+	return append(args, "-lavfi", graph, "-f", "null", "-")
+}
+
+// vmafAvailable reports whether ffmpeg has the libvmaf filter, warning once
+// that VMAF scores are skipped when it does not
+func (t *Transcoder) vmafAvailable() bool {
+	t.vmafOnce.Do(func() {
+		if err := t.RequireFilter("libvmaf", "--measure-quality"); err != nil {
+			t.log.Warnf("%v; skipping VMAF scores", err)
+			return
+		}
+		t.vmafUsable = true
+	})
+	return t.vmafUsable
+}
+
+// measureQuality scores an output against its source with --measure-quality
+// and --measure-ssim. A measurement that fails only costs the scores, never
+// the encode, so failures are warnings.
+func (t *Transcoder) measureQuality(ctx context.Context, inputPath, outputPath string) QualityScores {
+	var scores QualityScores
+	vmaf := t.config.MeasureQuality && t.vmafAvailable()
+	if !vmaf && !t.config.MeasureSSIM {
+		return scores
+	}
+
+	var logPath string
+	if vmaf {
+		dir, err := t.temp.CreateDir("ffmcli-vmaf-*")
+		if err != nil {
+			t.log.Warnf("cannot measure quality of %s: %v", filepath.Base(outputPath), err)
+			return scores
+		}
+		defer os.RemoveAll(dir)
+		logPath = filepath.Join(dir, "vmaf.json")
+	}
+
+	args := qualityArgs(outputPath, t.mediaInput(inputPath), logPath, t.config.MeasureSSIM, t.config.TrimStart, t.trimLength())
+	t.log.Debugf("Running: %s", FormatCommand("ffmpeg", args))
+	stderr, err := t.runFFmpeg(ctx, inputPath, args, nil)
+	if err != nil {
+		t.log.Warnf("quality measurement failed for %s: %v", filepath.Base(outputPath), err)
+		t.log.Debugf("FFmpeg output: %s", strings.TrimSpace(stderr))
+		return scores
+	}
+
+	if vmaf {
+		data, err := os.ReadFile(logPath)
+		if score, ok := parseVMAFLog(data); err == nil && ok {
+			scores.VMAF = &score
+		} else {
+			t.log.Warnf("no VMAF score in the libvmaf log for %s", filepath.Base(outputPath))
+		}
+	}
+	if t.config.MeasureSSIM {
+		if score, ok := parseSSIMScore(stderr); ok {
+			scores.SSIM = &score
+		} else {
+			t.log.Warnf("no SSIM score in ffmpeg output for %s", filepath.Base(outputPath))
+		}
+	}
+	return scores
+}
+
+// String renders the measured scores, e.g. "VMAF 95.12, SSIM 0.9871"
+func (s QualityScores) String() string {
+	var parts []string
+	if s.VMAF != nil {
+		parts = append(parts, fmt.Sprintf("VMAF %.2f", *s.VMAF))
+	}
+	if s.SSIM != nil {
+		parts = append(parts, fmt.Sprintf("SSIM %.4f", *s.SSIM))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,52 @@
+package transcoder
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// metadataArgs copies the global tags and chapters of the first input, the
+// source, to the output. ffmpeg already does so for a single input, but
+// external subtitle inputs and explicit -map options make its choice less
+// predictable. --strip-metadata drops both instead.
+func (t *Transcoder) metadataArgs() []string {
+	if t.config.StripMetadata {
+		return []string{"-map_metadata", "-1", "-map_chapters", "-1"}
+	}
+	return []string{"-map_metadata", "0", "-map_chapters", "0"}
+}
+
+// provenanceArgs tags an output with the ffmcli provenance comment. Only the
+// comment key is set, so the other tags carried over from the source stay
+// intact, and a comment the source has is kept with the provenance appended
+// rather than replaced.
+func (t *Transcoder) provenanceArgs(inputPath string, preset Preset) []string {
+	if t.config.NoToolMetadata {
+		return nil
+	}
+	comment := t.toolMetadataComment(preset)
+	if !t.config.StripMetadata {
+		if info, err := t.prober.Probe(t.mediaInput(inputPath)); err == nil {
+			if source := strings.TrimSpace(info.Comment); source != "" {
+				comment = source + "; " + comment
+			}
+		}
+	}
+	return []string{"-metadata", "comment=" + comment}
+}
+
+// preserveModTime gives an output the modification time of its source, so
+// libraries sorting by date keep the order the footage was recorded in
+func preserveModTime(inputPath, outputPath string) error {
+	info, err := os.Stat(inputPath)
+	if err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot read modification time of "+inputPath, err)
+	}
+	if err := os.Chtimes(outputPath, time.Time{}, info.ModTime()); err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot set modification time of "+outputPath, err)
+	}
+	return nil
+}
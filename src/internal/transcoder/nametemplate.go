@@ -0,0 +1,155 @@
+package transcoder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultNameTemplate is the --name-template of outputs named like
+// movie_1080p_h265.mkv
+const DefaultNameTemplate = "{name}_{preset}"
+
+// maxTemplateName caps an expanded name template, leaving room in a path
+// component for the suffix and extension
+const maxTemplateName = 180
+
+// maxTemplateLiteral caps the fixed text of a name template, so the source
+// name always keeps a useful share of maxTemplateName
+const maxTemplateLiteral = 100
+
+// NameTemplateFields lists the placeholders of --name-template
+var NameTemplateFields = []string{"name", "preset", "codec", "resolution", "height", "ext", "date"}
+
+// nameTemplatePlaceholder matches a {field} of a name template
+var nameTemplatePlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// ValidateNameTemplate checks that a template only uses known placeholders
+// and has no stray braces
+func ValidateNameTemplate(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return NewTranscoderError(ErrorTypeInvalidFilePath, "the name template is empty", nil)
+	}
+	for _, match := range nameTemplatePlaceholder.FindAllStringSubmatch(template, -1) {
+		if !slices.Contains(NameTemplateFields, match[1]) {
+			return NewTranscoderError(ErrorTypeInvalidFilePath,
+				fmt.Sprintf("unknown placeholder {%s} in name template '%s' (use %s)", match[1], template, templateFieldList()), nil)
+		}
+	}
+	literal := nameTemplatePlaceholder.ReplaceAllString(template, "")
+	if strings.ContainsAny(literal, "{}") {
+		return NewTranscoderError(ErrorTypeInvalidFilePath,
+			fmt.Sprintf("unbalanced brace in name template '%s'", template), nil)
+	}
+	if len(literal) > maxTemplateLiteral {
+		return NewTranscoderError(ErrorTypeInvalidFilePath,
+			fmt.Sprintf("name template '%s' has more than %d characters of fixed text", template, maxTemplateLiteral), nil)
+	}
+	return nil
+}
+
+// templateFieldList returns the placeholders as "{name}, {preset}, ..."
+func templateFieldList() string {
+	fields := make([]string, len(NameTemplateFields))
+	for i, field := range NameTemplateFields {
+		fields[i] = "{" + field + "}"
+	}
+	return strings.Join(fields, ", ")
+}
+
+// SetNameTemplate sets the template generated output names are expanded
+// from, before the suffix and extension. The empty template keeps the
+// default naming.
+func (p *PathUtils) SetNameTemplate(template string) error {
+	if template == "" || template == DefaultNameTemplate {
+		p.template = ""
+		return nil
+	}
+	if err := ValidateNameTemplate(template); err != nil {
+		return err
+	}
+	p.template = template
+	return nil
+}
+
+// expandNameTemplate fills in the name template for a source and preset.
+// The source name is shortened to keep the whole name within
+// maxTemplateName, so a long name never cuts off the fields after it.
+func (p *PathUtils) expandNameTemplate(inputPath string, preset Preset) string {
+	filename := filepath.Base(inputPath)
+	ext := filepath.Ext(filename)
+	_, height, _ := strings.Cut(preset.Resolution, "x")
+	codec := preset.Codec
+	if codec == "" {
+		codec = preset.Encoder
+	}
+	values := map[string]string{
+		"preset":     preset.Name,
+		"codec":      strings.ToLower(strings.NewReplacer(".", "", " ", "", "-", "").Replace(codec)),
+		"resolution": preset.Resolution,
+		"height":     height,
+		"ext":        strings.ToLower(strings.TrimPrefix(ext, ".")),
+		"date":       sourceDate(inputPath),
+	}
+	expand := func() string {
+		return nameTemplatePlaceholder.ReplaceAllStringFunc(p.template, func(placeholder string) string {
+			return values[placeholder[1:len(placeholder)-1]]
+		})
+	}
+
+	name := p.SanitizeFilename(strings.TrimSuffix(filename, ext))
+	if budget := maxTemplateName - len(expand()); len(name) > budget {
+		name = strings.ToValidUTF8(name[:max(budget, 1)], "")
+	}
+	values["name"] = name
+
+	expanded := strings.Trim(p.SanitizeFilename(expand()), "_- ")
+	if expanded == "" {
+		return name
+	}
+	return expanded
+}
+
+// sourceDate returns the modification date of a source as YYYY-MM-DD. The
+// source's date rather than today's keeps the name of an output the same
+// when a later run looks for it.
+func sourceDate(inputPath string) string {
+	if info, err := os.Stat(inputPath); err == nil {
+		return info.ModTime().Format(time.DateOnly)
+	}
+	return time.Now().Format(time.DateOnly)
+}
+
+// CheckOutputNames refuses a batch in which the --name-template, or the
+// default one, gives two encodes the same output path: distinct sources, or
+// several presets of one source, that the template does not tell apart, such
+// as movie.mp4 and movie.avi under {name}_{preset}. Without the check the
+// later encode would skip or overwrite the earlier one's output.
+func (t *Transcoder) CheckOutputNames(files []string) error {
+	template := t.config.NameTemplate
+	if template == "" {
+		template = DefaultNameTemplate
+	}
+	owners := make(map[string]string)
+	for _, file := range files {
+		for _, name := range t.presetNamesFor(file) {
+			preset, ok := t.presets[name]
+			if !ok {
+				continue
+			}
+			output := filepath.Clean(t.pathUtils.GenerateOutputPath(t.outputSource(file), t.config.OutputDir, t.inputBase(file), preset))
+			owner := fmt.Sprintf("%s (preset %s)", file, name)
+			if other, taken := owners[output]; taken {
+				return NewTranscoderError(ErrorTypeInvalidFilePath,
+					fmt.Sprintf("name template '%s' gives %s and %s the same output %s; add a placeholder that tells them apart, such as {name}, {preset} or {ext}",
+						template, other, owner, output), nil)
+			}
+			owners[output] = owner
+		}
+	}
+	return nil
+}
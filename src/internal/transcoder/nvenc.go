@@ -0,0 +1,83 @@
+package transcoder
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ranges accepted for the NVENC tuning options
+const (
+	MaxNVENCLookahead = 32
+	MaxNVENCBFrames   = 4
+)
+
+// nvencAQModes maps --aq values to the NVENC adaptive quantization switches
+var nvencAQModes = map[string][]string{
+	"spatial":  {"-spatial-aq", "1"},
+	"temporal": {"-temporal-aq", "1"},
+	"both":     {"-spatial-aq", "1", "-temporal-aq", "1"},
+}
+
+// NVENCAQModes returns the values accepted by --aq
+func NVENCAQModes() []string {
+	modes := make([]string, 0, len(nvencAQModes))
+	for mode := range nvencAQModes {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	return modes
+}
+
+// ValidateNVENCOptions checks the NVENC tuning options; nil values are unset
+func ValidateNVENCOptions(lookahead, bframes *int, aq string) error {
+	if lookahead != nil && (*lookahead < 0 || *lookahead > MaxNVENCLookahead) {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("lookahead %d is out of range (0-%d frames)", *lookahead, MaxNVENCLookahead), nil)
+	}
+	if bframes != nil && (*bframes < 0 || *bframes > MaxNVENCBFrames) {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("B-frames %d is out of range (0-%d)", *bframes, MaxNVENCBFrames), nil)
+	}
+	if _, ok := nvencAQModes[aq]; aq != "" && !ok {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("unknown AQ mode '%s' (use %s)", aq, strings.Join(NVENCAQModes(), ", ")), nil)
+	}
+	return nil
+}
+
+// isNVENCEncoder reports whether an encoder runs on NVENC
+func isNVENCEncoder(encoder string) bool {
+	return strings.HasSuffix(encoder, "_nvenc")
+}
+
+// hasNVENCOptions reports whether any NVENC tuning option is configured
+func (t *Transcoder) hasNVENCOptions() bool {
+	return t.config.NVENCLookahead != nil || t.config.NVENCBFrames != nil || t.config.NVENCAQ != ""
+}
+
+// nvencArgs returns the configured lookahead, B-frame and AQ options for an
+// NVENC encoder. Other encoders get none, with a warning printed once per run
+// since a software fallback or another platform would otherwise drop them silently.
+func (t *Transcoder) nvencArgs(encoder string) []string {
+	if !t.hasNVENCOptions() {
+		return nil
+	}
+	if !isNVENCEncoder(encoder) {
+		t.nvencWarnOnce.Do(func() {
+			t.log.Warnf("--lookahead, --bframes and --aq only apply to NVENC; ignoring them for %s", encoder)
+		})
+		return nil
+	}
+
+	var args []string
+	if t.config.NVENCLookahead != nil {
+		args = append(args, "-rc-lookahead", strconv.Itoa(*t.config.NVENCLookahead))
+	}
+	if t.config.NVENCBFrames != nil {
+		args = append(args, "-bf", strconv.Itoa(*t.config.NVENCBFrames))
+	}
+	args = append(args, nvencAQModes[t.config.NVENCAQ]...)
+	return args
+}
@@ -0,0 +1,53 @@
+package transcoder
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// orientScale swaps the target dimensions of a scale filter in the chain so
+// a landscape preset produces a portrait output for a portrait source and
+// vice versa. Chains without a fixed-size scale filter are returned unchanged.
+func orientScale(chain FilterChain, portrait bool) FilterChain {
+	filters := slices.Clone(chain)
+	changed := false
+	for i, filter := range filters {
+		if !strings.HasPrefix(filter, "scale=") {
+			continue
+		}
+		dims := strings.SplitN(strings.TrimPrefix(filter, "scale="), ":", 3)
+		if len(dims) < 2 {
+			continue
+		}
+		width, werr := strconv.Atoi(dims[0])
+		height, herr := strconv.Atoi(dims[1])
+		if werr != nil || herr != nil || width <= 0 || height <= 0 {
+			continue
+		}
+		if (height > width) == portrait || width == height {
+			continue
+		}
+		dims[0], dims[1] = dims[1], dims[0]
+		filters[i] = "scale=" + strings.Join(dims, ":")
+		changed = true
+	}
+	if !changed {
+		return chain
+	}
+	return filters
+}
+
+// autoOrient matches the preset's scale orientation to the source when
+// --auto-orient is set. Sources that cannot be probed keep the preset scaling.
+func (t *Transcoder) autoOrient(inputPath string, chain FilterChain) FilterChain {
+	if !t.config.AutoOrient {
+		return chain
+	}
+	info, err := t.prober.Probe(t.mediaInput(inputPath))
+	if err != nil || info.Width == 0 || info.Height == 0 {
+		t.log.Debugf("Cannot determine orientation of %s, keeping preset scaling", inputPath)
+		return chain
+	}
+	return orientScale(chain, info.IsPortrait())
+}
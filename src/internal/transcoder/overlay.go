@@ -0,0 +1,57 @@
+package transcoder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// debugOverlayFilter builds a drawtext filter that burns the frame number,
+// timestamp and the encode's rate settings into the picture, so samples of
+// different settings can be told apart on screen
+func debugOverlayFilter(args []string) string {
+	label := []string{"frame %{frame_num}", `%{pts\:hms}`}
+	for _, flag := range []string{"-crf", "-cq", "-q:v", "-global_quality"} {
+		if value := argValue(args, flag); value != "" {
+			label = append(label, strings.TrimPrefix(flag, "-")+" "+value)
+			break
+		}
+	}
+	if bitrate := argValue(args, "-b:v"); bitrate != "" {
+		rate := "b:v " + bitrate
+		if maxrate := argValue(args, "-maxrate"); maxrate != "" {
+			rate += " max " + maxrate
+		}
+		label = append(label, rate)
+	}
+	if encoder := argValue(args, "-c:v"); encoder != "" {
+		label = append(label, encoder)
+	}
+
+	return fmt.Sprintf("drawtext=text='%s':x=10:y=10:fontsize=h/28:fontcolor=white:box=1:boxcolor=black@0.6:boxborderw=6",
+		strings.Join(label, "  "))
+}
+
+// withDebugOverlay appends the debug overlay to the end of an ffmpeg
+// command's -vf chain, after any scaling so the text is sized for the output
+func withDebugOverlay(args []string) []string {
+	overlay := debugOverlayFilter(args)
+
+	if chain := filterChainOf(args); len(chain) > 0 {
+		// The overlay is drawn in software, before any VAAPI upload
+		upload := ParseFilterChain(vaapiUploadFilter)
+		if base, ok := chain.CutSuffix(upload); ok {
+			return withFilterChain(args, base.Append(overlay).Append(upload...))
+		}
+		return withFilterChain(args, chain.Append(overlay))
+	}
+
+	// No filter chain yet: add one just before "-y OUTPUT"
+	at := len(args)
+	if at >= 2 && args[at-2] == "-y" {
+		at -= 2
+	}
+	result := make([]string, 0, len(args)+2)
+	result = append(result, args[:at]...)
+	result = append(result, "-vf", overlay)
+	return append(result, args[at:]...)
+}
@@ -0,0 +1,66 @@
+package transcoder
+
+import "sync"
+
+// maxHardwareJobs caps --jobs when files are encoded in hardware. Consumer
+// NVIDIA drivers allow only a few concurrent NVENC sessions (historically 3)
+// and further encodes fail to open the encoder; VideoToolbox shares a single
+// media engine and gains nothing from more.
+const maxHardwareJobs = 3
+
+// jobCount returns how many of files to encode at once: --jobs, at most one
+// per file, and at most maxHardwareJobs when the preset uses a hardware
+// encoder
+func (t *Transcoder) jobCount(files int) int {
+	jobs := max(t.config.Parallelism, 1)
+	if jobs > maxHardwareJobs && t.encodesInHardware() {
+		t.jobsWarnOnce.Do(func() {
+			t.log.Warnf("--jobs %d lowered to %d; hardware encoders limit concurrent sessions", jobs, maxHardwareJobs)
+		})
+		jobs = maxHardwareJobs
+	}
+	return max(min(jobs, files), 1)
+}
+
+// encodesInHardware reports whether any configured preset runs on this
+// machine's hardware encoder
+func (t *Transcoder) encodesInHardware() bool {
+	platform := t.systemChecker.GetPlatform()
+	if platform == PlatformSoftware {
+		return false
+	}
+	for _, name := range t.config.PresetNames() {
+		preset, ok := t.presets[name]
+		if ok && t.useHardware(preset) && (preset.Platform == platform || preset.Platform == Platform(0)) {
+			return true
+		}
+	}
+	return false
+}
+
+// runJobs calls work for the indexes 0 to n-1 in order, with at most jobs
+// running at a time. next is asked before each index is started, once a
+// worker is free, and returning false stops dispatching; work already started
+// runs to completion. It returns how many indexes were started.
+func runJobs(n, jobs int, next func(i int) bool, work func(i int)) int {
+	slots := make(chan struct{}, max(jobs, 1))
+	var wg sync.WaitGroup
+	started := 0
+	for i := 0; i < n; i++ {
+		slots <- struct{}{}
+		if !next(i) {
+			break
+		}
+		started++
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			work(i)
+		}()
+	}
+	wg.Wait()
+	return started
+}
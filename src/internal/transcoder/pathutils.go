@@ -7,15 +7,64 @@ import (
 	"strings"
 )
 
+// maxSuffixLength caps --suffix so names stay within filesystem limits
+const maxSuffixLength = 40
+
+// outputExtension is the extension of Matroska outputs, the default container
+const outputExtension = ".mkv"
+
 // PathUtils provides utility functions for file paths
-type PathUtils struct{}
+type PathUtils struct {
+	suffix    string // Appended to generated output names after the preset name
+	extension string // Replaces the .mkv extension of generated names (empty keeps it)
+	template  string // --name-template expanded into generated names (empty for DefaultNameTemplate)
+}
 
 // NewPathUtils creates a new PathUtils instance
 func NewPathUtils() *PathUtils {
 	return &PathUtils{}
 }
 
-// GenerateOutputPath generates the output file path based on input and preset
+// SetSuffix sets a suffix appended to generated output names, e.g. crf20 for
+// movie_1080p_h265_crf20.mkv. It returns the sanitized suffix actually used.
+func (p *PathUtils) SetSuffix(suffix string) string {
+	p.suffix = p.SanitizeSuffix(suffix)
+	return p.suffix
+}
+
+// SanitizeSuffix cleans a suffix like a filename, turns spaces into
+// underscores and caps its length without splitting a character
+func (p *PathUtils) SanitizeSuffix(suffix string) string {
+	cleaned := p.SanitizeFilename(strings.ReplaceAll(suffix, " ", "_"))
+	if len(cleaned) > maxSuffixLength {
+		cleaned = strings.ToValidUTF8(cleaned[:maxSuffixLength], "")
+	}
+	return strings.Trim(cleaned, "_-. ")
+}
+
+// SetExtension makes generated output names end in ext instead of .mkv. The
+// container written is chosen separately.
+func (p *PathUtils) SetExtension(ext string) {
+	p.extension = ext
+}
+
+// NormalizeExtension turns "mp4" or ".MP4" into ".mp4" and rejects anything
+// that is not a plain alphanumeric extension
+func NormalizeExtension(ext string) (string, error) {
+	trimmed := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+	if trimmed == "" || len(trimmed) > 10 {
+		return "", fmt.Errorf("invalid extension '%s'", ext)
+	}
+	for _, r := range trimmed {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return "", fmt.Errorf("invalid extension '%s': use letters and digits only", ext)
+		}
+	}
+	return "." + trimmed, nil
+}
+
+// GenerateOutputPath generates the output file path based on input and preset,
+// named after the --name-template when one is set
 func (p *PathUtils) GenerateOutputPath(inputPath, outputDir, inputBasePath string, preset Preset) string {
 	filename := filepath.Base(inputPath)
 	nameWithoutExt := strings.TrimSuffix(filename, filepath.Ext(filename))
@@ -23,10 +72,20 @@ func (p *PathUtils) GenerateOutputPath(inputPath, outputDir, inputBasePath strin
 	// Sanitize filename - replace problematic characters and limit length
 	nameWithoutExt = p.SanitizeFilename(nameWithoutExt)
 
-	var ext string = ".mkv"
+	ext := outputExtension
+	if p.extension != "" {
+		ext = p.extension
+	}
 
 	// Create shorter, cleaner filename
-	outputFilename := fmt.Sprintf("%s_%s%s", nameWithoutExt, preset.Name, ext)
+	stem := fmt.Sprintf("%s_%s", nameWithoutExt, preset.Name)
+	if p.template != "" {
+		stem = p.expandNameTemplate(inputPath, preset)
+	}
+	outputFilename := stem + ext
+	if p.suffix != "" {
+		outputFilename = fmt.Sprintf("%s_%s%s", stem, p.suffix, ext)
+	}
 
 	// If input is a directory, maintain directory structure
 	if info, err := os.Stat(inputBasePath); err == nil && info.IsDir() {
@@ -39,6 +98,21 @@ func (p *PathUtils) GenerateOutputPath(inputPath, outputDir, inputBasePath strin
 	return filepath.Join(outputDir, outputFilename)
 }
 
+// IsSamePath reports whether two paths refer to the same file, comparing
+// absolute paths and, when both exist, the underlying files (which catches
+// symlinks and case-insensitive filesystems)
+func (p *PathUtils) IsSamePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA == nil && errB == nil && absA == absB {
+		return true
+	}
+
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
 // SanitizeWindowsPath handles long Windows paths and special characters
 func (p *PathUtils) SanitizeWindowsPath(path string) string {
 	// On Windows, use UNC path for long paths
@@ -0,0 +1,94 @@
+package transcoder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// errOwnershipUnsupported is returned on platforms without Unix-style group ownership
+var errOwnershipUnsupported = errors.New("file ownership is not supported on this platform")
+
+// ResolveOutputGroup looks up the configured output group. On platforms without
+// Unix ownership the group is ignored with a warning.
+func (t *Transcoder) ResolveOutputGroup() error {
+	t.outputGID = -1
+	if t.config.OutputGroup == "" {
+		return nil
+	}
+
+	gid, err := lookupGroupID(t.config.OutputGroup)
+	if errors.Is(err, errOwnershipUnsupported) {
+		t.log.Warnf("--output-group is ignored: %v", err)
+		return nil
+	}
+	if err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError,
+			fmt.Sprintf("unknown output group '%s'", t.config.OutputGroup), err)
+	}
+	t.outputGID = gid
+	return nil
+}
+
+// applyOutputPermissions applies the configured mode and group to a file or
+// directory created by ffmcli
+func (t *Transcoder) applyOutputPermissions(path string, isDir bool) error {
+	if t.config.OutputMode != 0 {
+		mode := t.config.OutputMode
+		if isDir {
+			mode = dirModeFor(mode)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return NewTranscoderError(ErrorTypeFileSystemError,
+				"failed to set permissions on "+path, err)
+		}
+	}
+	if t.outputGID >= 0 {
+		if err := setGroupID(path, t.outputGID); err != nil {
+			return NewTranscoderError(ErrorTypeFileSystemError,
+				"failed to set group on "+path, err)
+		}
+	}
+	return nil
+}
+
+// ensureOutputDir creates a directory tree and applies the configured
+// permissions to every directory that did not exist before
+func (t *Transcoder) ensureOutputDir(dir string) error {
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if info, err := os.Stat(d); err == nil {
+			// MkdirAll reports a file in the way only as "not a directory"
+			if !info.IsDir() {
+				return NewTranscoderError(ErrorTypeFileSystemError,
+					fmt.Sprintf("cannot create output directory %s: %s is a file", dir, d), nil)
+			}
+			break
+		}
+		created = append(created, d)
+		if parent := filepath.Dir(d); parent == d {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError,
+			"failed to create output directory", err)
+	}
+
+	for _, d := range created {
+		if err := t.applyOutputPermissions(d, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirModeFor derives a directory mode from a file mode by adding the execute
+// (search) bit wherever the read bit is set, so 0644 becomes 0755
+func dirModeFor(mode os.FileMode) os.FileMode {
+	perm := mode.Perm()
+	perm |= (perm & 0444) >> 2
+	return perm
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package transcoder
+
+func lookupGroupID(group string) (int, error) {
+	return -1, errOwnershipUnsupported
+}
+
+func setGroupID(path string, gid int) error {
+	return errOwnershipUnsupported
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package transcoder
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// lookupGroupID resolves a group name or numeric ID
+func lookupGroupID(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// setGroupID changes the group of a path, leaving the owner unchanged
+func setGroupID(path string, gid int) error {
+	return os.Lchown(path, -1, gid)
+}
@@ -0,0 +1,78 @@
+//go:build unix
+
+package transcoder
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestApplyOutputPermissions_Mode(t *testing.T) {
+	dir := t.TempDir()
+	tr := New(Config{InputPath: dir, OutputDir: dir, OutputMode: 0640})
+	if err := tr.ResolveOutputGroup(); err != nil {
+		t.Fatalf("ResolveOutputGroup() error = %v", err)
+	}
+
+	nested := filepath.Join(dir, "shows", "season1")
+	if err := tr.ensureOutputDir(nested); err != nil {
+		t.Fatalf("ensureOutputDir() error = %v", err)
+	}
+	for _, d := range []string{filepath.Join(dir, "shows"), nested} {
+		info, err := os.Stat(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != 0750 {
+			t.Errorf("directory %s mode = %o, want 750", d, got)
+		}
+	}
+
+	output := filepath.Join(nested, "episode.mkv")
+	if err := os.WriteFile(output, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.applyOutputPermissions(output, false); err != nil {
+		t.Fatalf("applyOutputPermissions() error = %v", err)
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0640 {
+		t.Errorf("output mode = %o, want 640", got)
+	}
+}
+
+func TestApplyOutputPermissions_Group(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(output, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing to our own primary group is always permitted
+	gid := os.Getgid()
+	tr := New(Config{InputPath: dir, OutputDir: dir, OutputGroup: strconv.Itoa(gid)})
+	if err := tr.ResolveOutputGroup(); err != nil {
+		t.Fatalf("ResolveOutputGroup() error = %v", err)
+	}
+	if err := tr.applyOutputPermissions(output, false); err != nil {
+		t.Fatalf("applyOutputPermissions() error = %v", err)
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Gid) != gid {
+		t.Errorf("output gid = %d, want %d", stat.Gid, gid)
+	}
+
+	tr = New(Config{InputPath: dir, OutputDir: dir, OutputGroup: "no-such-group-ffmcli"})
+	if err := tr.ResolveOutputGroup(); err == nil {
+		t.Error("ResolveOutputGroup() expected error for unknown group")
+	}
+}
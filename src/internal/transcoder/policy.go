@@ -0,0 +1,240 @@
+package transcoder
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Policy selects files that violate a quality/codec policy. It holds one or
+// more expressions; a file matches when any expression matches, and an
+// expression matches when all of its comma-separated conditions hold.
+type Policy struct {
+	expressions [][]policyCondition
+}
+
+type policyCondition struct {
+	field string
+	op    string
+	text  string  // Value for string fields
+	num   float64 // Value for numeric fields
+}
+
+// policyFieldKind describes whether a field compares as text or as a number
+type policyFieldKind int
+
+const (
+	policyText policyFieldKind = iota
+	policyNumber
+)
+
+// policyFields lists the probe fields a policy can compare against
+var policyFields = map[string]policyFieldKind{
+	"codec":        policyText,   // Video codec (h264, hevc, av1, ...)
+	"audio_codec":  policyText,   // First audio codec
+	"container":    policyText,   // Container format as reported by ffprobe
+	"bitrate":      policyNumber, // Overall bitrate in bits/s
+	"width":        policyNumber,
+	"height":       policyNumber,
+	"duration":     policyNumber, // Seconds
+	"size":         policyNumber, // Bytes
+	"size_per_min": policyNumber, // Bytes per minute of runtime
+}
+
+// policyOperators is ordered so two-character operators are tried first
+var policyOperators = []string{"!=", ">=", "<=", "==", "=", ">", "<"}
+
+// codecAliases maps common codec names to ffprobe's names
+var codecAliases = map[string]string{
+	"h265":  "hevc",
+	"x265":  "hevc",
+	"avc":   "h264",
+	"x264":  "h264",
+	"h.264": "h264",
+	"h.265": "hevc",
+}
+
+// ParsePolicy parses policy expressions such as "codec!=hevc,bitrate>8M"
+func ParsePolicy(expressions []string) (*Policy, error) {
+	policy := &Policy{}
+	for _, expr := range expressions {
+		var conditions []policyCondition
+		for _, part := range strings.Split(expr, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			cond, err := parsePolicyCondition(part)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, cond)
+		}
+		if len(conditions) > 0 {
+			policy.expressions = append(policy.expressions, conditions)
+		}
+	}
+	if len(policy.expressions) == 0 {
+		return nil, NewTranscoderError(ErrorTypeInvalidPolicy, "policy is empty", nil)
+	}
+	return policy, nil
+}
+
+func parsePolicyCondition(s string) (policyCondition, error) {
+	for _, op := range policyOperators {
+		idx := strings.Index(s, op)
+		if idx <= 0 {
+			continue
+		}
+
+		cond := policyCondition{
+			field: strings.ToLower(strings.TrimSpace(s[:idx])),
+			op:    op,
+		}
+		if cond.op == "=" {
+			cond.op = "=="
+		}
+		value := strings.TrimSpace(s[idx+len(op):])
+
+		kind, ok := policyFields[cond.field]
+		if !ok {
+			return cond, NewTranscoderError(ErrorTypeInvalidPolicy,
+				fmt.Sprintf("unknown policy field '%s' (supported: %s)", cond.field, strings.Join(policyFieldNames(), ", ")), nil)
+		}
+
+		if kind == policyText {
+			if cond.op != "==" && cond.op != "!=" {
+				return cond, NewTranscoderError(ErrorTypeInvalidPolicy,
+					fmt.Sprintf("field '%s' only supports == and !=", cond.field), nil)
+			}
+			cond.text = normalizeCodecName(value)
+			return cond, nil
+		}
+
+		num, err := parseSIValue(value)
+		if err != nil {
+			return cond, NewTranscoderError(ErrorTypeInvalidPolicy,
+				fmt.Sprintf("invalid value '%s' for field '%s'", value, cond.field), err)
+		}
+		cond.num = num
+		return cond, nil
+	}
+
+	return policyCondition{}, NewTranscoderError(ErrorTypeInvalidPolicy,
+		fmt.Sprintf("invalid policy condition '%s'", s), nil)
+}
+
+// Matches reports whether a probed file violates the policy
+func (p *Policy) Matches(info *ProbeInfo) bool {
+	for _, conditions := range p.expressions {
+		matched := true
+		for _, cond := range conditions {
+			if !cond.matches(info) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c policyCondition) matches(info *ProbeInfo) bool {
+	if policyFields[c.field] == policyText {
+		var actual string
+		switch c.field {
+		case "codec":
+			actual = info.VideoCodec
+		case "audio_codec":
+			actual = info.AudioCodec
+		case "container":
+			// ffprobe reports a list such as "mov,mp4,m4a,3gp,3g2,mj2"
+			contains := false
+			for _, name := range strings.Split(info.FormatName, ",") {
+				if name == c.text {
+					contains = true
+				}
+			}
+			return contains == (c.op == "==")
+		}
+		equal := normalizeCodecName(actual) == c.text
+		return equal == (c.op == "==")
+	}
+
+	var actual float64
+	switch c.field {
+	case "bitrate":
+		actual = float64(info.Bitrate)
+	case "width":
+		actual = float64(info.Width)
+	case "height":
+		actual = float64(info.Height)
+	case "duration":
+		actual = info.Duration
+	case "size":
+		actual = float64(info.Size)
+	case "size_per_min":
+		if info.Duration <= 0 {
+			return false
+		}
+		actual = float64(info.Size) / (info.Duration / 60)
+	}
+
+	switch c.op {
+	case "==":
+		return actual == c.num
+	case "!=":
+		return actual != c.num
+	case ">":
+		return actual > c.num
+	case ">=":
+		return actual >= c.num
+	case "<":
+		return actual < c.num
+	case "<=":
+		return actual <= c.num
+	}
+	return false
+}
+
+// normalizeCodecName lowercases a codec name and resolves common aliases
+func normalizeCodecName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := codecAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// parseSIValue parses numbers with optional k/M/G suffixes (powers of 1000)
+func parseSIValue(s string) (float64, error) {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"), strings.HasSuffix(s, "K"):
+		multiplier = 1e3
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1e6
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1e9
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
+func policyFieldNames() []string {
+	names := make([]string, 0, len(policyFields))
+	for name := range policyFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,165 @@
+package transcoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// presetFileEntry is one preset as written in a --presets-file
+type presetFileEntry struct {
+	Name        string   `json:"name" yaml:"name"`
+	Resolution  string   `json:"resolution" yaml:"resolution"`
+	Codec       string   `json:"codec" yaml:"codec"`
+	Encoder     string   `json:"encoder" yaml:"encoder"`
+	Bitrate     string   `json:"bitrate" yaml:"bitrate"`
+	Description string   `json:"description" yaml:"description"`
+	Args        []string `json:"args" yaml:"args"`
+	Tune        string   `json:"tune" yaml:"tune"`
+	Platform    string   `json:"platform" yaml:"platform"` // nvidia, apple_silicon, intel_qsv, amd, software, or empty for any
+	TwoPass     bool     `json:"two_pass" yaml:"two_pass"`
+}
+
+// presetFilePlatforms maps the platform names of a presets file
+var presetFilePlatforms = map[string]Platform{
+	"":              PlatformUnknown,
+	"any":           PlatformUnknown,
+	"nvidia":        PlatformNVIDIA,
+	"apple_silicon": PlatformAppleSilicon,
+	"software":      PlatformSoftware,
+	"intel_qsv":     PlatformIntelQSV,
+	"amd":           PlatformAMD,
+}
+
+// ParsePresetsFile reads a list of presets from a JSON file, or a YAML file
+// when its extension is .yaml or .yml. Every preset needs a name, an encoder
+// and arguments; the error of an incomplete preset names the missing fields.
+func ParsePresetsFile(path string) ([]Preset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot read presets file "+path, err)
+	}
+
+	var entries []presetFileEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &entries)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&entries)
+	}
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("presets file %s must hold a list of presets", path), err)
+	}
+
+	presets := make([]Preset, 0, len(entries))
+	seen := make(map[string]bool)
+	for i, entry := range entries {
+		label := fmt.Sprintf("preset %d", i+1)
+		if entry.Name != "" {
+			label += fmt.Sprintf(" (%s)", entry.Name)
+		}
+		var missing []string
+		if entry.Name == "" {
+			missing = append(missing, "name")
+		}
+		if entry.Encoder == "" {
+			missing = append(missing, "encoder")
+		}
+		if len(entry.Args) == 0 {
+			missing = append(missing, "args")
+		}
+		if len(missing) > 0 {
+			return nil, NewTranscoderError(ErrorTypeInvalidPreset,
+				fmt.Sprintf("%s in %s is missing %s", label, path, strings.Join(missing, ", ")), nil)
+		}
+		if seen[entry.Name] {
+			return nil, NewTranscoderError(ErrorTypeInvalidPreset,
+				fmt.Sprintf("%s in %s is defined twice", label, path), nil)
+		}
+		seen[entry.Name] = true
+		platform, ok := presetFilePlatforms[strings.ToLower(entry.Platform)]
+		if !ok {
+			return nil, NewTranscoderError(ErrorTypeInvalidPreset,
+				fmt.Sprintf("%s in %s has unknown platform '%s' (supported: nvidia, apple_silicon, intel_qsv, amd, software)", label, path, entry.Platform), nil)
+		}
+		presets = append(presets, Preset{
+			Name:        entry.Name,
+			Resolution:  entry.Resolution,
+			Codec:       entry.Codec,
+			Encoder:     entry.Encoder,
+			Bitrate:     entry.Bitrate,
+			Description: entry.Description,
+			Args:        entry.Args,
+			Platform:    platform,
+			Tune:        entry.Tune,
+			TwoPass:     entry.TwoPass,
+		})
+	}
+	return presets, nil
+}
+
+// LoadPresetsFile makes the presets of a --presets-file available by name,
+// replacing built-in presets with the same name and any presets loaded from
+// an earlier file
+func LoadPresetsFile(path string) error {
+	presets, err := ParsePresetsFile(path)
+	if err != nil {
+		return err
+	}
+	user := make(map[string]Preset, len(presets))
+	for _, preset := range presets {
+		user[preset.Name] = preset
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	registry.user = user
+	return nil
+}
+
+// IsUserPreset reports whether a preset came from a --presets-file
+func IsUserPreset(name string) bool {
+	registry.RLock()
+	defer registry.RUnlock()
+	_, ok := registry.user[name]
+	return ok
+}
+
+// userPresets returns a copy of the presets loaded from a --presets-file
+func userPresets() map[string]Preset {
+	registry.RLock()
+	defer registry.RUnlock()
+	presets := make(map[string]Preset, len(registry.user))
+	for name, preset := range registry.user {
+		presets[name] = preset
+	}
+	return presets
+}
+
+// userPresetNames returns the names of user presets that are not also
+// built-in or registered, sorted
+func userPresetNames() []string {
+	registered := registeredPresets()
+	var names []string
+	for name := range userPresets() {
+		if _, builtin := presetCache[name]; builtin {
+			continue
+		}
+		if _, ok := registered[name]; ok {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
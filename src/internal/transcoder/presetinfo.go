@@ -0,0 +1,51 @@
+package transcoder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Placeholder paths of the commands DescribePreset shows
+const (
+	describeInput  = "input.mp4"
+	describeOutput = "output.mkv"
+)
+
+// PresetDetail describes a preset together with the ffmpeg commands it
+// produces on this machine
+type PresetDetail struct {
+	Preset           Preset
+	Hardware         bool     // The preset's encoder runs on the detected platform; false means every encode is the software one
+	Args             []string // ffmpeg arguments of the first encode attempt
+	FallbackEncoder  string   // Software encoder used when the hardware encode fails
+	FallbackArgs     []string // ffmpeg arguments of the software fallback
+	FromPresetsFile  bool     // Defined or replaced by --presets-file
+	PresetPlatform   string   // Name of the platform the preset targets, empty for platform-agnostic presets
+	DetectedPlatform string   // Name of the platform of this machine
+}
+
+// DescribePreset returns the details of a preset, with the commands built
+// the same way an encode builds them but for placeholder input and output
+// paths and without the per-file adjustments that need a real source
+func (t *Transcoder) DescribePreset(name string) (*PresetDetail, error) {
+	preset, ok := t.presets[name]
+	if !ok {
+		return nil, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("unknown preset '%s'. Available presets: %s", name, strings.Join(GetAvailablePresets(), ", ")), nil)
+	}
+
+	platform := t.systemChecker.GetPlatform()
+	detail := &PresetDetail{
+		Preset:           preset,
+		Hardware:         t.useHardware(preset) && (preset.Platform == platform || preset.Platform == Platform(0)),
+		FallbackEncoder:  softwareFallbackFor(preset.Encoder).Encoder,
+		FromPresetsFile:  IsUserPreset(name),
+		DetectedPlatform: platform.String(),
+	}
+	if preset.Platform != Platform(0) {
+		detail.PresetPlatform = preset.Platform.String()
+	}
+	detail.Args = t.buildFFmpegArgs(describeInput, describeOutput, preset, detail.Hardware)
+	detail.FallbackArgs = t.buildFFmpegArgs(describeInput, describeOutput, preset, false)
+	return detail, nil
+}
@@ -9,22 +9,42 @@ type Preset struct {
 	Description string   // Human-readable description
 	Args        []string // FFmpeg command line arguments
 	Platform    Platform // Target platform for this preset
+	Tune        string   // Default encoder tuning (see TuneArgs); empty for none
+	TwoPass     bool     // Encode in two passes to hit Bitrate; ignored without a Bitrate
 }
 
 func GetPresets() map[string]Preset {
-	// Detect current platform
-	platform := detectPlatform()
+	return presetsFor(presetPlatform())
+}
 
+// presetsFor returns the built-in presets of a platform's preset table
+// together with the registered and presets-file ones
+func presetsFor(platform Platform) map[string]Preset {
 	presets := make(map[string]Preset)
 
 	// Add platform-appropriate presets
 	switch platform {
 	case PlatformAppleSilicon:
 		addAppleSiliconPresets(presets)
+	case PlatformIntelQSV:
+		addIntelQSVPresets(presets)
+	case PlatformAMD:
+		addAMDPresets(presets)
 	default:
 		addNVIDIAPresets(presets)
 	}
 
+	// Presets registered at runtime never replace built-in ones, while those
+	// of a presets file replace any preset of the same name
+	for name, preset := range registeredPresets() {
+		if _, exists := presets[name]; !exists {
+			presets[name] = preset
+		}
+	}
+	for name, preset := range userPresets() {
+		presets[name] = preset
+	}
+
 	return presets
 }
 
@@ -101,6 +121,26 @@ func addNVIDIAPresets(presets map[string]Preset) {
 			Args:        []string{"-c:v", "hevc_nvenc", "-preset", "p7", "-crf", "26", "-b:v", "20M", "-maxrate", "30M", "-bufsize", "60M", "-vf", "scale=3840:2160"},
 			Platform:    PlatformNVIDIA,
 		},
+		"720p_vertical": {
+			Name:        "720p_vertical",
+			Resolution:  "720x1280",
+			Codec:       "H.264",
+			Encoder:     "h264_nvenc",
+			Bitrate:     "3M",
+			Description: "720p portrait H.264 encoding with NVENC",
+			Args:        []string{"-c:v", "h264_nvenc", "-preset", "p7", "-crf", "23", "-b:v", "3M", "-maxrate", "4M", "-bufsize", "8M", "-vf", "scale=720:1280"},
+			Platform:    PlatformNVIDIA,
+		},
+		"1080p_vertical": {
+			Name:        "1080p_vertical",
+			Resolution:  "1080x1920",
+			Codec:       "H.264",
+			Encoder:     "h264_nvenc",
+			Bitrate:     "5M",
+			Description: "1080p portrait H.264 encoding with NVENC",
+			Args:        []string{"-c:v", "h264_nvenc", "-preset", "p7", "-crf", "23", "-b:v", "5M", "-maxrate", "8M", "-bufsize", "16M", "-vf", "scale=1080:1920"},
+			Platform:    PlatformNVIDIA,
+		},
 	}
 
 	for name, preset := range nvencPresets {
@@ -181,6 +221,26 @@ func addAppleSiliconPresets(presets map[string]Preset) {
 			Args:        []string{"-c:v", "hevc_videotoolbox", "-q:v", "60", "-b:v", "20M", "-maxrate", "30M", "-bufsize", "60M", "-vf", "scale=3840:2160"},
 			Platform:    PlatformAppleSilicon,
 		},
+		"720p_vertical": {
+			Name:        "720p_vertical",
+			Resolution:  "720x1280",
+			Codec:       "H.264",
+			Encoder:     "h264_videotoolbox",
+			Bitrate:     "3M",
+			Description: "720p portrait H.264 encoding with VideoToolbox",
+			Args:        []string{"-c:v", "h264_videotoolbox", "-q:v", "65", "-b:v", "3M", "-maxrate", "4M", "-bufsize", "8M", "-vf", "scale=720:1280"},
+			Platform:    PlatformAppleSilicon,
+		},
+		"1080p_vertical": {
+			Name:        "1080p_vertical",
+			Resolution:  "1080x1920",
+			Codec:       "H.264",
+			Encoder:     "h264_videotoolbox",
+			Bitrate:     "5M",
+			Description: "1080p portrait H.264 encoding with VideoToolbox",
+			Args:        []string{"-c:v", "h264_videotoolbox", "-q:v", "65", "-b:v", "5M", "-maxrate", "8M", "-bufsize", "16M", "-vf", "scale=1080:1920"},
+			Platform:    PlatformAppleSilicon,
+		},
 	}
 
 	for name, preset := range appleSiliconPresets {
@@ -188,6 +248,107 @@ func addAppleSiliconPresets(presets map[string]Preset) {
 	}
 }
 
+// addIntelQSVPresets adds Intel Quick Sync Video presets. AV1 needs an Arc
+// GPU or a Meteor Lake or newer iGPU; older ones fall back to software.
+func addIntelQSVPresets(presets map[string]Preset) {
+	qsvPresets := map[string]Preset{
+		"720p_av1": {
+			Name:        "720p_av1",
+			Resolution:  "1280x720",
+			Codec:       "AV1",
+			Encoder:     "av1_qsv",
+			Bitrate:     "2M",
+			Description: "720p AV1 encoding with Quick Sync",
+			Args:        []string{"-c:v", "av1_qsv", "-preset", "slower", "-global_quality", "28", "-b:v", "2M", "-maxrate", "3M", "-bufsize", "6M", "-vf", "scale=1280:720"},
+			Platform:    PlatformIntelQSV,
+		},
+		"1080p_av1": {
+			Name:        "1080p_av1",
+			Resolution:  "1920x1080",
+			Codec:       "AV1",
+			Encoder:     "av1_qsv",
+			Bitrate:     "4M",
+			Description: "1080p AV1 encoding with Quick Sync",
+			Args:        []string{"-c:v", "av1_qsv", "-preset", "slower", "-global_quality", "26", "-b:v", "4M", "-maxrate", "6M", "-bufsize", "12M", "-vf", "scale=1920:1080"},
+			Platform:    PlatformIntelQSV,
+		},
+		"720p_h264": {
+			Name:        "720p_h264",
+			Resolution:  "1280x720",
+			Codec:       "H.264",
+			Encoder:     "h264_qsv",
+			Bitrate:     "3M",
+			Description: "720p H.264 encoding with Quick Sync",
+			Args:        []string{"-c:v", "h264_qsv", "-preset", "slower", "-global_quality", "23", "-b:v", "3M", "-maxrate", "4M", "-bufsize", "8M", "-vf", "scale=1280:720"},
+			Platform:    PlatformIntelQSV,
+		},
+		"1080p_h264": {
+			Name:        "1080p_h264",
+			Resolution:  "1920x1080",
+			Codec:       "H.264",
+			Encoder:     "h264_qsv",
+			Bitrate:     "5M",
+			Description: "1080p H.264 encoding with Quick Sync",
+			Args:        []string{"-c:v", "h264_qsv", "-preset", "slower", "-global_quality", "23", "-b:v", "5M", "-maxrate", "8M", "-bufsize", "16M", "-vf", "scale=1920:1080"},
+			Platform:    PlatformIntelQSV,
+		},
+		"1080p_h265": {
+			Name:        "1080p_h265",
+			Resolution:  "1920x1080",
+			Codec:       "H.265",
+			Encoder:     "hevc_qsv",
+			Bitrate:     "3M",
+			Description: "1080p H.265 encoding with Quick Sync",
+			Args:        []string{"-c:v", "hevc_qsv", "-preset", "slower", "-global_quality", "26", "-b:v", "3M", "-maxrate", "5M", "-bufsize", "10M", "-vf", "scale=1920:1080"},
+			Platform:    PlatformIntelQSV,
+		},
+		"4k_av1": {
+			Name:        "4k_av1",
+			Resolution:  "3840x2160",
+			Codec:       "AV1",
+			Encoder:     "av1_qsv",
+			Bitrate:     "15M",
+			Description: "4K AV1 encoding with Quick Sync",
+			Args:        []string{"-c:v", "av1_qsv", "-preset", "slower", "-global_quality", "28", "-b:v", "15M", "-maxrate", "20M", "-bufsize", "40M", "-vf", "scale=3840:2160"},
+			Platform:    PlatformIntelQSV,
+		},
+		"4k_h265": {
+			Name:        "4k_h265",
+			Resolution:  "3840x2160",
+			Codec:       "H.265",
+			Encoder:     "hevc_qsv",
+			Bitrate:     "20M",
+			Description: "4K H.265 encoding with Quick Sync",
+			Args:        []string{"-c:v", "hevc_qsv", "-preset", "slower", "-global_quality", "26", "-b:v", "20M", "-maxrate", "30M", "-bufsize", "60M", "-vf", "scale=3840:2160"},
+			Platform:    PlatformIntelQSV,
+		},
+		"720p_vertical": {
+			Name:        "720p_vertical",
+			Resolution:  "720x1280",
+			Codec:       "H.264",
+			Encoder:     "h264_qsv",
+			Bitrate:     "3M",
+			Description: "720p portrait H.264 encoding with Quick Sync",
+			Args:        []string{"-c:v", "h264_qsv", "-preset", "slower", "-global_quality", "23", "-b:v", "3M", "-maxrate", "4M", "-bufsize", "8M", "-vf", "scale=720:1280"},
+			Platform:    PlatformIntelQSV,
+		},
+		"1080p_vertical": {
+			Name:        "1080p_vertical",
+			Resolution:  "1080x1920",
+			Codec:       "H.264",
+			Encoder:     "h264_qsv",
+			Bitrate:     "5M",
+			Description: "1080p portrait H.264 encoding with Quick Sync",
+			Args:        []string{"-c:v", "h264_qsv", "-preset", "slower", "-global_quality", "23", "-b:v", "5M", "-maxrate", "8M", "-bufsize", "16M", "-vf", "scale=1080:1920"},
+			Platform:    PlatformIntelQSV,
+		},
+	}
+
+	for name, preset := range qsvPresets {
+		presets[name] = preset
+	}
+}
+
 // Global cache to avoid repeated calls to GetPresets()
 var presetCache map[string]Preset
 var presetNames []string
@@ -201,17 +362,38 @@ func init() {
 }
 
 func IsValidPreset(preset string) bool {
-	_, exists := presetCache[preset]
+	if _, exists := presetCache[preset]; exists {
+		return true
+	}
+	if _, exists := registeredPresets()[preset]; exists {
+		return true
+	}
+	_, exists := userPresets()[preset]
 	return exists
 }
 
+// GetAvailablePresets returns the built-in preset names followed by the
+// registered ones and those only defined in a presets file
 func GetAvailablePresets() []string {
-	return presetNames
+	names := append(append([]string(nil), presetNames...), registeredPresetNames()...)
+	return append(names, userPresetNames()...)
+}
+
+// IsSupportedCodec reports whether any preset encodes to the given codec
+// (accepts names like h264, hevc, h265 and av1)
+func IsSupportedCodec(codec string) bool {
+	codec = normalizeCodecName(codec)
+	for _, preset := range GetPresets() {
+		if normalizeCodecName(preset.Codec) == codec {
+			return true
+		}
+	}
+	return false
 }
 
 // GetPresetsForPlatform returns presets suitable for the specified platform
 func GetPresetsForPlatform(platform Platform) map[string]Preset {
-	allPresets := GetPresets()
+	allPresets := presetsFor(platform)
 	filteredPresets := make(map[string]Preset)
 
 	for name, preset := range allPresets {
@@ -0,0 +1,130 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PreviewPath returns where the preview sample of an input is written, e.g.
+// movie_1080p_h264_preview.mkv in dir
+func (t *Transcoder) PreviewPath(inputPath, dir string) (string, error) {
+	return t.samplePath(inputPath, dir, "preview")
+}
+
+// samplePath returns the output path of a sample encode, tagged after the preset name
+func (t *Transcoder) samplePath(inputPath, dir, tag string) (string, error) {
+	preset, err := t.presetFor(inputPath)
+	if err != nil {
+		return "", err
+	}
+	output := t.pathUtils.GenerateOutputPath(inputPath, dir, filepath.Dir(inputPath), preset)
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + "_" + tag + ext, nil
+}
+
+// EncodeSample encodes length of the input starting at start with the
+// configured preset. A failed hardware encode is retried in software once.
+func (t *Transcoder) EncodeSample(inputPath, outputPath string, start, length time.Duration) error {
+	preset, err := t.presetFor(inputPath)
+	if err != nil {
+		return err
+	}
+	if err := t.ensureOutputDir(filepath.Dir(outputPath)); err != nil {
+		return err
+	}
+
+	// The debug overlay is only ever added here, so it cannot reach a real output
+	build := func(hardware bool) []string {
+		args := sampleArgs(t.buildFFmpegArgs(inputPath, outputPath, preset, hardware), start, length)
+		if t.config.DebugOverlay {
+			args = withDebugOverlay(args)
+		}
+		return args
+	}
+
+	stderr, _, err := t.runSample(context.Background(), inputPath, t.useHardware(preset), build)
+	if err != nil {
+		discardOutput(outputPath)
+		return NewTranscoderError(ErrorTypeEncodingFailed,
+			fmt.Sprintf("sample encode failed for %s: %s", inputPath, strings.TrimSpace(stderr)), err)
+	}
+	return nil
+}
+
+// runSample runs the sample encode build returns the arguments of, retrying
+// it in software once when the hardware encode fails. It returns the ffmpeg
+// output and encode time of the last run.
+func (t *Transcoder) runSample(ctx context.Context, inputPath string, hardware bool, build func(hardware bool) []string) (string, time.Duration, error) {
+	args := build(hardware)
+	t.log.Debugf("Running: %s", FormatCommand("ffmpeg", args))
+	started := time.Now()
+	stderr, err := t.runFFmpeg(ctx, inputPath, args, nil)
+	if err != nil && hardware && ctx.Err() == nil {
+		t.log.Infof("Hardware encoding failed, retrying sample in software...")
+		args = build(false)
+		t.log.Debugf("Running: %s", FormatCommand("ffmpeg", args))
+		started = time.Now()
+		stderr, err = t.runFFmpeg(ctx, inputPath, args, nil)
+	}
+	return stderr, time.Since(started), err
+}
+
+// sampleArgs limits an encode to a window of the input. -ss goes before the
+// first input so ffmpeg seeks instead of decoding up to the start; -t goes
+// before the output path. Arguments already trimmed with --start and
+// --duration or --end keep their seeks, moved on by start, and get the
+// window's -t in place of theirs, so the window is relative to the trim.
+func sampleArgs(args []string, start, length time.Duration) []string {
+	seeked := containsArg(args, "-ss")
+	result := make([]string, 0, len(args)+4)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-ss" && i+1 < len(args):
+			if trimStart, err := strconv.ParseFloat(args[i+1], 64); err == nil {
+				result = append(result, arg, formatSeconds(time.Duration(trimStart*float64(time.Second))+start))
+				i++
+				continue
+			}
+		case arg == "-t" && i+1 < len(args) && length > 0:
+			i++
+			continue
+		case arg == "-i" && start > 0 && !seeked && !containsArg(result, "-ss"):
+			result = append(result, "-ss", formatSeconds(start))
+		case arg == "-y" && i == len(args)-2 && length > 0:
+			result = append(result, "-t", formatSeconds(length))
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// containsArg reports whether args contains a flag
+func containsArg(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// formatSeconds renders a duration as seconds for ffmpeg time options
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// PlayFile plays a file with ffplay, returning once playback ends or the
+// window is closed
+func PlayFile(path string) error {
+	cmd := exec.Command("ffplay", "-autoexit", "-hide_banner", "-loglevel", "warning", "-window_title", filepath.Base(path), path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
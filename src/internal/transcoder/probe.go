@@ -0,0 +1,289 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// ProbeInfo summarizes the container and primary streams of a media file
+type ProbeInfo struct {
+	FormatName     string   `json:"format_name"`
+	Duration       float64  `json:"duration_seconds"`
+	Size           int64    `json:"size_bytes"`
+	Bitrate        int64    `json:"bitrate"`
+	VideoBitrate   int64    `json:"video_bitrate,omitempty"` // Bitrate of the primary video stream, 0 when the container does not state it
+	VideoCodec     string   `json:"video_codec,omitempty"`
+	Width          int      `json:"width,omitempty"`
+	Height         int      `json:"height,omitempty"`
+	FrameRate      string   `json:"frame_rate,omitempty"`
+	SAR            string   `json:"sample_aspect_ratio,omitempty"` // Pixel shape, e.g. 32:27 for anamorphic widescreen DVDs
+	Rotation       int      `json:"rotation,omitempty"`            // Display rotation in degrees
+	ColorTransfer  string   `json:"color_transfer,omitempty"`      // Transfer characteristics, e.g. smpte2084 for HDR10
+	FieldOrder     string   `json:"field_order,omitempty"`         // progressive, or tt/bb/tb/bt for interlaced video
+	AudioCodec     string   `json:"audio_codec,omitempty"`
+	AudioCodecs    []string `json:"audio_codecs,omitempty"`    // Codec of every audio stream, in stream order
+	SubtitleCodecs []string `json:"subtitle_codecs,omitempty"` // Codec of every subtitle stream, in stream order
+	Streams        int      `json:"streams"`
+	Comment        string   `json:"comment,omitempty"` // Global comment tag of the container
+}
+
+// Prober reads media information using ffprobe. Results are cached per path
+// for the lifetime of the prober so a file is only probed once per run.
+type Prober struct {
+	executor CommandExecutor
+	persist  *ProbeCache // Cross-run cache from --probe-cache, nil when unset
+
+	mu      sync.Mutex
+	cache   map[string]*ProbeInfo
+	offline bool // Only cached results are returned; set for dry runs
+}
+
+// errProbeOffline is returned for files not yet probed while probing is off
+var errProbeOffline = errors.New("probing is off for a dry run")
+
+// NewProber creates a new prober
+func NewProber(executor CommandExecutor) *Prober {
+	return &Prober{
+		executor: executor,
+		cache:    make(map[string]*ProbeInfo),
+	}
+}
+
+// Probe returns a summary of a file's contents, running ffprobe on a cache miss
+func (p *Prober) Probe(path string) (*ProbeInfo, error) {
+	p.mu.Lock()
+	info, ok := p.cache[path]
+	p.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	if p.persist != nil {
+		info, ok = p.persist.Get(path)
+	}
+	if !ok && p.isOffline() {
+		return nil, errProbeOffline
+	}
+	if !ok {
+		var err error
+		info, err = p.probe(path)
+		if err != nil {
+			return nil, err
+		}
+		if p.persist != nil {
+			p.persist.Put(path, info)
+		}
+	}
+
+	p.mu.Lock()
+	p.cache[path] = info
+	p.mu.Unlock()
+	return info, nil
+}
+
+// streamRotation reads a stream's rotation from the legacy rotate tag or the
+// display matrix side data, normalized to 0, 90, 180 or 270 degrees
+func streamRotation(tag string, sideData []ffprobeSideData) int {
+	rotation, err := strconv.Atoi(tag)
+	if err != nil {
+		for _, data := range sideData {
+			if data.Rotation != 0 {
+				rotation = int(data.Rotation)
+				break
+			}
+		}
+	}
+	rotation %= 360
+	if rotation < 0 {
+		rotation += 360
+	}
+	return rotation
+}
+
+// DisplaySize returns the width and height the video is shown at, swapping
+// the coded dimensions for sources rotated by 90 or 270 degrees
+func (p *ProbeInfo) DisplaySize() (width, height int) {
+	if p.Rotation == 90 || p.Rotation == 270 {
+		return p.Height, p.Width
+	}
+	return p.Width, p.Height
+}
+
+// HasVideo reports whether the file has a video stream other than cover art
+func (p *ProbeInfo) HasVideo() bool {
+	return p.VideoCodec != ""
+}
+
+// IsPortrait reports whether the video is displayed taller than it is wide
+func (p *ProbeInfo) IsPortrait() bool {
+	width, height := p.DisplaySize()
+	return width > 0 && height > width
+}
+
+// SetPersistentCache keeps probe results across runs in cache
+func (p *Prober) SetPersistentCache(cache *ProbeCache) {
+	p.persist = cache
+}
+
+// setOffline turns running ffprobe off or back on and returns the previous
+// setting; results already cached are still returned while it is off
+func (p *Prober) setOffline(offline bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous := p.offline
+	p.offline = offline
+	return previous
+}
+
+// isOffline reports whether running ffprobe is off
+func (p *Prober) isOffline() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.offline
+}
+
+// Invalidate drops any cached result for a path, e.g. after it was rewritten
+func (p *Prober) Invalidate(path string) {
+	p.mu.Lock()
+	delete(p.cache, path)
+	p.mu.Unlock()
+}
+
+// ProbeAll probes files concurrently using the given number of workers.
+// Files that fail to probe are omitted from the returned map.
+func (p *Prober) ProbeAll(paths []string, workers int) map[string]*ProbeInfo {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make(map[string]*ProbeInfo, len(paths))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				if info, err := p.Probe(path); err == nil {
+					mu.Lock()
+					results[path] = info
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, path := range paths {
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// probe runs ffprobe on a file
+func (p *Prober) probe(path string) (*ProbeInfo, error) {
+	output, err := p.executor.Execute("ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path,
+	)
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeEncodingFailed,
+			"ffprobe failed for "+path, err)
+	}
+	return parseProbeOutput(output)
+}
+
+// ffprobeOutput mirrors the subset of ffprobe's JSON output that we use
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		Size       string `json:"size"`
+		BitRate    string `json:"bit_rate"`
+		Tags       struct {
+			Comment string `json:"comment"`
+		} `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType     string `json:"codec_type"`
+		CodecName     string `json:"codec_name"`
+		Width         int    `json:"width"`
+		Height        int    `json:"height"`
+		AvgFrameRate  string `json:"avg_frame_rate"`
+		SAR           string `json:"sample_aspect_ratio"`
+		ColorTransfer string `json:"color_transfer"`
+		FieldOrder    string `json:"field_order"`
+		BitRate       string `json:"bit_rate"`
+		Disposition   struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+		Tags struct {
+			Rotate string `json:"rotate"`
+		} `json:"tags"`
+		SideDataList []ffprobeSideData `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+// ffprobeSideData is a stream side data entry; only the display matrix rotation is used
+type ffprobeSideData struct {
+	Rotation float64 `json:"rotation"`
+}
+
+// parseProbeOutput converts raw ffprobe JSON into a ProbeInfo
+func parseProbeOutput(data []byte) (*ProbeInfo, error) {
+	var raw ffprobeOutput
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, NewTranscoderError(ErrorTypeEncodingFailed,
+			"failed to parse ffprobe output", err)
+	}
+
+	info := &ProbeInfo{
+		FormatName: raw.Format.FormatName,
+		Streams:    len(raw.Streams),
+		Comment:    raw.Format.Tags.Comment,
+	}
+	info.Duration, _ = strconv.ParseFloat(raw.Format.Duration, 64)
+	info.Size, _ = strconv.ParseInt(raw.Format.Size, 10, 64)
+	info.Bitrate, _ = strconv.ParseInt(raw.Format.BitRate, 10, 64)
+
+	// Only the first stream of each type is summarized, apart from the lists
+	// of audio and subtitle codecs
+	for _, stream := range raw.Streams {
+		switch stream.CodecType {
+		case "video":
+			// Cover art is exposed as a video stream; skip it
+			if stream.Disposition.AttachedPic == 1 {
+				continue
+			}
+			if info.VideoCodec == "" {
+				info.VideoCodec = stream.CodecName
+				info.Width = stream.Width
+				info.Height = stream.Height
+				info.FrameRate = stream.AvgFrameRate
+				info.SAR = stream.SAR
+				info.ColorTransfer = stream.ColorTransfer
+				info.FieldOrder = stream.FieldOrder
+				info.VideoBitrate, _ = strconv.ParseInt(stream.BitRate, 10, 64)
+				info.Rotation = streamRotation(stream.Tags.Rotate, stream.SideDataList)
+			}
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = stream.CodecName
+			}
+			info.AudioCodecs = append(info.AudioCodecs, stream.CodecName)
+		case "subtitle":
+			info.SubtitleCodecs = append(info.SubtitleCodecs, stream.CodecName)
+		}
+	}
+
+	return info, nil
+}
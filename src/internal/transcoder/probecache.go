@@ -0,0 +1,120 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// probeCacheVersion is bumped when ProbeInfo changes meaning, discarding
+// caches written by older versions
+const probeCacheVersion = 8
+
+// ProbeCache keeps probe results across runs in a JSON file. Entries are
+// keyed by absolute path and only used while the file's size and
+// modification time are unchanged.
+type ProbeCache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]probeCacheEntry
+	dirty   bool
+}
+
+// probeCacheEntry is one cached probe with the file state it was taken from
+type probeCacheEntry struct {
+	Size    int64      `json:"size"`
+	ModTime time.Time  `json:"mod_time"`
+	Info    *ProbeInfo `json:"info"`
+}
+
+// probeCacheFile is the on-disk layout of a probe cache
+type probeCacheFile struct {
+	Version int                        `json:"version"`
+	Entries map[string]probeCacheEntry `json:"entries"`
+}
+
+// LoadProbeCache reads a probe cache, starting empty when the file does not
+// exist yet. A damaged or outdated cache is discarded rather than failing
+// the run; it only costs a re-probe.
+func LoadProbeCache(path string) (*ProbeCache, error) {
+	cache := &ProbeCache{path: path, entries: make(map[string]probeCacheEntry)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot read probe cache "+path, err)
+	}
+
+	var stored probeCacheFile
+	if err := json.Unmarshal(data, &stored); err == nil && stored.Version == probeCacheVersion && stored.Entries != nil {
+		cache.entries = stored.Entries
+	}
+	return cache, nil
+}
+
+// Get returns the cached probe of a file if the file is unchanged since
+func (c *ProbeCache) Get(path string) (*ProbeInfo, bool) {
+	key, info, ok := probeCacheKey(path)
+	if !ok {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.entries[key]
+	if !found || entry.Info == nil || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		return nil, false
+	}
+	return entry.Info, true
+}
+
+// Put records the probe of a file in its current state
+func (c *ProbeCache) Put(path string, probe *ProbeInfo) {
+	key, info, ok := probeCacheKey(path)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	c.entries[key] = probeCacheEntry{Size: info.Size(), ModTime: info.ModTime(), Info: probe}
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// Save writes the cache back if anything was added
+func (c *ProbeCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(probeCacheFile{Version: probeCacheVersion, Entries: c.entries}, "", "  ")
+	if err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError, "failed to encode probe cache", err)
+	}
+	if err := writeFileAtomic(c.path, data); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// probeCacheKey returns the cache key and current state of a regular file.
+// Anything else, such as a DVD concat URL, is not cached.
+func probeCacheKey(path string) (string, os.FileInfo, bool) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil, false
+	}
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, false
+	}
+	return key, info, true
+}
@@ -0,0 +1,320 @@
+package transcoder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BatchProgress tracks overall progress of a batch. When source durations are
+// known each file is weighted by its duration, otherwise every file counts the same.
+type BatchProgress struct {
+	mu         sync.Mutex
+	weights    []float64
+	partial    []float64 // Completed fraction of each file (0..1)
+	total      float64
+	byDuration bool
+	completed  int
+	start      time.Time
+}
+
+// NewBatchProgress creates a tracker for the given files. durations maps file
+// paths to probed durations in seconds; pass nil for count-based progress.
+func NewBatchProgress(files []string, durations map[string]float64) *BatchProgress {
+	bp := &BatchProgress{
+		weights: make([]float64, len(files)),
+		partial: make([]float64, len(files)),
+		start:   time.Now(),
+	}
+
+	// Files without a known duration are weighted by the average of the known ones
+	var known float64
+	var knownCount int
+	for _, file := range files {
+		if d := durations[file]; d > 0 {
+			known += d
+			knownCount++
+		}
+	}
+	bp.byDuration = knownCount > 0
+	average := 1.0
+	if bp.byDuration {
+		average = known / float64(knownCount)
+	}
+
+	for i, file := range files {
+		weight := average
+		if d := durations[file]; d > 0 {
+			weight = d
+		}
+		bp.weights[i] = weight
+		bp.total += weight
+	}
+
+	return bp
+}
+
+// ByDuration reports whether progress is weighted by source duration
+func (bp *BatchProgress) ByDuration() bool {
+	return bp.byDuration
+}
+
+// Update records in-file progress (0..1) for the file at index i
+func (bp *BatchProgress) Update(i int, fraction float64) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if i < 0 || i >= len(bp.partial) || bp.partial[i] >= 1 {
+		return
+	}
+	bp.partial[i] = clampFraction(fraction)
+}
+
+// Complete marks the file at index i as finished, whatever its outcome
+func (bp *BatchProgress) Complete(i int) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if i < 0 || i >= len(bp.partial) || bp.partial[i] >= 1 {
+		return
+	}
+	bp.partial[i] = 1
+	bp.completed++
+}
+
+// Fraction returns overall batch progress in the range 0..1
+func (bp *BatchProgress) Fraction() float64 {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.fraction()
+}
+
+func (bp *BatchProgress) fraction() float64 {
+	if bp.total == 0 {
+		return 0
+	}
+	var done float64
+	for i, p := range bp.partial {
+		done += bp.weights[i] * p
+	}
+	return done / bp.total
+}
+
+// ETA estimates the remaining time from the average rate so far
+func (bp *BatchProgress) ETA() time.Duration {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.eta(time.Since(bp.start))
+}
+
+func (bp *BatchProgress) eta(elapsed time.Duration) time.Duration {
+	f := bp.fraction()
+	if f <= 0 || f >= 1 {
+		return 0
+	}
+	return time.Duration(float64(elapsed) * (1 - f) / f)
+}
+
+// String renders a one-line progress summary
+func (bp *BatchProgress) String() string {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	line := fmt.Sprintf("Progress: %d/%d files completed (%.1f%%)",
+		bp.completed, len(bp.partial), bp.fraction()*100)
+	if eta := bp.eta(time.Since(bp.start)); eta > 0 {
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return line
+}
+
+// encodeStatus is one progress report of a running ffmpeg
+type encodeStatus struct {
+	Position time.Duration // Output position reached
+	Duration time.Duration // Source duration, set by the caller; zero when unknown
+	Frame    int64
+	FPS      float64
+	Speed    float64 // Multiple of real time; zero until ffmpeg has an estimate
+}
+
+// Fraction returns how much of the source has been encoded, in the range 0..1
+func (s encodeStatus) Fraction() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return clampFraction(s.Position.Seconds() / s.Duration.Seconds())
+}
+
+// ETA estimates the time left for the file from the current speed
+func (s encodeStatus) ETA() time.Duration {
+	if s.Speed <= 0 || s.Duration <= s.Position {
+		return 0
+	}
+	return time.Duration(float64(s.Duration-s.Position) / s.Speed)
+}
+
+// String renders the file's share of the status line
+func (s encodeStatus) String() string {
+	line := fmt.Sprintf("%.1f%%", s.Fraction()*100)
+	var details []string
+	if s.Frame > 0 {
+		details = append(details, fmt.Sprintf("frame %d", s.Frame))
+	}
+	if s.FPS > 0 {
+		details = append(details, fmt.Sprintf("%.0f fps", s.FPS))
+	}
+	if s.Speed > 0 {
+		details = append(details, fmt.Sprintf("%.2fx", s.Speed))
+	}
+	if len(details) > 0 {
+		line += " (" + strings.Join(details, ", ") + ")"
+	}
+	if eta := s.ETA(); eta > 0 {
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return line
+}
+
+// fileProgress receives in-file progress while a single file is encoding
+type fileProgress interface {
+	Update(status encodeStatus)
+	Done()
+}
+
+// liveLine is the single status line a batch redraws on a terminal, at most
+// once per interval. Every file being encoded has its part in it, so files
+// encoded in parallel with --jobs share the line instead of overwriting each
+// other's. A single file shows its full status, several only their
+// percentages to keep the line short.
+type liveLine struct {
+	mu       sync.Mutex
+	batch    *BatchProgress
+	out      io.Writer
+	interval time.Duration
+	statuses map[int]liveStatus // Files being encoded, by batch index
+	lastDraw time.Time
+	width    int
+}
+
+// liveStatus is the latest progress of one file on the live line
+type liveStatus struct {
+	name   string
+	status encodeStatus
+}
+
+// newLiveLine creates the live line of a batch
+func newLiveLine(batch *BatchProgress, out io.Writer, interval time.Duration) *liveLine {
+	return &liveLine{batch: batch, out: out, interval: interval, statuses: make(map[int]liveStatus)}
+}
+
+// update records a file's progress and redraws the line when due
+func (l *liveLine) update(index int, name string, status encodeStatus) {
+	l.batch.Update(index, status.Fraction())
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.statuses[index] = liveStatus{name: name, status: status}
+	if time.Since(l.lastDraw) < l.interval {
+		return
+	}
+	l.lastDraw = time.Now()
+
+	indexes := make([]int, 0, len(l.statuses))
+	for i := range l.statuses {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	line := l.batch.String()
+	for _, i := range indexes {
+		file := l.statuses[i]
+		if len(indexes) == 1 {
+			line += " | " + file.name + " " + file.status.String()
+		} else {
+			line += fmt.Sprintf(" | %s %.1f%%", file.name, file.status.Fraction()*100)
+		}
+	}
+	padding := ""
+	if len(line) < l.width {
+		padding = strings.Repeat(" ", l.width-len(line))
+	}
+	fmt.Fprintf(l.out, "\r%s%s", line, padding)
+	l.width = max(l.width, len(line))
+}
+
+// remove drops a finished file and erases the line so regular output starts
+// on a clean line; the files still encoding redraw it with their next update
+func (l *liveLine) remove(index int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.statuses, index)
+	if l.width > 0 {
+		fmt.Fprintf(l.out, "\r%s\r", strings.Repeat(" ", l.width))
+		l.width = 0
+	}
+	l.lastDraw = time.Time{}
+}
+
+// batchFileProgress feeds in-file progress of one file into the batch tracker
+// and its part of the live line
+type batchFileProgress struct {
+	line  *liveLine
+	index int
+	name  string // Shown next to the file's progress
+}
+
+func (p *batchFileProgress) Update(status encodeStatus) {
+	p.line.update(p.index, p.name, status)
+}
+
+// Done takes the file off the live line
+func (p *batchFileProgress) Done() {
+	p.line.remove(p.index)
+}
+
+// parseFFmpegProgress reads key=value lines written by ffmpeg's -progress
+// option and reports the status each time a progress block ends. Values ffmpeg
+// reports as N/A keep their previous value.
+func parseFFmpegProgress(r io.Reader, onStatus func(encodeStatus)) {
+	scanner := bufio.NewScanner(r)
+	var status encodeStatus
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us", "out_time_ms":
+			// Despite its name, out_time_ms is also reported in microseconds
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				status.Position = time.Duration(us) * time.Microsecond
+			}
+		case "frame":
+			if frame, err := strconv.ParseInt(value, 10, 64); err == nil && frame >= 0 {
+				status.Frame = frame
+			}
+		case "fps":
+			if fps, err := strconv.ParseFloat(value, 64); err == nil && fps >= 0 {
+				status.FPS = fps
+			}
+		case "speed":
+			if speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64); err == nil && speed >= 0 {
+				status.Speed = speed
+			}
+		case "progress":
+			onStatus(status)
+		}
+	}
+}
+
+// clampFraction limits a value to the range 0..1
+func clampFraction(f float64) float64 {
+	if f < 0 {
+		return 0
+	}
+	if f > 1 {
+		return 1
+	}
+	return f
+}
@@ -0,0 +1,119 @@
+package transcoder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectSetting is one key of a project file. Keys are the long flag names
+// of the main command, so `crf: 20` means the same as --crf 20.
+type ProjectSetting struct {
+	Key    string
+	Values []string // A single value, or one per list item for repeatable flags
+	Line   int      // Line of the key in the project file
+}
+
+// projectPathKeys are settings holding paths. Relative paths in a project
+// file are taken relative to the file, so a run does not depend on the
+// directory it is started from.
+var projectPathKeys = map[string]bool{
+	"input":        true,
+	"output":       true,
+	"csv-output":   true,
+	"json-output":  true,
+	"log-file":     true,
+	"manifest":     true,
+	"history":      true,
+	"sub-file":     true,
+	"temp-dir":     true,
+	"stage-dir":    true,
+	"probe-cache":  true,
+	"resume-file":  true,
+	"presets-file": true,
+}
+
+// LoadProject reads a YAML project file describing a whole run. Each key
+// must be accepted by known; values are scalars or lists of scalars. Errors
+// name the offending key and its line.
+func LoadProject(path string, known func(key string) bool) ([]ProjectSetting, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot read project file", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, NewTranscoderError(ErrorTypeInvalidFilePath,
+			fmt.Sprintf("project file %s is not valid YAML", path), err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, projectError(path, root.Line, "the project file must be a mapping of settings such as 'preset: 1080p_h265'")
+	}
+
+	dir := filepath.Dir(path)
+	seen := make(map[string]bool)
+	var settings []ProjectSetting
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode, valueNode := root.Content[i], root.Content[i+1]
+		key := keyNode.Value
+		if !known(key) {
+			return nil, projectError(path, keyNode.Line, fmt.Sprintf("unknown key '%s'", key))
+		}
+		if seen[key] {
+			return nil, projectError(path, keyNode.Line, fmt.Sprintf("key '%s' is set twice", key))
+		}
+		seen[key] = true
+
+		values, err := projectValues(valueNode)
+		if err != nil {
+			return nil, projectError(path, valueNode.Line, fmt.Sprintf("key '%s': %v", key, err))
+		}
+		if projectPathKeys[key] {
+			for j, value := range values {
+				if value != "" && !filepath.IsAbs(value) {
+					values[j] = filepath.Join(dir, value)
+				}
+			}
+		}
+		settings = append(settings, ProjectSetting{Key: key, Values: values, Line: keyNode.Line})
+	}
+	return settings, nil
+}
+
+// projectValues flattens a setting's YAML value into flag values
+func projectValues(node *yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return nil, fmt.Errorf("missing value")
+		}
+		return []string{node.Value}, nil
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("list items must be plain values")
+			}
+			values = append(values, item.Value)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("empty list")
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("expected a value or a list of values")
+}
+
+// projectError reports a problem at a line of a project file
+func projectError(path string, line int, msg string) error {
+	return NewTranscoderError(ErrorTypeInvalidFilePath,
+		fmt.Sprintf("%s:%d: %s", path, line, msg), nil)
+}
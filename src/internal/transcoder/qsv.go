@@ -0,0 +1,50 @@
+package transcoder
+
+import "strings"
+
+// qsvEncoders are the Quick Sync encoders the built-in presets use; ffmpeg
+// must provide both H.264 and HEVC for the platform to count as Quick Sync
+var qsvEncoders = []string{"h264_qsv", "hevc_qsv"}
+
+// isQSVEncoder reports whether an encoder runs on Intel Quick Sync
+func isQSVEncoder(encoder string) bool {
+	return strings.HasSuffix(encoder, "_qsv")
+}
+
+// qsvDeviceCount returns how many Intel GPUs Quick Sync can use. Linux
+// exposes each as a render node. Windows builds of ffmpeg list the QSV
+// encoders whatever the GPU, so there one test frame must encode. Other
+// systems have no Quick Sync support in ffmpeg.
+func (s *SystemChecker) qsvDeviceCount(goos string) int {
+	switch goos {
+	case "windows":
+		if s.SmokeTestEncoder(qsvEncoders[0]) == nil {
+			return 1
+		}
+	case "linux":
+		return len(s.vendorRenderNodes(intelPCIVendor))
+	}
+	return 0
+}
+
+// qsvDevice returns the render node of the Intel GPU at gpuIndex on Linux,
+// or "" when there is none. Windows exposes only one Quick Sync GPU.
+func (s *SystemChecker) qsvDevice(gpuIndex int) string {
+	nodes := s.vendorRenderNodes(intelPCIVendor)
+	if gpuIndex < 0 || gpuIndex >= len(nodes) {
+		return ""
+	}
+	return nodes[gpuIndex]
+}
+
+// hasQSVEncoders reports whether ffmpeg provides every Quick Sync encoder
+// the presets use
+func (s *SystemChecker) hasQSVEncoders() (bool, error) {
+	for _, encoder := range qsvEncoders {
+		available, err := s.CheckEncoderAvailability(encoder)
+		if err != nil || !available {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,128 @@
+package transcoder
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MaxCRF is the top of the unified quality scale (lower is better)
+const MaxCRF = 51
+
+// videoToolboxQualityPoints maps the unified CRF scale to VideoToolbox's
+// -q:v scale (1-100, higher is better). Points in between are interpolated.
+// The mapping is approximate: it was chosen so CRF 23 matches the q:v 65 the
+// VideoToolbox presets use for the same targets as the CRF 23 presets.
+var videoToolboxQualityPoints = []struct {
+	crf     float64
+	quality float64
+}{
+	{0, 100},
+	{18, 75},
+	{23, 65},
+	{28, 55},
+	{35, 40},
+	{51, 1},
+}
+
+// CRFToVideoToolboxQuality converts a unified CRF value to VideoToolbox -q:v
+func CRFToVideoToolboxQuality(crf int) int {
+	return int(math.Round(interpolate(float64(clampInt(crf, 0, MaxCRF)), false)))
+}
+
+// VideoToolboxQualityToCRF converts a VideoToolbox -q:v value to the unified CRF scale
+func VideoToolboxQualityToCRF(quality int) int {
+	return int(math.Round(interpolate(float64(clampInt(quality, 1, 100)), true)))
+}
+
+// interpolate maps a value across videoToolboxQualityPoints, from CRF to
+// quality or, when inverse is set, from quality to CRF
+func interpolate(value float64, inverse bool) float64 {
+	points := videoToolboxQualityPoints
+	for i := 1; i < len(points); i++ {
+		x0, y0, x1, y1 := points[i-1].crf, points[i-1].quality, points[i].crf, points[i].quality
+		if inverse {
+			x0, y0, x1, y1 = y0, x0, y1, x1
+		}
+		if (value >= x0 && value <= x1) || (value <= x0 && value >= x1) {
+			return y0 + (value-x0)*(y1-y0)/(x1-x0)
+		}
+	}
+	if inverse {
+		return points[len(points)-1].crf
+	}
+	return points[len(points)-1].quality
+}
+
+// clampInt limits v to [lo, hi]
+func clampInt(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
+
+// QualityArgs returns the arguments selecting a unified CRF value for an
+// encoder: -q:v for VideoToolbox, -cq for NVENC, -global_quality for Quick
+// Sync, a constant quantizer for AMD and -crf for software encoders
+func QualityArgs(encoder string, crf int) ([]string, error) {
+	if crf < 0 || crf > MaxCRF {
+		return nil, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("CRF %d is out of range (0-%d)", crf, MaxCRF), nil)
+	}
+	switch {
+	case strings.HasSuffix(encoder, "_videotoolbox"):
+		return []string{"-q:v", strconv.Itoa(CRFToVideoToolboxQuality(crf))}, nil
+	case strings.HasSuffix(encoder, "_nvenc"):
+		return []string{"-cq", strconv.Itoa(crf)}, nil
+	case isQSVEncoder(encoder):
+		// Quick Sync's ICQ scale runs 1-51 like CRF
+		return []string{"-global_quality", strconv.Itoa(max(crf, 1))}, nil
+	case isVAAPIEncoder(encoder):
+		// VAAPI and AMF have no constant-quality mode; a constant
+		// quantizer is the closest equivalent
+		return []string{"-rc_mode", "CQP", "-qp", strconv.Itoa(crf)}, nil
+	case isAMFEncoder(encoder):
+		qp := strconv.Itoa(crf)
+		return []string{"-rc", "cqp", "-qp_i", qp, "-qp_p", qp, "-qp_b", qp}, nil
+	default:
+		return []string{"-crf", strconv.Itoa(crf)}, nil
+	}
+}
+
+// applyQuality replaces the quality arguments of encoder arguments with the
+// configured --crf value, translated for the encoder in use
+func (t *Transcoder) applyQuality(args []string) []string {
+	if t.config.CRF == nil {
+		return args
+	}
+	qualityArgs, err := QualityArgs(argValue(args, "-c:v"), *t.config.CRF)
+	if err != nil {
+		return args
+	}
+
+	// Drop every quality flag so exactly one scale applies
+	result := make([]string, 0, len(args)+2)
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-crf", "-cq", "-q:v", "-global_quality", "-rc_mode", "-rc", "-qp", "-qp_i", "-qp_p", "-qp_b":
+			i++
+			continue
+		}
+		result = append(result, args[i])
+	}
+	return append(result, qualityArgs...)
+}
+
+// PresetCRF returns a preset's quality on the unified CRF scale
+func PresetCRF(preset Preset) (int, bool) {
+	if value := argValue(preset.Args, "-q:v"); value != "" {
+		if quality, err := strconv.Atoi(value); err == nil {
+			return VideoToolboxQualityToCRF(quality), true
+		}
+	}
+	for _, flag := range []string{"-crf", "-cq", "-global_quality"} {
+		if crf, err := strconv.Atoi(argValue(preset.Args, flag)); err == nil {
+			return crf, true
+		}
+	}
+	return 0, false
+}
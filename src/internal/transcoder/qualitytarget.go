@@ -0,0 +1,156 @@
+package transcoder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QualityTargets lists the --quality-target levels from smallest to best
+var QualityTargets = []string{"low", "medium", "high", "archival"}
+
+// targetSettings is what a quality target means for one encoder
+type targetSettings struct {
+	CRF   int    // Unified CRF, translated per encoder by QualityArgs
+	Speed string // -preset value; empty for encoders without one
+	Tune  string // Tune name from encoderTunes; empty for none
+}
+
+// nvencTargets applies to every NVENC encoder with that codec's CRFs
+func nvencTargets(low, medium, high, archival int) map[string]targetSettings {
+	return map[string]targetSettings{
+		"low":      {CRF: low, Speed: "p3"},
+		"medium":   {CRF: medium, Speed: "p5"},
+		"high":     {CRF: high, Speed: "p7", Tune: "hq"},
+		"archival": {CRF: archival, Speed: "p7", Tune: "hq"},
+	}
+}
+
+// quantizerTargets applies to the VideoToolbox, VAAPI and AMF encoders,
+// which have neither speed presets nor tunes
+func quantizerTargets(low, medium, high, archival int) map[string]targetSettings {
+	return map[string]targetSettings{
+		"low":      {CRF: low},
+		"medium":   {CRF: medium},
+		"high":     {CRF: high},
+		"archival": {CRF: archival},
+	}
+}
+
+// qsvTargets applies to every Quick Sync encoder, which take x264's speed
+// names but have no tunes
+func qsvTargets(low, medium, high, archival int) map[string]targetSettings {
+	return map[string]targetSettings{
+		"low":      {CRF: low, Speed: "veryfast"},
+		"medium":   {CRF: medium, Speed: "medium"},
+		"high":     {CRF: high, Speed: "slow"},
+		"archival": {CRF: archival, Speed: "veryslow"},
+	}
+}
+
+// encoderTargets maps each encoder and quality target to a coordinated CRF,
+// speed preset and tune. CRFs differ per codec because the same value gives
+// different quality in H.264, HEVC and AV1.
+var encoderTargets = map[string]map[string]targetSettings{
+	"libx264": {
+		"low":      {CRF: 28, Speed: "veryfast"},
+		"medium":   {CRF: 23, Speed: "medium"},
+		"high":     {CRF: 20, Speed: "slow"},
+		"archival": {CRF: 16, Speed: "veryslow", Tune: "film"},
+	},
+	"libx265": {
+		"low":      {CRF: 30, Speed: "veryfast"},
+		"medium":   {CRF: 26, Speed: "medium"},
+		"high":     {CRF: 22, Speed: "slow"},
+		"archival": {CRF: 18, Speed: "veryslow", Tune: "grain"},
+	},
+	"libsvtav1": {
+		"low":      {CRF: 34, Speed: "10"},
+		"medium":   {CRF: 28, Speed: "8"},
+		"high":     {CRF: 24, Speed: "5"},
+		"archival": {CRF: 20, Speed: "2", Tune: "film"},
+	},
+	"h264_nvenc":        nvencTargets(28, 23, 20, 16),
+	"hevc_nvenc":        nvencTargets(30, 26, 22, 18),
+	"av1_nvenc":         nvencTargets(34, 28, 24, 20),
+	"h264_videotoolbox": quantizerTargets(28, 23, 20, 16),
+	"hevc_videotoolbox": quantizerTargets(30, 26, 22, 18),
+	"h264_qsv":          qsvTargets(28, 23, 20, 16),
+	"hevc_qsv":          qsvTargets(30, 26, 22, 18),
+	"av1_qsv":           qsvTargets(34, 28, 24, 20),
+	"h264_vaapi":        quantizerTargets(28, 23, 20, 16),
+	"hevc_vaapi":        quantizerTargets(30, 26, 22, 18),
+	"av1_vaapi":         quantizerTargets(34, 28, 24, 20),
+	"h264_amf":          quantizerTargets(28, 23, 20, 16),
+	"hevc_amf":          quantizerTargets(30, 26, 22, 18),
+	"av1_amf":           quantizerTargets(34, 28, 24, 20),
+}
+
+// IsQualityTarget reports whether name is a known --quality-target level
+func IsQualityTarget(name string) bool {
+	for _, target := range QualityTargets {
+		if target == name {
+			return true
+		}
+	}
+	return false
+}
+
+// qualityTargetSettings returns what a quality target selects for an encoder
+func qualityTargetSettings(encoder, target string) (targetSettings, error) {
+	if !IsQualityTarget(target) {
+		return targetSettings{}, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("unknown quality target '%s' (supported: %s)", target, strings.Join(QualityTargets, ", ")), nil)
+	}
+	targets, ok := encoderTargets[encoder]
+	if !ok {
+		return targetSettings{}, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("encoder %s does not support --quality-target", encoder), nil)
+	}
+	return targets[target], nil
+}
+
+// applyQualityTarget replaces the speed preset and quality arguments of
+// encoder arguments with those of --quality-target. An explicit --crf is
+// applied afterwards and wins; the target's tune is picked up by
+// effectiveTune unless --tune is given.
+func (t *Transcoder) applyQualityTarget(args []string) []string {
+	if t.config.QualityTarget == "" {
+		return args
+	}
+	encoder := argValue(args, "-c:v")
+	settings, err := qualityTargetSettings(encoder, t.config.QualityTarget)
+	if err != nil {
+		t.log.Debugf("Ignoring quality target for %s", encoder)
+		return args
+	}
+	qualityArgs, err := QualityArgs(encoder, settings.CRF)
+	if err != nil {
+		return args
+	}
+
+	result := make([]string, 0, len(args)+4)
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-preset", "-crf", "-cq", "-q:v", "-global_quality", "-rc_mode", "-rc", "-qp", "-qp_i", "-qp_p", "-qp_b":
+			i++
+			continue
+		}
+		result = append(result, args[i])
+	}
+	if settings.Speed != "" {
+		result = append(result, "-preset", settings.Speed)
+	}
+	return append(result, qualityArgs...)
+}
+
+// targetTune returns the tune --quality-target selects for an encoder
+func (t *Transcoder) targetTune(encoder string) string {
+	if t.config.QualityTarget == "" {
+		return ""
+	}
+	settings, err := qualityTargetSettings(encoder, t.config.QualityTarget)
+	if err != nil {
+		return ""
+	}
+	return settings.Tune
+}
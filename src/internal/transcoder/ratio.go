@@ -0,0 +1,67 @@
+package transcoder
+
+import "fmt"
+
+// Ways --ratio-style shows an output's size next to its source's
+const (
+	RatioStyleSaved    = "saved"    // "saved 42.0% (1.2 GiB)"
+	RatioStyleOriginal = "original" // "58.0% of original size"
+)
+
+// RatioStyles lists the --ratio-style values, the default first
+var RatioStyles = []string{RatioStyleSaved, RatioStyleOriginal}
+
+// IsRatioStyle reports whether name is a known --ratio-style value
+func IsRatioStyle(name string) bool {
+	for _, style := range RatioStyles {
+		if style == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sizeRatio returns the output size as a fraction of the source size. ok is
+// false for an empty or unknown source, where the ratio would be NaN or
+// infinite.
+func sizeRatio(before, after float64) (ratio float64, ok bool) {
+	if before <= 0 || after < 0 {
+		return 0, false
+	}
+	return after / before, true
+}
+
+// FormatSizeChange describes an output's size against its source's in the
+// given style. Outputs larger than their source are shown as growth rather
+// than a negative saving.
+func FormatSizeChange(before, after int64, style string) string {
+	ratio, ok := sizeRatio(float64(before), float64(after))
+	if !ok {
+		return "source size unknown"
+	}
+	if style == RatioStyleOriginal {
+		return fmt.Sprintf("%.1f%% of original size", ratio*100)
+	}
+	if saved := before - after; saved < 0 {
+		return fmt.Sprintf("grew %.1f%% (%s)", (ratio-1)*100, FormatBytes(-saved))
+	}
+	return fmt.Sprintf("saved %.1f%% (%s)", (1-ratio)*100, FormatBytes(before-after))
+}
+
+// SizeChange records an output's size against its source's in machine
+// readable form, matching the compression_ratio and space_saved_percent
+// analytics columns
+type SizeChange struct {
+	CompressionRatio  float64 `json:"compression_ratio"`   // Output size as a fraction of the source size
+	SpaceSavedPercent float64 `json:"space_saved_percent"` // Negative when the output grew
+}
+
+// newSizeChange returns the size change of an output, or nil for an empty or
+// unknown source
+func newSizeChange(before, after int64) *SizeChange {
+	ratio, ok := sizeRatio(float64(before), float64(after))
+	if !ok {
+		return nil
+	}
+	return &SizeChange{CompressionRatio: ratio, SpaceSavedPercent: (1 - ratio) * 100}
+}
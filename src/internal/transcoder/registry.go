@@ -0,0 +1,159 @@
+package transcoder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SoftwareFallback is the software encoder used when a hardware encoder
+// cannot run, either because the hardware is missing or an encode failed
+type SoftwareFallback struct {
+	Encoder string // libx264 or libx265
+	Speed   string // -preset value for the software encoder; empty for "medium"
+}
+
+// softwareFallbackEncoders are the encoders convertToSoftwarePreset can
+// derive quality settings for
+var softwareFallbackEncoders = map[string]bool{
+	"libx264": true,
+	"libx265": true,
+}
+
+// builtinFallbacks maps the encoders of the built-in presets to their
+// software fallbacks. Encoders not listed here or registered fall back to
+// libx264.
+var builtinFallbacks = map[string]SoftwareFallback{
+	// NVIDIA NVENC encoders
+	"h264_nvenc": {Encoder: "libx264"},
+	"hevc_nvenc": {Encoder: "libx265"},
+	// AV1 falls back to H.264; a slower preset recovers some of the efficiency gap
+	"av1_nvenc": {Encoder: "libx264", Speed: "slower"},
+	// Apple VideoToolbox encoders
+	"h264_videotoolbox": {Encoder: "libx264"},
+	"hevc_videotoolbox": {Encoder: "libx265"},
+	// Intel Quick Sync encoders
+	"h264_qsv": {Encoder: "libx264"},
+	"hevc_qsv": {Encoder: "libx265"},
+	"av1_qsv":  {Encoder: "libx264", Speed: "slower"},
+	// AMD VAAPI (Linux) and AMF (Windows) encoders
+	"h264_vaapi": {Encoder: "libx264"},
+	"hevc_vaapi": {Encoder: "libx265"},
+	"av1_vaapi":  {Encoder: "libx264", Speed: "slower"},
+	"h264_amf":   {Encoder: "libx264"},
+	"hevc_amf":   {Encoder: "libx265"},
+	"av1_amf":    {Encoder: "libx264", Speed: "slower"},
+	// SVT-AV1 falls back to libx264 the same way
+	"libsvtav1": {Encoder: "libx264", Speed: "slower"},
+}
+
+// registry holds encoders and presets added at runtime by code embedding
+// the package, and the presets of a --presets-file
+var registry = struct {
+	sync.RWMutex
+	fallbacks map[string]SoftwareFallback
+	presets   map[string]Preset
+	user      map[string]Preset // From LoadPresetsFile; replace presets of the same name
+}{
+	fallbacks: make(map[string]SoftwareFallback),
+	presets:   make(map[string]Preset),
+}
+
+// RegisterEncoder makes a custom encoder, such as one for special hardware or
+// an experimental ffmpeg build, known together with the software encoder to
+// use when it cannot run. Built-in encoders cannot be redefined.
+func RegisterEncoder(encoder string, fallback SoftwareFallback) error {
+	if encoder == "" || strings.ContainsAny(encoder, " \t\n") {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("invalid encoder name '%s'", encoder), nil)
+	}
+	if _, ok := builtinFallbacks[encoder]; ok || softwareFallbackEncoders[encoder] {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("encoder %s is built in and cannot be registered", encoder), nil)
+	}
+	if !softwareFallbackEncoders[fallback.Encoder] {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("software fallback for %s must be libx264 or libx265, not '%s'", encoder, fallback.Encoder), nil)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.fallbacks[encoder]; ok {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("encoder %s is already registered", encoder), nil)
+	}
+	registry.fallbacks[encoder] = fallback
+	return nil
+}
+
+// RegisterPreset adds a preset that GetPresets, IsValidPreset and new
+// Transcoders see alongside the built-in ones. Its Args must select its
+// Encoder with -c:v. A preset without a Platform runs on any platform
+// whenever hardware encoding is allowed; otherwise, and after a failed
+// encode, it uses the software fallback of its encoder. Presets must be
+// registered before the Transcoder that uses them is created.
+func RegisterPreset(preset Preset) error {
+	if preset.Name == "" {
+		return NewTranscoderError(ErrorTypeInvalidPreset, "a registered preset needs a name", nil)
+	}
+	if _, ok := presetCache[preset.Name]; ok {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("preset %s is built in and cannot be registered", preset.Name), nil)
+	}
+	if preset.Encoder == "" || argValue(preset.Args, "-c:v") != preset.Encoder {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("preset %s must select its encoder with -c:v %s", preset.Name, preset.Encoder), nil)
+	}
+	preset.Args = append([]string(nil), preset.Args...)
+
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.presets[preset.Name]; ok {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("preset %s is already registered", preset.Name), nil)
+	}
+	registry.presets[preset.Name] = preset
+	return nil
+}
+
+// registeredPresets returns a copy of the registered presets
+func registeredPresets() map[string]Preset {
+	registry.RLock()
+	defer registry.RUnlock()
+	presets := make(map[string]Preset, len(registry.presets))
+	for name, preset := range registry.presets {
+		presets[name] = preset
+	}
+	return presets
+}
+
+// registeredPresetNames returns the names of the registered presets, sorted
+func registeredPresetNames() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	names := make([]string, 0, len(registry.presets))
+	for name := range registry.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// softwareFallbackFor returns the software fallback of a built-in or
+// registered encoder, or libx264 for any other
+func softwareFallbackFor(encoder string) SoftwareFallback {
+	fallback, ok := builtinFallbacks[encoder]
+	if !ok {
+		registry.RLock()
+		fallback, ok = registry.fallbacks[encoder]
+		registry.RUnlock()
+	}
+	if !ok {
+		fallback = SoftwareFallback{Encoder: "libx264"}
+	}
+	if fallback.Speed == "" {
+		fallback.Speed = "medium"
+	}
+	return fallback
+}
@@ -0,0 +1,142 @@
+package transcoder
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Container problems --repair fixes with a stream-copy remux
+const (
+	RepairFaststart   = "missing faststart" // MP4 index (moov) stored after the media data
+	RepairBrokenIndex = "broken index"      // Container reports no duration, so seeking fails
+)
+
+// faststartExtensions are the MP4-family containers that carry a moov index
+var faststartExtensions = map[string]bool{
+	".mp4": true,
+	".m4v": true,
+	".mov": true,
+}
+
+// repairIssues decides which fixable problems a file has from its probe and,
+// for MP4-family files, whether the index follows the media data
+func repairIssues(path string, info *ProbeInfo, moovLate bool) []string {
+	var issues []string
+	if faststartExtensions[strings.ToLower(filepath.Ext(path))] && moovLate {
+		issues = append(issues, RepairFaststart)
+	}
+	if info != nil && info.HasVideo() && info.Duration <= 0 {
+		issues = append(issues, RepairBrokenIndex)
+	}
+	return issues
+}
+
+// repairArgs builds the stream-copy remux that fixes issues. Every stream is
+// copied into a rewritten container; MP4-family files get their index moved
+// to the front, and timestamps are regenerated when the index is broken.
+func repairArgs(inputPath, outputPath string, issues []string) []string {
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	for _, issue := range issues {
+		if issue == RepairBrokenIndex {
+			args = append(args, "-fflags", "+genpts")
+			break
+		}
+	}
+	args = append(args, "-i", inputPath, "-map", "0", "-c", "copy")
+	if faststartExtensions[strings.ToLower(filepath.Ext(outputPath))] {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, "-y", outputPath)
+}
+
+// moovAfterMdat walks the top-level boxes of an MP4-family file and reports
+// whether the media data comes before the moov index. Files without either
+// box report false; a missing index is not something a remux can fix.
+func moovAfterMdat(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var header [16]byte
+	var offset int64
+	for {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		switch string(header[4:8]) {
+		case "moov":
+			return false, nil
+		case "mdat":
+			return true, nil
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		switch size {
+		case 0:
+			// The box runs to the end of the file
+			return false, nil
+		case 1:
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return false, nil
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if size < 8 {
+			return false, nil
+		}
+		offset += size
+	}
+}
+
+// repairInput remuxes an input with fixable container problems into the temp
+// directory and uses the result as the source of the encode. It reports what
+// was repaired; nothing is done when the file needs no repair.
+func (t *Transcoder) repairInput(ctx context.Context, inputPath string, info *ProbeInfo) ([]string, error) {
+	if _, ok := t.dvdTitles[inputPath]; ok {
+		return nil, nil
+	}
+
+	moovLate := false
+	if faststartExtensions[strings.ToLower(filepath.Ext(inputPath))] {
+		late, err := moovAfterMdat(inputPath)
+		if err != nil {
+			t.log.Debugf("Could not read the container of %s: %v", inputPath, err)
+		}
+		moovLate = late
+	}
+	issues := repairIssues(inputPath, info, moovLate)
+	if len(issues) == 0 {
+		return nil, nil
+	}
+
+	dir, err := t.temp.CreateDir("repair-*")
+	if err != nil {
+		return nil, err
+	}
+	repairedPath := filepath.Join(dir, filepath.Base(inputPath))
+	args := repairArgs(inputPath, repairedPath, issues)
+	t.log.Debugf("Running: %s", FormatCommand("ffmpeg", args))
+	if stderr, err := t.runFFmpeg(ctx, inputPath, args, nil); err != nil {
+		os.RemoveAll(dir)
+		return nil, NewTranscoderError(ErrorTypeEncodingFailed,
+			fmt.Sprintf("repair remux failed for %s: %s", inputPath, strings.TrimSpace(stderr)), err)
+	}
+
+	t.stateMu.Lock()
+	if t.repaired == nil {
+		t.repaired = make(map[string]string)
+	}
+	t.repaired[inputPath] = repairedPath
+	t.stateMu.Unlock()
+	return issues, nil
+}
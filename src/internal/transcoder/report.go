@@ -0,0 +1,174 @@
+package transcoder
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ExistingReport pairs existing outputs with their sources without re-encoding
+type ExistingReport struct {
+	Records        []AnalyticsRecord
+	MissingOutputs []string // Sources with no matching output
+	OrphanOutputs  []string // Outputs with no matching source
+}
+
+// TotalBeforeMB returns the combined size of all paired sources
+func (r *ExistingReport) TotalBeforeMB() float64 {
+	var total float64
+	for _, record := range r.Records {
+		total += record.SizeBeforeMB
+	}
+	return total
+}
+
+// TotalAfterMB returns the combined size of all paired outputs
+func (r *ExistingReport) TotalAfterMB() float64 {
+	var total float64
+	for _, record := range r.Records {
+		total += record.SizeAfterMB
+	}
+	return total
+}
+
+// WriteCSV writes the paired records in the regular analytics CSV format
+func (r *ExistingReport) WriteCSV(w *csv.Writer) error {
+	if err := WriteCSVHeader(w); err != nil {
+		return err
+	}
+	for _, record := range r.Records {
+		if err := writeCSVRecord(w, record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// ReportExisting pairs sources under the input paths with outputs under the
+// output directory. Pairs are found by regenerating each source's output path
+// for the configured preset (or every preset when none is set), or, with
+// useSidecars, from the sidecar records written next to the outputs.
+func (t *Transcoder) ReportExisting(useSidecars bool) (*ExistingReport, error) {
+	sources, err := t.FindVideoFiles()
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := t.fileDiscovery.FindVideoFiles(t.config.OutputDir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var pairs map[string][]existingPair
+	if useSidecars {
+		pairs, err = t.pairFromSidecars(outputs)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		pairs = t.pairFromPaths(sources)
+	}
+
+	report := &ExistingReport{}
+	claimed := make(map[string]bool)
+	for _, source := range sources {
+		matches := pairs[filepath.Clean(source)]
+		if len(matches) == 0 {
+			report.MissingOutputs = append(report.MissingOutputs, source)
+			continue
+		}
+		sourceInfo, err := os.Stat(source)
+		if err != nil {
+			report.MissingOutputs = append(report.MissingOutputs, source)
+			continue
+		}
+		for _, pair := range matches {
+			outputInfo, err := os.Stat(pair.output)
+			if err != nil {
+				continue
+			}
+			claimed[filepath.Clean(pair.output)] = true
+
+			record := AnalyticsRecord{
+				Filename:     filepath.Base(source),
+				StartTime:    outputInfo.ModTime(),
+				EndTime:      outputInfo.ModTime(),
+				SizeBeforeMB: bytesToMB(sourceInfo.Size()),
+				SizeAfterMB:  bytesToMB(outputInfo.Size()),
+				Preset:       pair.preset,
+				Status:       "existing",
+			}
+			if pair.sidecar != nil {
+				record.StartTime = pair.sidecar.StartedAt
+				record.EndTime = pair.sidecar.FinishedAt
+				record.DurationSeconds = pair.sidecar.DurationSeconds
+			}
+			report.Records = append(report.Records, record)
+		}
+	}
+
+	for _, output := range outputs {
+		if !claimed[filepath.Clean(output)] {
+			report.OrphanOutputs = append(report.OrphanOutputs, output)
+		}
+	}
+
+	return report, nil
+}
+
+// existingPair is an output matched to a source
+type existingPair struct {
+	output  string
+	preset  string
+	sidecar *Sidecar
+}
+
+// pairFromPaths regenerates output paths for each source and keeps those that exist
+func (t *Transcoder) pairFromPaths(sources []string) map[string][]existingPair {
+	presetNames := []string{t.config.Preset}
+	if t.config.Preset == "" {
+		presetNames = append([]string(nil), GetAvailablePresets()...)
+		sort.Strings(presetNames)
+	}
+
+	pairs := make(map[string][]existingPair)
+	for _, source := range sources {
+		for _, name := range presetNames {
+			preset, ok := t.presets[name]
+			if !ok {
+				continue
+			}
+			output := t.pathUtils.GenerateOutputPath(t.outputSource(source), t.config.OutputDir, t.inputBase(source), preset)
+			if _, err := os.Stat(output); err == nil {
+				key := filepath.Clean(source)
+				pairs[key] = append(pairs[key], existingPair{output: output, preset: name})
+			}
+		}
+	}
+	return pairs
+}
+
+// pairFromSidecars reads the sidecar of every output to find its source
+func (t *Transcoder) pairFromSidecars(outputs []string) (map[string][]existingPair, error) {
+	pairs := make(map[string][]existingPair)
+	for _, output := range outputs {
+		data, err := os.ReadFile(SidecarPath(output))
+		if err != nil {
+			continue
+		}
+		var sidecar Sidecar
+		if err := json.Unmarshal(data, &sidecar); err != nil {
+			return nil, NewTranscoderError(ErrorTypeFileSystemError,
+				"invalid sidecar "+SidecarPath(output), err)
+		}
+		if t.config.Preset != "" && !strings.EqualFold(sidecar.Preset, t.config.Preset) {
+			continue
+		}
+		key := filepath.Clean(sidecar.SourcePath)
+		pairs[key] = append(pairs[key], existingPair{output: output, preset: sidecar.Preset, sidecar: &sidecar})
+	}
+	return pairs, nil
+}
@@ -0,0 +1,56 @@
+package transcoder
+
+import "time"
+
+// Encoding modes recorded in FileResult
+const (
+	EncodingModeHardware         = "hardware"
+	EncodingModeSoftware         = "software"
+	EncodingModeSoftwareFallback = "software_fallback"
+	EncodingModeSafeFallback     = "safe_fallback"
+)
+
+// Reasons recorded in FileResult.SkipReason
+const (
+	SkipReasonOutputExists = "output_exists"
+	SkipReasonNoVideo      = "no_video"
+	SkipReasonUser         = "user"
+	SkipReasonDryRun       = "dry_run"
+	SkipReasonTargetCodec  = "target_codec" // Already in the preset's codec and resolution (--skip-if-codec)
+)
+
+// FileResult describes the outcome of processing a single input file
+type FileResult struct {
+	InputPath     string
+	OutputPath    string
+	Preset        Preset
+	EncodingMode  string
+	Encoder       string   // Video encoder of the attempt that produced the output
+	Args          []string // FFmpeg arguments of the first encode attempt
+	StartTime     time.Time
+	EndTime       time.Time
+	InputSize     int64
+	OutputSize    int64
+	Skipped       bool          // File was not encoded; see SkipReason
+	SkipReason    string        // Why the file was skipped (SkipReasonOutputExists, SkipReasonNoVideo, SkipReasonUser, SkipReasonDryRun, SkipReasonTargetCodec)
+	SourceCodec   string        // Source video codec from probing, empty if unknown
+	TargetBitrate float64       // Bitrate in bits/s chosen by --adaptive-bitrate or --smart-bitrate, 0 when the preset's applies
+	BitrateCapped bool          // --smart-bitrate lowered the target bitrate to fit the source's
+	Repairs       []string      // Container problems fixed by --repair before encoding
+	Energy        float64       // Approximate energy in joules the encode used (--energy)
+	PowerSampled  bool          // Energy comes from sampled GPU power draw rather than an assumed wattage
+	Quality       QualityScores // Scores against the source with --measure-quality or --measure-ssim
+	SourceProbe   *ProbeInfo    // Populated when source probing is enabled
+	OutputProbe   *ProbeInfo    // Populated when output probing is enabled
+	Others        []*FileResult // Results of the further presets when a file is encoded to several
+}
+
+// outputs returns the result of every preset the file was encoded with
+func (r *FileResult) outputs() []*FileResult {
+	return append([]*FileResult{r}, r.Others...)
+}
+
+// Duration returns the wall-clock time spent processing the file
+func (r *FileResult) Duration() time.Duration {
+	return r.EndTime.Sub(r.StartTime)
+}
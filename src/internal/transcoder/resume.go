@@ -0,0 +1,179 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resumeStateVersion is bumped when the state file layout changes; older
+// states are discarded
+const resumeStateVersion = 1
+
+// ResumeStateName is the state file --resume keeps in the output directory
+// unless --resume-file names another
+const ResumeStateName = ".ffmcli-resume.json"
+
+// Statuses of a file in the resume state
+const (
+	ResumeStatusPending = "pending" // Queued or being encoded when the run stopped
+	ResumeStatusDone    = "done"    // Encoded, or skipped because its output existed
+	ResumeStatusFailed  = "failed"  // The encode failed
+)
+
+// ResumeState records the status of each file of a run in a JSON file so
+// a rerun after a crash or kill can skip finished files. Entries are keyed
+// by absolute path and only count for the presets they were written with.
+type ResumeState struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]resumeEntry
+}
+
+// resumeEntry is the status of one source file
+type resumeEntry struct {
+	Preset  string    `json:"preset"` // Comma-separated presets the file is encoded with
+	Status  string    `json:"status"`
+	Updated time.Time `json:"updated"`
+}
+
+// resumeStateFile is the on-disk layout of a resume state
+type resumeStateFile struct {
+	Version int                    `json:"version"`
+	Files   map[string]resumeEntry `json:"files"`
+}
+
+// LoadResumeState reads a resume state, starting empty when the file does
+// not exist yet. Unlike the probe cache, a damaged state is an error: the
+// user asked to resume, and silently starting over could redo hours of work.
+func LoadResumeState(path string) (*ResumeState, error) {
+	state := &ResumeState{path: path, entries: make(map[string]resumeEntry)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot read resume state "+path, err)
+	}
+
+	var stored resumeStateFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"invalid resume state "+path+" (delete it to start over)", err)
+	}
+	if stored.Version == resumeStateVersion && stored.Files != nil {
+		state.entries = stored.Files
+	}
+	return state, nil
+}
+
+// Status returns the recorded status of a file for the given presets, or ""
+// when the file has no entry or was recorded with other presets
+func (s *ResumeState) Status(file, preset string) string {
+	key, err := filepath.Abs(file)
+	if err != nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || entry.Preset != preset {
+		return ""
+	}
+	return entry.Status
+}
+
+// Set records the status of a file without saving
+func (s *ResumeState) Set(file, preset, status string) {
+	key, err := filepath.Abs(file)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.entries[key] = resumeEntry{Preset: preset, Status: status, Updated: time.Now()}
+	s.mu.Unlock()
+}
+
+// Save writes the state, replacing the file atomically so a kill while
+// saving leaves the previous state intact
+func (s *ResumeState) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(resumeStateFile{Version: resumeStateVersion, Files: s.entries}, "", "  ")
+	if err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError, "failed to encode resume state", err)
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// OpenResumeState loads the state configured with --resume
+func (t *Transcoder) OpenResumeState() error {
+	if t.config.ResumeFile == "" {
+		return nil
+	}
+	state, err := LoadResumeState(t.config.ResumeFile)
+	if err != nil {
+		return err
+	}
+	t.resume = state
+	return nil
+}
+
+// resumeKey identifies the presets a file is encoded with, so switching
+// presets makes earlier entries stale
+func (t *Transcoder) resumeKey(file string) string {
+	return strings.Join(t.presetNamesFor(file), ",")
+}
+
+// FilterResumed leaves out files the resume state records as done with the
+// same presets, and failed ones unless --retry-failed is set
+func (t *Transcoder) FilterResumed(files []string) []string {
+	if t.resume == nil {
+		return files
+	}
+
+	var kept []string
+	done, failed := 0, 0
+	for _, file := range files {
+		switch t.resume.Status(file, t.resumeKey(file)) {
+		case ResumeStatusDone:
+			done++
+			t.log.Debugf("Skipping %s (done in an earlier run)", file)
+			continue
+		case ResumeStatusFailed:
+			if !t.config.RetryFailed {
+				failed++
+				t.log.Debugf("Skipping %s (failed in an earlier run)", file)
+				continue
+			}
+		}
+		kept = append(kept, file)
+	}
+
+	if done > 0 || failed > 0 {
+		t.log.Infof("Resuming: skipped %d file(s) done and %d failed in an earlier run", done, failed)
+		if failed > 0 {
+			t.log.Infof("Pass --retry-failed to encode the failed files again")
+		}
+	}
+	return kept
+}
+
+// markResume records the status of files in the resume state and saves it,
+// warning rather than failing the run when the state cannot be written
+func (t *Transcoder) markResume(status string, files ...string) {
+	if t.resume == nil {
+		return
+	}
+	for _, file := range files {
+		t.resume.Set(file, t.resumeKey(file), status)
+	}
+	if err := t.resume.Save(); err != nil {
+		t.log.Warnf("failed to save resume state: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package transcoder
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backoff between retries of a transiently failed encode: the first retry
+// waits RetryBaseDelay, each further one twice as long up to RetryMaxDelay
+const (
+	RetryBaseDelay = 5 * time.Second
+	RetryMaxDelay  = 2 * time.Minute
+)
+
+// transientFailures maps lowercase ffmpeg error output that a retry can get
+// past to the reason reported; shared GPUs run out of memory or encoder
+// sessions while other jobs hold them
+var transientFailures = []struct {
+	marker string
+	reason string
+}{
+	{"out of memory", "GPU out of memory"},
+	{"out_of_memory", "GPU out of memory"},
+	{"incompatible client key", "no free NVENC sessions"},
+	{"no free sessions", "no free encoder sessions"},
+	{"device or resource busy", "device busy"},
+	{"resource temporarily unavailable", "device busy"},
+}
+
+// transientFailure returns why an encode whose ffmpeg output is stderr failed
+// for a reason that may pass on its own, or false for any other failure
+func transientFailure(stderr string) (string, bool) {
+	stderr = strings.ToLower(stderr)
+	for _, failure := range transientFailures {
+		if strings.Contains(stderr, failure.marker) {
+			return failure.reason, true
+		}
+	}
+	return "", false
+}
+
+// retryDelay returns the wait before a retry (1 for the first)
+func retryDelay(retry int) time.Duration {
+	delay := RetryBaseDelay
+	for i := 1; i < retry && delay < RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, RetryMaxDelay)
+}
+
+// sleepContext waits for d, returning early with ctx's error when it is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// encodeWithRetries runs an encode, repeating it up to --retries times while
+// it fails with a transient error. Any other failure, or one that is still
+// there after the last retry, is returned for the fallbacks to handle.
+func (t *Transcoder) encodeWithRetries(ctx context.Context, inputPath string, args []string, preset Preset, progress fileProgress) (string, error) {
+	stderrOutput, err := t.encode(ctx, inputPath, args, preset, progress)
+	for retry := 1; err != nil && retry <= t.config.Retries && ctx.Err() == nil; retry++ {
+		reason, ok := transientFailure(stderrOutput)
+		if !ok {
+			break
+		}
+		delay := retryDelay(retry)
+		t.log.Infof("Attempt %d of %d for %s failed (%s); retrying in %s",
+			retry, t.config.Retries+1, filepath.Base(inputPath), reason, delay)
+		if t.sleep(ctx, delay) != nil {
+			break
+		}
+		stderrOutput, err = t.encode(ctx, inputPath, args, preset, progress)
+	}
+	return stderrOutput, err
+}
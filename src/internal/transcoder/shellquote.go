@@ -0,0 +1,91 @@
+package transcoder
+
+import (
+	"runtime"
+	"strings"
+)
+
+// FormatCommand renders a command and its arguments as a single line that can
+// be copy-pasted into the current platform's shell
+func FormatCommand(name string, args []string) string {
+	return formatCommandFor(runtime.GOOS, name, args)
+}
+
+// formatCommandFor renders a command line using the quoting rules of the given OS
+func formatCommandFor(goos, name string, args []string) string {
+	quote := quotePOSIXArg
+	if goos == "windows" {
+		quote = quoteWindowsArg
+	}
+
+	parts := make([]string, 0, len(args)+1)
+	parts = append(parts, quote(name))
+	for _, arg := range args {
+		parts = append(parts, quote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// quotePOSIXArg quotes an argument for sh-compatible shells
+func quotePOSIXArg(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+
+	safe := true
+	for _, r := range arg {
+		if !isShellSafeRune(r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return arg
+	}
+
+	// Single quotes disable all expansion; embedded single quotes are closed,
+	// escaped and reopened
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// quoteWindowsArg quotes an argument following the MSVCRT command line rules
+func quoteWindowsArg(arg string) string {
+	if arg == "" {
+		return `""`
+	}
+	if !strings.ContainsAny(arg, " \t\"&|<>^%") {
+		return arg
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	backslashes := 0
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			backslashes++
+		case '"':
+			// Backslashes preceding a quote must be doubled, then the quote escaped
+			b.WriteString(strings.Repeat(`\`, backslashes*2+1))
+			b.WriteRune(r)
+			backslashes = 0
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+			b.WriteRune(r)
+			backslashes = 0
+		}
+	}
+	// Trailing backslashes would escape the closing quote, so double them
+	b.WriteString(strings.Repeat(`\`, backslashes*2))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// isShellSafeRune reports whether a rune can appear unquoted in a POSIX shell word
+func isShellSafeRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("-_./:=,+@%", r)
+}
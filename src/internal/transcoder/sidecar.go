@@ -0,0 +1,121 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SidecarSchemaVersion is bumped whenever the sidecar layout changes incompatibly
+const SidecarSchemaVersion = 1
+
+// Sidecar is the machine-readable record written next to each output as <output>.json
+type Sidecar struct {
+	SchemaVersion   int             `json:"schema_version"`
+	Tool            string          `json:"tool"`
+	ToolVersion     string          `json:"tool_version"`
+	SourcePath      string          `json:"source_path"`
+	OutputPath      string          `json:"output_path"`
+	Preset          string          `json:"preset"`
+	Settings        SidecarSettings `json:"settings"`
+	Source          *ProbeInfo      `json:"source,omitempty"`
+	Output          *ProbeInfo      `json:"output,omitempty"`
+	SizeChange      *SizeChange     `json:"size_change,omitempty"` // Omitted when the source size is unknown
+	StartedAt       time.Time       `json:"started_at"`
+	FinishedAt      time.Time       `json:"finished_at"`
+	DurationSeconds float64         `json:"duration_seconds"`
+}
+
+// SidecarSettings captures the encoder settings used for an output
+type SidecarSettings struct {
+	Codec        string   `json:"codec"`
+	Encoder      string   `json:"encoder"`
+	Resolution   string   `json:"resolution"`
+	Bitrate      string   `json:"bitrate"`
+	AudioCodec   string   `json:"audio_codec"`
+	EncodingMode string   `json:"encoding_mode"`
+	FFmpegArgs   []string `json:"ffmpeg_args"`
+}
+
+// SidecarPath returns the sidecar location for an output file
+func SidecarPath(outputPath string) string {
+	return outputPath + ".json"
+}
+
+// newSidecar builds a sidecar record from a completed file result
+func (t *Transcoder) newSidecar(result *FileResult) Sidecar {
+	version := t.config.ToolVersion
+	if version == "" {
+		version = "dev"
+	}
+	return Sidecar{
+		SchemaVersion: SidecarSchemaVersion,
+		Tool:          "ffmcli",
+		ToolVersion:   version,
+		SourcePath:    result.InputPath,
+		OutputPath:    result.OutputPath,
+		Preset:        result.Preset.Name,
+		Settings: SidecarSettings{
+			Codec:        result.Preset.Codec,
+			Encoder:      result.Preset.Encoder,
+			Resolution:   result.Preset.Resolution,
+			Bitrate:      result.Preset.Bitrate,
+			AudioCodec:   t.config.AudioCodec,
+			EncodingMode: result.EncodingMode,
+			FFmpegArgs:   result.Args,
+		},
+		Source:          result.SourceProbe,
+		Output:          result.OutputProbe,
+		SizeChange:      newSizeChange(result.InputSize, result.OutputSize),
+		StartedAt:       result.StartTime,
+		FinishedAt:      result.EndTime,
+		DurationSeconds: result.Duration().Seconds(),
+	}
+}
+
+// writeSidecar atomically writes the sidecar for a result next to its output
+func (t *Transcoder) writeSidecar(result *FileResult) error {
+	data, err := json.MarshalIndent(t.newSidecar(result), "", "  ")
+	if err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError, "failed to encode sidecar", err)
+	}
+	return writeFileAtomic(SidecarPath(result.OutputPath), append(data, '\n'))
+}
+
+// discardOutput removes an output file along with its sidecar, if any
+func discardOutput(outputPath string) {
+	os.Remove(outputPath)
+	os.Remove(SidecarPath(outputPath))
+}
+
+// writeFileAtomic writes data to a temporary file in the target directory and
+// renames it into place so readers never observe a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError, "failed to create temp file", err)
+	}
+	tmpName := tmp.Name()
+
+	// CreateTemp uses 0600; match the permissions of a regular os.Create
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return NewTranscoderError(ErrorTypeFileSystemError, "failed to write "+path, err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return NewTranscoderError(ErrorTypeFileSystemError, "failed to write "+path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return NewTranscoderError(ErrorTypeFileSystemError, "failed to write "+path, err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return NewTranscoderError(ErrorTypeFileSystemError, "failed to move "+path+" into place", err)
+	}
+	return nil
+}
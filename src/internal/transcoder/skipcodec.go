@@ -0,0 +1,45 @@
+package transcoder
+
+// presetVideoCodec returns the codec a preset produces as ffprobe names it,
+// or "" when neither its encoder nor its codec label is recognized
+func presetVideoCodec(preset Preset) string {
+	if codec := encoderVideoCodec(preset.Encoder); codec != "" {
+		return codec
+	}
+	return normalizeCodecName(preset.Codec)
+}
+
+// inTargetCodec reports whether a source already has the video codec a
+// preset produces, at no more than the preset's resolution. Sizes compare
+// the long and short sides, so a portrait source fits a landscape preset of
+// the same size. A source of unknown size never matches a preset that
+// scales, and presets without a resolution match any size.
+func inTargetCodec(info *ProbeInfo, preset Preset) bool {
+	target := presetVideoCodec(preset)
+	if target == "" || normalizeCodecName(info.VideoCodec) != target {
+		return false
+	}
+	boxW, boxH, ok := parseResolution(preset.Resolution)
+	if !ok {
+		return true
+	}
+	width, height := info.DisplaySize()
+	if width <= 0 || height <= 0 {
+		return false
+	}
+	return max(width, height) <= max(boxW, boxH) && min(width, height) <= min(boxW, boxH)
+}
+
+// skipTargetCodec reports whether --skip-if-codec leaves a source alone for
+// a preset. Sources that cannot be probed are encoded, so the encode
+// reports the problem.
+func (t *Transcoder) skipTargetCodec(inputPath string, preset Preset) (*ProbeInfo, bool) {
+	if !t.config.SkipIfCodec {
+		return nil, false
+	}
+	info, err := t.prober.Probe(t.mediaInput(inputPath))
+	if err != nil {
+		return nil, false
+	}
+	return info, inTargetCodec(info, preset)
+}
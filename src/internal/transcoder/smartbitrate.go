@@ -0,0 +1,41 @@
+package transcoder
+
+// smartBitrateFactor is the share of the source's bitrate --smart-bitrate
+// allows an output; re-encoding never recovers quality, so a target at or
+// above the source's only makes the file bigger
+const smartBitrateFactor = 0.8
+
+// SourceVideoBitrate returns the bitrate of the primary video stream in
+// bits/s. Containers such as Matroska often leave the stream's bitrate out;
+// the overall bitrate, which includes audio, stands in for it then.
+func (p *ProbeInfo) SourceVideoBitrate() float64 {
+	if p.VideoBitrate > 0 {
+		return float64(p.VideoBitrate)
+	}
+	return float64(p.Bitrate)
+}
+
+// smartBitrate returns the capped target bitrate of a file with
+// --smart-bitrate and the source bitrate it was derived from. capped is
+// false when the target, the adaptive one or the preset's, is already low
+// enough, or when the preset has no target bitrate or the source's is
+// unknown; the target is never raised.
+func (t *Transcoder) smartBitrate(inputPath string, preset Preset) (rate, source float64, capped bool) {
+	target, err := parseSIValue(argValue(t.applyAdaptiveBitrate(inputPath, preset, t.videoArgs(preset, t.useHardware(preset))), "-b:v"))
+	if err != nil || target <= 0 {
+		t.log.Debugf("Smart bitrate: preset %s has no target bitrate to cap", preset.Name)
+		return 0, 0, false
+	}
+	info, err := t.prober.Probe(t.mediaInput(inputPath))
+	if err != nil || info.SourceVideoBitrate() <= 0 {
+		t.log.Debugf("Smart bitrate: source bitrate of %s unknown; using %s", inputPath, formatBitrateArg(target))
+		return 0, 0, false
+	}
+
+	source = info.SourceVideoBitrate()
+	rate = source * smartBitrateFactor
+	if rate >= target {
+		return 0, source, false
+	}
+	return rate, source, true
+}
@@ -0,0 +1,124 @@
+package transcoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EncoderSpeeds lists the --encoder-speed names from fastest to slowest. They
+// are x264's preset names and are translated for other encoders.
+var EncoderSpeeds = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
+
+// encoderSpeedValues maps each speed name, in EncoderSpeeds order, to the
+// -preset value of encoders that name their presets differently
+var encoderSpeedValues = map[string][]string{
+	"nvenc":     {"p1", "p1", "p2", "p3", "p3", "p4", "p5", "p6", "p7"},
+	"libsvtav1": {"12", "11", "10", "9", "8", "7", "5", "4", "2"},
+	// Quick Sync uses x264's names from veryfast on
+	"qsv": {"veryfast", "veryfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"},
+}
+
+// Ranges of the encoder-specific speed values accepted by --encoder-speed
+const (
+	maxNVENCSpeed  = 7  // p1 (fastest) to p7 (slowest)
+	maxSVTAV1Speed = 13 // 0 (slowest) to 13 (fastest)
+)
+
+// speedFamily returns the key of encoderSpeedValues for an encoder, "x264"
+// for the software encoders that take the names as they are, or "" for
+// encoders without speed presets
+func speedFamily(encoder string) string {
+	switch {
+	case isNVENCEncoder(encoder):
+		return "nvenc"
+	case encoder == "libsvtav1":
+		return "libsvtav1"
+	case isQSVEncoder(encoder):
+		return "qsv"
+	case encoder == "libx264" || encoder == "libx265":
+		return "x264"
+	}
+	return ""
+}
+
+// EncoderSpeedArgs returns the -preset arguments selecting a speed for an
+// encoder. speed is one of EncoderSpeeds, translated per encoder, or a value
+// of the encoder's own scale such as p6 for NVENC or 4 for SVT-AV1.
+func EncoderSpeedArgs(encoder, speed string) ([]string, error) {
+	speed = strings.ToLower(strings.TrimSpace(speed))
+	family := speedFamily(encoder)
+	if family == "" {
+		return nil, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("encoder %s has no speed presets", encoder), nil)
+	}
+
+	for i, name := range EncoderSpeeds {
+		if name == speed {
+			if values, ok := encoderSpeedValues[family]; ok {
+				return []string{"-preset", values[i]}, nil
+			}
+			return []string{"-preset", speed}, nil
+		}
+	}
+
+	switch family {
+	case "x264":
+		if speed == "placebo" {
+			return []string{"-preset", speed}, nil
+		}
+	case "nvenc":
+		if n, err := strconv.Atoi(strings.TrimPrefix(speed, "p")); err == nil && strings.HasPrefix(speed, "p") && n >= 1 && n <= maxNVENCSpeed {
+			return []string{"-preset", speed}, nil
+		}
+	case "libsvtav1":
+		if n, err := strconv.Atoi(speed); err == nil && n >= 0 && n <= maxSVTAV1Speed {
+			return []string{"-preset", speed}, nil
+		}
+	}
+	return nil, NewTranscoderError(ErrorTypeInvalidPreset,
+		fmt.Sprintf("unknown speed '%s' for %s (use %s, or the encoder's own presets)", speed, encoder, strings.Join(EncoderSpeeds, ", ")), nil)
+}
+
+// applyEncoderSpeed replaces the speed preset of encoder arguments with
+// --encoder-speed. A value that doesn't fit the encoder, such as an NVENC
+// preset after a fallback to software, leaves the arguments unchanged.
+func (t *Transcoder) applyEncoderSpeed(args []string) []string {
+	if t.config.EncoderSpeed == "" {
+		return args
+	}
+	encoder := argValue(args, "-c:v")
+	speedArgs, err := EncoderSpeedArgs(encoder, t.config.EncoderSpeed)
+	if err != nil {
+		t.log.Debugf("Ignoring encoder speed for %s: %v", encoder, err)
+		return args
+	}
+
+	result := make([]string, 0, len(args)+2)
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-preset" {
+			i++
+			continue
+		}
+		result = append(result, args[i])
+	}
+	return append(result, speedArgs...)
+}
+
+// ValidateEncoderSpeed checks that --encoder-speed is valid for the encoders
+// the configured presets will use
+func (t *Transcoder) ValidateEncoderSpeed() error {
+	if t.config.EncoderSpeed == "" {
+		return nil
+	}
+	presets, err := t.configuredPresets()
+	if err != nil {
+		return err
+	}
+	for _, preset := range presets {
+		if _, err := EncoderSpeedArgs(argValue(t.videoArgs(preset, t.useHardware(preset)), "-c:v"), t.config.EncoderSpeed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
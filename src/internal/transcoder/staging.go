@@ -0,0 +1,108 @@
+package transcoder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// stageOutput returns the path ffmpeg should write outputPath's encode to.
+// Without --stage-dir that is the output itself; with it, the file keeps its
+// name inside a fresh directory in the staging area, so the muxer still sees
+// the right extension and the file gets ordinary permissions.
+func (t *Transcoder) stageOutput(outputPath string) (string, error) {
+	if t.stage == nil {
+		return outputPath, nil
+	}
+	dir, err := t.stage.CreateDir("stage-*")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(outputPath)), nil
+}
+
+// commitStaged moves a finished encode from the staging area to its output
+// path. It does nothing when the encode was written in place.
+func (t *Transcoder) commitStaged(stagedPath, outputPath string) error {
+	if stagedPath == outputPath {
+		return nil
+	}
+	if err := moveFile(stagedPath, outputPath); err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError,
+			fmt.Sprintf("failed to move staged output to %s", outputPath), err)
+	}
+	os.Remove(filepath.Dir(stagedPath))
+	return nil
+}
+
+// discardStaged removes a failed encode. A staged encode never reached the
+// output path, so only its staging directory is removed.
+func (t *Transcoder) discardStaged(stagedPath, outputPath string) {
+	if stagedPath == outputPath {
+		discardOutput(outputPath)
+		return
+	}
+	os.RemoveAll(filepath.Dir(stagedPath))
+}
+
+// moveFile renames src to dst, falling back to a copy when they are on
+// different filesystems. The copy is written next to dst and renamed over it,
+// so a partial file never appears at the destination.
+func moveFile(src, dst string) error {
+	renameErr := os.Rename(src, dst)
+	if renameErr == nil {
+		return nil
+	}
+	if _, err := os.Stat(src); err != nil {
+		return renameErr
+	}
+	if err := copyFileAtomic(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFileAtomic copies src to dst through a temp file in dst's directory,
+// keeping src's permissions
+func copyFileAtomic(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return fail(err)
+	}
+	if _, err := io.Copy(tmp, in); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, dst); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
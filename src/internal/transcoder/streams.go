@@ -0,0 +1,287 @@
+package transcoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultAudioBitrate is the bitrate of re-encoded audio streams unless
+// --audio-bitrate sets another
+const DefaultAudioBitrate = "128k"
+
+// Bounds of --audio-bitrate in bits/s
+const (
+	minAudioBitrate = 8e3
+	maxAudioBitrate = 1536e3
+)
+
+// audioCodecEncoders maps --audio-codec values to the ffmpeg encoder used
+// for them; ffmpeg's own opus encoder is experimental
+var audioCodecEncoders = map[string]string{
+	"opus": "libopus",
+}
+
+// losslessAudioCodecs take no bitrate
+var losslessAudioCodecs = map[string]bool{
+	"flac": true,
+	"alac": true,
+}
+
+// audioEncoderCodecs maps ffmpeg audio encoder names to the codec ffprobe
+// reports for their output; other encoders are named after their codec
+var audioEncoderCodecs = map[string]string{
+	"libmp3lame": "mp3",
+	"libopus":    "opus",
+	"libvorbis":  "vorbis",
+	"libfdk_aac": "aac",
+}
+
+// audioTargetCodec returns the codec an --audio-codec value produces
+func audioTargetCodec(encoder string) string {
+	encoder = strings.ToLower(encoder)
+	if codec, ok := audioEncoderCodecs[encoder]; ok {
+		return codec
+	}
+	return encoder
+}
+
+// audioEncoder returns the ffmpeg encoder of an --audio-codec value
+func audioEncoder(codec string) string {
+	if encoder, ok := audioCodecEncoders[strings.ToLower(codec)]; ok {
+		return encoder
+	}
+	return codec
+}
+
+// ParseAudioBitrate parses an --audio-bitrate such as 192k into the form
+// ffmpeg is given
+func ParseAudioBitrate(value string) (string, error) {
+	bps, err := parseSIValue(strings.TrimSpace(value))
+	if err != nil || bps < minAudioBitrate || bps > maxAudioBitrate {
+		return "", NewTranscoderError(ErrorTypeInvalidAudio,
+			fmt.Sprintf("invalid audio bitrate '%s' (use a value from 8k to 1536k, such as 192k)", value), err)
+	}
+	return formatBitrateArg(bps), nil
+}
+
+// CheckAudioBitrate reports whether an --audio-bitrate applies to the audio
+// an --audio-codec and --container produce: lossless codecs take no
+// bitrate, and copied audio is not encoded at all unless normalized
+func CheckAudioBitrate(codec, bitrate, container string, normalize bool) error {
+	if bitrate == "" {
+		return nil
+	}
+	if _, err := ParseAudioBitrate(bitrate); err != nil {
+		return err
+	}
+	if losslessAudioCodecs[audioTargetCodec(audioEncoder(codec))] {
+		return NewTranscoderError(ErrorTypeInvalidAudio,
+			fmt.Sprintf("%s audio is lossless and takes no bitrate", codec), nil)
+	}
+	if (codec == "" || codec == "copy") && formatFor(container).copyAudio == "" && !normalize {
+		return NewTranscoderError(ErrorTypeInvalidAudio,
+			"an audio bitrate only applies to re-encoded audio; choose an audio codec other than copy", nil)
+	}
+	return nil
+}
+
+// buildAudioArgs decides per audio stream whether to copy or re-encode to
+// target at bitrate. Streams already in the target codec are copied, which
+// avoids a lossy second encode; lossless codecs get no bitrate. Each stream
+// gets its own decision only when mapsAllAudio says every source audio
+// stream is mapped in order; with ffmpeg's default selection of a single
+// stream, audio is copied only when all streams match. A filter such as
+// loudnorm applies to every audio stream, so all of them are encoded, with
+// copy falling back to normalizeAudioCodec.
+func buildAudioArgs(target, bitrate, filter string, sourceCodecs []string, mapsAllAudio bool) []string {
+	return buildAudioArgsKeeping(target, bitrate, filter, sourceCodecs, mapsAllAudio, nil)
+}
+
+// buildAudioArgsKeeping is buildAudioArgs copying the source codecs in keep
+// as well as those already in the target codec, for containers that hold
+// several audio codecs
+func buildAudioArgsKeeping(target, bitrate, filter string, sourceCodecs []string, mapsAllAudio bool, keep map[string]bool) []string {
+	if filter != "" && (target == "" || target == "copy") {
+		target = normalizeAudioCodec
+	}
+	if target == "" || target == "copy" {
+		return []string{"-c:a", "copy"}
+	}
+	target = audioEncoder(target)
+	if losslessAudioCodecs[audioTargetCodec(target)] {
+		bitrate = ""
+	}
+	encode := []string{"-c:a", target}
+	if bitrate != "" {
+		encode = append(encode, "-b:a", bitrate)
+	}
+	if filter != "" {
+		return append(encode, "-af", filter)
+	}
+	if len(sourceCodecs) == 0 {
+		return encode
+	}
+
+	want := audioTargetCodec(target)
+	copies := func(codec string) bool {
+		return strings.EqualFold(codec, want) || keep[strings.ToLower(codec)]
+	}
+	matching := 0
+	for _, codec := range sourceCodecs {
+		if copies(codec) {
+			matching++
+		}
+	}
+	switch {
+	case matching == len(sourceCodecs):
+		return []string{"-c:a", "copy"}
+	case matching == 0 || !mapsAllAudio:
+		return encode
+	}
+
+	var args []string
+	for i, codec := range sourceCodecs {
+		if copies(codec) {
+			args = append(args, fmt.Sprintf("-c:a:%d", i), "copy")
+		} else {
+			args = append(args, fmt.Sprintf("-c:a:%d", i), target)
+			if bitrate != "" {
+				args = append(args, fmt.Sprintf("-b:a:%d", i), bitrate)
+			}
+		}
+	}
+	return args
+}
+
+// audioArgs returns the audio arguments for an input. mapsAllAudio tells
+// whether the command maps every audio stream of the input.
+func (t *Transcoder) audioArgs(inputPath string, mapsAllAudio bool) []string {
+	target := t.config.AudioCodec
+	var keep map[string]bool
+	if format := t.containerFormat(); format.copyAudio != "" && (target == "" || target == "copy") {
+		// The container holds only some codecs; others are re-encoded
+		target, keep = format.copyAudio, format.audioCodecs
+	}
+	filter := t.loudnorm(inputPath)
+	if filter != "" && (target == "" || target == "copy") {
+		t.normalizeWarnOnce.Do(func() {
+			t.log.Warnf("--normalize-audio re-encodes audio; --audio-codec copy is overridden with %s", normalizeAudioCodec)
+		})
+	}
+	if target == "" || target == "copy" || filter != "" {
+		return buildAudioArgs(target, t.audioBitrate(), filter, nil, mapsAllAudio)
+	}
+
+	var codecs []string
+	if info, err := t.prober.Probe(t.mediaInput(inputPath)); err == nil {
+		codecs = info.AudioCodecs
+	}
+	// A single selected track is the only audio stream of the output
+	if track, err := ParseAudioTrack(t.config.AudioTrack); err == nil && track >= 0 {
+		if track < len(codecs) {
+			codecs = codecs[track : track+1]
+		} else {
+			codecs = nil
+		}
+		mapsAllAudio = true
+	}
+	args := buildAudioArgsKeeping(target, t.audioBitrate(), "", codecs, mapsAllAudio, keep)
+	if argValue(args, "-c:a") == "copy" {
+		t.log.Debugf("Copying audio of %s, already %s", inputPath, strings.Join(codecs, ", "))
+	}
+	return args
+}
+
+// audioBitrate returns the bitrate of re-encoded audio
+func (t *Transcoder) audioBitrate() string {
+	if bitrate, err := ParseAudioBitrate(t.config.AudioBitrate); err == nil {
+		return bitrate
+	}
+	return DefaultAudioBitrate
+}
+
+// mapsAllAudio reports whether ffmpeg arguments map every audio stream of
+// the main input
+func mapsAllAudio(args []string) bool {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == "-map" && (args[i+1] == "0:a?" || args[i+1] == "0:a") {
+			return true
+		}
+	}
+	return false
+}
+
+// AudioTrackAll selects every audio stream with --audio-track
+const AudioTrackAll = "all"
+
+// ParseAudioTrack parses an --audio-track value: a 0-based index among the
+// audio streams, or "all", returned as -1. An empty value is -1 too and keeps
+// ffmpeg's default stream selection.
+func ParseAudioTrack(value string) (int, error) {
+	if value == "" || strings.EqualFold(value, AudioTrackAll) {
+		return -1, nil
+	}
+	track, err := strconv.Atoi(value)
+	if err != nil || track < 0 {
+		return 0, NewTranscoderError(ErrorTypeInvalidAudio,
+			fmt.Sprintf("audio track '%s' must be a 0-based index or '%s'", value, AudioTrackAll), nil)
+	}
+	return track, nil
+}
+
+// audioTrackMaps returns the -map arguments selecting --audio-track
+func audioTrackMaps(value string) []string {
+	if track, err := ParseAudioTrack(value); err == nil && track >= 0 {
+		return []string{"-map", fmt.Sprintf("0:a:%d", track)}
+	}
+	return []string{"-map", "0:a?"}
+}
+
+// selectAudioTracks applies --audio-track to the stream mapping of a command.
+// A mapping of all audio is narrowed to the selected track. Without any
+// mapping, ffmpeg would pick a single audio stream itself, so the video and
+// the selected audio are mapped; Matroska sources keep their subtitles, which
+// a Matroska output can always copy.
+func (t *Transcoder) selectAudioTracks(inputPath string, maps []string) []string {
+	if t.config.AudioTrack == "" {
+		return maps
+	}
+	if len(maps) == 0 {
+		maps = []string{"-map", "0:v:0", "-map", "0:a?"}
+		if t.config.Container == "" || t.config.Container == ContainerMKV {
+			if info, err := t.prober.Probe(t.mediaInput(inputPath)); err == nil && strings.Contains(info.FormatName, "matroska") {
+				maps = append(maps, "-map", "0:s?", "-c:s", "copy")
+			}
+		}
+	}
+
+	var result []string
+	for i := 0; i < len(maps); i++ {
+		if maps[i] == "-map" && i+1 < len(maps) && maps[i+1] == "0:a?" {
+			result = append(result, audioTrackMaps(t.config.AudioTrack)...)
+			i++
+			continue
+		}
+		result = append(result, maps[i])
+	}
+	return result
+}
+
+// checkAudioTrack confirms that the --audio-track index exists in an input.
+// Inputs that cannot be probed are left to ffmpeg.
+func (t *Transcoder) checkAudioTrack(inputPath string) error {
+	track, err := ParseAudioTrack(t.config.AudioTrack)
+	if err != nil || track < 0 {
+		return err
+	}
+	info, err := t.prober.Probe(t.mediaInput(inputPath))
+	if err != nil {
+		return nil
+	}
+	if track >= len(info.AudioCodecs) {
+		return NewTranscoderError(ErrorTypeInvalidAudio,
+			fmt.Sprintf("%s has no audio track %d (it has %d; tracks are numbered from 0)", inputPath, track, len(info.AudioCodecs)), nil)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package transcoder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SubtitleFile is an external subtitle file muxed into the output
+type SubtitleFile struct {
+	Path     string
+	Language string // ISO 639 language tag, empty if unknown
+}
+
+// subtitleExtensions lists external subtitle formats that can be muxed
+var subtitleExtensions = map[string]bool{
+	".srt": true,
+	".ass": true,
+	".ssa": true,
+	".vtt": true,
+}
+
+// FindSubtitleFiles finds subtitle files next to a video that share its base
+// name, e.g. movie.srt or movie.en.srt for movie.mp4. A short middle segment
+// is taken as the language tag.
+func (f *FileDiscovery) FindSubtitleFiles(videoPath string) []SubtitleFile {
+	dir := filepath.Dir(videoPath)
+	base := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var subs []SubtitleFile
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if entry.IsDir() || !subtitleExtensions[ext] {
+			continue
+		}
+
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		sub := SubtitleFile{Path: filepath.Join(dir, name)}
+		switch {
+		case stem == base:
+		case strings.HasPrefix(stem, base+"."):
+			tag := strings.TrimPrefix(stem, base+".")
+			if isLanguageTag(tag) {
+				sub.Language = strings.ToLower(tag)
+			}
+		default:
+			continue
+		}
+		subs = append(subs, sub)
+	}
+
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Path < subs[j].Path })
+	return subs
+}
+
+// isLanguageTag reports whether s looks like a 2 or 3 letter language code
+func isLanguageTag(s string) bool {
+	if len(s) < 2 || len(s) > 3 {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// subtitleCodecFor picks the subtitle codec for an output container
+func subtitleCodecFor(container, subtitlePath string) string {
+	switch strings.ToLower(container) {
+	case ".mp4", ".m4v", ".mov":
+		return "mov_text"
+	case ".webm":
+		return "webvtt"
+	}
+
+	// Matroska stores text subtitles natively; keep styling for ASS/SSA
+	switch strings.ToLower(filepath.Ext(subtitlePath)) {
+	case ".ass", ".ssa":
+		return "ass"
+	}
+	return "srt"
+}
+
+// subtitleArgs returns extra input arguments and the output mapping arguments
+// for external subtitles. The main input is mapped explicitly since adding
+// inputs would otherwise change ffmpeg's automatic stream selection.
+func subtitleArgs(subs []SubtitleFile, container, defaultLanguage string) (inputs []string, outputs []string) {
+	if len(subs) == 0 {
+		return nil, nil
+	}
+
+	outputs = []string{"-map", "0:v:0", "-map", "0:a?"}
+	for i, sub := range subs {
+		inputs = append(inputs, "-i", sub.Path)
+		outputs = append(outputs, "-map", fmt.Sprintf("%d:s:0", i+1))
+	}
+	for i, sub := range subs {
+		outputs = append(outputs, fmt.Sprintf("-c:s:%d", i), subtitleCodecFor(container, sub.Path))
+		language := sub.Language
+		if language == "" {
+			language = defaultLanguage
+		}
+		if language != "" {
+			outputs = append(outputs, fmt.Sprintf("-metadata:s:s:%d", i), "language="+language)
+		}
+	}
+	return inputs, outputs
+}
+
+// subtitlesFor returns the external subtitles to mux for an input. Explicit
+// files take precedence over auto-detection; missing files are skipped.
+func (t *Transcoder) subtitlesFor(inputPath string) []SubtitleFile {
+	if _, ok := t.dvdTitles[inputPath]; ok {
+		return nil
+	}
+
+	var subs []SubtitleFile
+	if len(t.config.SubtitleFiles) > 0 {
+		for _, path := range t.config.SubtitleFiles {
+			if _, err := os.Stat(path); err != nil {
+				t.log.Warnf("subtitle file %s not found, skipping", path)
+				continue
+			}
+			subs = append(subs, SubtitleFile{Path: path})
+		}
+	} else if !t.config.NoAutoSubtitles {
+		subs = t.fileDiscovery.FindSubtitleFiles(inputPath)
+	}
+	return subs
+}
+
+// Modes of --subtitles for subtitle streams embedded in the source
+const (
+	SubtitleModeNone = "none" // ffmpeg's default stream selection
+	SubtitleModeCopy = "copy" // Keep every subtitle stream
+	SubtitleModeBurn = "burn" // Draw the first subtitle track onto the video
+)
+
+// SubtitleModes lists the --subtitles values, the default first
+var SubtitleModes = []string{SubtitleModeNone, SubtitleModeCopy, SubtitleModeBurn}
+
+// IsSubtitleMode reports whether name is a known --subtitles value
+func IsSubtitleMode(name string) bool {
+	for _, mode := range SubtitleModes {
+		if mode == name {
+			return true
+		}
+	}
+	return false
+}
+
+// matroskaSubtitleConversions maps subtitle codecs Matroska cannot store to
+// the codec they are converted to; all others are copied
+var matroskaSubtitleConversions = map[string]string{
+	"mov_text": "srt", // MP4 timed text
+	"eia_608":  "srt", // Closed captions
+	"text":     "srt",
+}
+
+// bitmapSubtitleCodecs are subtitle formats stored as images, which the
+// subtitles filter cannot draw
+var bitmapSubtitleCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+// matroskaSubtitleCodec returns the codec for an embedded subtitle stream in
+// the Matroska output
+func matroskaSubtitleCodec(codec string) string {
+	if converted, ok := matroskaSubtitleConversions[codec]; ok {
+		return converted
+	}
+	return "copy"
+}
+
+// embeddedSubtitleArgs adds the handling of embedded subtitles to the stream
+// mapping of a command. With copy, every subtitle stream is mapped after the
+// given number of external subtitle files, converted where the container
+// needs it. With burn, the subtitle streams ffmpeg would select itself are
+// left out, as the first track is drawn onto the video instead.
+func (t *Transcoder) embeddedSubtitleArgs(inputPath string, maps []string, external int) []string {
+	switch t.config.Subtitles {
+	case SubtitleModeCopy:
+		// DVD titles copy their subtitles already
+		if _, ok := t.dvdTitles[inputPath]; ok {
+			return maps
+		}
+		if len(maps) == 0 {
+			maps = []string{"-map", "0:v:0", "-map", "0:a?"}
+		}
+		info, err := t.prober.Probe(t.mediaInput(inputPath))
+		if err != nil {
+			if external == 0 {
+				codec, _ := t.embeddedSubtitleCodec("copy")
+				maps = append(maps, "-map", "0:s?", "-c:s", codec)
+			}
+			return maps
+		}
+		index := external
+		for i, codec := range info.SubtitleCodecs {
+			outputCodec, ok := t.embeddedSubtitleCodec(codec)
+			if !ok {
+				t.log.Infof("Dropping subtitle track %d of %s: %s cannot hold %s subtitles", i, inputPath, t.config.Container, codec)
+				continue
+			}
+			maps = append(maps, "-map", fmt.Sprintf("0:s:%d", i), fmt.Sprintf("-c:s:%d", index), outputCodec)
+			index++
+		}
+	case SubtitleModeBurn:
+		if len(maps) == 0 {
+			maps = []string{"-sn"}
+		}
+	}
+	return maps
+}
+
+// escapeFilterValue escapes a value for use as a filter option inside a
+// filter graph, first for the option parser and then for the graph parser
+func escapeFilterValue(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(value)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(value)
+}
+
+// burnSubtitles appends a subtitles filter drawing the first embedded
+// subtitle track to the chain with --subtitles burn. It runs after the
+// scale filter, so the text is rendered at the output resolution. Sources
+// without a text subtitle track are left alone; checkSubtitles reports bitmap
+// tracks before encoding.
+//
+// The filter reads subtitle times from the start of the file, while input
+// seeking with --start restarts the video's timestamps at zero; the frames
+// are shifted back to their place in the source for the filter and to zero
+// again after it.
+func (t *Transcoder) burnSubtitles(inputPath string, chain FilterChain) FilterChain {
+	if t.config.Subtitles != SubtitleModeBurn {
+		return chain
+	}
+	info, err := t.prober.Probe(t.mediaInput(inputPath))
+	if err != nil || len(info.SubtitleCodecs) == 0 || bitmapSubtitleCodecs[info.SubtitleCodecs[0]] {
+		t.log.Debugf("No text subtitle track to burn into %s", inputPath)
+		return chain
+	}
+
+	if start := t.config.TrimStart; start > 0 {
+		chain = chain.Append("setpts=PTS+" + formatSeconds(start) + "/TB")
+	}
+	chain = chain.Append("subtitles=" + escapeFilterValue(t.mediaInput(inputPath)) + ":si=0")
+	if t.config.TrimStart > 0 {
+		chain = chain.Append("setpts=PTS-STARTPTS")
+	}
+	return chain
+}
+
+// checkSubtitles confirms that the first subtitle track of an input can be
+// burned in with --subtitles burn. The subtitles filter draws text formats
+// only; sources without subtitles are encoded without any.
+func (t *Transcoder) checkSubtitles(inputPath string) error {
+	if t.config.Subtitles != SubtitleModeBurn {
+		return nil
+	}
+	info, err := t.prober.Probe(t.mediaInput(inputPath))
+	if err != nil || len(info.SubtitleCodecs) == 0 {
+		return nil
+	}
+	if codec := info.SubtitleCodecs[0]; bitmapSubtitleCodecs[codec] {
+		return NewTranscoderError(ErrorTypeInvalidSubtitle,
+			fmt.Sprintf("the first subtitle track of %s is a bitmap format (%s); only text subtitles can be burned in", inputPath, codec), nil)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package transcoder
+
+import (
+	"fmt"
+	"sort"
+)
+
+// assumedAudioBitrate is used to estimate output sizes when audio is re-encoded or copied
+const assumedAudioBitrate = 128_000
+
+// presetPreference lists presets per resolution tier, most preferred first
+var presetPreference = []struct {
+	minHeight int
+	presets   []string
+}{
+	{minHeight: 2160, presets: []string{"4k_h265", "4k_av1"}},
+	{minHeight: 1080, presets: []string{"1080p_h265", "1080p_av1", "1080p_h264"}},
+	{minHeight: 0, presets: []string{"720p_av1", "720p_h264"}},
+}
+
+// portraitPreference lists portrait presets by the source's displayed width
+var portraitPreference = []struct {
+	minHeight int
+	presets   []string
+}{
+	{minHeight: 1080, presets: []string{"1080p_vertical"}},
+	{minHeight: 0, presets: []string{"720p_vertical"}},
+}
+
+// Suggestion is a recommended preset for a source file
+type Suggestion struct {
+	Preset         string
+	Reasons        []string
+	SourceSize     int64
+	EstimatedSize  int64
+	EstimatedSaved float64 // Fraction of the source size saved (negative if larger)
+}
+
+// SuggestPreset recommends a preset from the source resolution, codec and bitrate
+func SuggestPreset(info *ProbeInfo, presets map[string]Preset) (*Suggestion, error) {
+	if info.Height == 0 {
+		return nil, NewTranscoderError(ErrorTypeInvalidPreset, "source has no video stream", nil)
+	}
+
+	// Portrait sources are tiered by their shorter, displayed side
+	preference, lines, orientation := presetPreference, info.Height, ""
+	if info.IsPortrait() {
+		width, _ := info.DisplaySize()
+		preference, lines, orientation = portraitPreference, width, " portrait"
+	}
+
+	var tier []string
+	for _, p := range preference {
+		if lines >= p.minHeight {
+			tier = p.presets
+			break
+		}
+	}
+
+	suggestion := &Suggestion{SourceSize: info.Size}
+	suggestion.Reasons = append(suggestion.Reasons, fmt.Sprintf("source is %dp%s %s at %s",
+		lines, orientation, codecLabel(info.VideoCodec), formatBitrate(info.Bitrate)))
+	if lines < 720 {
+		suggestion.Reasons = append(suggestion.Reasons,
+			"source is below 720p; the smallest preset will upscale it")
+	}
+
+	// Prefer the most efficient codec the source isn't already using
+	source := normalizeCodecName(info.VideoCodec)
+	var chosen Preset
+	for _, name := range tier {
+		preset, ok := presets[name]
+		if !ok {
+			continue
+		}
+		if chosen.Name == "" {
+			chosen = preset
+		}
+		if normalizeCodecName(preset.Codec) != source {
+			chosen = preset
+			break
+		}
+	}
+	if chosen.Name == "" {
+		return nil, NewTranscoderError(ErrorTypeInvalidPreset, "no preset available for source resolution", nil)
+	}
+	suggestion.Preset = chosen.Name
+	if normalizeCodecName(chosen.Codec) == source {
+		suggestion.Reasons = append(suggestion.Reasons,
+			fmt.Sprintf("source already uses %s; %s only lowers the bitrate", codecLabel(info.VideoCodec), chosen.Name))
+	} else {
+		suggestion.Reasons = append(suggestion.Reasons,
+			fmt.Sprintf("%s keeps the %s resolution and moves to the more efficient %s codec", chosen.Name, chosen.Resolution, chosen.Codec))
+	}
+
+	// Estimate output size from the preset's target bitrate
+	if bitrate, err := parseSIValue(chosen.Bitrate); err == nil && info.Duration > 0 {
+		suggestion.EstimatedSize = int64((bitrate + assumedAudioBitrate) * info.Duration / 8)
+		if info.Size > 0 {
+			suggestion.EstimatedSaved = 1 - float64(suggestion.EstimatedSize)/float64(info.Size)
+		}
+		if suggestion.EstimatedSaved < 0.1 {
+			suggestion.Reasons = append(suggestion.Reasons,
+				"source is already efficiently encoded; transcoding is unlikely to save much space")
+		}
+	}
+
+	return suggestion, nil
+}
+
+// SuggestForFiles probes up to sampleSize files and suggests the preset that
+// fits most of them. It returns the common suggestion along with per-file results.
+func (t *Transcoder) SuggestForFiles(files []string, sampleSize int) (*Suggestion, map[string]*Suggestion, error) {
+	if sampleSize > 0 && len(files) > sampleSize {
+		// Spread the sample evenly across the (sorted) file list
+		sample := make([]string, 0, sampleSize)
+		step := float64(len(files)) / float64(sampleSize)
+		for i := 0; i < sampleSize; i++ {
+			sample = append(sample, files[int(float64(i)*step)])
+		}
+		files = sample
+	}
+
+	perFile := make(map[string]*Suggestion)
+	counts := make(map[string]int)
+	var combined Suggestion
+	for path, info := range t.prober.ProbeAll(files, maxProbeWorkers) {
+		suggestion, err := SuggestPreset(info, t.presets)
+		if err != nil {
+			continue
+		}
+		perFile[path] = suggestion
+		counts[suggestion.Preset]++
+		combined.SourceSize += suggestion.SourceSize
+		combined.EstimatedSize += suggestion.EstimatedSize
+	}
+
+	if len(perFile) == 0 {
+		return nil, nil, NewTranscoderError(ErrorTypeInvalidPreset, "no probeable video files found", nil)
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	combined.Preset = names[0]
+	for _, name := range names {
+		combined.Reasons = append(combined.Reasons,
+			fmt.Sprintf("%d of %d sampled file(s) suit %s", counts[name], len(perFile), name))
+	}
+	if combined.SourceSize > 0 {
+		combined.EstimatedSaved = 1 - float64(combined.EstimatedSize)/float64(combined.SourceSize)
+	}
+
+	return &combined, perFile, nil
+}
+
+// codecLabel returns a display name for an ffprobe codec name
+func codecLabel(codec string) string {
+	switch normalizeCodecName(codec) {
+	case "h264":
+		return "H.264"
+	case "hevc":
+		return "H.265"
+	case "av1":
+		return "AV1"
+	case "":
+		return "unknown codec"
+	}
+	return codec
+}
+
+// formatBitrate renders bits/s as a human-readable rate
+func formatBitrate(bps int64) string {
+	switch {
+	case bps >= 1_000_000:
+		return fmt.Sprintf("%.1fMbps", float64(bps)/1e6)
+	case bps > 0:
+		return fmt.Sprintf("%dkbps", bps/1000)
+	}
+	return "unknown bitrate"
+}
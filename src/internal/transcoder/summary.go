@@ -0,0 +1,232 @@
+package transcoder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// CodecSummary aggregates converted files that share a source video codec
+type CodecSummary struct {
+	Codec      string `json:"codec"`
+	Files      int    `json:"files"`
+	SizeBefore int64  `json:"size_before_bytes"`
+	SizeAfter  int64  `json:"size_after_bytes"`
+}
+
+// SpaceSaved returns how many bytes the conversions saved (negative if they grew)
+func (s CodecSummary) SpaceSaved() int64 {
+	return s.SizeBefore - s.SizeAfter
+}
+
+// SummarizeByCodec groups converted files by source video codec, largest
+// savings first. Skipped files are left out; unprobed sources are "unknown".
+func SummarizeByCodec(results []*FileResult) []CodecSummary {
+	byCodec := make(map[string]*CodecSummary)
+	for _, result := range results {
+		if result == nil || result.Skipped {
+			continue
+		}
+		codec := normalizeCodecName(result.SourceCodec)
+		if codec == "" {
+			codec = "unknown"
+		}
+		summary, ok := byCodec[codec]
+		if !ok {
+			summary = &CodecSummary{Codec: codec}
+			byCodec[codec] = summary
+		}
+		summary.Files++
+		summary.SizeBefore += result.InputSize
+		summary.SizeAfter += result.OutputSize
+	}
+
+	summaries := make([]CodecSummary, 0, len(byCodec))
+	for _, summary := range byCodec {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].SpaceSaved() != summaries[j].SpaceSaved() {
+			return summaries[i].SpaceSaved() > summaries[j].SpaceSaved()
+		}
+		return summaries[i].Codec < summaries[j].Codec
+	})
+	return summaries
+}
+
+// printCodecSummary writes one line per source codec, showing sizes in the
+// given --ratio-style
+func printCodecSummary(out io.Writer, summaries []CodecSummary, style string) {
+	if len(summaries) == 0 {
+		return
+	}
+	fmt.Fprintln(out, "\nSummary by source codec:")
+	for _, s := range summaries {
+		label := s.Codec
+		if label != "unknown" {
+			label = codecLabel(s.Codec)
+		}
+		fmt.Fprintf(out, "  %-12s %4d file(s)  %s -> %s  (%s)\n",
+			label, s.Files, FormatBytes(s.SizeBefore), FormatBytes(s.SizeAfter), FormatSizeChange(s.SizeBefore, s.SizeAfter, style))
+	}
+}
+
+// BatchSummary totals the outcome of one ProcessFilesWithProgress batch
+type BatchSummary struct {
+	Files         int            // Files in the batch
+	Succeeded     int            // Files encoded
+	Failed        int            // Files whose encode failed
+	Skipped       int            // Files left alone, such as existing outputs
+	AlreadyTarget int            // Of the skipped files, those --skip-if-codec found in the target codec
+	NotProcessed  int            // Files never started (quit, time limit, interrupt or lost source)
+	InputSize     int64          // Bytes of the sources of encoded files
+	OutputSize    int64          // Bytes of their outputs
+	MediaDuration time.Duration  // Playing time of the encoded files that were probed
+	WallTime      time.Duration  // Time the batch took
+	Trimmed       bool           // Only segments were encoded, so sizes don't compare with the sources
+	Failures      []BatchFailure // Failed files in batch order
+}
+
+// BatchFailure is a file that failed to encode
+type BatchFailure struct {
+	Path string
+	Type ErrorType // Error type, empty when the error is not a TranscoderError
+	Err  error
+}
+
+// SpaceSaved returns how many bytes the encodes saved (negative if they grew)
+func (s *BatchSummary) SpaceSaved() int64 {
+	return s.InputSize - s.OutputSize
+}
+
+// Speed returns the probed playing time encoded per second of wall time, as
+// a multiple of realtime; zero when nothing probed was encoded
+func (s *BatchSummary) Speed() float64 {
+	if s.MediaDuration <= 0 || s.WallTime <= 0 {
+		return 0
+	}
+	return s.MediaDuration.Seconds() / s.WallTime.Seconds()
+}
+
+// Throughput returns the source bytes encoded per second of wall time
+func (s *BatchSummary) Throughput() float64 {
+	if s.WallTime <= 0 {
+		return 0
+	}
+	return float64(s.InputSize) / s.WallTime.Seconds()
+}
+
+// summarizeBatch totals the per-file outcomes of a batch; entries whose
+// result and error are both nil were not processed. durations holds probed
+// source durations in seconds and may be nil.
+func summarizeBatch(files []string, results []*FileResult, fileErrors []error, durations map[string]float64, wallTime time.Duration) *BatchSummary {
+	summary := &BatchSummary{Files: len(files), WallTime: wallTime}
+	for i, path := range files {
+		switch result := results[i]; {
+		case fileErrors[i] != nil:
+			summary.Failed++
+			failure := BatchFailure{Path: path, Err: fileErrors[i]}
+			var te *TranscoderError
+			if errors.As(fileErrors[i], &te) {
+				failure.Type = te.Type
+			}
+			summary.Failures = append(summary.Failures, failure)
+		case result == nil:
+			summary.NotProcessed++
+		default:
+			// With several presets, a file counts as encoded when any of its
+			// outputs was, and sizes add up over those outputs
+			encoded := false
+			for _, output := range result.outputs() {
+				if output.Skipped {
+					continue
+				}
+				encoded = true
+				summary.InputSize += output.InputSize
+				summary.OutputSize += output.OutputSize
+				summary.MediaDuration += time.Duration(durations[path] * float64(time.Second))
+			}
+			if encoded {
+				summary.Succeeded++
+			} else {
+				summary.Skipped++
+				for _, output := range result.outputs() {
+					if output.SkipReason == SkipReasonTargetCodec {
+						summary.AlreadyTarget++
+						break
+					}
+				}
+			}
+		}
+	}
+	return summary
+}
+
+// printBatchSummary writes the totals of a batch as a table, followed by its
+// failed files and their error types; sizes use the given --ratio-style
+func printBatchSummary(out io.Writer, s *BatchSummary, style string) {
+	if s.Files == 0 {
+		return
+	}
+	fmt.Fprintln(out, "\nBatch summary:")
+	row := func(label, value string) {
+		fmt.Fprintf(out, "  %-14s %s\n", label, value)
+	}
+	row("Files", fmt.Sprint(s.Files))
+	row("Succeeded", fmt.Sprint(s.Succeeded))
+	row("Failed", fmt.Sprint(s.Failed))
+	row("Skipped", fmt.Sprint(s.Skipped))
+	if s.AlreadyTarget > 0 {
+		row("Already target", fmt.Sprint(s.AlreadyTarget))
+	}
+	if s.NotProcessed > 0 {
+		row("Not processed", fmt.Sprint(s.NotProcessed))
+	}
+	if s.Succeeded > 0 {
+		row("Input size", FormatBytes(s.InputSize))
+		row("Output size", FormatBytes(s.OutputSize))
+		if !s.Trimmed {
+			row("Size change", FormatSizeChange(s.InputSize, s.OutputSize, style))
+		}
+	}
+	row("Wall time", s.WallTime.Round(time.Second).String())
+	if s.Succeeded > 0 {
+		speed := FormatBytes(int64(s.Throughput())) + "/s"
+		if s.Speed() > 0 {
+			speed = fmt.Sprintf("%.1fx realtime, %s", s.Speed(), speed)
+		}
+		row("Average speed", speed)
+	}
+
+	if len(s.Failures) == 0 {
+		return
+	}
+	fmt.Fprintln(out, "Failed files:")
+	for _, failure := range s.Failures {
+		errorType := string(failure.Type)
+		if errorType == "" {
+			errorType = "error"
+		}
+		fmt.Fprintf(out, "  %-40s %s\n", filepath.Base(failure.Path), errorType)
+	}
+}
+
+// FormatBytes renders a byte count using binary units
+func FormatBytes(n int64) string {
+	if n < 0 {
+		return "-" + FormatBytes(-n)
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
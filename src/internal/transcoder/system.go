@@ -1,9 +1,14 @@
 package transcoder
 
 import (
+	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // Platform represents the hardware platform type
@@ -14,6 +19,8 @@ const (
 	PlatformNVIDIA                // NVIDIA GPU systems
 	PlatformAppleSilicon          // Apple Silicon Macs
 	PlatformSoftware              // Software-only fallback
+	PlatformIntelQSV              // Intel GPUs with Quick Sync Video
+	PlatformAMD                   // AMD GPUs with VAAPI (Linux) or AMF (Windows)
 )
 
 // CommandExecutor defines an interface for executing external commands
@@ -39,26 +46,116 @@ func (r *RealCommandExecutor) Run(name string, args ...string) error {
 type SystemChecker struct {
 	executor CommandExecutor
 	platform Platform
+
+	// renderNodes lists the DRM render nodes of a GPU vendor on Linux; nil
+	// looks them up under /dev/dri
+	renderNodes func(vendor string) []string
+
+	encodersOnce sync.Once
+	encoders     string
+	encodersErr  error
+
+	filtersOnce sync.Once
+	filters     map[string]bool
+	filtersErr  error
 }
 
-// NewSystemChecker creates a new system checker
+// NewSystemChecker creates a new system checker and detects the platform up
+// front, so GetPlatform is accurate before any availability check runs
 func NewSystemChecker(executor CommandExecutor) *SystemChecker {
-	return &SystemChecker{
-		executor: executor,
-		platform: detectPlatform(),
-	}
+	s := &SystemChecker{executor: executor}
+	s.platform = s.detectPlatform(runtime.GOOS, runtime.GOARCH)
+	return s
 }
 
-// detectPlatform detects the current hardware platform
-func detectPlatform() Platform {
-	// Check if we're on macOS with Apple Silicon
+// presetPlatform returns the platform whose preset table applies to this
+// host before any hardware is probed. Only Apple Silicon is known from the
+// OS and architecture; every other host uses the NVENC presets, which are
+// converted to software encoders off NVIDIA hardware. Hosts where Quick Sync
+// or an AMD GPU is detected get their presets from hostPresetPlatform
+// instead.
+func presetPlatform() Platform {
 	if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" {
 		return PlatformAppleSilicon
 	}
+	return PlatformNVIDIA
+}
+
+// hostPresetPlatform returns the platform whose preset table applies once
+// the host's platform has been detected
+func hostPresetPlatform(detected Platform) Platform {
+	if detected == PlatformIntelQSV || detected == PlatformAMD {
+		return detected
+	}
+	return presetPlatform()
+}
+
+// detectPlatform probes the host for a usable hardware encoder. Apple Silicon
+// is identified by OS and architecture. Elsewhere an NVIDIA GPU must be listed
+// by nvidia-smi and ffmpeg must include NVENC encoders; failing that, an
+// Intel GPU with the Quick Sync encoders or an AMD GPU with the VAAPI (AMF on
+// Windows) encoders is used. Anything else is software-only. A later failed
+// GPU check still downgrades the platform to software.
+func (s *SystemChecker) detectPlatform(goos, goarch string) Platform {
+	if goos == "darwin" && goarch == "arm64" {
+		return PlatformAppleSilicon
+	}
+
+	output, err := s.executor.Execute("nvidia-smi", "-L")
+	if err == nil && countGPUs(string(output)) > 0 {
+		if available, err := s.CheckEncoderAvailability("_nvenc"); err == nil && available {
+			return PlatformNVIDIA
+		}
+	}
+	if available, err := s.hasQSVEncoders(); err == nil && available && s.qsvDeviceCount(goos) > 0 {
+		return PlatformIntelQSV
+	}
+	if available, err := s.hasAMDEncoders(goos); err == nil && available && s.amdDeviceCount(goos) > 0 {
+		return PlatformAMD
+	}
+	return PlatformSoftware
+}
+
+// PCI vendor IDs sysfs reports for GPUs with render-node encoders
+const (
+	intelPCIVendor = "0x8086"
+	amdPCIVendor   = "0x1002"
+)
+
+// vendorRenderNodes returns the DRM render nodes of a GPU vendor, in device
+// order
+func (s *SystemChecker) vendorRenderNodes(vendor string) []string {
+	if s.renderNodes != nil {
+		return s.renderNodes(vendor)
+	}
+	return findRenderNodes(vendor)
+}
+
+// findRenderNodes lists the render nodes under /dev/dri whose device sysfs
+// reports the given PCI vendor. Nodes of other vendors are left out, since
+// ffmpeg builds often include the encoders of every vendor.
+func findRenderNodes(vendor string) []string {
+	nodes, _ := filepath.Glob("/dev/dri/renderD*")
+	var matching []string
+	for _, node := range nodes {
+		id, err := os.ReadFile(filepath.Join("/sys/class/drm", filepath.Base(node), "device", "vendor"))
+		if err == nil && strings.TrimSpace(string(id)) == vendor {
+			matching = append(matching, node)
+		}
+	}
+	sort.Strings(matching)
+	return matching
+}
 
-	// For other platforms, we'll check for NVIDIA GPU availability
-	// This will be determined dynamically in CheckGPUAvailability
-	return PlatformUnknown
+// countGPUs counts the devices listed by `nvidia-smi -L`
+func countGPUs(output string) int {
+	count := 0
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "GPU ") {
+			count++
+		}
+	}
+	return count
 }
 
 // GetPlatform returns the detected platform
@@ -73,17 +170,49 @@ func (s *SystemChecker) CheckFFmpegAvailability() error {
 			return NewTranscoderError(ErrorTypeFFmpegNotFound,
 				"FFmpeg not found. Please install FFmpeg with VideoToolbox support (brew install ffmpeg)", err)
 		}
+		if s.platform == PlatformIntelQSV {
+			return NewTranscoderError(ErrorTypeFFmpegNotFound,
+				"FFmpeg not found. Please install FFmpeg with Intel Quick Sync support (libvpl or libmfx)", err)
+		}
+		if s.platform == PlatformAMD {
+			return NewTranscoderError(ErrorTypeFFmpegNotFound,
+				"FFmpeg not found. Please install FFmpeg with VAAPI (Linux) or AMF (Windows) support", err)
+		}
 		return NewTranscoderError(ErrorTypeFFmpegNotFound,
 			"FFmpeg not found. Please install FFmpeg with NVIDIA support", err)
 	}
 	return nil
 }
 
+// CheckFFprobeAvailability checks if ffprobe is available. Features that
+// judge files by their probe must not mistake a missing ffprobe for bad files.
+func (s *SystemChecker) CheckFFprobeAvailability() error {
+	if err := s.executor.Run("ffprobe", "-version"); err != nil {
+		return NewTranscoderError(ErrorTypeFFmpegNotFound,
+			"ffprobe not found; it is installed together with FFmpeg", err)
+	}
+	return nil
+}
+
+// CheckFFplayAvailability checks if ffplay is available. It ships separately
+// from ffmpeg in some packages and is only needed for previews.
+func (s *SystemChecker) CheckFFplayAvailability() error {
+	if err := s.executor.Run("ffplay", "-version"); err != nil {
+		return NewTranscoderError(ErrorTypeFFmpegNotFound,
+			"ffplay not found; previews are written to disk but not played", err)
+	}
+	return nil
+}
+
 // CheckGPUAvailability checks hardware acceleration availability based on platform
 func (s *SystemChecker) CheckGPUAvailability(gpuIndex int, verbose bool) error {
 	switch s.platform {
 	case PlatformAppleSilicon:
 		return s.checkAppleSiliconAvailability(verbose)
+	case PlatformIntelQSV:
+		return s.checkIntelQSVAvailability(runtime.GOOS, gpuIndex)
+	case PlatformAMD:
+		return s.checkAMDAvailability(runtime.GOOS, gpuIndex)
 	default:
 		return s.checkNVIDIAAvailability(gpuIndex, verbose)
 	}
@@ -117,6 +246,60 @@ func (s *SystemChecker) checkAppleSiliconAvailability(verbose bool) error {
 	return nil
 }
 
+// checkIntelQSVAvailability checks that ffmpeg still provides the Quick Sync
+// encoders and that the requested Intel GPU exists
+func (s *SystemChecker) checkIntelQSVAvailability(goos string, gpuIndex int) error {
+	available, err := s.hasQSVEncoders()
+	if err != nil {
+		return NewTranscoderError(ErrorTypeGPUNotAvailable,
+			"Failed to check Quick Sync encoder availability", err)
+	}
+	if !available {
+		s.platform = PlatformSoftware
+		return NewTranscoderError(ErrorTypeGPUNotAvailable,
+			"Quick Sync encoders not available. Please ensure FFmpeg is built with libvpl or libmfx", nil)
+	}
+
+	devices := s.qsvDeviceCount(goos)
+	if devices == 0 {
+		s.platform = PlatformSoftware
+		return NewTranscoderError(ErrorTypeGPUNotAvailable,
+			"no Intel GPU found for Quick Sync. Please ensure the Intel media driver is installed", nil)
+	}
+	if gpuIndex >= devices {
+		return NewTranscoderError(ErrorTypeGPUNotAvailable,
+			"GPU index not available", nil)
+	}
+	return nil
+}
+
+// checkAMDAvailability checks that ffmpeg still provides the AMD encoders
+// and that the requested AMD GPU exists
+func (s *SystemChecker) checkAMDAvailability(goos string, gpuIndex int) error {
+	available, err := s.hasAMDEncoders(goos)
+	if err != nil {
+		return NewTranscoderError(ErrorTypeGPUNotAvailable,
+			"Failed to check AMD encoder availability", err)
+	}
+	if !available {
+		s.platform = PlatformSoftware
+		return NewTranscoderError(ErrorTypeGPUNotAvailable,
+			"AMD encoders not available. Please ensure FFmpeg is built with VAAPI (Linux) or AMF (Windows) support", nil)
+	}
+
+	devices := s.amdDeviceCount(goos)
+	if devices == 0 {
+		s.platform = PlatformSoftware
+		return NewTranscoderError(ErrorTypeGPUNotAvailable,
+			"no AMD GPU found. Please ensure the Mesa VAAPI driver (Linux) or AMD driver (Windows) is installed", nil)
+	}
+	if gpuIndex >= devices {
+		return NewTranscoderError(ErrorTypeGPUNotAvailable,
+			"GPU index not available", nil)
+	}
+	return nil
+}
+
 // checkNVIDIAAvailability checks if NVIDIA GPU is available (original implementation)
 func (s *SystemChecker) checkNVIDIAAvailability(gpuIndex int, verbose bool) error {
 	output, err := s.executor.Execute("nvidia-smi", "-L")
@@ -127,14 +310,7 @@ func (s *SystemChecker) checkNVIDIAAvailability(gpuIndex int, verbose bool) erro
 			"NVIDIA GPU not detected. Please ensure NVIDIA drivers are installed", err)
 	}
 
-	// Parse GPU list
-	lines := strings.Split(string(output), "\n")
-	gpuCount := 0
-	for _, line := range lines {
-		if strings.Contains(line, "GPU") {
-			gpuCount++
-		}
-	}
+	gpuCount := countGPUs(string(output))
 
 	if gpuCount == 0 {
 		s.platform = PlatformSoftware
@@ -151,13 +327,82 @@ func (s *SystemChecker) checkNVIDIAAvailability(gpuIndex int, verbose bool) erro
 	return nil
 }
 
-// CheckEncoderAvailability checks if a specific encoder is available
+// CheckEncoderAvailability checks if a specific encoder is available. The
+// encoder list is queried once and cached.
 func (s *SystemChecker) CheckEncoderAvailability(encoder string) (bool, error) {
-	output, err := s.executor.Execute("ffmpeg", "-encoders")
+	s.encodersOnce.Do(func() {
+		output, err := s.executor.Execute("ffmpeg", "-encoders")
+		if err != nil {
+			s.encodersErr = NewTranscoderError(ErrorTypeEncoderNotFound,
+				"failed to check encoders", err)
+			return
+		}
+		s.encoders = string(output)
+	})
+	if s.encodersErr != nil {
+		return false, s.encodersErr
+	}
+	return strings.Contains(s.encoders, encoder), nil
+}
+
+// filterBuildHints explains how to get ffmpeg builds that include optional filters
+var filterBuildHints = map[string]string{
+	"zscale":     "it requires an ffmpeg built with --enable-libzimg",
+	"tonemap":    "install a full ffmpeg build",
+	"libvmaf":    "it requires an ffmpeg built with --enable-libvmaf",
+	"loudnorm":   "install a full ffmpeg build",
+	"scale_cuda": "it requires an ffmpeg built with CUDA support (--enable-cuda-nvcc)",
+	"subtitles":  "it requires an ffmpeg built with --enable-libass",
+	"drawtext":   "it requires an ffmpeg built with --enable-libfreetype",
+}
+
+// CheckFilterAvailability checks if ffmpeg was built with a specific filter.
+// The filter list is queried once and cached.
+func (s *SystemChecker) CheckFilterAvailability(filter string) (bool, error) {
+	s.filtersOnce.Do(func() {
+		output, err := s.executor.Execute("ffmpeg", "-hide_banner", "-filters")
+		if err != nil {
+			s.filtersErr = NewTranscoderError(ErrorTypeFFmpegNotFound,
+				"failed to list ffmpeg filters", err)
+			return
+		}
+		s.filters = parseFilterList(string(output))
+	})
+	if s.filtersErr != nil {
+		return false, s.filtersErr
+	}
+	return s.filters[filter], nil
+}
+
+// RequireFilter returns an actionable error when a feature needs a filter that
+// the installed ffmpeg lacks
+func (s *SystemChecker) RequireFilter(filter, feature string) error {
+	available, err := s.CheckFilterAvailability(filter)
 	if err != nil {
-		return false, NewTranscoderError(ErrorTypeEncoderNotFound,
-			"failed to check encoders", err)
+		return err
 	}
+	if available {
+		return nil
+	}
+
+	hint, ok := filterBuildHints[filter]
+	if !ok {
+		hint = "install a full ffmpeg build"
+	}
+	return NewTranscoderError(ErrorTypeFilterNotFound,
+		fmt.Sprintf("%s needs the '%s' filter but your ffmpeg lacks it; %s", feature, filter, hint), nil)
+}
 
-	return strings.Contains(string(output), encoder), nil
+// parseFilterList extracts filter names from `ffmpeg -filters` output, whose
+// entries look like " TSC scale             V->V       Scale the input video size."
+func parseFilterList(output string) map[string]bool {
+	filters := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.Contains(fields[2], "->") {
+			continue
+		}
+		filters[fields[1]] = true
+	}
+	return filters
 }
@@ -0,0 +1,99 @@
+package transcoder
+
+import (
+	"os"
+	"sync"
+)
+
+// TempManager is the single place intermediate files are created. Everything it
+// creates lives under one directory and is removed by Cleanup.
+type TempManager struct {
+	dir  string
+	kind string // Names the directory in errors
+
+	mu    sync.Mutex
+	paths []string
+}
+
+// NewTempManager creates a temp manager rooted at dir. An empty dir falls back
+// to the system default, which honors TMPDIR.
+func NewTempManager(dir string) *TempManager {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return &TempManager{dir: dir, kind: "temp"}
+}
+
+// NewStageManager creates a manager for the --stage-dir staging area, where
+// outputs are written before they are moved into place
+func NewStageManager(dir string) *TempManager {
+	return &TempManager{dir: dir, kind: "stage"}
+}
+
+// Dir returns the directory intermediate files are created in
+func (m *TempManager) Dir() string {
+	return m.dir
+}
+
+// Validate checks that the directory exists and is writable
+func (m *TempManager) Validate() error {
+	info, err := os.Stat(m.dir)
+	if err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError,
+			m.kind+" directory does not exist: "+m.dir, err)
+	}
+	if !info.IsDir() {
+		return NewTranscoderError(ErrorTypeFileSystemError,
+			m.kind+" directory is not a directory: "+m.dir, nil)
+	}
+
+	probe, err := os.CreateTemp(m.dir, ".ffmcli-write-test-*")
+	if err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError,
+			m.kind+" directory is not writable: "+m.dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+// CreateFile creates a new temp file; pattern follows os.CreateTemp
+func (m *TempManager) CreateFile(pattern string) (*os.File, error) {
+	f, err := os.CreateTemp(m.dir, pattern)
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"failed to create temp file", err)
+	}
+	m.track(f.Name())
+	return f, nil
+}
+
+// CreateDir creates a new temp directory; pattern follows os.MkdirTemp
+func (m *TempManager) CreateDir(pattern string) (string, error) {
+	dir, err := os.MkdirTemp(m.dir, pattern)
+	if err != nil {
+		return "", NewTranscoderError(ErrorTypeFileSystemError,
+			"failed to create temp directory", err)
+	}
+	m.track(dir)
+	return dir, nil
+}
+
+// Cleanup removes everything created through the manager. It is safe to call
+// more than once.
+func (m *TempManager) Cleanup() {
+	m.mu.Lock()
+	paths := m.paths
+	m.paths = nil
+	m.mu.Unlock()
+
+	for _, path := range paths {
+		os.RemoveAll(path)
+	}
+}
+
+func (m *TempManager) track(path string) {
+	m.mu.Lock()
+	m.paths = append(m.paths, path)
+	m.mu.Unlock()
+}
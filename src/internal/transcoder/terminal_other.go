@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package transcoder
+
+import (
+	"errors"
+	"os"
+)
+
+func enableKeypressMode(f *os.File) (func() error, error) {
+	return nil, errors.New("keyboard controls are not supported on this platform")
+}
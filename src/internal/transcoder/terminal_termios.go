@@ -0,0 +1,39 @@
+//go:build linux || darwin
+
+package transcoder
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// enableKeypressMode switches a terminal to deliver single keypresses without
+// echo. Ctrl-C keeps working. The returned function restores the previous mode.
+func enableKeypressMode(f *os.File) (func() error, error) {
+	fd := f.Fd()
+	var saved syscall.Termios
+	if err := termiosIoctl(fd, ioctlGetTermios, &saved); err != nil {
+		return nil, err
+	}
+
+	keypress := saved
+	keypress.Lflag &^= syscall.ICANON | syscall.ECHO
+	keypress.Cc[syscall.VMIN] = 1
+	keypress.Cc[syscall.VTIME] = 0
+	if err := termiosIoctl(fd, ioctlSetTermios, &keypress); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return termiosIoctl(fd, ioctlSetTermios, &saved)
+	}, nil
+}
+
+func termiosIoctl(fd uintptr, request uint64, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(request), uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
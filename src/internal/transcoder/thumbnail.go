@@ -0,0 +1,166 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Bounds of --contact-sheet in frames
+const (
+	minContactSheetFrames = 2
+	MaxContactSheetFrames = 100
+)
+
+// minThumbnailDuration is the shortest output that gets a thumbnail; a
+// shorter clip has hardly a frame worth showing
+const minThumbnailDuration = time.Second
+
+// thumbnailLead is the share of the output skipped before the thumbnail
+// filter looks for a frame, past opening titles and fades from black
+const thumbnailLead = 0.1
+
+// contactSheetSpacing is the least time between the frames of a contact
+// sheet; shorter outputs would repeat nearly the same picture
+const contactSheetSpacing = 2 * time.Second
+
+// contactSheetTileWidth is the width of each frame of a contact sheet
+const contactSheetTileWidth = 320
+
+// ThumbnailPath returns where --thumbnail writes the poster frame of an
+// output: next to it, with the extension replaced by .jpg
+func ThumbnailPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".jpg"
+}
+
+// ContactSheetPath returns where --contact-sheet writes the tiled frames of
+// an output
+func ContactSheetPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + "_sheet.jpg"
+}
+
+// CheckContactSheet reports whether an --contact-sheet frame count is
+// usable; 0 turns contact sheets off
+func CheckContactSheet(frames int) error {
+	if frames != 0 && (frames < minContactSheetFrames || frames > MaxContactSheetFrames) {
+		return NewTranscoderError(ErrorTypeInvalidThreshold,
+			fmt.Sprintf("a contact sheet needs %d to %d frames", minContactSheetFrames, MaxContactSheetFrames), nil)
+	}
+	return nil
+}
+
+// contactSheetLayout returns the columns and rows of a sheet of n frames:
+// as square as possible, never taller than wide
+func contactSheetLayout(n int) (columns, rows int) {
+	columns = int(math.Ceil(math.Sqrt(float64(n))))
+	rows = (n + columns - 1) / columns
+	return columns, rows
+}
+
+// thumbnailArgs returns the command writing one frame of an output as a
+// JPEG. With pick, the thumbnail filter chooses the most representative of
+// the frames following the position.
+func thumbnailArgs(outputPath, imagePath string, at time.Duration, pick bool) []string {
+	args := []string{"-hide_banner", "-y"}
+	if at > 0 {
+		args = append(args, "-ss", formatSeconds(at))
+	}
+	args = append(args, "-i", outputPath, "-map", "0:v:0")
+	if pick {
+		args = append(args, "-vf", "thumbnail")
+	}
+	return append(args, "-frames:v", "1", "-q:v", "2", "-update", "1", imagePath)
+}
+
+// contactSheetArgs returns the command tiling frames evenly spaced over an
+// output into one JPEG. Only keyframes are decoded, which keeps long
+// outputs fast at the cost of each frame landing on the nearest keyframe.
+func contactSheetArgs(outputPath, imagePath string, duration time.Duration, frames int) []string {
+	interval := (duration / time.Duration(frames+1)).Round(time.Millisecond)
+	columns, rows := contactSheetLayout(frames)
+	filter := fmt.Sprintf("fps=1/%s,scale=%d:-2,tile=%dx%d:padding=4:margin=4",
+		formatSeconds(interval), contactSheetTileWidth, columns, rows)
+	return []string{"-hide_banner", "-y", "-skip_frame", "nokey",
+		"-ss", formatSeconds(interval), "-t", formatSeconds(interval * time.Duration(frames)),
+		"-i", outputPath, "-map", "0:v:0", "-vf", filter, "-frames:v", "1", "-q:v", "3", "-update", "1", imagePath}
+}
+
+// thumbnailPosition returns where the thumbnail of an output of the given
+// duration is taken and whether the thumbnail filter picks the frame there.
+// A --thumbnail-at position past the end falls back to the filter's pick.
+func (t *Transcoder) thumbnailPosition(duration time.Duration) (time.Duration, bool) {
+	if t.config.ThumbnailAt != "" {
+		at, err := ParseTimestamp(t.config.ThumbnailAt)
+		if err == nil && (duration <= 0 || at < duration) {
+			return at, false
+		}
+		t.log.Warnf("--thumbnail-at %s is past the end of the %s output; picking a frame instead",
+			t.config.ThumbnailAt, duration.Round(time.Second))
+	}
+	return time.Duration(float64(duration) * thumbnailLead).Round(time.Millisecond), true
+}
+
+// writeThumbnails writes the poster frame and contact sheet of an output
+// after its encode, placed from the probed duration of the source. Clips too
+// short for them are skipped, and a failure is a warning: the output itself
+// is fine.
+func (t *Transcoder) writeThumbnails(ctx context.Context, inputPath, outputPath string) {
+	if !t.config.Thumbnail && t.config.ContactSheet == 0 {
+		return
+	}
+	var duration time.Duration
+	if info, err := t.prober.Probe(t.mediaInput(inputPath)); err == nil {
+		duration = time.Duration(t.trimmedDuration(info.Duration) * float64(time.Second))
+	}
+
+	if t.config.Thumbnail {
+		if duration > 0 && duration < minThumbnailDuration {
+			t.log.Infof("Skipping thumbnail of %s (only %s long)", filepath.Base(outputPath), duration)
+		} else {
+			at, pick := t.thumbnailPosition(duration)
+			t.writeImage(ctx, inputPath, "thumbnail", ThumbnailPath(outputPath), thumbnailArgs(outputPath, ThumbnailPath(outputPath), at, pick))
+		}
+	}
+
+	if frames := t.config.ContactSheet; frames > 0 {
+		needed := contactSheetSpacing * time.Duration(frames+1)
+		switch {
+		case duration <= 0:
+			t.log.Infof("Skipping contact sheet of %s (duration unknown)", filepath.Base(outputPath))
+		case duration < needed:
+			t.log.Infof("Skipping contact sheet of %s (%d frames need an output of at least %s)", filepath.Base(outputPath), frames, needed)
+		default:
+			t.writeImage(ctx, inputPath, "contact sheet", ContactSheetPath(outputPath), contactSheetArgs(outputPath, ContactSheetPath(outputPath), duration, frames))
+		}
+	}
+}
+
+// writeImage runs the ffmpeg command writing a thumbnail image, removing
+// whatever it left behind when it fails. An image already next to the output,
+// such as the poster a media library keeps there, is only replaced with
+// --overwrite.
+func (t *Transcoder) writeImage(ctx context.Context, inputPath, kind, imagePath string, args []string) {
+	if !t.config.Overwrite {
+		if _, err := os.Lstat(imagePath); err == nil {
+			t.log.Infof("Keeping existing %s %s (use --overwrite to replace it)", kind, filepath.Base(imagePath))
+			return
+		}
+	}
+	t.log.Debugf("Running: %s", FormatCommand("ffmpeg", args))
+	stderr, err := t.runFFmpeg(ctx, inputPath, args, nil)
+	if err != nil {
+		os.Remove(imagePath)
+		if ctx.Err() == nil {
+			t.log.Warnf("failed to write %s %s: %s", kind, filepath.Base(imagePath), ffmpegErrorLine(stderr))
+		}
+		return
+	}
+	if err := t.applyOutputPermissions(imagePath, false); err != nil {
+		t.log.Warnf("%v", err)
+	}
+	t.log.Debugf("Wrote %s %s", kind, imagePath)
+}
@@ -0,0 +1,105 @@
+package transcoder
+
+import "slices"
+
+// Modes of --tonemap
+const (
+	TonemapAuto = "auto" // Tone map sources whose transfer characteristics mark them as HDR
+	TonemapOn   = "on"   // Tone map every source, for HDR files with missing metadata
+	TonemapOff  = "off"  // Never tone map
+)
+
+// TonemapModes lists the --tonemap values, the default first
+var TonemapModes = []string{TonemapAuto, TonemapOn, TonemapOff}
+
+// TonemapAlgorithms lists the --tonemap-algorithm values, the default first
+var TonemapAlgorithms = []string{"hable", "mobius", "reinhard"}
+
+// IsTonemapMode reports whether name is a known --tonemap value
+func IsTonemapMode(name string) bool {
+	return slices.Contains(TonemapModes, name)
+}
+
+// IsTonemapAlgorithm reports whether name is a known --tonemap-algorithm value
+func IsTonemapAlgorithm(name string) bool {
+	return slices.Contains(TonemapAlgorithms, name)
+}
+
+// hdrTransfers are the transfer characteristics of HDR video as ffprobe names them
+var hdrTransfers = map[string]bool{
+	"smpte2084":    true, // PQ, used by HDR10, HDR10+ and Dolby Vision
+	"arib-std-b67": true, // HLG
+}
+
+// IsHDR reports whether the primary video stream uses an HDR transfer
+func (p *ProbeInfo) IsHDR() bool {
+	return hdrTransfers[p.ColorTransfer]
+}
+
+// tonemapFilter returns the filters converting HDR frames to SDR BT.709.
+// zscale linearizes the light with 100 nits as reference white, tonemap
+// compresses the highlights with the given curve in float RGB, and zscale
+// converts back to BT.709 limited range 4:2:0 for the encoder. A non-empty
+// transfer tags the input as that transfer with BT.2020 primaries and matrix,
+// for frames whose own tags are missing or wrong; zscale finds no conversion
+// without them.
+func tonemapFilter(algorithm, transfer string) string {
+	input := ""
+	if transfer != "" {
+		input = "tin=" + transfer + ":min=bt2020nc:pin=bt2020:"
+	}
+	return "zscale=" + input + "t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=" + algorithm +
+		":desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+}
+
+// defaultHDRTransfer is the transfer --tonemap on assumes for sources whose
+// metadata does not name an HDR one: PQ, by far the most common
+const defaultHDRTransfer = "smpte2084"
+
+// tonemap puts a tone mapping chain at the front of the -vf chain, for HDR
+// sources with --tonemap auto and for every source with --tonemap on. Being
+// first, it hands SDR frames to the scale filter and everything after it.
+// Sources that cannot be probed are left alone in auto mode; presets that
+// produce HDR never reach it, see filterStages.
+func (t *Transcoder) tonemap(inputPath string, chain FilterChain) FilterChain {
+	if t.config.Tonemap == TonemapOff {
+		return chain
+	}
+	// With on, the source is tagged as HDR: an HLG source keeps its transfer,
+	// anything else is taken for PQ
+	transfer := ""
+	if t.config.Tonemap == TonemapOn {
+		transfer = defaultHDRTransfer
+		if info, err := t.prober.Probe(t.mediaInput(inputPath)); err == nil && info.IsHDR() {
+			transfer = info.ColorTransfer
+		}
+	} else {
+		info, err := t.prober.Probe(t.mediaInput(inputPath))
+		if err != nil || !info.IsHDR() || !t.tonemapAvailable() {
+			return chain
+		}
+		t.log.Debugf("Tone mapping HDR source %s (%s) to SDR", inputPath, info.ColorTransfer)
+	}
+
+	algorithm := t.config.TonemapAlgorithm
+	if algorithm == "" {
+		algorithm = TonemapAlgorithms[0]
+	}
+	return chain.Prepend(ParseFilterChain(tonemapFilter(algorithm, transfer))...)
+}
+
+// tonemapAvailable reports whether ffmpeg has the filters tone mapping
+// needs, warning once when it does not. With --tonemap auto a missing filter
+// only costs the tone mapping; --tonemap on checks for them up front.
+func (t *Transcoder) tonemapAvailable() bool {
+	t.tonemapOnce.Do(func() {
+		for _, filter := range []string{"zscale", "tonemap"} {
+			if err := t.RequireFilter(filter, "tone mapping HDR sources"); err != nil {
+				t.log.Warnf("%v; HDR sources are encoded without tone mapping", err)
+				return
+			}
+		}
+		t.tonemapUsable = true
+	})
+	return t.tonemapUsable
+}
@@ -1,22 +1,130 @@
 package transcoder
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
+// maxProbeWorkers caps the number of concurrent ffprobe processes
+const maxProbeWorkers = 8
+
 // Transcoder handles video transcoding operations
 type Transcoder struct {
 	config        Config
 	systemChecker *SystemChecker
 	fileDiscovery *FileDiscovery
 	pathUtils     *PathUtils
+	prober        *Prober
+	log           *Logger
+	temp          *TempManager
+	stage         *TempManager // Staging area for outputs, nil without --stage-dir
 	presets       map[string]Preset
+	outputGID     int // Group applied to outputs, -1 to leave unchanged
+
+	// dvdTitles maps the first VOB of each title to its title in --dvd mode
+	dvdTitles map[string]*DVDTitle
+
+	// inputRoots maps each discovered file to the input root it was found under
+	inputRoots map[string]string
+
+	// repaired maps inputs remuxed by --repair to the repaired copy encoded instead
+	repaired map[string]string
+
+	// adaptiveRates holds the target bitrate chosen per file and preset with
+	// --adaptive-bitrate or --smart-bitrate
+	adaptiveRates map[string]map[string]float64
+	// crops holds the crop chosen per file with --crop
+	crops map[string]Crop
+	// loudnorms holds the loudnorm filter chosen per file with
+	// --normalize-audio-2pass
+	loudnorms map[string]string
+
+	// stateMu guards repaired, adaptiveRates, crops and loudnorms, which
+	// files encoded in parallel update
+	stateMu sync.Mutex
+
+	// energyShares splits the power draw of --energy between files encoded
+	// in parallel
+	energyShares energyShares
+
+	// presetOverrides holds per-file preset choices made in interactive mode
+	presetOverrides map[string]string
+
+	// manifest receives output checksums when --manifest is set
+	manifest *Manifest
+	// analyticsJSON receives per-file analytics when --json-output is set
+	analyticsJSON *AnalyticsJSON
+
+	// probeCache keeps probe results across runs when --probe-cache is set
+	probeCache *ProbeCache
+
+	// resume records the status of each file when --resume is set
+	resume *ResumeState
+
+	// commandLog receives every ffmpeg command and its output when
+	// --log-file is set
+	commandLog *CommandLog
+
+	// library indexes the file names under the output directory for
+	// --only-new, built on first use
+	library map[string]string
+
+	// control pauses or stops dispatching on keypresses, nil when disabled
+	control *RunControl
+
+	// runStarted is when the first file of the run was dispatched; batches
+	// share it so --max-runtime covers the whole run
+	runStarted time.Time
+
+	// concurrent is set while a batch encodes several files at once, which
+	// rules out skipping a single file from the keyboard
+	concurrent bool
+
+	// csvMu keeps analytics rows of parallel encodes from interleaving
+	csvMu sync.Mutex
+
+	// nvencWarnOnce limits the warning about NVENC options on other encoders
+	nvencWarnOnce sync.Once
+	// twoPassWarnOnce limits the warning about encoders without two-pass mode
+	twoPassWarnOnce sync.Once
+
+	// capWarnOnce limits the warning about encoders without capped quality
+	capWarnOnce sync.Once
+	// normalizeWarnOnce limits the warning that --normalize-audio overrides
+	// --audio-codec copy
+	normalizeWarnOnce sync.Once
+
+	// jobsWarnOnce limits the warning about --jobs lowered for hardware
+	jobsWarnOnce sync.Once
+
+	// vmafOnce checks for libvmaf once per run; vmafUsable holds the result
+	vmafOnce   sync.Once
+	vmafUsable bool
+
+	// tonemapOnce checks for the tone mapping filters once per run;
+	// tonemapUsable holds the result
+	tonemapOnce   sync.Once
+	tonemapUsable bool
+
+	// commandContext creates commands that are killed when ctx is done;
+	// replaced in tests
+	commandContext func(ctx context.Context, name string, args ...string) *exec.Cmd
+
+	// sleep waits between retries until ctx is done; replaced in tests
+	sleep func(ctx context.Context, d time.Duration) error
 }
 
 // New creates a new transcoder instance
@@ -27,13 +135,51 @@ func New(config Config) *Transcoder {
 	}
 
 	executor := &RealCommandExecutor{}
-	return &Transcoder{
-		config:        config,
-		systemChecker: NewSystemChecker(executor),
-		fileDiscovery: NewFileDiscovery(),
-		pathUtils:     NewPathUtils(),
-		presets:       GetPresets(),
+	pathUtils := NewPathUtils()
+	pathUtils.SetSuffix(config.Suffix)
+	pathUtils.SetNameTemplate(config.NameTemplate)
+	pathUtils.SetExtension(config.ForceExtension)
+	if config.ForceExtension == "" && config.Container != "" {
+		pathUtils.SetExtension(formatFor(config.Container).extension)
 	}
+	fileDiscovery := NewFileDiscovery()
+	fileDiscovery.SetFollowSymlinks(config.FollowSymlinks)
+	fileDiscovery.SetMaxFilesPerDir(config.MaxFilesPerDir)
+	systemChecker := NewSystemChecker(executor)
+	level := config.logLevel()
+	// Features check Verbose for debug-only work such as extra probing
+	config.Verbose = level == LogDebug
+	t := &Transcoder{
+		config:         config,
+		log:            NewLogger(nil, level),
+		systemChecker:  systemChecker,
+		fileDiscovery:  fileDiscovery,
+		pathUtils:      pathUtils,
+		prober:         NewProber(executor),
+		temp:           NewTempManager(config.TempDir),
+		presets:        presetsFor(hostPresetPlatform(systemChecker.GetPlatform())),
+		outputGID:      -1,
+		commandContext: exec.CommandContext,
+		sleep:          sleepContext,
+	}
+	if config.StageDir != "" {
+		t.stage = NewStageManager(config.StageDir)
+	}
+	if err := t.composePreset(); err != nil {
+		panic(fmt.Sprintf("Invalid configuration: %v", err))
+	}
+	return t
+}
+
+// Logger returns the logger the transcoder writes its messages to
+func (t *Transcoder) Logger() *Logger {
+	return t.log
+}
+
+// PresetName returns the configured preset name, including one synthesized
+// from --codec and --resolution
+func (t *Transcoder) PresetName() string {
+	return t.config.Preset
 }
 
 // CheckFFmpegAvailability checks if FFmpeg is available
@@ -41,6 +187,21 @@ func (t *Transcoder) CheckFFmpegAvailability() error {
 	return t.systemChecker.CheckFFmpegAvailability()
 }
 
+// EncoderStatuses reports which known encoders the installed ffmpeg supports
+func (t *Transcoder) EncoderStatuses(smokeTest bool) ([]EncoderStatus, error) {
+	return t.systemChecker.EncoderStatuses(smokeTest)
+}
+
+// GetPlatform returns the platform detected for this system
+func (t *Transcoder) GetPlatform() Platform {
+	return t.systemChecker.GetPlatform()
+}
+
+// CheckFFplayAvailability checks if ffplay is available for previews
+func (t *Transcoder) CheckFFplayAvailability() error {
+	return t.systemChecker.CheckFFplayAvailability()
+}
+
 // CheckGPUAvailability checks if NVIDIA GPU is available
 func (t *Transcoder) CheckGPUAvailability() error {
 	return t.systemChecker.CheckGPUAvailability(t.config.GPUIndex, t.config.Verbose)
@@ -51,154 +212,1136 @@ func (t *Transcoder) CheckEncoderAvailability(encoder string) (bool, error) {
 	return t.systemChecker.CheckEncoderAvailability(encoder)
 }
 
+// PrepareOutputDir creates the top-level output directory with the configured permissions
+func (t *Transcoder) PrepareOutputDir() error {
+	return t.ensureOutputDir(t.config.OutputDir)
+}
+
+// ValidateTempDir checks that the temp directory for intermediate files is usable
+func (t *Transcoder) ValidateTempDir() error {
+	return t.temp.Validate()
+}
+
+// ValidateStageDir checks that the --stage-dir staging area is usable
+func (t *Transcoder) ValidateStageDir() error {
+	if t.stage == nil {
+		return nil
+	}
+	return t.stage.Validate()
+}
+
+// OpenManifest opens the checksum manifest configured with --manifest
+func (t *Transcoder) OpenManifest() error {
+	if t.config.Manifest == "" {
+		return nil
+	}
+	manifest, err := OpenManifest(t.config.Manifest, t.config.ManifestAlgorithm)
+	if err != nil {
+		return err
+	}
+	t.manifest = manifest
+	return nil
+}
+
+// OpenJSONOutput creates the analytics file configured with --json-output
+func (t *Transcoder) OpenJSONOutput() error {
+	if t.config.JSONOutput == "" {
+		return nil
+	}
+	analytics, err := OpenAnalyticsJSON(t.config.JSONOutput)
+	if err != nil {
+		return err
+	}
+	t.analyticsJSON = analytics
+	return nil
+}
+
+// OpenProbeCache loads the cross-run probe cache configured with --probe-cache
+func (t *Transcoder) OpenProbeCache() error {
+	if t.config.ProbeCache == "" {
+		return nil
+	}
+	cache, err := LoadProbeCache(t.config.ProbeCache)
+	if err != nil {
+		return err
+	}
+	t.probeCache = cache
+	t.prober.SetPersistentCache(cache)
+	return nil
+}
+
+// Cleanup removes all intermediate files created during the run, closes
+// the manifest, JSON analytics and log file and saves the probe cache
+func (t *Transcoder) Cleanup() {
+	t.temp.Cleanup()
+	if t.stage != nil {
+		t.stage.Cleanup()
+	}
+	if t.manifest != nil {
+		t.manifest.Close()
+		t.manifest = nil
+	}
+	if t.analyticsJSON != nil {
+		t.analyticsJSON.Close()
+		t.analyticsJSON = nil
+	}
+	if t.commandLog != nil {
+		t.commandLog.Close()
+		t.commandLog = nil
+	}
+	if t.probeCache != nil {
+		if err := t.probeCache.Save(); err != nil {
+			t.log.Warnf("failed to save probe cache: %v", err)
+		}
+	}
+}
+
+// CheckFilterAvailability checks if ffmpeg was built with a specific filter
+func (t *Transcoder) CheckFilterAvailability(filter string) (bool, error) {
+	return t.systemChecker.CheckFilterAvailability(filter)
+}
+
+// RequireFilter checks that ffmpeg supports a filter needed by a feature
+func (t *Transcoder) RequireFilter(filter, feature string) error {
+	return t.systemChecker.RequireFilter(filter, feature)
+}
+
 // FindVideoFiles finds all video files based on configuration
 func (t *Transcoder) FindVideoFiles() ([]string, error) {
-	return t.fileDiscovery.FindVideoFiles(t.config.InputPath, t.config.Recursive)
+	if t.config.DVD {
+		return t.findDVDTitles()
+	}
+	sources, err := t.fileDiscovery.FindVideoFilesInRoots(t.config.InputRoots(), t.config.Recursive)
+	if err != nil {
+		return nil, err
+	}
+	t.inputRoots = make(map[string]string, len(sources))
+	files := make([]string, 0, len(sources))
+	for _, source := range sources {
+		t.inputRoots[source.Path] = source.Root
+		files = append(files, source.Path)
+	}
+	return files, nil
 }
 
-// ProcessFiles processes all video files with the configured settings
-func (t *Transcoder) ProcessFiles(files []string) error {
-	var errors []error
+// inputBase returns the input root a file was discovered under, which
+// its output path is made relative to
+func (t *Transcoder) inputBase(inputPath string) string {
+	if root, ok := t.inputRoots[inputPath]; ok {
+		return root
+	}
+	return t.config.InputPath
+}
 
-	// Process files sequentially
-	for _, file := range files {
-		if err := t.processFile(file); err != nil {
-			errors = append(errors, err)
+// findDVDTitles discovers DVD titles and returns the first VOB of each title,
+// which stands in for the whole title in the rest of the pipeline
+func (t *Transcoder) findDVDTitles() ([]string, error) {
+	t.dvdTitles = make(map[string]*DVDTitle)
+	t.inputRoots = make(map[string]string)
+	seen := make(map[string]bool)
+	var files []string
+	for _, root := range t.config.InputRoots() {
+		titles, warnings, err := t.fileDiscovery.FindDVDTitles(root, t.config.Recursive)
+		if err != nil {
+			return nil, err
 		}
+		for _, warning := range warnings {
+			t.log.Warnf("%s", warning)
+		}
+
+		for _, title := range titles {
+			key := sourceKey(title.Parts[0])
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			t.dvdTitles[title.Parts[0]] = title
+			t.inputRoots[title.Parts[0]] = root
+			files = append(files, title.Parts[0])
+			t.log.Debugf("DVD title %s: %d part(s)", title.Name(), len(title.Parts))
+		}
+	}
+	return files, nil
+}
+
+// presetNameFor returns the name of the first preset used for a file,
+// honoring per-file overrides
+func (t *Transcoder) presetNameFor(inputPath string) string {
+	if name, ok := t.presetOverrides[inputPath]; ok {
+		return name
 	}
+	return t.config.Preset
+}
 
-	if len(errors) > 0 {
-		fmt.Printf("Completed with %d error(s):\n", len(errors))
-		for _, err := range errors {
-			fmt.Printf("  - %v\n", err)
+// presetNamesFor returns the names of all presets a file is encoded to; a
+// per-file override replaces the whole list
+func (t *Transcoder) presetNamesFor(inputPath string) []string {
+	if name, ok := t.presetOverrides[inputPath]; ok {
+		return []string{name}
+	}
+	return t.config.PresetNames()
+}
+
+// presetFor resolves the preset used for a file
+func (t *Transcoder) presetFor(inputPath string) (Preset, error) {
+	name := t.presetNameFor(inputPath)
+	preset, exists := t.presets[name]
+	if !exists {
+		return Preset{}, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("preset %s not found", name), nil)
+	}
+	return preset, nil
+}
+
+// configuredPresets resolves every preset of the run
+func (t *Transcoder) configuredPresets() ([]Preset, error) {
+	var presets []Preset
+	for _, name := range t.config.PresetNames() {
+		preset, exists := t.presets[name]
+		if !exists {
+			return nil, NewTranscoderError(ErrorTypeInvalidPreset,
+				fmt.Sprintf("preset %s not found", name), nil)
 		}
-		return fmt.Errorf("transcoding completed with errors")
+		presets = append(presets, preset)
 	}
+	return presets, nil
+}
 
+// SetPresetOverride uses a different preset for a single file
+func (t *Transcoder) SetPresetOverride(inputPath, preset string) error {
+	if _, exists := t.presets[preset]; !exists {
+		return NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("preset %s not found", preset), nil)
+	}
+	if t.presetOverrides == nil {
+		t.presetOverrides = make(map[string]string)
+	}
+	t.presetOverrides[inputPath] = preset
 	return nil
 }
 
-// ProcessFilesWithProgress processes all video files with progress tracking and CSV output
-func (t *Transcoder) ProcessFilesWithProgress(files []string, csvWriter *csv.Writer) error {
-	total := len(files)
-	var errors []error
+// mediaInput returns what ffmpeg/ffprobe should read for an input file
+func (t *Transcoder) mediaInput(inputPath string) string {
+	if title, ok := t.dvdTitles[inputPath]; ok {
+		return title.ConcatURL()
+	}
+	t.stateMu.Lock()
+	repaired, ok := t.repaired[inputPath]
+	t.stateMu.Unlock()
+	if ok {
+		return repaired
+	}
+	return inputPath
+}
+
+// inputArgs returns the ffmpeg input arguments for an input file
+func (t *Transcoder) inputArgs(inputPath string) []string {
+	if title, ok := t.dvdTitles[inputPath]; ok {
+		return t.seekInputs(dvdInputArgs(title))
+	}
+	return t.seekInputs([]string{"-i", t.mediaInput(inputPath)})
+}
 
-	// Process files sequentially with progress tracking
+// inputSize returns the size of an input, summing all parts of a DVD title
+func (t *Transcoder) inputSize(inputPath string) (int64, error) {
+	if title, ok := t.dvdTitles[inputPath]; ok {
+		return title.Size, nil
+	}
+	info, err := os.Stat(inputPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// outputSource returns the path output names are derived from; DVD titles are
+// named after the title set rather than the first VOB
+func (t *Transcoder) outputSource(inputPath string) string {
+	if title, ok := t.dvdTitles[inputPath]; ok {
+		return filepath.Join(filepath.Dir(inputPath), title.Name()+filepath.Ext(inputPath))
+	}
+	return inputPath
+}
+
+// probeAll probes inputs concurrently, keyed by input path
+func (t *Transcoder) probeAll(files []string) map[string]*ProbeInfo {
+	media := make([]string, len(files))
 	for i, file := range files {
-		if err := t.processFileWithAnalytics(file, csvWriter); err != nil {
-			errors = append(errors, err)
+		media[i] = t.mediaInput(file)
+	}
+	workers := min(runtime.NumCPU(), maxProbeWorkers)
+	probed := t.prober.ProbeAll(media, workers)
+
+	infos := make(map[string]*ProbeInfo, len(probed))
+	for i, file := range files {
+		if info, ok := probed[media[i]]; ok {
+			infos[file] = info
+		}
+	}
+	return infos
+}
+
+// FilterByThresholds leaves out files below --min-size or outside
+// --min-duration/--max-duration and returns the rest together with how many
+// were left out. Durations are probed in parallel, and only for files that
+// pass the size check.
+func (t *Transcoder) FilterByThresholds(files []string) ([]string, int) {
+	kept, _ := t.fileDiscovery.FilterByThresholds(files, Thresholds{MinSize: t.config.MinSize}, nil)
+	if t.config.MinDuration > 0 || t.config.MaxDuration > 0 {
+		infos := t.probeAll(kept)
+		kept, _ = t.fileDiscovery.FilterByThresholds(kept, Thresholds{MinDuration: t.config.MinDuration, MaxDuration: t.config.MaxDuration},
+			func(file string) (time.Duration, error) {
+				info, ok := infos[file]
+				if !ok {
+					return 0, fmt.Errorf("could not probe %s", file)
+				}
+				return time.Duration(info.Duration * float64(time.Second)), nil
+			})
+	}
+
+	if t.log.Enabled(LogDebug) && len(kept) < len(files) {
+		remaining := make(map[string]bool, len(kept))
+		for _, file := range kept {
+			remaining[file] = true
+		}
+		for _, file := range files {
+			if !remaining[file] {
+				t.log.Debugf("Skipping %s (outside the size or duration thresholds)", file)
+			}
+		}
+	}
+	return kept, len(files) - len(kept)
+}
+
+// FilterByPolicy probes files and keeps only those matching the configured
+// policy. Files that cannot be probed are left out. Without a policy the
+// input is returned unchanged.
+func (t *Transcoder) FilterByPolicy(files []string) ([]string, error) {
+	if len(t.config.Policy) == 0 {
+		return files, nil
+	}
+
+	policy, err := ParsePolicy(t.config.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := t.probeAll(files)
+
+	var matched []string
+	for _, file := range files {
+		info, ok := infos[file]
+		if !ok {
+			t.log.Warnf("could not probe %s, skipping policy check", file)
+			continue
+		}
+		if policy.Matches(info) {
+			matched = append(matched, file)
+		} else {
+			t.log.Debugf("Skipping %s (complies with policy)", file)
+		}
+	}
+
+	t.log.Infof("%d of %d file(s) matched the policy", len(matched), len(files))
+	return matched, nil
+}
+
+// ProcessFiles processes all video files with the configured settings, up
+// to --jobs at a time. Errors are reported in file order.
+func (t *Transcoder) ProcessFiles(ctx context.Context, files []string) error {
+	fileErrors := make([]error, len(files))
+	runJobs(len(files), t.jobCount(len(files)), func(int) bool { return ctx.Err() == nil }, func(i int) {
+		_, fileErrors[i] = t.processFile(ctx, files[i], nil)
+	})
+	return t.reportErrors(collectErrors(fileErrors))
+}
+
+// SetRunControl enables pausing and stopping the batch between files
+func (t *Transcoder) SetRunControl(control *RunControl) {
+	t.control = control
+}
+
+// ProcessFilesWithProgress processes all video files with progress tracking
+// and CSV output. Up to --jobs files are encoded at once; results, the
+// summary and errors are reported in file order however the encodes finish.
+// The returned summary covers the batch even when it ends early with an error.
+func (t *Transcoder) ProcessFilesWithProgress(ctx context.Context, files []string, csvWriter *csv.Writer) (*BatchSummary, error) {
+	started := time.Now()
+	if t.runStarted.IsZero() {
+		t.runStarted = started
+	}
+
+	// A dry run prints commands without running ffprobe
+	if t.config.DryRun {
+		offline := t.prober.setOffline(true)
+		defer t.prober.setOffline(offline)
+	}
+
+	durations := t.probeDurations(files)
+	progress := NewBatchProgress(files, durations)
+	jobs := t.jobCount(len(files))
+	var live *liveLine
+	if !t.config.NoProgress && t.log.Enabled(LogInfo) && IsTerminal(os.Stdout) {
+		live = newLiveLine(progress, t.log.Writer(), time.Second)
+	}
+	t.concurrent = jobs > 1
+	defer func() { t.concurrent = false }()
+
+	fileResults := make([]*FileResult, len(files))
+	fileErrors := make([]error, len(files))
+	t.markResume(ResumeStatusPending, files...)
+	var mu sync.Mutex
+	var lostErr error
+	lost := make(map[int]bool)        // Files that failed because their input went away
+	interrupted := make(map[int]bool) // Files whose encode the interrupt stopped
+
+	next := func(i int) bool {
+		// An interrupt stops dispatching; the summary lists what is left
+		if ctx.Err() != nil {
+			return false
+		}
+
+		// Hold here while paused from the keyboard; quitting leaves the
+		// remaining files for a later run
+		if t.control != nil && !t.control.Wait() {
+			remaining := files[i:]
+			t.log.Infof("Quit requested; %d file(s) not processed:", len(remaining))
+			for _, path := range remaining {
+				t.log.Infof("  - %s", path)
+			}
+			t.log.Infof("Run the same command again to continue; finished outputs are skipped")
+			return false
+		}
+
+		// Stop dispatching once the runtime limit is exceeded; files in
+		// flight when the limit passes are allowed to finish
+		if t.timeLimitReached() {
+			remaining := files[i:]
+			t.log.Infof("Time limit of %s reached; %d file(s) not processed (time limit):",
+				t.config.MaxRuntime, len(remaining))
+			for _, path := range remaining {
+				t.log.Infof("  - %s", path)
+			}
+			t.log.Infof("Run the same command again to continue; finished outputs are skipped")
+			return false
+		}
+
+		// A removable drive or network share that went away would otherwise
+		// fail every remaining file one by one
+		mu.Lock()
+		defer mu.Unlock()
+		if lostErr == nil {
+			lostErr = t.checkInputAccessible(files[i])
+		}
+		return lostErr == nil
+	}
+
+	dispatched := runJobs(len(files), jobs, next, func(i int) {
+		// Without a terminal to redraw, progress is only printed between files
+		var fileProgress fileProgress
+		if live != nil {
+			fileProgress = &batchFileProgress{line: live, index: i, name: filepath.Base(files[i])}
+		}
+		result, err := t.processFileWithAnalytics(ctx, files[i], csvWriter, fileProgress)
+		if err != nil && ctx.Err() != nil {
+			mu.Lock()
+			interrupted[i] = true
+			mu.Unlock()
+			return
+		}
+		if err != nil {
+			// Blame the failure on the lost source rather than the file
+			if accessErr := t.checkInputAccessible(files[i]); accessErr != nil {
+				mu.Lock()
+				if lostErr == nil {
+					lostErr = accessErr
+				}
+				lost[i] = true
+				mu.Unlock()
+				return
+			}
+		}
+		fileResults[i], fileErrors[i] = result, err
+		if err != nil {
+			t.markResume(ResumeStatusFailed, files[i])
+		} else {
+			t.markResume(ResumeStatusDone, files[i])
 		}
 
 		// Show progress
-		completed := i + 1
-		fmt.Printf("Progress: %d/%d files completed (%.1f%%)\n",
-			completed, total, float64(completed)/float64(total)*100)
+		progress.Complete(i)
+		t.log.Infof("%s", progress.String())
+	})
+
+	summary := summarizeBatch(files, fileResults, fileErrors, durations, time.Since(started))
+	summary.Trimmed = t.trimming()
+
+	if ctx.Err() != nil {
+		var completed, remaining []string
+		for i, file := range files {
+			switch {
+			case interrupted[i] || i >= dispatched:
+				remaining = append(remaining, file)
+			case fileResults[i] != nil:
+				for _, output := range fileResults[i].outputs() {
+					if !output.Skipped {
+						completed = append(completed, output.OutputPath)
+					}
+				}
+			}
+		}
+		return summary, t.abortInterrupted(completed, remaining, collectErrors(fileErrors))
 	}
 
-	if len(errors) > 0 {
-		fmt.Printf("Completed with %d error(s):\n", len(errors))
-		for _, err := range errors {
-			fmt.Printf("  - %v\n", err)
+	if lostErr != nil {
+		var remaining []string
+		for i, file := range files {
+			if lost[i] || i >= dispatched {
+				remaining = append(remaining, file)
+			}
 		}
-		return fmt.Errorf("transcoding completed with errors")
+		return summary, t.abortInputLost(lostErr, remaining, collectErrors(fileErrors))
 	}
 
+	var results []*FileResult
+	for _, result := range fileResults {
+		if result != nil {
+			results = append(results, result.outputs()...)
+		}
+	}
+	// Summaries are info; --quiet leaves only the error list below
+	if t.log.Enabled(LogInfo) {
+		out := t.log.Writer()
+		if t.config.GroupByCodec {
+			printCodecSummary(out, SummarizeByCodec(results), t.config.RatioStyle)
+		}
+		if t.config.Energy {
+			printEnergySummary(out, results, t.assumedWatts())
+		}
+		printBatchSummary(out, summary, t.config.RatioStyle)
+	}
+
+	return summary, t.reportErrors(collectErrors(fileErrors))
+}
+
+// collectErrors drops the nil entries of per-file errors, keeping file order
+func collectErrors(fileErrors []error) []error {
+	var errors []error
+	for _, err := range fileErrors {
+		if err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return errors
+}
+
+// reportErrors lists the errors of a batch, if any, and returns the batch error
+func (t *Transcoder) reportErrors(errors []error) error {
+	if len(errors) == 0 {
+		return nil
+	}
+	t.log.Logf(LogError, "Completed with %d error(s):", len(errors))
+	for _, err := range errors {
+		t.log.Logf(LogError, "  - %v", err)
+	}
+	return fmt.Errorf("transcoding completed with errors")
+}
+
+// timeLimitReached reports whether --max-runtime has passed since the run started
+func (t *Transcoder) timeLimitReached() bool {
+	return t.config.MaxRuntime > 0 && !t.runStarted.IsZero() && time.Since(t.runStarted) >= t.config.MaxRuntime
+}
+
+// StreamBatches discovers input files incrementally and hands them to process
+// in batches of up to size files, in discovery order. Only one batch is held
+// at a time, and per-file state is dropped once its batch is done, so memory
+// stays bounded however large the library is. Processing continues after a
+// failed batch; the first error is returned at the end. Keyboard quit,
+// --max-runtime and cancelling ctx stop discovery as well.
+func (t *Transcoder) StreamBatches(ctx context.Context, size int, process func(batch []string, index int) error) error {
+	if t.config.DVD {
+		return NewTranscoderError(ErrorTypeInvalidFilePath,
+			"DVD titles span several files and cannot be discovered in batches", nil)
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	var firstErr error
+	batch := make([]string, 0, size)
+	index := 0
+	t.inputRoots = make(map[string]string)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		index++
+		if err := process(batch, index); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		t.forgetFiles(batch)
+		batch = batch[:0]
+		if ctx.Err() != nil {
+			return errStopBatches
+		}
+		if t.control != nil && !t.control.Wait() {
+			return errStopBatches
+		}
+		if t.timeLimitReached() {
+			return errStopBatches
+		}
+		return nil
+	}
+
+	err := t.fileDiscovery.StreamVideoFiles(t.config.InputRoots(), t.config.Recursive, func(source SourceFile) error {
+		t.inputRoots[source.Path] = source.Root
+		batch = append(batch, source.Path)
+		if len(batch) < size {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == errStopBatches {
+		t.log.Infof("Stopped before discovering the remaining files; run the same command again to continue")
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return firstErr
+}
+
+// errStopBatches ends batch discovery early without reporting an error
+var errStopBatches = errors.New("stop batches")
+
+// forgetFiles drops the per-file state kept for files that are done
+func (t *Transcoder) forgetFiles(files []string) {
+	for _, file := range files {
+		t.prober.Invalidate(t.mediaInput(file))
+		delete(t.inputRoots, file)
+		delete(t.presetOverrides, file)
+		t.stateMu.Lock()
+		delete(t.adaptiveRates, file)
+		delete(t.crops, file)
+		delete(t.loudnorms, file)
+		if repaired, ok := t.repaired[file]; ok {
+			os.RemoveAll(filepath.Dir(repaired))
+			delete(t.repaired, file)
+		}
+		t.stateMu.Unlock()
+	}
+}
+
+// checkInputAccessible verifies that the input root of a file can still be read
+func (t *Transcoder) checkInputAccessible(inputPath string) error {
+	root := t.inputBase(inputPath)
+	if _, err := os.Stat(root); err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError,
+			fmt.Sprintf("input source %s is no longer accessible", root), err)
+	}
 	return nil
 }
 
-// processFile processes a single video file
-func (t *Transcoder) processFile(inputPath string) error {
-	preset, exists := t.presets[t.config.Preset]
-	if !exists {
-		return NewTranscoderError(ErrorTypeInvalidPreset,
-			fmt.Sprintf("preset %s not found", t.config.Preset), nil)
+// abortInputLost stops a batch whose input root disappeared, listing the
+// files that were not processed along with any earlier per-file errors
+func (t *Transcoder) abortInputLost(err error, remaining []string, errors []error) error {
+	t.log.Logf(LogError, "\nAborting: %v", err)
+	t.log.Logf(LogError, "%d file(s) not processed:", len(remaining))
+	for _, path := range remaining {
+		t.log.Logf(LogError, "  - %s", path)
+	}
+	if len(errors) > 0 {
+		t.log.Logf(LogError, "Earlier error(s):")
+		for _, fileErr := range errors {
+			t.log.Logf(LogError, "  - %v", fileErr)
+		}
+	}
+	t.log.Logf(LogError, "Reconnect the source and run the same command again to continue; finished outputs are skipped")
+	return err
+}
+
+// abortInterrupted ends a batch stopped by an interrupt, listing the outputs
+// completed before it, which are kept, and the files left for a later run
+func (t *Transcoder) abortInterrupted(completed, remaining []string, errors []error) error {
+	t.log.Logf(LogWarn, "\nInterrupted; %d file(s) completed and kept:", len(completed))
+	for _, path := range completed {
+		t.log.Logf(LogWarn, "  - %s", path)
+	}
+	t.log.Logf(LogWarn, "%d file(s) not processed:", len(remaining))
+	for _, path := range remaining {
+		t.log.Logf(LogWarn, "  - %s", path)
+	}
+	if len(errors) > 0 {
+		t.log.Logf(LogWarn, "Earlier error(s):")
+		for _, fileErr := range errors {
+			t.log.Logf(LogWarn, "  - %v", fileErr)
+		}
+	}
+	t.log.Logf(LogWarn, "Run the same command again to continue; finished outputs are skipped")
+	return NewTranscoderError(ErrorTypeInterrupted, "run interrupted", nil)
+}
+
+// probeDurations probes all files up front so batch progress can be weighted by
+// duration. Returns nil when probing is disabled.
+func (t *Transcoder) probeDurations(files []string) map[string]float64 {
+	if t.config.NoProbe || t.config.DryRun {
+		return nil
+	}
+
+	t.log.Debugf("Probing %d file(s) for duration...", len(files))
+
+	durations := make(map[string]float64, len(files))
+	for path, info := range t.probeAll(files) {
+		durations[path] = t.trimmedDuration(info.Duration)
+	}
+	return durations
+}
+
+// processFile processes a single video file. progress may be nil; when set it
+// receives in-file progress while the primary encode runs. Cancelling ctx
+// stops ffmpeg and removes the partial output.
+func (t *Transcoder) processFile(ctx context.Context, inputPath string, progress fileProgress) (*FileResult, error) {
+	return t.processPresets(ctx, inputPath, progress, func(preset Preset, progress fileProgress) (*FileResult, error) {
+		return t.processPreset(ctx, inputPath, preset, progress)
+	})
+}
+
+// processPresets encodes a file with each of its presets in turn through
+// encode. A failing preset does not stop the others, and their errors are
+// joined. The result is that of the first preset that produced one, with the
+// results of the others in Others. The source is only deleted once every
+// preset has encoded it. Each preset reports its progress as its share of
+// the file's.
+func (t *Transcoder) processPresets(ctx context.Context, inputPath string, progress fileProgress, encode func(Preset, fileProgress) (*FileResult, error)) (*FileResult, error) {
+	names := t.presetNamesFor(inputPath)
+	var result *FileResult
+	var errs []error
+	for i, name := range names {
+		preset, exists := t.presets[name]
+		if !exists {
+			errs = append(errs, NewTranscoderError(ErrorTypeInvalidPreset,
+				fmt.Sprintf("preset %s not found", name), nil))
+			continue
+		}
+
+		presetProgress := progress
+		if progress != nil && len(names) > 1 {
+			presetProgress = &sharedProgress{progress: progress, part: i, parts: len(names)}
+		}
+		presetResult, err := encode(preset, presetProgress)
+		if err != nil {
+			if len(names) > 1 {
+				err = fmt.Errorf("preset %s: %w", name, err)
+			}
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if result == nil {
+			result = presetResult
+		} else {
+			result.Others = append(result.Others, presetResult)
+		}
+		// A file without video is skipped the same way by every preset
+		if presetResult.SkipReason == SkipReasonNoVideo {
+			break
+		}
+	}
+
+	if len(errs) == 1 {
+		return result, errs[0]
+	}
+	if len(errs) > 1 {
+		return result, errors.Join(errs...)
+	}
+	t.deleteSource(result)
+	return result, nil
+}
+
+// processPreset encodes a file with one preset, gathering the ffmpeg commands
+// it runs into one --log-file section
+func (t *Transcoder) processPreset(ctx context.Context, inputPath string, preset Preset, progress fileProgress) (*FileResult, error) {
+	logPath := t.pathUtils.SanitizeWindowsPath(inputPath)
+	t.commandLog.Begin(logPath, preset.Name)
+	result, err := t.encodePreset(ctx, inputPath, preset, progress)
+	t.commandLog.End(logPath, result, err)
+	return result, err
+}
+
+// checkSource probes a file before its encode. It returns the result of a
+// file left alone, such as one without video, or an error when the file
+// cannot be encoded as configured.
+func (t *Transcoder) checkSource(inputPath string, preset Preset) (*FileResult, error) {
+	// Audio-only files, such as voice memos saved as .mov or .m4v, have
+	// nothing for a video preset to encode
+	if info, err := t.prober.Probe(t.mediaInput(inputPath)); err == nil && !info.HasVideo() {
+		t.log.Infof("Skipping %s (no video stream)", inputPath)
+		return &FileResult{
+			InputPath:   inputPath,
+			Preset:      preset,
+			Skipped:     true,
+			SkipReason:  SkipReasonNoVideo,
+			SourceProbe: info,
+		}, nil
+	}
+
+	// With --skip-if-codec, sources already in the preset's codec at no more
+	// than its resolution are left alone rather than encoded again
+	if info, skip := t.skipTargetCodec(inputPath, preset); skip {
+		width, height := info.DisplaySize()
+		t.log.Infof("Skipping %s (already %s at %dx%d)", inputPath, info.VideoCodec, width, height)
+		return &FileResult{
+			InputPath:   inputPath,
+			Preset:      preset,
+			Skipped:     true,
+			SkipReason:  SkipReasonTargetCodec,
+			SourceCodec: info.VideoCodec,
+			SourceProbe: info,
+		}, nil
+	}
+
+	if err := t.checkAudioTrack(inputPath); err != nil {
+		return nil, err
 	}
+	return nil, t.checkSubtitles(inputPath)
+}
 
+// encodePreset does the work of processPreset
+func (t *Transcoder) encodePreset(ctx context.Context, inputPath string, preset Preset, progress fileProgress) (*FileResult, error) {
 	// Sanitize paths for Windows
 	inputPath = t.pathUtils.SanitizeWindowsPath(inputPath)
 
 	// Validate file path for common issues
 	if err := ValidateFilePath(inputPath); err != nil {
-		return fmt.Errorf("invalid file path: %v", err)
+		return nil, fmt.Errorf("invalid file path: %v", err)
 	}
 
-	// Probe input file to ensure it's valid
-	if t.config.Verbose {
-		fmt.Printf("Probing input file...\n")
+	// A dry run never probes, so the source checks are left to the encode
+	if !t.config.DryRun {
+		if result, err := t.checkSource(inputPath, preset); result != nil || err != nil {
+			return result, err
+		}
 	}
-	if err := t.probeInputFile(inputPath); err != nil {
-		return fmt.Errorf("input file validation failed: %v", err)
+	if err := t.checkContainer(preset); err != nil {
+		return nil, err
 	}
 
 	// Generate output filename
-	outputPath := t.pathUtils.GenerateOutputPath(inputPath, t.config.OutputDir, t.config.InputPath, preset)
+	outputPath := t.pathUtils.GenerateOutputPath(t.outputSource(inputPath), t.config.OutputDir, t.inputBase(inputPath), preset)
 	outputPath = t.pathUtils.SanitizeWindowsPath(outputPath)
 
+	// Reading and writing the same file would corrupt it
+	if t.pathUtils.IsSamePath(inputPath, outputPath) {
+		return nil, NewTranscoderError(ErrorTypeInvalidFilePath,
+			fmt.Sprintf("output path %s is the same file as the input; choose a different output directory", outputPath), nil)
+	}
+
+	result := &FileResult{
+		InputPath:  inputPath,
+		OutputPath: outputPath,
+		Preset:     preset,
+	}
+
+	exists, err := outputExists(outputPath)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check if output already exists
 	if !t.config.Overwrite {
-		if _, err := os.Stat(outputPath); err == nil {
-			if t.config.Verbose {
-				fmt.Printf("Skipping %s (output already exists)\n", inputPath)
-			}
-			return nil
+		if exists {
+			t.log.Debugf("Skipping %s (output already exists)", inputPath)
+			result.Skipped = true
+			result.SkipReason = SkipReasonOutputExists
+			return result, nil
+		}
+	} else if warning := linkedOutputWarning(outputPath); warning != "" {
+		t.log.Warnf("%s", warning)
+	}
+
+	// A dry run shows what would run and stops before anything is decoded,
+	// created or written
+	if t.config.DryRun {
+		commands := t.dryRunCommands(inputPath, outputPath, preset)
+		t.log.Infof("Dry run: %s -> %s", inputPath, outputPath)
+		for _, args := range commands {
+			t.log.Infof("  %s", FormatCommand("ffmpeg", args))
 		}
+		result.Args = commands[0]
+		result.Skipped = true
+		result.SkipReason = SkipReasonDryRun
+		return result, nil
+	}
+
+	// A stream-copy remux fixes container problems that make files fail or
+	// seek poorly; the repaired copy is validated and encoded instead
+	if t.config.Repair {
+		info, _ := t.prober.Probe(inputPath)
+		repairs, err := t.repairInput(ctx, inputPath, info)
+		if err != nil {
+			return nil, err
+		}
+		if len(repairs) > 0 {
+			t.log.Infof("Repaired %s: %s", filepath.Base(inputPath), strings.Join(repairs, ", "))
+			result.Repairs = repairs
+		}
+	}
+
+	// Probe input file to ensure it's valid
+	t.log.Debugf("Probing input file...")
+	if err := t.probeInputFile(ctx, inputPath); err != nil {
+		return nil, fmt.Errorf("input file validation failed: %v", err)
 	}
 
 	// Create output directory if needed
-	outputDir := filepath.Dir(outputPath)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return NewTranscoderError(ErrorTypeFileSystemError,
-			"failed to create output directory", err)
+	if err := t.ensureOutputDir(filepath.Dir(outputPath)); err != nil {
+		return nil, err
 	}
 
-	if t.config.Verbose {
-		fmt.Printf("Processing: %s -> %s\n", inputPath, outputPath)
+	t.log.Debugf("Processing: %s -> %s", inputPath, outputPath)
+
+	// Source details feed the sidecar record and the per-codec summary; the
+	// probe is usually cached from the duration scan
+	if t.config.Sidecar || !t.config.NoProbe {
+		if info, err := t.prober.Probe(t.mediaInput(inputPath)); err == nil {
+			result.SourceProbe = info
+			result.SourceCodec = info.VideoCodec
+		} else {
+			t.log.Debugf("Could not probe source: %v", err)
+		}
 	}
 
-	// Build FFmpeg command
-	args := t.buildFFmpegArgs(inputPath, outputPath, preset, !t.config.NoGPU)
+	// The crop is settled first, since the complexity probe of adaptive
+	// bitrate encodes with it
+	if err := t.chooseCrop(ctx, inputPath); err != nil {
+		return nil, err
+	}
+	if err := t.chooseLoudnorm(ctx, inputPath); err != nil {
+		return nil, err
+	}
 
-	// Execute FFmpeg
-	startTime := time.Now()
-	cmd := exec.Command("ffmpeg", args...)
+	// Adaptive bitrate replaces the preset's one-size-fits-all target; a
+	// failed probe leaves the preset bitrate in place
+	t.clearTargetBitrate(inputPath, preset.Name)
+	if t.config.AdaptiveBitrate {
+		if rate, err := t.chooseAdaptiveBitrate(ctx, inputPath, preset); err != nil {
+			t.log.Warnf("%v; using the preset bitrate", err)
+		} else {
+			t.setTargetBitrate(inputPath, preset.Name, rate)
+			result.TargetBitrate = rate
+			t.log.Infof("Adaptive bitrate for %s: %s (preset %s)", filepath.Base(inputPath), formatBitrateArg(rate), preset.Bitrate)
+		}
+	}
 
-	if t.config.Verbose {
-		encodingMode := "hardware"
-		if t.config.NoGPU {
-			encodingMode = "software"
+	// Smart bitrate then keeps the target, adaptive or the preset's, below
+	// the source's own bitrate
+	if t.config.SmartBitrate {
+		if rate, source, capped := t.smartBitrate(inputPath, preset); capped {
+			t.setTargetBitrate(inputPath, preset.Name, rate)
+			result.TargetBitrate = rate
+			result.BitrateCapped = true
+			t.log.Infof("Smart bitrate for %s: %s, capped below the source's %s (preset %s)",
+				filepath.Base(inputPath), formatBitrateArg(rate), formatBitrateArg(source), preset.Bitrate)
 		}
-		fmt.Printf("Running (%s): ffmpeg %s\n", encodingMode, strings.Join(args, " "))
 	}
 
-	// Always capture stderr to get detailed error information
-	var stderrBuf strings.Builder
-	cmd.Stderr = &stderrBuf
+	// With --stage-dir ffmpeg writes to the staging area and the result is
+	// moved into place only once the encode succeeded
+	encodePath, err := t.stageOutput(outputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build FFmpeg command
+	args := t.buildFFmpegArgs(inputPath, encodePath, preset, t.useHardware(preset))
+	result.Args = args
+	result.Encoder = argValue(args, "-c:v")
+	result.EncodingMode = EncodingModeHardware
+	if !t.useHardware(preset) {
+		result.EncodingMode = EncodingModeSoftware
+	}
 
-	ffmpegErr := cmd.Run()
-	stderrOutput := stderrBuf.String()
+	t.log.Debugf("Running (%s): %s", result.EncodingMode, FormatCommand("ffmpeg", args))
+
+	// Execute FFmpeg
+	result.StartTime = time.Now()
+	stderrOutput, ffmpegErr := t.encodeWithRetries(ctx, inputPath, args, preset, progress)
+	if ctx.Err() == nil {
+		t.logFFmpegOutput(inputPath, stderrOutput, ffmpegErr != nil)
+	}
 
-	// Handle encoding errors with fallback
+	// Handle encoding errors with fallback; a skipped or interrupted file is
+	// not retried, and its partial output is removed
+	if ffmpegErr != nil && ctx.Err() != nil {
+		t.discardStaged(encodePath, outputPath)
+		return nil, NewTranscoderError(ErrorTypeInterrupted,
+			fmt.Sprintf("encoding of %s stopped; partial output removed", inputPath), ctx.Err())
+	}
 	if ffmpegErr != nil {
-		if err := t.handleEncodingError(ffmpegErr, stderrOutput, inputPath, outputPath, preset); err != nil {
-			return err
+		mode, err := t.handleEncodingError(ctx, ffmpegErr, stderrOutput, inputPath, encodePath, preset)
+		if err != nil {
+			t.discardStaged(encodePath, outputPath)
+			return nil, err
 		}
+		result.EncodingMode = mode
+		result.Encoder = t.fallbackEncoder(mode, inputPath, encodePath, preset)
 	}
 
-	duration := time.Since(startTime)
+	result.EndTime = time.Now()
+
+	if err := t.commitStaged(encodePath, outputPath); err != nil {
+		t.discardStaged(encodePath, outputPath)
+		return nil, err
+	}
+
+	if err := t.applyOutputPermissions(outputPath, false); err != nil {
+		t.log.Warnf("%v", err)
+	}
+	if err := preserveModTime(inputPath, outputPath); err != nil {
+		t.log.Warnf("%v", err)
+	}
 
 	// Get file sizes for compression info
-	inputInfo, _ := os.Stat(inputPath)
+	inputSize, inputErr := t.inputSize(inputPath)
 	outputInfo, _ := os.Stat(outputPath)
 
-	if inputInfo != nil && outputInfo != nil {
-		compressionRatio := float64(outputInfo.Size()) / float64(inputInfo.Size()) * 100
-		fmt.Printf("Completed %s in %s (%.1f%% of original size)\n",
-			filepath.Base(inputPath),
-			duration.Round(time.Second),
-			compressionRatio)
+	if inputErr == nil && outputInfo != nil {
+		result.InputSize = inputSize
+		result.OutputSize = outputInfo.Size()
+		// A segment compared with its whole source says nothing
+		sizeChange := FormatSizeChange(result.InputSize, result.OutputSize, t.config.RatioStyle)
+		if t.trimming() {
+			sizeChange = "trimmed, output " + FormatBytes(result.OutputSize)
+		}
+		name := filepath.Base(inputPath)
+		if len(t.presetNamesFor(inputPath)) > 1 {
+			name += " with " + preset.Name
+		}
+		t.log.Infof("Completed %s in %s (%s)",
+			name,
+			result.Duration().Round(time.Second),
+			sizeChange)
 	}
 
-	return nil
+	// Scores need the source, so they are measured before it can be deleted
+	if t.config.MeasureQuality || t.config.MeasureSSIM {
+		result.Quality = t.measureQuality(ctx, inputPath, outputPath)
+		if scores := result.Quality.String(); scores != "" {
+			t.log.Infof("Quality of %s: %s", filepath.Base(outputPath), scores)
+		}
+	}
+
+	if t.manifest != nil {
+		if err := t.manifest.Add(outputPath); err != nil {
+			t.log.Warnf("failed to add %s to manifest: %v", filepath.Base(outputPath), err)
+		}
+	}
+
+	if t.config.Sidecar {
+		if info, err := t.prober.Probe(outputPath); err == nil {
+			result.OutputProbe = info
+		} else {
+			t.log.Debugf("Could not probe output: %v", err)
+		}
+		if err := t.writeSidecar(result); err != nil {
+			t.log.Warnf("failed to write sidecar for %s: %v", filepath.Base(outputPath), err)
+		} else if err := t.applyOutputPermissions(SidecarPath(outputPath), false); err != nil {
+			t.log.Warnf("%v", err)
+		}
+	}
+
+	t.writeThumbnails(ctx, inputPath, outputPath)
+
+	return result, nil
+}
+
+// ffmpegStopTimeout is how long ffmpeg gets to exit after being asked to stop
+// before it is killed
+const ffmpegStopTimeout = 5 * time.Second
+
+// ffmpegCommand creates an ffmpeg command that is stopped when ctx is done,
+// as when the file in flight is skipped or the run is interrupted. ffmpeg is
+// asked to quit with SIGTERM first and killed if it has not exited after
+// ffmpegStopTimeout; where SIGTERM is unsupported it is killed at once.
+func (t *Transcoder) ffmpegCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := t.commandContext(ctx, "ffmpeg", args...)
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	// Also bounds the wait on pipes held open by anything ffmpeg left behind
+	cmd.WaitDelay = ffmpegStopTimeout
+	return cmd
+}
+
+// outputExists reports whether an output file is already present. A directory
+// at the output path is an error: it would pass for an existing output, and
+// ffmpeg cannot write over it.
+func outputExists(outputPath string) (bool, error) {
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return false, nil
+	}
+	if info.IsDir() {
+		return true, NewTranscoderError(ErrorTypeInvalidFilePath,
+			fmt.Sprintf("output path %s is a directory", outputPath), nil)
+	}
+	return true, nil
+}
+
+// runFFmpeg runs an encode and returns its stderr output. When progress is set,
+// ffmpeg's machine-readable progress is parsed and reported as a fraction of
+// the source duration. Every run is recorded in the --log-file.
+func (t *Transcoder) runFFmpeg(ctx context.Context, inputPath string, args []string, progress fileProgress) (stderr string, err error) {
+	var stderrBuf strings.Builder
+	started := time.Now()
+	defer func() {
+		t.commandLog.Command(inputPath, args, stderrBuf.String(), err, time.Since(started))
+	}()
+
+	var sourceDuration float64
+	if progress != nil && !t.config.NoProbe {
+		if info, err := t.prober.Probe(t.mediaInput(inputPath)); err == nil {
+			sourceDuration = t.trimmedDuration(info.Duration)
+		}
+	}
+
+	if sourceDuration <= 0 {
+		cmd := t.ffmpegCommand(ctx, args...)
+		// Always capture stderr to get detailed error information
+		cmd.Stderr = &stderrBuf
+		err := cmd.Run()
+		return stderrBuf.String(), err
+	}
+
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	cmd := t.ffmpegCommand(ctx, args...)
+	cmd.Stderr = &stderrBuf
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	parseFFmpegProgress(stdout, func(status encodeStatus) {
+		status.Duration = time.Duration(sourceDuration * float64(time.Second))
+		progress.Update(status)
+	})
+	// Drain anything left so ffmpeg never blocks on a full pipe
+	io.Copy(io.Discard, stdout)
+	err = cmd.Wait()
+	progress.Done()
+
+	return stderrBuf.String(), err
 }
 
 // buildFFmpegArgs builds the FFmpeg command arguments
@@ -219,219 +1362,526 @@ func (t *Transcoder) buildFFmpegArgs(inputPath, outputPath string, preset Preset
 		case PlatformNVIDIA:
 			// Add hardware acceleration for encoding only (avoid hardware decoding issues)
 			args = append(args, "-hwaccel", "auto")
+		case PlatformIntelQSV:
+			// Decoded frames are copied back to system memory, so the
+			// software filters of the preset still apply. The device
+			// selects the Intel GPU for both decoding and encoding.
+			if device := t.systemChecker.qsvDevice(t.config.GPUIndex); device != "" {
+				args = append(args, "-qsv_device", device)
+			}
+			args = append(args, "-hwaccel", "qsv")
+		case PlatformAMD:
+			// VAAPI encoders upload frames to this device; AMF needs none
+			if device := t.systemChecker.vaapiDevice(t.config.GPUIndex); device != "" && isVAAPIEncoder(preset.Encoder) {
+				args = append(args, "-vaapi_device", device)
+			}
 		}
 	}
 
-	// Add input file
-	args = append(args, "-i", inputPath)
+	// Add input file, followed by any external subtitle inputs
+	args = append(args, t.inputArgs(inputPath)...)
+	subs := t.subtitlesFor(inputPath)
+	subInputs, subOutputs := subtitleArgs(subs, t.containerFormat().extension, t.config.SubtitleLanguage)
+	args = append(args, t.seekInputs(subInputs)...)
+	var maps []string
+	if _, ok := t.dvdTitles[inputPath]; ok {
+		maps = dvdMapArgs()
+	}
+	maps = append(maps, subOutputs...)
+	args = append(args, t.selectAudioTracks(inputPath, t.embeddedSubtitleArgs(inputPath, maps, len(subs)))...)
+
+	// Add preset arguments (hardware or software) with the rate control
+	// options applied in turn
+	videoArgs := t.videoArgs(preset, useHardware)
+	videoArgs = t.applyAdaptiveBitrate(inputPath, preset, videoArgs)
+	videoArgs = t.applyRateFactors(videoArgs)
+	videoArgs = t.applyQualityTarget(videoArgs)
+	videoArgs = t.applyEncoderSpeed(videoArgs)
+	videoArgs = t.applyQuality(videoArgs)
+	videoArgs = t.applyBitrateCap(videoArgs)
+
+	// Build the -vf chain from the preset's, run it through the filter
+	// stages and render it once
+	chain := filterChainOf(videoArgs)
+	for _, stage := range t.filterStages(videoArgs) {
+		chain = stage(inputPath, chain)
+	}
+	chain = vaapiUpload(argValue(videoArgs, "-c:v"), chain)
+	videoArgs = withFilterChain(videoArgs, chain)
+	if t.usesTwoPass(preset, videoArgs) {
+		videoArgs = t.twoPassVideoArgs(videoArgs)
+	}
+	args = append(args, videoArgs...)
+	args = append(args, t.containerVideoTag(argValue(videoArgs, "-c:v"))...)
+
+	// Add encoder tuning; tunes that don't apply to a fallback encoder are dropped
+	encoder := argValue(videoArgs, "-c:v")
+	if tune := t.effectiveTune(preset, encoder); tune != "" {
+		if tuneArgs, err := TuneArgs(encoder, tune); err == nil {
+			args = append(args, tuneArgs...)
+		} else {
+			t.log.Debugf("Ignoring tune '%s' for %s", tune, encoder)
+		}
+	}
+
+	// Add NVENC rate control options; other encoders ignore them with a warning
+	args = append(args, t.nvencArgs(encoder)...)
+
+	// Add audio codecs, copying streams that already have the target codec
+	args = append(args, t.audioArgs(inputPath, mapsAllAudio(args))...)
+
+	// Carry the source's tags and chapters over, then tag the output with
+	// provenance metadata
+	args = append(args, t.metadataArgs()...)
+	args = append(args, t.provenanceArgs(inputPath, preset)...)
 
-	// Add preset arguments (hardware or software)
+	// Encode only the --duration or --end segment
+	args = append(args, t.trimOutputArgs()...)
+
+	// Add output path, naming the muxer when the extension would suggest another
+	args = append(args, t.muxerArgs(outputPath)...)
+
+	// User arguments come last so ffmpeg lets them override everything above
+	args = append(args, t.config.FFmpegArgs...)
+	args = append(args, "-y", outputPath)
+
+	return args
+}
+
+// useHardware reports whether a preset should be encoded on the hardware path.
+// It is false with --no-gpu or when the preset's codec is listed in --software-codecs.
+func (t *Transcoder) useHardware(preset Preset) bool {
+	if t.config.NoGPU {
+		return false
+	}
+	codec := normalizeCodecName(preset.Codec)
+	for _, forced := range t.config.SoftwareCodecs {
+		if normalizeCodecName(forced) == codec {
+			return false
+		}
+	}
+	return true
+}
+
+// videoArgs returns the video encoding arguments for the hardware or software path
+func (t *Transcoder) videoArgs(preset Preset, useHardware bool) []string {
+	platform := t.systemChecker.GetPlatform()
 	if useHardware && (preset.Platform == platform || preset.Platform == Platform(0)) {
 		// Use hardware preset if platform matches or preset is platform-agnostic
-		args = append(args, preset.Args...)
-	} else {
-		// Use software encoding
-		softwareArgs := t.convertToSoftwarePreset(preset)
-		args = append(args, softwareArgs...)
+		return preset.Args
 	}
+	// Use software encoding
+	return t.convertToSoftwarePreset(preset)
+}
 
-	// Add audio codec
-	if t.config.AudioCodec == "" || t.config.AudioCodec == "copy" {
-		args = append(args, "-c:a", "copy")
-	} else {
-		args = append(args, "-c:a", t.config.AudioCodec, "-b:a", "128k")
+// applyRateFactors derives -maxrate and -bufsize from the effective -b:v using
+// the configured factors, replacing any values the preset already set
+func (t *Transcoder) applyRateFactors(args []string) []string {
+	if t.config.MaxrateFactor <= 0 && t.config.BufsizeFactor <= 0 {
+		return args
 	}
 
-	// Add output path
-	args = append(args, "-y", outputPath)
+	bitrate, err := parseSIValue(argValue(args, "-b:v"))
+	if err != nil || bitrate <= 0 {
+		return args
+	}
 
+	// Never modify the preset's own slice
+	args = append([]string(nil), args...)
+	if t.config.MaxrateFactor > 0 {
+		args = setArgValue(args, "-maxrate", formatBitrateArg(bitrate*t.config.MaxrateFactor))
+	}
+	if t.config.BufsizeFactor > 0 {
+		args = setArgValue(args, "-bufsize", formatBitrateArg(bitrate*t.config.BufsizeFactor))
+	}
 	return args
 }
 
-// handleEncodingError handles FFmpeg encoding errors with fallback strategies
-func (t *Transcoder) handleEncodingError(ffmpegErr error, stderrOutput, inputPath, outputPath string, preset Preset) error {
-	if !t.config.NoGPU {
-		// Try software fallback
-		if t.config.Verbose {
-			fmt.Printf("Hardware encoding failed, attempting software fallback...\n")
-		} else {
-			fmt.Printf("Hardware encoding failed for %s, trying software fallback...\n", filepath.Base(inputPath))
+// effectiveTune returns the requested tune, falling back to the quality
+// target's tune for the encoder and then to the preset default
+func (t *Transcoder) effectiveTune(preset Preset, encoder string) string {
+	if t.config.Tune != "" {
+		return t.config.Tune
+	}
+	if tune := t.targetTune(encoder); tune != "" {
+		return tune
+	}
+	return preset.Tune
+}
+
+// ValidateTune checks that an explicitly requested tune is supported by the
+// encoders the configured presets will use
+func (t *Transcoder) ValidateTune() error {
+	if t.config.Tune == "" {
+		return nil
+	}
+	presets, err := t.configuredPresets()
+	if err != nil {
+		return err
+	}
+	for _, preset := range presets {
+		encoder := argValue(t.videoArgs(preset, t.useHardware(preset)), "-c:v")
+		if _, err := TuneArgs(encoder, t.config.Tune); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// toolMetadataComment builds the provenance comment embedded in transcoded outputs
+func (t *Transcoder) toolMetadataComment(preset Preset) string {
+	version := t.config.ToolVersion
+	if version == "" {
+		version = "dev"
+	}
+	return fmt.Sprintf("transcoded by ffmcli %s preset=%s on %s",
+		version, preset.Name, time.Now().Format("2006-01-02"))
+}
+
+// handleEncodingError handles FFmpeg encoding errors with fallback strategies and
+// returns the encoding mode that eventually succeeded. Errors no fallback can
+// get past, such as a missing input, are returned without trying any.
+func (t *Transcoder) handleEncodingError(ctx context.Context, ffmpegErr error, stderrOutput, inputPath, outputPath string, preset Preset) (string, error) {
+	errorType := classifyFFmpegError(stderrOutput)
+	if t.useHardware(preset) && fallbackHelps(errorType) {
+		// Try software fallback
+		t.log.Warnf("hardware encoding failed for %s, trying software fallback...", filepath.Base(inputPath))
 
 		softwareArgs := t.buildFFmpegArgs(inputPath, outputPath, preset, false)
-		softwareCmd := exec.Command("ffmpeg", softwareArgs...)
+		t.log.Debugf("Running (%s): %s", EncodingModeSoftwareFallback, FormatCommand("ffmpeg", softwareArgs))
+		var softwareStderr string
+		var softwareErr error
+		if t.usesTwoPass(preset, softwareArgs) {
+			softwareStderr, softwareErr = t.encode(ctx, inputPath, softwareArgs, preset, nil)
+		} else {
+			softwareStderr, softwareErr = t.runFFmpeg(ctx, inputPath, softwareArgs, nil)
+		}
 
-		var softwareStderr strings.Builder
-		softwareCmd.Stderr = &softwareStderr
+		if softwareErr != nil && ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		t.logFFmpegOutput(inputPath, softwareStderr, softwareErr != nil)
+		if softwareErr != nil {
+			if softwareType := classifyFFmpegError(softwareStderr); !fallbackHelps(softwareType) {
+				return "", encodingError(softwareType, inputPath, softwareErr, softwareStderr)
+			}
 
-		if err := softwareCmd.Run(); err != nil {
 			// Try safe fallback
 			safeArgs := t.createSafeFallbackArgs(inputPath, outputPath)
-			safeCmd := exec.Command("ffmpeg", safeArgs...)
-
-			if safeErr := safeCmd.Run(); safeErr != nil {
-				return NewTranscoderError(ErrorTypeEncodingFailed,
+			t.log.Debugf("Running (%s): %s", EncodingModeSafeFallback, FormatCommand("ffmpeg", safeArgs))
+			safeStderr, safeErr := t.runFFmpeg(ctx, inputPath, safeArgs, nil)
+			t.logFFmpegOutput(inputPath, safeStderr, safeErr != nil)
+			if safeErr != nil {
+				return "", NewTranscoderError(ErrorTypeEncodingFailed,
 					fmt.Sprintf("all encoding attempts failed for %s", inputPath), safeErr)
 			}
 
-			fmt.Printf("Successfully encoded %s using safe fallback mode\n", filepath.Base(inputPath))
-		} else {
-			fmt.Printf("Successfully encoded %s using software fallback\n", filepath.Base(inputPath))
+			t.log.Infof("Successfully encoded %s using safe fallback mode", filepath.Base(inputPath))
+			return EncodingModeSafeFallback, nil
 		}
 
-		return nil
+		t.log.Infof("Successfully encoded %s using software fallback", filepath.Base(inputPath))
+		return EncodingModeSoftwareFallback, nil
 	}
 
-	return NewTranscoderError(ErrorTypeEncodingFailed,
-		fmt.Sprintf("encoding failed for %s", inputPath), ffmpegErr)
+	return "", encodingError(errorType, inputPath, ffmpegErr, stderrOutput)
 }
 
-// processFileWithAnalytics processes a single video file and writes analytics to CSV
-func (t *Transcoder) processFileWithAnalytics(inputPath string, csvWriter *csv.Writer) error {
-	startTime := time.Now()
+// encodingError describes a failed encode, typed by classifyFFmpegError and
+// quoting the line of ffmpeg's output that names the problem
+func encodingError(errorType ErrorType, inputPath string, err error, stderr string) error {
+	message := fmt.Sprintf("encoding failed for %s", inputPath)
+	if line := ffmpegErrorLine(stderr); line != "" {
+		message += ": " + line
+	}
+	return NewTranscoderError(errorType, message, err)
+}
+
+// fallbackEncoder returns the video encoder of the fallback that produced an
+// output in the given mode
+func (t *Transcoder) fallbackEncoder(mode, inputPath, outputPath string, preset Preset) string {
+	if mode == EncodingModeSafeFallback {
+		return argValue(t.createSafeFallbackArgs(inputPath, outputPath), "-c:v")
+	}
+	return argValue(t.videoArgs(preset, false), "-c:v")
+}
 
+// processFileWithAnalytics processes a single video file and writes its
+// analytics to the CSV and JSON analytics files, one record per preset
+func (t *Transcoder) processFileWithAnalytics(ctx context.Context, inputPath string, csvWriter *csv.Writer, progress fileProgress) (*FileResult, error) {
 	// Get input file size
-	inputInfo, err := os.Stat(inputPath)
+	inputSize, err := t.inputSize(inputPath)
 	if err != nil {
-		return NewTranscoderError(ErrorTypeFileSystemError,
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
 			"failed to get input file info", err)
 	}
-	inputSizeMB := float64(inputInfo.Size()) / (1024 * 1024)
-
-	// Process the file using existing method
-	err = t.processFile(inputPath)
 
-	endTime := time.Now()
-	duration := endTime.Sub(startTime).Seconds()
+	// With keyboard controls, 's' cancels this file's ffmpeg through its
+	// context; with several files in flight it could not tell which to cancel
+	skippable := t.control != nil && !t.concurrent
+	if skippable {
+		ctx = t.control.StartFile(ctx)
+	}
 
-	// Prepare CSV data
-	filename := filepath.Base(inputPath)
-	status := "success"
-	if err != nil {
-		status = "error"
+	// Each preset's outcome is recorded once the file is done, so a skip
+	// can still turn the failed attempt into a skipped one
+	type attempt struct {
+		preset             Preset
+		result             *FileResult
+		err                error
+		startTime, endTime time.Time
+	}
+	var attempts []attempt
+	result, err := t.processPresets(ctx, inputPath, progress, func(preset Preset, progress fileProgress) (*FileResult, error) {
+		startTime := time.Now()
+		energy := t.startEnergyMeter()
+		presetResult, err := t.processPreset(ctx, inputPath, preset, progress)
+		energy.finish(presetResult)
+		attempts = append(attempts, attempt{preset, presetResult, err, startTime, time.Now()})
+		return presetResult, err
+	})
+
+	if skippable {
+		// A skip that arrives after the encode finished leaves the output be
+		if t.control.FinishFile() && err != nil {
+			t.log.Infof("Skipped %s at the user's request", filepath.Base(inputPath))
+			preset, _ := t.presetFor(inputPath)
+			last := len(attempts) - 1
+			if last >= 0 {
+				preset = attempts[last].preset
+			}
+			skipped := &FileResult{InputPath: inputPath, Preset: preset, Skipped: true, SkipReason: SkipReasonUser}
+			if last >= 0 {
+				attempts[last].result, attempts[last].err = skipped, nil
+			}
+			result, err = skipped, nil
+		}
 	}
 
-	// Get output file size if successful
-	var outputSizeMB float64
-	var spaceSavedMB float64
-	var compressionRatio float64
+	for _, a := range attempts {
+		record := t.analyticsRecord(inputPath, a.preset.Name, a.result, a.err, a.startTime, a.endTime, inputSize)
 
-	if err == nil {
-		preset, exists := t.presets[t.config.Preset]
-		if exists {
-			outputPath := t.pathUtils.GenerateOutputPath(inputPath, t.config.OutputDir, t.config.InputPath, preset)
-			if outputInfo, statErr := os.Stat(outputPath); statErr == nil {
-				outputSizeMB = float64(outputInfo.Size()) / (1024 * 1024)
-				spaceSavedMB = inputSizeMB - outputSizeMB
-				compressionRatio = outputSizeMB / inputSizeMB
+		// Write to CSV if provided
+		if csvWriter != nil {
+			t.csvMu.Lock()
+			writeErr := writeCSVRecord(csvWriter, record)
+			t.csvMu.Unlock()
+			if writeErr != nil {
+				t.log.Warnf("failed to write CSV record: %v", writeErr)
 			}
 		}
+		if t.analyticsJSON != nil {
+			if writeErr := t.analyticsJSON.Write(record); writeErr != nil {
+				t.log.Warnf("failed to write JSON record: %v", writeErr)
+			}
+		}
+	}
+
+	return result, err
+}
+
+// analyticsRecord assembles the analytics of one file encoded with a preset
+func (t *Transcoder) analyticsRecord(inputPath, preset string, result *FileResult, err error, startTime, endTime time.Time, inputSize int64) AnalyticsRecord {
+	record := AnalyticsRecord{
+		Filename:        filepath.Base(inputPath),
+		StartTime:       startTime,
+		EndTime:         endTime,
+		DurationSeconds: endTime.Sub(startTime).Seconds(),
+		SizeBeforeMB:    bytesToMB(inputSize),
+		Preset:          preset,
+		Status:          "success",
+		Platform:        t.systemChecker.GetPlatform().String(),
+		Trimmed:         t.trimming(),
+	}
+	if err != nil {
+		record.Status = "error"
+		record.Error = err.Error()
+	} else if result.SkipReason == SkipReasonNoVideo {
+		record.Status = "skipped_no_video"
+	} else if result.SkipReason == SkipReasonUser {
+		record.Status = "skipped_by_user"
+	} else if result.SkipReason == SkipReasonDryRun {
+		record.Status = "dry_run"
+	} else if result.SkipReason == SkipReasonTargetCodec {
+		record.Status = "skipped_target_codec"
 	}
 
-	// Write to CSV if provided
-	if csvWriter != nil {
-		record := []string{
-			filename,
-			startTime.Format("2006-01-02 15:04:05"),
-			endTime.Format("2006-01-02 15:04:05"),
-			fmt.Sprintf("%.2f", duration),
-			fmt.Sprintf("%.2f", inputSizeMB),
-			fmt.Sprintf("%.2f", outputSizeMB),
-			fmt.Sprintf("%.2f", spaceSavedMB),
-			fmt.Sprintf("%.4f", compressionRatio),
-			t.config.Preset,
-			status,
+	// Get output file size if successful
+	if err == nil {
+		if outputInfo, statErr := os.Stat(result.OutputPath); statErr == nil {
+			record.SizeAfterMB = bytesToMB(outputInfo.Size())
 		}
-		if writeErr := csvWriter.Write(record); writeErr != nil {
-			fmt.Printf("Warning: failed to write CSV record: %v\n", writeErr)
+		record.TargetBitrate = result.TargetBitrate
+		record.BitrateCapped = result.BitrateCapped
+		record.VMAF = result.Quality.VMAF
+		record.SSIM = result.Quality.SSIM
+		if !result.Skipped {
+			record.Encoder = result.Encoder
+			record.EncodingMode = result.EncodingMode
 		}
-		csvWriter.Flush()
 	}
-
-	return err
+	return record
 }
 
-// convertToSoftwarePreset converts hardware preset arguments to software equivalent
+// convertToSoftwarePreset converts hardware preset arguments to a software
+// equivalent that keeps the preset's intent: the same scaling, the preset's
+// target bitrate with its -maxrate/-bufsize caps, and a CRF chosen to land
+// near that bitrate at the preset resolution rather than a fixed default
 func (t *Transcoder) convertToSoftwarePreset(preset Preset) []string {
-	var codec, crf string
-	var presetName = "medium"
-
-	switch preset.Encoder {
-	// NVIDIA NVENC encoders
-	case "h264_nvenc":
-		codec = "libx264"
-		crf = "23"
-	case "hevc_nvenc":
-		codec = "libx265"
-		crf = "26"
-	case "av1_nvenc":
-		// Convert to libx264 with higher quality settings (AV1 fallback to H.264)
-		codec = "libx264"
-		crf = "18"
-		presetName = "slower"
-	// Apple VideoToolbox encoders
-	case "h264_videotoolbox":
-		codec = "libx264"
-		crf = "23"
-	case "hevc_videotoolbox":
-		codec = "libx265"
-		crf = "26"
-	// Software encoders
-	case "libsvtav1":
-		// SVT-AV1 fallback to libx264
-		codec = "libx264"
-		crf = "18"
-		presetName = "slower"
-	default:
-		codec = "libx264"
-		crf = "23"
-	}
+	fallback := softwareFallbackFor(preset.Encoder)
+	codec := fallback.Encoder
 
 	args := []string{
 		"-c:v", codec,
-		"-preset", presetName,
-		"-crf", crf,
-		"-vf", t.extractScaleFilter(preset.Args),
+		"-preset", fallback.Speed,
+		"-crf", strconv.Itoa(softwareCRF(codec, preset)),
+		"-vf", t.extractScaleFilter(preset.Args).String(),
 	}
 
-	// Add bitrate control if specified
+	// Keep the preset's rate control so output sizes stay comparable
 	if preset.Bitrate != "" {
+		maxrate := argValue(preset.Args, "-maxrate")
+		if maxrate == "" {
+			maxrate = preset.Bitrate
+		}
+		bufsize := argValue(preset.Args, "-bufsize")
+		if bufsize == "" {
+			bufsize = maxrate
+		}
 		args = append(args,
 			"-b:v", preset.Bitrate,
-			"-maxrate", preset.Bitrate,
-			"-bufsize", preset.Bitrate,
+			"-maxrate", maxrate,
+			"-bufsize", bufsize,
 		)
 	}
 
 	return args
 }
 
-// extractScaleFilter extracts the scale filter from preset arguments
-func (t *Transcoder) extractScaleFilter(args []string) string {
+// Reference points for softwareCRF: libx264 CRF 23 lands near 0.08 bits per
+// pixel (1080p30 at 5 Mbit/s), each 6 CRF steps roughly halve the bitrate, and
+// libx265 reaches similar quality at half the bitrate with a CRF 5 higher
+const (
+	assumedFrameRate   = 30.0
+	referenceBitsPixel = 0.08
+	referenceCRF       = 23.0
+	x265CRFOffset      = 5.0
+	minSoftwareCRF     = 16
+	maxSoftwareCRF     = 32
+)
+
+// softwareCRF estimates the CRF at which a software encoder produces about
+// the preset's target bitrate at its resolution. Presets without a bitrate or
+// resolution keep the encoder's default quality.
+func softwareCRF(codec string, preset Preset) int {
+	defaultCRF := 23
+	if codec == "libx265" {
+		defaultCRF = 28
+	}
+
+	bitrate, err := parseSIValue(preset.Bitrate)
+	width, height, ok := parseResolution(preset.Resolution)
+	if err != nil || bitrate <= 0 || !ok {
+		return defaultCRF
+	}
+
+	bitsPerPixel := bitrate / (float64(width*height) * assumedFrameRate)
+	crf := 0.0
+	if codec == "libx265" {
+		crf = referenceCRF - 6*math.Log2(2*bitsPerPixel/referenceBitsPixel) + x265CRFOffset
+	} else {
+		crf = referenceCRF - 6*math.Log2(bitsPerPixel/referenceBitsPixel)
+	}
+	return clampInt(int(math.Round(crf)), minSoftwareCRF, maxSoftwareCRF)
+}
+
+// parseResolution parses a WIDTHxHEIGHT resolution
+func parseResolution(resolution string) (width, height int, ok bool) {
+	w, h, found := strings.Cut(resolution, "x")
+	if !found {
+		return 0, 0, false
+	}
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// extractScaleFilter extracts the filter chain, usually just the scaling,
+// from preset arguments
+func (t *Transcoder) extractScaleFilter(args []string) FilterChain {
+	if chain := filterChainOf(args); len(chain) > 0 {
+		return chain
+	}
+	return FilterChain{"scale=-1:-1"} // Default no scaling
+}
+
+// setArgValue replaces the value following a flag, appending the flag if absent
+func setArgValue(args []string, flag, value string) []string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			args[i+1] = value
+			return args
+		}
+	}
+	return append(args, flag, value)
+}
+
+// formatBitrateArg renders bits/s as an ffmpeg bitrate value such as 7500k or 8M
+func formatBitrateArg(bps float64) string {
+	kbps := int64(math.Round(bps / 1000))
+	if kbps%1000 == 0 {
+		return fmt.Sprintf("%dM", kbps/1000)
+	}
+	return fmt.Sprintf("%dk", kbps)
+}
+
+// argValue returns the value following a flag in an argument list, or "" if absent
+func argValue(args []string, flag string) string {
 	for i, arg := range args {
-		if arg == "-vf" && i+1 < len(args) {
+		if arg == flag && i+1 < len(args) {
 			return args[i+1]
 		}
 	}
-	return "scale=-1:-1" // Default no scaling
+	return ""
 }
 
+// DefaultProbeTimeout bounds the input check when --input-probe-timeout is unset
+const DefaultProbeTimeout = 10 * time.Second
+
 // probeInputFile probes the input file to check if it's valid and get basic info
-func (t *Transcoder) probeInputFile(inputPath string) error {
+func (t *Transcoder) probeInputFile(ctx context.Context, inputPath string) error {
 	args := []string{
 		"-hide_banner",
 		"-loglevel", "error",
-		"-i", inputPath,
+	}
+	args = append(args, t.inputArgs(inputPath)...)
+	args = append(args,
 		"-f", "null",
 		"-t", "1", // Only check first second
 		"-",
+	)
+
+	timeout := t.config.ProbeTimeout
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
 	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	cmd := exec.Command("ffmpeg", args...)
+	cmd := t.commandContext(ctx, "ffmpeg", args...)
 	var stderrBuf strings.Builder
 	cmd.Stderr = &stderrBuf
+	// Don't wait on pipes held open by anything the killed process left behind
+	cmd.WaitDelay = time.Second
 
 	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return NewTranscoderError(ErrorTypeInvalidFilePath,
+			fmt.Sprintf("input probe timed out after %s; the file is likely malformed", timeout), ctx.Err())
+	}
 	if err != nil {
 		stderrOutput := stderrBuf.String()
 		return NewTranscoderError(ErrorTypeEncodingFailed,
@@ -441,16 +1891,40 @@ func (t *Transcoder) probeInputFile(inputPath string) error {
 	return nil
 }
 
-// createSafeFallbackArgs creates the simplest possible FFmpeg command that should work
+// createSafeFallbackArgs creates the simplest possible FFmpeg command that
+// should work. It leaves out --ffmpeg-args, which may be what failed.
 func (t *Transcoder) createSafeFallbackArgs(inputPath, outputPath string) []string {
-	return []string{
+	args := []string{
 		"-hide_banner",
 		"-loglevel", "error",
-		"-i", inputPath,
-		"-c:v", "libx264",
-		"-preset", "medium",
-		"-crf", "23",
-		"-c:a", "copy",
-		"-y", outputPath,
 	}
+	args = append(args, t.inputArgs(inputPath)...)
+	if _, ok := t.dvdTitles[inputPath]; ok {
+		args = append(args, dvdMapArgs()...)
+	}
+	if t.config.Container == ContainerWebM {
+		// WebM holds no H.264
+		args = append(args,
+			"-c:v", "libvpx-vp9",
+			"-crf", "32",
+			"-b:v", "0",
+		)
+	} else {
+		args = append(args,
+			"-c:v", "libx264",
+			"-preset", "medium",
+			"-crf", "23",
+		)
+	}
+	// Audio is copied where the container can hold any source audio, and
+	// encoded to the container's codec otherwise
+	audio := "copy"
+	if copyAudio := t.containerFormat().copyAudio; copyAudio != "" {
+		audio = copyAudio
+	}
+	args = append(args, buildAudioArgs(audio, t.audioBitrate(), t.loudnorm(inputPath), nil, false)...)
+	args = append(args, t.metadataArgs()...)
+	args = append(args, t.trimOutputArgs()...)
+	args = append(args, t.muxerArgs(outputPath)...)
+	return append(args, "-y", outputPath)
 }
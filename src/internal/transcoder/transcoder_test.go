@@ -1,7 +1,28 @@
 package transcoder
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 // MockCommandExecutor for testing
@@ -250,3 +271,6224 @@ func TestPathUtils_SanitizeFilename(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatCommandFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		goos     string
+		args     []string
+		expected string
+	}{
+		{
+			name:     "posix plain args",
+			goos:     "linux",
+			args:     []string{"-i", "input.mp4", "-c:v", "libx264"},
+			expected: "ffmpeg -i input.mp4 -c:v libx264",
+		},
+		{
+			name:     "posix path with spaces",
+			goos:     "linux",
+			args:     []string{"-i", "/videos/my movie.mp4"},
+			expected: "ffmpeg -i '/videos/my movie.mp4'",
+		},
+		{
+			name:     "posix single quote",
+			goos:     "darwin",
+			args:     []string{"-i", "it's.mp4"},
+			expected: `ffmpeg -i 'it'\''s.mp4'`,
+		},
+		{
+			name:     "windows path with spaces",
+			goos:     "windows",
+			args:     []string{"-i", `C:\My Videos\clip.mp4`},
+			expected: `ffmpeg -i "C:\My Videos\clip.mp4"`,
+		},
+		{
+			name:     "windows embedded quote",
+			goos:     "windows",
+			args:     []string{"-metadata", `title=a "b"`},
+			expected: `ffmpeg -metadata "title=a \"b\""`,
+		},
+		{
+			name:     "empty argument",
+			goos:     "linux",
+			args:     []string{""},
+			expected: "ffmpeg ''",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatCommandFor(tt.goos, "ffmpeg", tt.args); got != tt.expected {
+				t.Errorf("formatCommandFor() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildFFmpegArgs_ToolMetadata(t *testing.T) {
+	preset := GetPresets()["1080p_h264"]
+
+	hasComment := func(args []string) bool {
+		for i, arg := range args {
+			if arg == "-metadata" && i+1 < len(args) &&
+				strings.HasPrefix(args[i+1], "comment=transcoded by ffmcli v1.2.3 preset=1080p_h264 on ") {
+				return true
+			}
+		}
+		return false
+	}
+
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", ToolVersion: "v1.2.3"})
+	if args := tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, false); !hasComment(args) {
+		t.Errorf("buildFFmpegArgs() missing tool metadata comment: %v", args)
+	}
+
+	tr = New(Config{InputPath: "/in", OutputDir: "/out", ToolVersion: "v1.2.3", NoToolMetadata: true})
+	if args := tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, false); hasComment(args) {
+		t.Errorf("buildFFmpegArgs() included tool metadata with NoToolMetadata set: %v", args)
+	}
+
+	// A comment carried over from the source survives with the provenance appended
+	tr = New(Config{InputPath: "/in", OutputDir: "/out", ToolVersion: "v1.2.3"})
+	tr.prober = NewProber(&MockCommandExecutor{output: `{"format": {"duration": "60", "tags": {"COMMENT": "Family trip 2019"}}, "streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}]}`})
+	comment := argValue(tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, false), "-metadata")
+	if !strings.HasPrefix(comment, "comment=Family trip 2019; transcoded by ffmcli v1.2.3 preset=1080p_h264 on ") {
+		t.Errorf("-metadata = %q, want the source comment kept before the provenance", comment)
+	}
+}
+
+func TestBuildFFmpegArgs_ForceExtension(t *testing.T) {
+	preset := GetPresets()["1080p_h264"]
+	tr := New(Config{InputPath: "/videos", OutputDir: "/out", ForceExtension: ".mp4"})
+
+	output := tr.pathUtils.GenerateOutputPath("/videos/movie.mkv", "/out", "/videos/movie.mkv", preset)
+	if filepath.Base(output) != "movie_1080p_h264.mp4" {
+		t.Fatalf("GenerateOutputPath() = %s, want movie_1080p_h264.mp4", output)
+	}
+	args := tr.buildFFmpegArgs("/videos/movie.mkv", output, preset, false)
+	n := len(args)
+	if n < 4 || args[n-4] != "-f" || args[n-3] != "matroska" || args[n-1] != output {
+		t.Errorf("buildFFmpegArgs() does not force the Matroska muxer before the output: %v", args)
+	}
+	if args := tr.createSafeFallbackArgs("/videos/movie.mkv", output); argValue(args, "-f") != "matroska" {
+		t.Errorf("createSafeFallbackArgs() = %v, want -f matroska", args)
+	}
+
+	// A matching extension leaves the muxer to ffmpeg
+	if args := tr.buildFFmpegArgs("/videos/movie.mkv", "/out/movie_1080p_h264.MKV", preset, false); argValue(args, "-f") != "" {
+		t.Errorf("buildFFmpegArgs() = %v, want no -f for a .mkv output", args)
+	}
+
+	for input, want := range map[string]string{"mp4": ".mp4", ".M4V": ".m4v", " webm ": ".webm"} {
+		if got, err := NormalizeExtension(input); err != nil || got != want {
+			t.Errorf("NormalizeExtension(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", ".", "mp4/x", "tar.gz", "waytoolongext"} {
+		if _, err := NormalizeExtension(input); err == nil {
+			t.Errorf("NormalizeExtension(%q) accepted an invalid extension", input)
+		}
+	}
+}
+
+func TestParseProbeOutput(t *testing.T) {
+	data := []byte(`{
+		"streams": [
+			{"codec_type": "video", "codec_name": "mjpeg", "width": 600, "height": 600, "disposition": {"attached_pic": 1}},
+			{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "avg_frame_rate": "24000/1001"},
+			{"codec_type": "audio", "codec_name": "aac"}
+		],
+		"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "125.500000", "size": "1048576", "bit_rate": "66847"}
+	}`)
+
+	info, err := parseProbeOutput(data)
+	if err != nil {
+		t.Fatalf("parseProbeOutput() error = %v", err)
+	}
+	if info.VideoCodec != "h264" || info.Width != 1920 || info.Height != 1080 {
+		t.Errorf("parseProbeOutput() video = %s %dx%d, want h264 1920x1080", info.VideoCodec, info.Width, info.Height)
+	}
+	if info.AudioCodec != "aac" || !reflect.DeepEqual(info.AudioCodecs, []string{"aac"}) {
+		t.Errorf("parseProbeOutput() audio = %s %v, want aac", info.AudioCodec, info.AudioCodecs)
+	}
+	if info.Duration != 125.5 || info.Size != 1048576 || info.Streams != 3 {
+		t.Errorf("parseProbeOutput() format = %+v", info)
+	}
+
+	rotated, err := parseProbeOutput([]byte(`{"streams": [{"codec_type": "video", "codec_name": "hevc", "width": 1920, "height": 1080, "side_data_list": [{"rotation": -90}]}], "format": {}}`))
+	if err != nil {
+		t.Fatalf("parseProbeOutput() error = %v", err)
+	}
+	if rotated.Rotation != 270 || !rotated.IsPortrait() {
+		t.Errorf("parseProbeOutput() rotation = %d, portrait = %v, want 270 and portrait", rotated.Rotation, rotated.IsPortrait())
+	}
+
+	if _, err := parseProbeOutput([]byte("not json")); err == nil {
+		t.Error("parseProbeOutput() expected error for invalid JSON")
+	}
+}
+
+func TestWriteSidecar(t *testing.T) {
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "movie_1080p_h264.mkv")
+
+	tr := New(Config{InputPath: dir, OutputDir: dir, ToolVersion: "v1.2.3"})
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	result := &FileResult{
+		InputPath:    "/videos/movie.mp4",
+		OutputPath:   outputPath,
+		Preset:       GetPresets()["1080p_h264"],
+		EncodingMode: EncodingModeHardware,
+		StartTime:    start,
+		EndTime:      start.Add(90 * time.Second),
+		SourceProbe:  &ProbeInfo{VideoCodec: "h264", Duration: 60},
+	}
+
+	if err := tr.writeSidecar(result); err != nil {
+		t.Fatalf("writeSidecar() error = %v", err)
+	}
+
+	data, err := os.ReadFile(SidecarPath(outputPath))
+	if err != nil {
+		t.Fatalf("sidecar not written: %v", err)
+	}
+	var sidecar Sidecar
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		t.Fatalf("sidecar is not valid JSON: %v", err)
+	}
+	if sidecar.SourcePath != "/videos/movie.mp4" || sidecar.Preset != "1080p_h264" ||
+		sidecar.ToolVersion != "v1.2.3" || sidecar.DurationSeconds != 90 {
+		t.Errorf("unexpected sidecar contents: %+v", sidecar)
+	}
+	if sidecar.Source == nil || sidecar.Source.VideoCodec != "h264" {
+		t.Errorf("sidecar source probe = %+v, want h264", sidecar.Source)
+	}
+
+	// Discarding the output removes the sidecar too
+	discardOutput(outputPath)
+	if _, err := os.Stat(SidecarPath(outputPath)); !os.IsNotExist(err) {
+		t.Errorf("sidecar still present after discardOutput()")
+	}
+}
+
+func TestBatchProgress(t *testing.T) {
+	files := []string{"short.mp4", "long.mp4", "unknown.mp4"}
+
+	t.Run("duration weighted", func(t *testing.T) {
+		bp := NewBatchProgress(files, map[string]float64{"short.mp4": 30, "long.mp4": 7170})
+		if !bp.ByDuration() {
+			t.Fatal("expected duration-weighted progress")
+		}
+
+		// The unknown file is weighted by the average of the known durations
+		bp.Complete(0)
+		if got, want := bp.Fraction(), 30.0/(30+7170+3600); math.Abs(got-want) > 1e-9 {
+			t.Errorf("Fraction() after short file = %v, want %v", got, want)
+		}
+
+		bp.Update(1, 0.5)
+		if got, want := bp.Fraction(), (30+3585)/(30+7170+3600.0); math.Abs(got-want) > 1e-9 {
+			t.Errorf("Fraction() mid long file = %v, want %v", got, want)
+		}
+
+		bp.Complete(1)
+		bp.Complete(2)
+		if got := bp.Fraction(); math.Abs(got-1) > 1e-9 {
+			t.Errorf("Fraction() when done = %v, want 1", got)
+		}
+	})
+
+	t.Run("count based without durations", func(t *testing.T) {
+		bp := NewBatchProgress(files, nil)
+		if bp.ByDuration() {
+			t.Fatal("expected count-based progress")
+		}
+		bp.Complete(0)
+		if got := bp.Fraction(); math.Abs(got-1.0/3) > 1e-9 {
+			t.Errorf("Fraction() = %v, want 1/3", got)
+		}
+	})
+}
+
+func TestParseFFmpegProgress(t *testing.T) {
+	input := "frame=10\nfps=0.00\nout_time_us=1500000\nspeed=N/A\nprogress=continue\n" +
+		"frame=20\nfps=47.5\nout_time_ms=3000000\nspeed=2.05x\nprogress=end\n"
+
+	var statuses []encodeStatus
+	parseFFmpegProgress(strings.NewReader(input), func(status encodeStatus) {
+		statuses = append(statuses, status)
+	})
+
+	want := []encodeStatus{
+		{Position: 1500 * time.Millisecond, Frame: 10},
+		{Position: 3 * time.Second, Frame: 20, FPS: 47.5, Speed: 2.05},
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("parseFFmpegProgress() = %+v, want %+v", statuses, want)
+	}
+}
+
+func TestEncodeStatus(t *testing.T) {
+	status := encodeStatus{Position: time.Minute, Duration: 5 * time.Minute, Frame: 1440, FPS: 48, Speed: 2}
+	if got := status.Fraction(); got != 0.2 {
+		t.Errorf("Fraction() = %v, want 0.2", got)
+	}
+	if got := status.ETA(); got != 2*time.Minute {
+		t.Errorf("ETA() = %v, want 2m at 2x for 4 minutes left", got)
+	}
+	if got, want := status.String(), "20.0% (frame 1440, 48 fps, 2.00x), ETA 2m0s"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	// Before ffmpeg has a speed estimate there is no ETA, and without a
+	// duration no percentage beyond zero
+	if got := (encodeStatus{Position: time.Minute, Duration: 5 * time.Minute}).String(); got != "20.0%" {
+		t.Errorf("String() without speed = %q, want 20.0%%", got)
+	}
+	if got := (encodeStatus{Position: time.Minute, Speed: 1}).Fraction(); got != 0 {
+		t.Errorf("Fraction() without duration = %v, want 0", got)
+	}
+
+	var out strings.Builder
+	bp := NewBatchProgress([]string{"a.mp4", "b.mp4"}, nil)
+	line := newLiveLine(bp, &out, 0)
+	p := &batchFileProgress{line: line, index: 1, name: "b.mp4"}
+	p.Update(status)
+	if line := out.String(); !strings.HasPrefix(line, "\rProgress: 0/2 files completed (10.0%)") ||
+		!strings.Contains(line, "| b.mp4 20.0% (frame 1440") {
+		t.Errorf("status line = %q", line)
+	}
+	p.Done()
+	if !strings.HasSuffix(out.String(), "\r") {
+		t.Errorf("Done() did not erase the status line: %q", out.String())
+	}
+
+	// Files encoded in parallel share one line, each with its percentage
+	out.Reset()
+	bp = NewBatchProgress([]string{"a.mp4", "b.mp4", "c.mp4"}, nil)
+	line = newLiveLine(bp, &out, 0)
+	a := &batchFileProgress{line: line, index: 0, name: "a.mp4"}
+	b := &batchFileProgress{line: line, index: 1, name: "b.mp4"}
+	b.Update(status)
+	a.Update(encodeStatus{Position: time.Minute, Duration: 2 * time.Minute})
+	draws := strings.Split(out.String(), "\r")
+	if last := strings.TrimSpace(draws[len(draws)-1]); !strings.HasSuffix(last, "| a.mp4 50.0% | b.mp4 20.0%") {
+		t.Errorf("combined status line = %q", last)
+	}
+	a.Done()
+	out.Reset()
+	b.Update(status)
+	if got := strings.TrimSpace(out.String()); strings.Contains(got, "a.mp4") || !strings.Contains(got, "| b.mp4 20.0% (frame 1440") {
+		t.Errorf("status line after a.mp4 finished = %q", got)
+	}
+}
+
+func TestTempManager(t *testing.T) {
+	if err := NewTempManager(filepath.Join(t.TempDir(), "missing")).Validate(); err == nil {
+		t.Error("Validate() expected error for missing directory")
+	}
+
+	dir := t.TempDir()
+	m := NewTempManager(dir)
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	f, err := m.CreateFile("pass-*.log")
+	if err != nil {
+		t.Fatalf("CreateFile() error = %v", err)
+	}
+	f.Close()
+	sub, err := m.CreateDir("stage-*")
+	if err != nil {
+		t.Fatalf("CreateDir() error = %v", err)
+	}
+	if filepath.Dir(f.Name()) != dir || filepath.Dir(sub) != dir {
+		t.Errorf("temp paths %s, %s not created under %s", f.Name(), sub, dir)
+	}
+
+	m.Cleanup()
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("Cleanup() left %d entries behind", len(entries))
+	}
+}
+
+func TestTuneArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoder  string
+		tune     string
+		expected []string
+		wantErr  bool
+	}{
+		{name: "x264 animation", encoder: "libx264", tune: "animation", expected: []string{"-tune", "animation"}},
+		{name: "x265 grain", encoder: "libx265", tune: "grain", expected: []string{"-tune", "grain"}},
+		{name: "x265 film unsupported", encoder: "libx265", tune: "film", wantErr: true},
+		{name: "nvenc hq", encoder: "hevc_nvenc", tune: "hq", expected: []string{"-tune", "hq"}},
+		{name: "nvenc animation unsupported", encoder: "h264_nvenc", tune: "animation", wantErr: true},
+		{name: "svt-av1 grain", encoder: "libsvtav1", tune: "grain", expected: []string{"-svtav1-params", "tune=0:film-grain=8"}},
+		{name: "videotoolbox has no tunes", encoder: "h264_videotoolbox", tune: "film", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := TuneArgs(tt.encoder, tt.tune)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TuneArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(args, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("TuneArgs() = %v, want %v", args, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildFFmpegArgs_Tune(t *testing.T) {
+	preset := GetPresets()["1080p_h264"]
+
+	// Software path encodes with libx264, so the film tune applies
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", Preset: "1080p_h264", NoGPU: true, Tune: "film"})
+	if err := tr.ValidateTune(); err != nil {
+		t.Fatalf("ValidateTune() error = %v", err)
+	}
+	args := tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, false)
+	if argValue(args, "-tune") != "film" {
+		t.Errorf("buildFFmpegArgs() missing -tune film: %v", args)
+	}
+
+	// Preset default applies when no tune is requested
+	preset.Tune = "animation"
+	tr = New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true})
+	args = tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, false)
+	if argValue(args, "-tune") != "animation" {
+		t.Errorf("buildFFmpegArgs() missing preset default tune: %v", args)
+	}
+}
+
+func TestSystemChecker_RequireFilter(t *testing.T) {
+	filterList := `Filters:
+  T.. = Timeline support
+  ------
+ ... loudnorm          A->A       EBU R128 loudness normalization
+ TSC scale             V->V       Scale the input video size.
+ ... tonemap           V->V       Conversion to/from different dynamic ranges.
+`
+	checker := NewSystemChecker(&MockCommandExecutor{output: filterList})
+
+	if available, err := checker.CheckFilterAvailability("scale"); err != nil || !available {
+		t.Errorf("CheckFilterAvailability(scale) = %v, %v; want true, nil", available, err)
+	}
+	if err := checker.RequireFilter("loudnorm", "--normalize-audio"); err != nil {
+		t.Errorf("RequireFilter(loudnorm) error = %v", err)
+	}
+
+	err := checker.RequireFilter("zscale", "HDR tone-mapping")
+	if !IsTranscoderError(err, ErrorTypeFilterNotFound) {
+		t.Fatalf("RequireFilter(zscale) error = %v, want filter_not_found", err)
+	}
+	if !strings.Contains(err.Error(), "--enable-libzimg") {
+		t.Errorf("RequireFilter(zscale) error lacks build hint: %v", err)
+	}
+
+	failing := NewSystemChecker(&MockCommandExecutor{shouldFail: true})
+	if _, err := failing.CheckFilterAvailability("scale"); err == nil {
+		t.Error("CheckFilterAvailability() expected error when ffmpeg fails")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	h264 := &ProbeInfo{VideoCodec: "h264", Bitrate: 12_000_000, Duration: 600, Size: 900_000_000, FormatName: "mov,mp4"}
+	hevc := &ProbeInfo{VideoCodec: "hevc", Bitrate: 4_000_000, Duration: 600, Size: 300_000_000, FormatName: "matroska,webm"}
+
+	tests := []struct {
+		name        string
+		expressions []string
+		info        *ProbeInfo
+		want        bool
+	}{
+		{name: "codec not hevc", expressions: []string{"codec!=hevc"}, info: h264, want: true},
+		{name: "codec alias", expressions: []string{"codec!=h265"}, info: hevc, want: false},
+		{name: "bitrate threshold", expressions: []string{"bitrate>8M"}, info: h264, want: true},
+		{name: "all conditions must hold", expressions: []string{"codec==hevc,bitrate>8M"}, info: hevc, want: false},
+		{name: "any expression may match", expressions: []string{"codec==av1", "bitrate>=4M"}, info: hevc, want: true},
+		{name: "size per minute", expressions: []string{"size_per_min>50M"}, info: h264, want: true},
+		{name: "container", expressions: []string{"container==mp4"}, info: h264, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParsePolicy(tt.expressions)
+			if err != nil {
+				t.Fatalf("ParsePolicy() error = %v", err)
+			}
+			if got := policy.Matches(tt.info); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"fps>30", "codec>h264", "bitrate>fast", "nonsense", ""} {
+		if _, err := ParsePolicy([]string{invalid}); !IsTranscoderError(err, ErrorTypeInvalidPolicy) {
+			t.Errorf("ParsePolicy(%q) error = %v, want invalid_policy", invalid, err)
+		}
+	}
+}
+
+func TestPathUtils_IsSamePath(t *testing.T) {
+	pathUtils := NewPathUtils()
+	dir := t.TempDir()
+
+	input := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(input, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.mkv")
+	if err := os.Symlink(input, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{name: "identical", a: input, b: input, want: true},
+		{name: "unclean path", a: input, b: filepath.Join(dir, "sub", "..", "movie.mkv"), want: true},
+		{name: "symlink to input", a: input, b: link, want: true},
+		{name: "different file", a: input, b: filepath.Join(dir, "movie_1080p_h264.mkv"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pathUtils.IsSamePath(tt.a, tt.b); got != tt.want {
+				t.Errorf("IsSamePath(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUseHardware_SoftwareCodecs(t *testing.T) {
+	presets := GetPresets()
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", SoftwareCodecs: []string{"av1"}})
+
+	if tr.useHardware(presets["1080p_av1"]) {
+		t.Error("useHardware(1080p_av1) = true, want false with --software-codecs av1")
+	}
+	if !tr.useHardware(presets["1080p_h264"]) || !tr.useHardware(presets["1080p_h265"]) {
+		t.Error("useHardware() = false for codecs not listed in --software-codecs")
+	}
+
+	args := tr.buildFFmpegArgs("in.mp4", "out.mkv", presets["1080p_av1"], tr.useHardware(presets["1080p_av1"]))
+	if argValue(args, "-hwaccel") != "" {
+		t.Errorf("buildFFmpegArgs() used hardware acceleration for a software codec: %v", args)
+	}
+
+	tr = New(Config{InputPath: "/in", OutputDir: "/out", SoftwareCodecs: []string{"h265"}})
+	if tr.useHardware(presets["4k_h265"]) {
+		t.Error("useHardware(4k_h265) = true, want false with --software-codecs h265")
+	}
+
+	if !IsSupportedCodec("HEVC") || IsSupportedCodec("vp9") {
+		t.Error("IsSupportedCodec() mismatched known codecs")
+	}
+}
+
+func TestSuggestPreset(t *testing.T) {
+	presets := GetPresets()
+
+	tests := []struct {
+		name       string
+		info       *ProbeInfo
+		wantPreset string
+		wantSaving bool
+	}{
+		{
+			name:       "4k h264 moves to hevc",
+			info:       &ProbeInfo{VideoCodec: "h264", Width: 3840, Height: 2160, Bitrate: 40_000_000, Duration: 600, Size: 3_000_000_000},
+			wantPreset: "4k_h265",
+			wantSaving: true,
+		},
+		{
+			name:       "4k hevc moves to av1",
+			info:       &ProbeInfo{VideoCodec: "hevc", Width: 3840, Height: 2160, Bitrate: 40_000_000, Duration: 600, Size: 3_000_000_000},
+			wantPreset: "4k_av1",
+			wantSaving: true,
+		},
+		{
+			name:       "1080p h264",
+			info:       &ProbeInfo{VideoCodec: "h264", Width: 1920, Height: 1080, Bitrate: 10_000_000, Duration: 600, Size: 750_000_000},
+			wantPreset: "1080p_h265",
+			wantSaving: true,
+		},
+		{
+			name:       "portrait phone video",
+			info:       &ProbeInfo{VideoCodec: "hevc", Width: 1920, Height: 1080, Rotation: 90, Bitrate: 15_000_000, Duration: 60, Size: 112_500_000},
+			wantPreset: "1080p_vertical",
+			wantSaving: true,
+		},
+		{
+			name:       "low resolution source",
+			info:       &ProbeInfo{VideoCodec: "mpeg2video", Width: 720, Height: 480, Bitrate: 1_000_000, Duration: 600, Size: 75_000_000},
+			wantPreset: "720p_av1",
+			wantSaving: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suggestion, err := SuggestPreset(tt.info, presets)
+			if err != nil {
+				t.Fatalf("SuggestPreset() error = %v", err)
+			}
+			if suggestion.Preset != tt.wantPreset {
+				t.Errorf("SuggestPreset() = %s, want %s", suggestion.Preset, tt.wantPreset)
+			}
+			if (suggestion.EstimatedSaved > 0.1) != tt.wantSaving {
+				t.Errorf("SuggestPreset() estimated savings = %.2f, want saving %v", suggestion.EstimatedSaved, tt.wantSaving)
+			}
+			if len(suggestion.Reasons) == 0 {
+				t.Error("SuggestPreset() gave no reasoning")
+			}
+		})
+	}
+
+	if _, err := SuggestPreset(&ProbeInfo{AudioCodec: "aac"}, presets); err == nil {
+		t.Error("SuggestPreset() expected error for audio-only source")
+	}
+}
+
+func TestFindDVDTitles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]int{
+		"VIDEO_TS.VOB": 10,
+		"VTS_01_0.VOB": 10,
+		"VTS_01_1.VOB": 1000,
+		"VTS_01_2.VOB": 1000,
+		"VTS_01_3.VOB": 500,
+		"VTS_02_1.VOB": 50,
+		"VTS_03_1.VOB": 800,
+		"VTS_03_3.VOB": 800,
+	}
+	for name, size := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	titles, warnings, err := NewFileDiscovery().FindDVDTitles(dir, false)
+	if err != nil {
+		t.Fatalf("FindDVDTitles() error = %v", err)
+	}
+	if len(titles) != 3 {
+		t.Fatalf("FindDVDTitles() found %d titles, want 3", len(titles))
+	}
+
+	main := titles[0]
+	if main.Name() != "VTS_01" || len(main.Parts) != 3 || main.Size != 2500 {
+		t.Errorf("main title = %s with %d parts (%d bytes), want VTS_01 with 3 parts (2500 bytes)", main.Name(), len(main.Parts), main.Size)
+	}
+	wantURL := "concat:" + strings.Join([]string{
+		filepath.Join(dir, "VTS_01_1.VOB"), filepath.Join(dir, "VTS_01_2.VOB"), filepath.Join(dir, "VTS_01_3.VOB"),
+	}, "|")
+	if main.ConcatURL() != wantURL {
+		t.Errorf("ConcatURL() = %s, want %s", main.ConcatURL(), wantURL)
+	}
+
+	joined := strings.Join(warnings, "\n")
+	for _, want := range []string{"VIDEO_TS.VOB", "VTS_01_0.VOB", "VTS_02 is much smaller", "VTS_03 in " + dir + " is missing part 2"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("warnings missing %q:\n%s", want, joined)
+		}
+	}
+
+	// DVD titles are read through the concat protocol and keep all streams
+	tr := New(Config{InputPath: dir, OutputDir: t.TempDir(), Preset: "1080p_h264", DVD: true})
+	inputs, err := tr.FindVideoFiles()
+	if err != nil || len(inputs) != 3 {
+		t.Fatalf("FindVideoFiles() = %v, %v", inputs, err)
+	}
+	args := tr.buildFFmpegArgs(inputs[0], "out.mkv", GetPresets()["1080p_h264"], false)
+	if argValue(args, "-i") != wantURL || argValue(args, "-c:s") != "copy" {
+		t.Errorf("buildFFmpegArgs() for DVD title = %v", args)
+	}
+	if size, _ := tr.inputSize(inputs[0]); size != 2500 {
+		t.Errorf("inputSize() = %d, want 2500", size)
+	}
+	if got := filepath.Base(tr.outputSource(inputs[0])); got != "VTS_01.VOB" {
+		t.Errorf("outputSource() = %s, want VTS_01.VOB", got)
+	}
+}
+
+func TestApplyRateFactors(t *testing.T) {
+	preset := GetPresets()["1080p_h264"] // 5M target bitrate
+	original := strings.Join(preset.Args, " ")
+
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", MaxrateFactor: 1.5, BufsizeFactor: 2})
+	args := tr.applyRateFactors(preset.Args)
+
+	if got := argValue(args, "-maxrate"); got != "7500k" {
+		t.Errorf("-maxrate = %s, want 7500k", got)
+	}
+	if got := argValue(args, "-bufsize"); got != "10M" {
+		t.Errorf("-bufsize = %s, want 10M", got)
+	}
+
+	// Existing tokens are replaced, not duplicated
+	count := 0
+	for _, arg := range args {
+		if arg == "-maxrate" || arg == "-bufsize" {
+			count++
+		}
+	}
+	if count != 2 {
+		t.Errorf("expected exactly one -maxrate and one -bufsize, got %d flags: %v", count, args)
+	}
+
+	if strings.Join(preset.Args, " ") != original {
+		t.Error("applyRateFactors() modified the preset arguments")
+	}
+
+	// Software path derives values from the preset bitrate as well
+	software := tr.applyRateFactors(tr.convertToSoftwarePreset(GetPresets()["4k_h265"]))
+	if got := argValue(software, "-maxrate"); got != "30M" {
+		t.Errorf("software -maxrate = %s, want 30M", got)
+	}
+}
+
+func TestReviewFiles(t *testing.T) {
+	files := []string{"a.mp4", "b.mp4", "c.mp4", "d.mp4"}
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", Preset: "1080p_h264", NoProbe: true})
+	tr.prober = NewProber(&MockCommandExecutor{shouldFail: true})
+
+	// Encode a, skip b, change c to 720p_h264 (after a typo) and encode it, then quit before d
+	input := "e\ns\np\nbogus\np\n720p_h264\ne\nq\n"
+	var out strings.Builder
+	approved, err := tr.ReviewFiles(files, strings.NewReader(input), &out)
+	if err != nil {
+		t.Fatalf("ReviewFiles() error = %v", err)
+	}
+
+	if strings.Join(approved, ",") != "a.mp4,c.mp4" {
+		t.Errorf("ReviewFiles() approved = %v, want [a.mp4 c.mp4]", approved)
+	}
+	if got := tr.presetNameFor("c.mp4"); got != "720p_h264" {
+		t.Errorf("preset for c.mp4 = %s, want 720p_h264", got)
+	}
+	if got := tr.presetNameFor("a.mp4"); got != "1080p_h264" {
+		t.Errorf("preset override leaked to a.mp4: %s", got)
+	}
+	if !strings.Contains(out.String(), "Unknown preset 'bogus'") {
+		t.Errorf("expected unknown preset message, got:\n%s", out.String())
+	}
+
+	// Running out of input must not approve anything further
+	if _, err := tr.ReviewFiles(files, strings.NewReader("e\n"), io.Discard); err == nil {
+		t.Error("ReviewFiles() expected error when input ends")
+	}
+}
+
+func TestFindSubtitleFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"movie.mp4", "movie.srt", "movie.de.ass", "movie.director.srt", "movie2.srt", "other.srt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subs := NewFileDiscovery().FindSubtitleFiles(filepath.Join(dir, "movie.mp4"))
+	if len(subs) != 3 {
+		t.Fatalf("FindSubtitleFiles() found %d files, want 3: %v", len(subs), subs)
+	}
+	languages := map[string]string{}
+	for _, sub := range subs {
+		languages[filepath.Base(sub.Path)] = sub.Language
+	}
+	if languages["movie.de.ass"] != "de" || languages["movie.srt"] != "" || languages["movie.director.srt"] != "" {
+		t.Errorf("unexpected language tags: %v", languages)
+	}
+}
+
+func TestSubtitleArgs(t *testing.T) {
+	subs := []SubtitleFile{
+		{Path: "movie.srt"},
+		{Path: "movie.de.ass", Language: "de"},
+	}
+
+	inputs, outputs := subtitleArgs(subs, ".mkv", "eng")
+	if got := strings.Join(inputs, " "); got != "-i movie.srt -i movie.de.ass" {
+		t.Errorf("subtitle inputs = %s", got)
+	}
+	wantOutputs := "-map 0:v:0 -map 0:a? -map 1:s:0 -map 2:s:0 " +
+		"-c:s:0 srt -metadata:s:s:0 language=eng -c:s:1 ass -metadata:s:s:1 language=de"
+	if got := strings.Join(outputs, " "); got != wantOutputs {
+		t.Errorf("subtitle outputs = %s, want %s", got, wantOutputs)
+	}
+
+	_, outputs = subtitleArgs(subs, ".mp4", "")
+	if argValue(outputs, "-c:s:0") != "mov_text" || argValue(outputs, "-c:s:1") != "mov_text" {
+		t.Errorf("mp4 subtitles should use mov_text: %v", outputs)
+	}
+	if argValue(outputs, "-metadata:s:s:0") != "" {
+		t.Errorf("untagged subtitle got a language without a default: %v", outputs)
+	}
+
+	if inputs, outputs := subtitleArgs(nil, ".mkv", "eng"); inputs != nil || outputs != nil {
+		t.Error("subtitleArgs() without subtitles should add nothing")
+	}
+
+	// Missing explicit files are skipped rather than failing the encode
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", SubtitleFiles: []string{"/does/not/exist.srt"}})
+	if subs := tr.subtitlesFor("/in/movie.mp4"); len(subs) != 0 {
+		t.Errorf("subtitlesFor() = %v, want none for missing file", subs)
+	}
+}
+
+func TestReportExisting(t *testing.T) {
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+	for _, name := range []string{"done.mp4", "pending.mp4"} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), make([]byte, 2048), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tr := New(Config{InputPath: sourceDir, OutputDir: outputDir, Preset: "1080p_h264"})
+	output := tr.pathUtils.GenerateOutputPath(filepath.Join(sourceDir, "done.mp4"), outputDir, sourceDir, tr.presets["1080p_h264"])
+	if err := os.WriteFile(output, make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(outputDir, "unrelated.mkv")
+	if err := os.WriteFile(orphan, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := tr.ReportExisting(false)
+	if err != nil {
+		t.Fatalf("ReportExisting() error = %v", err)
+	}
+	if len(report.Records) != 1 || report.Records[0].Filename != "done.mp4" {
+		t.Fatalf("expected one record for done.mp4, got %+v", report.Records)
+	}
+	if ratio := report.Records[0].CompressionRatio(); ratio != 0.5 {
+		t.Errorf("CompressionRatio() = %v, want 0.5", ratio)
+	}
+	if len(report.MissingOutputs) != 1 || filepath.Base(report.MissingOutputs[0]) != "pending.mp4" {
+		t.Errorf("MissingOutputs = %v, want [pending.mp4]", report.MissingOutputs)
+	}
+	if len(report.OrphanOutputs) != 1 || report.OrphanOutputs[0] != orphan {
+		t.Errorf("OrphanOutputs = %v, want [%s]", report.OrphanOutputs, orphan)
+	}
+
+	// Outputs of a --name-template run pair under the same template
+	if err := os.Rename(output, filepath.Join(outputDir, "done-1080p.mkv")); err != nil {
+		t.Fatal(err)
+	}
+	tr = New(Config{InputPath: sourceDir, OutputDir: outputDir, Preset: "1080p_h264", NameTemplate: "{name}-{height}p"})
+	report, err = tr.ReportExisting(false)
+	if err != nil || len(report.Records) != 1 || report.Records[0].Filename != "done.mp4" {
+		t.Errorf("ReportExisting() with a name template = %+v, %v, want done.mp4 paired", report, err)
+	}
+}
+
+func TestOrientScale(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		portrait bool
+		wantVF   string
+	}{
+		{"landscape preset, portrait source", []string{"-c:v", "libx264", "-vf", "scale=1920:1080"}, true, "scale=1080:1920"},
+		{"landscape preset, landscape source", []string{"-vf", "scale=1920:1080"}, false, "scale=1920:1080"},
+		{"portrait preset, landscape source", []string{"-vf", "scale=1080:1920"}, false, "scale=1920:1080"},
+		{"filter chain keeps other filters", []string{"-vf", "yadif,scale=1280:720:flags=lanczos"}, true, "yadif,scale=720:1280:flags=lanczos"},
+		{"automatic dimensions untouched", []string{"-vf", "scale=-1:-1"}, true, "scale=-1:-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := filterChainOf(tt.args)
+			original := slices.Clone(chain)
+			if got := orientScale(chain, tt.portrait).String(); got != tt.wantVF {
+				t.Errorf("orientScale() = %s, want %s", got, tt.wantVF)
+			}
+			if !slices.Equal(chain, original) {
+				t.Errorf("orientScale() modified its input: %v", chain)
+			}
+		})
+	}
+}
+
+func TestProcessFilesWithProgress_MaxRuntime(t *testing.T) {
+	dir := t.TempDir()
+	tr := New(Config{InputPath: dir, OutputDir: dir, Preset: "1080p_h264", NoProbe: true, MaxRuntime: time.Nanosecond})
+
+	// Both inputs are missing, so processing either one would report an error
+	files := []string{filepath.Join(dir, "a.mp4"), filepath.Join(dir, "b.mp4")}
+	if _, err := tr.ProcessFilesWithProgress(context.Background(), files, nil); err != nil {
+		t.Errorf("ProcessFilesWithProgress() error = %v, want no files processed", err)
+	}
+}
+
+func TestParseFrameRate(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "25", want: "25"},
+		{input: "60", want: "60"},
+		{input: "29.97", want: "30000/1001"},
+		{input: "23.976", want: "24000/1001"},
+		{input: "23.98", want: "24000/1001"},
+		{input: "59.94", want: "60000/1001"},
+		{input: "12.5", want: "25/2"},
+		{input: "30.0", want: "30"},
+		{input: "30000/1001", want: "30000/1001"},
+		{input: "60/2", want: "30"},
+		{input: "ntsc", want: "30000/1001"},
+		{input: "PAL", want: "25"},
+		{input: "film", want: "24"},
+		{input: "ntsc-film", want: "24000/1001"},
+		{input: "", wantErr: true},
+		{input: "fast", wantErr: true},
+		{input: "0", wantErr: true},
+		{input: "-25", wantErr: true},
+		{input: "30/0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseFrameRate(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFrameRate(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFrameRate(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSampleArgs(t *testing.T) {
+	args := []string{"-hide_banner", "-i", "in.mp4", "-i", "in.srt", "-c:v", "libx264", "-y", "out.mkv"}
+
+	got := sampleArgs(args, 90*time.Second, 2500*time.Millisecond)
+	want := []string{"-hide_banner", "-ss", "90", "-i", "in.mp4", "-i", "in.srt", "-c:v", "libx264", "-t", "2.5", "-y", "out.mkv"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sampleArgs() = %v, want %v", got, want)
+	}
+
+	got = sampleArgs(args, 0, 10*time.Second)
+	if containsArg(got, "-ss") || argValue(got, "-t") != "10" {
+		t.Errorf("sampleArgs() without start = %v", got)
+	}
+
+	// A --start trim moves the window on and its -t is replaced
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", TrimStart: 60 * time.Second, TrimDuration: 10 * time.Minute})
+	trimmed := append(tr.seekInputs([]string{"-hide_banner", "-i", "in.mp4", "-i", "in.srt", "-c:v", "libx264"}), tr.trimOutputArgs()...)
+	trimmed = append(trimmed, "-y", "out.mkv")
+	got = sampleArgs(trimmed, 90*time.Second, 2500*time.Millisecond)
+	want = []string{"-hide_banner", "-ss", "150", "-i", "in.mp4", "-ss", "150", "-i", "in.srt", "-c:v", "libx264", "-t", "2.5", "-y", "out.mkv"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sampleArgs() of trimmed args = %v, want %v", got, want)
+	}
+}
+
+func TestPreviewPath(t *testing.T) {
+	dir := t.TempDir()
+	tr := New(Config{InputPath: "/videos/movie.mp4", OutputDir: dir, Preset: "1080p_h264", SkipValidation: true})
+
+	got, err := tr.PreviewPath("/videos/movie.mp4", dir)
+	if err != nil {
+		t.Fatalf("PreviewPath() error = %v", err)
+	}
+	if filepath.Dir(got) != dir || !strings.HasSuffix(got, "_preview"+filepath.Ext(got)) {
+		t.Errorf("PreviewPath() = %s, want a _preview file in %s", got, dir)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "encoded", "movie.mkv")
+	writeFixture(t, output, []byte("abc"))
+
+	manifestPath := filepath.Join(dir, "manifest.sha256")
+	manifest, err := OpenManifest(manifestPath, "sha256")
+	if err != nil {
+		t.Fatalf("OpenManifest() error = %v", err)
+	}
+	if err := manifest.Add(output); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	manifest.Close()
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad  encoded/movie.mkv\n"
+	if string(data) != want {
+		t.Errorf("manifest = %q, want %q", data, want)
+	}
+
+	if got := manifestLine("00", "a\\b"); got != "\\00  a\\\\b\n" {
+		t.Errorf("manifestLine() = %q, want escaped name", got)
+	}
+	if _, err := OpenManifest(manifestPath, "md5"); !IsTranscoderError(err, ErrorTypeInvalidOption) {
+		t.Errorf("OpenManifest() with md5 error = %v, want an invalid option", err)
+	}
+
+	// BLAKE3 lines match b3sum
+	b3Path := filepath.Join(dir, "manifest.b3")
+	manifest, err = OpenManifest(b3Path, "BLAKE3")
+	if err != nil {
+		t.Fatalf("OpenManifest() blake3 error = %v", err)
+	}
+	if err := manifest.Add(output); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	manifest.Close()
+	want = "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85  encoded/movie.mkv\n"
+	if data, _ := os.ReadFile(b3Path); string(data) != want {
+		t.Errorf("blake3 manifest = %q, want %q", data, want)
+	}
+}
+
+// scriptedExecutor returns canned output per command; commands without an
+// entry fail as if not installed
+type scriptedExecutor struct {
+	outputs map[string]string
+}
+
+func (s *scriptedExecutor) Execute(name string, args ...string) ([]byte, error) {
+	output, ok := s.outputs[name]
+	if !ok {
+		return nil, NewTranscoderError(ErrorTypeFFmpegNotFound, name+" not found", nil)
+	}
+	return []byte(output), nil
+}
+
+func (s *scriptedExecutor) Run(name string, args ...string) error {
+	_, err := s.Execute(name, args...)
+	return err
+}
+
+func TestSystemChecker_DetectPlatform(t *testing.T) {
+	const nvencEncoders = " V....D h264_nvenc           NVIDIA NVENC H.264 encoder (codec h264)\n"
+	const softwareEncoders = " V....D libx264              libx264 H.264 / AVC (codec h264)\n"
+	const vaapiEncoders = " V....D h264_vaapi           H.264/AVC (VAAPI) (codec h264)\n V....D hevc_vaapi           H.265/HEVC (VAAPI) (codec hevc)\n"
+	const qsvEncoders = " V..... h264_qsv             H.264 / AVC (Intel Quick Sync Video acceleration) (codec h264)\n V..... hevc_qsv             HEVC (Intel Quick Sync Video acceleration) (codec hevc)\n"
+
+	tests := []struct {
+		name        string
+		goos        string
+		goarch      string
+		outputs     map[string]string
+		renderNodes map[string][]string // By PCI vendor
+		want        Platform
+	}{
+		{
+			name:   "apple silicon",
+			goos:   "darwin",
+			goarch: "arm64",
+			want:   PlatformAppleSilicon,
+		},
+		{
+			name:   "nvidia gpu with nvenc",
+			goos:   "linux",
+			goarch: "amd64",
+			outputs: map[string]string{
+				"nvidia-smi": "GPU 0: NVIDIA GeForce RTX 3080 (UUID: GPU-1234)\n",
+				"ffmpeg":     nvencEncoders,
+			},
+			want: PlatformNVIDIA,
+		},
+		{
+			name:   "nvidia gpu but ffmpeg without nvenc",
+			goos:   "linux",
+			goarch: "amd64",
+			outputs: map[string]string{
+				"nvidia-smi": "GPU 0: NVIDIA GeForce RTX 3080 (UUID: GPU-1234)\n",
+				"ffmpeg":     softwareEncoders,
+			},
+			want: PlatformSoftware,
+		},
+		{
+			name:    "no nvidia driver",
+			goos:    "linux",
+			goarch:  "amd64",
+			outputs: map[string]string{"ffmpeg": nvencEncoders},
+			want:    PlatformSoftware,
+		},
+		{
+			name:    "intel mac",
+			goos:    "darwin",
+			goarch:  "amd64",
+			outputs: map[string]string{"ffmpeg": softwareEncoders},
+			want:    PlatformSoftware,
+		},
+		{
+			name:        "intel gpu with quick sync",
+			goos:        "linux",
+			goarch:      "amd64",
+			outputs:     map[string]string{"ffmpeg": qsvEncoders},
+			renderNodes: map[string][]string{intelPCIVendor: {"/dev/dri/renderD128"}},
+			want:        PlatformIntelQSV,
+		},
+		{
+			name:        "intel gpu but ffmpeg without quick sync",
+			goos:        "linux",
+			goarch:      "amd64",
+			outputs:     map[string]string{"ffmpeg": softwareEncoders},
+			renderNodes: map[string][]string{intelPCIVendor: {"/dev/dri/renderD128"}},
+			want:        PlatformSoftware,
+		},
+		{
+			name:    "quick sync encoders without an intel gpu",
+			goos:    "linux",
+			goarch:  "amd64",
+			outputs: map[string]string{"ffmpeg": qsvEncoders},
+			want:    PlatformSoftware,
+		},
+		{
+			name:    "quick sync on windows",
+			goos:    "windows",
+			goarch:  "amd64",
+			outputs: map[string]string{"ffmpeg": qsvEncoders},
+			want:    PlatformIntelQSV,
+		},
+		{
+			name:   "nvidia preferred over quick sync",
+			goos:   "linux",
+			goarch: "amd64",
+			outputs: map[string]string{
+				"nvidia-smi": "GPU 0: NVIDIA GeForce RTX 3080 (UUID: GPU-1234)\n",
+				"ffmpeg":     nvencEncoders + qsvEncoders,
+			},
+			renderNodes: map[string][]string{intelPCIVendor: {"/dev/dri/renderD128"}},
+			want:        PlatformNVIDIA,
+		},
+		{
+			name:        "amd gpu with vaapi",
+			goos:        "linux",
+			goarch:      "amd64",
+			outputs:     map[string]string{"ffmpeg": vaapiEncoders},
+			renderNodes: map[string][]string{amdPCIVendor: {"/dev/dri/renderD128"}},
+			want:        PlatformAMD,
+		},
+		{
+			name:        "vaapi encoders on an intel gpu",
+			goos:        "linux",
+			goarch:      "amd64",
+			outputs:     map[string]string{"ffmpeg": vaapiEncoders},
+			renderNodes: map[string][]string{intelPCIVendor: {"/dev/dri/renderD128"}},
+			want:        PlatformSoftware,
+		},
+		{
+			name:        "quick sync preferred over amd",
+			goos:        "linux",
+			goarch:      "amd64",
+			outputs:     map[string]string{"ffmpeg": qsvEncoders + vaapiEncoders},
+			renderNodes: map[string][]string{intelPCIVendor: {"/dev/dri/renderD128"}, amdPCIVendor: {"/dev/dri/renderD129"}},
+			want:        PlatformIntelQSV,
+		},
+		{
+			name:    "amd gpu with amf on windows",
+			goos:    "windows",
+			goarch:  "amd64",
+			outputs: map[string]string{"ffmpeg": " V....D h264_amf             AMD AMF H.264 Encoder (codec h264)\n V....D hevc_amf             AMD AMF HEVC encoder (codec hevc)\n"},
+			want:    PlatformAMD,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := tt.renderNodes
+			checker := &SystemChecker{executor: &scriptedExecutor{outputs: tt.outputs}, renderNodes: func(vendor string) []string { return nodes[vendor] }}
+			if got := checker.detectPlatform(tt.goos, tt.goarch); got != tt.want {
+				t.Errorf("detectPlatform() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemChecker_GPUCheckDowngradesPlatform(t *testing.T) {
+	executor := &scriptedExecutor{outputs: map[string]string{
+		"nvidia-smi": "GPU 0: NVIDIA GeForce RTX 3080\n",
+		"ffmpeg":     " V....D hevc_nvenc           NVIDIA NVENC hevc encoder\n",
+	}}
+	checker := &SystemChecker{executor: executor}
+	checker.platform = checker.detectPlatform("linux", "amd64")
+	if checker.GetPlatform() != PlatformNVIDIA {
+		t.Fatalf("GetPlatform() = %v before check, want NVIDIA", checker.GetPlatform())
+	}
+
+	// The driver disappearing later still falls back to software
+	delete(executor.outputs, "nvidia-smi")
+	if err := checker.CheckGPUAvailability(0, false); err == nil {
+		t.Fatal("CheckGPUAvailability() expected error without nvidia-smi")
+	}
+	if checker.GetPlatform() != PlatformSoftware {
+		t.Errorf("GetPlatform() = %v after failed check, want software", checker.GetPlatform())
+	}
+}
+
+func TestSystemChecker_EncoderStatuses(t *testing.T) {
+	executor := &scriptedExecutor{outputs: map[string]string{
+		"ffmpeg": " V....D h264_nvenc           NVIDIA NVENC H.264 encoder\n V....D libx264              libx264 H.264 / AVC\n",
+	}}
+	checker := &SystemChecker{executor: executor, platform: PlatformNVIDIA}
+
+	statuses, err := checker.EncoderStatuses(true)
+	if err != nil {
+		t.Fatalf("EncoderStatuses() error = %v", err)
+	}
+	if len(statuses) != len(knownEncoders) {
+		t.Fatalf("EncoderStatuses() returned %d entries, want %d", len(statuses), len(knownEncoders))
+	}
+
+	byName := make(map[string]EncoderStatus)
+	for _, status := range statuses {
+		byName[status.Encoder] = status
+	}
+	if s := byName["h264_nvenc"]; !s.Available || s.SmokeTest != SmokeTestPassed || s.Platform != "NVIDIA" {
+		t.Errorf("h264_nvenc status = %+v", s)
+	}
+	if s := byName["hevc_videotoolbox"]; s.Available || s.SmokeTest != SmokeTestSkipped {
+		t.Errorf("hevc_videotoolbox status = %+v", s)
+	}
+
+	statuses, _ = checker.EncoderStatuses(false)
+	for _, status := range statuses {
+		if status.SmokeTest != SmokeTestSkipped {
+			t.Errorf("%s smoke test = %s without smoke testing", status.Encoder, status.SmokeTest)
+		}
+	}
+}
+
+func TestProcessFilesWithProgress_InputLost(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "a.mp4"), filepath.Join(dir, "b.mp4")}
+
+	// A missing file under a reachable root is an ordinary per-file error
+	tr := New(Config{InputPath: dir, OutputDir: dir, Preset: "1080p_h264", NoProbe: true})
+	_, err := tr.ProcessFilesWithProgress(context.Background(), files, nil)
+	if err == nil || strings.Contains(err.Error(), "no longer accessible") {
+		t.Errorf("ProcessFilesWithProgress() error = %v, want per-file errors", err)
+	}
+
+	// An unreachable root aborts the batch with a single clear error
+	gone := filepath.Join(dir, "unmounted")
+	tr = New(Config{InputPath: gone, OutputDir: dir, Preset: "1080p_h264", NoProbe: true})
+	_, err = tr.ProcessFilesWithProgress(context.Background(), files, nil)
+	var transcoderErr *TranscoderError
+	if !errors.As(err, &transcoderErr) || !strings.Contains(err.Error(), "no longer accessible") {
+		t.Errorf("ProcessFilesWithProgress() error = %v, want input source error", err)
+	}
+}
+
+func TestVideoToolboxQualityTranslation(t *testing.T) {
+	tests := []struct {
+		crf     int
+		quality int
+	}{
+		{0, 100},
+		{18, 75},
+		{23, 65},
+		{28, 55},
+		{51, 1},
+	}
+	for _, tt := range tests {
+		if got := CRFToVideoToolboxQuality(tt.crf); got != tt.quality {
+			t.Errorf("CRFToVideoToolboxQuality(%d) = %d, want %d", tt.crf, got, tt.quality)
+		}
+		if got := VideoToolboxQualityToCRF(tt.quality); got != tt.crf {
+			t.Errorf("VideoToolboxQualityToCRF(%d) = %d, want %d", tt.quality, got, tt.crf)
+		}
+	}
+	if got := CRFToVideoToolboxQuality(20); got <= 65 || got >= 75 {
+		t.Errorf("CRFToVideoToolboxQuality(20) = %d, want between 65 and 75", got)
+	}
+}
+
+func TestApplyQuality(t *testing.T) {
+	crf := 20
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", CRF: &crf})
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantFlag string
+		wantVal  string
+		dropped  []string
+	}{
+		{"videotoolbox", []string{"-c:v", "h264_videotoolbox", "-q:v", "65", "-b:v", "5M"}, "-q:v", "71", nil},
+		{"nvenc", []string{"-c:v", "hevc_nvenc", "-preset", "p7", "-crf", "26"}, "-cq", "20", []string{"-crf"}},
+		{"x264", []string{"-c:v", "libx264", "-crf", "23"}, "-crf", "20", nil},
+		{"svt-av1", []string{"-c:v", "libsvtav1", "-crf", "28"}, "-crf", "20", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tr.applyQuality(tt.args)
+			if v := argValue(got, tt.wantFlag); v != tt.wantVal {
+				t.Errorf("applyQuality() %s = %q, want %q (args %v)", tt.wantFlag, v, tt.wantVal, got)
+			}
+			for _, flag := range tt.dropped {
+				if containsArg(got, flag) {
+					t.Errorf("applyQuality() kept %s: %v", flag, got)
+				}
+			}
+		})
+	}
+
+	if got := New(Config{InputPath: "in.mp4", OutputDir: "out"}).applyQuality([]string{"-crf", "23"}); argValue(got, "-crf") != "23" {
+		t.Errorf("applyQuality() without --crf changed args: %v", got)
+	}
+}
+
+func TestPathUtils_Suffix(t *testing.T) {
+	preset := GetPresets()["1080p_h265"]
+	p := NewPathUtils()
+
+	if got := p.SetSuffix("crf 20"); got != "crf_20" {
+		t.Errorf("SetSuffix() = %q, want crf_20", got)
+	}
+	out := p.GenerateOutputPath("/videos/movie.mp4", "/out", "/videos/movie.mp4", preset)
+	if filepath.Base(out) != "movie_1080p_h265_crf_20.mkv" {
+		t.Errorf("GenerateOutputPath() = %s, want movie_1080p_h265_crf_20.mkv", out)
+	}
+
+	if got := p.SanitizeSuffix(`a:b*c/d`); got != "abc_d" {
+		t.Errorf("SanitizeSuffix() = %q, want abc_d", got)
+	}
+	if got := p.SanitizeSuffix("???"); got != "" {
+		t.Errorf("SanitizeSuffix() = %q, want empty", got)
+	}
+
+	// A long suffix on a long name must still fit in a single path component
+	p.SetSuffix(strings.Repeat("é", 100))
+	if len(p.suffix) > maxSuffixLength || !utf8.ValidString(p.suffix) {
+		t.Errorf("suffix not capped to valid UTF-8: %q (%d bytes)", p.suffix, len(p.suffix))
+	}
+	out = p.GenerateOutputPath("/videos/"+strings.Repeat("x", 300)+".mp4", "/out", "/videos", preset)
+	if n := len(filepath.Base(out)); n > 255 {
+		t.Errorf("GenerateOutputPath() name is %d bytes, want at most 255", n)
+	}
+}
+
+func TestConvertToSoftwarePreset_PreservesIntent(t *testing.T) {
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out"})
+
+	for name, preset := range GetPresets() {
+		t.Run(name, func(t *testing.T) {
+			args := tr.convertToSoftwarePreset(preset)
+
+			if got, want := argValue(args, "-vf"), argValue(preset.Args, "-vf"); got != want {
+				t.Errorf("-vf = %s, want preset scaling %s", got, want)
+			}
+			if got := argValue(args, "-b:v"); got != preset.Bitrate {
+				t.Errorf("-b:v = %s, want preset bitrate %s", got, preset.Bitrate)
+			}
+			for _, flag := range []string{"-maxrate", "-bufsize"} {
+				if got, want := argValue(args, flag), argValue(preset.Args, flag); got != want {
+					t.Errorf("%s = %s, want preset value %s", flag, got, want)
+				}
+			}
+			crf, err := strconv.Atoi(argValue(args, "-crf"))
+			if err != nil || crf < minSoftwareCRF || crf > maxSoftwareCRF {
+				t.Errorf("-crf = %s, want a value in [%d, %d]", argValue(args, "-crf"), minSoftwareCRF, maxSoftwareCRF)
+			}
+		})
+	}
+}
+
+func TestSoftwareCRF(t *testing.T) {
+	tests := []struct {
+		name   string
+		codec  string
+		preset Preset
+		want   int
+	}{
+		{"1080p h264 at 5M is the reference point", "libx264", Preset{Resolution: "1920x1080", Bitrate: "5M"}, 23},
+		{"same bitrate at 720p allows better quality", "libx264", Preset{Resolution: "1280x720", Bitrate: "5M"}, 16},
+		{"halving the bitrate raises CRF by 6", "libx264", Preset{Resolution: "1920x1080", Bitrate: "2.5M"}, 29},
+		{"hevc needs half the bits", "libx265", Preset{Resolution: "1920x1080", Bitrate: "2.5M"}, 28},
+		{"no bitrate keeps default", "libx265", Preset{Resolution: "1920x1080"}, 28},
+		{"very low bitrate is clamped", "libx264", Preset{Resolution: "3840x2160", Bitrate: "1M"}, maxSoftwareCRF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := softwareCRF(tt.codec, tt.preset); got != tt.want {
+				t.Errorf("softwareCRF() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeByCodec(t *testing.T) {
+	const gib = 1 << 30
+	results := []*FileResult{
+		{SourceCodec: "h264", InputSize: 4 * gib, OutputSize: 2 * gib},
+		{SourceCodec: "H264", InputSize: 2 * gib, OutputSize: 1 * gib},
+		{SourceCodec: "mpeg2video", InputSize: 8 * gib, OutputSize: 2 * gib},
+		{SourceCodec: "", InputSize: 1 * gib, OutputSize: 1 * gib},
+		{SourceCodec: "h264", InputSize: 5 * gib, Skipped: true},
+		nil,
+	}
+
+	got := SummarizeByCodec(results)
+	want := []CodecSummary{
+		{Codec: "mpeg2video", Files: 1, SizeBefore: 8 * gib, SizeAfter: 2 * gib},
+		{Codec: "h264", Files: 2, SizeBefore: 6 * gib, SizeAfter: 3 * gib},
+		{Codec: "unknown", Files: 1, SizeBefore: 1 * gib, SizeAfter: 1 * gib},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SummarizeByCodec() = %+v, want %+v", got, want)
+	}
+
+	var out strings.Builder
+	printCodecSummary(&out, got, RatioStyleSaved)
+	if !strings.Contains(out.String(), "H.264") || !strings.Contains(out.String(), "saved 75.0% (6.0 GiB)") {
+		t.Errorf("printCodecSummary() output:\n%s", out.String())
+	}
+}
+
+func TestProbeInputFile_Timeout(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not available")
+	}
+
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", ProbeTimeout: 50 * time.Millisecond})
+	// Stand in for an ffmpeg that hangs on a malformed file
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, sleep, "10")
+	}
+
+	start := time.Now()
+	err = tr.probeInputFile(context.Background(), "in.mp4")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("probeInputFile() took %s, timeout not enforced", elapsed)
+	}
+	var transcoderErr *TranscoderError
+	if !errors.As(err, &transcoderErr) || transcoderErr.Type != ErrorTypeInvalidFilePath ||
+		!strings.Contains(err.Error(), "timed out") {
+		t.Errorf("probeInputFile() error = %v, want invalid file timeout", err)
+	}
+}
+
+func TestFindVideoFilesInRoots(t *testing.T) {
+	dirA := t.TempDir()
+	dirB := t.TempDir()
+	for _, path := range []string{
+		filepath.Join(dirA, "a.mp4"),
+		filepath.Join(dirA, "sub", "nested.mkv"),
+		filepath.Join(dirB, "b.mov"),
+		filepath.Join(dirB, "notes.txt"),
+	} {
+		writeFixture(t, path, []byte("x"))
+	}
+
+	// dirA/sub overlaps dirA and the single file overlaps dirB
+	roots := []string{dirA, filepath.Join(dirA, "sub"), dirB, filepath.Join(dirB, "b.mov")}
+	sources, err := NewFileDiscovery().FindVideoFilesInRoots(roots, true)
+	if err != nil {
+		t.Fatalf("FindVideoFilesInRoots() error = %v", err)
+	}
+
+	want := map[string]string{
+		filepath.Join(dirA, "a.mp4"):             dirA,
+		filepath.Join(dirA, "sub", "nested.mkv"): dirA,
+		filepath.Join(dirB, "b.mov"):             dirB,
+	}
+	if len(sources) != len(want) {
+		t.Fatalf("FindVideoFilesInRoots() = %+v, want %d files", sources, len(want))
+	}
+	for _, source := range sources {
+		if root, ok := want[source.Path]; !ok || root != source.Root {
+			t.Errorf("unexpected source %+v", source)
+		}
+	}
+}
+
+func TestMultipleInputRoots_PreserveStructure(t *testing.T) {
+	dirA := t.TempDir()
+	dirB := t.TempDir()
+	single := filepath.Join(t.TempDir(), "clip.mkv")
+	for _, path := range []string{
+		filepath.Join(dirA, "season1", "ep1.mp4"),
+		filepath.Join(dirB, "trailers", "t1.mp4"),
+		single,
+	} {
+		writeFixture(t, path, []byte("x"))
+	}
+
+	outputDir := t.TempDir()
+	tr := New(Config{
+		InputPath:  dirA,
+		InputPaths: []string{dirA, dirB, single},
+		OutputDir:  outputDir,
+		Preset:     "1080p_h264",
+		Recursive:  true,
+	})
+	files, err := tr.FindVideoFiles()
+	if err != nil || len(files) != 3 {
+		t.Fatalf("FindVideoFiles() = %v, %v", files, err)
+	}
+
+	preset := tr.presets["1080p_h264"]
+	want := map[string]string{
+		"ep1.mp4":  filepath.Join(outputDir, "season1"),
+		"t1.mp4":   filepath.Join(outputDir, "trailers"),
+		"clip.mkv": outputDir,
+	}
+	for _, file := range files {
+		output := tr.pathUtils.GenerateOutputPath(file, outputDir, tr.inputBase(file), preset)
+		if dir := filepath.Dir(output); dir != want[filepath.Base(file)] {
+			t.Errorf("output for %s in %s, want %s", file, dir, want[filepath.Base(file)])
+		}
+	}
+}
+
+func TestRunControl_PauseResumeQuit(t *testing.T) {
+	var out strings.Builder
+	control := NewRunControl(&out)
+
+	control.HandleKey('p')
+	resumed := make(chan bool)
+	go func() { resumed <- control.Wait() }()
+	select {
+	case <-resumed:
+		t.Fatal("Wait() returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	control.HandleKey('r')
+	if !<-resumed {
+		t.Fatal("Wait() = false after resume, want true")
+	}
+
+	control.HandleKey('p')
+	go func() { resumed <- control.Wait() }()
+	control.HandleKey('q')
+	if <-resumed {
+		t.Error("Wait() = true after quit, want false")
+	}
+	for _, state := range []string{"[paused]", "[running]", "[quitting]"} {
+		if !strings.Contains(out.String(), state) {
+			t.Errorf("output %q does not mention %s", out.String(), state)
+		}
+	}
+}
+
+func TestProcessFilesWithProgress_QuitKey(t *testing.T) {
+	dir := t.TempDir()
+	tr := New(Config{InputPath: dir, OutputDir: dir, Preset: "1080p_h264", NoProbe: true})
+	control := NewRunControl(io.Discard)
+	control.Listen(strings.NewReader("q"))
+	tr.SetRunControl(control)
+
+	if _, err := tr.ProcessFilesWithProgress(context.Background(), []string{filepath.Join(dir, "a.mp4")}, nil); err != nil {
+		t.Fatalf("ProcessFilesWithProgress() error = %v, want nil after quit", err)
+	}
+}
+
+func TestComposePreset(t *testing.T) {
+	tests := []struct {
+		codec, resolution string
+		platform          Platform
+		wantName          string
+		wantEncoder       string
+		wantBitrate       string
+		wantScale         string
+	}{
+		{"av1", "1080p", PlatformNVIDIA, "1080p_av1", "av1_nvenc", "4M", "scale=1920:1080"},
+		{"h265", "720p", PlatformNVIDIA, "720p_h265", "hevc_nvenc", "2M", "scale=1280:720"},
+		{"x264", "2160p", PlatformNVIDIA, "4k_h264", "h264_nvenc", "25M", "scale=3840:2160"},
+		{"hevc", "4k", PlatformAppleSilicon, "4k_h265", "hevc_videotoolbox", "20M", "scale=3840:2160"},
+		{"av1", "720p", PlatformAppleSilicon, "720p_av1", "libsvtav1", "2M", "scale=1280:720"},
+		{"h264", "1080p", PlatformAppleSilicon, "1080p_h264", "h264_videotoolbox", "5M", "scale=1920:1080"},
+		{"av1", "4k", PlatformIntelQSV, "4k_av1", "av1_qsv", "15M", "scale=3840:2160"},
+		{"h265", "1080p", PlatformIntelQSV, "1080p_h265", "hevc_qsv", "3M", "scale=1920:1080"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wantName+"/"+tt.platform.String(), func(t *testing.T) {
+			preset, err := ComposePreset(tt.codec, tt.resolution, tt.platform)
+			if err != nil {
+				t.Fatalf("ComposePreset() error = %v", err)
+			}
+			if preset.Name != tt.wantName || preset.Encoder != tt.wantEncoder || preset.Platform != tt.platform {
+				t.Errorf("ComposePreset() = %s/%s/%v, want %s/%s/%v",
+					preset.Name, preset.Encoder, preset.Platform, tt.wantName, tt.wantEncoder, tt.platform)
+			}
+			if got := argValue(preset.Args, "-c:v"); got != tt.wantEncoder {
+				t.Errorf("-c:v = %s, want %s", got, tt.wantEncoder)
+			}
+			if got := argValue(preset.Args, "-b:v"); got != tt.wantBitrate {
+				t.Errorf("-b:v = %s, want %s", got, tt.wantBitrate)
+			}
+			if got := argValue(preset.Args, "-vf"); got != tt.wantScale {
+				t.Errorf("-vf = %s, want %s", got, tt.wantScale)
+			}
+		})
+	}
+
+	// Combinations matching a fixed preset reproduce it
+	fixed := GetPresetsForPlatform(presetPlatform())["1080p_h265"]
+	composed, _ := ComposePreset("h265", "1080p", presetPlatform())
+	if !reflect.DeepEqual(composed.Args, fixed.Args) {
+		t.Errorf("composed 1080p_h265 args = %v, want %v", composed.Args, fixed.Args)
+	}
+	fixed = GetPresetsForPlatform(PlatformIntelQSV)["1080p_h265"]
+	composed, _ = ComposePreset("h265", "1080p", PlatformIntelQSV)
+	if !reflect.DeepEqual(composed.Args, fixed.Args) {
+		t.Errorf("composed Quick Sync 1080p_h265 args = %v, want %v", composed.Args, fixed.Args)
+	}
+
+	if _, err := ComposePreset("vp9", "1080p", PlatformNVIDIA); !IsTranscoderError(err, ErrorTypeInvalidPreset) {
+		t.Errorf("ComposePreset(vp9) error = %v, want invalid preset", err)
+	}
+	if _, err := ComposePreset("h264", "480p", PlatformNVIDIA); !IsTranscoderError(err, ErrorTypeInvalidPreset) {
+		t.Errorf("ComposePreset(480p) error = %v, want invalid preset", err)
+	}
+}
+
+func TestNew_ComposesPresetFromCodecAndResolution(t *testing.T) {
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", Preset: "4k_h265", Codec: "av1"})
+	if tr.PresetName() != "4k_av1" {
+		t.Errorf("PresetName() = %s, want 4k_av1 (resolution from --preset)", tr.PresetName())
+	}
+
+	tr = New(Config{InputPath: "in.mp4", OutputDir: "out", Preset: "1080p_h264", Resolution: "720p"})
+	if _, err := tr.presetFor("in.mp4"); err != nil || tr.PresetName() != "720p_h264" {
+		t.Errorf("PresetName() = %s, %v, want 720p_h264", tr.PresetName(), err)
+	}
+}
+
+func TestValidateCodec_MissingEncoder(t *testing.T) {
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", Codec: "h264", Resolution: "720p", NoGPU: true})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{output: " V....D libx265 H.265"}, platform: PlatformSoftware}
+	if err := tr.ValidateCodec(); !IsTranscoderError(err, ErrorTypeEncoderNotFound) {
+		t.Errorf("ValidateCodec() error = %v, want encoder not found", err)
+	}
+
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{output: " V....D libx264 H.264"}, platform: PlatformSoftware}
+	if err := tr.ValidateCodec(); err != nil {
+		t.Errorf("ValidateCodec() error = %v, want nil", err)
+	}
+}
+
+func TestParseLadderScores(t *testing.T) {
+	vmaf := "[Parsed_libvmaf_4 @ 0x5581] VMAF score: 93.512345\n"
+	if score, ok := parseVMAFScore(vmaf); !ok || score != 93.512345 {
+		t.Errorf("parseVMAFScore() = %v, %v, want 93.512345", score, ok)
+	}
+	ssim := "[Parsed_ssim_4 @ 0x55] SSIM Y:0.987000 (18.9) U:0.991000 (20.5) V:0.990000 (20.1) All:0.988123 (19.3)\n"
+	if score, ok := parseSSIMScore(ssim); !ok || score != 0.988123 {
+		t.Errorf("parseSSIMScore() = %v, %v, want 0.988123", score, ok)
+	}
+	if _, ok := parseVMAFScore("frame=  240 fps=60"); ok {
+		t.Error("parseVMAFScore() found a score in output without one")
+	}
+}
+
+func TestMetricArgs(t *testing.T) {
+	args := metricArgs("sample.mkv", "movie.mp4", 5*time.Minute, 10*time.Second, LadderMetricVMAF)
+	joined := strings.Join(args, " ")
+	if !strings.HasPrefix(joined, "-hide_banner -i sample.mkv -ss 300 -t 10 -i movie.mp4 ") {
+		t.Errorf("metricArgs() = %s, want the source window as second input", joined)
+	}
+	if graph := argValue(args, "-lavfi"); !strings.HasSuffix(graph, "[d][r]libvmaf") || !strings.Contains(graph, "scale2ref") {
+		t.Errorf("metricArgs() -lavfi = %s", graph)
+	}
+	if graph := argValue(metricArgs("s.mkv", "m.mp4", 0, time.Second, LadderMetricSSIM), "-lavfi"); !strings.HasSuffix(graph, "ssim") {
+		t.Errorf("metricArgs(ssim) -lavfi = %s", graph)
+	}
+}
+
+func TestWriteLadderTable(t *testing.T) {
+	rungs := []LadderRung{
+		{CRF: 18, SizeBytes: 4 << 20, Score: 97.1},
+		{CRF: 24, SizeBytes: 2 << 20, Score: 93.4},
+	}
+	var out strings.Builder
+	WriteLadderTable(&out, rungs, LadderMetricVMAF)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "VMAF") {
+		t.Fatalf("WriteLadderTable() =\n%s", out.String())
+	}
+	if !strings.Contains(lines[2], "50%") || !strings.Contains(lines[2], "93.40") {
+		t.Errorf("CRF 24 row = %q, want 50%% of CRF 18 and its score", lines[2])
+	}
+}
+
+func TestQualityLadder_RejectsOutOfRangeCRF(t *testing.T) {
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", Preset: "1080p_h264", SkipValidation: true})
+	if _, err := tr.QualityLadder("in.mp4", t.TempDir(), []int{60}, 0, time.Second, LadderMetricNone); !IsTranscoderError(err, ErrorTypeInvalidPreset) {
+		t.Errorf("QualityLadder(60) error = %v, want invalid preset", err)
+	}
+	if tr.config.CRF != nil {
+		t.Error("QualityLadder() left a CRF override behind")
+	}
+}
+
+func TestProcessFile_SkipsAudioOnly(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "memo.m4v")
+	if err := os.WriteFile(input, make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := New(Config{InputPath: dir, OutputDir: dir, Preset: "1080p_h264"})
+	// Cover art shows up as a video stream but is not video
+	tr.prober = NewProber(&MockCommandExecutor{output: `{
+		"format": {"format_name": "mov,mp4,m4a", "duration": "42.0"},
+		"streams": [
+			{"codec_type": "audio", "codec_name": "aac"},
+			{"codec_type": "video", "codec_name": "mjpeg", "disposition": {"attached_pic": 1}}
+		]
+	}`})
+
+	var buf strings.Builder
+	csvWriter := csv.NewWriter(&buf)
+	result, err := tr.processFileWithAnalytics(context.Background(), input, csvWriter, nil)
+	if err != nil {
+		t.Fatalf("processFileWithAnalytics() error = %v", err)
+	}
+	if !result.Skipped || result.SkipReason != SkipReasonNoVideo {
+		t.Errorf("result = %+v, want skipped with no video", result)
+	}
+	csvWriter.Flush()
+	if !strings.Contains(buf.String(), "skipped_no_video") {
+		t.Errorf("CSV record = %q, want status skipped_no_video", buf.String())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected no output next to the input, found %d entries", len(entries))
+	}
+}
+
+func TestNVENCArgs(t *testing.T) {
+	lookahead, bframes := 20, 3
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", Preset: "1080p_h264",
+		NVENCLookahead: &lookahead, NVENCBFrames: &bframes, NVENCAQ: "both"})
+
+	want := []string{"-rc-lookahead", "20", "-bf", "3", "-spatial-aq", "1", "-temporal-aq", "1"}
+	if got := tr.nvencArgs("hevc_nvenc"); !reflect.DeepEqual(got, want) {
+		t.Errorf("nvencArgs(hevc_nvenc) = %v, want %v", got, want)
+	}
+	for _, encoder := range []string{"libx264", "hevc_videotoolbox", "libsvtav1"} {
+		if got := tr.nvencArgs(encoder); got != nil {
+			t.Errorf("nvencArgs(%s) = %v, want none", encoder, got)
+		}
+	}
+
+	// The options reach the command only on the hardware path
+	preset := tr.presets["1080p_h264"]
+	tr.systemChecker.platform = PlatformNVIDIA
+	if args := tr.buildFFmpegArgs("in.mp4", "out.mp4", preset, true); argValue(args, "-bf") != "3" {
+		t.Errorf("hardware args %v lack -bf 3", args)
+	}
+	if args := tr.buildFFmpegArgs("in.mp4", "out.mp4", preset, false); containsArg(args, "-bf") || containsArg(args, "-rc-lookahead") {
+		t.Errorf("software args %v carry NVENC options", args)
+	}
+
+	if got := New(Config{InputPath: "in.mp4", OutputDir: "out"}).nvencArgs("h264_nvenc"); got != nil {
+		t.Errorf("nvencArgs() without options = %v, want none", got)
+	}
+}
+
+func TestValidateNVENCOptions(t *testing.T) {
+	value := func(n int) *int { return &n }
+	tests := []struct {
+		name      string
+		lookahead *int
+		bframes   *int
+		aq        string
+		wantErr   bool
+	}{
+		{"unset", nil, nil, "", false},
+		{"in range", value(32), value(0), "spatial", false},
+		{"bframes too high", nil, value(5), "", true},
+		{"negative lookahead", value(-1), nil, "", true},
+		{"unknown aq", nil, nil, "strong", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateNVENCOptions(tt.lookahead, tt.bframes, tt.aq); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateNVENCOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.csv")
+	data := "filename,start_time,end_time,duration_seconds,size_before_mb,size_after_mb,space_saved_mb,compression_ratio,preset,status\n" +
+		"a.mp4,2024-01-01 10:00:00,2024-01-01 10:01:40,100.00,1000.00,400.00,600.00,0.4000,1080p_h265,success\n" +
+		"b.mp4,2024-01-01 10:02:00,2024-01-01 10:02:50,50.00,1000.00,200.00,800.00,0.2000,1080p_h265,success\n" +
+		"c.mp4,2024-01-01 10:03:00,2024-01-01 10:03:01,1.00,500.00,0.00,0.00,0.0000,1080p_h265,error\n" +
+		"d.mp4,2024-01-01 10:04:00,2024-01-01 10:04:00,0.00,800.00,0.00,0.00,0.0000,1080p_h264,success\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := LoadHistory(path)
+	if err != nil {
+		t.Fatalf("LoadHistory() error = %v", err)
+	}
+	entry, ok := history["1080p_h265"]
+	if !ok || entry.Files != 2 {
+		t.Fatalf("history[1080p_h265] = %+v, want 2 successful files", entry)
+	}
+	if got := entry.ThroughputMBps(); math.Abs(got-2000.0/150) > 1e-9 {
+		t.Errorf("ThroughputMBps() = %v, want %v", got, 2000.0/150)
+	}
+	if got := entry.CompressionRatio(); got != 0.3 {
+		t.Errorf("CompressionRatio() = %v, want 0.3", got)
+	}
+	if _, ok := history["1080p_h264"]; ok {
+		t.Error("a skipped row without output was counted")
+	}
+
+	if _, err := LoadHistory(filepath.Join(t.TempDir(), "missing.csv")); !IsTranscoderError(err, ErrorTypeFileSystemError) {
+		t.Errorf("LoadHistory(missing) error = %v, want file system error", err)
+	}
+}
+
+func TestEstimateRun(t *testing.T) {
+	dir := t.TempDir()
+	outputDir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a.mp4", "b.mp4", "done.mp4"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, 10<<20), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+
+	tr := New(Config{InputPath: dir, OutputDir: outputDir, Preset: "1080p_h265"})
+	existing, _, _ := tr.plannedOutput(files[2])
+	if err := os.WriteFile(existing, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history := map[string]*PresetHistory{"1080p_h265": {Files: 1, InputMB: 100, OutputMB: 25, Seconds: 50}}
+	estimate := tr.EstimateRun(files, history)
+	if estimate.Files != 2 || estimate.Skipped != 1 || estimate.FromHistory != 2 {
+		t.Fatalf("EstimateRun() = %+v, want 2 files from history and 1 skipped", estimate)
+	}
+	if estimate.Duration != 10*time.Second {
+		t.Errorf("Duration = %s, want 10s at 2 MB/s", estimate.Duration)
+	}
+	if estimate.OutputBytes != 5<<20 {
+		t.Errorf("OutputBytes = %d, want a quarter of the input", estimate.OutputBytes)
+	}
+
+	fallback := tr.EstimateRun(files[:1], nil)
+	if fallback.FromDefaults != 1 || fallback.OutputBytes != int64(float64(10<<20)*defaultCompressionRatio) {
+		t.Errorf("EstimateRun() without history = %+v, want the default ratio", fallback)
+	}
+	var out strings.Builder
+	PrintEstimate(&out, fallback)
+	if !strings.Contains(out.String(), "Approximate") || !strings.Contains(out.String(), "rough defaults") {
+		t.Errorf("PrintEstimate() = %q, want approximate caveats", out.String())
+	}
+}
+
+func TestApplyBitrateCap(t *testing.T) {
+	crf := 21
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", CRF: &crf, MaxBitrate: 6e6})
+
+	software := tr.applyBitrateCap(tr.applyQuality([]string{"-c:v", "libx264", "-preset", "medium", "-crf", "23", "-b:v", "5M", "-maxrate", "8M", "-bufsize", "16M"}))
+	want := []string{"-c:v", "libx264", "-preset", "medium", "-crf", "21", "-maxrate", "6M", "-bufsize", "12M"}
+	if !reflect.DeepEqual(software, want) {
+		t.Errorf("capped libx264 args = %v, want %v", software, want)
+	}
+
+	nvenc := tr.applyBitrateCap(tr.applyQuality(tr.presets["1080p_h265"].Args))
+	if argValue(nvenc, "-cq") != "21" || argValue(nvenc, "-rc") != "vbr" || argValue(nvenc, "-b:v") != "0" || argValue(nvenc, "-maxrate") != "6M" {
+		t.Errorf("capped NVENC args = %v, want -rc vbr -cq 21 -b:v 0 -maxrate 6M", nvenc)
+	}
+
+	videotoolbox := []string{"-c:v", "hevc_videotoolbox", "-q:v", "65", "-b:v", "3M"}
+	if got := tr.applyBitrateCap(videotoolbox); !reflect.DeepEqual(got, videotoolbox) {
+		t.Errorf("capped VideoToolbox args = %v, want them unchanged", got)
+	}
+
+	tr.config.BufsizeFactor = 1.5
+	if got := tr.applyBitrateCap([]string{"-c:v", "libx265", "-crf", "21"}); argValue(got, "-bufsize") != "9M" {
+		t.Errorf("capped args with --bufsize-factor = %v, want -bufsize 9M", got)
+	}
+}
+
+func TestConfig_ValidateBitrateCapNeedsCRF(t *testing.T) {
+	config := Config{InputPath: "in.mp4", OutputDir: "out", MaxBitrate: 6e6}
+	if err := config.Validate(); !IsTranscoderError(err, ErrorTypeInvalidPreset) {
+		t.Errorf("Validate() error = %v, want a CRF requirement", err)
+	}
+	if _, err := ParseBitrate("fast"); err == nil {
+		t.Error("ParseBitrate(fast) succeeded")
+	}
+	if bps, err := ParseBitrate("7500k"); err != nil || bps != 7.5e6 {
+		t.Errorf("ParseBitrate(7500k) = %v, %v", bps, err)
+	}
+}
+
+// pathProbeExecutor answers ffprobe with canned JSON per probed path; paths
+// without an entry fail to probe
+type pathProbeExecutor struct {
+	probes map[string]string
+}
+
+func (p *pathProbeExecutor) Execute(name string, args ...string) ([]byte, error) {
+	if name == "ffprobe" && len(args) > 0 {
+		if output, ok := p.probes[args[len(args)-1]]; ok {
+			return []byte(output), nil
+		}
+	}
+	return nil, NewTranscoderError(ErrorTypeEncodingFailed, "probe failed", nil)
+}
+
+func (p *pathProbeExecutor) Run(name string, args ...string) error {
+	return nil
+}
+
+func TestFindCleanupCandidates(t *testing.T) {
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+	write := func(path string, size int) string {
+		return writeFixture(t, path, make([]byte, size))
+	}
+	probe := func(duration string, video bool) string {
+		streams := `{"codec_type": "audio", "codec_name": "aac"}`
+		if video {
+			streams = `{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}, ` + streams
+		}
+		return `{"format": {"duration": "` + duration + `"}, "streams": [` + streams + `]}`
+	}
+
+	tr := New(Config{InputPath: sourceDir, OutputDir: outputDir, SkipValidation: true})
+	preset := tr.presets["1080p_h264"]
+	outputFor := func(name string) string {
+		return tr.pathUtils.GenerateOutputPath(filepath.Join(sourceDir, name), outputDir, sourceDir, preset)
+	}
+
+	source := write(filepath.Join(sourceDir, "movie.mp4"), 10)
+	truncated := write(outputFor("movie.mp4"), 10)
+	complete := write(filepath.Join(outputDir, "complete.mkv"), 10)
+	empty := write(filepath.Join(outputDir, "empty.mp4"), 0)
+	broken := write(filepath.Join(outputDir, "broken.mp4"), 10)
+	audioOnly := write(filepath.Join(outputDir, "audio.mp4"), 10)
+
+	executor := &pathProbeExecutor{probes: map[string]string{
+		source:    probe("6000", true),
+		truncated: probe("720", true),
+		complete:  probe("5990", true),
+		audioOnly: probe("6000", false),
+	}}
+	tr.prober = NewProber(executor)
+	tr.systemChecker = &SystemChecker{executor: executor, platform: PlatformSoftware}
+
+	candidates, err := tr.FindCleanupCandidates(DefaultMinDurationRatio)
+	if err != nil {
+		t.Fatalf("FindCleanupCandidates() error = %v", err)
+	}
+	reasons := make(map[string]string)
+	for _, candidate := range candidates {
+		reasons[candidate.Path] = candidate.Reason
+	}
+	want := map[string]string{
+		empty:     "zero-byte file",
+		broken:    "unreadable (probe failed)",
+		audioOnly: "no video stream",
+		truncated: "truncated (12m0s of 1h40m0s)",
+	}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("FindCleanupCandidates() reasons = %v, want %v", reasons, want)
+	}
+
+	deleted, errs := DeleteCleanupCandidates(candidates)
+	if deleted != 4 || len(errs) != 0 {
+		t.Errorf("DeleteCleanupCandidates() = %d, %v", deleted, errs)
+	}
+	if _, err := os.Stat(complete); err != nil {
+		t.Errorf("complete output was removed: %v", err)
+	}
+}
+
+func TestFindCleanupCandidates_RequiresFFprobe(t *testing.T) {
+	tr := New(Config{OutputDir: t.TempDir(), SkipValidation: true})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{shouldFail: true}, platform: PlatformSoftware}
+	if _, err := tr.FindCleanupCandidates(DefaultMinDurationRatio); !IsTranscoderError(err, ErrorTypeFFmpegNotFound) {
+		t.Errorf("FindCleanupCandidates() error = %v, want ffprobe not found", err)
+	}
+}
+
+func TestConfirmCleanup(t *testing.T) {
+	for answer, want := range map[string]bool{"y\n": true, "yes\n": true, "\n": false, "n\n": false} {
+		got, err := ConfirmCleanup(strings.NewReader(answer), io.Discard, 3)
+		if err != nil || got != want {
+			t.Errorf("ConfirmCleanup(%q) = %v, %v, want %v", answer, got, err, want)
+		}
+	}
+	if _, err := ConfirmCleanup(strings.NewReader(""), io.Discard, 3); err == nil {
+		t.Error("ConfirmCleanup() on closed input succeeded")
+	}
+}
+
+func TestStagedOutput(t *testing.T) {
+	stageDir := t.TempDir()
+	outputDir := t.TempDir()
+	outputPath := filepath.Join(outputDir, "show", "episode.mkv")
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := New(Config{InputPath: "in.mp4", OutputDir: outputDir, StageDir: stageDir})
+	if err := tr.ValidateStageDir(); err != nil {
+		t.Fatalf("ValidateStageDir() error = %v", err)
+	}
+
+	staged, err := tr.stageOutput(outputPath)
+	if err != nil {
+		t.Fatalf("stageOutput() error = %v", err)
+	}
+	if !strings.HasPrefix(staged, stageDir) || filepath.Base(staged) != "episode.mkv" {
+		t.Fatalf("stageOutput() = %s, want episode.mkv under %s", staged, stageDir)
+	}
+	if err := os.WriteFile(staged, []byte("encoded"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.commitStaged(staged, outputPath); err != nil {
+		t.Fatalf("commitStaged() error = %v", err)
+	}
+	if data, err := os.ReadFile(outputPath); err != nil || string(data) != "encoded" {
+		t.Errorf("output = %q, %v; want the staged encode", data, err)
+	}
+
+	// A failed encode leaves nothing at the output path; Cleanup empties the
+	// staging area of anything still in flight
+	failed, err := tr.stageOutput(filepath.Join(outputDir, "failed.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(failed, []byte("partial"), 0644)
+	tr.discardStaged(failed, filepath.Join(outputDir, "failed.mkv"))
+	pending, err := tr.stageOutput(filepath.Join(outputDir, "pending.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(pending, []byte("partial"), 0644)
+	tr.Cleanup()
+
+	if entries, _ := os.ReadDir(stageDir); len(entries) != 0 {
+		t.Errorf("stage dir not cleaned, contains %d entries", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "failed.mkv")); !os.IsNotExist(err) {
+		t.Errorf("failed encode reached the output directory")
+	}
+}
+
+func TestCopyFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.mkv")
+	dst := filepath.Join(dir, "dst.mkv")
+	if err := os.WriteFile(src, []byte("encoded"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(dst, []byte("old"), 0644)
+
+	// The copy path is what a move across filesystems falls back to
+	if err := copyFileAtomic(src, dst); err != nil {
+		t.Fatalf("copyFileAtomic() error = %v", err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "encoded" {
+		t.Errorf("dst = %q, want %q", data, "encoded")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("copy left %d entries, want src and dst only", len(entries))
+	}
+}
+
+func TestAdaptiveBitrate(t *testing.T) {
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", Preset: "1080p_h264", AdaptiveBitrate: true, AdaptiveMax: 9e6})
+
+	lo, hi := tr.adaptiveBounds(6e6)
+	if lo != 3e6 || hi != 9e6 {
+		t.Errorf("adaptiveBounds(6M) = %v, %v; want 3M default minimum and the 9M maximum", lo, hi)
+	}
+
+	// Preset args without a chosen rate pass through untouched
+	args := []string{"-c:v", "libx264", "-b:v", "6M", "-maxrate", "9M", "-bufsize", "12M"}
+	preset := tr.presets["1080p_h264"]
+	if got := tr.applyAdaptiveBitrate("in.mp4", preset, args); !reflect.DeepEqual(got, args) {
+		t.Errorf("applyAdaptiveBitrate() without a rate = %v", got)
+	}
+
+	tr.setTargetBitrate("in.mp4", preset.Name, 3e6)
+	got := tr.applyAdaptiveBitrate("in.mp4", preset, args)
+	want := []string{"-c:v", "libx264", "-b:v", "3M", "-maxrate", "4500k", "-bufsize", "6M"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("applyAdaptiveBitrate() = %v, want %v", got, want)
+	}
+	if args[3] != "6M" {
+		t.Errorf("applyAdaptiveBitrate() modified the preset args")
+	}
+	if got := tr.applyAdaptiveBitrate("in.mp4", tr.presets["1080p_h265"], args); !reflect.DeepEqual(got, args) {
+		t.Errorf("applyAdaptiveBitrate() of another preset = %v, want the rate kept to its own preset", got)
+	}
+}
+
+func TestComplexityProbeArgs(t *testing.T) {
+	tests := []struct {
+		duration    time.Duration
+		start, want time.Duration
+	}{
+		{10 * time.Second, 0, 10 * time.Second},
+		{100 * time.Second, 40 * time.Second, 20 * time.Second},
+	}
+	for _, tt := range tests {
+		start, length := complexityWindow(tt.duration)
+		if start != tt.start || length != tt.want {
+			t.Errorf("complexityWindow(%s) = %s, %s; want %s, %s", tt.duration, start, length, tt.start, tt.want)
+		}
+	}
+
+	args := complexityArgs([]string{"-i", "in.mp4", "-c:v", "libx264", "-c:a", "aac", "-y", "out.mkv"}, 40*time.Second, 20*time.Second)
+	want := []string{"-ss", "40", "-i", "in.mp4", "-c:v", "libx264", "-c:a", "aac", "-t", "20", "-an", "-sn", "-y", "out.mkv"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("complexityArgs() = %v, want %v", args, want)
+	}
+
+	record := AnalyticsRecord{Status: "success", TargetBitrate: 4.25e6}
+	if row := record.csvRow(); len(row) != len(csvHeader) || row[slices.Index(csvHeader, "target_bitrate_kbps")] != "4250" {
+		t.Errorf("csvRow() = %v, want target_bitrate_kbps 4250", row)
+	}
+}
+
+func TestRepairIssues(t *testing.T) {
+	video := &ProbeInfo{VideoCodec: "h264", Duration: 60}
+	noIndex := &ProbeInfo{VideoCodec: "h264"}
+	tests := []struct {
+		name     string
+		path     string
+		info     *ProbeInfo
+		moovLate bool
+		want     []string
+	}{
+		{"healthy mp4", "a.mp4", video, false, nil},
+		{"mp4 without faststart", "a.mp4", video, true, []string{RepairFaststart}},
+		{"mov without faststart", "a.MOV", video, true, []string{RepairFaststart}},
+		{"late moov ignored outside mp4", "a.mkv", video, true, nil},
+		{"missing duration", "a.avi", noIndex, false, []string{RepairBrokenIndex}},
+		{"both", "a.m4v", noIndex, true, []string{RepairFaststart, RepairBrokenIndex}},
+		{"audio only is not broken", "a.mkv", &ProbeInfo{AudioCodec: "aac"}, false, nil},
+		{"no probe", "a.mkv", nil, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := repairIssues(tt.path, tt.info, tt.moovLate); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("repairIssues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	args := repairArgs("in.mp4", "fixed.mp4", []string{RepairFaststart})
+	want := []string{"-hide_banner", "-loglevel", "error", "-i", "in.mp4", "-map", "0", "-c", "copy", "-movflags", "+faststart", "-y", "fixed.mp4"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("repairArgs(mp4) = %v, want %v", args, want)
+	}
+	args = repairArgs("in.avi", "fixed.avi", []string{RepairBrokenIndex})
+	want = []string{"-hide_banner", "-loglevel", "error", "-fflags", "+genpts", "-i", "in.avi", "-map", "0", "-c", "copy", "-y", "fixed.avi"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("repairArgs(avi) = %v, want %v", args, want)
+	}
+}
+
+func TestMoovAfterMdat(t *testing.T) {
+	box := func(kind string, payload int) []byte {
+		b := make([]byte, 8+payload)
+		binary.BigEndian.PutUint32(b, uint32(len(b)))
+		copy(b[4:], kind)
+		return b
+	}
+	dir := t.TempDir()
+	tests := []struct {
+		name  string
+		boxes [][]byte
+		want  bool
+	}{
+		{"faststart", [][]byte{box("ftyp", 16), box("moov", 32), box("mdat", 64)}, false},
+		{"index at end", [][]byte{box("ftyp", 16), box("free", 0), box("mdat", 64), box("moov", 32)}, true},
+		{"no index", [][]byte{box("ftyp", 16)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_")+".mp4")
+			if err := os.WriteFile(path, bytes.Join(tt.boxes, nil), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := moovAfterMdat(path)
+			if err != nil || got != tt.want {
+				t.Errorf("moovAfterMdat() = %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputPathIsDirectory(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	input := filepath.Join(inputDir, "movie.mp4")
+	if err := os.WriteFile(input, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: "1080p_h264", Overwrite: true})
+	tr.prober = NewProber(&MockCommandExecutor{shouldFail: true})
+	output, _, _ := tr.plannedOutput(input)
+	if err := os.MkdirAll(output, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := tr.processFile(context.Background(), input, nil)
+	if err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Errorf("processFile() error = %v, want output path is a directory", err)
+	}
+	if _, _, err := tr.plannedOutput(input); err == nil {
+		t.Errorf("plannedOutput() should report the directory in the way")
+	}
+}
+
+func TestEnsureOutputDirBlockedByFile(t *testing.T) {
+	root := t.TempDir()
+	blocker := filepath.Join(root, "show")
+	if err := os.WriteFile(blocker, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := New(Config{InputPath: "in.mp4", OutputDir: root})
+	err := tr.ensureOutputDir(filepath.Join(blocker, "season1"))
+	var transcoderErr *TranscoderError
+	if !errors.As(err, &transcoderErr) || !strings.Contains(err.Error(), blocker+" is a file") {
+		t.Errorf("ensureOutputDir() error = %v, want %s is a file", err, blocker)
+	}
+}
+
+// countingExecutor answers every ffprobe call with the same output and counts the calls
+type countingExecutor struct {
+	output string
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *countingExecutor) Execute(name string, args ...string) ([]byte, error) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+	return []byte(c.output), nil
+}
+
+func (c *countingExecutor) Run(name string, args ...string) error {
+	return nil
+}
+
+func (c *countingExecutor) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func TestProbeCache(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "probe-cache.json")
+	var files []string
+	for _, name := range []string{"a.mkv", "b.mkv", "c.mkv"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	const output = `{"format": {"format_name": "matroska", "duration": "60.0"}, "streams": [{"codec_type": "video", "codec_name": "h264"}]}`
+
+	// First run probes every file, through the worker pool
+	cache, err := LoadProbeCache(cachePath)
+	if err != nil {
+		t.Fatalf("LoadProbeCache() error = %v", err)
+	}
+	first := &countingExecutor{output: output}
+	prober := NewProber(first)
+	prober.SetPersistentCache(cache)
+	if got := prober.ProbeAll(files, 3); len(got) != len(files) {
+		t.Fatalf("ProbeAll() probed %d files, want %d", len(got), len(files))
+	}
+	if first.Calls() != len(files) {
+		t.Errorf("first run ran ffprobe %d times, want %d", first.Calls(), len(files))
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// The next run only re-probes the file that changed since
+	later := time.Now().Add(time.Minute)
+	if err := os.WriteFile(files[1], []byte("re-encoded video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(files[1], later, later)
+
+	cache, err = LoadProbeCache(cachePath)
+	if err != nil {
+		t.Fatalf("LoadProbeCache() error = %v", err)
+	}
+	second := &countingExecutor{output: output}
+	prober = NewProber(second)
+	prober.SetPersistentCache(cache)
+	for _, file := range files {
+		info, err := prober.Probe(file)
+		if err != nil || info.VideoCodec != "h264" || info.Duration != 60 {
+			t.Errorf("Probe(%s) = %+v, %v", file, info, err)
+		}
+	}
+	if second.Calls() != 1 {
+		t.Errorf("second run ran ffprobe %d times, want 1 for the modified file", second.Calls())
+	}
+
+	// A damaged cache is discarded instead of failing the run
+	os.WriteFile(cachePath, []byte("{not json"), 0644)
+	cache, err = LoadProbeCache(cachePath)
+	if err != nil {
+		t.Fatalf("LoadProbeCache(damaged) error = %v", err)
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("LoadProbeCache(damaged) kept %d entries, want an empty cache", len(cache.entries))
+	}
+}
+
+func TestWithDebugOverlay(t *testing.T) {
+	args := []string{"-i", "in.mp4", "-c:v", "libx264", "-crf", "22", "-b:v", "5M", "-maxrate", "8M", "-vf", "scale=1920:1080", "-t", "10", "-y", "out.mkv"}
+	got := withDebugOverlay(args)
+	chain := argValue(got, "-vf")
+	if !strings.HasPrefix(chain, "scale=1920:1080,drawtext=") {
+		t.Errorf("overlay not appended after scaling: %s", chain)
+	}
+	for _, want := range []string{"frame %{frame_num}", `%{pts\:hms}`, "crf 22", "b:v 5M max 8M", "libx264"} {
+		if !strings.Contains(chain, want) {
+			t.Errorf("overlay %q missing %q", chain, want)
+		}
+	}
+	if argValue(args, "-vf") != "scale=1920:1080" {
+		t.Errorf("withDebugOverlay() modified its input")
+	}
+
+	// Without a filter chain one is added before the output
+	got = withDebugOverlay([]string{"-i", "in.mp4", "-c:v", "h264_videotoolbox", "-q:v", "65", "-y", "out.mp4"})
+	if len(got) != 10 || got[6] != "-vf" || got[8] != "-y" || !strings.Contains(got[7], "q:v 65") {
+		t.Errorf("withDebugOverlay() = %v", got)
+	}
+}
+
+func TestSkipKeyCancelsCurrentFile(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not available")
+	}
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	input := filepath.Join(inputDir, "slow.mp4")
+	if err := os.WriteFile(input, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: "1080p_h264", NoProbe: true, ProbeTimeout: time.Minute})
+	tr.prober = NewProber(&MockCommandExecutor{shouldFail: true})
+	// Stand in for an ffmpeg that would run far longer than the test
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, sleep, "30")
+	}
+	var out strings.Builder
+	control := NewRunControl(&out)
+	tr.SetRunControl(control)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		control.HandleKey('s')
+	}()
+
+	var csvOut strings.Builder
+	writer := csv.NewWriter(&csvOut)
+	start := time.Now()
+	result, err := tr.processFileWithAnalytics(context.Background(), input, writer, nil)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("skip took %s, ffmpeg was not cancelled", elapsed)
+	}
+	if err != nil || result == nil || result.SkipReason != SkipReasonUser {
+		t.Fatalf("processFileWithAnalytics() = %+v, %v; want skipped by user", result, err)
+	}
+	if !strings.Contains(csvOut.String(), "skipped_by_user") {
+		t.Errorf("analytics row %q does not record the skip", csvOut.String())
+	}
+	if entries, _ := os.ReadDir(outputDir); len(entries) != 0 {
+		t.Errorf("skipped file left %d entries in the output directory", len(entries))
+	}
+
+	// The control is ready for the next file; 's' between files does nothing
+	if control.FinishFile() {
+		t.Error("FinishFile() still reports the previous skip")
+	}
+	control.HandleKey('s')
+	if strings.Count(out.String(), "[skipping]") != 1 {
+		t.Errorf("output %q, want a single skip notice", out.String())
+	}
+}
+
+func TestAspectArgs(t *testing.T) {
+	scaled := []string{"-c:v", "libx264", "-vf", "scale=1920:1080"}
+	tests := []struct {
+		name string
+		args []string
+		info ProbeInfo
+		want string
+	}{
+		{"square pixels untouched", scaled, ProbeInfo{Width: 1920, Height: 1080, SAR: "1:1"}, "scale=1920:1080"},
+		{"unknown SAR untouched", scaled, ProbeInfo{Width: 720, Height: 480, SAR: "0:1"}, "scale=1920:1080"},
+		// 720x480 at 32:27 displays 16:9 and fills the box
+		{"NTSC DVD widescreen", scaled, ProbeInfo{Width: 720, Height: 480, SAR: "32:27"}, "scale=1920:1080,setsar=1"},
+		// 720x480 at 8:9 displays 4:3 and is pillarboxed to 1440 wide
+		{"NTSC DVD 4:3", scaled, ProbeInfo{Width: 720, Height: 480, SAR: "8:9"}, "scale=1440:1080,setsar=1"},
+		{"PAL DVD widescreen", scaled, ProbeInfo{Width: 720, Height: 576, SAR: "64:45"}, "scale=1920:1080,setsar=1"},
+		// 1440x1080 HDV at 4:3 pixels is 16:9 full HD
+		{"HDV anamorphic", scaled, ProbeInfo{Width: 1440, Height: 1080, SAR: "4:3"}, "scale=1920:1080,setsar=1"},
+		{"rotated anamorphic", scaled, ProbeInfo{Width: 720, Height: 480, SAR: "32:27", Rotation: 90}, "scale=608:1080,setsar=1"},
+		{"scale options kept", []string{"-vf", "scale=1920:1080:flags=lanczos"}, ProbeInfo{Width: 720, Height: 480, SAR: "8:9"}, "scale=1440:1080:flags=lanczos,setsar=1"},
+		{"no preset scaling", []string{"-c:v", "libx264"}, ProbeInfo{Width: 720, Height: 480, SAR: "32:27"}, "scale=854:480,setsar=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aspectFilters(filterChainOf(tt.args), &tt.info).String(); got != tt.want {
+				t.Errorf("aspectFilters() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamBatches_LargeLibrary(t *testing.T) {
+	inputDir := t.TempDir()
+	const dirs, perDir = 20, 150
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(inputDir, fmt.Sprintf("show%02d", d))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < perDir; i++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("ep%03d.mp4", i)), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tr := New(Config{InputPath: inputDir, OutputDir: t.TempDir(), Preset: "1080p_h264", Recursive: true})
+	all, err := tr.FindVideoFiles()
+	if err != nil || len(all) != dirs*perDir {
+		t.Fatalf("FindVideoFiles() found %d files, %v", len(all), err)
+	}
+
+	var streamed []string
+	var sizes []int
+	err = tr.StreamBatches(context.Background(), 250, func(batch []string, index int) error {
+		if index != len(sizes)+1 {
+			t.Errorf("batch index %d, want %d", index, len(sizes)+1)
+		}
+		if len(tr.inputRoots) != len(batch) {
+			t.Errorf("batch %d: %d files tracked, want only the %d in the batch", index, len(tr.inputRoots), len(batch))
+		}
+		sizes = append(sizes, len(batch))
+		streamed = append(streamed, batch...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamBatches() error = %v", err)
+	}
+	if len(sizes) != 12 || sizes[0] != 250 || sizes[11] != 250 {
+		t.Errorf("batch sizes %v, want 12 batches of 250", sizes)
+	}
+	if !reflect.DeepEqual(streamed, all) {
+		t.Error("streamed files differ from FindVideoFiles order")
+	}
+	if len(tr.inputRoots) != 0 {
+		t.Errorf("%d files still tracked after the last batch", len(tr.inputRoots))
+	}
+
+	// A failing batch is reported but later batches still run
+	count := 0
+	err = tr.StreamBatches(context.Background(), 1000, func(batch []string, index int) error {
+		count++
+		if index == 1 {
+			return errors.New("batch failed")
+		}
+		return nil
+	})
+	if err == nil || count != 3 {
+		t.Errorf("StreamBatches() ran %d batches, error %v; want 3 and the first failure", count, err)
+	}
+}
+
+func TestMaxFilesPerDir(t *testing.T) {
+	inputDir := t.TempDir()
+	for _, name := range []string{"a.mp4", "b.mp4", "c.mkv", "notes.txt", "cover.jpg"} {
+		if err := os.WriteFile(filepath.Join(inputDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crowded := filepath.Join(inputDir, "crowded")
+	if err := os.MkdirAll(crowded, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := os.WriteFile(filepath.Join(crowded, fmt.Sprintf("clip%d.mp4", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	discovery := NewFileDiscovery()
+	discovery.SetMaxFilesPerDir(3)
+	// Only video files count towards the limit
+	if files, err := discovery.FindVideoFiles(inputDir, false); err != nil || len(files) != 3 {
+		t.Errorf("FindVideoFiles() = %v, %v; want the 3 top-level videos", files, err)
+	}
+	if _, err := discovery.FindVideoFiles(inputDir, true); err == nil || !strings.Contains(err.Error(), "--max-files-per-dir") {
+		t.Errorf("FindVideoFiles() error = %v, want the per-directory limit", err)
+	}
+
+	discovery.SetMaxFilesPerDir(0)
+	if files, err := discovery.FindVideoFiles(inputDir, true); err != nil || len(files) != 8 {
+		t.Errorf("FindVideoFiles() without a limit = %d files, %v", len(files), err)
+	}
+}
+
+func TestLoadProject(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ffmcli.yaml")
+	project := `# Season archive
+input:
+  - shows/season1
+  - /mnt/archive/extras
+output: out
+preset: 1080p_h265
+recursive: true
+crf: 20
+audio-codec: aac
+software-codecs: [av1]
+max-runtime: 2h
+log-file: logs/run.log
+json-output: run.json
+resume-file: state/resume.json
+`
+	if err := os.WriteFile(path, []byte(project), 0644); err != nil {
+		t.Fatal(err)
+	}
+	known := func(key string) bool {
+		switch key {
+		case "input", "output", "preset", "recursive", "crf", "audio-codec", "software-codecs", "max-runtime",
+			"log-file", "json-output", "resume-file":
+			return true
+		}
+		return false
+	}
+
+	settings, err := LoadProject(path, known)
+	if err != nil {
+		t.Fatalf("LoadProject() error = %v", err)
+	}
+	want := []ProjectSetting{
+		{Key: "input", Values: []string{filepath.Join(dir, "shows/season1"), "/mnt/archive/extras"}, Line: 2},
+		{Key: "output", Values: []string{filepath.Join(dir, "out")}, Line: 5},
+		{Key: "preset", Values: []string{"1080p_h265"}, Line: 6},
+		{Key: "recursive", Values: []string{"true"}, Line: 7},
+		{Key: "crf", Values: []string{"20"}, Line: 8},
+		{Key: "audio-codec", Values: []string{"aac"}, Line: 9},
+		{Key: "software-codecs", Values: []string{"av1"}, Line: 10},
+		{Key: "max-runtime", Values: []string{"2h"}, Line: 11},
+		{Key: "log-file", Values: []string{filepath.Join(dir, "logs/run.log")}, Line: 12},
+		{Key: "json-output", Values: []string{filepath.Join(dir, "run.json")}, Line: 13},
+		{Key: "resume-file", Values: []string{filepath.Join(dir, "state/resume.json")}, Line: 14},
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("LoadProject() = %+v\nwant %+v", settings, want)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown key", "output: out\ncodec_name: h264\n", "ffmcli.yaml:2: unknown key 'codec_name'"},
+		{"duplicate key", "crf: 20\ncrf: 22\n", "ffmcli.yaml:2: key 'crf' is set twice"},
+		{"nested value", "preset:\n  name: x\n", "ffmcli.yaml:2: key 'preset': expected a value"},
+		{"missing value", "output:\n", "key 'output': missing value"},
+		{"not a mapping", "- input\n", "must be a mapping"},
+		{"bad yaml", "output: [out\n", "not valid YAML"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadProject(path, known); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadProject() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPowerSampler(t *testing.T) {
+	if watts, err := parsePowerDraw("142.37\n"); err != nil || watts != 142.37 {
+		t.Errorf("parsePowerDraw() = %v, %v; want 142.37", watts, err)
+	}
+	if _, err := parsePowerDraw("[N/A]\n"); err == nil {
+		t.Error("parsePowerDraw() accepted [N/A]")
+	}
+
+	// Each reading applies until the next: 100 W for 2s, then 200 W for 1s
+	s := &powerSampler{}
+	start := time.Now()
+	s.record(100, start)
+	s.record(200, start.Add(2*time.Second))
+	s.record(50, start.Add(3*time.Second))
+	if s.joules != 400 {
+		t.Errorf("integrated %v J, want 400", s.joules)
+	}
+
+	sampler := startPowerSampler(&MockCommandExecutor{output: "150.00\n"}, 0, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if joules, ok := sampler.Stop(); !ok || joules <= 0 {
+		t.Errorf("Stop() = %v, %v; want a positive measurement", joules, ok)
+	}
+	sampler = startPowerSampler(&MockCommandExecutor{shouldFail: true}, 0, time.Millisecond)
+	if _, ok := sampler.Stop(); ok {
+		t.Error("Stop() reported a measurement without any reading")
+	}
+}
+
+func TestEnergySummary(t *testing.T) {
+	const gb = 1 << 30
+	results := []*FileResult{
+		{EncodingMode: EncodingModeHardware, InputSize: 3 * gb, OutputSize: gb, Energy: 7200, PowerSampled: true},
+		{EncodingMode: EncodingModeSoftware, InputSize: 2 * gb, OutputSize: gb, Energy: 3600},
+		{Skipped: true, SkipReason: SkipReasonOutputExists, InputSize: 5 * gb},
+	}
+	var out bytes.Buffer
+	printEnergySummary(&out, results, 65)
+	for _, want := range []string{
+		"Energy (approximate): 3.0 Wh for 2 file(s), 1.0 Wh per GB saved",
+		"1 file(s) from sampled GPU power draw, 1 assuming 65 W",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("summary %q does not contain %q", out.String(), want)
+		}
+	}
+
+	// Software encodes fall back to the assumed wattage over the wall time
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", Energy: true, EnergyWatts: 100, NoGPU: true})
+	meter := tr.startEnergyMeter()
+	meter.start = meter.start.Add(-time.Minute)
+	meter.mark -= 60
+	result := &FileResult{EncodingMode: EncodingModeSoftware}
+	meter.finish(result)
+	if result.PowerSampled || result.Energy < 6000 || result.Energy > 6100 {
+		t.Errorf("software encode energy = %v J (sampled %v), want about 6000 J", result.Energy, result.PowerSampled)
+	}
+	if New(Config{InputPath: "in.mp4", OutputDir: "out"}).startEnergyMeter() != nil {
+		t.Error("energy meter started without --energy")
+	}
+
+	// Encodes running at once split the draw: a and b share the first
+	// minute, b and c the second, and c runs alone for the third
+	var shares energyShares
+	start := time.Now()
+	a := shares.join(start)
+	b := shares.join(start)
+	if got := shares.leave(a, start.Add(time.Minute)); got != 30 {
+		t.Errorf("share of a = %vs, want 30s", got)
+	}
+	c := shares.join(start.Add(time.Minute))
+	if got := shares.leave(b, start.Add(2*time.Minute)); got != 60 {
+		t.Errorf("share of b = %vs, want 60s", got)
+	}
+	if got := shares.leave(c, start.Add(3*time.Minute)); got != 90 {
+		t.Errorf("share of c = %vs, want 90s", got)
+	}
+}
+
+func TestFilterNew(t *testing.T) {
+	inputDir := t.TempDir()
+	libraryDir := t.TempDir()
+	var sources []string
+	for _, name := range []string{"a.mp4", "b.mp4", "season1/c.mkv", "season1/d.mkv"} {
+		path := filepath.Join(inputDir, name)
+		writeFixture(t, path, []byte("x"))
+		sources = append(sources, path)
+	}
+	// a was moved into a folder of its own, c sits where ffmcli put it, and
+	// d only exists for another preset
+	for _, name := range []string{"Movies/A (2020)/a_1080p_h264.mkv", "season1/c_1080p_h264.mkv", "d_720p_av1.mkv"} {
+		path := filepath.Join(libraryDir, name)
+		writeFixture(t, path, []byte("x"))
+	}
+
+	tr := New(Config{InputPath: inputDir, OutputDir: libraryDir, Preset: "1080p_h264", Recursive: true, OnlyNew: true})
+	files, err := tr.FindVideoFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := tr.FilterNew(files)
+	if err != nil {
+		t.Fatalf("FilterNew() error = %v", err)
+	}
+	if want := []string{sources[1], sources[3]}; !reflect.DeepEqual(fresh, want) {
+		t.Errorf("FilterNew() = %v, want %v", fresh, want)
+	}
+
+	// Without --only-new every file is kept, and a library that does not
+	// exist yet holds nothing
+	tr = New(Config{InputPath: inputDir, OutputDir: libraryDir, Preset: "1080p_h264", Recursive: true})
+	if kept, _ := tr.FilterNew(files); len(kept) != len(files) {
+		t.Errorf("FilterNew() without OnlyNew kept %d of %d files", len(kept), len(files))
+	}
+	tr = New(Config{InputPath: inputDir, OutputDir: filepath.Join(libraryDir, "missing"), Preset: "1080p_h264", Recursive: true, OnlyNew: true})
+	tr.FindVideoFiles()
+	if kept, err := tr.FilterNew(files); err != nil || len(kept) != len(files) {
+		t.Errorf("FilterNew() with a missing library = %d files, %v", len(kept), err)
+	}
+}
+
+func TestApplyQualityTarget(t *testing.T) {
+	tests := []struct {
+		encoder string
+		target  string
+		speed   string
+		quality []string
+		tune    string
+	}{
+		{"libx264", "low", "veryfast", []string{"-crf", "28"}, ""},
+		{"libx264", "medium", "medium", []string{"-crf", "23"}, ""},
+		{"libx264", "high", "slow", []string{"-crf", "20"}, ""},
+		{"libx264", "archival", "veryslow", []string{"-crf", "16"}, "film"},
+		{"libx265", "archival", "veryslow", []string{"-crf", "18"}, "grain"},
+		{"libsvtav1", "low", "10", []string{"-crf", "34"}, ""},
+		{"libsvtav1", "archival", "2", []string{"-crf", "20"}, "film"},
+		{"h264_nvenc", "medium", "p5", []string{"-cq", "23"}, ""},
+		{"hevc_nvenc", "high", "p7", []string{"-cq", "22"}, "hq"},
+		{"av1_nvenc", "archival", "p7", []string{"-cq", "20"}, "hq"},
+		{"h264_videotoolbox", "high", "", []string{"-q:v", strconv.Itoa(CRFToVideoToolboxQuality(20))}, ""},
+		{"hevc_videotoolbox", "low", "", []string{"-q:v", strconv.Itoa(CRFToVideoToolboxQuality(30))}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoder+"/"+tt.target, func(t *testing.T) {
+			tr := New(Config{InputPath: "in.mp4", OutputDir: "out", QualityTarget: tt.target})
+			args := []string{"-c:v", tt.encoder, "-preset", "p7", "-crf", "23", "-q:v", "65", "-b:v", "5M", "-vf", "scale=1920:1080"}
+			got := tr.applyQualityTarget(args)
+
+			if speed := argValue(got, "-preset"); speed != tt.speed {
+				t.Errorf("-preset = %q, want %q", speed, tt.speed)
+			}
+			qualityFlags := 0
+			for _, arg := range got {
+				switch arg {
+				case "-crf", "-cq", "-q:v":
+					qualityFlags++
+				}
+			}
+			if qualityFlags != 1 || argValue(got, tt.quality[0]) != tt.quality[1] {
+				t.Errorf("args = %v, want only %v for quality", got, tt.quality)
+			}
+			if argValue(got, "-b:v") != "5M" || argValue(got, "-vf") != "scale=1920:1080" {
+				t.Errorf("args = %v, lost unrelated arguments", got)
+			}
+			if tune := tr.effectiveTune(Preset{}, tt.encoder); tune != tt.tune {
+				t.Errorf("effectiveTune() = %q, want %q", tune, tt.tune)
+			}
+			if tt.tune != "" {
+				if _, err := TuneArgs(tt.encoder, tt.tune); err != nil {
+					t.Errorf("target tune is not supported: %v", err)
+				}
+			}
+		})
+	}
+
+	// AMD presets bring their own rate control, which the target replaces
+	amd := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-c:v", "h264_vaapi", "-rc_mode", "VBR", "-b:v", "5M"}, []string{"-c:v", "h264_vaapi", "-b:v", "5M", "-rc_mode", "CQP", "-qp", "20"}},
+		{[]string{"-c:v", "hevc_amf", "-quality", "quality", "-rc", "vbr_peak", "-b:v", "5M"}, []string{"-c:v", "hevc_amf", "-quality", "quality", "-b:v", "5M", "-rc", "cqp", "-qp_i", "22", "-qp_p", "22", "-qp_b", "22"}},
+	}
+	for _, tt := range amd {
+		tr := New(Config{InputPath: "in.mp4", OutputDir: "out", QualityTarget: "high"})
+		if got := tr.applyQualityTarget(tt.args); !slices.Equal(got, tt.want) {
+			t.Errorf("applyQualityTarget(%v) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+
+	// Every encoder covers every target
+	for encoder, targets := range encoderTargets {
+		for _, target := range QualityTargets {
+			if _, ok := targets[target]; !ok {
+				t.Errorf("%s has no settings for quality target %s", encoder, target)
+			}
+		}
+	}
+}
+
+func TestBuildFFmpegArgs_QualityTargetOverrides(t *testing.T) {
+	preset := GetPresets()["1080p_h264"]
+	crf := 19
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", NoGPU: true, QualityTarget: "archival", CRF: &crf, Tune: "grain"})
+	args := tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, false)
+
+	if encoder := argValue(args, "-c:v"); encoder != "libx264" {
+		t.Fatalf("encoder = %s, want libx264", encoder)
+	}
+	if argValue(args, "-preset") != "veryslow" {
+		t.Errorf("args = %v, want the archival speed preset", args)
+	}
+	if argValue(args, "-crf") != "19" {
+		t.Errorf("args = %v, want --crf to override the target CRF", args)
+	}
+	if argValue(args, "-tune") != "grain" {
+		t.Errorf("args = %v, want --tune to override the target tune", args)
+	}
+
+	if err := (&Config{InputPath: "in", OutputDir: "out", QualityTarget: "ultra"}).Validate(); err == nil {
+		t.Error("Validate() accepted an unknown quality target")
+	}
+}
+
+func TestVerifyOutputs(t *testing.T) {
+	sourceDir := t.TempDir()
+	outputDir := t.TempDir()
+	write := func(path string, size int) string {
+		return writeFixture(t, path, make([]byte, size))
+	}
+	probe := func(duration, codec string) string {
+		return `{"format": {"duration": "` + duration + `"}, "streams": [{"codec_type": "video", "codec_name": "` + codec + `", "width": 1920, "height": 1080}]}`
+	}
+
+	tr := New(Config{InputPath: sourceDir, OutputDir: outputDir, SkipValidation: true})
+	source := write(filepath.Join(sourceDir, "movie.mp4"), 10)
+	good := write(filepath.Join(outputDir, "good_1080p_h265.mkv"), 10)
+	moved := write(filepath.Join(outputDir, "Shows", "ep1_720p_av1_crf30.mkv"), 10)
+	wrongCodec := write(filepath.Join(outputDir, "clip_1080p_h265.mkv"), 10)
+	truncated := write(tr.pathUtils.GenerateOutputPath(source, outputDir, sourceDir, tr.presets["1080p_h264"]), 10)
+	empty := write(filepath.Join(outputDir, "empty_1080p_h264.mkv"), 0)
+
+	executor := &pathProbeExecutor{probes: map[string]string{
+		source:     probe("6000", "h264"),
+		good:       probe("1200", "hevc"),
+		moved:      probe("1500", "av1"),
+		wrongCodec: probe("1200", "h264"),
+		truncated:  probe("600", "h264"),
+	}}
+	tr.prober = NewProber(executor)
+	tr.systemChecker = &SystemChecker{executor: executor, platform: PlatformSoftware}
+
+	results, err := tr.VerifyOutputs("", DefaultMinDurationRatio)
+	if err != nil {
+		t.Fatalf("VerifyOutputs() error = %v", err)
+	}
+	got := make(map[string]string)
+	for _, result := range results {
+		got[result.Path] = strings.Join(result.Problems, "; ")
+	}
+	want := map[string]string{
+		good:       "",
+		moved:      "",
+		wrongCodec: "codec h264, expected hevc",
+		truncated:  "truncated (10m0s of 1h40m0s)",
+		empty:      "zero-byte file",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VerifyOutputs() = %v\nwant %v", got, want)
+	}
+
+	// With the run's CSV only its converted files count, and missing ones fail
+	csvPath := filepath.Join(t.TempDir(), "run.csv")
+	csvData := strings.Join([]string{
+		strings.Join(csvHeader, ","),
+		"good.mp4,,,60,100,40,60,0.4,1080p_h265,success,",
+		"gone.mp4,,,60,100,40,60,0.4,1080p_h265,success,",
+		"broken.mp4,,,60,100,0,0,0,1080p_h265,error,",
+	}, "\n") + "\n"
+	if err := os.WriteFile(csvPath, []byte(csvData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err = tr.VerifyOutputs(csvPath, DefaultMinDurationRatio)
+	if err != nil {
+		t.Fatalf("VerifyOutputs() with CSV error = %v", err)
+	}
+	if len(results) != 2 || results[0].Path != filepath.Join(outputDir, "gone_1080p_h265.mkv") || results[0].Passed() ||
+		results[1].Path != good || !results[1].Passed() {
+		t.Errorf("VerifyOutputs() with CSV = %+v, want good passing and gone missing", results)
+	}
+}
+
+func TestBuildAudioArgs(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		sources      []string
+		mapsAllAudio bool
+		want         []string
+	}{
+		{"copy requested", "copy", []string{"dts"}, false, []string{"-c:a", "copy"}},
+		{"default is copy", "", []string{"dts"}, false, []string{"-c:a", "copy"}},
+		{"already aac", "aac", []string{"aac"}, false, []string{"-c:a", "copy"}},
+		{"every track aac", "aac", []string{"aac", "aac"}, true, []string{"-c:a", "copy"}},
+		{"dts to aac", "aac", []string{"dts"}, false, []string{"-c:a", "aac", "-b:a", "128k"}},
+		{"encoder name maps to codec", "libmp3lame", []string{"mp3"}, false, []string{"-c:a", "copy"}},
+		{"codec names ignore case", "AC3", []string{"ac3"}, false, []string{"-c:a", "copy"}},
+		{"mixed tracks all mapped", "aac", []string{"aac", "dts", "aac"}, true,
+			[]string{"-c:a:0", "copy", "-c:a:1", "aac", "-b:a:1", "128k", "-c:a:2", "copy"}},
+		{"mixed tracks default mapping", "aac", []string{"dts", "aac"}, false, []string{"-c:a", "aac", "-b:a", "128k"}},
+		{"no probe data", "aac", nil, true, []string{"-c:a", "aac", "-b:a", "128k"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildAudioArgs(tt.target, DefaultAudioBitrate, "", tt.sources, tt.mapsAllAudio); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildAudioArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildFFmpegArgs_CopiesMatchingAudio(t *testing.T) {
+	probes := map[string]string{
+		"/in/aac.mp4": `{"streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}, {"codec_type": "audio", "codec_name": "aac"}], "format": {"duration": "60"}}`,
+		"/in/dts.mkv": `{"streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}, {"codec_type": "audio", "codec_name": "dts"}], "format": {"duration": "60"}}`,
+	}
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, AudioCodec: "aac"})
+	tr.prober = NewProber(&pathProbeExecutor{probes: probes})
+	preset := tr.presets["1080p_h264"]
+
+	args := tr.buildFFmpegArgs("/in/aac.mp4", "/out/aac.mkv", preset, false)
+	if argValue(args, "-c:v") != "libx264" || argValue(args, "-c:a") != "copy" || argValue(args, "-b:a") != "" {
+		t.Errorf("buildFFmpegArgs() for aac source = %v, want video encoded and audio copied", args)
+	}
+	args = tr.buildFFmpegArgs("/in/dts.mkv", "/out/dts.mkv", preset, false)
+	if argValue(args, "-c:a") != "aac" || argValue(args, "-b:a") != "128k" {
+		t.Errorf("buildFFmpegArgs() for dts source = %v, want audio encoded to aac", args)
+	}
+}
+
+func TestFormatSizeChange(t *testing.T) {
+	const mib = int64(1 << 20)
+	tests := []struct {
+		name          string
+		before, after int64
+		style         string
+		want          string
+	}{
+		{"saved", 100 * mib, 40 * mib, RatioStyleSaved, "saved 60.0% (60.0 MiB)"},
+		{"default style is saved", 100 * mib, 40 * mib, "", "saved 60.0% (60.0 MiB)"},
+		{"percent of original", 100 * mib, 40 * mib, RatioStyleOriginal, "40.0% of original size"},
+		{"grew", 100 * mib, 110 * mib, RatioStyleSaved, "grew 10.0% (10.0 MiB)"},
+		{"zero source", 0, 40 * mib, RatioStyleSaved, "source size unknown"},
+		{"zero source as original", 0, 40 * mib, RatioStyleOriginal, "source size unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FormatSizeChange(tt.before, tt.after, tt.style)
+			if got != tt.want {
+				t.Errorf("FormatSizeChange(%d, %d, %q) = %q, want %q", tt.before, tt.after, tt.style, got, tt.want)
+			}
+			if strings.Contains(got, "NaN") || strings.Contains(got, "Inf") {
+				t.Errorf("FormatSizeChange() = %q, want no NaN or Inf", got)
+			}
+		})
+	}
+
+	record := AnalyticsRecord{SizeBeforeMB: 0, SizeAfterMB: 12}
+	if record.CompressionRatio() != 0 || record.SpaceSavedPercent() != 0 {
+		t.Errorf("zero-size source ratio = %v, saved = %v, want 0 and 0", record.CompressionRatio(), record.SpaceSavedPercent())
+	}
+	row := record.csvRow()
+	for _, field := range row {
+		if strings.Contains(field, "NaN") || strings.Contains(field, "Inf") {
+			t.Errorf("csvRow() = %v, want no NaN or Inf", row)
+		}
+	}
+	if saved := (AnalyticsRecord{SizeBeforeMB: 200, SizeAfterMB: 50}).SpaceSavedPercent(); saved != 75 {
+		t.Errorf("SpaceSavedPercent() = %v, want 75", saved)
+	}
+}
+
+func TestRegisterEncoderAndPreset(t *testing.T) {
+	t.Cleanup(func() {
+		registry.Lock()
+		delete(registry.fallbacks, "asic_hevc")
+		delete(registry.presets, "1080p_asic")
+		registry.Unlock()
+	})
+
+	if err := RegisterEncoder("asic_hevc", SoftwareFallback{Encoder: "libx265", Speed: "slow"}); err != nil {
+		t.Fatalf("RegisterEncoder() error = %v", err)
+	}
+	if err := RegisterEncoder("asic_hevc", SoftwareFallback{Encoder: "libx265"}); err == nil {
+		t.Error("RegisterEncoder() twice expected an error")
+	}
+	if err := RegisterEncoder("hevc_nvenc", SoftwareFallback{Encoder: "libx265"}); err == nil {
+		t.Error("RegisterEncoder() of a built-in encoder expected an error")
+	}
+	if err := RegisterEncoder("asic_av1", SoftwareFallback{Encoder: "libvpx"}); err == nil {
+		t.Error("RegisterEncoder() with an unsupported fallback expected an error")
+	}
+
+	preset := Preset{
+		Name:       "1080p_asic",
+		Resolution: "1920x1080",
+		Codec:      "H.265",
+		Encoder:    "asic_hevc",
+		Bitrate:    "4M",
+		Args:       []string{"-c:v", "asic_hevc", "-b:v", "4M", "-vf", "scale=1920:1080"},
+	}
+	if err := RegisterPreset(Preset{Name: "bad", Encoder: "asic_hevc", Args: []string{"-c:v", "libx264"}}); err == nil {
+		t.Error("RegisterPreset() with args for another encoder expected an error")
+	}
+	if err := RegisterPreset(Preset{Name: "1080p_h264", Encoder: "asic_hevc", Args: []string{"-c:v", "asic_hevc"}}); err == nil {
+		t.Error("RegisterPreset() over a built-in preset expected an error")
+	}
+	if err := RegisterPreset(preset); err != nil {
+		t.Fatalf("RegisterPreset() error = %v", err)
+	}
+	if err := RegisterPreset(preset); err == nil {
+		t.Error("RegisterPreset() twice expected an error")
+	}
+
+	if !IsValidPreset("1080p_asic") {
+		t.Error("IsValidPreset() = false for a registered preset")
+	}
+	if _, ok := GetPresets()["1080p_asic"]; !ok {
+		t.Error("GetPresets() is missing the registered preset")
+	}
+	available := GetAvailablePresets()
+	if available[len(available)-1] != "1080p_asic" {
+		t.Errorf("GetAvailablePresets() = %v, want the registered preset last", available)
+	}
+
+	// End to end: a Transcoder created after registration encodes with the
+	// custom encoder, and falls back to its registered software encoder
+	executor := &MockCommandExecutor{output: `{"streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}], "format": {"duration": "60"}}`}
+	tr := New(Config{InputPath: "/in/movie.mp4", OutputDir: "/out", Preset: "1080p_asic"})
+	tr.systemChecker = &SystemChecker{executor: executor, platform: PlatformNVIDIA}
+	tr.prober = NewProber(executor)
+
+	got, err := tr.presetFor("/in/movie.mp4")
+	if err != nil {
+		t.Fatalf("presetFor() error = %v", err)
+	}
+	if output := tr.pathUtils.GenerateOutputPath("/in/movie.mp4", "/out", "", got); filepath.Base(output) != "movie_1080p_asic.mkv" {
+		t.Errorf("GenerateOutputPath() = %s, want movie_1080p_asic.mkv", output)
+	}
+	args := tr.buildFFmpegArgs("/in/movie.mp4", "/out/movie_1080p_asic.mkv", got, tr.useHardware(got))
+	if argValue(args, "-c:v") != "asic_hevc" || argValue(args, "-b:v") != "4M" {
+		t.Errorf("buildFFmpegArgs() hardware = %v, want asic_hevc at 4M", args)
+	}
+	args = tr.buildFFmpegArgs("/in/movie.mp4", "/out/movie_1080p_asic.mkv", got, false)
+	if argValue(args, "-c:v") != "libx265" || argValue(args, "-preset") != "slow" || argValue(args, "-vf") != "scale=1920:1080" {
+		t.Errorf("buildFFmpegArgs() software = %v, want libx265 slow at 1080p", args)
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	var order []int
+	work := func(i int) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		mu.Lock()
+		order = append(order, i)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+	}
+
+	if started := runJobs(8, 3, func(int) bool { return true }, work); started != 8 {
+		t.Errorf("runJobs() started %d, want 8", started)
+	}
+	if peak.Load() > 3 {
+		t.Errorf("runJobs() ran %d at once, want at most 3", peak.Load())
+	}
+	if len(order) != 8 {
+		t.Errorf("runJobs() ran %v, want 8 indexes", order)
+	}
+
+	// With one job, next is asked only after the previous index finished
+	order = nil
+	started := runJobs(5, 1, func(i int) bool {
+		if running.Load() != 0 {
+			t.Errorf("next(%d) asked while work was running", i)
+		}
+		return i < 3
+	}, work)
+	if started != 3 || !reflect.DeepEqual(order, []int{0, 1, 2}) {
+		t.Errorf("runJobs() started %d, ran %v; want 3 and [0 1 2]", started, order)
+	}
+}
+
+func TestProcessFilesWithProgress_Parallel(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	names := []string{"a.mp4", "bad_b.mp4", "c.mp4", "d.mp4", "bad_e.mp4", "f.mp4"}
+	var files []string
+	for _, name := range names {
+		file := filepath.Join(inputDir, name)
+		if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+
+	tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: "1080p_h264", NoGPU: true, NoProbe: true, Parallelism: 3})
+	tr.prober = NewProber(&MockCommandExecutor{shouldFail: true})
+	// Stand in for ffmpeg: the input check passes, files later in the list
+	// finish first, and "bad" files fail to encode
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		output := args[len(args)-1]
+		if output == "-" {
+			return exec.CommandContext(ctx, "sh", "-c", "exit 0")
+		}
+		input := argValue(args, "-i")
+		delay := float64(len(names)-slices.Index(files, input)) * 0.03
+		if strings.Contains(input, "bad") {
+			return exec.CommandContext(ctx, "sh", "-c", fmt.Sprintf("sleep %.2f; exit 1", delay))
+		}
+		return exec.CommandContext(ctx, "sh", "-c", fmt.Sprintf("sleep %.2f; : > \"$0\"", delay), output)
+	}
+
+	var csvOut strings.Builder
+	writer := csv.NewWriter(&csvOut)
+	var summary *BatchSummary
+	var err error
+	stdout := captureStdout(t, func() { summary, err = tr.ProcessFilesWithProgress(context.Background(), files, writer) })
+	if err == nil {
+		t.Fatal("ProcessFilesWithProgress() error = nil, want errors for the bad files")
+	}
+
+	// The summary counts every file and names the failures in file order
+	if summary.Files != 6 || summary.Succeeded != 4 || summary.Failed != 2 || summary.Skipped != 0 || summary.InputSize != 4 {
+		t.Errorf("summary = %+v, want 4 of 6 files encoded from 4 bytes", summary)
+	}
+	if len(summary.Failures) != 2 || filepath.Base(summary.Failures[0].Path) != "bad_b.mp4" || summary.Failures[1].Type != ErrorTypeEncodingFailed {
+		t.Errorf("summary failures = %+v", summary.Failures)
+	}
+	if table := stdout[strings.Index(stdout, "Batch summary:"):]; !strings.Contains(table, "Succeeded      4") ||
+		!slices.Equal(strings.Fields(table[strings.Index(table, "bad_e.mp4"):])[:2], []string{"bad_e.mp4", "encoding_failed"}) {
+		t.Errorf("summary table:\n%s", table)
+	}
+
+	// Errors are listed in file order, not completion order
+	list := stdout[strings.Index(stdout, "Completed with 2 error(s)"):]
+	if first, second := strings.Index(list, "bad_b.mp4"), strings.Index(list, "bad_e.mp4"); first < 0 || second < first {
+		t.Errorf("error list out of file order:\n%s", list)
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(csvOut.String())).ReadAll()
+	if err != nil || len(rows) != len(files) {
+		t.Fatalf("analytics rows = %d (%v), want %d intact rows", len(rows), err, len(files))
+	}
+	for _, row := range rows {
+		if len(row) != len(csvHeader) {
+			t.Errorf("analytics row %v has %d fields, want %d", row, len(row), len(csvHeader))
+		}
+	}
+	for _, name := range []string{"a", "c", "d", "f"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name+"_1080p_h264.mkv")); err != nil {
+			t.Errorf("output for %s missing: %v", name, err)
+		}
+	}
+}
+
+func TestJobCount(t *testing.T) {
+	tr := New(Config{InputPath: "in", OutputDir: "out", Preset: "1080p_h264", Parallelism: 8})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+	if got := tr.jobCount(20); got != 8 {
+		t.Errorf("jobCount() software = %d, want 8", got)
+	}
+	if got := tr.jobCount(2); got != 2 {
+		t.Errorf("jobCount() for 2 files = %d, want 2", got)
+	}
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformNVIDIA}
+	if got := tr.jobCount(20); got != maxHardwareJobs {
+		t.Errorf("jobCount() NVENC = %d, want %d", got, maxHardwareJobs)
+	}
+	tr.config.NoGPU = true
+	if got := tr.jobCount(20); got != 8 {
+		t.Errorf("jobCount() with --no-gpu = %d, want 8", got)
+	}
+	if got := New(Config{InputPath: "in", OutputDir: "out"}).jobCount(5); got != 1 {
+		t.Errorf("jobCount() default = %d, want 1", got)
+	}
+}
+
+// captureStdout returns what fn prints to standard output
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	os.Stdout = saved
+	w.Close()
+	return <-done
+}
+
+// writeFixture writes a test file, creating its directories, and returns its
+// path
+func writeFixture(t *testing.T, path string, data []byte) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPresetsFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		return writeFixture(t, filepath.Join(dir, name), []byte(content))
+	}
+
+	yamlPath := write("presets.yaml", `
+- name: 1080p_h264
+  codec: H.264
+  encoder: libx264
+  resolution: 1920x1080
+  args: [-c:v, libx264, -preset, slow, -crf, "20", -vf, "scale=1920:1080"]
+- name: anime_x265
+  codec: H.265
+  encoder: libx265
+  platform: software
+  args: [-c:v, libx265, -crf, "19", -tune, animation]
+`)
+	presets, err := ParsePresetsFile(yamlPath)
+	if err != nil {
+		t.Fatalf("ParsePresetsFile() error = %v", err)
+	}
+	if len(presets) != 2 || presets[1].Name != "anime_x265" || presets[1].Platform != PlatformSoftware ||
+		!reflect.DeepEqual(presets[1].Args, []string{"-c:v", "libx265", "-crf", "19", "-tune", "animation"}) {
+		t.Errorf("ParsePresetsFile() = %+v", presets)
+	}
+
+	jsonPath := write("presets.json", `[{"name": "fast", "encoder": "libx264", "args": ["-c:v", "libx264", "-preset", "veryfast"]}]`)
+	if presets, err := ParsePresetsFile(jsonPath); err != nil || len(presets) != 1 || presets[0].Platform != PlatformUnknown {
+		t.Errorf("ParsePresetsFile() JSON = %+v, %v", presets, err)
+	}
+
+	invalid := []struct {
+		name, content, want string
+	}{
+		{"missing.json", `[{"name": "ok", "encoder": "libx264", "args": ["-c:v", "libx264"]}, {"encoder": "libx264"}]`, "preset 2 in"},
+		{"missing-fields.json", `[{"name": "bare"}]`, "is missing encoder, args"},
+		{"platform.json", `[{"name": "p", "encoder": "x", "args": ["-c:v", "x"], "platform": "tpu"}]`, "unknown platform 'tpu'"},
+		{"twice.yaml", "- {name: a, encoder: x, args: [-c:v, x]}\n- {name: a, encoder: x, args: [-c:v, x]}\n", "defined twice"},
+		{"object.json", `{"name": "a"}`, "must hold a list of presets"},
+		{"typo.json", `[{"name": "a", "encodr": "x"}]`, "must hold a list of presets"},
+	}
+	for _, tt := range invalid {
+		_, err := ParsePresetsFile(write(tt.name, tt.content))
+		var transcoderErr *TranscoderError
+		if !errors.As(err, &transcoderErr) || transcoderErr.Type != ErrorTypeInvalidPreset || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParsePresetsFile(%s) error = %v, want invalid preset error containing %q", tt.name, err, tt.want)
+		}
+	}
+	if _, err := ParsePresetsFile(filepath.Join(dir, "absent.json")); err == nil {
+		t.Error("ParsePresetsFile() expected an error for a missing file")
+	}
+
+	// Loaded presets join the built-in ones and replace those of the same name
+	t.Cleanup(func() {
+		registry.Lock()
+		registry.user = nil
+		registry.Unlock()
+	})
+	if err := LoadPresetsFile(yamlPath); err != nil {
+		t.Fatalf("LoadPresetsFile() error = %v", err)
+	}
+	if got := GetPresets()["1080p_h264"]; got.Encoder != "libx264" || argValue(got.Args, "-crf") != "20" {
+		t.Errorf("GetPresets() 1080p_h264 = %+v, want the file's version", got)
+	}
+	if !IsValidPreset("anime_x265") || !IsUserPreset("anime_x265") || !IsUserPreset("1080p_h264") || IsUserPreset("720p_h264") {
+		t.Error("user presets not reported as valid and from the file")
+	}
+	if names := GetAvailablePresets(); !slices.Contains(names, "anime_x265") || len(names) != len(presetNames)+1 {
+		t.Errorf("GetAvailablePresets() = %v, want the built-in names plus anime_x265", names)
+	}
+
+	// A second file replaces the first
+	if err := LoadPresetsFile(jsonPath); err != nil {
+		t.Fatalf("LoadPresetsFile() error = %v", err)
+	}
+	if IsValidPreset("anime_x265") || !IsValidPreset("fast") {
+		t.Error("presets of the earlier file still loaded")
+	}
+}
+
+func TestTwoPassArgs(t *testing.T) {
+	args := []string{"-hide_banner", "-i", "in.mp4", "-c:v", "libx264", "-b:v", "5M", "-c:a", "copy", "-f", "matroska", "-y", "out.mp4"}
+	first, second := twoPassArgs(args, "/tmp/log/in")
+	wantFirst := []string{"-hide_banner", "-i", "in.mp4", "-c:v", "libx264", "-b:v", "5M", "-c:a", "copy",
+		"-pass", "1", "-passlogfile", "/tmp/log/in", "-an", "-sn", "-f", "null", "-y", os.DevNull}
+	wantSecond := []string{"-hide_banner", "-i", "in.mp4", "-c:v", "libx264", "-b:v", "5M", "-c:a", "copy",
+		"-pass", "2", "-passlogfile", "/tmp/log/in", "-f", "matroska", "-y", "out.mp4"}
+	if !reflect.DeepEqual(first, wantFirst) {
+		t.Errorf("first pass = %v, want %v", first, wantFirst)
+	}
+	if !reflect.DeepEqual(second, wantSecond) {
+		t.Errorf("second pass = %v, want %v", second, wantSecond)
+	}
+
+	// libx265 gets its passes through -x265-params, extending any set already
+	args = []string{"-i", "in.mkv", "-c:v", "libx265", "-x265-params", "aq-mode=3", "-b:v", "4M", "-y", "out.mkv"}
+	first, second = twoPassArgs(args, "log")
+	if got := argValue(first, "-x265-params"); got != "aq-mode=3:pass=1:stats=log" {
+		t.Errorf("first pass -x265-params = %q", got)
+	}
+	if got := argValue(second, "-x265-params"); got != "aq-mode=3:pass=2:stats=log" || slices.Contains(second, "-pass") {
+		t.Errorf("second pass = %v", second)
+	}
+	if args[5] != "aq-mode=3" {
+		t.Error("twoPassArgs() modified its input")
+	}
+
+	// A Windows path is escaped so its drive colon doesn't end the option
+	first, _ = twoPassArgs(args, `C:\Temp\ffmcli-2pass\in`)
+	if got := argValue(first, "-x265-params"); got != `aq-mode=3:pass=1:stats=C\:\\Temp\\ffmcli-2pass\\in` {
+		t.Errorf("first pass -x265-params = %q", got)
+	}
+}
+
+func TestBuildFFmpegArgs_TwoPass(t *testing.T) {
+	softwareChecker := &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+
+	tr := New(Config{InputPath: "in", OutputDir: "out", Preset: "1080p_h264", NoGPU: true, NoProbe: true, NoToolMetadata: true, TwoPass: true})
+	tr.systemChecker = softwareChecker
+	preset := tr.presets["1080p_h264"]
+	args := tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, false)
+	if slices.Contains(args, "-crf") || argValue(args, "-b:v") != preset.Bitrate {
+		t.Errorf("two-pass software args = %v, want the bitrate without -crf", args)
+	}
+
+	// NVENC runs both passes itself
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformNVIDIA}
+	tr.config.NoGPU = false
+	nvenc := Preset{Name: "n", Codec: "H.264", Encoder: "h264_nvenc", Bitrate: "5M", Platform: PlatformNVIDIA,
+		Args: []string{"-c:v", "h264_nvenc", "-cq", "23", "-b:v", "5M"}}
+	if args := tr.buildFFmpegArgs("in.mp4", "out.mkv", nvenc, true); argValue(args, "-multipass") != "fullres" {
+		t.Errorf("two-pass NVENC args = %v, want -multipass fullres", args)
+	}
+
+	// A preset without a bitrate, or an explicit CRF, stays single pass
+	noBitrate := Preset{Name: "q", Codec: "H.264", Encoder: "libx264", Args: []string{"-c:v", "libx264", "-crf", "20"}}
+	if tr.usesTwoPass(noBitrate, noBitrate.Args) {
+		t.Error("usesTwoPass() = true for a preset without a bitrate")
+	}
+	crf := 20
+	tr = New(Config{InputPath: "in", OutputDir: "out", Preset: "1080p_h264", NoGPU: true, CRF: &crf})
+	tr.systemChecker = softwareChecker
+	if args := tr.buildFFmpegArgs("in.mp4", "out.mkv", Preset{Name: "p", Codec: "H.264", Encoder: "libx264", Bitrate: "5M", TwoPass: true,
+		Args: []string{"-c:v", "libx264", "-crf", "23", "-b:v", "5M"}}, false); !slices.Contains(args, "-crf") {
+		t.Errorf("two-pass preset with --crf = %v, want a single CRF pass", args)
+	}
+	if err := (&Config{InputPath: "in", OutputDir: "out", Preset: "1080p_h264", TwoPass: true, CRF: &crf}).Validate(); err == nil {
+		t.Error("Validate() accepted --two-pass with --crf")
+	}
+}
+
+func TestProcessFilesWithProgress_TwoPass(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	tempDir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		file := filepath.Join(inputDir, name)
+		if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+
+	tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: "1080p_h264", NoGPU: true, NoProbe: true,
+		TwoPass: true, Parallelism: 2, TempDir: tempDir})
+	tr.prober = NewProber(&MockCommandExecutor{shouldFail: true})
+	var mu sync.Mutex
+	var encodes [][]string
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		output := args[len(args)-1]
+		if output == "-" {
+			return exec.CommandContext(ctx, "sh", "-c", "exit 0")
+		}
+		mu.Lock()
+		encodes = append(encodes, args)
+		mu.Unlock()
+		// The second pass needs the first pass's log
+		if argValue(args, "-pass") == "1" {
+			return exec.CommandContext(ctx, "sh", "-c", `: > "$0"`, argValue(args, "-passlogfile"))
+		}
+		return exec.CommandContext(ctx, "sh", "-c", `test -f "$0" && : > "$1"`, argValue(args, "-passlogfile"), output)
+	}
+
+	captureStdout(t, func() {
+		if _, err := tr.ProcessFilesWithProgress(context.Background(), files, nil); err != nil {
+			t.Errorf("ProcessFilesWithProgress() error = %v", err)
+		}
+	})
+
+	if len(encodes) != 4 {
+		t.Fatalf("ffmpeg ran %d encodes, want two passes per file", len(encodes))
+	}
+	passLogs := make(map[string][]string)
+	for _, args := range encodes {
+		passLogs[argValue(args, "-i")] = append(passLogs[argValue(args, "-i")], argValue(args, "-pass")+" "+argValue(args, "-passlogfile"))
+	}
+	if logA, logB := passLogs[files[0]], passLogs[files[1]]; len(logA) != 2 || len(logB) != 2 ||
+		strings.TrimPrefix(logA[0], "1 ") != strings.TrimPrefix(logA[1], "2 ") || logA[0][2:] == logB[0][2:] {
+		t.Errorf("pass logs = %v, want passes 1 and 2 sharing a log per file, different across files", passLogs)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name+"_1080p_h264.mkv")); err != nil {
+			t.Errorf("output for %s missing: %v", name, err)
+		}
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("temp directory not cleaned up: %v", entries)
+	}
+}
+
+func TestEncoderSpeedArgs(t *testing.T) {
+	tests := []struct {
+		encoder, speed, want string
+	}{
+		{"libx264", "slow", "slow"},
+		{"libx265", "placebo", "placebo"},
+		{"h264_nvenc", "veryslow", "p7"},
+		{"hevc_nvenc", "medium", "p4"},
+		{"av1_nvenc", "P6", "p6"},
+		{"libsvtav1", "ultrafast", "12"},
+		{"libsvtav1", "4", "4"},
+		{"hevc_qsv", "ultrafast", "veryfast"},
+		{"h264_qsv", "slower", "slower"},
+	}
+	for _, tt := range tests {
+		args, err := EncoderSpeedArgs(tt.encoder, tt.speed)
+		if err != nil || argValue(args, "-preset") != tt.want {
+			t.Errorf("EncoderSpeedArgs(%s, %s) = %v, %v; want -preset %s", tt.encoder, tt.speed, args, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct{ encoder, speed string }{
+		{"h264_videotoolbox", "slow"},
+		{"libx264", "p7"},
+		{"h264_nvenc", "p8"},
+		{"libsvtav1", "14"},
+		{"h264_qsv", "p7"},
+		{"libx264", "quick"},
+	} {
+		if _, err := EncoderSpeedArgs(tt.encoder, tt.speed); err == nil {
+			t.Errorf("EncoderSpeedArgs(%s, %s) expected an error", tt.encoder, tt.speed)
+		}
+	}
+}
+
+func TestApplyEncoderSpeed(t *testing.T) {
+	tr := New(Config{InputPath: "in", OutputDir: "out", Preset: "1080p_h264", EncoderSpeed: "slower"})
+	args := tr.applyEncoderSpeed([]string{"-c:v", "h264_nvenc", "-preset", "p7", "-cq", "23", "-b:v", "5M"})
+	if want := []string{"-c:v", "h264_nvenc", "-cq", "23", "-b:v", "5M", "-preset", "p6"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("applyEncoderSpeed() = %v, want %v", args, want)
+	}
+
+	// The software fallback gets the same speed, translated
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+	preset := tr.presets["1080p_h264"]
+	if got := argValue(tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, false), "-preset"); got != "slower" {
+		t.Errorf("software -preset = %q, want slower", got)
+	}
+
+	// An encoder-specific value is kept out of encoders it doesn't fit
+	tr = New(Config{InputPath: "in", OutputDir: "out", Preset: "1080p_h264", EncoderSpeed: "p3"})
+	args = []string{"-c:v", "libx264", "-preset", "medium"}
+	if got := tr.applyEncoderSpeed(args); !reflect.DeepEqual(got, args) {
+		t.Errorf("applyEncoderSpeed() = %v, want the arguments unchanged", got)
+	}
+
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformAppleSilicon}
+	tr.presets = map[string]Preset{"vt": {Name: "vt", Codec: "H.264", Encoder: "h264_videotoolbox", Platform: PlatformAppleSilicon,
+		Args: []string{"-c:v", "h264_videotoolbox", "-q:v", "65"}}}
+	tr.config.Preset = "vt"
+	if err := tr.ValidateEncoderSpeed(); err == nil || !strings.Contains(err.Error(), "no speed presets") {
+		t.Errorf("ValidateEncoderSpeed() for VideoToolbox = %v, want an error", err)
+	}
+}
+
+func TestSkipUpscale(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		width, height int
+		want          []string
+		skipped       bool
+	}{
+		{"smaller source keeps its size", []string{"-c:v", "libx264", "-vf", "scale=1280:720", "-b:v", "3M"}, 854, 480,
+			[]string{"-c:v", "libx264", "-b:v", "3M"}, true},
+		{"same size needs no scaling", []string{"-vf", "scale=1280:720"}, 1280, 720, []string{}, true},
+		{"narrower source keeps its size", []string{"-vf", "scale=1920:1080"}, 1440, 1080, []string{}, true},
+		{"larger source is scaled down", []string{"-vf", "scale=1280:720"}, 1920, 1080, []string{"-vf", "scale=1280:720"}, false},
+		{"wider source is scaled down", []string{"-vf", "scale=1920:1080"}, 2560, 1080, []string{"-vf", "scale=1920:1080"}, false},
+		{"other filters kept", []string{"-vf", "yadif,scale=1280:720:flags=lanczos"}, 720, 576, []string{"-vf", "yadif"}, true},
+		{"automatic dimensions untouched", []string{"-vf", "scale=-1:-1"}, 640, 360, []string{"-vf", "scale=-1:-1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := filterChainOf(tt.args)
+			original := slices.Clone(chain)
+			kept, skipped := skipUpscale(chain, tt.width, tt.height)
+			if got := withFilterChain(tt.args, kept); skipped != tt.skipped || !slices.Equal(got, tt.want) {
+				t.Errorf("skipUpscale() = %v, %v; want %v, %v", got, skipped, tt.want, tt.skipped)
+			}
+			if !slices.Equal(chain, original) {
+				t.Errorf("skipUpscale() modified its input: %v", chain)
+			}
+		})
+	}
+}
+
+func TestBuildFFmpegArgs_NoUpscale(t *testing.T) {
+	probes := map[string]string{
+		"/in/sd.mp4":       `{"streams": [{"codec_type": "video", "codec_name": "mpeg2video", "width": 640, "height": 480}], "format": {"duration": "60"}}`,
+		"/in/uhd.mp4":      `{"streams": [{"codec_type": "video", "codec_name": "hevc", "width": 3840, "height": 2160}], "format": {"duration": "60"}}`,
+		"/in/portrait.mp4": `{"streams": [{"codec_type": "video", "codec_name": "h264", "width": 720, "height": 1280}], "format": {"duration": "60"}}`,
+	}
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, NoUpscale: true, AutoOrient: true})
+	tr.prober = NewProber(&pathProbeExecutor{probes: probes})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+	preset := tr.presets["720p_h264"]
+
+	if args := tr.buildFFmpegArgs("/in/sd.mp4", "/out/sd.mkv", preset, false); slices.Contains(args, "-vf") {
+		t.Errorf("buildFFmpegArgs() for a 480p source = %v, want no scaling", args)
+	}
+	if vf := argValue(tr.buildFFmpegArgs("/in/uhd.mp4", "/out/uhd.mkv", preset, false), "-vf"); vf != "scale=1280:720" {
+		t.Errorf("buildFFmpegArgs() for a 4K source -vf = %q, want scale=1280:720", vf)
+	}
+	// Orientation is matched first, so a portrait source at the preset size is not scaled
+	if args := tr.buildFFmpegArgs("/in/portrait.mp4", "/out/portrait.mkv", preset, false); slices.Contains(args, "-vf") {
+		t.Errorf("buildFFmpegArgs() for a 720x1280 source = %v, want no scaling", args)
+	}
+
+	// Without the flag the preset scaling always applies
+	tr.config.NoUpscale = false
+	if vf := argValue(tr.buildFFmpegArgs("/in/sd.mp4", "/out/sd.mkv", preset, false), "-vf"); vf != "scale=1280:720" {
+		t.Errorf("buildFFmpegArgs() without --no-upscale -vf = %q, want scale=1280:720", vf)
+	}
+}
+
+func TestDeleteSource(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	probe := func(duration string) string {
+		return `{"format": {"duration": "` + duration + `"}, "streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}]}`
+	}
+	probes := make(map[string]string)
+	newInput := func(name, sourceDuration, outputDuration string) string {
+		input := filepath.Join(inputDir, name+".mp4")
+		if err := os.WriteFile(input, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		probes[input] = probe(sourceDuration)
+		probes[filepath.Join(outputDir, name+"_1080p_h264.mkv")] = probe(outputDuration)
+		return input
+	}
+	complete := newInput("complete", "600", "599.5")
+	truncated := newInput("truncated", "600", "420")
+
+	tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: "1080p_h264", NoGPU: true, NoProbe: true, DeleteSource: true})
+	executor := &pathProbeExecutor{probes: probes}
+	tr.prober = NewProber(executor)
+	tr.systemChecker = &SystemChecker{executor: executor, platform: PlatformSoftware}
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `[ "$0" = - ] || echo encoded > "$0"`, args[len(args)-1])
+	}
+
+	output := captureStdout(t, func() {
+		for _, input := range []string{complete, truncated} {
+			if _, err := tr.processFile(context.Background(), input, nil); err != nil {
+				t.Errorf("processFile(%s) error = %v", input, err)
+			}
+		}
+	})
+	if _, err := os.Stat(complete); !os.IsNotExist(err) {
+		t.Errorf("source with a verified output not deleted: %v", err)
+	}
+	if !strings.Contains(output, "Deleted source "+complete) {
+		t.Errorf("deletion not logged:\n%s", output)
+	}
+	if _, err := os.Stat(truncated); err != nil {
+		t.Errorf("source of a truncated output deleted: %v", err)
+	}
+	if !strings.Contains(output, "Keeping source "+truncated+": output runs") {
+		t.Errorf("kept source not explained:\n%s", output)
+	}
+
+	// Degraded fallbacks and dry runs never delete
+	kept := newInput("kept", "600", "600")
+	result := &FileResult{InputPath: kept, OutputPath: filepath.Join(outputDir, "kept_1080p_h264.mkv"), EncodingMode: EncodingModeSafeFallback}
+	if err := os.WriteFile(result.OutputPath, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if problem := tr.sourceDeletionProblem(result); problem == "" {
+		t.Error("sourceDeletionProblem() allowed deleting after a safe fallback encode")
+	}
+	result.EncodingMode = EncodingModeSoftwareFallback
+	if problem := tr.sourceDeletionProblem(result); problem != "" {
+		t.Errorf("sourceDeletionProblem() after a software fallback = %q, want none", problem)
+	}
+	tr.config.DryRun = true
+	if problem := tr.sourceDeletionProblem(result); problem != "dry run" {
+		t.Errorf("sourceDeletionProblem() in a dry run = %q", problem)
+	}
+	tr.config.DryRun = false
+
+	// A segment whose length is within the tolerance is still not the source
+	tr.config.TrimStart = 30 * time.Second
+	if problem := tr.sourceDeletionProblem(result); problem != "output is a trimmed segment" {
+		t.Errorf("sourceDeletionProblem() with --start = %q", problem)
+	}
+	tr.config.TrimStart = 0
+
+	result.OutputPath = kept
+	if problem := tr.sourceDeletionProblem(result); problem != "output is the same file" {
+		t.Errorf("sourceDeletionProblem() for output == input = %q", problem)
+	}
+}
+
+func TestBuildFFmpegArgs_AudioTrack(t *testing.T) {
+	probes := map[string]string{
+		"/in/movie.mkv": `{"format": {"format_name": "matroska,webm", "duration": "60"}, "streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080},
+			{"codec_type": "audio", "codec_name": "truehd"}, {"codec_type": "audio", "codec_name": "aac"}, {"codec_type": "subtitle", "codec_name": "hdmv_pgs_subtitle"}]}`,
+		"/in/clip.mp4": `{"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "60"}, "streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080},
+			{"codec_type": "audio", "codec_name": "aac"}]}`,
+	}
+	newTranscoder := func(track string) *Transcoder {
+		tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, AudioCodec: "aac", AudioTrack: track, NoAutoSubtitles: true})
+		tr.prober = NewProber(&pathProbeExecutor{probes: probes})
+		tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+		return tr
+	}
+	maps := func(args []string) []string {
+		var selected []string
+		for i := 0; i+1 < len(args); i++ {
+			if args[i] == "-map" {
+				selected = append(selected, args[i+1])
+			}
+		}
+		return selected
+	}
+
+	tr := newTranscoder("")
+	preset := tr.presets["1080p_h264"]
+	if args := tr.buildFFmpegArgs("/in/movie.mkv", "/out/movie.mkv", preset, false); len(maps(args)) != 0 {
+		t.Errorf("buildFFmpegArgs() without --audio-track maps %v, want ffmpeg's default selection", maps(args))
+	}
+
+	// The second track is already AAC, so it is copied
+	args := newTranscoder("1").buildFFmpegArgs("/in/movie.mkv", "/out/movie.mkv", preset, false)
+	if got, want := maps(args), []string{"0:v:0", "0:a:1", "0:s?"}; !slices.Equal(got, want) {
+		t.Errorf("--audio-track 1 maps %v, want %v", got, want)
+	}
+	if argValue(args, "-c:a") != "copy" || argValue(args, "-c:s") != "copy" {
+		t.Errorf("--audio-track 1 args = %v, want audio and subtitles copied", args)
+	}
+
+	// Every track is mapped, and only the TrueHD one is encoded
+	args = newTranscoder("all").buildFFmpegArgs("/in/movie.mkv", "/out/movie.mkv", preset, false)
+	if got := maps(args); !slices.Equal(got, []string{"0:v:0", "0:a?", "0:s?"}) {
+		t.Errorf("--audio-track all maps %v", got)
+	}
+	if argValue(args, "-c:a:0") != "aac" || argValue(args, "-c:a:1") != "copy" {
+		t.Errorf("--audio-track all args = %v, want track 0 encoded and track 1 copied", args)
+	}
+
+	// Subtitles are only carried over from Matroska sources
+	if got := maps(newTranscoder("0").buildFFmpegArgs("/in/clip.mp4", "/out/clip.mkv", preset, false)); !slices.Equal(got, []string{"0:v:0", "0:a:0"}) {
+		t.Errorf("--audio-track 0 for an MP4 source maps %v", got)
+	}
+
+	if err := newTranscoder("1").checkAudioTrack("/in/movie.mkv"); err != nil {
+		t.Errorf("checkAudioTrack() error = %v", err)
+	}
+	err := newTranscoder("1").checkAudioTrack("/in/clip.mp4")
+	if !IsTranscoderError(err, ErrorTypeInvalidAudio) || !strings.Contains(err.Error(), "has no audio track 1 (it has 1") {
+		t.Errorf("checkAudioTrack() for a missing track = %v", err)
+	}
+	for _, value := range []string{"-1", "first", "1.5"} {
+		if _, err := ParseAudioTrack(value); err == nil {
+			t.Errorf("ParseAudioTrack(%q) expected an error", value)
+		}
+	}
+}
+
+func TestBuildFFmpegArgs_Subtitles(t *testing.T) {
+	subtitleProbe := func(codecs ...string) string {
+		streams := `{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}, {"codec_type": "audio", "codec_name": "aac"}`
+		for _, codec := range codecs {
+			streams += `, {"codec_type": "subtitle", "codec_name": "` + codec + `"}`
+		}
+		return `{"format": {"duration": "60"}, "streams": [` + streams + `]}`
+	}
+	dir := t.TempDir()
+	external := filepath.Join(dir, "extra.srt")
+	if err := os.WriteFile(external, []byte("1\n00:00:01,000 --> 00:00:02,000\nHi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	probes := map[string]string{
+		"/in/bluray.mkv":     subtitleProbe("hdmv_pgs_subtitle", "subrip"),
+		"/in/phone.mp4":      subtitleProbe("mov_text"),
+		"/in/none.mp4":       subtitleProbe(),
+		"/in/it's [new].mkv": subtitleProbe("ass"),
+	}
+	newTranscoder := func(mode string, subtitleFiles ...string) *Transcoder {
+		tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, Subtitles: mode, NoAutoSubtitles: true, SubtitleFiles: subtitleFiles})
+		tr.prober = NewProber(&pathProbeExecutor{probes: probes})
+		tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+		return tr
+	}
+	preset := New(Config{InputPath: "/in", OutputDir: "/out"}).presets["1080p_h264"]
+	build := func(tr *Transcoder, input string) []string {
+		return tr.buildFFmpegArgs(input, "/out/x.mkv", preset, false)
+	}
+	contains := func(args []string, want ...string) bool {
+		for i := 0; i+len(want) <= len(args); i++ {
+			if slices.Equal(args[i:i+len(want)], want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if args := build(newTranscoder(SubtitleModeNone), "/in/bluray.mkv"); slices.Contains(args, "-map") || slices.Contains(args, "-sn") {
+		t.Errorf("--subtitles none args = %v, want ffmpeg's default selection", args)
+	}
+
+	// Every track is kept; MP4 timed text is converted for Matroska
+	args := build(newTranscoder(SubtitleModeCopy), "/in/bluray.mkv")
+	if !contains(args, "-map", "0:v:0", "-map", "0:a?", "-map", "0:s:0", "-c:s:0", "copy", "-map", "0:s:1", "-c:s:1", "copy") {
+		t.Errorf("--subtitles copy args = %v", args)
+	}
+	if args := build(newTranscoder(SubtitleModeCopy), "/in/phone.mp4"); !contains(args, "-map", "0:s:0", "-c:s:0", "srt") {
+		t.Errorf("--subtitles copy for mov_text = %v, want conversion to srt", args)
+	}
+	if args := build(newTranscoder(SubtitleModeCopy), "/in/none.mp4"); slices.Contains(args, "-c:s") || !contains(args, "-map", "0:a?") {
+		t.Errorf("--subtitles copy without subtitle streams = %v", args)
+	}
+	// Embedded tracks follow external subtitle files
+	args = build(newTranscoder(SubtitleModeCopy, external), "/in/phone.mp4")
+	if !contains(args, "-map", "1:s:0") || !contains(args, "-c:s:0", "srt") || !contains(args, "-map", "0:s:0", "-c:s:1", "srt") {
+		t.Errorf("--subtitles copy with an external file = %v", args)
+	}
+
+	// The first text track is drawn after scaling, and no stream is muxed
+	args = build(newTranscoder(SubtitleModeBurn), "/in/it's [new].mkv")
+	if vf := argValue(args, "-vf"); vf != `scale=1920:1080,subtitles=/in/it\\\'s \[new\].mkv:si=0` {
+		t.Errorf("--subtitles burn -vf = %s", vf)
+	}
+	if !slices.Contains(args, "-sn") {
+		t.Errorf("--subtitles burn args = %v, want -sn", args)
+	}
+	// With --start the frames are drawn at their time in the source
+	trimmed := newTranscoder(SubtitleModeBurn)
+	trimmed.config.TrimStart = 90 * time.Second
+	args = build(trimmed, "/in/it's [new].mkv")
+	if vf := argValue(args, "-vf"); vf != `scale=1920:1080,setpts=PTS+90/TB,subtitles=/in/it\\\'s \[new\].mkv:si=0,setpts=PTS-STARTPTS` {
+		t.Errorf("--subtitles burn with --start -vf = %s", vf)
+	}
+	if argValue(args, "-ss") != "90" {
+		t.Errorf("--subtitles burn with --start args = %v, want the input seeked", args)
+	}
+	if args := build(newTranscoder(SubtitleModeBurn), "/in/none.mp4"); argValue(args, "-vf") != "scale=1920:1080" {
+		t.Errorf("--subtitles burn without subtitles = %v, want the preset filters only", args)
+	}
+	if err := newTranscoder(SubtitleModeBurn).checkSubtitles("/in/none.mp4"); err != nil {
+		t.Errorf("checkSubtitles() without subtitles error = %v", err)
+	}
+	err := newTranscoder(SubtitleModeBurn).checkSubtitles("/in/bluray.mkv")
+	if !IsTranscoderError(err, ErrorTypeInvalidSubtitle) || !strings.Contains(err.Error(), "hdmv_pgs_subtitle") {
+		t.Errorf("checkSubtitles() for a bitmap track = %v", err)
+	}
+}
+
+func TestEscapeFilterValue(t *testing.T) {
+	tests := map[string]string{
+		"/media/movie.mkv":    "/media/movie.mkv",
+		`C:\Videos\movie.mkv`: `C\\:\\\\Videos\\\\movie.mkv`,
+		"a,b;c[1].mkv":        `a\,b\;c\[1\].mkv`,
+	}
+	for value, want := range tests {
+		if got := escapeFilterValue(value); got != want {
+			t.Errorf("escapeFilterValue(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestStabilityTracker(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "recording.mp4")
+	if err := os.WriteFile(path, []byte("part"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	tracker := newStabilityTracker(5 * time.Second)
+	tracker.touch(path, start)
+	if ready := tracker.ready(start.Add(2 * time.Second)); len(ready) != 0 {
+		t.Errorf("ready() before the stable delay = %v", ready)
+	}
+
+	// A write restarts the delay
+	if err := os.WriteFile(path, []byte("part, and more"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ready := tracker.ready(start.Add(4 * time.Second)); len(ready) != 0 {
+		t.Errorf("ready() right after a write = %v", ready)
+	}
+	if ready := tracker.ready(start.Add(8 * time.Second)); len(ready) != 0 {
+		t.Errorf("ready() less than the stable delay after a write = %v", ready)
+	}
+	if ready := tracker.ready(start.Add(9 * time.Second)); !reflect.DeepEqual(ready, []string{path}) {
+		t.Errorf("ready() after the stable delay = %v, want [%s]", ready, path)
+	}
+	if ready := tracker.ready(start.Add(20 * time.Second)); len(ready) != 0 {
+		t.Errorf("ready() returned a file twice: %v", ready)
+	}
+
+	// Files renamed or removed before they settle are dropped
+	tracker.touch(path, start)
+	moved := filepath.Join(dir, "moved.mp4")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if ready := tracker.ready(start.Add(time.Minute)); len(ready) != 0 {
+		t.Errorf("ready() returned a renamed file: %v", ready)
+	}
+	tracker.touch(moved, start)
+	tracker.forget(moved)
+	if ready := tracker.ready(start.Add(time.Minute)); len(ready) != 0 {
+		t.Errorf("ready() returned a forgotten file: %v", ready)
+	}
+}
+
+func TestWatchRecord(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, WatchRecordFile)
+	record, err := loadWatchRecord(path)
+	if err != nil {
+		t.Fatalf("loadWatchRecord() of a missing file error = %v", err)
+	}
+	if err := record.add(filepath.Join(dir, "a.mp4"), 100); err != nil {
+		t.Fatalf("add() error = %v", err)
+	}
+
+	reloaded, err := loadWatchRecord(path)
+	if err != nil {
+		t.Fatalf("loadWatchRecord() error = %v", err)
+	}
+	if !reloaded.has(filepath.Join(dir, "a.mp4"), 100) {
+		t.Error("reloaded record lost a processed source")
+	}
+	if reloaded.has(filepath.Join(dir, "a.mp4"), 200) {
+		t.Error("a new file reusing the name counts as processed")
+	}
+	if reloaded.has(filepath.Join(dir, "b.mp4"), 100) {
+		t.Error("an unknown source counts as processed")
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadWatchRecord(path); err == nil {
+		t.Error("loadWatchRecord() accepted invalid JSON")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	writeInput := func(name string) string {
+		path := filepath.Join(inputDir, name)
+		if err := os.WriteFile(path, []byte("video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	done := writeInput("done.mp4")
+	waiting := writeInput("waiting.mp4")
+
+	record, err := loadWatchRecord(filepath.Join(outputDir, WatchRecordFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := record.add(done, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: "1080p_h264", NoGPU: true, NoProbe: true})
+	executor := &MockCommandExecutor{}
+	tr.systemChecker = &SystemChecker{executor: executor, platform: PlatformSoftware}
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `[ "$0" = - ] || echo encoded > "$0"`, args[len(args)-1])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := captureStdout(t, func() {
+		finished := make(chan error)
+		go func() { finished <- tr.Watch(ctx, 200*time.Millisecond, nil) }()
+
+		// A file appearing under a temporary name and renamed when complete
+		time.Sleep(100 * time.Millisecond)
+		partial := writeInput("new.part")
+		added := filepath.Join(inputDir, "new.mp4")
+		if err := os.Rename(partial, added); err != nil {
+			t.Error(err)
+		}
+
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			reloaded, err := loadWatchRecord(filepath.Join(outputDir, WatchRecordFile))
+			if err == nil && reloaded.has(waiting, 5) && reloaded.has(added, 5) {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		cancel()
+		if err := <-finished; err != nil {
+			t.Errorf("Watch() error = %v", err)
+		}
+	})
+
+	for _, name := range []string{"waiting_1080p_h264.mkv", "new_1080p_h264.mkv"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); err != nil {
+			t.Errorf("output %s not written: %v\n%s", name, err, output)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "done_1080p_h264.mkv")); !os.IsNotExist(err) {
+		t.Errorf("recorded source encoded again: %v", err)
+	}
+	if strings.Contains(output, "new.part") {
+		t.Errorf("partial file name processed:\n%s", output)
+	}
+}
+
+func TestCheckContainer(t *testing.T) {
+	tests := []struct {
+		container, encoder, audio string
+		wantErr                   bool
+	}{
+		{ContainerMKV, "libx264", "copy", false},
+		{ContainerMKV, "libx265", "pcm_s16le", false},
+		{ContainerMP4, "h264_nvenc", "copy", false},
+		{ContainerMP4, "hevc_videotoolbox", "aac", false},
+		{ContainerMP4, "libsvtav1", "copy", false},
+		{ContainerMP4, "libx264", "libopus", false},
+		{ContainerMP4, "libx264", "libvorbis", true},
+		{ContainerWebM, "av1_nvenc", "copy", false},
+		{ContainerWebM, "libsvtav1", "libopus", false},
+		{ContainerWebM, "libx264", "copy", true},
+		{ContainerWebM, "hevc_nvenc", "libopus", true},
+		{ContainerWebM, "libsvtav1", "aac", true},
+		{"avi", "libx264", "copy", true},
+	}
+	for _, tt := range tests {
+		err := CheckContainer(tt.container, tt.encoder, tt.audio)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckContainer(%s, %s, %s) error = %v, wantErr %v", tt.container, tt.encoder, tt.audio, err, tt.wantErr)
+		}
+		if err != nil && !IsTranscoderError(err, ErrorTypeInvalidContainer) {
+			t.Errorf("CheckContainer(%s, %s, %s) error type = %v", tt.container, tt.encoder, tt.audio, err)
+		}
+	}
+}
+
+func TestBuildFFmpegArgs_Container(t *testing.T) {
+	probe := `{"format": {"duration": "60"}, "streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}, {"codec_type": "audio", "codec_name": "opus"}, {"codec_type": "subtitle", "codec_name": "subrip"}, {"codec_type": "subtitle", "codec_name": "hdmv_pgs_subtitle"}]}`
+	newTranscoder := func(container string) *Transcoder {
+		tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, Container: container, Subtitles: SubtitleModeCopy, NoAutoSubtitles: true, AudioCodec: "copy"})
+		tr.prober = NewProber(&pathProbeExecutor{probes: map[string]string{"/in/movie.mkv": probe}})
+		tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+		return tr
+	}
+	contains := func(args []string, want ...string) bool {
+		for i := 0; i+len(want) <= len(args); i++ {
+			if slices.Equal(args[i:i+len(want)], want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	tr := newTranscoder(ContainerMP4)
+	output := tr.pathUtils.GenerateOutputPath("/in/movie.mkv", "/out", "/in", tr.presets["1080p_h265"])
+	if output != filepath.Join("/out", "movie_1080p_h265.mp4") {
+		t.Errorf("GenerateOutputPath() with --container mp4 = %s", output)
+	}
+	var args []string
+	captureStdout(t, func() {
+		args = tr.buildFFmpegArgs("/in/movie.mkv", output, tr.presets["1080p_h265"], false)
+	})
+	if !contains(args, "-movflags", "+faststart", "-y", output) {
+		t.Errorf("mp4 args = %v, want +faststart before the output", args)
+	}
+	if !contains(args, "-tag:v", "hvc1") {
+		t.Errorf("mp4 args = %v, want HEVC tagged hvc1", args)
+	}
+	if !contains(args, "-map", "0:s:0", "-c:s:0", "mov_text") || contains(args, "-map", "0:s:1") {
+		t.Errorf("mp4 args = %v, want text subtitles as mov_text and bitmap ones dropped", args)
+	}
+	if !contains(args, "-c:a", "copy") {
+		t.Errorf("mp4 args = %v, want Opus, which MP4 holds, copied", args)
+	}
+
+	// Audio MP4 cannot hold is encoded to AAC, by the safe fallback too
+	tr.prober = NewProber(&pathProbeExecutor{probes: map[string]string{"/in/movie.mkv": strings.Replace(probe, `"opus"`, `"truehd"`, 1)}})
+	captureStdout(t, func() {
+		args = tr.buildFFmpegArgs("/in/movie.mkv", output, tr.presets["1080p_h265"], false)
+	})
+	if argValue(args, "-c:a") != "aac" {
+		t.Errorf("mp4 args for TrueHD = %v, want AAC", args)
+	}
+	if fallback := tr.createSafeFallbackArgs("/in/movie.mkv", output); argValue(fallback, "-c:a") != "aac" {
+		t.Errorf("mp4 safe fallback args = %v, want AAC", fallback)
+	}
+
+	// A forced extension names the output without changing the container
+	tr = New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, Container: ContainerMP4, ForceExtension: ".mkv"})
+	output = tr.pathUtils.GenerateOutputPath("/in/movie.mkv", "/out", "/in", tr.presets["1080p_h264"])
+	if filepath.Ext(output) != ".mkv" || !slices.Equal(tr.muxerArgs(output), []string{"-movflags", "+faststart", "-f", "mp4"}) {
+		t.Errorf("--container mp4 --force-extension mkv output %s, muxer args %v", output, tr.muxerArgs(output))
+	}
+
+	// WebM re-encodes audio it cannot hold; Opus sources are copied
+	tr = newTranscoder(ContainerWebM)
+	output = tr.pathUtils.GenerateOutputPath("/in/movie.mkv", "/out", "/in", tr.presets["1080p_av1"])
+	captureStdout(t, func() {
+		args = tr.buildFFmpegArgs("/in/movie.mkv", output, tr.presets["1080p_av1"], false)
+	})
+	if filepath.Ext(output) != ".webm" || contains(args, "-f") || contains(args, "-movflags") {
+		t.Errorf("webm output %s args = %v", output, args)
+	}
+	if !contains(args, "-c:a", "copy") || !contains(args, "-c:s:0", "webvtt") {
+		t.Errorf("webm args = %v, want Opus copied and subtitles as webvtt", args)
+	}
+	if err := tr.checkContainer(tr.presets["1080p_h264"]); !IsTranscoderError(err, ErrorTypeInvalidContainer) {
+		t.Errorf("checkContainer() of H.264 into webm error = %v", err)
+	}
+	if fallback := tr.createSafeFallbackArgs("/in/movie.mkv", output); slices.Contains(fallback, "libx264") {
+		t.Errorf("webm safe fallback args = %v", fallback)
+	}
+
+	// The muxer options of the output stay out of a first pass
+	first, _ := twoPassArgs([]string{"-i", "in", "-c:v", "libx264", "-b:v", "5M", "-movflags", "+faststart", "-y", "out.mp4"}, "log")
+	if slices.Contains(first, "-movflags") {
+		t.Errorf("first pass args = %v, want no muxer options", first)
+	}
+
+	if args := newTranscoder("").muxerArgs("/out/movie.mp4"); !slices.Equal(args, []string{"-f", "matroska"}) {
+		t.Errorf("muxerArgs() for a Matroska .mp4 name = %v", args)
+	}
+}
+
+func TestProcessFilesWithProgress_Interrupted(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a_done.mp4", "b_slow.mp4", "c_later.mp4"} {
+		path := filepath.Join(inputDir, name)
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+
+	tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: "1080p_h264", NoGPU: true, NoProbe: true, NoProgress: true})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+	// The slow encode writes part of its output and then runs until stopped
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `[ "$0" = - ] && exit 0; echo partial > "$0"; case "$0" in *slow*) exec sleep 30;; esac`, args[len(args)-1])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	slowOutput := filepath.Join(outputDir, "b_slow_1080p_h264.mkv")
+	go func() {
+		for {
+			if _, err := os.Stat(slowOutput); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	var err error
+	start := time.Now()
+	output := captureStdout(t, func() {
+		_, err = tr.ProcessFilesWithProgress(ctx, files, nil)
+	})
+	if !IsTranscoderError(err, ErrorTypeInterrupted) {
+		t.Errorf("ProcessFilesWithProgress() error = %v, want an interrupted error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("interrupted encode took %s to stop", elapsed)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "a_done_1080p_h264.mkv")); err != nil {
+		t.Errorf("completed output not kept: %v", err)
+	}
+	if _, err := os.Stat(slowOutput); !os.IsNotExist(err) {
+		t.Errorf("partial output not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "c_later_1080p_h264.mkv")); !os.IsNotExist(err) {
+		t.Errorf("file after the interrupt was encoded: %v", err)
+	}
+	if !strings.Contains(output, "1 file(s) completed and kept:\n  - "+filepath.Join(outputDir, "a_done_1080p_h264.mkv")) {
+		t.Errorf("completed file not reported:\n%s", output)
+	}
+	if !strings.Contains(output, "2 file(s) not processed:\n  - "+files[1]+"\n  - "+files[2]) {
+		t.Errorf("remaining files not reported:\n%s", output)
+	}
+}
+
+func TestShutdownContext(t *testing.T) {
+	defer func() {
+		interruptMu.Lock()
+		shutdownRequested = false
+		interruptMu.Unlock()
+	}()
+
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out"})
+	ctx, stop := tr.ShutdownContext(context.Background())
+	defer stop()
+	if log, ok := requestShutdown(); !ok || log != tr.log {
+		t.Fatal("requestShutdown() = false with a shutdown context registered")
+	}
+	if ctx.Err() == nil {
+		t.Error("shutdown context not cancelled")
+	}
+	if _, ok := requestShutdown(); ok {
+		t.Error("a second interrupt did not fall through to exiting")
+	}
+}
+
+func TestAnalyticsJSON(t *testing.T) {
+	dir := t.TempDir()
+	records := []AnalyticsRecord{
+		{Filename: "a.mp4", Status: "success", SizeBeforeMB: 200, SizeAfterMB: 50, Encoder: "hevc_nvenc", Platform: "NVIDIA", EncodingMode: EncodingModeHardware},
+		{Filename: "b.mp4", Status: "success", SizeBeforeMB: 100, SizeAfterMB: 80, Encoder: "libx265", Platform: "NVIDIA", EncodingMode: EncodingModeSoftwareFallback, TargetBitrate: 4.25e6},
+	}
+
+	// An array stays valid JSON after every record
+	arrayPath := filepath.Join(dir, "run.json")
+	analytics, err := OpenAnalyticsJSON(arrayPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, record := range records {
+		if err := analytics.Write(record); err != nil {
+			t.Fatal(err)
+		}
+		var got []analyticsJSONRecord
+		data, _ := os.ReadFile(arrayPath)
+		if err := json.Unmarshal(data, &got); err != nil || len(got) != i+1 {
+			t.Fatalf("after %d record(s) the array holds %d (error %v):\n%s", i+1, len(got), err, data)
+		}
+	}
+	analytics.Close()
+
+	// Appended elements read the same as an array written in one go
+	var all []analyticsJSONRecord
+	for _, record := range records {
+		all = append(all, record.jsonRecord())
+	}
+	want, _ := json.MarshalIndent(all, "", "  ")
+	if data, _ := os.ReadFile(arrayPath); string(data) != string(want)+"\n" {
+		t.Errorf("array file =\n%s\nwant\n%s", data, want)
+	}
+
+	linesPath := filepath.Join(dir, "run.jsonl")
+	analytics, err = OpenAnalyticsJSON(linesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := analytics.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, _ := os.ReadFile(linesPath)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("NDJSON has %d lines, want 2:\n%s", len(lines), data)
+	}
+	var fallback analyticsJSONRecord
+	if err := json.Unmarshal([]byte(lines[1]), &fallback); err != nil {
+		t.Fatal(err)
+	}
+	if !fallback.Fallback || fallback.Hardware || fallback.Encoder != "libx265" || fallback.TargetBitrateKbps != 4250 || fallback.SpaceSavedMB != 20 {
+		t.Errorf("fallback record = %+v", fallback)
+	}
+	if err := analytics.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProcessFileWithAnalytics_JSON(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	input := filepath.Join(inputDir, "clip.mp4")
+	if err := os.WriteFile(input, make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jsonPath := filepath.Join(t.TempDir(), "run.ndjson")
+
+	tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: "1080p_h264", NoGPU: true, NoProbe: true, NoProgress: true, JSONOutput: jsonPath})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `[ "$0" = - ] && exit 0; echo encoded > "$0"`, args[len(args)-1])
+	}
+	if err := tr.OpenJSONOutput(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Cleanup()
+
+	captureStdout(t, func() {
+		if _, err := tr.processFileWithAnalytics(context.Background(), input, nil, nil); err != nil {
+			t.Errorf("processFileWithAnalytics() error = %v", err)
+		}
+	})
+
+	data, _ := os.ReadFile(jsonPath)
+	var record analyticsJSONRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("JSON record %q: %v", data, err)
+	}
+	if record.Filename != "clip.mp4" || record.Status != "success" || record.Encoder != "libx264" ||
+		record.EncodingMode != EncodingModeSoftware || record.Hardware || record.Fallback || record.Platform != PlatformSoftware.String() {
+		t.Errorf("JSON record = %+v", record)
+	}
+}
+
+func TestSystemChecker_CheckIntelQSVAvailability(t *testing.T) {
+	executor := &scriptedExecutor{outputs: map[string]string{
+		"ffmpeg": " V..... h264_qsv             H.264 / AVC (Intel Quick Sync Video acceleration)\n V..... hevc_qsv             HEVC (Intel Quick Sync Video acceleration)\n",
+	}}
+	nodes := []string{"/dev/dri/renderD128"}
+	checker := &SystemChecker{executor: executor, platform: PlatformIntelQSV, renderNodes: func(string) []string { return nodes }}
+
+	if err := checker.checkIntelQSVAvailability("linux", 0); err != nil {
+		t.Errorf("checkIntelQSVAvailability(0) error = %v", err)
+	}
+	if err := checker.checkIntelQSVAvailability("linux", 1); !IsTranscoderError(err, ErrorTypeGPUNotAvailable) {
+		t.Errorf("checkIntelQSVAvailability(1) error = %v, want GPU not available", err)
+	}
+
+	// The GPU disappearing later falls back to software
+	nodes = nil
+	if err := checker.checkIntelQSVAvailability("linux", 0); err == nil {
+		t.Fatal("checkIntelQSVAvailability() expected error without a render node")
+	}
+	if checker.GetPlatform() != PlatformSoftware {
+		t.Errorf("GetPlatform() = %v after failed check, want software", checker.GetPlatform())
+	}
+}
+
+func TestBuildFFmpegArgs_IntelQSV(t *testing.T) {
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", Preset: "1080p_h265", NoProbe: true})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformIntelQSV}
+	tr.presets = GetPresetsForPlatform(PlatformIntelQSV)
+	preset := tr.presets["1080p_h265"]
+
+	args := tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, true)
+	if argValue(args, "-hwaccel") != "qsv" || argValue(args, "-c:v") != "hevc_qsv" || argValue(args, "-global_quality") != "26" {
+		t.Errorf("hardware args = %v, want -hwaccel qsv with hevc_qsv at global_quality 26", args)
+	}
+	if argValue(args, "-qsv_device") != "" {
+		t.Errorf("hardware args = %v, want no -qsv_device without a render node", args)
+	}
+
+	// --gpu picks the Intel render node for Quick Sync
+	tr.systemChecker.renderNodes = func(vendor string) []string {
+		if vendor == intelPCIVendor {
+			return []string{"/dev/dri/renderD128", "/dev/dri/renderD129"}
+		}
+		return nil
+	}
+	tr.config.GPUIndex = 1
+	args = tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, true)
+	if argValue(args, "-qsv_device") != "/dev/dri/renderD129" || argValue(args, "-hwaccel") != "qsv" {
+		t.Errorf("--gpu 1 args = %v, want -qsv_device /dev/dri/renderD129", args)
+	}
+	tr.config.GPUIndex = 0
+	tr.systemChecker.renderNodes = nil
+
+	// The software fallback drops Quick Sync entirely
+	args = tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, false)
+	if argValue(args, "-hwaccel") != "" || argValue(args, "-c:v") != "libx265" || argValue(args, "-global_quality") != "" {
+		t.Errorf("software args = %v, want libx265 without Quick Sync options", args)
+	}
+
+	// --crf is translated to Quick Sync's scale
+	crf := 20
+	tr.config.CRF = &crf
+	args = tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, true)
+	if argValue(args, "-global_quality") != "20" || argValue(args, "-crf") != "" {
+		t.Errorf("--crf 20 args = %v, want -global_quality 20", args)
+	}
+	if got, ok := PresetCRF(preset); !ok || got != 26 {
+		t.Errorf("PresetCRF() = %d, %v; want 26", got, ok)
+	}
+}
+
+func TestBuildFFmpegArgs_AMDVAAPI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("AMD presets use AMF on Windows")
+	}
+	tr := New(Config{InputPath: "in.mp4", OutputDir: "out", Preset: "1080p_h264", NoProbe: true, NoToolMetadata: true})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformAMD,
+		renderNodes: func(vendor string) []string {
+			if vendor == amdPCIVendor {
+				return []string{"/dev/dri/renderD128", "/dev/dri/renderD129"}
+			}
+			return nil
+		}}
+	tr.presets = GetPresetsForPlatform(PlatformAMD)
+	tr.config.GPUIndex = 1
+	preset := tr.presets["1080p_h264"]
+
+	args := tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, true)
+	if got := argValue(args, "-vaapi_device"); got != "/dev/dri/renderD129" {
+		t.Errorf("-vaapi_device = %q, want the second AMD render node", got)
+	}
+	if got := argValue(args, "-vf"); got != "scale=1920:1080,"+vaapiUploadFilter {
+		t.Errorf("-vf = %q, want scaling before the upload", got)
+	}
+	if argValue(args, "-c:v") != "h264_vaapi" || argValue(args, "-rc_mode") != "VBR" {
+		t.Errorf("args = %v, want h264_vaapi in VBR mode", args)
+	}
+
+	// The upload stays last when the debug overlay is added
+	if got := argValue(withDebugOverlay(args), "-vf"); !strings.HasPrefix(got, "scale=1920:1080,drawtext=") || !strings.HasSuffix(got, ","+vaapiUploadFilter) {
+		t.Errorf("overlay -vf = %q, want drawtext between scaling and the upload", got)
+	}
+
+	// The software fallback neither uploads nor names the device
+	args = tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, false)
+	if argValue(args, "-vaapi_device") != "" || argValue(args, "-c:v") != "libx264" || strings.Contains(argValue(args, "-vf"), "hwupload") {
+		t.Errorf("software args = %v, want libx264 without VAAPI", args)
+	}
+
+	// --crf becomes a constant quantizer
+	crf := 21
+	tr.config.CRF = &crf
+	args = tr.buildFFmpegArgs("in.mp4", "out.mkv", preset, true)
+	if argValue(args, "-rc_mode") != "CQP" || argValue(args, "-qp") != "21" || strings.Count(strings.Join(args, " "), "-rc_mode") != 1 {
+		t.Errorf("--crf 21 args = %v, want a single -rc_mode CQP with -qp 21", args)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "", want: nil},
+		{line: "  -g 48   -bf 2 ", want: []string{"-g", "48", "-bf", "2"}},
+		{line: `-metadata "title=My Movie"`, want: []string{"-metadata", "title=My Movie"}},
+		{line: `-vf 'drawtext=text="a b"'`, want: []string{"-vf", `drawtext=text="a b"`}},
+		{line: `-metadata title=It\'s\ here`, want: []string{"-metadata", "title=It's here"}},
+		{line: `-metadata "title=say \"hi\""`, want: []string{"-metadata", `title=say "hi"`}},
+		{line: `-attach C:\fonts\a.ttf`, want: []string{"-attach", `C:\fonts\a.ttf`}},
+		{line: `-metadata ""`, want: []string{"-metadata", ""}},
+		{line: `-metadata "title=open`, wantErr: true},
+		{line: `-vf 'scale=1:1`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.line)
+		if tt.wantErr {
+			if !IsTranscoderError(err, ErrorTypeInvalidArgs) {
+				t.Errorf("SplitArgs(%q) error = %v, want an invalid arguments error", tt.line, err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("SplitArgs(%q) = %q, %v, want %q", tt.line, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateFFmpegArgs(t *testing.T) {
+	valid := [][]string{
+		nil,
+		{"-g", "48", "-bf", "2"},
+		{"-an", "-movflags", "+faststart"},
+		{"-x264-params", "keyint=48", "-metadata", "title=x"},
+	}
+	for _, args := range valid {
+		if err := ValidateFFmpegArgs(args); err != nil {
+			t.Errorf("ValidateFFmpegArgs(%q) error = %v", args, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"-i", "other.mp4"},
+		{"-y"},
+		{"-n"},
+		{"out.mkv"},
+		{"-g", "48", "extra.mkv"},
+		{"-c:v", "libx264", "-"},
+	}
+	for _, args := range invalid {
+		if err := ValidateFFmpegArgs(args); !IsTranscoderError(err, ErrorTypeInvalidArgs) {
+			t.Errorf("ValidateFFmpegArgs(%q) error = %v, want an invalid arguments error", args, err)
+		}
+	}
+
+	config := Config{InputPath: "/in", OutputDir: "/out", FFmpegArgs: []string{"-i", "x"}}
+	if err := config.Validate(); !IsTranscoderError(err, ErrorTypeInvalidArgs) {
+		t.Errorf("Validate() with -i in FFmpegArgs error = %v", err)
+	}
+}
+
+func TestBuildFFmpegArgs_FFmpegArgs(t *testing.T) {
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, NoProbe: true, AudioCodec: "copy",
+		Container: ContainerMP4, FFmpegArgs: []string{"-g", "48", "-movflags", "+faststart+frag_keyframe"}})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+
+	preset := tr.presets["1080p_h264"]
+	var args []string
+	captureStdout(t, func() {
+		args = tr.buildFFmpegArgs("/in/movie.mkv", "/out/movie.mp4", preset, false)
+	})
+	tail := []string{"-g", "48", "-movflags", "+faststart+frag_keyframe", "-y", "/out/movie.mp4"}
+	if len(args) < len(tail) || !slices.Equal(args[len(args)-len(tail):], tail) {
+		t.Errorf("args = %v, want the extra arguments just before the output", args)
+	}
+	if fallback := tr.createSafeFallbackArgs("/in/movie.mkv", "/out/movie.mp4"); slices.Contains(fallback, "-g") {
+		t.Errorf("safe fallback args = %v, want no extra arguments", fallback)
+	}
+
+	// Both passes encode with the extra arguments; only the second muxes
+	first, second := twoPassArgs(args, "log")
+	if !slices.Contains(first, "-g") || slices.Contains(first, "-movflags") || !slices.Contains(second, "+faststart+frag_keyframe") {
+		t.Errorf("two-pass args = %v / %v", first, second)
+	}
+}
+
+func TestQualityArgs(t *testing.T) {
+	args := qualityArgs("/out/movie.mkv", "/in/movie.mkv", "/tmp/vmaf.json", false, 0, 0)
+	if args[2] != "/out/movie.mkv" || args[4] != "/in/movie.mkv" {
+		t.Errorf("qualityArgs() = %v, want the output as the first input", args)
+	}
+	graph := argValue(args, "-lavfi")
+	if !strings.HasPrefix(graph, "[0:v][1:v]scale2ref") || !strings.HasSuffix(graph, "[d][r]libvmaf=log_fmt=json:log_path=/tmp/vmaf.json") {
+		t.Errorf("VMAF graph = %s, want the output scaled to the source and scored as distorted", graph)
+	}
+	if graph := argValue(qualityArgs("o", "i", "/tmp/vmaf.json", true, 0, 0), "-lavfi"); !strings.Contains(graph, "libvmaf") || !strings.HasSuffix(graph, "[d2][r2]ssim") {
+		t.Errorf("VMAF and SSIM graph = %s", graph)
+	}
+	if graph := argValue(qualityArgs("o", "i", "", true, 0, 0), "-lavfi"); strings.Contains(graph, "libvmaf") || !strings.HasSuffix(graph, "[d][r]ssim") {
+		t.Errorf("SSIM graph = %s", graph)
+	}
+
+	if score, ok := parseVMAFLog([]byte(`{"frames": [], "pooled_metrics": {"vmaf": {"min": 80.1, "mean": 94.5}}}`)); !ok || score != 94.5 {
+		t.Errorf("parseVMAFLog() = %v, %v", score, ok)
+	}
+	if _, ok := parseVMAFLog([]byte(`{"pooled_metrics": {}}`)); ok {
+		t.Error("parseVMAFLog() without a vmaf entry reported a score")
+	}
+}
+
+func TestMeasureQuality(t *testing.T) {
+	const filters = " ... scale2ref         VV->VV     Scale the input by the size of another.\n ... ssim              VV->V      Calculate the SSIM.\n"
+	const vmafFilter = " ... libvmaf           VV->V      Calculate the VMAF.\n"
+	newTranscoder := func(filterList string, ssim bool) (*Transcoder, *int) {
+		tr := New(Config{InputPath: "/in", OutputDir: "/out", NoProbe: true, MeasureQuality: true, MeasureSSIM: ssim})
+		tr.systemChecker = &SystemChecker{executor: &scriptedExecutor{outputs: map[string]string{"ffmpeg": filterList}}, platform: PlatformSoftware}
+		runs := 0
+		tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+			runs++
+			graph := argValue(args, "-lavfi")
+			if i := strings.Index(graph, "log_path="); i >= 0 {
+				path, _, _ := strings.Cut(graph[i+len("log_path="):], ";")
+				os.WriteFile(path, []byte(`{"pooled_metrics": {"vmaf": {"mean": 94.5}}}`), 0o644)
+			}
+			return exec.CommandContext(ctx, "sh", "-c", `echo "[Parsed_ssim_7 @ 0x1] SSIM Y:0.98 (17.0) U:0.99 (20.0) V:0.99 (20.0) All:0.9871 (19.3)" >&2`)
+		}
+		return tr, &runs
+	}
+
+	tr, runs := newTranscoder(filters+vmafFilter, true)
+	var scores QualityScores
+	captureStdout(t, func() { scores = tr.measureQuality(context.Background(), "/in/movie.mkv", "/out/movie.mkv") })
+	if *runs != 1 || scores.VMAF == nil || *scores.VMAF != 94.5 || scores.SSIM == nil || *scores.SSIM != 0.9871 {
+		t.Errorf("measureQuality() = %s after %d runs, want VMAF 94.50 and SSIM 0.9871 from one run", scores, *runs)
+	}
+	record := AnalyticsRecord{Status: "success", VMAF: scores.VMAF, SSIM: scores.SSIM}
+	row := record.csvRow()
+	if row[slices.Index(csvHeader, "vmaf")] != "94.50" || row[slices.Index(csvHeader, "ssim")] != "0.9871" {
+		t.Errorf("csvRow() = %v, want the scores in the vmaf and ssim columns", row)
+	}
+
+	// Without libvmaf the encode keeps going with a single warning
+	tr, runs = newTranscoder(filters, false)
+	output := captureStdout(t, func() {
+		for range 2 {
+			scores = tr.measureQuality(context.Background(), "/in/movie.mkv", "/out/movie.mkv")
+		}
+	})
+	if *runs != 0 || scores.VMAF != nil || strings.Count(output, "skipping VMAF") != 1 {
+		t.Errorf("measureQuality() without libvmaf = %s after %d runs, output %q", scores, *runs, output)
+	}
+	if row := (AnalyticsRecord{Status: "success"}).csvRow(); row[slices.Index(csvHeader, "vmaf")] != "" {
+		t.Errorf("csvRow() = %v, want an empty vmaf column when not measured", row)
+	}
+}
+
+func TestEncodeWithRetries(t *testing.T) {
+	const sessionError = "[h264_nvenc @ 0x1] OpenEncodeSessionEx failed: out of memory (10)"
+	newTranscoder := func(retries int, failures []string) (*Transcoder, *int, *[]time.Duration) {
+		tr := New(Config{InputPath: "/in", OutputDir: "/out", NoProbe: true, Retries: retries})
+		runs := 0
+		tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+			runs++
+			if runs <= len(failures) {
+				return exec.CommandContext(ctx, "sh", "-c", `echo "$1" >&2; exit 1`, "-", failures[runs-1])
+			}
+			return exec.CommandContext(ctx, "sh", "-c", "exit 0")
+		}
+		var waits []time.Duration
+		tr.sleep = func(ctx context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return nil
+		}
+		return tr, &runs, &waits
+	}
+	args := []string{"-i", "/in/movie.mkv", "-c:v", "h264_nvenc", "-y", "/out/movie.mkv"}
+	encode := func(tr *Transcoder) (err error) {
+		captureStdout(t, func() {
+			_, err = tr.encodeWithRetries(context.Background(), "/in/movie.mkv", args, Preset{}, nil)
+		})
+		return err
+	}
+
+	tr, runs, waits := newTranscoder(3, []string{sessionError, sessionError})
+	if err := encode(tr); err != nil || *runs != 3 || !slices.Equal(*waits, []time.Duration{5 * time.Second, 10 * time.Second}) {
+		t.Errorf("transient failures: error %v after %d runs, waits %v", err, *runs, *waits)
+	}
+
+	tr, runs, _ = newTranscoder(3, []string{"Unrecognized option 'foo'."})
+	if err := encode(tr); err == nil || *runs != 1 {
+		t.Errorf("non-transient failure: error %v after %d runs, want one run", err, *runs)
+	}
+
+	tr, runs, _ = newTranscoder(1, []string{sessionError, sessionError, sessionError})
+	if err := encode(tr); err == nil || *runs != 2 {
+		t.Errorf("retries used up: error %v after %d runs, want 2 runs", err, *runs)
+	}
+
+	tr, runs, _ = newTranscoder(0, []string{sessionError})
+	if err := encode(tr); err == nil || *runs != 1 {
+		t.Errorf("no retries: error %v after %d runs", err, *runs)
+	}
+
+	if reason, ok := transientFailure("cuMemAlloc failed -> CUDA_ERROR_OUT_OF_MEMORY"); !ok || reason != "GPU out of memory" {
+		t.Errorf("transientFailure() = %q, %v", reason, ok)
+	}
+	if got := retryDelay(10); got != RetryMaxDelay {
+		t.Errorf("retryDelay(10) = %s, want the %s cap", got, RetryMaxDelay)
+	}
+}
+
+func TestClassifyFFmpegError(t *testing.T) {
+	tests := []struct {
+		name     string
+		stderr   string
+		expected ErrorType
+		fallback bool
+	}{
+		{"missing input", "/in/gone.mkv: No such file or directory", ErrorTypeInvalidFilePath, false},
+		{"unwritable output", "/out/movie.mkv: Permission denied", ErrorTypeFileSystemError, false},
+		{"full disk", "av_interleaved_write_frame(): No space left on device", ErrorTypeFileSystemError, false},
+		{"missing filter", "[AVFilterGraph @ 0x1] No such filter: 'zscale'\nError initializing filters", ErrorTypeFilterNotFound, false},
+		{"unknown encoder", "Unknown encoder 'h264_nvenc'", ErrorTypeEncoderNotFound, true},
+		{"no CUDA on Windows", "[h264_nvenc @ 0x1] Cannot load nvcuda.dll", ErrorTypeGPUNotAvailable, true},
+		{"no CUDA on Linux", "[hevc_nvenc @ 0x1] Cannot load libcuda.so.1", ErrorTypeGPUNotAvailable, true},
+		{"device creation", "Device creation failed: -22.\nFailed to set value '/dev/dri/renderD128' for option 'vaapi_device': Invalid argument", ErrorTypeGPUNotAvailable, true},
+		{"no capable GPU", "[h264_nvenc @ 0x1] No capable devices found", ErrorTypeGPUNotAvailable, true},
+		{"render node denied", "[AVHWDeviceContext @ 0x1] Failed to open /dev/dri/renderD128: Permission denied", ErrorTypeGPUNotAvailable, true},
+		{"NVIDIA node denied", "/dev/nvidia0: Operation not permitted\nConversion failed!", ErrorTypeGPUNotAvailable, true},
+		{"bad encoder parameter", "[h264_nvenc @ 0x1] InitializeEncoder failed: invalid param (8)\nError while opening encoder - Invalid argument", ErrorTypeInvalidPreset, true},
+		{"bad option", "Unrecognized option 'tune-x'.\nError splitting the argument list: Option not found", ErrorTypeInvalidPreset, true},
+		{"unknown failure", "Conversion failed!", ErrorTypeEncodingFailed, true},
+		{"no output", "", ErrorTypeEncodingFailed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyFFmpegError(tt.stderr)
+			if got != tt.expected {
+				t.Errorf("classifyFFmpegError() = %s, want %s", got, tt.expected)
+			}
+			if helps := fallbackHelps(got); helps != tt.fallback {
+				t.Errorf("fallbackHelps(%s) = %v, want %v", got, helps, tt.fallback)
+			}
+		})
+	}
+}
+
+func TestHandleEncodingError_SkipsUselessFallback(t *testing.T) {
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", NoProbe: true, AudioCodec: "copy"})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformNVIDIA}
+	runs := 0
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		runs++
+		return exec.CommandContext(ctx, "sh", "-c", "exit 0")
+	}
+	preset := Preset{Name: "1080p_h264", Codec: "H.264", Encoder: "h264_nvenc", Args: []string{"-c:v", "h264_nvenc", "-b:v", "5M"}}
+
+	var err error
+	captureStdout(t, func() {
+		_, err = tr.handleEncodingError(context.Background(), errors.New("exit status 1"),
+			"[in#0 @ 0x1] Error opening input: No such file or directory\nError opening input file /in/gone.mkv.", "/in/gone.mkv", "/out/gone.mkv", preset)
+	})
+	if !IsTranscoderError(err, ErrorTypeInvalidFilePath) || runs != 0 {
+		t.Errorf("missing input: error %v after %d fallback runs, want an invalid file path error and none", err, runs)
+	}
+	if !strings.Contains(err.Error(), "Error opening input: No such file or directory") {
+		t.Errorf("error %q, want the ffmpeg line naming the problem", err)
+	}
+
+	var mode string
+	captureStdout(t, func() {
+		mode, err = tr.handleEncodingError(context.Background(), errors.New("exit status 1"),
+			"[h264_nvenc @ 0x1] Cannot load libcuda.so.1", "/in/movie.mkv", "/out/movie.mkv", preset)
+	})
+	if err != nil || mode != EncodingModeSoftwareFallback || runs != 1 {
+		t.Errorf("GPU failure: mode %q, error %v after %d runs, want a software fallback", mode, err, runs)
+	}
+}
+
+func TestSummarizeBatch(t *testing.T) {
+	files := []string{"/in/a.mkv", "/in/b.mkv", "/in/c.mkv", "/in/d.mkv", "/in/e.mkv"}
+	results := []*FileResult{
+		{InputSize: 3000, OutputSize: 1000},
+		{InputSize: 1000, OutputSize: 500},
+		{Skipped: true, SkipReason: SkipReasonOutputExists},
+		nil,
+		nil,
+	}
+	fileErrors := []error{nil, nil, nil, NewTranscoderError(ErrorTypeInvalidFilePath, "missing", nil), nil}
+	durations := map[string]float64{"/in/a.mkv": 60, "/in/b.mkv": 30, "/in/c.mkv": 600}
+
+	summary := summarizeBatch(files, results, fileErrors, durations, 30*time.Second)
+	want := BatchSummary{Files: 5, Succeeded: 2, Failed: 1, Skipped: 1, NotProcessed: 1,
+		InputSize: 4000, OutputSize: 1500, MediaDuration: 90 * time.Second, WallTime: 30 * time.Second}
+	failures := summary.Failures
+	summary.Failures = nil
+	if !reflect.DeepEqual(*summary, want) {
+		t.Errorf("summarizeBatch() = %+v, want %+v", *summary, want)
+	}
+	if len(failures) != 1 || failures[0].Path != "/in/d.mkv" || failures[0].Type != ErrorTypeInvalidFilePath {
+		t.Errorf("failures = %+v", failures)
+	}
+	if summary.SpaceSaved() != 2500 || summary.Speed() != 3 || summary.Throughput() != 4000.0/30 {
+		t.Errorf("saved %d, speed %v, throughput %v", summary.SpaceSaved(), summary.Speed(), summary.Throughput())
+	}
+
+	var out strings.Builder
+	printBatchSummary(&out, &BatchSummary{}, RatioStyleSaved)
+	if out.Len() != 0 {
+		t.Errorf("empty batch printed %q", out.String())
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "90", want: 90 * time.Second},
+		{value: "90.5", want: 90500 * time.Millisecond},
+		{value: "5:00", want: 5 * time.Minute},
+		{value: "01:02:03.5", want: time.Hour + 2*time.Minute + 3500*time.Millisecond},
+		{value: "100:00:00", want: 100 * time.Hour},
+		{value: "5m30s", want: 5*time.Minute + 30*time.Second},
+		{value: "1500ms", want: 1500 * time.Millisecond},
+		{value: " 30s ", want: 30 * time.Second},
+		{value: "", wantErr: true},
+		{value: "-5", wantErr: true},
+		{value: "1:60", wantErr: true},
+		{value: "1:60:00", wantErr: true},
+		{value: "1:2:3:4", wantErr: true},
+		{value: "NaN", wantErr: true},
+		{value: "1e3", wantErr: true},
+		{value: "five", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.value)
+		if tt.wantErr {
+			if !IsTranscoderError(err, ErrorTypeInvalidTime) {
+				t.Errorf("ParseTimestamp(%q) = %s, %v, want an invalid time error", tt.value, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTimestamp(%q) = %s, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+
+	invalid := []Config{
+		{InputPath: "/in", OutputDir: "/out", TrimDuration: time.Minute, TrimEnd: 2 * time.Minute},
+		{InputPath: "/in", OutputDir: "/out", TrimStart: 5 * time.Minute, TrimEnd: 5 * time.Minute},
+		{InputPath: "/in", OutputDir: "/out", TrimStart: -time.Second},
+	}
+	for _, config := range invalid {
+		if err := config.Validate(); !IsTranscoderError(err, ErrorTypeInvalidTime) {
+			t.Errorf("Validate() with start %s, duration %s, end %s error = %v", config.TrimStart, config.TrimDuration, config.TrimEnd, err)
+		}
+	}
+}
+
+func TestBuildFFmpegArgs_Trim(t *testing.T) {
+	dir := t.TempDir()
+	external := filepath.Join(dir, "extra.srt")
+	if err := os.WriteFile(external, []byte("1\n00:00:01,000 --> 00:00:02,000\nHi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, NoProbe: true, NoAutoSubtitles: true, SubtitleFiles: []string{external},
+		TrimStart: 5 * time.Minute, TrimEnd: 65 * time.Minute})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+	preset := tr.presets["1080p_h264"]
+
+	var args []string
+	captureStdout(t, func() { args = tr.buildFFmpegArgs("/in/talk.mkv", "/out/talk.mkv", preset, false) })
+	for i, arg := range args {
+		if arg == "-i" && (i < 2 || args[i-2] != "-ss" || args[i-1] != "300") {
+			t.Errorf("args = %v, want -ss 300 before input %s", args, args[i+1])
+		}
+	}
+	if idx := slices.Index(args, "-t"); idx < 0 || args[idx+1] != "3600" || idx < slices.Index(args, "-c:v") {
+		t.Errorf("args = %v, want -t 3600 as an output option", args)
+	}
+	if fallback := tr.createSafeFallbackArgs("/in/talk.mkv", "/out/talk.mkv"); !slices.Contains(fallback, "-ss") || argValue(fallback, "-t") != "3600" {
+		t.Errorf("safe fallback args = %v, want the same segment", fallback)
+	}
+	if got := tr.trimmedDuration(7200); got != 3600 {
+		t.Errorf("trimmedDuration(7200) = %v, want the 3600 second segment", got)
+	}
+	if got := tr.trimmedDuration(1200); got != 900 {
+		t.Errorf("trimmedDuration(1200) = %v, want the 900 seconds after the start", got)
+	}
+
+	// Trimmed outputs are not compared with the whole source
+	record := AnalyticsRecord{Status: "success", SizeBeforeMB: 1000, SizeAfterMB: 100, Trimmed: true}
+	row := record.csvRow()
+	for _, column := range []string{"space_saved_mb", "compression_ratio", "space_saved_percent"} {
+		if value := row[slices.Index(csvHeader, column)]; value != "" {
+			t.Errorf("%s of a trimmed output = %q, want empty", column, value)
+		}
+	}
+	if json := record.jsonRecord(); !json.Trimmed || json.CompressionRatio != 0 {
+		t.Errorf("JSON record = %+v, want trimmed without a ratio", json)
+	}
+}
+
+func TestProcessFile_DryRun(t *testing.T) {
+	inputDir := t.TempDir()
+	input := filepath.Join(inputDir, "shows", "pilot.mkv")
+	writeFixture(t, input, []byte("x"))
+	outputDir := filepath.Join(t.TempDir(), "encoded")
+
+	tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: "1080p_h264", NoGPU: true, DryRun: true, Recursive: true})
+	probes := &countingExecutor{output: `{"streams": [{"codec_type": "audio", "codec_name": "aac"}], "format": {"duration": "60"}}`}
+	tr.prober = NewProber(probes)
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		t.Errorf("dry run executed %s %v", name, args)
+		return exec.CommandContext(ctx, "sh", "-c", "exit 1")
+	}
+
+	var summary *BatchSummary
+	var err error
+	stdout := captureStdout(t, func() { summary, err = tr.ProcessFilesWithProgress(context.Background(), []string{input}, nil) })
+	if err != nil {
+		t.Fatalf("ProcessFilesWithProgress() error = %v", err)
+	}
+	if summary.Skipped != 1 || summary.Succeeded != 0 {
+		t.Errorf("summary = %+v, want the file skipped", summary)
+	}
+
+	// The output keeps the directory structure but nothing is created
+	want := filepath.Join(outputDir, "shows", "pilot_1080p_h264.mkv")
+	if !strings.Contains(stdout, "Dry run: "+input+" -> "+want) {
+		t.Errorf("output %q, want the resolved output path %s", stdout, want)
+	}
+	if !strings.Contains(stdout, FormatCommand("ffmpeg", []string{"-hide_banner", "-loglevel", "warning", "-i", input})) ||
+		!strings.Contains(stdout, "-c:v libx264") {
+		t.Errorf("output %q, want the ffmpeg command", stdout)
+	}
+	if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+		t.Errorf("dry run created the output directory: %v", err)
+	}
+
+	// Nothing is probed, neither up front nor for the commands
+	if probes.Calls() != 0 {
+		t.Errorf("dry run probed %d time(s), want none", probes.Calls())
+	}
+	var listing bytes.Buffer
+	tr.PrintDryRun(&listing, []string{input})
+	if probes.Calls() != 0 || !strings.Contains(listing.String(), "-c:v libx264") {
+		t.Errorf("PrintDryRun() probed %d time(s) and printed %q", probes.Calls(), listing.String())
+	}
+	if _, err := tr.prober.Probe(input); err != nil || probes.Calls() != 1 {
+		t.Errorf("Probe() after the dry run = %v with %d call(s), want probing back on", err, probes.Calls())
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"1024", 1024},
+		{"50M", 50 << 20},
+		{"50mb", 50 << 20},
+		{"700MiB", 700 << 20},
+		{"1.5G", 3 << 29},
+		{"2k", 2048},
+		{" 1T ", 1 << 40},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+	for _, value := range []string{"", "M", "-5M", "5X", "inf", "lots"} {
+		if _, err := ParseSize(value); !IsTranscoderError(err, ErrorTypeInvalidThreshold) {
+			t.Errorf("ParseSize(%q) error = %v, want %s", value, err, ErrorTypeInvalidThreshold)
+		}
+	}
+}
+
+func TestFilterByThresholds(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]struct {
+		size     int
+		duration time.Duration
+	}{
+		"sample.mp4":  {size: 10, duration: 30 * time.Second},
+		"episode.mkv": {size: 2000, duration: 22 * time.Minute},
+		"movie.mkv":   {size: 5000, duration: 2 * time.Hour},
+		"unknown.mkv": {size: 3000},
+	}
+	var paths []string
+	durations := make(map[string]time.Duration)
+	for name, file := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, file.size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+		durations[path] = file.duration
+	}
+	slices.Sort(paths)
+
+	var probed []string
+	durationOf := func(path string) (time.Duration, error) {
+		probed = append(probed, filepath.Base(path))
+		if durations[path] == 0 {
+			return 0, fmt.Errorf("no duration")
+		}
+		return durations[path], nil
+	}
+
+	tests := []struct {
+		name       string
+		thresholds Thresholds
+		want       []string
+		wantProbed []string
+	}{
+		{"off", Thresholds{}, []string{"episode.mkv", "movie.mkv", "sample.mp4", "unknown.mkv"}, nil},
+		{"min size", Thresholds{MinSize: 1000}, []string{"episode.mkv", "movie.mkv", "unknown.mkv"}, nil},
+		{"min duration", Thresholds{MinDuration: time.Minute}, []string{"episode.mkv", "movie.mkv", "unknown.mkv"},
+			[]string{"episode.mkv", "movie.mkv", "sample.mp4", "unknown.mkv"}},
+		{"duration range", Thresholds{MinDuration: time.Minute, MaxDuration: time.Hour}, []string{"episode.mkv", "unknown.mkv"},
+			[]string{"episode.mkv", "movie.mkv", "sample.mp4", "unknown.mkv"}},
+		// Files failing the size check are never probed
+		{"combined", Thresholds{MinSize: 1000, MaxDuration: time.Hour}, []string{"episode.mkv", "unknown.mkv"},
+			[]string{"episode.mkv", "movie.mkv", "unknown.mkv"}},
+	}
+	discovery := NewFileDiscovery()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probed = nil
+			kept, excluded := discovery.FilterByThresholds(paths, tt.thresholds, durationOf)
+			var names []string
+			for _, path := range kept {
+				names = append(names, filepath.Base(path))
+			}
+			if !reflect.DeepEqual(names, tt.want) || excluded != len(paths)-len(tt.want) {
+				t.Errorf("FilterByThresholds() = %v, %d excluded, want %v", names, excluded, tt.want)
+			}
+			if !reflect.DeepEqual(probed, tt.wantProbed) {
+				t.Errorf("probed %v, want %v", probed, tt.wantProbed)
+			}
+		})
+	}
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.mkv", "a.mkv", "c.mp4", "odd[1].mkv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "shows"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name   string
+		inputs []string
+		want   []string
+	}{
+		{"glob sorted", []string{path("*.mkv")}, []string{path("a.mkv"), path("b.mkv"), path("odd[1].mkv")}},
+		// The shell expanded the pattern already
+		{"literal paths", []string{path("b.mkv"), path("a.mkv")}, []string{path("b.mkv"), path("a.mkv")}},
+		{"existing path with glob characters", []string{path("odd[1].mkv")}, []string{path("odd[1].mkv")}},
+		{"deduplicated", []string{path("a.mkv"), path("?.mkv"), path("a.mkv")}, []string{path("a.mkv"), path("b.mkv")}},
+		{"directories", []string{path("sh*"), path("c.mp4")}, []string{path("shows"), path("c.mp4")}},
+		// Missing plain paths are reported by the caller
+		{"missing plain path", []string{path("gone.mkv")}, []string{path("gone.mkv")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandInputs(tt.inputs)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpandInputs(%v) = %v, %v, want %v", tt.inputs, got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := ExpandInputs([]string{path("*.avi")}); !IsTranscoderError(err, ErrorTypeInvalidFilePath) {
+		t.Errorf("unmatched pattern error = %v, want %s", err, ErrorTypeInvalidFilePath)
+	}
+	if _, err := ExpandInputs([]string{path("[")}); !IsTranscoderError(err, ErrorTypeInvalidFilePath) {
+		t.Errorf("malformed pattern error = %v, want %s", err, ErrorTypeInvalidFilePath)
+	}
+}
+
+func TestChoosePreset(t *testing.T) {
+	names := GetAvailablePresets()
+	tests := []struct {
+		name   string
+		input  string
+		want   string
+		prompt int // Times the question is asked
+	}{
+		{"default", "\n", "1080p_h264", 1},
+		{"by number", "2\n", names[1], 1},
+		{"by name", "4K_H265\n", "4k_h265", 1},
+		{"retry after invalid answers", "0\nnope\n" + strconv.Itoa(len(names)) + "\n", names[len(names)-1], 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			got, err := ChoosePreset(strings.NewReader(tt.input), &out, "1080p_h264")
+			if err != nil || got != tt.want {
+				t.Fatalf("ChoosePreset() = %q, %v, want %q", got, err, tt.want)
+			}
+			if n := strings.Count(out.String(), "Preset number or name [1080p_h264]: "); n != tt.prompt {
+				t.Errorf("asked %d times, want %d", n, tt.prompt)
+			}
+			if !strings.Contains(out.String(), " 1) "+names[0]) || !strings.Contains(out.String(), GetPresets()[names[0]].Description) {
+				t.Errorf("menu %q does not list %s with its description", out.String(), names[0])
+			}
+		})
+	}
+
+	// A closed stdin never silently picks a preset
+	if _, err := ChoosePreset(strings.NewReader("nope\n"), io.Discard, "1080p_h264"); err == nil {
+		t.Error("ChoosePreset() with closed input succeeded, want an error")
+	}
+}
+
+func TestProcessFilesWithProgress_SeveralPresets(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		file := filepath.Join(inputDir, name)
+		if err := os.WriteFile(file, []byte("xx"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+
+	tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Presets: []string{"720p_h264", "1080p_h265"}, NoGPU: true, NoProbe: true})
+	tr.prober = NewProber(&MockCommandExecutor{shouldFail: true})
+	// The 1080p_h265 encode of a.mp4 fails; everything else succeeds
+	var encodes []string
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		output := args[len(args)-1]
+		if output == "-" {
+			return exec.CommandContext(ctx, "sh", "-c", "exit 0")
+		}
+		encodes = append(encodes, filepath.Base(output))
+		if filepath.Base(output) == "a_1080p_h265.mkv" {
+			return exec.CommandContext(ctx, "sh", "-c", "exit 1")
+		}
+		return exec.CommandContext(ctx, "sh", "-c", `echo x > "$0"`, output)
+	}
+
+	var csvOut strings.Builder
+	writer := csv.NewWriter(&csvOut)
+	var summary *BatchSummary
+	var err error
+	captureStdout(t, func() { summary, err = tr.ProcessFilesWithProgress(context.Background(), files, writer) })
+	writer.Flush()
+	if err == nil {
+		t.Fatal("ProcessFilesWithProgress() error = nil, want the failed preset reported")
+	}
+	if len(summary.Failures) != 1 || !strings.Contains(summary.Failures[0].Err.Error(), "preset 1080p_h265") || summary.Failures[0].Type != ErrorTypeEncodingFailed {
+		t.Errorf("summary failures = %+v, want the 1080p_h265 encode of a.mp4", summary.Failures)
+	}
+
+	// A failed preset does not stop the file's other presets
+	want := []string{"a_720p_h264.mkv", "a_1080p_h265.mkv", "b_720p_h264.mkv", "b_1080p_h265.mkv"}
+	if !slices.Equal(encodes, want) {
+		t.Errorf("encodes = %v, want %v", encodes, want)
+	}
+	for _, name := range []string{"a_720p_h264.mkv", "b_720p_h264.mkv", "b_1080p_h265.mkv"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); err != nil {
+			t.Errorf("output %s missing: %v", name, err)
+		}
+	}
+	if summary.Files != 2 || summary.Succeeded != 1 || summary.Failed != 1 || summary.OutputSize != 4 {
+		t.Errorf("summary = %+v, want b encoded to two 2-byte outputs and a failed", summary)
+	}
+
+	// One analytics row per file and preset
+	rows, err := csv.NewReader(strings.NewReader(csvOut.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	presetColumn, statusColumn := slices.Index(csvHeader, "preset"), slices.Index(csvHeader, "status")
+	var got []string
+	for _, row := range rows {
+		got = append(got, row[0]+" "+row[presetColumn]+" "+row[statusColumn])
+	}
+	wantRows := []string{"a.mp4 720p_h264 success", "a.mp4 1080p_h265 error", "b.mp4 720p_h264 success", "b.mp4 1080p_h265 success"}
+	if !slices.Equal(got, wantRows) {
+		t.Errorf("analytics rows = %v, want %v", got, wantRows)
+	}
+}
+
+func TestTonemap(t *testing.T) {
+	const filters = " ... zscale            V->V       Apply resizing, colorspace and bit depth conversion.\n ... tonemap           V->V       Conversion to/from different dynamic ranges.\n"
+	probe := func(transfer string) string {
+		return `{"format": {"format_name": "matroska,webm", "duration": "60"}, "streams": [{"codec_type": "video", "codec_name": "hevc", "width": 3840, "height": 2160, "color_transfer": "` + transfer + `"}]}`
+	}
+	newTranscoder := func(mode, algorithm, transfer, filterList string) *Transcoder {
+		tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, NoProbe: true, Tonemap: mode, TonemapAlgorithm: algorithm})
+		tr.systemChecker = &SystemChecker{executor: &scriptedExecutor{outputs: map[string]string{"ffmpeg": filterList}}, platform: PlatformSoftware}
+		tr.prober = NewProber(&scriptedExecutor{outputs: map[string]string{"ffprobe": probe(transfer)}})
+		return tr
+	}
+	chain := func(tr *Transcoder) string {
+		var args []string
+		captureStdout(t, func() { args = tr.buildFFmpegArgs("/in/film.mkv", "/out/film.mkv", tr.presets["1080p_h264"], false) })
+		return argValue(args, "-vf")
+	}
+	const hable = "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+	// --tonemap on tags the input, whose own tags may be missing
+	tagged := func(transfer string) string {
+		return strings.Replace(hable, "zscale=t=linear", "zscale=tin="+transfer+":min=bt2020nc:pin=bt2020:t=linear", 1)
+	}
+
+	tests := []struct {
+		name                              string
+		mode, algorithm, transfer, filter string
+		want                              string
+	}{
+		{"HDR10 in auto mode", "", "", "smpte2084", filters, hable + ",scale=1920:1080"},
+		{"HLG with another curve", TonemapAuto, "mobius", "arib-std-b67", filters, strings.Replace(hable, "hable", "mobius", 1) + ",scale=1920:1080"},
+		{"SDR source", TonemapAuto, "", "bt709", filters, "scale=1920:1080"},
+		{"off", TonemapOff, "", "smpte2084", filters, "scale=1920:1080"},
+		{"forced for SDR metadata", TonemapOn, "", "bt709", filters, tagged("smpte2084") + ",scale=1920:1080"},
+		{"forced for HLG", TonemapOn, "", "arib-std-b67", filters, tagged("arib-std-b67") + ",scale=1920:1080"},
+		{"auto without zscale", TonemapAuto, "", "smpte2084", "", "scale=1920:1080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chain(newTranscoder(tt.mode, tt.algorithm, tt.transfer, tt.filter)); got != tt.want {
+				t.Errorf("-vf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The missing filters are reported once, not per file
+	tr := newTranscoder(TonemapAuto, "", "smpte2084", "")
+	out := captureStdout(t, func() {
+		tr.buildFFmpegArgs("/in/a.mkv", "/out/a.mkv", tr.presets["1080p_h264"], false)
+		tr.buildFFmpegArgs("/in/b.mkv", "/out/b.mkv", tr.presets["1080p_h264"], false)
+	})
+	if strings.Count(out, "without tone mapping") != 1 {
+		t.Errorf("output %q, want a single warning about the missing filter", out)
+	}
+
+	// Presets producing HDR keep it
+	hdr := newTranscoder(TonemapOn, "", "smpte2084", filters)
+	hdr.presets["hdr"] = Preset{Name: "hdr", Encoder: "libx265", Args: []string{"-c:v", "libx265", "-color_trc", "smpte2084", "-vf", "scale=3840:2160"}}
+	var args []string
+	captureStdout(t, func() { args = hdr.buildFFmpegArgs("/in/film.mkv", "/out/film.mkv", hdr.presets["hdr"], true) })
+	if got := argValue(args, "-vf"); got != "scale=3840:2160" {
+		t.Errorf("-vf of an HDR preset = %q, want it unchanged", got)
+	}
+
+	if !(&ProbeInfo{ColorTransfer: "smpte2084"}).IsHDR() || (&ProbeInfo{ColorTransfer: "bt709"}).IsHDR() {
+		t.Error("IsHDR() does not match the HDR transfers")
+	}
+}
+
+func TestDeinterlaceAndFrameRate(t *testing.T) {
+	probe := func(fieldOrder string) string {
+		return `{"format": {"format_name": "mpeg", "duration": "60"}, "streams": [{"codec_type": "video", "codec_name": "mpeg2video", "width": 1920, "height": 1080, "field_order": "` + fieldOrder + `"}]}`
+	}
+	chain := func(config Config, fieldOrder string) []string {
+		config.InputPath, config.OutputDir, config.NoGPU, config.NoProbe = "/in", "/out", true, true
+		tr := New(config)
+		tr.prober = NewProber(&scriptedExecutor{outputs: map[string]string{"ffprobe": probe(fieldOrder)}})
+		var args []string
+		captureStdout(t, func() { args = tr.buildFFmpegArgs("/in/tape.mpg", "/out/tape.mkv", tr.presets["1080p_h264"], false) })
+		return args
+	}
+
+	tests := []struct {
+		name       string
+		config     Config
+		fieldOrder string
+		want       string
+	}{
+		{"off by default", Config{}, "tt", "scale=1920:1080"},
+		{"auto on interlaced source", Config{Deinterlace: DeinterlaceAuto}, "tt", "yadif,scale=1920:1080"},
+		{"auto on progressive source", Config{Deinterlace: DeinterlaceAuto}, "progressive", "scale=1920:1080"},
+		{"auto on unknown field order", Config{Deinterlace: DeinterlaceAuto}, "unknown", "scale=1920:1080"},
+		{"forced with bwdif", Config{Deinterlace: DeinterlaceOn, DeinterlaceFilter: "bwdif"}, "progressive", "bwdif,scale=1920:1080"},
+		{"frame rate after scaling", Config{FrameRate: "30"}, "progressive", "scale=1920:1080,fps=30"},
+		{"both", Config{Deinterlace: DeinterlaceAuto, FrameRate: "30000/1001"}, "bb", "yadif,scale=1920:1080,fps=30000/1001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := chain(tt.config, tt.fieldOrder)
+			if got := argValue(args, "-vf"); got != tt.want {
+				t.Errorf("-vf = %q, want %q", got, tt.want)
+			}
+			if slices.Contains(args, "-r") {
+				t.Errorf("args %v set -r next to the fps filter", args)
+			}
+		})
+	}
+
+	if !(&ProbeInfo{FieldOrder: "tb"}).IsInterlaced() || (&ProbeInfo{FieldOrder: "progressive"}).IsInterlaced() {
+		t.Error("IsInterlaced() does not match the interlaced field orders")
+	}
+}
+
+func TestParseFilterChain(t *testing.T) {
+	subtitles := "subtitles=" + escapeFilterValue("/in/Tom's, Jerry's.mkv") + ":si=0"
+	tests := []struct {
+		name  string
+		value string
+		want  FilterChain
+	}{
+		{"empty", "", nil},
+		{"single filter", "scale=1280:720", FilterChain{"scale=1280:720"}},
+		{"several filters", "yadif,scale=1920:1080:flags=lanczos,fps=30", FilterChain{"yadif", "scale=1920:1080:flags=lanczos", "fps=30"}},
+		{"escaped commas", "scale=1920:1080," + subtitles, FilterChain{"scale=1920:1080", subtitles}},
+		{"quoted commas", "drawtext=text='a, b':x=10,format=nv12", FilterChain{"drawtext=text='a, b':x=10", "format=nv12"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseFilterChain(tt.value)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseFilterChain(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+			if got.String() != tt.value {
+				t.Errorf("String() = %q, want the parsed value %q", got.String(), tt.value)
+			}
+		})
+	}
+
+	chain := FilterChain{"scale=1280:720"}
+	if got := chain.Prepend("yadif").Append("fps=25", "setsar=1").String(); got != "yadif,scale=1280:720,fps=25,setsar=1" {
+		t.Errorf("Prepend/Append = %q", got)
+	}
+	if len(chain) != 1 {
+		t.Errorf("Prepend/Append modified the chain: %q", chain)
+	}
+	if base, ok := ParseFilterChain("scale=1280:720," + vaapiUploadFilter).CutSuffix(ParseFilterChain(vaapiUploadFilter)); !ok || base.String() != "scale=1280:720" {
+		t.Errorf("CutSuffix() = %q, %v", base, ok)
+	}
+
+	args := []string{"-c:v", "libx264", "-vf", "scale=1280:720", "-crf", "23"}
+	if got := withFilterChain(args, nil); slices.Contains(got, "-vf") || len(got) != 4 {
+		t.Errorf("withFilterChain(nil) = %v, want -vf dropped", got)
+	}
+	if got := withFilterChain(args, chain.Append("fps=25")); argValue(got, "-vf") != "scale=1280:720,fps=25" || argValue(args, "-vf") != "scale=1280:720" {
+		t.Errorf("withFilterChain() = %v, input %v", got, args)
+	}
+}
+
+// TestFilterChain_Presets checks that the filter chains built for the
+// built-in presets of every platform render exactly as the preset's -vf
+func TestFilterChain_Presets(t *testing.T) {
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, NoProbe: true})
+	for _, platform := range []Platform{PlatformNVIDIA, PlatformAppleSilicon, PlatformSoftware, PlatformIntelQSV, PlatformAMD} {
+		for name, preset := range GetPresetsForPlatform(platform) {
+			vf := argValue(preset.Args, "-vf")
+			if got := filterChainOf(preset.Args).String(); got != vf {
+				t.Errorf("%s: chain = %q, want %q", name, got, vf)
+			}
+
+			want := vf
+			if want == "" {
+				want = "scale=-1:-1"
+			}
+			if got := argValue(tr.convertToSoftwarePreset(preset), "-vf"); got != want {
+				t.Errorf("%s: software fallback -vf = %q, want %q", name, got, want)
+			}
+
+			want = vf
+			if isVAAPIEncoder(argValue(preset.Args, "-c:v")) {
+				want = strings.TrimPrefix(vf+","+vaapiUploadFilter, ",")
+			}
+			if got := vaapiUpload(argValue(preset.Args, "-c:v"), filterChainOf(preset.Args)).String(); got != want {
+				t.Errorf("%s: hardware -vf = %q, want %q", name, got, want)
+			}
+			if argValue(preset.Args, "-vf") != vf {
+				t.Errorf("%s: preset -vf modified", name)
+			}
+		}
+	}
+}
+
+func TestResumeState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ResumeStateName)
+	state, err := LoadResumeState(path)
+	if err != nil {
+		t.Fatalf("LoadResumeState(missing) error = %v", err)
+	}
+	state.Set("/in/a.mp4", "1080p_h264", ResumeStatusDone)
+	state.Set("/in/b.mp4", "1080p_h264", ResumeStatusFailed)
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadResumeState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file, preset, want string
+	}{
+		{"/in/a.mp4", "1080p_h264", ResumeStatusDone},
+		{"/in/b.mp4", "1080p_h264", ResumeStatusFailed},
+		{"/in/a.mp4", "720p_h264", ""},
+		{"/in/c.mp4", "1080p_h264", ""},
+	}
+	for _, tt := range tests {
+		if got := loaded.Status(tt.file, tt.preset); got != tt.want {
+			t.Errorf("Status(%s, %s) = %q, want %q", tt.file, tt.preset, got, tt.want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadResumeState(path); err == nil {
+		t.Error("LoadResumeState(damaged) error = nil, want the damaged state reported")
+	}
+}
+
+func TestProcessFilesWithProgress_Resume(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a.mp4", "b.mp4", "c.mp4"} {
+		file := filepath.Join(inputDir, name)
+		if err := os.WriteFile(file, []byte("xx"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	statePath := filepath.Join(outputDir, ResumeStateName)
+
+	newTranscoder := func(preset string, retryFailed bool) *Transcoder {
+		tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: preset, NoGPU: true, NoProbe: true, ResumeFile: statePath, RetryFailed: retryFailed})
+		tr.prober = NewProber(&MockCommandExecutor{shouldFail: true})
+		if err := tr.OpenResumeState(); err != nil {
+			t.Fatal(err)
+		}
+		return tr
+	}
+
+	// b.mp4 fails and the run is cancelled while c.mp4 encodes
+	tr := newTranscoder("1080p_h264", false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		output := args[len(args)-1]
+		switch {
+		case output == "-":
+			return exec.CommandContext(ctx, "sh", "-c", "exit 0")
+		case strings.HasPrefix(filepath.Base(output), "b_"):
+			return exec.CommandContext(ctx, "sh", "-c", "exit 1")
+		case strings.HasPrefix(filepath.Base(output), "c_"):
+			cancel()
+			return exec.CommandContext(ctx, "sh", "-c", "sleep 5")
+		}
+		return exec.CommandContext(ctx, "sh", "-c", `echo x > "$0"`, output)
+	}
+	captureStdout(t, func() { tr.ProcessFilesWithProgress(ctx, files, nil) })
+
+	state, err := LoadResumeState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{"a.mp4": ResumeStatusDone, "b.mp4": ResumeStatusFailed, "c.mp4": ResumeStatusPending} {
+		if got := state.Status(filepath.Join(inputDir, file), "1080p_h264"); got != want {
+			t.Errorf("status of %s = %q, want %q", file, got, want)
+		}
+	}
+
+	var kept []string
+	out := captureStdout(t, func() { kept = newTranscoder("1080p_h264", false).FilterResumed(files) })
+	if !slices.Equal(kept, files[2:]) || !strings.Contains(out, "skipped 1 file(s) done and 1 failed") {
+		t.Errorf("FilterResumed() = %v, output %q, want only c.mp4 kept", kept, out)
+	}
+	captureStdout(t, func() { kept = newTranscoder("1080p_h264", true).FilterResumed(files) })
+	if !slices.Equal(kept, files[1:]) {
+		t.Errorf("FilterResumed() with --retry-failed = %v, want b.mp4 and c.mp4", kept)
+	}
+
+	// Another preset makes the entries stale
+	captureStdout(t, func() { kept = newTranscoder("720p_h264", false).FilterResumed(files) })
+	if !slices.Equal(kept, files) {
+		t.Errorf("FilterResumed() with another preset = %v, want every file", kept)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var out strings.Builder
+	log := NewLogger(&out, LogInfo)
+	log.Debugf("probing %s", "a.mkv")
+	log.Infof("Completed %s", "a.mkv")
+	log.Warnf("cannot measure quality of %s\n", "a.mkv")
+	log.Errorf("GPU check failed")
+	log.Logf(LogError, "  - %s", "b.mkv")
+	want := "Completed a.mkv\nWarning: cannot measure quality of a.mkv\nError: GPU check failed\n  - b.mkv\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	quiet := NewLogger(&out, LogWarn)
+	quiet.Infof("Completed a.mkv")
+	quiet.Warnf("disk almost full")
+	if out.String() != "Warning: disk almost full\n" {
+		t.Errorf("warn-level output = %q, want only the warning", out.String())
+	}
+
+	// Messages logged at once from many goroutines stay whole lines
+	out.Reset()
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				log.Infof("file-%02d done", i)
+			}
+		}()
+	}
+	wg.Wait()
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 1000 {
+		t.Fatalf("got %d lines, want 1000", len(lines))
+	}
+	for _, line := range lines {
+		if len(line) != len("file-00 done") || !strings.HasPrefix(line, "file-") || !strings.HasSuffix(line, " done") {
+			t.Fatalf("garbled line %q", line)
+		}
+	}
+
+	for i, name := range LogLevels {
+		if level, err := ParseLogLevel(strings.ToUpper(name)); err != nil || level != LogLevel(i) || level.String() != name {
+			t.Errorf("ParseLogLevel(%s) = %v, %v", name, level, err)
+		}
+	}
+	if _, err := ParseLogLevel("trace"); err == nil {
+		t.Error("ParseLogLevel(trace) error = nil")
+	}
+}
+
+func TestLogLevelConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		want        LogLevel
+		wantVerbose bool
+	}{
+		{"default", Config{}, LogInfo, false},
+		{"verbose", Config{Verbose: true}, LogDebug, true},
+		{"debug implies verbose", Config{LogLevel: "debug"}, LogDebug, true},
+		{"quiet", Config{LogLevel: "warn"}, LogWarn, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.InputPath, tt.config.OutputDir, tt.config.NoGPU = "/in", "/out", true
+			tr := New(tt.config)
+			if tr.Logger().Level() != tt.want || tr.config.Verbose != tt.wantVerbose {
+				t.Errorf("level = %v, verbose = %v, want %v, %v", tr.Logger().Level(), tr.config.Verbose, tt.want, tt.wantVerbose)
+			}
+		})
+	}
+	if err := (&Config{InputPath: "/in", OutputDir: "/out", Preset: "1080p_h264", LogLevel: "loud"}).Validate(); err == nil {
+		t.Error("Validate() accepted an unknown log level")
+	}
+
+	// ffmpeg's output reaches the log as debug after a success and as a
+	// warning, trimmed to its last lines, after a failure
+	var out strings.Builder
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true})
+	tr.log = NewLogger(&out, LogInfo)
+	tr.logFFmpegOutput("a.mkv", "[mp4 @ 0x1] deprecated option\n", false)
+	if out.Len() != 0 {
+		t.Errorf("warnings of a successful encode logged at info: %q", out.String())
+	}
+	var stderr []string
+	for i := range 15 {
+		stderr = append(stderr, fmt.Sprintf("line %d", i))
+	}
+	tr.logFFmpegOutput("a.mkv", strings.Join(stderr, "\n"), true)
+	if got := out.String(); !strings.HasPrefix(got, "Warning: ffmpeg failed for a.mkv:\n  line 5\n") || !strings.HasSuffix(got, "  line 14\n") {
+		t.Errorf("failure output = %q, want the last 10 lines as a warning", got)
+	}
+}
+
+func TestCommandLog(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	input := filepath.Join(inputDir, "movie.mp4")
+	if err := os.WriteFile(input, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(t.TempDir(), "ffmcli.log")
+
+	tr := New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: "1080p_h264", NoProbe: true, LogFile: logPath})
+	tr.prober = NewProber(&MockCommandExecutor{shouldFail: true})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformNVIDIA}
+	// The GPU encode fails with more output than the console shows; the
+	// software fallback succeeds
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		script := `[ "$0" = - ] && exit 0
+case "$1" in *h264_nvenc*)
+	for i in 1 2 3 4 5 6 7 8 9 10 11 12; do echo "stderr line $i" >&2; done
+	echo "Cannot load libcuda.so.1" >&2
+	exit 1;;
+esac
+echo encoded > "$0"`
+		return exec.CommandContext(ctx, "sh", "-c", script, args[len(args)-1], strings.Join(args, " "))
+	}
+	if err := tr.OpenCommandLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	preset := GetPresetsForPlatform(PlatformNVIDIA)["1080p_h264"]
+	var result *FileResult
+	var err error
+	captureStdout(t, func() {
+		result, err = tr.processPreset(context.Background(), input, preset, nil)
+	})
+	if err != nil || result.EncodingMode != EncodingModeSoftwareFallback {
+		t.Fatalf("processPreset() = %+v, %v, want a software fallback", result, err)
+	}
+	tr.Cleanup()
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	for _, want := range []string{
+		"=== ", input + " (preset 1080p_h264) ===",
+		"--- Command 1 ---\nffmpeg ", "h264_nvenc", "Exit status: 1 after",
+		"stderr line 1\n", "stderr line 12\nCannot load libcuda.so.1\n",
+		"--- Command 2 ---\nffmpeg ", "libx264", "Exit status: 0 after", "Stderr: (empty)",
+		"Result: encoded with fallback software_fallback",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log file lacks %q:\n%s", want, log)
+		}
+	}
+	if strings.Count(log, "=== ") != 1 {
+		t.Errorf("want a single section for the input:\n%s", log)
+	}
+
+	if got := exitStatus(nil); got != "0" {
+		t.Errorf("exitStatus(nil) = %q", got)
+	}
+	if got := exitStatus(errors.New("executable file not found")); got != "executable file not found" {
+		t.Errorf("exitStatus() of a command that never ran = %q", got)
+	}
+}
+
+func TestSmartBitrate(t *testing.T) {
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", Preset: "1080p_h264", NoGPU: true, SmartBitrate: true})
+	preset := tr.presets["1080p_h264"]
+	probe := func(format, video string) *Prober {
+		return NewProber(&scriptedExecutor{outputs: map[string]string{"ffprobe": `{"format": {"duration": "60", "bit_rate": "` + format +
+			`"}, "streams": [{"codec_type": "video", "codec_name": "h264", "bit_rate": "` + video + `"}]}`}})
+	}
+
+	// The stream's bitrate is preferred to the overall one, which includes audio
+	tr.prober = probe("2000000", "1500000")
+	rate, source, capped := tr.smartBitrate("/in/low.mkv", preset)
+	if !capped || source != 1.5e6 || rate != 1.2e6 {
+		t.Fatalf("smartBitrate() of a 1.5M source = %v, %v, %v; want 1.2M capped", rate, source, capped)
+	}
+	tr.setTargetBitrate("/in/low.mkv", preset.Name, rate)
+	args := tr.buildFFmpegArgs("/in/low.mkv", "/out/low.mkv", preset, false)
+	for flag, want := range map[string]string{"-b:v": "1200k", "-maxrate": "1920k", "-bufsize": "3840k"} {
+		if got := argValue(args, flag); got != want {
+			t.Errorf("%s = %s, want %s scaled with the target", flag, got, want)
+		}
+	}
+
+	// Without a stream bitrate the overall one is used, and a source above
+	// the preset bitrate never raises it
+	tr.prober = probe("20000000", "")
+	if rate, source, capped := tr.smartBitrate("/in/high.mkv", preset); capped || source != 20e6 {
+		t.Errorf("smartBitrate() of a 20M source = %v, %v, %v; want the preset bitrate", rate, source, capped)
+	}
+	tr.prober = NewProber(&MockCommandExecutor{shouldFail: true})
+	if _, _, capped := tr.smartBitrate("/in/unknown.mkv", preset); capped {
+		t.Error("smartBitrate() capped a source that could not be probed")
+	}
+
+	record := AnalyticsRecord{Status: "success", TargetBitrate: 1.2e6, BitrateCapped: true}
+	if row := record.csvRow(); row[slices.Index(csvHeader, "bitrate_capped")] != "true" || row[slices.Index(csvHeader, "target_bitrate_kbps")] != "1200" {
+		t.Errorf("csvRow() = %v, want the capped target and bitrate_capped true", row)
+	}
+	if !record.jsonRecord().BitrateCapped {
+		t.Error("jsonRecord() lost bitrate_capped")
+	}
+	crf := 20
+	if err := (&Config{InputPath: "/in", OutputDir: "/out", Preset: "1080p_h264", SmartBitrate: true, CRF: &crf}).Validate(); err == nil {
+		t.Error("Validate() accepted --smart-bitrate with --crf")
+	}
+}
+
+func TestSmartBitrateSeveralPresets(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(input, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr := New(Config{InputPath: input, OutputDir: t.TempDir(), Preset: "4k_h265", Presets: []string{"4k_h265", "720p_h264"}, NoGPU: true, SmartBitrate: true})
+	tr.prober = NewProber(&scriptedExecutor{outputs: map[string]string{"ffprobe": `{"format": {"duration": "60", "bit_rate": "10000000"}, "streams": [{"codec_type": "video", "codec_name": "h264", "width": 3840, "height": 2160, "bit_rate": "10000000"}]}`}})
+	rates := make(map[string]string)
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		output := args[len(args)-1]
+		rates[filepath.Base(output)] = argValue(args, "-b:v")
+		return exec.CommandContext(ctx, "sh", "-c", `[ "$0" = - ] || echo encoded > "$0"`, output)
+	}
+	captureStdout(t, func() {
+		if _, err := tr.processFile(context.Background(), input, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// The 4K preset's 20M is capped below the source, and the cap must not
+	// raise the lower bitrate of the 720p preset encoded after it
+	want := map[string]string{
+		"movie_4k_h265.mkv":   "8M",
+		"movie_720p_h264.mkv": argValue(tr.videoArgs(tr.presets["720p_h264"], false), "-b:v"),
+	}
+	for output, rate := range want {
+		if got, ok := rates[output]; !ok || got != rate {
+			t.Errorf("%s encoded at -b:v %q, want %s (all rates: %v)", output, got, rate, rates)
+		}
+	}
+}
+
+// fractionRecorder records the in-file progress reported to it
+type fractionRecorder struct {
+	fractions []float64
+}
+
+func (r *fractionRecorder) Update(status encodeStatus) {
+	r.fractions = append(r.fractions, status.Fraction())
+}
+
+func (r *fractionRecorder) Done() {}
+
+func TestProgressSeveralPresets(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(input, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr := New(Config{InputPath: input, OutputDir: t.TempDir(), Preset: "1080p_h264", Presets: []string{"1080p_h264", "720p_h264"}, NoGPU: true})
+	tr.prober = NewProber(&scriptedExecutor{outputs: map[string]string{"ffprobe": `{"format": {"duration": "10"}, "streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}]}`}})
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `printf 'out_time_us=5000000\nprogress=continue\nout_time_us=10000000\nprogress=end\n'; [ "$0" = - ] || echo encoded > "$0"`, args[len(args)-1])
+	}
+	progress := &fractionRecorder{}
+	captureStdout(t, func() {
+		if _, err := tr.processFile(context.Background(), input, progress); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Each preset fills its half of the file's progress
+	if want := []float64{0.25, 0.5, 0.75, 1}; !reflect.DeepEqual(progress.fractions, want) {
+		t.Errorf("progress over two presets = %v, want %v", progress.fractions, want)
+	}
+}
+
+func TestDescribePreset(t *testing.T) {
+	tr := New(Config{Preset: "1080p_h265", NoProbe: true, SkipValidation: true})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformNVIDIA}
+
+	detail, err := tr.DescribePreset("1080p_h265")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !detail.Hardware || detail.PresetPlatform != PlatformNVIDIA.String() || detail.FallbackEncoder != "libx265" {
+		t.Errorf("DescribePreset() = %+v", detail)
+	}
+	if argValue(detail.Args, "-c:v") != "hevc_nvenc" || argValue(detail.FallbackArgs, "-c:v") != "libx265" {
+		t.Errorf("commands use %s and %s, want hevc_nvenc with a libx265 fallback",
+			argValue(detail.Args, "-c:v"), argValue(detail.FallbackArgs, "-c:v"))
+	}
+	if detail.Args[len(detail.Args)-1] != describeOutput || argValue(detail.Args, "-i") != describeInput {
+		t.Errorf("command %v lacks the placeholder paths", detail.Args)
+	}
+
+	// Off its platform a preset only ever encodes in software
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformSoftware}
+	if detail, err := tr.DescribePreset("1080p_h265"); err != nil || detail.Hardware || argValue(detail.Args, "-c:v") != "libx265" {
+		t.Errorf("DescribePreset() on a software platform = %+v, %v", detail, err)
+	}
+
+	_, err = tr.DescribePreset("1080p_h266")
+	if !IsTranscoderError(err, ErrorTypeInvalidPreset) || !strings.Contains(err.Error(), "1080p_h265") {
+		t.Errorf("DescribePreset() of an unknown name = %v, want an invalid preset error listing the presets", err)
+	}
+}
+
+func TestEstimateFromSamples(t *testing.T) {
+	inputDir := t.TempDir()
+	tempDir := t.TempDir()
+	good := filepath.Join(inputDir, "good.mp4")
+	bad := filepath.Join(inputDir, "bad.mp4")
+	for _, input := range []string{good, bad} {
+		if err := os.WriteFile(input, make([]byte, 100000), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tr := New(Config{InputPath: inputDir, OutputDir: t.TempDir(), Preset: "1080p_h264", NoGPU: true, TempDir: tempDir})
+	tr.prober = NewProber(&scriptedExecutor{outputs: map[string]string{"ffprobe": `{"format": {"duration": "600"}, "streams": [{"codec_type": "video", "codec_name": "h264"}]}`}})
+	var sampled [][]string
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		sampled = append(sampled, args)
+		// A 30s sample of 1000 bytes; the sample of bad.mp4 fails
+		script := `case "$1" in *bad.mp4*) echo "Invalid data found when processing input" >&2; exit 1;; esac
+head -c 1000 /dev/zero > "$0"`
+		return exec.CommandContext(ctx, "sh", "-c", script, args[len(args)-1], strings.Join(args, " "))
+	}
+
+	var estimate *RunEstimate
+	output := captureStdout(t, func() {
+		estimate = tr.EstimateFromSamples(context.Background(), []string{good, bad}, nil, 30*time.Second)
+	})
+	if estimate.Files != 2 || estimate.FromSamples != 1 || estimate.FromDefaults != 1 {
+		t.Fatalf("estimate = %+v, want one file from its sample and one from defaults", estimate)
+	}
+	// 1000 bytes for 30 of 600 seconds, plus half the failed file's size
+	if want := int64(20000 + 50000); estimate.OutputBytes != want {
+		t.Errorf("OutputBytes = %d, want %d", estimate.OutputBytes, want)
+	}
+	if args := sampled[0]; argValue(args, "-ss") != "285" || argValue(args, "-t") != "30" {
+		t.Errorf("sample command %v, want 30s from the middle", args)
+	}
+	if !strings.Contains(output, "sample encode of "+bad+" failed: Invalid data found when processing input") {
+		t.Errorf("failed sample not reported:\n%s", output)
+	}
+	if leftover, _ := filepath.Glob(filepath.Join(tempDir, "*")); len(leftover) != 0 {
+		t.Errorf("samples left behind: %v", leftover)
+	}
+
+	var printed strings.Builder
+	PrintEstimate(&printed, estimate)
+	if !strings.Contains(printed.String(), "1 file(s) projected from encoding a 30s sample of each.") {
+		t.Errorf("PrintEstimate() =\n%s", printed.String())
+	}
+}
+
+func TestCrop(t *testing.T) {
+	for _, value := range []string{"1920:800", "1920:800:0:-2", "0:800:0:140", "w:h:x:y"} {
+		if _, err := ParseCrop(value); !IsTranscoderError(err, ErrorTypeInvalidOption) {
+			t.Errorf("ParseCrop(%q) error = %v, want invalid", value, err)
+		}
+	}
+	if crop, err := ParseCrop(" 1920:800:0:140 "); err != nil || crop != (Crop{1920, 800, 0, 140}) {
+		t.Errorf("ParseCrop() = %v, %v", crop, err)
+	}
+	stderr := "[Parsed_cropdetect_0 @ 0x1] x1:0 x2:1919 y1:0 y2:1079 w:1920 h:1080 x:0 y:0 pts:1 t:0.04 crop=1920:1080:0:0\n" +
+		strings.Repeat("[Parsed_cropdetect_0 @ 0x1] x1:0 x2:1919 y1:140 y2:939 w:1920 h:800 x:0 y:140 pts:2 t:0.08 crop=1920:800:0:140\n", 3)
+	if crop, ok := parseCropDetect(stderr); !ok || crop.String() != "1920:800:0:140" {
+		t.Errorf("parseCropDetect() = %v, %v, want the most common suggestion", crop, ok)
+	}
+
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", Preset: "1080p_h264", NoGPU: true, Crop: CropAuto})
+	tr.prober = NewProber(&scriptedExecutor{outputs: map[string]string{"ffprobe": `{"format": {"duration": "600"}, "streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}]}`}})
+	var detectArgs []string
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		detectArgs = args
+		return exec.CommandContext(ctx, "sh", "-c", `printf '%s' "$0" >&2`, stderr)
+	}
+	captureStdout(t, func() {
+		if err := tr.chooseCrop(context.Background(), "/in/movie.mkv"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if argValue(detectArgs, "-ss") != "270" || argValue(detectArgs, "-t") != "60" || argValue(detectArgs, "-vf") != cropDetectFilter {
+		t.Errorf("cropdetect command %v, want 60s from the middle", detectArgs)
+	}
+	// The crop comes first, and the preset's 16:9 box is fitted to the
+	// cropped shape instead of stretching it
+	args := tr.buildFFmpegArgs("/in/movie.mkv", "/out/movie.mkv", tr.presets["1080p_h264"], false)
+	if got := filterChainOf(args); len(got) != 2 || got[0] != "crop=1920:800:0:140" || got[1] != "scale=1920:800" {
+		t.Errorf("-vf = %v, want the crop before a scale fitted to it", got)
+	}
+
+	// An explicit crop must fit the frame, and one covering it crops nothing
+	tr.config.Crop = "1920:800:0:400"
+	if err := tr.chooseCrop(context.Background(), "/in/tall.mkv"); !IsTranscoderError(err, ErrorTypeInvalidOption) {
+		t.Errorf("chooseCrop() of a crop outside the frame = %v", err)
+	}
+	tr.config.Crop = "1920:1080:0:0"
+	if err := tr.chooseCrop(context.Background(), "/in/full.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	if got := filterChainOf(tr.buildFFmpegArgs("/in/full.mkv", "/out/full.mkv", tr.presets["1080p_h264"], false)); got[0] != "scale=1920:1080" {
+		t.Errorf("-vf = %v for a crop covering the frame, want it left out", got)
+	}
+}
+
+func TestPreserveMetadata(t *testing.T) {
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", Preset: "1080p_h264", NoProbe: true})
+	tr.systemChecker = &SystemChecker{executor: &MockCommandExecutor{}, platform: PlatformNVIDIA}
+	preset := tr.presets["1080p_h264"]
+	commands := map[string][]string{
+		"hardware":      tr.buildFFmpegArgs("/in/a.mkv", "/out/a.mkv", preset, true),
+		"software":      tr.buildFFmpegArgs("/in/a.mkv", "/out/a.mkv", preset, false),
+		"safe fallback": tr.createSafeFallbackArgs("/in/a.mkv", "/out/a.mkv"),
+	}
+	for name, args := range commands {
+		if argValue(args, "-map_metadata") != "0" || argValue(args, "-map_chapters") != "0" {
+			t.Errorf("%s command %v does not copy the source's metadata and chapters", name, args)
+		}
+	}
+	tr.config.StripMetadata = true
+	if args := tr.buildFFmpegArgs("/in/a.mkv", "/out/a.mkv", preset, true); argValue(args, "-map_metadata") != "-1" || argValue(args, "-map_chapters") != "-1" {
+		t.Errorf("--strip-metadata command %v keeps the source's metadata", args)
+	}
+
+	// A finished output takes over the source's modification time
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	input := filepath.Join(inputDir, "movie.mp4")
+	if err := os.WriteFile(input, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recorded := time.Date(2019, 7, 14, 18, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(input, recorded, recorded); err != nil {
+		t.Fatal(err)
+	}
+	tr = New(Config{InputPath: inputDir, OutputDir: outputDir, Preset: "1080p_h264", NoGPU: true, NoProbe: true})
+	tr.prober = NewProber(&MockCommandExecutor{shouldFail: true})
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `[ "$0" = - ] || echo encoded > "$0"`, args[len(args)-1])
+	}
+	var result *FileResult
+	captureStdout(t, func() {
+		var err error
+		if result, err = tr.processFile(context.Background(), input, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	info, err := os.Stat(result.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(recorded) {
+		t.Errorf("output modification time = %v, want the source's %v", info.ModTime(), recorded)
+	}
+}
+
+func TestNameTemplate(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"movie.mp4", "movie.avi"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modified := time.Date(2024, 5, 17, 12, 0, 0, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(dir, "movie.mp4"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	preset := GetPresets()["1080p_h265"]
+
+	p := NewPathUtils()
+	if err := p.SetNameTemplate("{name}-{codec}-{height}p"); err != nil {
+		t.Fatal(err)
+	}
+	if out := filepath.Base(p.GenerateOutputPath(filepath.Join(dir, "movie.mp4"), "/out", dir, preset)); out != "movie-h265-1080p.mkv" {
+		t.Errorf("GenerateOutputPath() = %s, want movie-h265-1080p.mkv", out)
+	}
+	p.SetNameTemplate("{date} {name} ({resolution}, {ext})")
+	p.SetSuffix("crf20")
+	if out := filepath.Base(p.GenerateOutputPath(filepath.Join(dir, "movie.mp4"), "/out", dir, preset)); out != "2024-05-17 movie (1920x1080, mp4)_crf20.mkv" {
+		t.Errorf("GenerateOutputPath() = %s, want 2024-05-17 movie (1920x1080, mp4)_crf20.mkv", out)
+	}
+
+	// A long source name is shortened so the fields after it survive
+	p.SetNameTemplate("{name}.{preset}")
+	p.SetSuffix("")
+	if out := filepath.Base(p.GenerateOutputPath("/videos/"+strings.Repeat("x", 300)+".mp4", "/out", "/videos", preset)); !strings.HasSuffix(out, ".1080p_h265.mkv") || len(out) > 255 {
+		t.Errorf("GenerateOutputPath() with a long name = %s", out)
+	}
+
+	// The default template keeps the usual names
+	p.SetNameTemplate(DefaultNameTemplate)
+	if out := filepath.Base(p.GenerateOutputPath(filepath.Join(dir, "movie.mp4"), "/out", dir, preset)); out != "movie_1080p_h265.mkv" {
+		t.Errorf("GenerateOutputPath() with the default template = %s", out)
+	}
+
+	for _, template := range []string{"", "{name}_{title}", "{name}}", "{name"} {
+		if err := ValidateNameTemplate(template); err == nil {
+			t.Errorf("ValidateNameTemplate(%q) accepted", template)
+		}
+	}
+
+	files := []string{filepath.Join(dir, "movie.mp4"), filepath.Join(dir, "movie.avi")}
+	tr := New(Config{InputPath: dir, OutputDir: t.TempDir(), Preset: "1080p_h265", NameTemplate: "{name}-{codec}"})
+	if err := tr.CheckOutputNames(files); err == nil || !strings.Contains(err.Error(), "the same output") {
+		t.Errorf("CheckOutputNames() = %v, want a collision error", err)
+	}
+	tr = New(Config{InputPath: dir, OutputDir: t.TempDir(), Preset: "1080p_h265", NameTemplate: "{name}-{ext}-{codec}"})
+	if err := tr.CheckOutputNames(files); err != nil {
+		t.Errorf("CheckOutputNames() with {ext} = %v", err)
+	}
+
+	// The default template collides the same way
+	tr = New(Config{InputPath: dir, OutputDir: t.TempDir(), Preset: "1080p_h265"})
+	if err := tr.CheckOutputNames(files); err == nil || !strings.Contains(err.Error(), "'{name}_{preset}'") {
+		t.Errorf("CheckOutputNames() with the default template = %v, want a collision error", err)
+	}
+	if err := tr.CheckOutputNames(files[:1]); err != nil {
+		t.Errorf("CheckOutputNames() of one file = %v", err)
+	}
+
+	// Several presets of one file need {preset} or another field that differs
+	tr = New(Config{InputPath: dir, OutputDir: t.TempDir(), Presets: []string{"1080p_h265", "1080p_h264"}, NameTemplate: "{name}-{height}p"})
+	if err := tr.CheckOutputNames(files[:1]); err == nil {
+		t.Error("CheckOutputNames() accepted two presets with one output")
+	}
+}
+
+func TestSkipIfCodec(t *testing.T) {
+	preset := GetPresets()["1080p_h265"]
+	for _, tt := range []struct {
+		codec         string
+		width, height int
+		want          bool
+	}{
+		{"hevc", 1920, 1080, true},
+		{"HEVC", 1280, 720, true},
+		{"hevc", 1080, 1920, true}, // Portrait of the same size
+		{"hevc", 3840, 2160, false},
+		{"h264", 1920, 1080, false},
+		{"hevc", 0, 0, false},
+	} {
+		info := &ProbeInfo{VideoCodec: tt.codec, Width: tt.width, Height: tt.height}
+		if got := inTargetCodec(info, preset); got != tt.want {
+			t.Errorf("inTargetCodec(%s %dx%d) = %v, want %v", tt.codec, tt.width, tt.height, got, tt.want)
+		}
+	}
+	for preset, want := range map[string]string{"1080p_h264": "h264", "1080p_av1": "av1", "1080p_h265": "hevc"} {
+		if got := presetVideoCodec(GetPresets()[preset]); got != want {
+			t.Errorf("presetVideoCodec(%s) = %s, want %s", preset, got, want)
+		}
+	}
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(input, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr := New(Config{InputPath: input, OutputDir: t.TempDir(), Preset: "1080p_h265", NoGPU: true, SkipIfCodec: true})
+	tr.prober = NewProber(&scriptedExecutor{outputs: map[string]string{"ffprobe": `{"format": {"duration": "60"}, "streams": [{"codec_type": "video", "codec_name": "hevc", "width": 1280, "height": 720}]}`}})
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		t.Errorf("ran %s for a source already in the target codec", name)
+		return exec.CommandContext(ctx, "true")
+	}
+	result, err := tr.processFile(context.Background(), input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Skipped || result.SkipReason != SkipReasonTargetCodec {
+		t.Fatalf("processFile() = %+v, want skipped as already in the target codec", result)
+	}
+
+	summary := summarizeBatch([]string{input}, []*FileResult{result}, []error{nil}, nil, time.Second)
+	if summary.Skipped != 1 || summary.AlreadyTarget != 1 {
+		t.Errorf("summarizeBatch() = %+v, want one file skipped in the target codec", summary)
+	}
+	var out bytes.Buffer
+	printBatchSummary(&out, summary, "")
+	if !strings.Contains(out.String(), "Already target 1") {
+		t.Errorf("batch summary does not list the file in the target codec:\n%s", out.String())
+	}
+	if estimate := tr.EstimateRun([]string{input}, nil); estimate.InCodec != 1 || estimate.Files != 0 {
+		t.Errorf("EstimateRun() = %+v, want the file left out", estimate)
+	}
+}
+
+func TestAudioBitrateAndCodecs(t *testing.T) {
+	for _, tt := range []struct {
+		target, bitrate string
+		sources         []string
+		want            []string
+	}{
+		{"opus", "192k", []string{"aac"}, []string{"-c:a", "libopus", "-b:a", "192k"}},
+		{"opus", "192k", []string{"opus"}, []string{"-c:a", "copy"}},
+		{"flac", "192k", []string{"dts"}, []string{"-c:a", "flac"}},
+		{"aac", "256k", []string{"aac", "flac"}, []string{"-c:a:0", "copy", "-c:a:1", "aac", "-b:a:1", "256k"}},
+	} {
+		if got := buildAudioArgs(tt.target, tt.bitrate, "", tt.sources, true); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("buildAudioArgs(%s, %s, %v) = %v, want %v", tt.target, tt.bitrate, tt.sources, got, tt.want)
+		}
+	}
+
+	if got, err := ParseAudioBitrate("192000"); err != nil || got != "192k" {
+		t.Errorf("ParseAudioBitrate(192000) = %s, %v; want 192k", got, err)
+	}
+	for _, value := range []string{"", "4k", "2M", "loud"} {
+		if _, err := ParseAudioBitrate(value); err == nil {
+			t.Errorf("ParseAudioBitrate(%q) accepted", value)
+		}
+	}
+	for _, tt := range []struct {
+		codec, bitrate, container string
+		ok                        bool
+	}{
+		{"opus", "96k", ContainerMKV, true},
+		{"copy", "", ContainerMKV, true},
+		{"copy", "96k", ContainerWebM, true}, // WebM re-encodes to opus
+		{"copy", "96k", ContainerMKV, false},
+		{"flac", "96k", ContainerMKV, false},
+	} {
+		if err := CheckAudioBitrate(tt.codec, tt.bitrate, tt.container, false); (err == nil) != tt.ok {
+			t.Errorf("CheckAudioBitrate(%s, %s, %s) = %v", tt.codec, tt.bitrate, tt.container, err)
+		}
+	}
+	if err := CheckContainer(ContainerWebM, "libvpx-vp9", "ac3"); err == nil || !strings.Contains(err.Error(), "webm cannot hold ac3 audio") {
+		t.Errorf("CheckContainer(webm, ac3) = %v, want a clear error", err)
+	}
+	if err := CheckContainer(ContainerWebM, "libvpx-vp9", "opus"); err != nil {
+		t.Errorf("CheckContainer(webm, opus) = %v", err)
+	}
+
+	// WebM outputs default to opus, at the configured bitrate
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, Preset: "1080p_av1", Container: ContainerWebM, AudioBitrate: "160k"})
+	tr.prober = NewProber(&pathProbeExecutor{probes: map[string]string{
+		"/in/movie.mkv": `{"streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}, {"codec_type": "audio", "codec_name": "ac3"}], "format": {"duration": "60"}}`,
+	}})
+	args := tr.buildFFmpegArgs("/in/movie.mkv", "/out/movie.webm", tr.presets["1080p_av1"], false)
+	if argValue(args, "-c:a") != "libopus" || argValue(args, "-b:a") != "160k" {
+		t.Errorf("buildFFmpegArgs() for webm = %v, want ac3 re-encoded to opus at 160k", args)
+	}
+	if args := tr.createSafeFallbackArgs("/in/movie.mkv", "/out/movie.webm"); argValue(args, "-b:a") != "160k" {
+		t.Errorf("createSafeFallbackArgs() for webm = %v, want opus at 160k", args)
+	}
+}
+
+func TestNormalizeAudio(t *testing.T) {
+	single := singlePassLoudnorm()
+	for _, tt := range []struct {
+		target string
+		want   []string
+	}{
+		{"copy", []string{"-c:a", "aac", "-b:a", "128k", "-af", single}},
+		{"aac", []string{"-c:a", "aac", "-b:a", "128k", "-af", single}},
+		{"flac", []string{"-c:a", "flac", "-af", single}},
+	} {
+		// Audio already in the target codec is encoded too, since it is filtered
+		if got := buildAudioArgs(tt.target, DefaultAudioBitrate, single, []string{"aac"}, true); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("buildAudioArgs(%s) with loudnorm = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+	if err := CheckAudioBitrate("copy", "192k", ContainerMKV, true); err != nil {
+		t.Errorf("CheckAudioBitrate() with --normalize-audio = %v, want the bitrate of the override accepted", err)
+	}
+
+	stderr := `[Parsed_loudnorm_0 @ 0x5598] 
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-22.98",
+	"target_offset" : "-0.02"
+}
+`
+	m, ok := parseLoudnorm(stderr)
+	if !ok || m.InputI != "-27.61" || m.TargetOffset != "-0.02" {
+		t.Fatalf("parseLoudnorm() = %+v, %v", m, ok)
+	}
+	if _, ok := parseLoudnorm(strings.Replace(stderr, `"-27.61"`, `"-inf"`, 1)); ok {
+		t.Error("parseLoudnorm() accepted the -inf of a silent source")
+	}
+
+	audio := func(tracks int) *Prober {
+		streams := `{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}`
+		for i := 0; i < tracks; i++ {
+			streams += `, {"codec_type": "audio", "codec_name": "ac3"}`
+		}
+		return NewProber(&scriptedExecutor{outputs: map[string]string{"ffprobe": `{"format": {"duration": "60"}, "streams": [` + streams + `]}`}})
+	}
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, Preset: "1080p_h264", NormalizeTwoPass: true})
+	if !tr.config.NormalizeAudio {
+		t.Fatal("--normalize-audio-2pass does not imply --normalize-audio")
+	}
+	tr.prober = audio(1)
+	var measureArgs []string
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		measureArgs = args
+		return exec.CommandContext(ctx, "sh", "-c", `printf '%s' "$0" >&2`, stderr)
+	}
+	if err := tr.chooseLoudnorm(context.Background(), "/in/movie.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	if argValue(measureArgs, "-map") != "0:a:0" || !strings.Contains(argValue(measureArgs, "-af"), "print_format=json") {
+		t.Errorf("measuring pass = %v", measureArgs)
+	}
+	var args []string
+	output := captureStdout(t, func() {
+		args = tr.buildFFmpegArgs("/in/movie.mkv", "/out/movie.mkv", tr.presets["1080p_h264"], false)
+	})
+	filter := argValue(args, "-af")
+	if argValue(args, "-c:a") != "aac" || !strings.Contains(filter, "measured_I=-27.61") || !strings.Contains(filter, "linear=true") {
+		t.Errorf("second pass = %v, want aac with the measured loudness", args)
+	}
+	if !strings.Contains(output, "--audio-codec copy is overridden") {
+		t.Errorf("no warning about overriding copy: %q", output)
+	}
+
+	// Several kept tracks cannot share one measurement
+	measureArgs = nil
+	tr.prober = audio(2)
+	if err := tr.chooseLoudnorm(context.Background(), "/in/tracks.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	if measureArgs != nil || tr.loudnorm("/in/tracks.mkv") != single {
+		t.Errorf("source with two tracks measured with %v, filter %s; want a single pass", measureArgs, tr.loudnorm("/in/tracks.mkv"))
+	}
+}
+
+func TestThumbnails(t *testing.T) {
+	if got := ThumbnailPath("/out/movie_1080p_h264.mkv"); got != "/out/movie_1080p_h264.jpg" {
+		t.Errorf("ThumbnailPath() = %s", got)
+	}
+	if got := ContactSheetPath("/out/movie_1080p_h264.mkv"); got != "/out/movie_1080p_h264_sheet.jpg" {
+		t.Errorf("ContactSheetPath() = %s", got)
+	}
+	for _, tt := range []struct{ n, columns, rows int }{{2, 2, 1}, {9, 3, 3}, {12, 4, 3}, {17, 5, 4}} {
+		if columns, rows := contactSheetLayout(tt.n); columns != tt.columns || rows != tt.rows {
+			t.Errorf("contactSheetLayout(%d) = %dx%d, want %dx%d", tt.n, columns, rows, tt.columns, tt.rows)
+		}
+	}
+	for frames, ok := range map[int]bool{0: true, 1: false, 2: true, MaxContactSheetFrames: true, MaxContactSheetFrames + 1: false} {
+		if err := CheckContactSheet(frames); (err == nil) != ok {
+			t.Errorf("CheckContactSheet(%d) = %v", frames, err)
+		}
+	}
+
+	args := thumbnailArgs("/out/a.mkv", "/out/a.jpg", 12*time.Second, true)
+	if argValue(args, "-ss") != "12" || argValue(args, "-vf") != "thumbnail" || args[len(args)-1] != "/out/a.jpg" {
+		t.Errorf("thumbnailArgs() = %v", args)
+	}
+	args = contactSheetArgs("/out/a.mkv", "/out/a_sheet.jpg", 130*time.Second, 12)
+	if argValue(args, "-ss") != "10" || argValue(args, "-t") != "120" ||
+		argValue(args, "-vf") != "fps=1/10,scale=320:-2,tile=4x3:padding=4:margin=4" {
+		t.Errorf("contactSheetArgs() = %v", args)
+	}
+
+	if err := (&Config{InputPath: "/in", OutputDir: "/out", ThumbnailAt: "90"}).Validate(); err != nil {
+		t.Errorf("Validate() with --thumbnail-at 90 = %v", err)
+	}
+	if err := (&Config{InputPath: "/in", OutputDir: "/out", ThumbnailAt: "soon"}).Validate(); err == nil {
+		t.Error("Validate() accepted --thumbnail-at soon")
+	}
+	tr := New(Config{InputPath: "/in", OutputDir: "/out", NoGPU: true, Preset: "1080p_h264", ThumbnailAt: "00:01:30", ContactSheet: 4})
+	if !tr.config.Thumbnail {
+		t.Fatal("--thumbnail-at does not imply --thumbnail")
+	}
+	if at, pick := tr.thumbnailPosition(10 * time.Minute); at != 90*time.Second || pick {
+		t.Errorf("thumbnailPosition() = %s, %v; want the --thumbnail-at position", at, pick)
+	}
+	var at time.Duration
+	var pick bool
+	output := captureStdout(t, func() { at, pick = tr.thumbnailPosition(time.Minute) })
+	if at != 6*time.Second || !pick || !strings.Contains(output, "past the end") {
+		t.Errorf("thumbnailPosition() past the end = %s, %v (%q); want the filter's pick at 10%%", at, pick, output)
+	}
+
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "movie_1080p_h264.mkv")
+	probe := func(duration string) *Prober {
+		return NewProber(&scriptedExecutor{outputs: map[string]string{"ffprobe": `{"format": {"duration": "` + duration + `"}, "streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}]}`}})
+	}
+	var commands [][]string
+	writeLast := func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		commands = append(commands, args)
+		return exec.CommandContext(ctx, "sh", "-c", `printf jpeg > "$0"`, args[len(args)-1])
+	}
+
+	// A clip shorter than a second gets neither image
+	tr.prober = probe("0.5")
+	tr.commandContext = writeLast
+	output = captureStdout(t, func() { tr.writeThumbnails(context.Background(), "/in/movie.mkv", outputPath) })
+	if len(commands) != 0 || !strings.Contains(output, "Skipping thumbnail") || !strings.Contains(output, "Skipping contact sheet") {
+		t.Errorf("short clip ran %v, logged %q; want both images skipped", commands, output)
+	}
+
+	tr.prober = probe("600")
+	captureStdout(t, func() { tr.writeThumbnails(context.Background(), "/in/movie.mkv", outputPath) })
+	if len(commands) != 2 {
+		t.Fatalf("ran %d commands, want a thumbnail and a contact sheet", len(commands))
+	}
+	for _, path := range []string{ThumbnailPath(outputPath), ContactSheetPath(outputPath)} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("image not written: %v", err)
+		}
+	}
+	if argValue(commands[1], "-ss") != "120" {
+		t.Errorf("contact sheet of 4 frames over 600s starts at %s, want 120", argValue(commands[1], "-ss"))
+	}
+
+	// Images already next to the output are kept without --overwrite, and
+	// discarding the output leaves them alone
+	commands = nil
+	output = captureStdout(t, func() { tr.writeThumbnails(context.Background(), "/in/movie.mkv", outputPath) })
+	if len(commands) != 0 || !strings.Contains(output, "Keeping existing thumbnail") {
+		t.Errorf("existing images replaced by %v (%q)", commands, output)
+	}
+	discardOutput(outputPath)
+	if _, err := os.Stat(ThumbnailPath(outputPath)); err != nil {
+		t.Errorf("discardOutput() removed the thumbnail: %v", err)
+	}
+	tr.config.Overwrite = true
+	captureStdout(t, func() { tr.writeThumbnails(context.Background(), "/in/movie.mkv", outputPath) })
+	if len(commands) != 2 {
+		t.Errorf("--overwrite ran %d commands, want both images written again", len(commands))
+	}
+
+	// A failed extraction leaves no partial image behind and only warns
+	tr.commandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `printf partial > "$0"; printf 'Output file is empty\n' >&2; exit 1`, args[len(args)-1])
+	}
+	tr.config.ContactSheet = 0
+	os.Remove(ThumbnailPath(outputPath))
+	output = captureStdout(t, func() { tr.writeThumbnails(context.Background(), "/in/movie.mkv", outputPath) })
+	if _, err := os.Stat(ThumbnailPath(outputPath)); !os.IsNotExist(err) {
+		t.Errorf("failed thumbnail left %s behind", ThumbnailPath(outputPath))
+	}
+	if !strings.Contains(output, "failed to write thumbnail") {
+		t.Errorf("no warning about the failed thumbnail: %q", output)
+	}
+}
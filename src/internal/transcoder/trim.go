@@ -0,0 +1,125 @@
+package transcoder
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTimestamp parses a position or length for --start, --duration and
+// --end. It accepts ffmpeg's time duration syntax, seconds such as 90.5 or
+// [HH:]MM:SS[.fraction], as well as durations such as 5m30s or 1500ms.
+func ParseTimestamp(value string) (time.Duration, error) {
+	invalid := NewTranscoderError(ErrorTypeInvalidTime,
+		fmt.Sprintf("invalid timestamp '%s' (use seconds such as 90.5, [HH:]MM:SS[.fraction] or a duration such as 5m30s)", value), nil)
+
+	v := strings.TrimSpace(value)
+	if strings.Contains(v, ":") {
+		parts := strings.Split(v, ":")
+		if len(parts) > 3 {
+			return 0, invalid
+		}
+		seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+		if err != nil || !isPlainNumber(parts[len(parts)-1]) || seconds < 0 || seconds >= 60 {
+			return 0, invalid
+		}
+		total := seconds
+		for i, unit := len(parts)-2, 60.0; i >= 0; i, unit = i-1, unit*60 {
+			n, err := strconv.Atoi(parts[i])
+			// Minutes roll over into hours, which are unbounded
+			if err != nil || n < 0 || (unit == 60 && len(parts) == 3 && n >= 60) {
+				return 0, invalid
+			}
+			total += float64(n) * unit
+		}
+		return time.Duration(total * float64(time.Second)), nil
+	}
+
+	if isPlainNumber(v) {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil || seconds < 0 || math.IsInf(seconds, 0) {
+			return 0, invalid
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, invalid
+	}
+	return d, nil
+}
+
+// isPlainNumber reports whether s is digits with at most one decimal point,
+// ruling out the signs, exponents and NaN that strconv.ParseFloat accepts
+func isPlainNumber(s string) bool {
+	digits, points := 0, 0
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '.':
+			points++
+		default:
+			return false
+		}
+	}
+	return digits > 0 && points <= 1
+}
+
+// trimLength returns the length of the encoded segment with --duration or
+// --end, zero when the output runs to the end of the source
+func (t *Transcoder) trimLength() time.Duration {
+	if t.config.TrimDuration > 0 {
+		return t.config.TrimDuration
+	}
+	if t.config.TrimEnd > 0 {
+		return t.config.TrimEnd - t.config.TrimStart
+	}
+	return 0
+}
+
+// trimming reports whether only a segment of each source is encoded
+func (t *Transcoder) trimming() bool {
+	return t.config.TrimStart > 0 || t.trimLength() > 0
+}
+
+// seekInputs puts -ss before every -i of input arguments, so ffmpeg seeks
+// each input to --start quickly instead of decoding up to it. External
+// subtitles are seeked too, keeping them in step with the video.
+func (t *Transcoder) seekInputs(args []string) []string {
+	if t.config.TrimStart <= 0 {
+		return args
+	}
+	result := make([]string, 0, len(args)+2)
+	for _, arg := range args {
+		if arg == "-i" {
+			result = append(result, "-ss", formatSeconds(t.config.TrimStart))
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// trimOutputArgs limits the output to the --duration or --end segment.
+// Input seeking restarts timestamps at zero, so --end becomes a length.
+func (t *Transcoder) trimOutputArgs() []string {
+	if length := t.trimLength(); length > 0 {
+		return []string{"-t", formatSeconds(length)}
+	}
+	return nil
+}
+
+// trimmedDuration returns the part of a source duration in seconds that is
+// encoded, for progress; unknown durations stay zero
+func (t *Transcoder) trimmedDuration(seconds float64) float64 {
+	if seconds <= 0 || !t.trimming() {
+		return seconds
+	}
+	seconds = max(seconds-t.config.TrimStart.Seconds(), 0)
+	if length := t.trimLength(); length > 0 {
+		seconds = min(seconds, length.Seconds())
+	}
+	return seconds
+}
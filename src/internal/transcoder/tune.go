@@ -0,0 +1,69 @@
+package transcoder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// encoderTunes maps each encoder to the tunings it supports and the FFmpeg
+// arguments that select them
+var encoderTunes = map[string]map[string][]string{
+	"libx264": {
+		"film":        {"-tune", "film"},
+		"animation":   {"-tune", "animation"},
+		"grain":       {"-tune", "grain"},
+		"stillimage":  {"-tune", "stillimage"},
+		"fastdecode":  {"-tune", "fastdecode"},
+		"zerolatency": {"-tune", "zerolatency"},
+	},
+	"libx265": {
+		"animation":   {"-tune", "animation"},
+		"grain":       {"-tune", "grain"},
+		"fastdecode":  {"-tune", "fastdecode"},
+		"zerolatency": {"-tune", "zerolatency"},
+	},
+	"h264_nvenc": nvencTunes,
+	"hevc_nvenc": nvencTunes,
+	"av1_nvenc":  nvencTunes,
+	"libsvtav1": {
+		// SVT-AV1 tunes for visual quality by default; grain enables film grain synthesis
+		"film":  {"-svtav1-params", "tune=0"},
+		"grain": {"-svtav1-params", "tune=0:film-grain=8"},
+		"psnr":  {"-svtav1-params", "tune=1"},
+	},
+}
+
+// nvencTunes are shared by all NVENC encoders
+var nvencTunes = map[string][]string{
+	"hq":       {"-tune", "hq"},
+	"ll":       {"-tune", "ll"},
+	"ull":      {"-tune", "ull"},
+	"lossless": {"-tune", "lossless"},
+}
+
+// TuneArgs returns the FFmpeg arguments selecting a tuning for an encoder
+func TuneArgs(encoder, tune string) ([]string, error) {
+	tunes, ok := encoderTunes[encoder]
+	if !ok {
+		return nil, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("encoder %s does not support --tune", encoder), nil)
+	}
+	args, ok := tunes[tune]
+	if !ok {
+		return nil, NewTranscoderError(ErrorTypeInvalidPreset,
+			fmt.Sprintf("tune '%s' is not supported by %s (supported: %s)",
+				tune, encoder, strings.Join(SupportedTunes(encoder), ", ")), nil)
+	}
+	return args, nil
+}
+
+// SupportedTunes lists the tunings available for an encoder
+func SupportedTunes(encoder string) []string {
+	names := make([]string, 0, len(encoderTunes[encoder]))
+	for name := range encoderTunes[encoder] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
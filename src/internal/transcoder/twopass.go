@@ -0,0 +1,180 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// passLogEncoders are the encoders whose two-pass encodes run ffmpeg twice,
+// sharing a pass log. libx265 takes the passes through -x265-params instead of
+// ffmpeg's -pass and -passlogfile.
+var passLogEncoders = map[string]bool{
+	"libx264": true,
+	"libx265": true,
+}
+
+// usesTwoPass reports whether an encode with the given video arguments is two
+// pass: requested by the preset or --two-pass, for a preset with a target
+// bitrate that the arguments still carry. An explicit --crf or quality target
+// asks for constant quality instead and keeps the encode single pass.
+func (t *Transcoder) usesTwoPass(preset Preset, videoArgs []string) bool {
+	if !preset.TwoPass && !t.config.TwoPass {
+		return false
+	}
+	if t.config.CRF != nil || t.config.QualityTarget != "" {
+		return false
+	}
+	return preset.Bitrate != "" && argValue(videoArgs, "-b:v") != ""
+}
+
+// twoPassVideoArgs adapts video arguments to a two-pass encode. Encoders with
+// a pass log drop -crf, which cannot be combined with a two-pass bitrate
+// target; NVENC runs both passes internally with -multipass.
+func (t *Transcoder) twoPassVideoArgs(args []string) []string {
+	encoder := argValue(args, "-c:v")
+	switch {
+	case passLogEncoders[encoder]:
+		result := make([]string, 0, len(args))
+		for i := 0; i < len(args); i++ {
+			if args[i] == "-crf" {
+				i++
+				continue
+			}
+			result = append(result, args[i])
+		}
+		return result
+	case isNVENCEncoder(encoder):
+		return setArgValue(append([]string(nil), args...), "-multipass", "fullres")
+	default:
+		t.twoPassWarnOnce.Do(func() {
+			t.log.Warnf("%s has no two-pass mode; encoding in a single pass", encoder)
+		})
+		return args
+	}
+}
+
+// x265ParamEscaper escapes a value of -x265-params, whose ':' separates
+// options, so a Windows drive letter or backslashes in a path survive
+var x265ParamEscaper = strings.NewReplacer(`\`, `\\`, ":", `\:`, "=", `\=`, "'", `\'`)
+
+// twoPassArgs derives the arguments of both passes from a complete encode
+// command. The first pass encodes video only, discarding the output; the
+// second writes the output using the first pass's statistics in passLog.
+func twoPassArgs(args []string, passLog string) (first, second []string) {
+	// Split off the output: [muxer options] [-f muxer] -y path
+	end := len(args)
+	if end >= 2 && args[end-2] == "-y" {
+		end -= 2
+	}
+	for end >= 2 && (args[end-2] == "-f" || args[end-2] == "-movflags") {
+		end -= 2
+	}
+	head, output := args[:end], args[end:]
+
+	withPass := func(head []string, pass int) []string {
+		passArgs := append([]string(nil), head...)
+		if argValue(head, "-c:v") == "libx265" {
+			params := fmt.Sprintf("pass=%d:stats=%s", pass, x265ParamEscaper.Replace(passLog))
+			if existing := argValue(head, "-x265-params"); existing != "" {
+				params = existing + ":" + params
+			}
+			return setArgValue(passArgs, "-x265-params", params)
+		}
+		return append(passArgs, "-pass", strconv.Itoa(pass), "-passlogfile", passLog)
+	}
+
+	first = append(withPass(withoutMuxerArgs(head), 1), "-an", "-sn", "-f", "null", "-y", os.DevNull)
+	second = append(withPass(head, 2), output...)
+	return first, second
+}
+
+// withoutMuxerArgs drops output muxer options (-f and -movflags after the
+// last input) that would conflict with a first pass's null output. Extra
+// --ffmpeg-args can leave them short of the end of the command.
+func withoutMuxerArgs(args []string) []string {
+	lastInput := -1
+	for i, arg := range args {
+		if arg == "-i" {
+			lastInput = i
+		}
+	}
+	result := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if i > lastInput+1 && (args[i] == "-f" || args[i] == "-movflags") && i+1 < len(args) {
+			i++
+			continue
+		}
+		result = append(result, args[i])
+	}
+	return result
+}
+
+// encode runs an encode command, as two ffmpeg passes when it is a two-pass
+// encode for an encoder with a pass log
+func (t *Transcoder) encode(ctx context.Context, inputPath string, args []string, preset Preset, progress fileProgress) (string, error) {
+	if !t.usesTwoPass(preset, args) || !passLogEncoders[argValue(args, "-c:v")] {
+		return t.runFFmpeg(ctx, inputPath, args, progress)
+	}
+
+	// Each encode gets its own directory, so parallel jobs never share a log
+	dir, err := t.temp.CreateDir("ffmcli-2pass-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	passLog := filepath.Join(dir, strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath)))
+	if progress != nil {
+		defer progress.Done()
+	}
+
+	first, second := twoPassArgs(args, passLog)
+	for pass, passArgs := range [][]string{first, second} {
+		t.log.Debugf("Pass %d/2: %s", pass+1, FormatCommand("ffmpeg", passArgs))
+		var passProgress fileProgress
+		if progress != nil {
+			passProgress = &twoPassProgress{progress: progress, pass: pass}
+		}
+		if stderr, err := t.runFFmpeg(ctx, inputPath, passArgs, passProgress); err != nil {
+			return stderr, err
+		}
+	}
+	return "", nil
+}
+
+// twoPassProgress reports the progress of one pass as a half of the file's
+// progress, keeping the status line until the second pass is done
+type twoPassProgress struct {
+	progress fileProgress
+	pass     int // 0 or 1
+}
+
+func (p *twoPassProgress) Update(status encodeStatus) {
+	status.Position = (time.Duration(p.pass)*status.Duration + status.Position) / 2
+	p.progress.Update(status)
+}
+
+func (p *twoPassProgress) Done() {}
+
+// sharedProgress reports the progress of one of several encodes of a file,
+// such as one preset of a list, as its share of the file's progress, so the
+// batch never moves backwards when the next encode starts. The file's
+// duration covers every encode, which keeps the ETA for the whole file.
+type sharedProgress struct {
+	progress    fileProgress
+	part, parts int
+}
+
+func (p *sharedProgress) Update(status encodeStatus) {
+	status.Position += time.Duration(p.part) * status.Duration
+	status.Duration *= time.Duration(p.parts)
+	p.progress.Update(status)
+}
+
+func (p *sharedProgress) Done() {
+	p.progress.Done()
+}
@@ -0,0 +1,47 @@
+package transcoder
+
+// skipUpscale removes fixed-size scale filters from the chain whose target is
+// at least the source size in both dimensions, so a source at or below the
+// preset resolution keeps its own size. Filters that shrink the source in
+// either dimension are kept.
+func skipUpscale(chain FilterChain, width, height int) (FilterChain, bool) {
+	if width <= 0 || height <= 0 {
+		return chain, false
+	}
+
+	var kept FilterChain
+	skipped := false
+	for _, filter := range chain {
+		if boxW, boxH, _, ok := fixedScale(filter); ok && boxW >= width && boxH >= height {
+			skipped = true
+			continue
+		}
+		kept = append(kept, filter)
+	}
+	if !skipped {
+		return chain, false
+	}
+	return kept, true
+}
+
+// noUpscale keeps sources at or below the preset resolution at their own size
+// when --no-upscale is set. Sources that cannot be probed keep the preset
+// scaling.
+func (t *Transcoder) noUpscale(inputPath string, chain FilterChain) FilterChain {
+	if !t.config.NoUpscale {
+		return chain
+	}
+	info, err := t.prober.Probe(t.mediaInput(inputPath))
+	if err != nil || info.Width == 0 || info.Height == 0 {
+		t.log.Debugf("Cannot determine resolution of %s, keeping preset scaling", inputPath)
+		return chain
+	}
+
+	// Frames are rotated before the filter chain runs
+	width, height := info.DisplaySize()
+	chain, skipped := skipUpscale(chain, width, height)
+	if skipped {
+		t.log.Debugf("Keeping %s at its %dx%d resolution instead of upscaling", inputPath, width, height)
+	}
+	return chain
+}
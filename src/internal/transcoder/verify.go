@@ -0,0 +1,239 @@
+package transcoder
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// VerifyResult is the verdict on one output
+type VerifyResult struct {
+	Path     string
+	Problems []string // Empty when the output passed
+}
+
+// Passed reports whether the output has no problems
+func (r VerifyResult) Passed() bool {
+	return len(r.Problems) == 0
+}
+
+// verifyTarget is an output to check and the preset it should have been
+// encoded with, if known
+type verifyTarget struct {
+	path   string
+	preset string
+}
+
+// VerifyOutputs checks outputs after a run, without changing anything. Each
+// output must be non-empty, probe cleanly, have a video stream, reach
+// minDurationRatio of its source duration (from its sidecar or the paired
+// source under the input path) and use its preset's codec. With csvPath,
+// only the outputs the analytics CSV lists as converted are checked, and any
+// that are missing fail; otherwise every output under the output directory
+// is checked. Outputs are probed in parallel.
+func (t *Transcoder) VerifyOutputs(csvPath string, minDurationRatio float64) ([]VerifyResult, error) {
+	if err := t.systemChecker.CheckFFprobeAvailability(); err != nil {
+		return nil, err
+	}
+	outputs, err := t.fileDiscovery.FindVideoFiles(t.config.OutputDir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []VerifyResult
+	var targets []verifyTarget
+	if csvPath == "" {
+		for _, output := range outputs {
+			targets = append(targets, verifyTarget{path: output, preset: t.presetFromOutputName(output)})
+		}
+	} else {
+		var missing []string
+		targets, missing, err = t.verifyTargetsFromCSV(csvPath, outputs)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range missing {
+			results = append(results, VerifyResult{Path: filepath.Join(t.config.OutputDir, name),
+				Problems: []string{"missing (listed as converted in " + filepath.Base(csvPath) + ")"}})
+		}
+	}
+	sources := t.cleanupSources()
+
+	verified := make([]VerifyResult, len(targets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.NumCPU(), maxProbeWorkers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				target := targets[i]
+				verified[i] = VerifyResult{Path: target.path,
+					Problems: t.verifyOutput(target, sources[filepath.Clean(target.path)], minDurationRatio)}
+			}
+		}()
+	}
+	for i := range targets {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	results = append(results, verified...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	return results, nil
+}
+
+// verifyOutput lists the problems of one output
+func (t *Transcoder) verifyOutput(target verifyTarget, source string, minDurationRatio float64) []string {
+	info, err := os.Stat(target.path)
+	if err != nil {
+		return []string{"cannot be read"}
+	}
+	var problems []string
+	if reason := t.cleanupReason(target.path, info.Size(), source, minDurationRatio); reason != "" {
+		problems = append(problems, reason)
+	}
+
+	probe, err := t.prober.Probe(target.path)
+	if err != nil || !probe.HasVideo() {
+		return problems
+	}
+	if expected := t.expectedCodec(target); expected != "" && normalizeCodecName(probe.VideoCodec) != expected {
+		problems = append(problems, fmt.Sprintf("codec %s, expected %s", probe.VideoCodec, expected))
+	}
+	return problems
+}
+
+// expectedCodec returns the video codec an output should have, from its
+// sidecar or its preset, normalized like ffprobe names it
+func (t *Transcoder) expectedCodec(target verifyTarget) string {
+	if data, err := os.ReadFile(SidecarPath(target.path)); err == nil {
+		var sidecar Sidecar
+		if json.Unmarshal(data, &sidecar) == nil && sidecar.Settings.Codec != "" {
+			return normalizeCodecName(sidecar.Settings.Codec)
+		}
+	}
+	if preset, ok := t.presets[target.preset]; ok {
+		return normalizeCodecName(preset.Codec)
+	}
+	return ""
+}
+
+// presetFromOutputName finds the preset named in a generated output name
+// such as movie_1080p_h265.mkv or movie_1080p_h265_crf20.mkv, or "" when
+// none is
+func (t *Transcoder) presetFromOutputName(output string) string {
+	base := filepath.Base(output)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	best := ""
+	for name := range t.presets {
+		marker := "_" + name
+		at := strings.LastIndex(stem, marker)
+		if at < 0 {
+			continue
+		}
+		rest := stem[at+len(marker):]
+		if (rest == "" || strings.HasPrefix(rest, "_")) && len(name) > len(best) {
+			best = name
+		}
+	}
+	return best
+}
+
+// verifyTargetsFromCSV matches the converted rows of an analytics CSV to
+// outputs by their generated file name. It returns the outputs to check and
+// the names of listed outputs that do not exist.
+func (t *Transcoder) verifyTargetsFromCSV(csvPath string, outputs []string) ([]verifyTarget, []string, error) {
+	file, err := os.Open(csvPath)
+	if err != nil {
+		return nil, nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"cannot open analytics CSV "+csvPath, err)
+	}
+	defer file.Close()
+	rows, err := readConvertedRows(file)
+	if err != nil {
+		return nil, nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"invalid analytics CSV "+csvPath, err)
+	}
+
+	byName := make(map[string]string, len(outputs))
+	for _, output := range outputs {
+		byName[filepath.Base(output)] = output
+	}
+
+	var targets []verifyTarget
+	var missing []string
+	for _, row := range rows {
+		// Composed presets are not in the table, but only the name matters here
+		preset, ok := t.presets[row.preset]
+		if !ok {
+			preset = Preset{Name: row.preset}
+		}
+		name := filepath.Base(t.pathUtils.GenerateOutputPath(row.filename, t.config.OutputDir, "", preset))
+		if output, ok := byName[name]; ok {
+			targets = append(targets, verifyTarget{path: output, preset: row.preset})
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	return targets, missing, nil
+}
+
+// convertedRow is a file an analytics CSV reports as converted
+type convertedRow struct {
+	filename string
+	preset   string
+}
+
+// readConvertedRows returns the successful rows of an analytics CSV, each of
+// which has an output. Columns are matched by header name.
+func readConvertedRows(r io.Reader) ([]convertedRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"filename", "preset", "status"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i := columns[name]; i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var rows []convertedRow
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if field(row, "status") != "success" {
+			continue
+		}
+		rows = append(rows, convertedRow{filename: field(row, "filename"), preset: field(row, "preset")})
+	}
+}
@@ -0,0 +1,304 @@
+package transcoder
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultStableDelay is how long a watched file's size must stay unchanged
+// before it is considered completely written
+const DefaultStableDelay = 5 * time.Second
+
+// WatchRecordFile is kept in the output directory and lists the sources watch
+// mode has processed, so a restarted watch doesn't encode them again
+const WatchRecordFile = ".ffmcli-watch.json"
+
+// watchEntry records one processed source
+type watchEntry struct {
+	Size      int64     `json:"size"`
+	Processed time.Time `json:"processed"`
+}
+
+// watchRecord is the set of sources processed by watch mode, saved after each
+// file. A source whose size differs from the record is a new file that reuses
+// the name and is processed again.
+type watchRecord struct {
+	path    string
+	Sources map[string]watchEntry `json:"sources"`
+}
+
+// loadWatchRecord reads the record at path; a missing file is an empty record
+func loadWatchRecord(path string) (*watchRecord, error) {
+	record := &watchRecord{path: path, Sources: make(map[string]watchEntry)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return record, nil
+	}
+	if err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"failed to read watch record "+path, err)
+	}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, NewTranscoderError(ErrorTypeFileSystemError,
+			"watch record "+path+" is not valid JSON", err)
+	}
+	if record.Sources == nil {
+		record.Sources = make(map[string]watchEntry)
+	}
+	return record, nil
+}
+
+// has reports whether a source of the given size was already processed
+func (r *watchRecord) has(path string, size int64) bool {
+	entry, ok := r.Sources[sourceKey(path)]
+	return ok && entry.Size == size
+}
+
+// add records a processed source and saves the record, replacing the file
+// in one step so an interrupted write never leaves it truncated
+func (r *watchRecord) add(path string, size int64) error {
+	r.Sources[sourceKey(path)] = watchEntry{Size: size, Processed: time.Now()}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// pendingFile is a watched file that is still being written, as far as we know
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	since   time.Time // When the size or modification time last changed
+}
+
+// stabilityTracker holds watched files until their size and modification
+// time have been unchanged for the stable delay
+type stabilityTracker struct {
+	delay time.Duration
+	files map[string]*pendingFile
+}
+
+func newStabilityTracker(delay time.Duration) *stabilityTracker {
+	return &stabilityTracker{delay: delay, files: make(map[string]*pendingFile)}
+}
+
+// touch starts or restarts the stable delay of a file after it changed
+func (s *stabilityTracker) touch(path string, now time.Time) {
+	file := &pendingFile{size: -1, since: now}
+	if info, err := os.Stat(path); err == nil {
+		file.size, file.modTime = info.Size(), info.ModTime()
+	}
+	s.files[path] = file
+}
+
+// forget stops tracking a file that was removed or renamed. A file renamed
+// within the watched directory comes back under its new name.
+func (s *stabilityTracker) forget(path string) {
+	delete(s.files, path)
+}
+
+// ready returns the files, in lexical order, that stayed unchanged for the
+// stable delay and stops tracking them. Files that have gone are dropped.
+func (s *stabilityTracker) ready(now time.Time) []string {
+	var stable []string
+	for path, file := range s.files {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			delete(s.files, path)
+			continue
+		}
+		if info.Size() != file.size || !info.ModTime().Equal(file.modTime) {
+			file.size, file.modTime, file.since = info.Size(), info.ModTime(), now
+			continue
+		}
+		if now.Sub(file.since) >= s.delay {
+			stable = append(stable, path)
+			delete(s.files, path)
+		}
+	}
+	sort.Strings(stable)
+	return stable
+}
+
+// Watch monitors the input directory and processes each new video file once
+// its size has been unchanged for stableDelay, until ctx is done. Video files
+// already in the directory are processed first unless the watch record in the
+// output directory lists them. Failed files are retried when they change or
+// when the watch restarts.
+func (t *Transcoder) Watch(ctx context.Context, stableDelay time.Duration, csvWriter *csv.Writer) error {
+	if stableDelay <= 0 {
+		return NewTranscoderError(ErrorTypeInvalidFilePath, "stable delay must be positive", nil)
+	}
+	record, err := loadWatchRecord(filepath.Join(t.config.OutputDir, WatchRecordFile))
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return NewTranscoderError(ErrorTypeFileSystemError, "failed to start watching", err)
+	}
+	defer watcher.Close()
+	if err := t.watchDir(watcher, t.config.InputPath); err != nil {
+		return err
+	}
+
+	tracker := newStabilityTracker(stableDelay)
+	files, err := t.FindVideoFiles()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, path := range files {
+		if t.isWatchCandidate(path) && !t.recorded(record, path) {
+			tracker.touch(path, now)
+		}
+	}
+
+	ticker := time.NewTicker(max(stableDelay/5, 100*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			t.log.Warnf("watch error: %v", err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			t.handleWatchEvent(watcher, tracker, record, event)
+		case now := <-ticker.C:
+			for _, path := range tracker.ready(now) {
+				if ctx.Err() != nil {
+					return nil
+				}
+				t.processWatched(ctx, record, path, csvWriter)
+			}
+		}
+	}
+}
+
+// watchDir adds a directory to the watcher, with its subdirectories when
+// recursive. The output directory is left out so outputs written inside the
+// input directory are not picked up as new sources.
+func (t *Transcoder) watchDir(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return NewTranscoderError(ErrorTypeFileSystemError, "cannot access "+path, err)
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if t.inOutputDir(path) || (path != dir && !t.config.Recursive) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return NewTranscoderError(ErrorTypeFileSystemError, "failed to watch "+path, err)
+		}
+		return nil
+	})
+}
+
+// handleWatchEvent updates the tracked files for one filesystem event
+func (t *Transcoder) handleWatchEvent(watcher *fsnotify.Watcher, tracker *stabilityTracker, record *watchRecord, event fsnotify.Event) {
+	now := time.Now()
+	switch {
+	case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+		// A rename is reported under the old name; the new name, when it
+		// is still inside a watched directory, arrives as a create
+		tracker.forget(event.Name)
+	case event.Has(fsnotify.Create):
+		info, err := os.Stat(event.Name)
+		if err != nil {
+			return
+		}
+		if info.IsDir() {
+			if t.config.Recursive && !t.inOutputDir(event.Name) {
+				if err := t.watchDir(watcher, event.Name); err != nil {
+					t.log.Warnf("%v", err)
+				}
+				// A directory moved in arrives with its files already there
+				t.trackDir(tracker, record, event.Name, now)
+			}
+			return
+		}
+		if t.isWatchCandidate(event.Name) && !t.recorded(record, event.Name) {
+			tracker.touch(event.Name, now)
+		}
+	case event.Has(fsnotify.Write):
+		if t.isWatchCandidate(event.Name) && !t.recorded(record, event.Name) {
+			tracker.touch(event.Name, now)
+		}
+	}
+}
+
+// trackDir starts tracking the video files below a directory that appeared
+func (t *Transcoder) trackDir(tracker *stabilityTracker, record *watchRecord, dir string, now time.Time) {
+	files, err := t.fileDiscovery.FindVideoFiles(dir, true)
+	if err != nil {
+		return
+	}
+	for _, path := range files {
+		if t.isWatchCandidate(path) && !t.recorded(record, path) {
+			tracker.touch(path, now)
+		}
+	}
+}
+
+// processWatched processes a file that has finished writing and records it.
+// Skipped files are recorded too; failed ones are not.
+func (t *Transcoder) processWatched(ctx context.Context, record *watchRecord, path string, csvWriter *csv.Writer) {
+	info, err := os.Stat(path)
+	if err != nil || t.recorded(record, path) {
+		return
+	}
+
+	// A new file may reuse the name of one probed earlier
+	t.prober.Invalidate(path)
+	t.log.Infof("Processing %s", path)
+	if _, err := t.processFileWithAnalytics(ctx, path, csvWriter, nil); err != nil {
+		t.log.Infof("Failed to process %s: %v", path, err)
+		return
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+	if err := record.add(path, info.Size()); err != nil {
+		t.log.Warnf("failed to update watch record: %v", err)
+	}
+}
+
+// isWatchCandidate reports whether a path is a video file watch mode should
+// process, which excludes anything in the output directory
+func (t *Transcoder) isWatchCandidate(path string) bool {
+	return t.fileDiscovery.isVideoFile(path) && !t.inOutputDir(path)
+}
+
+// recorded reports whether the watch record lists a file at its current size
+func (t *Transcoder) recorded(record *watchRecord, path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && record.has(path, info.Size())
+}
+
+// inOutputDir reports whether path is the output directory or inside it
+func (t *Transcoder) inOutputDir(path string) bool {
+	rel, err := filepath.Rel(sourceKey(t.config.OutputDir), sourceKey(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
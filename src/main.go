@@ -7,8 +7,11 @@ import (
 	"ffmcli/cmd"
 )
 
+// version is set at build time via -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
-	if err := cmd.Execute(); err != nil {
+	if err := cmd.Execute(version); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
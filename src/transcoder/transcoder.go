@@ -0,0 +1,87 @@
+// Package transcoder is the API for programs that embed ffmcli. It registers
+// custom encoders and presets, creates Transcoders and reports the outcome
+// of a batch; the command line is built on the same implementation.
+package transcoder
+
+import (
+	internal "ffmcli/internal/transcoder"
+)
+
+// Config holds the settings of a Transcoder, the same ones the command line
+// flags set
+type Config = internal.Config
+
+// Transcoder finds and encodes video files
+type Transcoder = internal.Transcoder
+
+// Preset is a named set of encoding settings
+type Preset = internal.Preset
+
+// Platform is the hardware a preset is meant for
+type Platform = internal.Platform
+
+// Platforms a preset can target; a registered preset without one runs on any
+const (
+	PlatformUnknown      = internal.PlatformUnknown
+	PlatformNVIDIA       = internal.PlatformNVIDIA
+	PlatformAppleSilicon = internal.PlatformAppleSilicon
+	PlatformSoftware     = internal.PlatformSoftware
+	PlatformIntelQSV     = internal.PlatformIntelQSV
+	PlatformAMD          = internal.PlatformAMD
+)
+
+// SoftwareFallback is the software encoder used when a hardware encoder
+// cannot run
+type SoftwareFallback = internal.SoftwareFallback
+
+// BatchSummary totals the outcome of one ProcessFilesWithProgress batch
+type BatchSummary = internal.BatchSummary
+
+// BatchFailure is a file that failed to encode
+type BatchFailure = internal.BatchFailure
+
+// FileResult is the outcome of encoding one file with one preset
+type FileResult = internal.FileResult
+
+// TranscoderError is an error with an ErrorType callers can act on
+type TranscoderError = internal.TranscoderError
+
+// ErrorType classifies a TranscoderError
+type ErrorType = internal.ErrorType
+
+// RegisterEncoder makes a custom encoder known together with the software
+// encoder to use when it cannot run. Built-in encoders cannot be redefined.
+func RegisterEncoder(encoder string, fallback SoftwareFallback) error {
+	return internal.RegisterEncoder(encoder, fallback)
+}
+
+// RegisterPreset adds a preset alongside the built-in ones. Its Args must
+// select its Encoder with -c:v. Register presets before creating the
+// Transcoder that uses them.
+func RegisterPreset(preset Preset) error {
+	return internal.RegisterPreset(preset)
+}
+
+// GetPresets returns the built-in and registered presets by name
+func GetPresets() map[string]Preset {
+	return internal.GetPresets()
+}
+
+// IsValidPreset reports whether a preset of that name exists
+func IsValidPreset(name string) bool {
+	return internal.IsValidPreset(name)
+}
+
+// IsTranscoderError reports whether err is a TranscoderError of that type
+func IsTranscoderError(err error, errorType ErrorType) bool {
+	return internal.IsTranscoderError(err, errorType)
+}
+
+// New creates a Transcoder, returning the error of an invalid configuration
+// instead of panicking
+func New(config Config) (*Transcoder, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return internal.New(config), nil
+}
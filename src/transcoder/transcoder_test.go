@@ -0,0 +1,98 @@
+package transcoder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeTools puts ffmpeg and ffprobe scripts first on PATH. ffprobe reports
+// a 60 second 1080p H.264 file; ffmpeg appends its arguments to the returned
+// log and writes its last argument as the output.
+func fakeTools(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools are shell scripts")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "ffmpeg.log")
+	scripts := map[string]string{
+		"ffprobe": `cat <<'JSON'
+{"streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}], "format": {"duration": "60"}}
+JSON
+`,
+		"ffmpeg": `echo "$@" >> '` + log + `'
+for last; do :; done
+case "$last" in -*) ;; *) echo encoded > "$last" ;; esac
+`,
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestRegisteredPresetEncodes(t *testing.T) {
+	log := fakeTools(t)
+	in, out := t.TempDir(), t.TempDir()
+	input := filepath.Join(in, "movie.mp4")
+	if err := os.WriteFile(input, []byte("source video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterEncoder("asic_hevc", SoftwareFallback{Encoder: "libx265", Speed: "slow"}); err != nil {
+		t.Fatalf("RegisterEncoder() error = %v", err)
+	}
+	if err := RegisterPreset(Preset{
+		Name:       "1080p_asic",
+		Resolution: "1920x1080",
+		Codec:      "H.265",
+		Encoder:    "asic_hevc",
+		Bitrate:    "4M",
+		Args:       []string{"-c:v", "asic_hevc", "-b:v", "4M", "-vf", "scale=1920:1080"},
+	}); err != nil {
+		t.Fatalf("RegisterPreset() error = %v", err)
+	}
+	if !IsValidPreset("1080p_asic") {
+		t.Fatal("IsValidPreset() = false for the registered preset")
+	}
+
+	if _, err := New(Config{InputPath: in, Preset: "1080p_asic"}); err == nil {
+		t.Error("New() without an output directory expected an error")
+	}
+	tr, err := New(Config{InputPath: in, OutputDir: out, Preset: "1080p_asic"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer tr.Cleanup()
+
+	files, err := tr.FindVideoFiles()
+	if err != nil || len(files) != 1 {
+		t.Fatalf("FindVideoFiles() = %v, %v, want movie.mp4", files, err)
+	}
+	var summary *BatchSummary
+	summary, err = tr.ProcessFilesWithProgress(context.Background(), files, nil)
+	if err != nil {
+		t.Fatalf("ProcessFilesWithProgress() error = %v", err)
+	}
+	if summary.Files != 1 || summary.Succeeded != 1 || summary.Failed != 0 {
+		t.Errorf("BatchSummary = %+v, want one file encoded", summary)
+	}
+
+	if _, err := os.Stat(filepath.Join(out, "movie_1080p_asic.mkv")); err != nil {
+		t.Errorf("output missing: %v", err)
+	}
+	calls, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("ffmpeg never ran: %v", err)
+	}
+	if !strings.Contains(string(calls), "-c:v asic_hevc -b:v 4M") {
+		t.Errorf("ffmpeg calls = %s, want the registered encoder", calls)
+	}
+}